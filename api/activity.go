@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Fleet activity counts telemetry by when the server received it, per minute
+// and per org, so a facility-wide dip in ingestion stands out even though
+// each device's own history is hourly and keyed by sent_at.
+const (
+	activityBucketSize = time.Minute
+	activityBuckets    = 24 * 60 // one day of minute buckets, ~56 KB per org
+
+	defaultActivityWindow = time.Hour
+	defaultActivityStep   = time.Minute
+)
+
+// activityBucket holds the telemetry received during one minute.
+type activityBucket struct {
+	start      time.Time
+	heartbeats int64
+	uploads    int64
+}
+
+// activityRing is a ring of minute buckets indexed by minute number.
+type activityRing struct {
+	buckets []activityBucket
+}
+
+// activityFor returns the org's ring, creating it on first use.
+// Callers must hold s.mu for writing.
+func (s *Store) activityFor(org string) *activityRing {
+	a, exists := s.activity[org]
+	if !exists {
+		a = &activityRing{buckets: make([]activityBucket, activityBuckets)}
+		s.activity[org] = a
+	}
+	return a
+}
+
+// slot returns the ring index for the bucket starting at start.
+func (a *activityRing) slot(start time.Time) int {
+	minute := start.Unix() / int64(activityBucketSize/time.Second)
+	n := int64(len(a.buckets))
+	return int(((minute % n) + n) % n)
+}
+
+// bucket returns the bucket covering t, recycling the slot if it holds an
+// older minute. Telemetry is counted on arrival, so t never goes backwards
+// far enough to fall out of the ring.
+func (a *activityRing) bucket(t time.Time) *activityBucket {
+	start := t.UTC().Truncate(activityBucketSize)
+	b := &a.buckets[a.slot(start)]
+	if !b.start.Equal(start) {
+		*b = activityBucket{start: start}
+	}
+	return b
+}
+
+// recordActivityLocked counts one heartbeat or upload received for org at now.
+// Callers must hold s.mu for writing.
+func (s *Store) recordActivityLocked(org string, now time.Time, heartbeat bool) {
+	b := s.activityFor(org).bucket(now)
+	if heartbeat {
+		b.heartbeats++
+	} else {
+		b.uploads++
+	}
+}
+
+// ActivityPoint is one step of fleet activity.
+type ActivityPoint struct {
+	Start      time.Time `json:"start"`
+	Heartbeats int64     `json:"heartbeats"`
+	Uploads    int64     `json:"uploads"`
+}
+
+// Activity rolls the minute buckets received in [from, to) up into steps,
+// one point per step even if empty. An empty org sums every org.
+func (s *Store) Activity(ctx context.Context, org string, from, to time.Time, step time.Duration) ([]ActivityPoint, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	var points []ActivityPoint
+	for start := from; start.Before(to); start = start.Add(step) {
+		points = append(points, ActivityPoint{Start: start})
+	}
+	for ringOrg, a := range s.activity {
+		if org != "" && ringOrg != org {
+			continue
+		}
+		for _, b := range a.buckets {
+			if b.start.Before(from) || !b.start.Before(to) {
+				continue
+			}
+			p := &points[b.start.Sub(from)/step]
+			p.Heartbeats += b.heartbeats
+			p.Uploads += b.uploads
+		}
+	}
+	return points, nil
+}
+
+// ActivityResponse is the body of GET /api/v1/fleet/activity.
+type ActivityResponse struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Step   Duration        `json:"step"`
+	Points []ActivityPoint `json:"points"`
+}
+
+// parseActivityQuery reads window and step, returning a message on invalid
+// input. The range ends with the current, partial minute.
+func parseActivityQuery(r *http.Request, now time.Time) (from, to time.Time, step time.Duration, msg string) {
+	q := r.URL.Query()
+
+	window := defaultActivityWindow
+	if v := q.Get("window"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d <= 0 || d%activityBucketSize != 0 || d > activityBucketSize*activityBuckets {
+			return from, to, step, fmt.Sprintf("window must be a whole number of minutes up to %v", activityBucketSize*activityBuckets)
+		}
+		window = d
+	}
+
+	step = defaultActivityStep
+	if v := q.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d%activityBucketSize != 0 || window%d != 0 {
+			return from, to, step, "step must be a whole number of minutes that divides the window"
+		}
+		step = d
+	}
+	if window/step > maxHistoryPoints {
+		return from, to, step, fmt.Sprintf("window/step cannot exceed %d points", maxHistoryPoints)
+	}
+
+	to = now.UTC().Truncate(activityBucketSize).Add(activityBucketSize)
+	from = to.Add(-window)
+	return from, to, step, ""
+}
+
+// HandleFleetActivity processes GET /api/v1/fleet/activity
+func (s *Server) HandleFleetActivity(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/fleet/activity")
+
+	from, to, step, msg := parseActivityQuery(r, time.Now())
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	points, err := s.store.Activity(r.Context(), orgFromContext(r.Context()), from, to, step)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ActivityResponse{
+		From:   from,
+		To:     to,
+		Step:   format.duration(step),
+		Points: points,
+	})
+}
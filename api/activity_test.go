@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStoreActivity tests rolling minute buckets up into steps per org
+func TestStoreActivity(t *testing.T) {
+	s := NewStore()
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.recordActivityLocked("acme", t0.Add(30*time.Second), true)
+	s.recordActivityLocked("acme", t0.Add(4*time.Minute), true)
+	s.recordActivityLocked("acme", t0.Add(6*time.Minute), false)
+	s.recordActivityLocked("other", t0.Add(time.Minute), true)
+	s.recordActivityLocked("acme", t0.Add(10*time.Minute), true) // outside the range
+
+	points, err := s.Activity(t.Context(), "", t0, t0.Add(10*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Activity failed: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %+v", points)
+	}
+	if points[0].Heartbeats != 3 || points[1].Heartbeats != 0 || points[1].Uploads != 1 {
+		t.Errorf("unexpected fleet points: %+v", points)
+	}
+
+	points, err = s.Activity(t.Context(), "acme", t0, t0.Add(10*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Activity failed: %v", err)
+	}
+	if points[0].Heartbeats != 2 {
+		t.Errorf("expected 2 acme heartbeats in the first step, got %d", points[0].Heartbeats)
+	}
+}
+
+// TestActivityRing_Recycle tests that a slot is reset when its minute comes round again
+func TestActivityRing_Recycle(t *testing.T) {
+	a := &activityRing{buckets: make([]activityBucket, activityBuckets)}
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	a.bucket(t0).heartbeats = 5
+
+	if b := a.bucket(t0.Add(24 * time.Hour)); b.heartbeats != 0 || !b.start.Equal(t0.Add(24*time.Hour)) {
+		t.Errorf("expected a fresh bucket a day later, got %+v", b)
+	}
+}
+
+// TestHandleFleetActivity tests that received telemetry shows up in the current minute
+func TestHandleFleetActivity(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	for range 3 {
+		body := `{"sent_at": "` + time.Now().UTC().Format(time.RFC3339) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/fleet/activity?window=10m&step=5m", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp ActivityResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Step.String() != "5m0s" || len(resp.Points) != 2 || resp.To.Sub(resp.From) != 10*time.Minute {
+		t.Fatalf("unexpected range: %+v", resp)
+	}
+	if got := resp.Points[1].Heartbeats; got != 3 {
+		t.Errorf("expected 3 heartbeats in the latest step, got %d", got)
+	}
+}
+
+// TestHandleFleetActivity_InvalidQuery tests 400 for bad window and step values
+func TestHandleFleetActivity_InvalidQuery(t *testing.T) {
+	router := setupTestServer().Router()
+
+	for _, query := range []string{"window=48h", "window=90s", "step=7m", "step=0s", "window=24h&step=1m"} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/fleet/activity?"+query, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+// Operator endpoints under /api/v1/admin.
+
+// LimitsResponse reports the validation limits in effect. A zero duration
+// or length means the limit is disabled.
+type LimitsResponse struct {
+	MaxFutureSkew        Duration `json:"max_future_skew"`
+	MaxSentAtAge         Duration `json:"max_sent_at_age"`
+	MaxUploadTime        Duration `json:"max_upload_time"`
+	MaxHeartbeatInterval Duration `json:"max_heartbeat_interval"`
+	MaxUploadInterval    Duration `json:"max_upload_interval"`
+	MaxVersionLength     int      `json:"max_version_length"`
+
+	// Concurrency-limited routes; omitted when none are
+	RouteConcurrency []RouteLimitStatus `json:"route_concurrency,omitempty"`
+}
+
+// HandleLimits processes GET /api/v1/admin/limits
+func (s *Server) HandleLimits(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/admin/limits")
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	cfg := s.validation
+	resp := LimitsResponse{
+		MaxFutureSkew:        format.duration(cfg.MaxFutureSkew),
+		MaxSentAtAge:         format.duration(cfg.MaxPastAge),
+		MaxUploadTime:        format.duration(cfg.MaxUploadTime),
+		MaxHeartbeatInterval: format.duration(cfg.MaxHeartbeatInterval),
+		MaxUploadInterval:    format.duration(cfg.MaxUploadInterval),
+		MaxVersionLength:     cfg.MaxVersionLength,
+	}
+	if s.routeLimits != nil {
+		resp.RouteConcurrency = s.routeLimits.status()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
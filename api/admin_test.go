@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLimits tests that the admin endpoint reports the effective limits
+func TestLimits(t *testing.T) {
+	server := setupTestServer()
+	cfg := DefaultValidationConfig()
+	cfg.MaxUploadTime = 4 * time.Hour
+	cfg.MaxPastAge = 24 * time.Hour
+	server.SetValidationConfig(cfg)
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/limits", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var resp LimitsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp.MaxUploadTime.String() != "4h0m0s" || resp.MaxSentAtAge.String() != "24h0m0s" || resp.MaxVersionLength != 64 {
+		t.Errorf("unexpected limits: %+v", resp)
+	}
+}
+
+// TestConfigurableMaxUploadTime tests that uploads over an hour pass once the limit is raised
+func TestConfigurableMaxUploadTime(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	body := `{"upload_time": 7200000000000}` // 2 hours
+
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post(); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 with the default limit, got %d", code)
+	}
+
+	cfg := server.validation
+	cfg.MaxUploadTime = 3 * time.Hour
+	server.SetValidationConfig(cfg)
+	if code := post(); code != http.StatusNoContent {
+		t.Errorf("expected status 204 with a 3h limit, got %d", code)
+	}
+}
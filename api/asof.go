@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Disputed SLA reports need the numbers a device had at a past moment, not
+// today's. Aggregates only ever grow, so GET /stats?as_of= rewinds them by
+// taking off what the hourly history recorded from as_of on. That works as
+// far back as the history goes; telemetry is placed by its sent_at, so a
+// backlog that arrived after as_of but was sent before it still counts.
+
+// errAsOfUnavailable means the device's history no longer covers as_of, so
+// its aggregates can't be rewound that far.
+var errAsOfUnavailable = errors.New("the device's history doesn't reach back to as_of")
+
+// DeviceAsOf returns a copy of the device's heartbeat and upload aggregates
+// as they stood at asOf, which is rounded down to the hour. Aggregates
+// history doesn't keep per hour, such as network quality and upload
+// extremes, are left as they are now. It returns ErrDeviceNotFound or
+// errAsOfUnavailable.
+func (s *Store) DeviceAsOf(ctx context.Context, deviceID string, asOf time.Time) (DeviceStats, error) {
+	if err := s.rlock(ctx); err != nil {
+		return DeviceStats{}, err
+	}
+	defer s.mu.RUnlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return DeviceStats{}, ErrDeviceNotFound
+	}
+	copied := s.copyDeviceLocked(device)
+	asOf = asOf.UTC().Truncate(historyBucketSize)
+	if copied.DecommissionedAt.After(asOf) {
+		copied.DecommissionedAt = time.Time{}
+	}
+	// Before activation nothing counted yet; whatever was counted then
+	// was discarded by the activation
+	if copied.ActivatedAt.After(asOf) {
+		copied.ActivatedAt = time.Time{}
+		resetHeartbeatsLocked(&copied)
+	}
+
+	heartbeatsAfter := !copied.LastHeartbeat.IsZero() && !copied.LastHeartbeat.Before(asOf)
+	uploadsAfter := !copied.LastUploadAt.IsZero() && !copied.LastUploadAt.Before(asOf)
+	if !heartbeatsAfter && !uploadsAfter {
+		return copied, nil
+	}
+	h, exists := s.history[deviceID]
+	if !exists || asOf.Before(h.since) || h.latest.Sub(asOf) >= historyBuckets*historyBucketSize {
+		return DeviceStats{}, errAsOfUnavailable
+	}
+
+	// Take off every hour from asOf to the newest written
+	var count, covered, uploads int64
+	var uploadSum time.Duration
+	for start := asOf; !start.After(h.latest); start = start.Add(historyBucketSize) {
+		if b := h.buckets[h.slot(start)]; b.Start.Equal(start) {
+			count += b.HeartbeatCount
+			covered += b.coveredMinutes()
+			uploads += b.UploadCount
+			uploadSum += b.UploadTimeSum
+		}
+	}
+
+	if heartbeatsAfter {
+		copied.HeartbeatCount -= count
+		copied.CoveredMinutes -= covered
+		if copied.HeartbeatCount < 0 || copied.CoveredMinutes < 0 {
+			return DeviceStats{}, errAsOfUnavailable
+		}
+		if copied.HeartbeatCount == 0 {
+			copied.FirstHeartbeat, copied.LastHeartbeat = time.Time{}, time.Time{}
+		} else {
+			last, found := h.lastMinuteBefore(asOf)
+			if !found {
+				return DeviceStats{}, errAsOfUnavailable
+			}
+			copied.LastHeartbeat = last
+		}
+	}
+	if uploadsAfter {
+		copied.UploadCount -= uploads
+		copied.UploadTimeSum -= uploadSum
+		if copied.UploadCount < 0 {
+			return DeviceStats{}, errAsOfUnavailable
+		}
+		copied.LastUploadAt = time.Time{}
+	}
+	return copied, nil
+}
+
+// lastMinuteBefore returns the start of the latest minute before t that the
+// history saw a heartbeat in.
+func (h *deviceHistory) lastMinuteBefore(t time.Time) (time.Time, bool) {
+	oldest := h.latest.Add(-(historyBuckets - 1) * historyBucketSize)
+	for start := t.Add(-historyBucketSize); !start.Before(oldest); start = start.Add(-historyBucketSize) {
+		if b := h.buckets[h.slot(start)]; b.Start.Equal(start) && b.Minutes != 0 {
+			minute := 63 - bits.LeadingZeros64(b.Minutes)
+			return start.Add(time.Duration(minute) * time.Minute), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// StatsAsOfResponse is the body of GET /stats?as_of=.
+type StatsAsOfResponse struct {
+	DeviceID       string    `json:"device_id"`
+	AsOf           time.Time `json:"as_of"` // rounded down to the hour
+	Uptime         float64   `json:"uptime"`
+	AvgUploadTime  Duration  `json:"avg_upload_time"`
+	HeartbeatCount int64     `json:"heartbeat_count"`
+	UploadCount    int64     `json:"upload_count"`
+
+	// Heartbeats to the minute; omitted before the first
+	FirstHeartbeat time.Time `json:"first_heartbeat,omitzero"`
+	LastHeartbeat  time.Time `json:"last_heartbeat,omitzero"`
+
+	// The cadence uptime is measured against, as it is now
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+
+	Lifecycle   string    `json:"lifecycle"`
+	ActivatedAt time.Time `json:"activated_at,omitzero"`
+}
+
+// parseAsOf reads the as_of parameter, which must be a past RFC 3339 time
+// within the retained history, returning a message for the client if it
+// isn't.
+func parseAsOf(v string, now time.Time) (time.Time, string) {
+	asOf, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return asOf, "as_of must be an RFC 3339 timestamp"
+	}
+	if asOf.After(now) {
+		return asOf, "as_of cannot be in the future"
+	}
+	if now.Sub(asOf) > (historyBuckets-1)*historyBucketSize {
+		return asOf, "as_of must be within the last " + strconv.Itoa(int(maxDailyDays)) + " days"
+	}
+	return asOf.UTC().Truncate(historyBucketSize), ""
+}
+
+// handleStatsAsOf serves GET /stats?as_of=, answering 204 if the device had
+// no data then, like GET /stats.
+func (s *Server) handleStatsAsOf(w http.ResponseWriter, r *http.Request, deviceID string, format durationFormat) {
+	now := time.Now()
+	asOf, msg := parseAsOf(r.URL.Query().Get("as_of"), now)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	device, err := s.store.DeviceAsOf(r.Context(), deviceID, asOf)
+	if errors.Is(err, errAsOfUnavailable) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	result := device.Stats()
+	if !result.HasHeartbeats && !result.HasUploads {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, StatsAsOfResponse{
+		DeviceID:          deviceID,
+		AsOf:              asOf,
+		Uptime:            format.percent(result.Uptime),
+		AvgUploadTime:     format.duration(result.AvgUploadTime),
+		HeartbeatCount:    device.HeartbeatCount,
+		UploadCount:       device.UploadCount,
+		FirstHeartbeat:    device.FirstHeartbeat,
+		LastHeartbeat:     device.LastHeartbeat,
+		HeartbeatInterval: format.duration(device.EffectiveInterval()),
+		Lifecycle:         device.Lifecycle(asOf),
+		ActivatedAt:       device.ActivatedAt,
+	})
+}
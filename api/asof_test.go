@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStore_DeviceAsOf tests that aggregates are rewound to a past hour
+func TestStore_DeviceAsOf(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	t0 := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	for m := range 4 * 60 {
+		s.RecordHeartbeat(t.Context(), "device-1", t0.Add(time.Duration(m)*time.Minute))
+	}
+	s.RecordUploadStatAt(t.Context(), "device-1", 10*time.Second, t0.Add(30*time.Minute))
+	s.RecordUploadStatAt(t.Context(), "device-1", 20*time.Second, t0.Add(3*time.Hour))
+
+	device, err := s.DeviceAsOf(t.Context(), "device-1", t0.Add(2*time.Hour+20*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device.HeartbeatCount != 120 || device.CoveredMinutes != 120 || !device.LastHeartbeat.Equal(t0.Add(119*time.Minute)) {
+		t.Errorf("unexpected heartbeats: %d in %d minutes, last %v", device.HeartbeatCount, device.CoveredMinutes, device.LastHeartbeat)
+	}
+	if result := device.Stats(); result.Uptime != 100 || result.AvgUploadTime != 10*time.Second {
+		t.Errorf("unexpected stats %+v", result)
+	}
+
+	// Nothing had arrived yet
+	if device, err := s.DeviceAsOf(t.Context(), "device-1", t0); err != nil || device.HeartbeatCount != 0 || device.UploadCount != 0 {
+		t.Errorf("expected no data, got %d heartbeats and %d uploads (%v)", device.HeartbeatCount, device.UploadCount, err)
+	}
+
+	// Without the history there's nothing to rewind by, nor with history
+	// started after the device already had telemetry, as after a restart
+	delete(s.history, "device-1")
+	if _, err := s.DeviceAsOf(t.Context(), "device-1", t0.Add(2*time.Hour)); err != errAsOfUnavailable {
+		t.Errorf("expected errAsOfUnavailable, got %v", err)
+	}
+	s.RecordHeartbeat(t.Context(), "device-1", time.Now())
+	if _, err := s.DeviceAsOf(t.Context(), "device-1", t0.Add(2*time.Hour)); err != errAsOfUnavailable {
+		t.Errorf("expected errAsOfUnavailable after the history restarted, got %v", err)
+	}
+	if _, err := s.DeviceAsOf(t.Context(), "unknown", t0); err != ErrDeviceNotFound {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+// TestGetStats_AsOf tests stats as of a past moment through the API
+func TestGetStats_AsOf(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	t0 := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	for _, m := range []int{0, 1, 2, 3, 60, 61, 62, 63} {
+		store.RecordHeartbeat(t.Context(), "device-1", t0.Add(time.Duration(m)*time.Minute))
+	}
+	router := server.Router()
+
+	get := func(query string) (StatsAsOfResponse, int) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats"+query, nil))
+		var resp StatsAsOfResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp, rr.Code
+	}
+
+	resp, code := get("?as_of=" + t0.Add(time.Hour+30*time.Minute).Format(time.RFC3339))
+	if code != http.StatusOK || !resp.AsOf.Equal(t0.Add(time.Hour)) || resp.HeartbeatCount != 4 || resp.Uptime != 100 {
+		t.Errorf("unexpected response %d %+v", code, resp)
+	}
+	if _, code := get("?as_of=" + t0.Format(time.RFC3339)); code != http.StatusNoContent {
+		t.Errorf("expected status 204 before the first heartbeat, got %d", code)
+	}
+
+	tooOld := time.Now().Add(-31 * 24 * time.Hour).Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	for _, query := range []string{"?as_of=yesterday", "?as_of=" + tooOld, "?as_of=" + future} {
+		if _, code := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
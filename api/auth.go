@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// apiKeyHeader is the request header carrying the caller's API key.
+const apiKeyHeader = "X-API-Key"
+
+// operatorOrg is written in the key file's org column for operator keys.
+const operatorOrg = "*"
+
+// APIKeys maps an API key to the organization it is scoped to. An empty
+// organization is an operator key, which sees every organization and can
+// use the admin endpoints.
+type APIKeys map[string]string
+
+// LoadAPIKeysFromCSV reads API keys from a CSV file with a "key,org" header
+// row. An org of "*" makes an operator key.
+func LoadAPIKeysFromCSV(filename string) (APIKeys, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", filename, err)
+		}
+	}()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(APIKeys)
+	for i := 1; i < len(records); i++ {
+		if len(records[i]) < 2 || records[i][0] == "" || records[i][1] == "" {
+			return nil, fmt.Errorf("line %d: expected key and org", i+1)
+		}
+		org := records[i][1]
+		if org == operatorOrg {
+			org = ""
+		}
+		keys[records[i][0]] = org
+	}
+	return keys, nil
+}
+
+type orgContextKey struct{}
+
+// withOrg returns a copy of ctx carrying the caller's organization.
+func withOrg(ctx context.Context, org string) context.Context {
+	return context.WithValue(ctx, orgContextKey{}, org)
+}
+
+// orgFromContext returns the caller's organization, or "" when auth is disabled.
+func orgFromContext(ctx context.Context) string {
+	org, _ := ctx.Value(orgContextKey{}).(string)
+	return org
+}
+
+// authenticate resolves the request's API key to an organization and stores it
+// in the request context. When no keys are configured, all requests pass unscoped.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.keysMu.RLock()
+		enabled := len(s.apiKeys) > 0
+		org, ok := s.apiKeys[r.Header.Get(apiKeyHeader)]
+		s.keysMu.RUnlock()
+
+		// OPTIONS only reveals which methods a resource allows, and API
+		// gateways probe it without credentials
+		if !enabled || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !ok {
+			log.Printf("[WARN] Rejected request with missing or invalid API key: %s %s", r.Method, r.URL.Path)
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withOrg(r.Context(), org)))
+	})
+}
+
+// fleetOnly refuses API keys scoped to an organization, leaving admin
+// endpoints to operator keys.
+func (s *Server) fleetOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if orgFromContext(r.Context()) != "" {
+			writeError(w, http.StatusForbidden, "admin endpoints require an API key without an organization")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addAPIKey accepts a newly issued key. It is ignored while authentication
+// is disabled, so issuing a key never turns authentication on.
+func (s *Server) addAPIKey(key, org string) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if len(s.apiKeys) > 0 {
+		s.apiKeys[key] = org
+	}
+}
+
+// checkDeviceVisible returns ErrDeviceNotFound unless the device exists and
+// belongs to the caller's organization. Devices in other orgs are reported
+// as not found so their existence isn't leaked across tenants.
+func (s *Server) checkDeviceVisible(r *http.Request, deviceID string) error {
+	deviceOrg, err := s.store.DeviceOrg(r.Context(), deviceID)
+	if err != nil {
+		return err
+	}
+	if org := orgFromContext(r.Context()); org != "" && org != deviceOrg {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// visibleDevice returns a device's aggregates, or ErrDeviceNotFound unless
+// the device is visible to the caller, as checkDeviceVisible.
+func (s *Server) visibleDevice(r *http.Request, deviceID string) (DeviceStats, error) {
+	device, err := s.store.Device(r.Context(), deviceID)
+	if err != nil {
+		return DeviceStats{}, err
+	}
+	if org := orgFromContext(r.Context()); org != "" && org != device.Org {
+		return DeviceStats{}, ErrDeviceNotFound
+	}
+	return device, nil
+}
+
+// writeDeviceError writes the response for a device lookup that failed:
+// 404 when the device isn't visible, otherwise the store's error.
+func writeDeviceError(w http.ResponseWriter, deviceID string, err error) {
+	if errors.Is(err, ErrDeviceNotFound) {
+		log.Printf("[WARN] Device not found: %s", deviceID)
+		writeErrorCode(w, http.StatusNotFound, errCodeDeviceNotFound, "device not found")
+		return
+	}
+	writeStorageError(w, err)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Helper to create a test server with two orgs and one API key per org
+func setupAuthTestServer() *Server {
+	store := NewStore()
+	store.devices["device-a"] = &DeviceStats{ID: "device-a", Org: "org-a"}
+	store.devices["device-b"] = &DeviceStats{ID: "device-b", Org: "org-b"}
+	server := NewServer(store, nil)
+	server.EnableAuth(APIKeys{"key-a": "org-a", "key-b": "org-b"})
+	return server
+}
+
+func TestLoadAPIKeysFromCSV(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "api_keys*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString("key,org\nkey-a,org-a\nkey-b,org-b\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	keys, err := LoadAPIKeysFromCSV(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("LoadAPIKeysFromCSV failed: %v", err)
+	}
+	if len(keys) != 2 || keys["key-a"] != "org-a" || keys["key-b"] != "org-b" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+// TestLoadAPIKeysFromCSV_OperatorKey tests that an org of "*" makes a key
+// without an organization
+func TestLoadAPIKeysFromCSV_OperatorKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.csv")
+	if err := os.WriteFile(path, []byte("key,org\nkey-ops,*\nkey-a,org-a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeysFromCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org, ok := keys["key-ops"]; !ok || org != "" || keys["key-a"] != "org-a" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestLoadAPIKeysFromCSV_MissingOrg(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "api_keys*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString("key,org\nkey-a,\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	if _, err := LoadAPIKeysFromCSV(tmpFile.Name()); err == nil {
+		t.Error("expected error for key without org")
+	}
+}
+
+// TestAuth_MissingKey tests 401 when auth is enabled and no key is sent
+func TestAuth_MissingKey(t *testing.T) {
+	server := setupAuthTestServer()
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-a/stats", nil)
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", rr.Code)
+	}
+}
+
+// TestAuth_OrgScoping tests that a key only reaches devices in its own org
+func TestAuth_OrgScoping(t *testing.T) {
+	server := setupAuthTestServer()
+	router := server.Router()
+
+	tests := []struct {
+		key      string
+		deviceID string
+		expected int
+	}{
+		{"key-a", "device-a", http.StatusNoContent},
+		{"key-a", "device-b", http.StatusNotFound},
+		{"key-b", "device-b", http.StatusNoContent},
+		{"key-b", "device-a", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/"+tc.deviceID+"/heartbeat", bytes.NewBufferString(body))
+		req.Header.Set(apiKeyHeader, tc.key)
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.expected {
+			t.Errorf("%s -> %s: expected status %d, got %d", tc.key, tc.deviceID, tc.expected, rr.Code)
+		}
+	}
+
+	// Cross-org GET must not reveal the device either
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-b/stats", nil)
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("cross-org GET: expected status 404, got %d", rr.Code)
+	}
+}
+
+// TestAuth_AdminRequiresFleetKey tests that org-scoped keys can't reach any
+// admin endpoint
+func TestAuth_AdminRequiresFleetKey(t *testing.T) {
+	server := setupAuthTestServer()
+	server.EnableAuth(APIKeys{"key-a": "org-a", "key-admin": ""})
+	router := server.Router()
+
+	for _, route := range routeTemplates {
+		path := "/" + strings.Join(route, "/")
+		if !strings.HasPrefix(path, "/api/v1/admin/") {
+			continue
+		}
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set(apiKeyHeader, "key-a")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s %s: expected status 403, got %d", method, path, rr.Code)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/housekeeping", nil)
+	req.Header.Set(apiKeyHeader, "key-admin")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a fleet key to be served, got %d", rr.Code)
+	}
+}
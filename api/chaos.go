@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Chaos mode is for development only: it makes the API misbehave on purpose
+// so firmware teams can check that devices retry and back off against a
+// server that is slow, fails or hangs up, instead of only against a healthy
+// one. Health probes and metrics scrapes are never affected.
+
+// ChaosConfig sets how often each fault is injected. Percentages are of API
+// requests, from 0 to 100.
+type ChaosConfig struct {
+	Latency        time.Duration // most latency added; each delayed request waits a random time up to it
+	LatencyPercent float64       // requests delayed
+	ErrorPercent   float64       // requests answered with a 500 instead of being handled
+	DropPercent    float64       // requests handled, then cut off without a response
+}
+
+// DefaultChaosConfig returns faults frequent enough to show up within a few
+// minutes of a device's traffic.
+func DefaultChaosConfig() ChaosConfig {
+	return ChaosConfig{
+		Latency:        2 * time.Second,
+		LatencyPercent: 10,
+		ErrorPercent:   5,
+		DropPercent:    2,
+	}
+}
+
+// validate checks the percentages are in range. Errors and drops are
+// exclusive, so together they can't exceed 100.
+func (c ChaosConfig) validate() error {
+	for name, pct := range map[string]float64{"latency": c.LatencyPercent, "error": c.ErrorPercent, "drop": c.DropPercent} {
+		if pct < 0 || pct > 100 {
+			return fmt.Errorf("chaos %s percentage must be between 0 and 100, got %v", name, pct)
+		}
+	}
+	if c.ErrorPercent+c.DropPercent > 100 {
+		return errors.New("chaos error and drop percentages add up to more than 100")
+	}
+	if c.Latency < 0 {
+		return fmt.Errorf("chaos latency must not be negative, got %v", c.Latency)
+	}
+	return nil
+}
+
+// EnableChaos injects the configured faults into API requests.
+func (s *Server) EnableChaos(cfg ChaosConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	s.chaos = &cfg
+	return nil
+}
+
+// injectChaos delays, fails or drops a share of requests. It runs inside the
+// handler timeout, so injected latency can also produce timeouts. Dropped
+// requests are handled first, so devices also see the case where the server
+// applied telemetry but the response was lost. It is a no-op when chaos mode
+// is off.
+func (s *Server) injectChaos(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.chaos == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.chaos.Latency > 0 && rand.Float64()*100 < s.chaos.LatencyPercent {
+			delay := rand.N(s.chaos.Latency) + 1
+			log.Printf("[WARN] Chaos: delaying %s %s by %v", r.Method, r.URL.Path, delay)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		roll := rand.Float64() * 100
+		switch {
+		case roll < s.chaos.DropPercent:
+			log.Printf("[WARN] Chaos: dropping the response to %s %s", r.Method, r.URL.Path)
+			next.ServeHTTP(&discardResponse{header: make(http.Header)}, r)
+			// net/http closes the connection without writing a response
+			panic(http.ErrAbortHandler)
+		case roll < s.chaos.DropPercent+s.chaos.ErrorPercent:
+			log.Printf("[WARN] Chaos: failing %s %s", r.Method, r.URL.Path)
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// discardResponse is a ResponseWriter that throws the response away.
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header         { return d.header }
+func (d *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponse) WriteHeader(int)             {}
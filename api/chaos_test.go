@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChaosConfig_Validate tests that out-of-range percentages are refused
+func TestChaosConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ChaosConfig
+		wantErr bool
+	}{
+		{"defaults", DefaultChaosConfig(), false},
+		{"all errors", ChaosConfig{ErrorPercent: 100}, false},
+		{"negative percentage", ChaosConfig{LatencyPercent: -1}, true},
+		{"over 100", ChaosConfig{DropPercent: 101}, true},
+		{"errors and drops over 100", ChaosConfig{ErrorPercent: 60, DropPercent: 50}, true},
+		{"negative latency", ChaosConfig{Latency: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := setupTestServer().EnableChaos(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestChaos_Error tests that failed requests get a 500 without being handled, and health checks are spared
+func TestChaos_Error(t *testing.T) {
+	server := setupTestServer()
+	if err := server.EnableChaos(ChaosConfig{ErrorPercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(body)))
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "ERR_INTERNAL") {
+		t.Errorf("expected an injected 500, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 0 {
+		t.Errorf("expected no heartbeat recorded, got %d", device.HeartbeatCount)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected health checks unaffected, got %d", rr.Code)
+	}
+}
+
+// TestChaos_Drop tests that dropped requests are applied but the connection closes without a response
+func TestChaos_Drop(t *testing.T) {
+	server := setupTestServer()
+	if err := server.EnableChaos(ChaosConfig{DropPercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	resp, err := http.Post(ts.URL+"/api/v1/devices/device-1/heartbeat", contentTypeJSON, strings.NewReader(body))
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatalf("expected the connection dropped, got status %d", resp.StatusCode)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 1 {
+		t.Errorf("expected the heartbeat recorded, got %d", device.HeartbeatCount)
+	}
+}
+
+// TestChaos_LatencyTimeout tests that injected latency is cut short by the handler timeout
+func TestChaos_LatencyTimeout(t *testing.T) {
+	server := setupTestServer()
+	server.SetHandlerTimeout(10 * time.Millisecond)
+	if err := server.EnableChaos(ChaosConfig{Latency: time.Hour, LatencyPercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operators queue commands for a device, such as reboot or upload_logs,
+// and the device picks them up either on a heartbeat sent with Prefer:
+// return=representation, answered 200 with a small body carrying the server
+// time, the cadence the device is expected at and its pending commands, or
+// by polling GET /commands?deliver=true. Either way a command is delivered
+// once, and the device reports how it went with POST
+// /commands/{id}/ack. Commands are held in memory and aren't saved with
+// snapshots.
+
+// Command limits per device.
+const (
+	maxPendingCommands = 16 // undelivered commands a device can have waiting
+	maxCommandHistory  = 50 // commands kept, oldest finished ones dropped first
+)
+
+// commandNamePattern is what command names may look like.
+var commandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Command states, in order.
+const (
+	commandPending   = "pending"   // queued, not yet picked up
+	commandDelivered = "delivered" // picked up, not yet acknowledged
+	commandSucceeded = "succeeded"
+	commandFailed    = "failed"
+)
+
+// DeviceCommand is an instruction for a device and how far it has got.
+type DeviceCommand struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Args     map[string]string `json:"args,omitempty"`
+	IssuedAt time.Time         `json:"issued_at"`
+
+	State       string    `json:"state"`
+	DeliveredAt time.Time `json:"delivered_at,omitzero"`
+	AckedAt     time.Time `json:"acked_at,omitzero"`
+	Result      string    `json:"result,omitempty"` // the device's report, if any
+}
+
+// finished reports whether the device has acknowledged the command.
+func (cmd *DeviceCommand) finished() bool {
+	return cmd.State == commandSucceeded || cmd.State == commandFailed
+}
+
+// commandQueue holds each device's recent commands.
+type commandQueue struct {
+	mu       sync.Mutex
+	commands map[string][]DeviceCommand // protected by mu; by device ID, oldest first
+	nextID   int64                      // protected by mu
+}
+
+func newCommandQueue() *commandQueue {
+	return &commandQueue{commands: make(map[string][]DeviceCommand)}
+}
+
+// Command queue errors.
+var (
+	errTooManyCommands  = fmt.Errorf("at most %d commands can be pending for a device", maxPendingCommands)
+	errCommandNotFound  = errors.New("command not found")
+	errCommandNotQueued = errors.New("command was already delivered")
+	errCommandNotOut    = errors.New("command isn't awaiting acknowledgement")
+)
+
+// add queues a command for the device, returning it with its ID.
+func (q *commandQueue) add(deviceID string, cmd DeviceCommand) (DeviceCommand, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmds := q.commands[deviceID]
+	pending := 0
+	for i := range cmds {
+		if cmds[i].State == commandPending {
+			pending++
+		}
+	}
+	if pending >= maxPendingCommands {
+		return DeviceCommand{}, errTooManyCommands
+	}
+	q.nextID++
+	cmd.ID = q.nextID
+	cmd.State = commandPending
+	cmds = append(cmds, cmd)
+
+	// Forget the oldest finished commands, then the oldest unacknowledged
+	// ones, never those still waiting
+	for len(cmds) > maxCommandHistory {
+		i := slices.IndexFunc(cmds, func(c DeviceCommand) bool { return c.finished() })
+		if i < 0 {
+			i = slices.IndexFunc(cmds, func(c DeviceCommand) bool { return c.State == commandDelivered })
+		}
+		cmds = slices.Delete(cmds, i, i+1)
+	}
+	q.commands[deviceID] = cmds
+	return cmd, nil
+}
+
+// list returns a copy of the device's commands, only those in state if it
+// isn't empty.
+func (q *commandQueue) list(deviceID, state string) []DeviceCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmds := make([]DeviceCommand, 0, len(q.commands[deviceID]))
+	for _, cmd := range q.commands[deviceID] {
+		if state == "" || cmd.State == state {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// deliver marks the device's pending commands delivered at now, returning them.
+func (q *commandQueue) deliver(deviceID string, now time.Time) []DeviceCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delivered := []DeviceCommand{}
+	cmds := q.commands[deviceID]
+	for i := range cmds {
+		if cmds[i].State == commandPending {
+			cmds[i].State = commandDelivered
+			cmds[i].DeliveredAt = now
+			delivered = append(delivered, cmds[i])
+		}
+	}
+	return delivered
+}
+
+// ack records the device's report on a delivered command.
+func (q *commandQueue) ack(deviceID string, id int64, succeeded bool, result string, now time.Time) (DeviceCommand, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmds := q.commands[deviceID]
+	i := slices.IndexFunc(cmds, func(cmd DeviceCommand) bool { return cmd.ID == id })
+	if i < 0 {
+		return DeviceCommand{}, errCommandNotFound
+	}
+	if cmds[i].State != commandDelivered {
+		return DeviceCommand{}, errCommandNotOut
+	}
+	cmds[i].State = commandFailed
+	if succeeded {
+		cmds[i].State = commandSucceeded
+	}
+	cmds[i].AckedAt = now
+	cmds[i].Result = result
+	return cmds[i], nil
+}
+
+// cancel removes a command that hasn't been delivered.
+func (q *commandQueue) cancel(deviceID string, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cmds := q.commands[deviceID]
+	i := slices.IndexFunc(cmds, func(cmd DeviceCommand) bool { return cmd.ID == id })
+	if i < 0 {
+		return errCommandNotFound
+	}
+	if cmds[i].State != commandPending {
+		return errCommandNotQueued
+	}
+	if cmds = slices.Delete(cmds, i, i+1); len(cmds) == 0 {
+		delete(q.commands, deviceID)
+	} else {
+		q.commands[deviceID] = cmds
+	}
+	return nil
+}
+
+// HeartbeatAck is the body of a heartbeat that asked for one.
+type HeartbeatAck struct {
+	ServerTime time.Time `json:"server_time"`
+
+	// The cadence uptime is measured against; the next heartbeat is due
+	// this long after the one acknowledged
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+
+	Commands []DeviceCommand `json:"commands"` // delivered now, oldest first
+}
+
+// wantsHeartbeatAck reports whether a heartbeat asked for a body with
+// Prefer: return=representation (RFC 7240).
+func wantsHeartbeatAck(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for pref := range strings.SplitSeq(v, ",") {
+			name, _, _ := strings.Cut(pref, ";")
+			if strings.EqualFold(strings.ReplaceAll(name, " ", ""), "return=representation") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acknowledgeHeartbeat records a heartbeat like acknowledge, answering with
+// the device's pending commands and marking them delivered. With
+// the write pipeline the answer is 202, since the heartbeat is only queued.
+func (s *Server) acknowledgeHeartbeat(w http.ResponseWriter, r *http.Request, deviceID string, format durationFormat, record func(ctx context.Context) error) error {
+	if err := record(r.Context()); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	ack := HeartbeatAck{ServerTime: now, Commands: s.commands.deliver(deviceID, now)}
+	if device, err := s.store.Device(r.Context(), deviceID); err == nil {
+		ack.HeartbeatInterval = format.duration(device.EffectiveInterval())
+	}
+	if len(ack.Commands) > 0 {
+		log.Printf("[INFO] Delivered %d commands to %s on heartbeat", len(ack.Commands), deviceID)
+	}
+	status := http.StatusOK
+	if s.pipeline != nil {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Preference-Applied", "return=representation")
+	writeJSON(w, status, ack)
+	return nil
+}
+
+// CommandRequest is the body of POST /commands.
+type CommandRequest struct {
+	Name string            `json:"name"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// CommandsResponse lists a device's commands.
+type CommandsResponse struct {
+	DeviceID string          `json:"device_id"`
+	Commands []DeviceCommand `json:"commands"` // oldest first
+}
+
+// CommandAckRequest is the body of POST /commands/{id}/ack.
+type CommandAckRequest struct {
+	Status string `json:"status"` // succeeded or failed
+	Result string `json:"result,omitempty"`
+}
+
+// maxCommandResultLength is the longest report a device can attach to an ack.
+const maxCommandResultLength = 1024
+
+// commandStates are the values ?state= accepts.
+var commandStates = []string{commandPending, commandDelivered, commandSucceeded, commandFailed}
+
+// HandleCommands processes GET and POST /api/v1/devices/{device_id}/commands
+func (s *Server) HandleCommands(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] %s /api/v1/devices/%s/commands", r.Method, deviceID)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// ?deliver=true is the device's poll: it picks up its pending
+		// commands, which are then delivered
+		query := r.URL.Query()
+		if query.Get("deliver") == "true" {
+			cmds := s.commands.deliver(deviceID, time.Now().UTC())
+			if len(cmds) > 0 {
+				log.Printf("[INFO] Delivered %d commands to %s on poll", len(cmds), deviceID)
+			}
+			writeJSON(w, http.StatusOK, CommandsResponse{DeviceID: deviceID, Commands: cmds})
+			return
+		}
+		state := query.Get("state")
+		if state != "" && !slices.Contains(commandStates, state) {
+			writeError(w, http.StatusBadRequest, "state must be one of "+strings.Join(commandStates, ", "))
+			return
+		}
+		writeJSON(w, http.StatusOK, CommandsResponse{DeviceID: deviceID, Commands: s.commands.list(deviceID, state)})
+		return
+	}
+
+	var req CommandRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if !commandNamePattern.MatchString(req.Name) {
+		writeError(w, http.StatusBadRequest, "name must be 1 to 64 lowercase letters, digits or underscores, starting with a letter")
+		return
+	}
+	retired, err := s.store.IsDecommissioned(r.Context(), deviceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if retired {
+		writeErrorCode(w, http.StatusGone, errCodeDeviceDecommissioned, "device decommissioned")
+		return
+	}
+	cmd, err := s.commands.add(deviceID, DeviceCommand{Name: req.Name, Args: req.Args, IssuedAt: time.Now().UTC()})
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("[INFO] Queued command %s (#%d) for %s", cmd.Name, cmd.ID, deviceID)
+	writeJSON(w, http.StatusCreated, cmd)
+}
+
+// HandleCommand processes DELETE /api/v1/devices/{device_id}/commands/{id}
+// and POST /api/v1/devices/{device_id}/commands/{id}/ack
+func (s *Server) HandleCommand(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	_, rest, _ := strings.Cut(r.URL.Path, "/commands/")
+	log.Printf("[REQUEST] %s /api/v1/devices/%s/commands/%s", r.Method, deviceID, rest)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	rest, isAck := strings.CutSuffix(rest, "/ack")
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "command ID must be a positive integer")
+		return
+	}
+
+	if !isAck {
+		if err := s.commands.cancel(deviceID, id); err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		log.Printf("[INFO] Cancelled command #%d for %s", id, deviceID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req CommandAckRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if req.Status != commandSucceeded && req.Status != commandFailed {
+		writeError(w, http.StatusBadRequest, "status must be succeeded or failed")
+		return
+	}
+	if len(req.Result) > maxCommandResultLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("result must be at most %d bytes", maxCommandResultLength))
+		return
+	}
+	cmd, err := s.commands.ack(deviceID, id, req.Status == commandSucceeded, req.Result, time.Now().UTC())
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	log.Printf("[INFO] Command %s (#%d) %s on %s", cmd.Name, cmd.ID, cmd.State, deviceID)
+	writeJSON(w, http.StatusOK, cmd)
+}
+
+// writeCommandError answers a request for a command that doesn't exist or
+// isn't in a state that allows it.
+func writeCommandError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCommandNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusConflict, err.Error())
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHeartbeatAck tests that a heartbeat asking for a body gets the
+// server time, its interval and its pending commands, once
+func TestHeartbeatAck(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	minute := 0
+	heartbeat := func(prefer string) (*httptest.ResponseRecorder, HeartbeatAck) {
+		minute++
+		body := fmt.Sprintf(`{"sent_at": "2024-01-15T10:%02d:00Z"}`, minute)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat?format=iso8601", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if prefer != "" {
+			req.Header.Set("Prefer", prefer)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var ack HeartbeatAck
+		if rr.Code == http.StatusOK {
+			_ = json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&ack)
+		}
+		return rr, ack
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/commands", bytes.NewBufferString(`{"name": "send_diagnostics", "args": {"level": "full"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Without the preference the heartbeat is answered as before, and the
+	// command keeps waiting
+	if rr, _ := heartbeat(""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+
+	rr, ack := heartbeat("respond-async, return=representation")
+	if rr.Code != http.StatusOK || rr.Header().Get("Preference-Applied") != "return=representation" {
+		t.Fatalf("expected status 200 with the preference applied, got %d %v", rr.Code, rr.Header())
+	}
+	if ack.ServerTime.IsZero() || ack.HeartbeatInterval.Duration != defaultHeartbeatInterval {
+		t.Errorf("unexpected acknowledgement %+v", ack)
+	}
+	if len(ack.Commands) != 1 || ack.Commands[0].Name != "send_diagnostics" || ack.Commands[0].Args["level"] != "full" {
+		t.Errorf("expected the queued command, got %+v", ack.Commands)
+	}
+	if server.backend().(*Store).devices["device-1"].HeartbeatCount != 2 {
+		t.Error("heartbeats were not recorded")
+	}
+
+	// Delivered commands aren't sent again
+	if _, ack := heartbeat("return=representation"); ack.Commands == nil || len(ack.Commands) != 0 {
+		t.Errorf("expected no commands, got %+v", ack.Commands)
+	}
+	if cmds := server.commands.list("device-1", commandDelivered); len(cmds) != 1 || cmds[0].DeliveredAt.IsZero() {
+		t.Errorf("expected the command to be marked delivered, got %+v", cmds)
+	}
+}
+
+// TestCommandLifecycle tests polling for commands and acknowledging them
+func TestCommandLifecycle(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	list := func(query string) []DeviceCommand {
+		var resp CommandsResponse
+		_ = json.NewDecoder(do(http.MethodGet, "/api/v1/devices/device-1/commands"+query, "").Body).Decode(&resp)
+		return resp.Commands
+	}
+
+	do(http.MethodPost, "/api/v1/devices/device-1/commands", `{"name": "reboot"}`)
+	do(http.MethodPost, "/api/v1/devices/device-1/commands", `{"name": "upload_logs"}`)
+
+	// Listing doesn't deliver; polling does, once
+	if cmds := list("?state=pending"); len(cmds) != 2 {
+		t.Fatalf("expected 2 pending commands, got %+v", cmds)
+	}
+	polled := list("?deliver=true")
+	if len(polled) != 2 || polled[0].State != commandDelivered || polled[0].Name != "reboot" {
+		t.Fatalf("expected both commands delivered, got %+v", polled)
+	}
+	if cmds := list("?deliver=true"); cmds == nil || len(cmds) != 0 {
+		t.Errorf("expected nothing on the second poll, got %+v", cmds)
+	}
+
+	ack := func(id int64, body string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/api/v1/devices/device-1/commands/"+strconv.FormatInt(id, 10)+"/ack", body)
+	}
+	if rr := ack(polled[0].ID, `{"status": "succeeded"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := ack(polled[1].ID, `{"status": "failed", "result": "disk full"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+	if rr := ack(polled[0].ID, `{"status": "failed"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409 acknowledging twice, got %d", rr.Code)
+	}
+	if rr := ack(polled[0].ID, `{"status": "done"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad status, got %d", rr.Code)
+	}
+	if rr := ack(999, `{"status": "succeeded"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown command, got %d", rr.Code)
+	}
+
+	cmds := list("")
+	if len(cmds) != 2 || cmds[0].State != commandSucceeded || cmds[1].State != commandFailed || cmds[1].Result != "disk full" || cmds[1].AckedAt.IsZero() {
+		t.Errorf("unexpected command states %+v", cmds)
+	}
+	if cmds := list("?state=failed"); len(cmds) != 1 {
+		t.Errorf("expected 1 failed command, got %+v", cmds)
+	}
+	if rr := do(http.MethodGet, "/api/v1/devices/device-1/commands?state=lost", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad state, got %d", rr.Code)
+	}
+
+	// Delivered commands can't be cancelled
+	if rr := do(http.MethodDelete, "/api/v1/devices/device-1/commands/"+strconv.FormatInt(polled[0].ID, 10), ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rr.Code)
+	}
+}
+
+// TestCommandQueue_History tests that finished commands are forgotten first
+func TestCommandQueue_History(t *testing.T) {
+	q := newCommandQueue()
+	now := time.Now()
+	first, _ := q.add("cam-1", DeviceCommand{Name: "reboot"})
+	for range maxCommandHistory - 1 {
+		q.add("cam-1", DeviceCommand{Name: "reboot"})
+		q.deliver("cam-1", now)
+	}
+	if _, err := q.ack("cam-1", first.ID+1, true, "", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q.add("cam-1", DeviceCommand{Name: "upload_logs"})
+
+	cmds := q.list("cam-1", "")
+	if len(cmds) != maxCommandHistory || cmds[0].ID != first.ID || cmds[1].ID != first.ID+2 {
+		t.Errorf("expected the finished command to be dropped, got %d commands starting %d, %d", len(cmds), cmds[0].ID, cmds[1].ID)
+	}
+}
+
+// TestHandleCommands tests queueing, listing and cancelling commands
+func TestHandleCommands(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, body := range []string{`{"name": ""}`, `{"name": "Reboot"}`, `{"name": "reboot", "when": "now"}`} {
+		if rr := do(http.MethodPost, "/api/v1/devices/device-1/commands", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rr.Code)
+		}
+	}
+	if rr := do(http.MethodPost, "/api/v1/devices/unknown/commands", `{"name": "reboot"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown device, got %d", rr.Code)
+	}
+
+	var cmd DeviceCommand
+	rr := do(http.MethodPost, "/api/v1/devices/device-1/commands", `{"name": "reboot"}`)
+	if err := json.NewDecoder(rr.Body).Decode(&cmd); err != nil || cmd.ID == 0 {
+		t.Fatalf("unexpected command %+v (%v)", cmd, err)
+	}
+	do(http.MethodPost, "/api/v1/devices/device-1/commands", `{"name": "send_diagnostics"}`)
+
+	var resp CommandsResponse
+	_ = json.NewDecoder(do(http.MethodGet, "/api/v1/devices/device-1/commands", "").Body).Decode(&resp)
+	if len(resp.Commands) != 2 || resp.Commands[0].Name != "reboot" {
+		t.Errorf("expected both commands oldest first, got %+v", resp.Commands)
+	}
+
+	path := "/api/v1/devices/device-1/commands/" + strconv.FormatInt(cmd.ID, 10)
+	if rr := do(http.MethodDelete, path, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, path, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 cancelling twice, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/v1/devices/device-1/commands/x", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad ID, got %d", rr.Code)
+	}
+
+	for range maxPendingCommands - 1 {
+		do(http.MethodPost, "/api/v1/devices/device-2/commands", `{"name": "reboot"}`)
+	}
+	if rr := do(http.MethodPost, "/api/v1/devices/device-2/commands", `{"name": "reboot"}`); rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/api/v1/devices/device-2/commands", `{"name": "reboot"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409 over the limit, got %d", rr.Code)
+	}
+}
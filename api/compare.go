@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device comparison for support, who are often asked whether one camera is
+// worse than its neighbors or the whole floor is bad. Each device is set
+// against the mean of the others compared with it, and the compared devices
+// together against the caller's fleet.
+
+// maxCompareDevices bounds how many devices one comparison may name.
+const maxCompareDevices = 50
+
+// DeviceComparison is one device's stats and how they differ from its peers.
+type DeviceComparison struct {
+	DeviceID      string    `json:"device_id"`
+	Status        string    `json:"status"`          // as in v2 stats
+	Uptime        *float64  `json:"uptime"`          // null before the first heartbeat
+	AvgUploadTime *Duration `json:"avg_upload_time"` // null before the first upload
+	MaxUploadTime *Duration `json:"max_upload_time"`
+	UploadCount   int64     `json:"upload_count"`
+	LastHeartbeat time.Time `json:"last_heartbeat,omitzero"`
+	NetworkScore  *float64  `json:"network_score"` // null until two heartbeats arrive
+
+	// Difference from the mean of the other compared devices: negative
+	// uptime and positive upload time are worse. Null when either side has
+	// no data, and for decommissioned devices
+	UptimeVsPeers        *float64  `json:"uptime_vs_peers"`
+	AvgUploadTimeVsPeers *Duration `json:"avg_upload_time_vs_peers"`
+}
+
+// ComparisonBaseline averages a set of devices, each counting once.
+// Decommissioned devices and devices without data are left out.
+type ComparisonBaseline struct {
+	Devices       int       `json:"devices"`
+	Uptime        *float64  `json:"uptime"`          // mean uptime of reporting devices
+	AvgUploadTime *Duration `json:"avg_upload_time"` // mean of their average upload times
+}
+
+// DeviceComparisonResponse is the body of GET /api/v1/devices/compare.
+type DeviceComparisonResponse struct {
+	Devices []DeviceComparison `json:"devices"` // in the order requested
+	Peers   ComparisonBaseline `json:"peers"`   // the compared devices
+	Fleet   ComparisonBaseline `json:"fleet"`   // every device visible to the caller
+}
+
+// comparisonSums accumulates a baseline.
+type comparisonSums struct {
+	devices        int
+	uptimes        int
+	uptimeSum      float64
+	uploaders      int
+	avgUploadSum   time.Duration
+	excludedDevice string // device whose values are left out, for a peer mean
+}
+
+func (c *comparisonSums) add(device *DeviceStats) {
+	if !device.DecommissionedAt.IsZero() || device.ID == c.excludedDevice {
+		return
+	}
+	c.devices++
+	stats := device.Stats()
+	if stats.HasHeartbeats {
+		c.uptimes++
+		c.uptimeSum += stats.Uptime
+	}
+	if stats.HasUploads {
+		c.uploaders++
+		c.avgUploadSum += stats.AvgUploadTime
+	}
+}
+
+// means returns the mean uptime and average upload time; false for either
+// that no device reported.
+func (c *comparisonSums) means() (float64, bool, time.Duration, bool) {
+	var uptime float64
+	var upload time.Duration
+	if c.uptimes > 0 {
+		uptime = c.uptimeSum / float64(c.uptimes)
+	}
+	if c.uploaders > 0 {
+		upload = c.avgUploadSum / time.Duration(c.uploaders)
+	}
+	return uptime, c.uptimes > 0, upload, c.uploaders > 0
+}
+
+func (c *comparisonSums) baseline(format durationFormat) ComparisonBaseline {
+	baseline := ComparisonBaseline{Devices: c.devices}
+	uptime, hasUptime, upload, hasUpload := c.means()
+	if hasUptime {
+		uptime = format.percent(uptime)
+		baseline.Uptime = &uptime
+	}
+	if hasUpload {
+		v := format.duration(upload)
+		baseline.AvgUploadTime = &v
+	}
+	return baseline
+}
+
+// parseCompareIDs reads the comma-separated ids parameter.
+func parseCompareIDs(r *http.Request) ([]string, string) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if slices.Contains(ids, id) {
+			return nil, "duplicate device id: " + id
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case len(ids) < 2:
+		return nil, "ids must name at least 2 devices"
+	case len(ids) > maxCompareDevices:
+		return nil, "ids may name at most " + strconv.Itoa(maxCompareDevices) + " devices"
+	}
+	return ids, ""
+}
+
+// HandleCompareDevices processes GET /api/v1/devices/compare
+func (s *Server) HandleCompareDevices(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/devices/compare")
+
+	ids, msg := parseCompareIDs(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	devices := make([]DeviceStats, len(ids))
+	for i, id := range ids {
+		device, err := s.visibleDevice(r, id)
+		if errors.Is(err, ErrDeviceNotFound) {
+			log.Printf("[WARN] Device not found: %s", id)
+			writeErrorCode(w, http.StatusNotFound, errCodeDeviceNotFound, "device not found: "+id)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		devices[i] = device
+	}
+
+	now := time.Now().UTC()
+	thresholds, err := s.store.GroupAlertThresholds(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	resp := DeviceComparisonResponse{Devices: make([]DeviceComparison, len(devices))}
+	for i := range devices {
+		device := &devices[i]
+		stats := device.Stats()
+		cmp := DeviceComparison{
+			DeviceID:      device.ID,
+			Status:        deviceStatus(*device, offlineThreshold(*device, thresholds, s.offlineAfter), now),
+			UploadCount:   device.UploadCount,
+			LastHeartbeat: device.LastHeartbeat,
+		}
+		if stats.HasHeartbeats {
+			uptime := format.percent(stats.Uptime)
+			cmp.Uptime = &uptime
+		}
+		if stats.HasUploads {
+			avg, maxUpload := format.duration(stats.AvgUploadTime), format.duration(stats.MaxUploadTime)
+			cmp.AvgUploadTime, cmp.MaxUploadTime = &avg, &maxUpload
+		}
+		if quality, ok := device.NetworkQuality(); ok {
+			cmp.NetworkScore = &quality.Score
+		}
+
+		if device.DecommissionedAt.IsZero() {
+			others := comparisonSums{excludedDevice: device.ID}
+			for j := range devices {
+				others.add(&devices[j])
+			}
+			uptime, hasUptime, upload, hasUpload := others.means()
+			if stats.HasHeartbeats && hasUptime {
+				delta := format.percent(stats.Uptime - uptime)
+				cmp.UptimeVsPeers = &delta
+			}
+			if stats.HasUploads && hasUpload {
+				delta := format.duration(stats.AvgUploadTime - upload)
+				cmp.AvgUploadTimeVsPeers = &delta
+			}
+		}
+		resp.Devices[i] = cmp
+	}
+
+	var peers, fleet comparisonSums
+	for i := range devices {
+		peers.add(&devices[i])
+	}
+	all, err := s.orgDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	for _, device := range all {
+		fleet.add(&device)
+	}
+	resp.Peers = peers.baseline(format)
+	resp.Fleet = fleet.baseline(format)
+
+	writeJSON(w, http.StatusOK, resp)
+}
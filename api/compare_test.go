@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCompareDevices tests comparing devices with their peers and the fleet
+func TestCompareDevices(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	store.devices["device-3"] = &DeviceStats{ID: "device-3"}
+	store.devices["device-4"] = &DeviceStats{ID: "device-4", DecommissionedAt: time.Now()}
+	router := server.Router()
+
+	// device-2 misses most heartbeats and uploads slowly
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 11 {
+		store.RecordHeartbeat(t.Context(), "device-1", start.Add(time.Duration(i)*time.Minute))
+		store.RecordHeartbeat(t.Context(), "device-3", start.Add(time.Duration(i)*time.Minute))
+	}
+	store.RecordHeartbeat(t.Context(), "device-2", start)
+	store.RecordHeartbeat(t.Context(), "device-2", start.Add(10*time.Minute))
+	store.RecordUploadStat(t.Context(), "device-1", time.Second)
+	store.RecordUploadStat(t.Context(), "device-2", 4*time.Second)
+	store.RecordUploadStat(t.Context(), "device-3", time.Second)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/compare?ids=device-2,device-1,device-3,device-4&format=seconds", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Devices []struct {
+			DeviceID             string   `json:"device_id"`
+			Status               string   `json:"status"`
+			Uptime               *float64 `json:"uptime"`
+			UptimeVsPeers        *float64 `json:"uptime_vs_peers"`
+			AvgUploadTimeVsPeers *float64 `json:"avg_upload_time_vs_peers"`
+		} `json:"devices"`
+		Peers struct {
+			Devices       int      `json:"devices"`
+			Uptime        *float64 `json:"uptime"`
+			AvgUploadTime *float64 `json:"avg_upload_time"`
+		} `json:"peers"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if len(resp.Devices) != 4 || resp.Devices[0].DeviceID != "device-2" || resp.Devices[3].Status != statusRetired {
+		t.Fatalf("expected devices in request order, got %+v", resp.Devices)
+	}
+	worst := resp.Devices[0]
+	if worst.UptimeVsPeers == nil || *worst.UptimeVsPeers >= 0 {
+		t.Errorf("expected device-2 below its peers' uptime, got %v", worst.UptimeVsPeers)
+	}
+	if worst.AvgUploadTimeVsPeers == nil || *worst.AvgUploadTimeVsPeers != 3 {
+		t.Errorf("expected device-2 3s slower than its peers, got %v", worst.AvgUploadTimeVsPeers)
+	}
+	if best := resp.Devices[1]; best.UptimeVsPeers == nil || *best.UptimeVsPeers <= 0 {
+		t.Errorf("expected device-1 above its peers' uptime, got %v", best.UptimeVsPeers)
+	}
+	if retired := resp.Devices[3]; retired.UptimeVsPeers != nil {
+		t.Errorf("expected no deltas for a decommissioned device, got %v", *retired.UptimeVsPeers)
+	}
+	if resp.Peers.Devices != 3 || resp.Peers.AvgUploadTime == nil || *resp.Peers.AvgUploadTime != 2 {
+		t.Errorf("expected 3 peers averaging 2s, got %+v", resp.Peers)
+	}
+}
+
+// TestCompareDevices_Invalid tests rejecting bad ids
+func TestCompareDevices_Invalid(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	store.devices["device-1"].Org = "acme"
+	store.devices["device-2"].Org = "acme"
+	store.devices["device-3"] = &DeviceStats{ID: "device-3", Org: "globex"}
+	server.EnableAuth(APIKeys{"key-acme": "acme"})
+	router := server.Router()
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"missing", "", http.StatusBadRequest},
+		{"one device", "?ids=device-1", http.StatusBadRequest},
+		{"duplicate", "?ids=device-1,device-1", http.StatusBadRequest},
+		{"unknown", "?ids=device-1,nope", http.StatusNotFound},
+		{"another org's device", "?ids=device-1,device-3", http.StatusNotFound},
+		{"visible", "?ids=device-1,device-2", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/compare"+tt.query, nil)
+			req.Header.Set("X-API-Key", "key-acme")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// During an incident, dashboards and on-call pile onto GET /stats and the
+// fleet views while devices keep sending heartbeats. Per-route concurrency
+// limits cap how many requests to a route run at once, so a stampede on
+// one route holds at most its own slots and can't starve ingestion of
+// CPU and store locks. A request over its route's limit waits briefly for
+// a slot, then gets 503 with Retry-After. Routes without a limit aren't
+// affected.
+
+// DefaultRouteConcurrencyWait is how long a request waits for a slot on a
+// limited route before it's shed.
+const DefaultRouteConcurrencyWait = 100 * time.Millisecond
+
+// RouteLimit caps the requests to one route that run at once.
+type RouteLimit struct {
+	Method string // empty limits every method
+	Route  string // a route template, as in the request metrics
+	Max    int
+}
+
+// ParseRouteLimits reads limits written as "[METHOD ]ROUTE=MAX", separated
+// by commas, such as "GET /api/v1/devices/{device_id}/stats=32".
+func ParseRouteLimits(spec string) ([]RouteLimit, error) {
+	var limits []RouteLimit
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("route limit %q must be ROUTE=MAX", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("route limit %q must be a positive integer", entry)
+		}
+		limit := RouteLimit{Route: strings.TrimSpace(target), Max: n}
+		if method, route, ok := strings.Cut(limit.Route, " "); ok {
+			limit.Method, limit.Route = strings.ToUpper(method), strings.TrimSpace(route)
+			if methodLabel(limit.Method) != limit.Method || limit.Method == "OTHER" {
+				return nil, fmt.Errorf("route limit %q has an unknown method", entry)
+			}
+		}
+		if !slices.ContainsFunc(routeTemplates, func(t []string) bool { return "/"+strings.Join(t, "/") == limit.Route }) {
+			return nil, fmt.Errorf("route limit %q names an unknown route; use a template such as /api/v1/devices/{device_id}/stats", entry)
+		}
+		if slices.ContainsFunc(limits, func(l RouteLimit) bool { return l.Method == limit.Method && l.Route == limit.Route }) {
+			return nil, fmt.Errorf("route limit %q is given twice", entry)
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// routeSemaphore holds the slots of one limited route.
+type routeSemaphore struct {
+	limit RouteLimit
+	slots chan struct{}
+
+	mu       sync.Mutex
+	rejected int64 // protected by mu
+}
+
+// routeLimiter finds the semaphore for each request.
+type routeLimiter struct {
+	wait       time.Duration
+	semaphores map[RouteLimit]*routeSemaphore // keyed by method and route, Max zeroed
+}
+
+// acquire takes a slot, waiting up to the limiter's wait, and reports
+// whether it got one.
+func (sem *routeSemaphore) acquire(ctx context.Context, wait time.Duration) bool {
+	select {
+	case sem.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case sem.slots <- struct{}{}:
+			return true
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	sem.mu.Lock()
+	sem.rejected++
+	sem.mu.Unlock()
+	return false
+}
+
+func (sem *routeSemaphore) release() {
+	<-sem.slots
+}
+
+// EnableRouteLimits caps concurrent requests per route, shedding those that
+// wait longer than wait for a slot.
+func (s *Server) EnableRouteLimits(limits []RouteLimit, wait time.Duration) {
+	l := &routeLimiter{wait: wait, semaphores: make(map[RouteLimit]*routeSemaphore, len(limits))}
+	for _, limit := range limits {
+		l.semaphores[RouteLimit{Method: limit.Method, Route: limit.Route}] = &routeSemaphore{limit: limit, slots: make(chan struct{}, limit.Max)}
+	}
+	s.routeLimits = l
+}
+
+// semaphore returns the semaphore limiting a request, preferring a limit
+// for its method over one for the whole route; nil if it isn't limited.
+func (l *routeLimiter) semaphore(method, path string) *routeSemaphore {
+	route := routeLabel(path)
+	if sem, exists := l.semaphores[RouteLimit{Method: methodLabel(method), Route: route}]; exists {
+		return sem
+	}
+	return l.semaphores[RouteLimit{Route: route}]
+}
+
+// limitConcurrency holds a slot of the request's route while it's handled.
+func (s *Server) limitConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.routeLimits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sem := s.routeLimits.semaphore(r.Method, r.URL.Path)
+		if sem == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sem.acquire(r.Context(), s.routeLimits.wait) {
+			log.Printf("[WARN] Concurrency limit of %d reached for %s %s, shedding request", sem.limit.Max, r.Method, sem.limit.Route)
+			w.Header().Set("Retry-After", "1")
+			writeErrorCode(w, http.StatusServiceUnavailable, errCodeConcurrencyLimit, "too many concurrent requests to this endpoint")
+			return
+		}
+		defer sem.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteLimitStatus reports one limited route.
+type RouteLimitStatus struct {
+	Method   string `json:"method,omitempty"` // empty when every method shares the limit
+	Route    string `json:"route"`
+	Max      int    `json:"max"`
+	InFlight int    `json:"in_flight"`
+	Rejected int64  `json:"rejected"`
+}
+
+// status reports every limited route, sorted by route and method.
+func (l *routeLimiter) status() []RouteLimitStatus {
+	statuses := make([]RouteLimitStatus, 0, len(l.semaphores))
+	for _, sem := range l.semaphores {
+		sem.mu.Lock()
+		rejected := sem.rejected
+		sem.mu.Unlock()
+		statuses = append(statuses, RouteLimitStatus{
+			Method:   sem.limit.Method,
+			Route:    sem.limit.Route,
+			Max:      sem.limit.Max,
+			InFlight: len(sem.slots),
+			Rejected: rejected,
+		})
+	}
+	slices.SortFunc(statuses, func(a, b RouteLimitStatus) int {
+		if c := strings.Compare(a.Route, b.Route); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return statuses
+}
+
+// writeRouteLimitMetrics writes each limited route's slots in use and
+// rejections.
+func (s *Server) writeRouteLimitMetrics(w io.Writer, openMetrics bool) {
+	if s.routeLimits == nil {
+		return
+	}
+	statuses := s.routeLimits.status()
+	labels := func(st RouteLimitStatus) string {
+		return fmt.Sprintf("method=%q,route=%q", st.Method, st.Route)
+	}
+	fmt.Fprintln(w, "# HELP safelyyou_route_concurrency_in_flight Requests holding a slot of a concurrency-limited route.")
+	fmt.Fprintln(w, "# TYPE safelyyou_route_concurrency_in_flight gauge")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "safelyyou_route_concurrency_in_flight{%s} %d\n", labels(st), st.InFlight)
+	}
+	fmt.Fprintln(w, "# HELP safelyyou_route_concurrency_limit Slots of a concurrency-limited route.")
+	fmt.Fprintln(w, "# TYPE safelyyou_route_concurrency_limit gauge")
+	for _, st := range statuses {
+		fmt.Fprintf(w, "safelyyou_route_concurrency_limit{%s} %d\n", labels(st), st.Max)
+	}
+	writeCounterFamily(w, "safelyyou_route_concurrency_rejections_total", "Requests shed because their route had no free slot.", openMetrics)
+	for _, st := range statuses {
+		fmt.Fprintf(w, "safelyyou_route_concurrency_rejections_total{%s} %d\n", labels(st), st.Rejected)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseRouteLimits tests reading per-route concurrency limits
+func TestParseRouteLimits(t *testing.T) {
+	limits, err := ParseRouteLimits("get /api/v1/devices/{device_id}/stats=32, /api/v1/fleet/sla=4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []RouteLimit{
+		{Method: http.MethodGet, Route: "/api/v1/devices/{device_id}/stats", Max: 32},
+		{Route: "/api/v1/fleet/sla", Max: 4},
+	}
+	if len(limits) != len(want) || limits[0] != want[0] || limits[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, limits)
+	}
+
+	for _, spec := range []string{
+		"/api/v1/fleet/sla",
+		"/api/v1/fleet/sla=0",
+		"/api/v1/fleet/sla=many",
+		"/api/v1/devices/device-1/stats=4",
+		"FETCH /api/v1/fleet/sla=4",
+		"/api/v1/fleet/sla=4,/api/v1/fleet/sla=8",
+	} {
+		if _, err := ParseRouteLimits(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// TestLimitConcurrency tests that a route over its limit sheds requests
+// while other routes keep working
+func TestLimitConcurrency(t *testing.T) {
+	server := setupTestServer()
+	server.EnableRouteLimits([]RouteLimit{{Method: http.MethodGet, Route: "/api/v1/devices/{device_id}/stats", Max: 1}}, 20*time.Millisecond)
+	router := server.Router()
+
+	stats := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+		return rr
+	}
+	if rr := stats(); rr.Code == http.StatusServiceUnavailable {
+		t.Fatalf("expected the request to run, got %d", rr.Code)
+	}
+
+	// Hold the route's only slot, as a slow request would
+	sem := server.routeLimits.semaphore(http.MethodGet, "/api/v1/devices/device-2/stats")
+	sem.slots <- struct{}{}
+
+	rr := stats()
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" || !strings.Contains(rr.Body.String(), errCodeConcurrencyLimit) {
+		t.Errorf("expected 503 %s, got %d: %s", errCodeConcurrencyLimit, rr.Code, rr.Body.String())
+	}
+
+	// Heartbeats have their own route, and POST /stats isn't limited
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(`{"sent_at": "2024-01-15T10:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	hb := httptest.NewRecorder()
+	router.ServeHTTP(hb, req)
+	if hb.Code != http.StatusNoContent {
+		t.Errorf("expected heartbeats to be unaffected, got %d", hb.Code)
+	}
+
+	// A slot freed while waiting is taken
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		sem.release()
+	}()
+	server.routeLimits.wait = time.Second
+	if rr := stats(); rr.Code == http.StatusServiceUnavailable {
+		t.Errorf("expected the waiting request to run, got %d", rr.Code)
+	}
+
+	status := server.routeLimits.status()
+	if len(status) != 1 || status[0].Rejected != 1 || status[0].InFlight != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// supportedContentTypes returns the body types accepted on path.
+func supportedContentTypes(path string) []string {
+	if path == "/api/v1/ingest" {
+		return []string{contentTypeNDJSON, contentTypeJSON}
+	}
+	if path == "/api/v1/admin/devices/import" || path == "/api/v1/admin/validate-csv" {
+		return []string{contentTypeCSV}
+	}
+	if strings.HasPrefix(path, "/api/v1/devices/") && strings.HasSuffix(path, "/diagnostics") {
+		return diagnosticsContentTypes
+	}
+	return []string{contentTypeJSON}
+}
+
+// requireContentType rejects POST, PUT and PATCH bodies whose Content-Type
+// the endpoint can't decode with 415 and the list of supported types. A
+// missing Content-Type is accepted as the endpoint's default, since older
+// device firmware doesn't send one.
+func requireContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Content-Type")
+		if header == "" || !slices.Contains([]string{http.MethodPost, http.MethodPut, http.MethodPatch}, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		supported := supportedContentTypes(r.URL.Path)
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil || !slices.Contains(supported, mediaType) {
+			log.Printf("[WARN] Unsupported Content-Type %q for %s %s", header, r.Method, r.URL.Path)
+			writeErrorResponse(w, http.StatusUnsupportedMediaType, ErrorResponse{
+				Msg:       fmt.Sprintf("unsupported Content-Type %q", header),
+				Code:      errCodeUnsupportedMediaType,
+				Supported: supported,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSON strictly decodes a single JSON value from data into v. Unknown
+// fields are rejected so typos and firmware drift surface instead of being
+// silently dropped. Errors are validationErrors naming the offending field.
+func decodeJSON(data []byte, v any) error {
+	return decodeJSONFrom(bytes.NewReader(data), v)
+}
+
+// decodeJSONBody strictly decodes a request body into v, like decodeJSON.
+func decodeJSONBody(r *http.Request, v any) error {
+	return decodeJSONFrom(r.Body, v)
+}
+
+func decodeJSONFrom(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return jsonFieldError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &validationError{code: errCodeInvalidJSON, msg: "invalid JSON"}
+	}
+	return nil
+}
+
+// jsonFieldError turns an encoding/json error into a validationError with a
+// message naming the field, where the decoder reports one.
+func jsonFieldError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &validationError{
+			code:  errCodeInvalidFieldType,
+			field: typeErr.Field,
+			msg:   fmt.Sprintf("%s must be %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value),
+		}
+	}
+	// encoding/json has no typed error for unknown fields
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field := strings.Trim(name, `"`)
+		return &validationError{code: errCodeUnknownField, field: field, msg: fmt.Sprintf("unknown field %q", field)}
+	}
+	return &validationError{code: errCodeInvalidJSON, msg: "invalid JSON"}
+}
+
+// jsonTypeName describes a Go type the way a JSON client would see it.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
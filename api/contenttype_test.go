@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// TestRequireContentType tests which Content-Types each endpoint accepts
+func TestRequireContentType(t *testing.T) {
+	heartbeat := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	ingest := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		contentType string
+		want        int
+	}{
+		{"json", "/api/v1/devices/device-1/heartbeat", heartbeat, "application/json", http.StatusNoContent},
+		{"json with charset", "/api/v1/devices/device-1/heartbeat", heartbeat, "application/json; charset=utf-8", http.StatusNoContent},
+		{"missing", "/api/v1/devices/device-1/heartbeat", heartbeat, "", http.StatusNoContent},
+		{"text", "/api/v1/devices/device-1/heartbeat", heartbeat, "text/plain", http.StatusUnsupportedMediaType},
+		{"form", "/api/v1/devices/device-1/stats", "sent_at=now", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"malformed", "/api/v1/devices/device-1/heartbeat", heartbeat, "application/", http.StatusUnsupportedMediaType},
+		{"ndjson ingest", "/api/v1/ingest", ingest, "application/x-ndjson", http.StatusOK},
+		{"ndjson heartbeat", "/api/v1/devices/device-1/heartbeat", heartbeat, "application/x-ndjson", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupTestServer().Router()
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusUnsupportedMediaType {
+				return
+			}
+			var resp ProblemDetails
+			_ = json.NewDecoder(rr.Body).Decode(&resp)
+			if resp.Code != errCodeUnsupportedMediaType || !slices.Equal(resp.Supported, supportedContentTypes(tt.path)) {
+				t.Errorf("unexpected 415 body: %+v", resp)
+			}
+		})
+	}
+}
+
+// TestStrictJSON tests that unknown and mistyped fields are rejected by name
+func TestStrictJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		code  string
+		field string
+		msg   string
+	}{
+		{"unknown field", `{"sent_at": "2024-01-15T10:00:00Z", "upload_tme": 1000}`, errCodeUnknownField, "upload_tme", `unknown field "upload_tme"`},
+		{"wrong type", `{"sent_at": "2024-01-15T10:00:00Z", "upload_time": "1s"}`, errCodeInvalidFieldType, "upload_time", "upload_time must be an integer, got string"},
+		{"trailing data", `{"sent_at": "2024-01-15T10:00:00Z", "upload_time": 1000} {}`, errCodeInvalidJSON, "", "invalid JSON"},
+		{"syntax", `{"sent_at": `, errCodeInvalidJSON, "", "invalid JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupTestServer().Router()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", rr.Code)
+			}
+			var resp ProblemDetails
+			_ = json.NewDecoder(rr.Body).Decode(&resp)
+			if resp.Code != tt.code || resp.Field != tt.field || resp.Detail != tt.msg {
+				t.Errorf("expected %s/%q/%q, got %+v", tt.code, tt.field, tt.msg, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API directly.
+type CORSConfig struct {
+	AllowedOrigins []string // "*" allows any origin
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         time.Duration // how long browsers may cache a preflight result
+}
+
+// DefaultCORSConfig returns settings suited to a read-only dashboard: GET
+// requests carrying an API key and conditional-GET headers. No origins are
+// allowed until configured.
+func DefaultCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{apiKeyHeader, "If-None-Match", "Content-Type"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// corsExposedHeaders are response headers dashboards need to read: ETag for
+// conditional GET and Retry-After for rate limiting.
+const corsExposedHeaders = "ETag, Retry-After, X-Request-ID, traceparent"
+
+// EnableCORS allows cross-origin requests from the configured origins.
+func (s *Server) EnableCORS(cfg CORSConfig) {
+	s.cors = &cfg
+}
+
+// originAllowed reports whether the origin may make cross-origin requests.
+func (c *CORSConfig) originAllowed(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+// handleCORS adds CORS headers for allowed origins and answers preflight
+// requests itself, since browsers send them without the API key.
+// It is a no-op when CORS is not configured.
+func (s *Server) handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if s.cors == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := s.cors.originAllowed(origin)
+
+		// Preflight: an OPTIONS request announcing the real method
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if allowed {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Allow-Methods", strings.Join(s.cors.AllowedMethods, ", "))
+				h.Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowedHeaders, ", "))
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+			}
+			// Disallowed origins get no CORS headers, so the browser blocks the request
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
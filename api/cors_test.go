@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupCORSTestServer returns a server requiring an API key that allows one dashboard origin
+func setupCORSTestServer() *Server {
+	server := setupTestServer()
+	server.EnableAuth(APIKeys{"key-a": "org-a"})
+	cfg := DefaultCORSConfig()
+	cfg.AllowedOrigins = []string{"https://ops.example.com"}
+	server.EnableCORS(cfg)
+	return server
+}
+
+// TestCORS_Preflight tests that preflights are answered without an API key
+func TestCORS_Preflight(t *testing.T) {
+	router := setupCORSTestServer().Router()
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("Origin", "https://ops.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "x-api-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://ops.example.com" {
+		t.Errorf("expected allowed origin echoed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "X-API-Key, If-None-Match, Content-Type" {
+		t.Errorf("unexpected allowed headers %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("expected max age 600, got %q", got)
+	}
+}
+
+// TestCORS_DisallowedOrigin tests that unknown origins get no CORS headers
+func TestCORS_DisallowedOrigin(t *testing.T) {
+	router := setupCORSTestServer().Router()
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no allowed origin, got %q", got)
+	}
+}
+
+// TestCORS_SimpleRequest tests headers on an authenticated cross-origin GET
+func TestCORS_SimpleRequest(t *testing.T) {
+	server := setupCORSTestServer()
+	server.backend().(*Store).devices["device-1"].Org = "org-a"
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("Origin", "https://ops.example.com")
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent && rr.Code != http.StatusOK {
+		t.Fatalf("expected success, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://ops.example.com" {
+		t.Errorf("expected allowed origin echoed, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != corsExposedHeaders {
+		t.Errorf("expected exposed headers %q, got %q", corsExposedHeaders, got)
+	}
+}
+
+// TestCORS_Disabled tests that no CORS headers are added by default
+func TestCORS_Disabled(t *testing.T) {
+	router := setupTestServer().Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("Origin", "https://ops.example.com")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no CORS headers, got %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// GET /api/v1/devices/{device_id} returns the aggregates the derived stats
+// are computed from, alongside the inputs of the uptime formula, so an
+// uptime that looks wrong can be checked by hand.
+
+// DeviceCountersResponse is a device's raw aggregates.
+type DeviceCountersResponse struct {
+	DeviceID         string    `json:"device_id"`
+	Org              string    `json:"org,omitempty"`
+	Lifecycle        string    `json:"lifecycle"`
+	ActivatedAt      time.Time `json:"activated_at,omitzero"`
+	DecommissionedAt time.Time `json:"decommissioned_at,omitzero"`
+
+	HeartbeatCount      int64     `json:"heartbeat_count"`
+	CoveredMinutes      int64     `json:"covered_minutes"`      // distinct minutes with a heartbeat
+	CoalescedHeartbeats int64     `json:"coalesced_heartbeats"` // received in a minute already counted
+	ReceivedHeartbeats  int64     `json:"received_heartbeats"`  // heartbeat_count plus coalesced_heartbeats
+	FirstHeartbeat      time.Time `json:"first_heartbeat,omitzero"`
+	LastHeartbeat       time.Time `json:"last_heartbeat,omitzero"`
+
+	// Where the latest HTTP or UDP heartbeat came from, and when
+	LastHeartbeatIP   string    `json:"last_heartbeat_ip,omitempty"`
+	LastHeartbeatIPAt time.Time `json:"last_heartbeat_ip_at,omitzero"`
+
+	HeartbeatGaps    int64    `json:"heartbeat_gaps"`
+	MissedHeartbeats int64    `json:"missed_heartbeats"`
+	JitterGaps       int64    `json:"jitter_gaps"`
+	JitterSum        Duration `json:"jitter_sum"`
+
+	// Delays from sent_at to receipt of live heartbeats
+	LatencyCount int64    `json:"latency_count"`
+	LatencySum   Duration `json:"latency_sum"`
+
+	// Configured, detected (zero until enough gaps are seen) and used
+	ConfiguredInterval      Duration `json:"configured_interval"`
+	DetectedInterval        Duration `json:"detected_interval"`
+	HeartbeatInterval       Duration `json:"heartbeat_interval"`
+	HeartbeatIntervalSource string   `json:"heartbeat_interval_source"`
+
+	// Uptime is observed_heartbeats / expected_heartbeats * 100, capped
+	// at 100; omitted without heartbeats
+	UptimeWindowStart  time.Time `json:"uptime_window_start,omitzero"`
+	UptimeWindow       *Duration `json:"uptime_window,omitempty"`       // excludes maintenance
+	ObservedHeartbeats *int64    `json:"observed_heartbeats,omitempty"` // covered_minutes, or heartbeat_count below a one-minute interval
+	ExpectedHeartbeats *float64  `json:"expected_heartbeats,omitempty"`
+	Uptime             *float64  `json:"uptime,omitempty"`
+
+	UploadCount    int64    `json:"upload_count"`
+	UploadTimeSum  Duration `json:"upload_time_sum"`
+	MinUploadTime  Duration `json:"min_upload_time"`
+	MaxUploadTime  Duration `json:"max_upload_time"`
+	LastUploadTime Duration `json:"last_upload_time"`
+
+	// Expected upload cadence, zero without one, and the windows it missed
+	UploadInterval      Duration  `json:"upload_interval"`
+	LastUploadAt        time.Time `json:"last_upload_at,omitzero"`
+	MissedUploadWindows int64     `json:"missed_upload_windows"` // between uploads; excludes the current gap
+
+	// Moves between organizations, oldest first; omitted if never moved
+	Transfers []TransferResponse `json:"transfers,omitempty"`
+}
+
+// HandleGetDevice processes GET /api/v1/devices/{device_id}
+func (s *Server) HandleGetDevice(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s", deviceID)
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format.precision = exactPrecision // raw counters are never rounded
+
+	device, err := s.visibleDevice(r, deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	resp := DeviceCountersResponse{
+		DeviceID:         device.ID,
+		Org:              device.Org,
+		Lifecycle:        device.Lifecycle(time.Now()),
+		ActivatedAt:      device.ActivatedAt,
+		DecommissionedAt: device.DecommissionedAt,
+
+		HeartbeatCount:      device.HeartbeatCount,
+		CoveredMinutes:      device.CoveredMinutes,
+		CoalescedHeartbeats: device.CoalescedHeartbeats,
+		ReceivedHeartbeats:  device.HeartbeatCount + device.CoalescedHeartbeats,
+		FirstHeartbeat:      device.FirstHeartbeat,
+		LastHeartbeat:       device.LastHeartbeat,
+
+		LastHeartbeatIP:   device.SourceIP,
+		LastHeartbeatIPAt: device.SourceIPAt,
+
+		HeartbeatGaps:    device.HeartbeatGaps,
+		MissedHeartbeats: device.MissedHeartbeats,
+		JitterGaps:       device.JitterGaps,
+		JitterSum:        format.duration(device.JitterSum),
+
+		LatencyCount: device.LatencyCount,
+		LatencySum:   format.duration(device.LatencySum),
+
+		ConfiguredInterval:      format.duration(device.HeartbeatInterval),
+		DetectedInterval:        format.duration(device.detectedInterval),
+		HeartbeatInterval:       format.duration(device.EffectiveInterval()),
+		HeartbeatIntervalSource: device.IntervalSource(),
+
+		UploadCount:    device.UploadCount,
+		UploadTimeSum:  format.duration(device.UploadTimeSum),
+		MinUploadTime:  format.duration(device.MinUploadTime),
+		MaxUploadTime:  format.duration(device.MaxUploadTime),
+		LastUploadTime: format.duration(device.LastUploadTime),
+
+		UploadInterval:      format.duration(device.UploadInterval),
+		LastUploadAt:        device.LastUploadAt,
+		MissedUploadWindows: device.MissedUploadWindows,
+	}
+	if device.HeartbeatCount > 0 {
+		start, window, expected := device.uptimeWindow()
+		uptime := device.Stats().Uptime
+		observed := device.observedHeartbeats()
+		windowDuration := format.duration(window)
+		resp.UptimeWindowStart = start
+		resp.UptimeWindow = &windowDuration
+		resp.ObservedHeartbeats = &observed
+		resp.ExpectedHeartbeats = &expected
+		resp.Uptime = &uptime
+	}
+	for _, transfer := range device.Transfers {
+		resp.Transfers = append(resp.Transfers, newTransferResponse(transfer, format))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHandleGetDevice tests reading a device's raw aggregates and the
+// inputs of its uptime
+func TestHandleGetDevice(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, minute := range []int{0, 1, 2, 4} {
+		server.store.RecordHeartbeat(t.Context(), "device-1", start.Add(time.Duration(minute)*time.Minute))
+	}
+	server.store.RecordUploadStat(t.Context(), "device-1", 2*time.Second)
+	server.store.RecordUploadStat(t.Context(), "device-1", 4*time.Second)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1?format=seconds", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		HeartbeatCount     int64     `json:"heartbeat_count"`
+		FirstHeartbeat     time.Time `json:"first_heartbeat"`
+		LastHeartbeat      time.Time `json:"last_heartbeat"`
+		MissedHeartbeats   int64     `json:"missed_heartbeats"`
+		UptimeWindow       float64   `json:"uptime_window"`
+		ExpectedHeartbeats float64   `json:"expected_heartbeats"`
+		Uptime             float64   `json:"uptime"`
+		UploadCount        int64     `json:"upload_count"`
+		UploadTimeSum      float64   `json:"upload_time_sum"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.HeartbeatCount != 4 || !resp.FirstHeartbeat.Equal(start) || !resp.LastHeartbeat.Equal(start.Add(4*time.Minute)) || resp.MissedHeartbeats != 1 {
+		t.Errorf("unexpected heartbeat counters %+v", resp)
+	}
+	// 4 of the 5 heartbeats expected over 4 minutes
+	if resp.UptimeWindow != 240 || resp.ExpectedHeartbeats != 5 || resp.Uptime != 80 {
+		t.Errorf("unexpected uptime inputs %+v", resp)
+	}
+	if resp.UploadCount != 2 || resp.UploadTimeSum != 6 {
+		t.Errorf("unexpected upload counters %+v", resp)
+	}
+
+	// Without heartbeats the uptime inputs are omitted
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-2", nil))
+	var raw map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&raw)
+	if _, ok := raw["expected_heartbeats"]; rr.Code != http.StatusOK || ok || raw["heartbeat_count"] != 0.0 {
+		t.Errorf("unexpected response for a silent device %d %v", rr.Code, raw)
+	}
+
+	for _, path := range []string{"/api/v1/devices/device-9", "/api/v1/devices/device-1/unknown"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Uptime counts the distinct minutes a device heartbeated in rather than its
+// heartbeats, so retries and duplicates within a minute can't inflate it.
+// Each hourly history bucket carries a bitmap of its minutes, which bounds
+// the bitmap to the history's 30 days. A heartbeat older than that can't be
+// checked for duplicates and is counted as covering a new minute.
+//
+// Devices beating faster than once a minute would cover every minute even
+// with half their heartbeats missing, so their uptime still counts
+// heartbeats.
+//
+// A device that loses its uplink buffers heartbeats and flushes the backlog
+// in a burst when it reconnects, often with retries of ones already
+// delivered. For devices beating at most once a minute, a heartbeat in a
+// minute already counted is coalesced at ingest: it's counted as received,
+// but not toward the heartbeat count, hourly history or the gaps behind
+// interval detection and network quality, where a burst of near-zero gaps
+// would read as a device beating many times a minute.
+
+// minuteBit returns the bit for t's minute in its hour's bitmap.
+func minuteBit(t time.Time) uint64 {
+	return 1 << t.UTC().Minute()
+}
+
+// coveredMinutes returns how many of the bucket's minutes saw a heartbeat.
+func (b HistoryBucket) coveredMinutes() int64 {
+	return int64(bits.OnesCount64(b.Minutes))
+}
+
+// observedHeartbeats returns the heartbeats uptime counts for a device with
+// the given interval, out of count heartbeats received in covered distinct
+// minutes.
+func observedHeartbeats(count, covered int64, interval time.Duration) int64 {
+	if interval < time.Minute {
+		return count
+	}
+	return covered
+}
+
+// coalesceHeartbeatLocked counts a heartbeat of a device beating at most
+// once a minute as coalesced if its minute in history bucket b already saw
+// one, reporting whether it did. A coalesced heartbeat only moves the last
+// heartbeat forward. b is nil for heartbeats older than the history, which
+// are never coalesced.
+// Callers must hold s.mu for writing.
+func coalesceHeartbeatLocked(device *DeviceStats, b *HistoryBucket, sentAt time.Time) bool {
+	if b == nil || b.Minutes&minuteBit(sentAt) == 0 || device.EffectiveInterval() < time.Minute {
+		return false
+	}
+	device.CoalescedHeartbeats++
+	device.LastHeartbeat = maxTime(device.LastHeartbeat, sentAt)
+	return true
+}
+
+// recordCoverageLocked marks the heartbeat's minute in its history bucket
+// b, counting it toward the device's covered minutes if it is new. b is nil
+// for heartbeats older than the history.
+// Callers must hold s.mu for writing.
+func recordCoverageLocked(device *DeviceStats, b *HistoryBucket, sentAt time.Time) {
+	if b == nil {
+		device.CoveredMinutes++
+		return
+	}
+	if bit := minuteBit(sentAt); b.Minutes&bit == 0 {
+		b.Minutes |= bit
+		device.CoveredMinutes++
+	}
+}
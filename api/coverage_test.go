@@ -0,0 +1,105 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// allMinutes is a bucket bitmap with every minute of the hour covered.
+const allMinutes = 1<<60 - 1
+
+// TestStats_DuplicateHeartbeats tests that repeated heartbeats within a minute don't inflate uptime
+func TestStats_DuplicateHeartbeats(t *testing.T) {
+	s := NewStore()
+	// Configured, since retries a second apart would be detected as the cadence
+	s.devices["cam"] = &DeviceStats{ID: "cam", HeartbeatInterval: time.Minute}
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+
+	// Minutes 0-10 less 3, 4 and 5, each sent three times by a retrying agent
+	for i := 0; i <= 10; i++ {
+		if i >= 3 && i <= 5 {
+			continue
+		}
+		for retry := range 3 {
+			s.RecordHeartbeat(t.Context(), "cam", start.Add(time.Duration(i)*time.Minute+time.Duration(retry)*time.Second))
+		}
+	}
+
+	device, _ := s.Device(t.Context(), "cam")
+	if device.HeartbeatCount != 8 || device.CoalescedHeartbeats != 16 || device.CoveredMinutes != 8 {
+		t.Fatalf("expected 8 heartbeats covering 8 minutes and 16 coalesced, got %d, %d and %d",
+			device.HeartbeatCount, device.CoveredMinutes, device.CoalescedHeartbeats)
+	}
+	// 8 of the 11 minutes covered; counting heartbeats would report 100%
+	if want := 8.0 / 11 * 100; device.Stats().Uptime < want-0.5 || device.Stats().Uptime > want+0.5 {
+		t.Errorf("expected uptime ~%.1f%%, got %.1f%%", want, device.Stats().Uptime)
+	}
+
+	buckets, _, _ := s.History(t.Context(), "cam", start, start.Add(time.Hour))
+	points := buildHistoryPoints(buckets, start, start.Add(time.Hour), time.Hour, time.Minute, nil, formatGo)
+	if points[0].HeartbeatCount != 8 || points[0].Uptime != 8.0/60*100 {
+		t.Errorf("expected the history point to count covered minutes, got %+v", points[0])
+	}
+}
+
+// TestStats_CoverageBeyondHistory tests that heartbeats older than the history still count
+func TestStats_CoverageBeyondHistory(t *testing.T) {
+	s := NewStore()
+	s.devices["cam"] = &DeviceStats{ID: "cam"}
+	now := time.Now().UTC()
+	s.RecordHeartbeat(t.Context(), "cam", now)
+	old := now.Add(-historyBucketSize * historyBuckets)
+	s.RecordHeartbeat(t.Context(), "cam", old)
+	s.RecordHeartbeat(t.Context(), "cam", old)
+
+	// Too old to check for duplicates, so both are counted
+	if device, _ := s.Device(t.Context(), "cam"); device.CoveredMinutes != 3 {
+		t.Errorf("expected 3 covered minutes, got %d", device.CoveredMinutes)
+	}
+}
+
+// TestObservedHeartbeats tests that sub-minute devices count heartbeats rather than minutes
+func TestObservedHeartbeats(t *testing.T) {
+	if got := observedHeartbeats(120, 60, 30*time.Second); got != 120 {
+		t.Errorf("expected heartbeats counted at 30s, got %d", got)
+	}
+	if got := observedHeartbeats(120, 60, time.Minute); got != 60 {
+		t.Errorf("expected minutes counted at 1m, got %d", got)
+	}
+}
+
+// TestHeartbeat_BurstCoalesced tests that a flushed backlog repeating
+// counted minutes is coalesced, leaving gaps and the cadence alone
+func TestHeartbeat_BurstCoalesced(t *testing.T) {
+	s := NewStore()
+	s.devices["cam"] = &DeviceStats{ID: "cam"}
+	s.devices["fast"] = &DeviceStats{ID: "fast", HeartbeatInterval: 10 * time.Second}
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+
+	// Minutes 0-5 live, then the same minutes replayed in a burst
+	for i := range 6 {
+		s.RecordHeartbeat(t.Context(), "cam", start.Add(time.Duration(i)*time.Minute))
+	}
+	for i := range 6 {
+		s.RecordHeartbeat(t.Context(), "cam", start.Add(time.Duration(i)*time.Minute+30*time.Second))
+	}
+	device, _ := s.Device(t.Context(), "cam")
+	if device.HeartbeatCount != 6 || device.CoalescedHeartbeats != 6 || device.HeartbeatGaps != 5 {
+		t.Errorf("expected 6 counted, 6 coalesced and 5 gaps, got %d, %d and %d",
+			device.HeartbeatCount, device.CoalescedHeartbeats, device.HeartbeatGaps)
+	}
+	if want := start.Add(5*time.Minute + 30*time.Second); !device.LastHeartbeat.Equal(want) {
+		t.Errorf("expected the last heartbeat moved to %v, got %v", want, device.LastHeartbeat)
+	}
+	if device.EffectiveInterval() != time.Minute {
+		t.Errorf("expected a 1m cadence, got %v", device.EffectiveInterval())
+	}
+
+	// Devices beating faster than once a minute share minutes by design
+	for i := range 6 {
+		s.RecordHeartbeat(t.Context(), "fast", start.Add(time.Duration(i)*10*time.Second))
+	}
+	if device, _ := s.Device(t.Context(), "fast"); device.HeartbeatCount != 6 || device.CoalescedHeartbeats != 0 {
+		t.Errorf("expected sub-minute heartbeats all counted, got %d and %d coalesced", device.HeartbeatCount, device.CoalescedHeartbeats)
+	}
+}
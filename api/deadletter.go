@@ -0,0 +1,352 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rejected telemetry (unknown device, decommissioned device, bad JSON or a
+// failed validation) is kept in a capped dead-letter queue instead of being
+// dropped, so firmware bugs show up as inspectable payloads rather than gaps.
+// Entries can be replayed once the cause is fixed, e.g. after registering a
+// missing device or relaxing a limit. The queue is saved with snapshots.
+
+// DefaultDeadLetterCapacity bounds how many rejected payloads are kept.
+const DefaultDeadLetterCapacity = 1000
+
+// DeadLetter is one rejected telemetry payload.
+type DeadLetter struct {
+	ID         int64     `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Org        string    `json:"org,omitempty"` // caller's organization
+	DeviceID   string    `json:"device_id"`
+	Type       string    `json:"type"` // "heartbeat" or "upload"; empty if the payload couldn't be parsed
+	Payload    string    `json:"payload"`
+	Reason     string    `json:"reason"`
+	Code       string    `json:"code,omitempty"`
+}
+
+// deadLetterQueue keeps the most recent rejected payloads, dropping the
+// oldest once full. It has its own lock so rejections never contend with
+// telemetry writes.
+type deadLetterQueue struct {
+	mu       sync.Mutex
+	capacity int          // protected by mu; zero disables the queue
+	entries  []DeadLetter // protected by mu; oldest (lowest ID) first
+	nextID   int64        // protected by mu
+	dropped  int64        // protected by mu; evicted to make room
+}
+
+func newDeadLetterQueue(capacity int) *deadLetterQueue {
+	return &deadLetterQueue{capacity: capacity, nextID: 1}
+}
+
+// add stores a rejected payload, evicting the oldest entry if full.
+func (q *deadLetterQueue) add(dl DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.capacity <= 0 {
+		return
+	}
+	if len(q.entries) >= q.capacity {
+		evict := len(q.entries) - q.capacity + 1
+		q.entries = append(q.entries[:0], q.entries[evict:]...)
+		q.dropped += int64(evict)
+	}
+	dl.ID = q.nextID
+	q.nextID++
+	q.entries = append(q.entries, dl)
+}
+
+// list returns copies of the entries visible to org, oldest first. An empty
+// org or deviceID matches everything.
+func (q *deadLetterQueue) list(org, deviceID string) ([]DeadLetter, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var result []DeadLetter
+	for _, dl := range q.entries {
+		if (org == "" || dl.Org == org) && (deviceID == "" || dl.DeviceID == deviceID) {
+			result = append(result, dl)
+		}
+	}
+	return result, q.dropped
+}
+
+// get returns the entry with the given ID if it is visible to org.
+func (q *deadLetterQueue) get(org string, id int64) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, dl := range q.entries {
+		if dl.ID == id && (org == "" || dl.Org == org) {
+			return dl, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// update replaces an entry's rejection reason after a failed replay.
+func (q *deadLetterQueue) update(id int64, reason, code string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.entries {
+		if q.entries[i].ID == id {
+			q.entries[i].Reason, q.entries[i].Code = reason, code
+			return
+		}
+	}
+}
+
+// remove deletes the entry with the given ID if it is visible to org.
+func (q *deadLetterQueue) remove(org string, id int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, dl := range q.entries {
+		if dl.ID == id && (org == "" || dl.Org == org) {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// restore replaces the queue's contents with saved entries, keeping the most
+// recent ones if there are more than the queue holds.
+func (q *deadLetterQueue) restore(entries []DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(entries) > q.capacity {
+		entries = entries[len(entries)-q.capacity:]
+	}
+	q.entries = append([]DeadLetter(nil), entries...)
+	for _, dl := range q.entries {
+		q.nextID = max(q.nextID, dl.ID+1)
+	}
+}
+
+// setCapacity sets how many rejected payloads are kept; zero
+// disables the dead-letter queue. Existing entries beyond the new capacity
+// are dropped, oldest first.
+func (q *deadLetterQueue) setCapacity(capacity int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.capacity = capacity
+	if extra := len(q.entries) - max(capacity, 0); extra > 0 {
+		q.entries = append(q.entries[:0], q.entries[extra:]...)
+		q.dropped += int64(extra)
+	}
+}
+
+// droppedCount returns how many entries have been evicted to make room.
+func (q *deadLetterQueue) droppedCount() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// SetDeadLetterCapacity sets how many rejected payloads are kept; zero
+// disables the dead-letter queue.
+func (s *Server) SetDeadLetterCapacity(capacity int) {
+	s.deadLetters.setCapacity(capacity)
+}
+
+// deadLetter keeps a rejected telemetry payload. Transient failures (a full
+// write queue, a cancelled request, a failing store) are not dead-lettered:
+// the client is told to retry, and the payload itself was fine.
+func (s *Server) deadLetter(r *http.Request, deviceID, kind string, payload []byte, err error) {
+	if !refused(err) || r.Context().Err() != nil {
+		return
+	}
+	dl := DeadLetter{
+		ReceivedAt: time.Now().UTC(),
+		Org:        orgFromContext(r.Context()),
+		DeviceID:   deviceID,
+		Type:       kind,
+		Payload:    string(payload),
+		Reason:     err.Error(),
+		Code:       validationCode(err),
+	}
+	s.deadLetters.add(dl)
+}
+
+// refused reports whether err rejects the payload itself, rather than being
+// a transient failure worth retrying.
+func refused(err error) bool {
+	var verr *validationError
+	return errors.As(err, &verr)
+}
+
+// readTelemetryBody reads a telemetry request body, up to the size of one
+// ingest line, so it can be both decoded and dead-lettered.
+func readTelemetryBody(r *http.Request) []byte {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxIngestLineSize))
+	return body
+}
+
+// replayDeadLetter re-submits a dead letter as the caller. The payload is
+// decoded as an ingest record, whose fields match the heartbeat and upload
+// request bodies, with the device and type taken from the dead letter.
+func (s *Server) replayDeadLetter(r *http.Request, dl DeadLetter) error {
+	var rec IngestRecord
+	if err := decodeJSON([]byte(dl.Payload), &rec); err != nil {
+		return err
+	}
+	rec.DeviceID, rec.Type = dl.DeviceID, dl.Type
+	return s.applyRecord(r, rec)
+}
+
+// DeadLetterListResponse is one page of dead letters, oldest first.
+type DeadLetterListResponse struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+	Dropped     int64        `json:"dropped"` // evicted because the queue was full
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
+
+// ReplayResponse reports the outcome of replaying dead letters.
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+}
+
+// HandleListDeadLetters processes GET /api/v1/deadletter
+func (s *Server) HandleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/deadletter")
+
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	entries, dropped := s.deadLetters.list(orgFromContext(r.Context()), r.URL.Query().Get("device_id"))
+	entries, next := paginate(entries, deadLetterKey, page)
+	if entries == nil {
+		entries = []DeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, DeadLetterListResponse{DeadLetters: entries, Dropped: dropped, NextCursor: next})
+}
+
+// deadLetterKey orders dead letters by ID; zero padding keeps string order numeric.
+func deadLetterKey(dl DeadLetter) string {
+	return fmt.Sprintf("%020d", dl.ID)
+}
+
+// HandleReplayDeadLetters processes POST /api/v1/deadletter/replay, replaying
+// every dead letter visible to the caller, optionally for one device_id.
+// Replayed entries are removed; failures stay queued with their new reason.
+func (s *Server) HandleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/deadletter/replay")
+
+	org := orgFromContext(r.Context())
+	entries, _ := s.deadLetters.list(org, r.URL.Query().Get("device_id"))
+
+	var resp ReplayResponse
+	for _, dl := range entries {
+		if err := s.replayDeadLetter(r, dl); err != nil {
+			if !refused(err) || r.Context().Err() != nil {
+				break // transient; leave the rest queued for another attempt
+			}
+			s.deadLetters.update(dl.ID, err.Error(), validationCode(err))
+			resp.Failed++
+			continue
+		}
+		s.deadLetters.remove(org, dl.ID)
+		resp.Replayed++
+	}
+
+	log.Printf("[INFO] Replayed %d dead letters, %d failed", resp.Replayed, resp.Failed)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleDeadLetter processes POST /api/v1/deadletter/{id}/replay and
+// DELETE /api/v1/deadletter/{id}
+func (s *Server) HandleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/deadletter/")
+	idStr, action, _ := strings.Cut(rest, "/")
+	log.Printf("[REQUEST] %s /api/v1/deadletter/%s", r.Method, rest)
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+	org := orgFromContext(r.Context())
+
+	switch {
+	case r.Method == http.MethodDelete && action == "":
+		if !s.deadLetters.remove(org, id) {
+			writeError(w, http.StatusNotFound, "dead letter not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && action == "replay":
+		dl, ok := s.deadLetters.get(org, id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "dead letter not found")
+			return
+		}
+		if err := s.replayDeadLetter(r, dl); err != nil {
+			if errors.Is(err, errQueueFull) {
+				writeQueueFull(w)
+				return
+			}
+			if !refused(err) {
+				writeStorageError(w, err)
+				return
+			}
+			s.deadLetters.update(id, err.Error(), validationCode(err))
+			writeErrorResponse(w, http.StatusUnprocessableEntity, ErrorResponse{Msg: err.Error(), Code: validationCode(err)})
+			return
+		}
+		s.deadLetters.remove(org, id)
+		log.Printf("[INFO] Replayed dead letter %d for device %s", id, dl.DeviceID)
+		w.WriteHeader(s.acceptedStatus())
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validationCode returns the machine-readable code of a validation error, if any.
+func validationCode(err error) string {
+	var verr *validationError
+	if errors.As(err, &verr) {
+		return verr.code
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func listDeadLetters(t *testing.T, router http.Handler, query string) DeadLetterListResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/deadletter"+query, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp DeadLetterListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// TestDeadLetter_UnknownDeviceReplay tests that a payload for an unregistered
+// device is kept and can be replayed once the device exists
+func TestDeadLetter_UnknownDeviceReplay(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z", "firmware_version": "3.0.0"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/new-cam/heartbeat", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rr.Code)
+	}
+
+	list := listDeadLetters(t, router, "")
+	if len(list.DeadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(list.DeadLetters))
+	}
+	dl := list.DeadLetters[0]
+	if dl.DeviceID != "new-cam" || dl.Type != ingestTypeHeartbeat || dl.Reason != "device not found" || dl.Payload != body {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+
+	replayPath := "/api/v1/deadletter/" + strconv.FormatInt(dl.ID, 10) + "/replay"
+
+	// Still unknown: the replay fails and the entry stays
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, replayPath, nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", rr.Code)
+	}
+
+	server.store.AddDevice(t.Context(), DeviceStats{ID: "new-cam"})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, replayPath, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	device, _ := server.store.Device(t.Context(), "new-cam")
+	if device.HeartbeatCount != 1 || device.FirmwareVersion != "3.0.0" {
+		t.Errorf("expected replayed heartbeat recorded, got %+v", device)
+	}
+	if list := listDeadLetters(t, router, ""); len(list.DeadLetters) != 0 {
+		t.Errorf("expected replayed entry removed, got %+v", list.DeadLetters)
+	}
+}
+
+// TestDeadLetter_ValidationAndIngest tests that validation failures and
+// rejected ingest lines are kept with their error codes
+func TestDeadLetter_ValidationAndIngest(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2099-01-01T00:00:00Z", "upload_time": 1000}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", bytes.NewBufferString(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := `{"device_id": "device-2", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}
+not json
+`
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(lines))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	list := listDeadLetters(t, router, "")
+	if len(list.DeadLetters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %+v", list.DeadLetters)
+	}
+	if got := list.DeadLetters[0]; got.Code != errCodeSentAtFuture || got.Type != ingestTypeUpload {
+		t.Errorf("unexpected validation dead letter: %+v", got)
+	}
+	if got := list.DeadLetters[1]; got.Reason != "invalid JSON" || got.Payload != "not json" {
+		t.Errorf("unexpected ingest dead letter: %+v", got)
+	}
+
+	if filtered := listDeadLetters(t, router, "?device_id=device-1"); len(filtered.DeadLetters) != 1 {
+		t.Errorf("expected 1 dead letter for device-1, got %d", len(filtered.DeadLetters))
+	}
+}
+
+// TestDeadLetterQueue_Capacity tests that the oldest entries are evicted
+func TestDeadLetterQueue_Capacity(t *testing.T) {
+	q := newDeadLetterQueue(2)
+	for _, id := range []string{"a", "b", "c"} {
+		q.add(DeadLetter{DeviceID: id})
+	}
+
+	entries, dropped := q.list("", "")
+	if len(entries) != 2 || entries[0].DeviceID != "b" || entries[1].ID != 3 {
+		t.Errorf("expected entries b and c, got %+v", entries)
+	}
+	if dropped != 1 {
+		t.Errorf("expected 1 dropped, got %d", dropped)
+	}
+}
+
+// TestDeadLetter_Snapshot tests that dead letters survive a snapshot round trip
+func TestDeadLetter_Snapshot(t *testing.T) {
+	server := NewServer(NewStore(), nil)
+	server.deadLetters.add(DeadLetter{DeviceID: "ghost", Reason: "device not found"})
+
+	var buf bytes.Buffer
+	if err := server.Snapshotter(server.backend().(Snapshotter)).Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	restored := NewServer(NewStore(), nil)
+	if err := restored.Snapshotter(restored.backend().(Snapshotter)).Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	entries, _ := restored.deadLetters.list("", "")
+	if len(entries) != 1 || entries[0].DeviceID != "ghost" {
+		t.Errorf("expected ghost dead letter restored, got %+v", entries)
+	}
+	restored.deadLetters.add(DeadLetter{})
+	if entries, _ := restored.deadLetters.list("", ""); entries[1].ID != 2 {
+		t.Errorf("expected IDs to continue after restore, got %d", entries[1].ID)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestParseDevicesCSV_Token tests the optional token column
+func TestParseDevicesCSV_Token(t *testing.T) {
+	devices, err := parseDevicesCSV(strings.NewReader("device_id,token\ncam-1,t0ken\ncam-2,\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if devices[0].token != "t0ken" || devices[1].token != "" {
+		t.Errorf("unexpected tokens %q %q", devices[0].token, devices[1].token)
+	}
+}
+
+// TestDeviceToken tests that a device with a token must send it with its telemetry
+func TestDeviceToken(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-1"].token = "t0ken"
+	router := server.Router()
+
+	post := func(path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set(deviceTokenHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	heartbeat := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	upload := `{"sent_at": "2024-01-15T10:00:00Z", "upload_time": 5000000000}`
+
+	tests := []struct {
+		name, path, body, token string
+		want                    int
+		code                    string
+	}{
+		{"heartbeat", "/api/v1/devices/device-1/heartbeat", heartbeat, "t0ken", http.StatusNoContent, ""},
+		{"missing", "/api/v1/devices/device-1/heartbeat", heartbeat, "", http.StatusUnauthorized, errCodeDeviceTokenMissing},
+		{"wrong", "/api/v1/devices/device-1/heartbeat", heartbeat, "guess", http.StatusUnauthorized, errCodeDeviceTokenInvalid},
+		{"upload", "/api/v1/devices/device-1/stats", upload, "t0ken", http.StatusNoContent, ""},
+		{"upload missing", "/api/v1/devices/device-1/stats", upload, "", http.StatusUnauthorized, errCodeDeviceTokenMissing},
+		{"device without a token", "/api/v1/devices/device-2/heartbeat", heartbeat, "", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		rr := post(tt.path, tt.body, tt.token)
+		var resp ProblemDetails
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		if rr.Code != tt.want || resp.Code != tt.code {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.want, tt.code, rr.Code, resp.Code)
+		}
+	}
+
+	// Ingest lines carry no token, so the device's records are refused
+	rr := post("/api/v1/ingest", `{"type":"heartbeat","device_id":"device-1","sent_at":"2024-01-15T10:01:00Z"}`+"\n", "t0ken")
+	if !strings.Contains(rr.Body.String(), errCodeDeviceTokenRequired) {
+		t.Errorf("expected the ingest record refused, got %s", rr.Body.String())
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 1 || device.UploadCount != 1 {
+		t.Errorf("expected only the authenticated telemetry recorded, got %d heartbeats, %d uploads", device.HeartbeatCount, device.UploadCount)
+	}
+}
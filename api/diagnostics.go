@@ -0,0 +1,468 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Support needs camera logs without SSH access to the facility. A device,
+// typically told to by an upload_logs command, posts a log bundle to
+// /diagnostics, either gzipped as the body or as a file in a multipart
+// form. Bundles are kept on disk under -diagnostics-dir, one directory per
+// device, and housekeeping deletes them once they are older than the
+// retention. Each bundle is a data file and a JSON sidecar describing it,
+// written after the data, so a bundle is listed only once it's complete.
+
+// Diagnostics defaults.
+const (
+	DefaultDiagnosticsMaxSize   = 50 << 20 // bytes per bundle
+	DefaultDiagnosticsRetention = 7 * 24 * time.Hour
+	maxDiagnosticsBundles       = 20 // per device; the oldest are deleted first
+)
+
+// Bundle body types.
+const (
+	contentTypeGzip      = "application/gzip"
+	contentTypeXGzip     = "application/x-gzip"
+	contentTypeMultipart = "multipart/form-data"
+)
+
+// diagnosticsContentTypes are the body types POST /diagnostics accepts.
+var diagnosticsContentTypes = []string{contentTypeGzip, contentTypeXGzip, contentTypeMultipart}
+
+// bundleIDPattern is what bundle IDs look like: the upload time, so they
+// sort in upload order, then random hex so they can't collide.
+var bundleIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z-[0-9a-f]{8}$`)
+
+// bundleIDTimeLayout is the upload time at the start of a bundle ID.
+const bundleIDTimeLayout = "20060102T150405.000000000Z"
+
+// DiagnosticsBundle describes an uploaded bundle.
+type DiagnosticsBundle struct {
+	ID          string    `json:"id"`
+	DeviceID    string    `json:"device_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// diagnosticsStore keeps bundles on disk.
+type diagnosticsStore struct {
+	dir       string
+	maxSize   int64
+	retention time.Duration
+
+	// Serializes writes and deletions, so pruning never races an upload
+	mu sync.Mutex
+}
+
+// EnableDiagnostics accepts diagnostics bundles of up to maxSize bytes,
+// storing them under dir for retention.
+func (s *Server) EnableDiagnostics(dir string, maxSize int64, retention time.Duration) error {
+	if maxSize <= 0 || retention <= 0 {
+		return fmt.Errorf("diagnostics max size and retention must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create diagnostics directory: %w", err)
+	}
+	s.diagnostics = &diagnosticsStore{dir: dir, maxSize: maxSize, retention: retention}
+	return nil
+}
+
+// deviceDir is where a device's bundles are kept. Device IDs are encoded,
+// since they can hold characters a path can't.
+func (d *diagnosticsStore) deviceDir(deviceID string) string {
+	return filepath.Join(d.dir, base64.RawURLEncoding.EncodeToString([]byte(deviceID)))
+}
+
+// newBundleID returns an ID for a bundle uploaded at now.
+func newBundleID(now time.Time) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return now.UTC().Format(bundleIDTimeLayout) + "-" + hex.EncodeToString(b[:])
+}
+
+// bundleTime returns the upload time in a bundle ID.
+func bundleTime(id string) (time.Time, bool) {
+	t, err := time.Parse(bundleIDTimeLayout, strings.SplitN(id, "-", 2)[0])
+	return t, err == nil
+}
+
+// save writes a bundle read from r, returning its description. Once the
+// device has more than maxDiagnosticsBundles, the oldest are deleted.
+func (d *diagnosticsStore) save(deviceID, filename, contentType string, r io.Reader, now time.Time) (DiagnosticsBundle, error) {
+	dir := d.deviceDir(deviceID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return DiagnosticsBundle{}, err
+	}
+	bundle := DiagnosticsBundle{
+		ID:          newBundleID(now),
+		DeviceID:    deviceID,
+		Filename:    filename,
+		ContentType: contentType,
+		UploadedAt:  now.UTC().Truncate(time.Second),
+	}
+	bundle.ExpiresAt = bundle.UploadedAt.Add(d.retention)
+
+	// The data is complete before the sidecar lists it
+	dataPath := filepath.Join(dir, bundle.ID+".bundle")
+	err := writeFileAtomic(dataPath, func(w io.Writer) error {
+		hash := sha256.New()
+		size, err := io.Copy(w, io.TeeReader(r, hash))
+		if err == nil && size == 0 {
+			err = errEmptyBundle
+		}
+		bundle.Size, bundle.SHA256 = size, hex.EncodeToString(hash.Sum(nil))
+		return err
+	})
+	if err != nil {
+		return DiagnosticsBundle{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = writeFileAtomic(filepath.Join(dir, bundle.ID+".json"), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(bundle)
+	})
+	if err != nil {
+		_ = os.Remove(dataPath)
+		return DiagnosticsBundle{}, err
+	}
+
+	// Keep the newest maxDiagnosticsBundles
+	ids := bundleIDs(dir)
+	for _, id := range ids[:max(0, len(ids)-maxDiagnosticsBundles)] {
+		removeBundle(dir, id)
+	}
+	return bundle, nil
+}
+
+// errEmptyBundle rejects an upload with no content.
+var errEmptyBundle = errors.New("diagnostics bundle is empty")
+
+// bundleIDs returns the IDs of the complete bundles in dir, oldest first.
+func bundleIDs(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if id := strings.TrimSuffix(filepath.Base(m), ".json"); bundleIDPattern.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// removeBundle deletes a bundle's sidecar, so it's no longer listed, then
+// its data.
+func removeBundle(dir, id string) {
+	_ = os.Remove(filepath.Join(dir, id+".json"))
+	_ = os.Remove(filepath.Join(dir, id+".bundle"))
+}
+
+// list returns the device's unexpired bundles, oldest first.
+func (d *diagnosticsStore) list(deviceID string, now time.Time) []DiagnosticsBundle {
+	dir := d.deviceDir(deviceID)
+	bundles := []DiagnosticsBundle{}
+	for _, id := range bundleIDs(dir) {
+		if bundle, ok := d.readBundle(dir, id); ok && now.Before(bundle.ExpiresAt) {
+			bundles = append(bundles, bundle)
+		}
+	}
+	return bundles
+}
+
+// readBundle reads a bundle's sidecar.
+func (d *diagnosticsStore) readBundle(dir, id string) (DiagnosticsBundle, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return DiagnosticsBundle{}, false
+	}
+	var bundle DiagnosticsBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return DiagnosticsBundle{}, false
+	}
+	return bundle, true
+}
+
+// get returns an unexpired bundle and its data path.
+func (d *diagnosticsStore) get(deviceID, id string, now time.Time) (DiagnosticsBundle, string, bool) {
+	if !bundleIDPattern.MatchString(id) {
+		return DiagnosticsBundle{}, "", false
+	}
+	dir := d.deviceDir(deviceID)
+	bundle, ok := d.readBundle(dir, id)
+	if !ok || !now.Before(bundle.ExpiresAt) {
+		return DiagnosticsBundle{}, "", false
+	}
+	return bundle, filepath.Join(dir, id+".bundle"), true
+}
+
+// remove deletes a bundle, returning false if there is none with the ID.
+func (d *diagnosticsStore) remove(deviceID, id string) bool {
+	if !bundleIDPattern.MatchString(id) {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dir := d.deviceDir(deviceID)
+	if _, err := os.Stat(filepath.Join(dir, id+".json")); err != nil {
+		return false
+	}
+	removeBundle(dir, id)
+	return true
+}
+
+// prune deletes bundles uploaded more than the retention before now, along
+// with uploads abandoned mid-write, returning how many bundles it deleted.
+func (d *diagnosticsStore) prune(now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dirs, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Printf("[WARN] Failed to read diagnostics directory: %v", err)
+		return 0
+	}
+	pruned := 0
+	for _, entry := range dirs {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(d.dir, entry.Name())
+		for _, id := range bundleIDs(dir) {
+			if at, ok := bundleTime(id); ok && !now.Before(at.Add(d.retention)) {
+				removeBundle(dir, id)
+				pruned++
+			}
+		}
+		// Temporary files, and data without a sidecar, outlive their upload
+		// only if the server died mid-write
+		temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+		data, _ := filepath.Glob(filepath.Join(dir, "*.bundle"))
+		for _, path := range append(temps, data...) {
+			if _, err := os.Stat(strings.TrimSuffix(path, ".bundle") + ".json"); err == nil {
+				continue
+			}
+			if info, err := os.Stat(path); err == nil && now.Sub(info.ModTime()) > time.Hour {
+				_ = os.Remove(path)
+			}
+		}
+		_ = os.Remove(dir) // only succeeds once the device has no bundles left
+	}
+	return pruned
+}
+
+// expired reports the bundles prune would delete at now, and their size on
+// disk, without deleting them.
+func (d *diagnosticsStore) expired(now time.Time) RetentionImpact {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	impact := RetentionImpact{Policy: policyDiagnosticsRetention}
+	dirs, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Printf("[WARN] Failed to read diagnostics directory: %v", err)
+		return impact
+	}
+	for _, entry := range dirs {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(d.dir, entry.Name())
+		for _, id := range bundleIDs(dir) {
+			if at, ok := bundleTime(id); ok && !now.Before(at.Add(d.retention)) {
+				impact.DiagnosticsBundles++
+				for _, name := range []string{id + ".json", id + ".bundle"} {
+					if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+						impact.DiskBytes += info.Size()
+					}
+				}
+			}
+		}
+	}
+	return impact
+}
+
+// DiagnosticsResponse lists a device's bundles.
+type DiagnosticsResponse struct {
+	DeviceID string              `json:"device_id"`
+	Bundles  []DiagnosticsBundle `json:"bundles"` // oldest first
+}
+
+// HandleDiagnostics processes GET and POST /api/v1/devices/{device_id}/diagnostics
+func (s *Server) HandleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] %s /api/v1/devices/%s/diagnostics", r.Method, deviceID)
+
+	if s.diagnostics == nil {
+		writeError(w, http.StatusNotFound, "diagnostics uploads are not enabled")
+		return
+	}
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, DiagnosticsResponse{DeviceID: deviceID, Bundles: s.diagnostics.list(deviceID, time.Now())})
+		return
+	}
+
+	retired, err := s.store.IsDecommissioned(r.Context(), deviceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if retired {
+		writeErrorCode(w, http.StatusGone, errCodeDeviceDecommissioned, "device decommissioned")
+		return
+	}
+	if err := s.verifyDeviceToken(r, deviceID); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Rejected diagnostics token for %s: %v", deviceID, err)
+		writeErrorCode(w, http.StatusUnauthorized, validationCode(err), err.Error())
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.diagnostics.maxSize)
+	filename, contentType, body, msg := readDiagnosticsBody(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	bundle, err := s.diagnostics.save(deviceID, filename, contentType, body, time.Now())
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("diagnostics bundle must be at most %d bytes", s.diagnostics.maxSize))
+		return
+	case errors.Is(err, errEmptyBundle):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("[ERROR] Failed to store diagnostics bundle for %s: %v", deviceID, err)
+		writeError(w, http.StatusInternalServerError, "failed to store diagnostics bundle")
+		return
+	}
+	log.Printf("[INFO] Stored diagnostics bundle %s for %s (%d bytes)", bundle.ID, deviceID, bundle.Size)
+	w.Header().Set("Location", "/api/v1/devices/"+deviceID+"/diagnostics/"+bundle.ID)
+	writeJSON(w, http.StatusCreated, bundle)
+}
+
+// readDiagnosticsBody returns the bundle in a request: the body itself if
+// it's gzip, or the first file of a multipart form. It returns a message
+// for the client if there is none.
+func readDiagnosticsBody(r *http.Request) (filename, contentType string, body io.Reader, msg string) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeMultipart {
+		// Bundles without a Content-Type are taken as gzip, like JSON
+		// elsewhere, but must look like it
+		br := bufio.NewReader(r.Body)
+		if magic, err := br.Peek(2); err == nil && !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			return "", "", nil, "diagnostics bundle must be gzip compressed"
+		}
+		return "diagnostics.gz", contentTypeGzip, br, ""
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", "", nil, "invalid multipart form"
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return "", "", nil, "multipart form must contain a file"
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return filepath.Base(part.FileName()), contentType, part, ""
+	}
+}
+
+// HandleDiagnosticsBundle processes GET and DELETE
+// /api/v1/devices/{device_id}/diagnostics/{bundle_id}
+func (s *Server) HandleDiagnosticsBundle(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	_, id, _ := strings.Cut(r.URL.Path, "/diagnostics/")
+	log.Printf("[REQUEST] %s /api/v1/devices/%s/diagnostics/%s", r.Method, deviceID, id)
+
+	if s.diagnostics == nil {
+		writeError(w, http.StatusNotFound, "diagnostics uploads are not enabled")
+		return
+	}
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !s.diagnostics.remove(deviceID, id) {
+			writeError(w, http.StatusNotFound, "diagnostics bundle not found")
+			return
+		}
+		log.Printf("[INFO] Deleted diagnostics bundle %s for %s", id, deviceID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	bundle, path, ok := s.diagnostics.get(deviceID, id, time.Now())
+	if !ok {
+		writeError(w, http.StatusNotFound, "diagnostics bundle not found")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "diagnostics bundle not found")
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	w.Header().Set("Content-Type", bundle.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bundle.Filename}))
+	http.ServeContent(w, r, "", bundle.UploadedAt, f)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gzipped compresses s.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestDiagnostics tests uploading, listing, downloading and deleting bundles
+func TestDiagnostics(t *testing.T) {
+	server := setupTestServer()
+	if err := server.EnableDiagnostics(t.TempDir(), 1024, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	router := server.Router()
+
+	upload := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/diagnostics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	bundle := gzipped(t, "camera log")
+	rr := upload("application/gzip", bundle)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var gz DiagnosticsBundle
+	_ = json.NewDecoder(rr.Body).Decode(&gz)
+	if gz.Size != int64(len(bundle)) || gz.SHA256 == "" || rr.Header().Get("Location") != "/api/v1/devices/device-1/diagnostics/"+gz.ID {
+		t.Errorf("unexpected bundle %+v", gz)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("note", "after reboot")
+	fw, _ := mw.CreateFormFile("bundle", "logs.tar.gz")
+	_, _ = fw.Write([]byte("tarball"))
+	_ = mw.Close()
+	if rr := upload(mw.FormDataContentType(), form.Bytes()); rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201 for a multipart upload, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Bundles must be gzip, non-empty and within the size cap
+	for name, tc := range map[string]struct {
+		contentType string
+		body        []byte
+		code        int
+	}{
+		"not gzip":  {"application/gzip", []byte("plain text"), http.StatusBadRequest},
+		"empty":     {"application/gzip", nil, http.StatusBadRequest},
+		"too large": {"application/gzip", append([]byte{0x1f, 0x8b}, make([]byte, 2048)...), http.StatusRequestEntityTooLarge},
+		"json":      {"application/json", []byte("{}"), http.StatusUnsupportedMediaType},
+	} {
+		if rr := upload(tc.contentType, tc.body); rr.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", name, tc.code, rr.Code)
+		}
+	}
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	var resp DiagnosticsResponse
+	_ = json.NewDecoder(get(http.MethodGet, "/api/v1/devices/device-1/diagnostics").Body).Decode(&resp)
+	if len(resp.Bundles) != 2 || resp.Bundles[0].ID != gz.ID || resp.Bundles[1].Filename != "logs.tar.gz" {
+		t.Fatalf("expected both bundles oldest first, got %+v", resp.Bundles)
+	}
+
+	rr = get(http.MethodGet, "/api/v1/devices/device-1/diagnostics/"+gz.ID)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), bundle) || rr.Header().Get("Content-Type") != "application/gzip" {
+		t.Errorf("unexpected download %d %v", rr.Code, rr.Header())
+	}
+	if rr := get(http.MethodGet, "/api/v1/devices/device-2/diagnostics/"+gz.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for another device's bundle, got %d", rr.Code)
+	}
+	if rr := get(http.MethodGet, "/api/v1/devices/device-1/diagnostics/..%2F..%2Fetc"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a malformed ID, got %d", rr.Code)
+	}
+
+	if rr := get(http.MethodDelete, "/api/v1/devices/device-1/diagnostics/"+gz.ID); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if rr := get(http.MethodGet, "/api/v1/devices/device-1/diagnostics/"+gz.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after deleting, got %d", rr.Code)
+	}
+}
+
+// TestDiagnostics_Disabled tests that uploads need a diagnostics directory
+func TestDiagnostics_Disabled(t *testing.T) {
+	server := setupTestServer()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/diagnostics", bytes.NewReader(gzipped(t, "log")))
+	req.Header.Set("Content-Type", "application/gzip")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+// TestDiagnosticsStore_Prune tests that expired bundles, the oldest beyond
+// the per-device limit and abandoned uploads are deleted
+func TestDiagnosticsStore_Prune(t *testing.T) {
+	dir := t.TempDir()
+	d := &diagnosticsStore{dir: dir, maxSize: 1024, retention: time.Hour}
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	old, err := d.save("cam-1", "a.gz", contentTypeGzip, bytes.NewReader([]byte("a")), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxDiagnosticsBundles {
+		if _, err := d.save("cam-1", "b.gz", contentTypeGzip, bytes.NewReader([]byte("b")), t0.Add(time.Duration(i+1)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, ok := d.get("cam-1", old.ID, t0); ok {
+		t.Error("expected the oldest bundle beyond the limit to be deleted")
+	}
+
+	abandoned := filepath.Join(d.deviceDir("cam-1"), "x.bundle.tmp123")
+	_ = os.WriteFile(abandoned, []byte("partial"), 0o600)
+	_ = os.Chtimes(abandoned, t0, t0)
+
+	if n := d.prune(t0.Add(time.Hour + 10*time.Minute)); n != 10 {
+		t.Errorf("expected 10 bundles pruned, got %d", n)
+	}
+	if bundles := d.list("cam-1", t0.Add(time.Hour+10*time.Minute)); len(bundles) != 10 {
+		t.Errorf("expected 10 bundles left, got %d", len(bundles))
+	}
+	if _, err := os.Stat(abandoned); !os.IsNotExist(err) {
+		t.Error("expected the abandoned upload to be deleted")
+	}
+
+	d.prune(t0.Add(2 * time.Hour))
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the device directory to be removed, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Fleet distributions answer "how many cameras sit below 95% uptime?" at a
+// glance: percentiles and a histogram of one per-device metric across the
+// caller's active devices. Devices that haven't reported the metric yet are
+// counted separately rather than as zeros.
+
+// Metrics a distribution can be computed over.
+const (
+	distributionUptime        = "uptime"
+	distributionAvgUploadTime = "avg_upload_time"
+)
+
+// distributionPercentiles are reported for every metric. Low percentiles
+// matter for uptime (the worst devices), high ones for upload time.
+var distributionPercentiles = []float64{1, 5, 10, 25, 50, 75, 90, 95, 99}
+
+// Histogram bucket edges; each bucket covers [edge, next edge), and the last
+// is open-ended.
+var (
+	uptimeEdges        = []float64{0, 50, 80, 90, 95, 99, 100}
+	avgUploadTimeEdges = []time.Duration{0, time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+)
+
+// PercentileValue is one percentile of a distribution.
+type PercentileValue struct {
+	Percentile float64 `json:"percentile"`
+	Value      any     `json:"value"` // a percentage for uptime, a duration for upload time
+}
+
+// HistogramBucket counts devices whose metric falls in [Min, Max).
+type HistogramBucket struct {
+	Min   any `json:"min"`
+	Max   any `json:"max,omitempty"` // omitted for the open-ended last bucket
+	Count int `json:"count"`
+}
+
+// FleetDistributionResponse is the body of GET /api/v1/fleet/distribution.
+type FleetDistributionResponse struct {
+	Metric      string            `json:"metric"`
+	Devices     int               `json:"devices"` // devices with a value
+	NoData      int               `json:"no_data"`
+	Min         any               `json:"min,omitempty"`
+	Max         any               `json:"max,omitempty"`
+	Mean        any               `json:"mean,omitempty"`
+	Percentiles []PercentileValue `json:"percentiles"`
+	Histogram   []HistogramBucket `json:"histogram"`
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+// histogram counts sorted values into buckets starting at each edge.
+func histogram(sorted []float64, edges []float64) []int {
+	counts := make([]int, len(edges))
+	for _, v := range sorted {
+		i := sort.SearchFloat64s(edges, v)
+		if i == len(edges) || edges[i] > v {
+			i--
+		}
+		counts[max(i, 0)]++
+	}
+	return counts
+}
+
+// buildDistribution summarizes values, rendering each with value. The last
+// edge is open-ended.
+func buildDistribution(metric string, values []float64, noData int, edges []float64, value func(float64) any) FleetDistributionResponse {
+	sort.Float64s(values)
+	resp := FleetDistributionResponse{
+		Metric:      metric,
+		Devices:     len(values),
+		NoData:      noData,
+		Percentiles: []PercentileValue{},
+		Histogram:   make([]HistogramBucket, len(edges)),
+	}
+
+	for i, count := range histogram(values, edges) {
+		resp.Histogram[i] = HistogramBucket{Min: value(edges[i]), Count: count}
+		if i+1 < len(edges) {
+			resp.Histogram[i].Max = value(edges[i+1])
+		}
+	}
+	if len(values) == 0 {
+		return resp
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	resp.Min = value(values[0])
+	resp.Max = value(values[len(values)-1])
+	resp.Mean = value(sum / float64(len(values)))
+	for _, p := range distributionPercentiles {
+		resp.Percentiles = append(resp.Percentiles, PercentileValue{Percentile: p, Value: value(percentile(values, p))})
+	}
+	return resp
+}
+
+// HandleFleetDistribution processes GET /api/v1/fleet/distribution
+func (s *Server) HandleFleetDistribution(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/fleet/distribution")
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	devices, err := s.fleetDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	var values []float64
+	noData := 0
+	metric := r.URL.Query().Get("metric")
+	switch metric {
+	case distributionUptime:
+		for _, stats := range mapDevices(devices, (*DeviceStats).Stats) {
+			if stats.HasHeartbeats {
+				values = append(values, stats.Uptime)
+			} else {
+				noData++
+			}
+		}
+		writeJSON(w, http.StatusOK, buildDistribution(metric, values, noData, uptimeEdges, func(v float64) any {
+			return roundTo(v, 3)
+		}))
+	case distributionAvgUploadTime:
+		for _, stats := range mapDevices(devices, (*DeviceStats).Stats) {
+			if stats.HasUploads {
+				values = append(values, float64(stats.AvgUploadTime))
+			} else {
+				noData++
+			}
+		}
+		edges := make([]float64, len(avgUploadTimeEdges))
+		for i, d := range avgUploadTimeEdges {
+			edges[i] = float64(d)
+		}
+		writeJSON(w, http.StatusOK, buildDistribution(metric, values, noData, edges, func(v float64) any {
+			return format.duration(time.Duration(v))
+		}))
+	default:
+		writeError(w, http.StatusBadRequest, "metric must be uptime or avg_upload_time")
+	}
+}
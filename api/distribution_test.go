@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestPercentile tests nearest-rank percentiles
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for p, want := range map[float64]float64{1: 10, 10: 10, 50: 50, 95: 100, 99: 100} {
+		if got := percentile(values, p); got != want {
+			t.Errorf("p%v: expected %v, got %v", p, want, got)
+		}
+	}
+}
+
+// TestHistogram tests bucketing on edges and past the last edge
+func TestHistogram(t *testing.T) {
+	got := histogram([]float64{0, 49.9, 50, 94, 95, 99.5, 100}, uptimeEdges)
+	want := []int{2, 1, 0, 1, 1, 1, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+// TestFleetDistribution tests uptime and upload time distributions
+func TestFleetDistribution(t *testing.T) {
+	server := setupTestServer()
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	// device-1: 100% uptime; device-2: 2 of 11 expected heartbeats
+	server.store.RecordHeartbeat(t.Context(), "device-1", base)
+	server.store.RecordHeartbeat(t.Context(), "device-1", base.Add(time.Minute))
+	server.store.RecordHeartbeat(t.Context(), "device-2", base)
+	server.store.RecordHeartbeat(t.Context(), "device-2", base.Add(10*time.Minute))
+	server.store.RecordUploadStat(t.Context(), "device-1", 3*time.Second)
+	router := server.Router()
+
+	get := func(query string) (int, map[string]any) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/fleet/distribution"+query, nil))
+		var resp map[string]any
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp
+	}
+
+	code, resp := get("?metric=uptime")
+	if code != http.StatusOK || resp["devices"] != 2.0 || resp["min"] != 18.182 || resp["max"] != 100.0 {
+		t.Fatalf("unexpected uptime distribution %d %v", code, resp)
+	}
+	buckets := resp["histogram"].([]any)
+	if first, last := buckets[0].(map[string]any), buckets[len(buckets)-1].(map[string]any); first["count"] != 1.0 || last["count"] != 1.0 || last["max"] != nil {
+		t.Errorf("unexpected histogram %v", buckets)
+	}
+
+	_, resp = get("?metric=avg_upload_time&format=seconds")
+	if resp["devices"] != 1.0 || resp["no_data"] != 1.0 || resp["mean"] != 3.0 {
+		t.Errorf("unexpected upload time distribution %v", resp)
+	}
+	if p50 := resp["percentiles"].([]any)[4].(map[string]any); p50["percentile"] != 50.0 || p50["value"] != 3.0 {
+		t.Errorf("unexpected median %v", p50)
+	}
+
+	if code, _ := get("?metric=battery"); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown metric, got %d", code)
+	}
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /metrics exports how long uploads take and how far apart heartbeats
+// arrive as histograms, so Grafana can draw them as heatmaps straight from
+// the bucket series. /metrics is served without authentication, so by
+// default the histograms cover the whole fleet and name no one. With
+// SetMetricsDetail they're split by organization, and scrapers that accept
+// OpenMetrics get each bucket's latest observation as an exemplar naming
+// the device, so an outlier on the heatmap leads to the device behind it.
+// The histograms count from startup and aren't saved with snapshots.
+
+// Bucket upper bounds in seconds. Uploads run up to the hour MaxUploadTime
+// allows; heartbeat gaps run from sub-minute cadences to devices silent for a day.
+var (
+	uploadTimeBuckets   = []float64{0.5, 1, 2, 5, 10, 15, 30, 60, 120, 300, 600, 1800, 3600}
+	heartbeatGapBuckets = []float64{5, 10, 15, 30, 45, 60, 90, 120, 300, 600, 1800, 3600, 21600, 86400}
+)
+
+// Exemplar is one observation a histogram bucket points at.
+type Exemplar struct {
+	DeviceID string
+	Value    float64 // seconds
+	At       time.Time
+}
+
+// Histogram is a distribution of observations in seconds.
+type Histogram struct {
+	Bounds    []float64  // bucket upper bounds; a final +Inf bucket follows
+	Counts    []uint64   // observations per bucket, not cumulative
+	Exemplars []Exemplar // each bucket's latest observation; zero if it has none
+	Sum       float64
+	Count     uint64
+}
+
+// newHistogram returns an empty histogram over bounds.
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds:    bounds,
+		Counts:    make([]uint64, len(bounds)+1),
+		Exemplars: make([]Exemplar, len(bounds)+1),
+	}
+}
+
+// observe counts one observation by the device at the given time.
+func (h *Histogram) observe(deviceID string, seconds float64, at time.Time) {
+	i, _ := slices.BinarySearch(h.Bounds, seconds)
+	h.Counts[i]++
+	h.Exemplars[i] = Exemplar{DeviceID: deviceID, Value: seconds, At: at}
+	h.Sum += seconds
+	h.Count++
+}
+
+// clone returns a copy that shares nothing with h.
+func (h *Histogram) clone() Histogram {
+	return Histogram{
+		Bounds:    h.Bounds,
+		Counts:    slices.Clone(h.Counts),
+		Exemplars: slices.Clone(h.Exemplars),
+		Sum:       h.Sum,
+		Count:     h.Count,
+	}
+}
+
+// Distributions are the telemetry histograms, keyed by organization.
+type Distributions struct {
+	UploadTimes   map[string]Histogram
+	HeartbeatGaps map[string]Histogram
+}
+
+// telemetryDistributions holds the live histograms.
+type telemetryDistributions struct {
+	uploadTimes   map[string]*Histogram
+	heartbeatGaps map[string]*Histogram
+}
+
+// observeDistribution counts an observation in the histogram for the
+// device's organization, creating it over bounds on first use.
+func observeDistribution(histograms *map[string]*Histogram, bounds []float64, device *DeviceStats, d time.Duration) {
+	if *histograms == nil {
+		*histograms = make(map[string]*Histogram)
+	}
+	h, exists := (*histograms)[device.Org]
+	if !exists {
+		h = newHistogram(bounds)
+		(*histograms)[device.Org] = h
+	}
+	h.observe(device.ID, d.Seconds(), time.Now())
+}
+
+// observeUploadTimeLocked counts an upload in the fleet's distribution.
+// Callers must hold s.mu for writing.
+func (s *Store) observeUploadTimeLocked(device *DeviceStats, uploadTime time.Duration) {
+	observeDistribution(&s.distributions.uploadTimes, uploadTimeBuckets, device, uploadTime)
+}
+
+// observeHeartbeatGapLocked counts a heartbeat gap in the fleet's distribution.
+// Callers must hold s.mu for writing.
+func (s *Store) observeHeartbeatGapLocked(device *DeviceStats, gap time.Duration) {
+	observeDistribution(&s.distributions.heartbeatGaps, heartbeatGapBuckets, device, gap)
+}
+
+// Distributions returns a copy of the telemetry histograms.
+func (s *Store) Distributions(ctx context.Context) (Distributions, error) {
+	if err := s.rlock(ctx); err != nil {
+		return Distributions{}, err
+	}
+	defer s.mu.RUnlock()
+
+	clone := func(histograms map[string]*Histogram) map[string]Histogram {
+		copied := make(map[string]Histogram, len(histograms))
+		for org, h := range histograms {
+			copied[org] = h.clone()
+		}
+		return copied
+	}
+	return Distributions{
+		UploadTimes:   clone(s.distributions.uploadTimes),
+		HeartbeatGaps: clone(s.distributions.heartbeatGaps),
+	}, nil
+}
+
+// SetMetricsDetail splits the /metrics telemetry histograms by organization
+// and attaches device exemplars for OpenMetrics scrapers. Enable it only
+// where /metrics can't be reached by those the names would leak to.
+func (s *Server) SetMetricsDetail(enabled bool) {
+	s.metricsDetail = enabled
+}
+
+// writeDistributionMetrics writes the telemetry histograms. With detail
+// enabled they're per organization, with exemplars if openMetrics.
+func (s *Server) writeDistributionMetrics(ctx context.Context, w io.Writer, openMetrics bool) {
+	reporter, ok := s.backend().(DistributionReporter)
+	if !ok {
+		return
+	}
+	distributions, err := reporter.Distributions(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Telemetry histograms not written: %v", err)
+		return
+	}
+	uploads, gaps := distributions.UploadTimes, distributions.HeartbeatGaps
+	help := ""
+	if s.metricsDetail {
+		help = ", by organization"
+	} else {
+		uploads, gaps = fleetHistogram(uploads, uploadTimeBuckets), fleetHistogram(gaps, heartbeatGapBuckets)
+	}
+	exemplars := openMetrics && s.metricsDetail
+	writeHistogramFamily(w, "safelyyou_upload_time_seconds",
+		"Upload times reported by devices"+help+".", uploads, s.metricsDetail, exemplars)
+	writeHistogramFamily(w, "safelyyou_heartbeat_gap_seconds",
+		"Time between consecutive heartbeats of a device, less maintenance"+help+".", gaps, s.metricsDetail, exemplars)
+}
+
+// fleetHistogram sums the organizations' histograms into one, keyed by the
+// empty string, without exemplars.
+func fleetHistogram(histograms map[string]Histogram, bounds []float64) map[string]Histogram {
+	fleet := Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1), Exemplars: make([]Exemplar, len(bounds)+1)}
+	for _, h := range histograms {
+		for i, count := range h.Counts {
+			fleet.Counts[i] += count
+		}
+		fleet.Sum += h.Sum
+		fleet.Count += h.Count
+	}
+	return map[string]Histogram{"": fleet}
+}
+
+// writeHistogramFamily writes the histograms, labelled with their
+// organization if byOrg, sorted so the output is stable between scrapes.
+func writeHistogramFamily(w io.Writer, name, help string, histograms map[string]Histogram, byOrg, exemplars bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, org := range slices.Sorted(maps.Keys(histograms)) {
+		h := histograms[org]
+		labels, bucketLabels := "", ""
+		if byOrg {
+			labels = fmt.Sprintf("{org=%q}", org)
+			bucketLabels = fmt.Sprintf("org=%q,", org)
+		}
+		var cumulative uint64
+		for i := range h.Counts {
+			cumulative += h.Counts[i]
+			le := "+Inf"
+			if i < len(h.Bounds) {
+				le = strconv.FormatFloat(h.Bounds[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%sle=%q} %d", name, bucketLabels, le, cumulative)
+			if e := h.Exemplars[i]; exemplars && e.DeviceID != "" {
+				fmt.Fprintf(w, " # {device_id=%q} %g %s", exemplarLabel(e.DeviceID), e.Value,
+					strconv.FormatFloat(float64(e.At.UnixMilli())/1000, 'f', 3, 64))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.Sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+	}
+}
+
+// maxExemplarLabel is the most characters OpenMetrics allows in an
+// exemplar's label set, less the device_id name and quoting.
+const maxExemplarLabel = 128 - len(`device_id=""`)
+
+// exemplarLabel truncates a device ID to fit an exemplar.
+func exemplarLabel(deviceID string) string {
+	if len(deviceID) <= maxExemplarLabel {
+		return deviceID
+	}
+	return strings.ToValidUTF8(deviceID[:maxExemplarLabel], "")
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDistributions tests that upload times and heartbeat gaps are counted
+// per organization
+func TestDistributions(t *testing.T) {
+	s := NewStore()
+	s.devices["cam-1"] = &DeviceStats{ID: "cam-1", Org: "acme"}
+	s.devices["cam-2"] = &DeviceStats{ID: "cam-2", Org: "globex"}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "cam-1", t1)
+	s.RecordHeartbeat(t.Context(), "cam-1", t1.Add(time.Minute))
+	s.RecordHeartbeat(t.Context(), "cam-1", t1.Add(11*time.Minute))
+	s.RecordUploadStat(t.Context(), "cam-2", 3*time.Second)
+	s.RecordUploadStat(t.Context(), "cam-2", 4*time.Second)
+
+	d, err := s.Distributions(t.Context())
+	if err != nil {
+		t.Fatalf("Distributions failed: %v", err)
+	}
+	gaps := d.HeartbeatGaps["acme"]
+	if gaps.Count != 2 || gaps.Sum != 660 {
+		t.Errorf("expected 2 gaps totalling 660s, got %d totalling %v", gaps.Count, gaps.Sum)
+	}
+	uploads := d.UploadTimes["globex"]
+	if uploads.Count != 2 || uploads.Counts[3] != 2 || uploads.Exemplars[3].DeviceID != "cam-2" || uploads.Exemplars[3].Value != 4 {
+		t.Errorf("unexpected upload histogram %+v", uploads)
+	}
+	if _, exists := d.UploadTimes["acme"]; exists {
+		t.Error("expected no upload histogram for acme")
+	}
+}
+
+// TestMetrics_OpenMetrics tests that scrapers accepting OpenMetrics get
+// exemplars and the OpenMetrics counter naming, and others don't
+func TestMetrics_OpenMetrics(t *testing.T) {
+	server := setupTestServer()
+	server.SetMetricsDetail(true)
+	server.store.RecordUploadStat(t.Context(), "device-1", 3*time.Second)
+	router := server.Router()
+
+	scrape := func(accept string) (string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Header().Get("Content-Type"), rr.Body.String()
+	}
+
+	ct, out := scrape("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	if !strings.HasPrefix(ct, "application/openmetrics-text") || !strings.HasSuffix(out, "# EOF\n") {
+		t.Fatalf("expected OpenMetrics, got %q:\n%s", ct, out)
+	}
+	for _, line := range []string{
+		`# TYPE safelyyou_http_requests counter`,
+		`# TYPE safelyyou_evictions counter`,
+		`safelyyou_upload_time_seconds_bucket{org="",le="2"} 0`,
+		`safelyyou_upload_time_seconds_bucket{org="",le="5"} 1 # {device_id="device-1"} 3 `,
+		`safelyyou_upload_time_seconds_count{org=""} 1`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	ct, out = scrape("text/plain")
+	if !strings.HasPrefix(ct, "text/plain") || strings.Contains(out, "device-1") || strings.Contains(out, "# EOF") {
+		t.Errorf("expected the Prometheus format without exemplars, got %q:\n%s", ct, out)
+	}
+	if !strings.Contains(out, `safelyyou_upload_time_seconds_bucket{org="",le="5"} 1`+"\n") {
+		t.Errorf("missing upload histogram in:\n%s", out)
+	}
+}
+
+// TestMetrics_NoDetail tests that by default the unauthenticated /metrics
+// sums the telemetry histograms over the fleet and names no device or
+// organization, even to OpenMetrics scrapers
+func TestMetrics_NoDetail(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	store.devices["device-1"].Org = "acme"
+	store.devices["device-2"].Org = "globex"
+	server.store.RecordUploadStat(t.Context(), "device-1", 3*time.Second)
+	server.store.RecordUploadStat(t.Context(), "device-2", 20*time.Second)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	out := rr.Body.String()
+
+	for _, leak := range []string{"device-1", "device-2", "acme", "globex", "org="} {
+		if strings.Contains(out, leak) {
+			t.Errorf("expected no %q in:\n%s", leak, out)
+		}
+	}
+	for _, line := range []string{
+		`safelyyou_upload_time_seconds_bucket{le="5"} 1` + "\n",
+		`safelyyou_upload_time_seconds_bucket{le="30"} 2` + "\n",
+		`safelyyou_upload_time_seconds_sum 23` + "\n",
+		`safelyyou_upload_time_seconds_count 2` + "\n",
+		`safelyyou_heartbeat_gap_seconds_count 0` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package api is the device monitoring API: the Store holding device
+// statistics, the Server with its HTTP handlers, and the background jobs
+// (offline monitor, reports, snapshots, leader election) around them.
+//
+// The safelyyou command is only flags and wiring, so the API can be embedded
+// in another binary, such as a facility gateway, by mounting its Router:
+//
+//	store := api.NewStore()
+//	if err := store.LoadDevicesFromCSV("devices.csv"); err != nil { ... }
+//	server := api.NewServer(store, nil)
+//	server.EnableAuth(keys)
+//	mux.Handle("/api/v1/", server.Router())
+//
+// Dependencies are injected rather than global: the Server takes any
+// Storage, and optional features are switched on with its Enable and Set
+// methods before Router is called. The package logs through the standard
+// log package, so the embedding binary controls where logs go.
+package api
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Durations in responses default to Go syntax ("7.5s"), which non-Go
+// clients can't parse without a port of time.ParseDuration. Any endpoint
+// returning durations accepts ?format= to render them as seconds,
+// milliseconds or ISO 8601 instead, and ?precision= to round them (see
+// precision.go). Durations in request bodies stay nanoseconds.
+
+// durationUnit is the notation response durations are rendered in.
+type durationUnit int
+
+const (
+	unitGo      durationUnit = iota // "7.5s", the default
+	unitSeconds                     // 7.5
+	unitMillis                      // 7500
+	unitISO8601                     // "PT7.5S"
+)
+
+var durationUnits = map[string]durationUnit{
+	"go":      unitGo,
+	"seconds": unitSeconds,
+	"millis":  unitMillis,
+	"iso8601": unitISO8601,
+}
+
+// durationFormat is how a response renders durations, and the precision it
+// rounds them and uptime percentages to.
+type durationFormat struct {
+	unit      durationUnit
+	precision precision
+}
+
+// Exact formats in each unit.
+var (
+	formatGo      = durationFormat{unit: unitGo}
+	formatSeconds = durationFormat{unit: unitSeconds}
+	formatMillis  = durationFormat{unit: unitMillis}
+	formatISO8601 = durationFormat{unit: unitISO8601}
+)
+
+// parseDurationFormat reads the optional format and precision parameters,
+// returning a message for the client if either is invalid. Precision
+// defaults to the server's.
+func (s *Server) parseDurationFormat(r *http.Request) (durationFormat, string) {
+	p, msg := s.parsePrecision(r)
+	if msg != "" {
+		return formatGo, msg
+	}
+	format := durationFormat{precision: p}
+	if v := r.URL.Query().Get("format"); v != "" {
+		unit, ok := durationUnits[v]
+		if !ok {
+			return formatGo, "format must be one of go, seconds, millis, iso8601"
+		}
+		format.unit = unit
+	}
+	return format, ""
+}
+
+// percent rounds an uptime percentage, or a difference of them, to the
+// format's precision.
+func (f durationFormat) percent(v float64) float64 {
+	return f.precision.round(v)
+}
+
+// Duration is a duration in a response, rendered in the client's format.
+type Duration struct {
+	time.Duration
+	format durationFormat
+}
+
+// duration wraps d for a response in format f.
+func (f durationFormat) duration(d time.Duration) Duration {
+	return Duration{Duration: d, format: f}
+}
+
+// optionalDuration is like duration, but nil for d <= 0, so optional
+// settings that are unset are omitted.
+func (f durationFormat) optionalDuration(d time.Duration) *Duration {
+	if d <= 0 {
+		return nil
+	}
+	v := f.duration(d)
+	return &v
+}
+
+// MarshalJSON renders d in its unit. Rounding keeps the precision's
+// decimal places of the unit: of a millisecond for millis, of a second
+// otherwise.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	p := d.format.precision
+	switch d.format.unit {
+	case unitSeconds:
+		return strconv.AppendFloat(nil, p.round(d.Seconds()), 'f', -1, 64), nil
+	case unitMillis:
+		return strconv.AppendFloat(nil, p.round(float64(d.Duration)/float64(time.Millisecond)), 'f', -1, 64), nil
+	case unitISO8601:
+		return json.Marshal(iso8601Duration(p.roundDuration(d.Duration)))
+	default:
+		return json.Marshal(p.roundDuration(d.Duration).String())
+	}
+}
+
+// UnmarshalJSON reads the string formats, Go and ISO 8601. Numbers are
+// refused, since seconds and milliseconds can't be told apart.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string")
+	}
+	if v, err := time.ParseDuration(s); err == nil {
+		*d = Duration{Duration: v}
+		return nil
+	}
+	v, err := parseISO8601Duration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration{Duration: v, format: formatISO8601}
+	return nil
+}
+
+// iso8601Duration renders d as an ISO 8601 duration in hours, minutes and
+// seconds ("PT1H30M", "PT0.25S"). Days are left out since they aren't
+// always 24 hours. Negative durations get a leading minus, a common
+// extension to the standard.
+func iso8601Duration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		b.WriteString(strconv.FormatInt(int64(h), 10) + "H")
+		d -= h * time.Hour
+	}
+	if m := d / time.Minute; m > 0 {
+		b.WriteString(strconv.FormatInt(int64(m), 10) + "M")
+		d -= m * time.Minute
+	}
+	if d > 0 {
+		b.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S")
+	}
+	return b.String()
+}
+
+// parseISO8601Duration parses the output of iso8601Duration.
+func parseISO8601Duration(s string) (time.Duration, error) {
+	invalid := errors.New("invalid duration " + strconv.Quote(s))
+	sign := time.Duration(1)
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		sign, s = -1, rest
+	}
+	rest, ok := strings.CutPrefix(s, "PT")
+	if !ok || rest == "" {
+		return 0, invalid
+	}
+
+	var d time.Duration
+	for _, unit := range []struct {
+		designator string
+		size       time.Duration
+	}{{"H", time.Hour}, {"M", time.Minute}, {"S", time.Second}} {
+		value, after, found := strings.Cut(rest, unit.designator)
+		if !found {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 0 {
+			return 0, invalid
+		}
+		d += time.Duration(n * float64(unit.size))
+		rest = after
+	}
+	if rest != "" {
+		return 0, invalid
+	}
+	return sign * d, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDuration_MarshalJSON tests each response format
+func TestDuration_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		format durationFormat
+		d      time.Duration
+		want   string
+	}{
+		{formatGo, 7500 * time.Millisecond, `"7.5s"`},
+		{formatSeconds, 7500 * time.Millisecond, `7.5`},
+		{formatMillis, 7500 * time.Millisecond, `7500`},
+		{formatMillis, 1500 * time.Microsecond, `1.5`},
+		{formatISO8601, 7500 * time.Millisecond, `"PT7.5S"`},
+		{formatISO8601, 90 * time.Minute, `"PT1H30M"`},
+		{formatISO8601, 168 * time.Hour, `"PT168H"`},
+		{formatISO8601, -1500 * time.Millisecond, `"-PT1.5S"`},
+		{formatISO8601, 0, `"PT0S"`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.format.duration(tt.d))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("format %+v of %v: expected %s, got %s (%v)", tt.format, tt.d, tt.want, got, err)
+		}
+	}
+}
+
+// TestDuration_UnmarshalJSON tests reading Go and ISO 8601 strings back
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	for _, d := range []time.Duration{0, 7500 * time.Millisecond, 90*time.Minute + 250*time.Millisecond, -time.Second} {
+		for _, format := range []durationFormat{formatGo, formatISO8601} {
+			data, _ := json.Marshal(format.duration(d))
+			var got Duration
+			if err := json.Unmarshal(data, &got); err != nil || got.Duration != d {
+				t.Errorf("round trip of %s: got %v (%v)", data, got.Duration, err)
+			}
+		}
+	}
+	for _, data := range []string{`7.5`, `"P1D"`, `"PT"`, `"PT5X"`} {
+		var got Duration
+		if err := json.Unmarshal([]byte(data), &got); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
+
+// TestDurationFormat_Stats tests the format parameter on device stats
+func TestDurationFormat_Stats(t *testing.T) {
+	server := setupTestServer()
+	server.store.RecordUploadStat(t.Context(), "device-1", 7500*time.Millisecond)
+	router := server.Router()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats"+query, nil))
+		return rr
+	}
+
+	if rr := get("?format=hours"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown format, got %d", rr.Code)
+	}
+
+	var resp map[string]any
+	_ = json.NewDecoder(get("?format=millis").Body).Decode(&resp)
+	if resp["avg_upload_time"] != 7500.0 || resp["max_upload_time"] != 7500.0 {
+		t.Errorf("expected milliseconds, got %v", resp)
+	}
+	_ = json.NewDecoder(get("?format=iso8601").Body).Decode(&resp)
+	if resp["avg_upload_time"] != "PT7.5S" {
+		t.Errorf("expected ISO 8601, got %v", resp)
+	}
+}
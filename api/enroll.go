@@ -0,0 +1,296 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enrollment lets a new install register itself: the device presents a
+// one-time token handed out by an operator and receives a device ID and,
+// when authentication is enabled, an API key scoped to the token's org.
+// The device and key are written back to the device and API key CSVs, so
+// they survive restarts without manual edits.
+
+// errInvalidEnrollmentToken is returned for unknown or already used tokens.
+var errInvalidEnrollmentToken = errors.New("invalid or used enrollment token")
+
+// Enroller issues device identities in exchange for one-time tokens. The
+// token file is the source of truth and is re-read on every enrollment, so
+// tokens burned by a previous leader stay burned after a failover.
+type Enroller struct {
+	tokensPath  string
+	devicesPath string
+	keysPath    string // empty means no API keys are issued
+
+	mu sync.Mutex // serializes enrollments, including their file writes
+}
+
+// NewEnroller checks that tokensPath is a readable CSV with a "token,org"
+// header row; org may be empty when multi-tenancy is not configured.
+// Enrolled devices are appended to devicesPath and, if keysPath is set,
+// their API keys to keysPath.
+func NewEnroller(tokensPath, devicesPath, keysPath string) (*Enroller, error) {
+	if _, err := loadEnrollmentTokens(tokensPath); err != nil {
+		return nil, err
+	}
+	return &Enroller{tokensPath: tokensPath, devicesPath: devicesPath, keysPath: keysPath}, nil
+}
+
+// loadEnrollmentTokens reads unused tokens, mapped to their org.
+func loadEnrollmentTokens(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", path, err)
+		}
+	}()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]string)
+	for i := 1; i < len(records); i++ {
+		if len(records[i]) == 0 || records[i][0] == "" {
+			return nil, fmt.Errorf("line %d: expected token", i+1)
+		}
+		org := ""
+		if len(records[i]) > 1 {
+			org = records[i][1]
+		}
+		tokens[records[i][0]] = org
+	}
+	return tokens, nil
+}
+
+// Remaining returns how many tokens are still unused.
+func (e *Enroller) Remaining() (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tokens, err := loadEnrollmentTokens(e.tokensPath)
+	return len(tokens), err
+}
+
+// Enroll consumes token and registers a new device in store, returning the
+// device and its API key ("" when keys aren't issued). The token is burned
+// on disk before anything else is written, so a failure part-way through
+// never leaves a token that can be replayed.
+func (e *Enroller) Enroll(ctx context.Context, store Storage, token string) (DeviceStats, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tokens, err := loadEnrollmentTokens(e.tokensPath)
+	if err != nil {
+		return DeviceStats{}, "", fmt.Errorf("load enrollment tokens: %w", err)
+	}
+	org, ok := tokens[token]
+	if !ok || token == "" {
+		return DeviceStats{}, "", errInvalidEnrollmentToken
+	}
+	id, err := newDeviceID(ctx, store)
+	if err != nil {
+		return DeviceStats{}, "", fmt.Errorf("pick device ID: %w", err)
+	}
+	delete(tokens, token)
+	if err := writeFileAtomic(e.tokensPath, func(w io.Writer) error { return writeEnrollmentTokens(w, tokens) }); err != nil {
+		return DeviceStats{}, "", fmt.Errorf("save enrollment tokens: %w", err)
+	}
+
+	device := DeviceStats{ID: id, Org: org, source: e.devicesPath}
+	err = appendCSVRecord(e.devicesPath, func(header []string) ([]string, error) {
+		record := make([]string, len(header))
+		record[0] = device.ID
+		orgCol := columnIndex(header, "org")
+		if org != "" && orgCol < 0 {
+			return nil, errors.New("device CSV has no org column")
+		}
+		if orgCol >= 0 {
+			record[orgCol] = org
+		}
+		return record, nil
+	})
+	if err != nil {
+		return DeviceStats{}, "", fmt.Errorf("save device: %w", err)
+	}
+
+	var apiKey string
+	if e.keysPath != "" {
+		apiKey = randomHex(32)
+		err := appendCSVRecord(e.keysPath, func([]string) ([]string, error) {
+			return []string{apiKey, org}, nil
+		})
+		if err != nil {
+			return DeviceStats{}, "", fmt.Errorf("save API key: %w", err)
+		}
+	}
+
+	if err := store.AddDevice(ctx, device); err != nil {
+		return DeviceStats{}, "", fmt.Errorf("register device: %w", err)
+	}
+	return device, apiKey, nil
+}
+
+// tokenOrg returns the organization token enrolls a device into, without
+// using the token.
+func (e *Enroller) tokenOrg(token string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tokens, err := loadEnrollmentTokens(e.tokensPath)
+	if err != nil {
+		return "", false
+	}
+	org, ok := tokens[token]
+	return org, ok && token != ""
+}
+
+// writeEnrollmentTokens writes tokens in the format loadEnrollmentTokens reads.
+func writeEnrollmentTokens(w io.Writer, tokens map[string]string) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"token", "org"})
+	for token, org := range tokens {
+		_ = cw.Write([]string{token, org})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// appendCSVRecord rewrites a CSV file with one more record, built from the
+// file's header row.
+func appendCSVRecord(path string, build func(header []string) ([]string, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("missing header row")
+	}
+
+	record, err := build(records[0])
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		_ = cw.WriteAll(append(records, record))
+		return cw.Error()
+	})
+}
+
+// newDeviceID returns an unused ID in the MAC-style format of existing devices.
+func newDeviceID(ctx context.Context, store Storage) (string, error) {
+	for {
+		b := make([]byte, 6)
+		_, _ = rand.Read(b)
+		b[0] = b[0]&^0x01 | 0x02 // locally administered unicast, so it can't collide with real hardware
+		parts := make([]string, len(b))
+		for i := range b {
+			parts[i] = hex.EncodeToString(b[i : i+1])
+		}
+		id := strings.Join(parts, "-")
+		exists, err := store.DeviceExists(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return id, nil
+		}
+	}
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// EnrollRequest is the body of POST /api/v1/enroll.
+type EnrollRequest struct {
+	Token string `json:"token"`
+}
+
+// EnrollResponse carries the credentials a newly enrolled device reports with.
+type EnrollResponse struct {
+	DeviceID string `json:"device_id"`
+	APIKey   string `json:"api_key,omitempty"` // omitted when authentication is disabled
+}
+
+// HandleEnroll processes POST /api/v1/enroll
+func (s *Server) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/enroll")
+
+	if s.enroller == nil {
+		writeError(w, http.StatusNotFound, "enrollment is not enabled")
+		return
+	}
+
+	var req EnrollRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return
+	}
+
+	// The device quota is soft: concurrent enrollments may both pass it
+	if org, ok := s.enroller.tokenOrg(req.Token); ok {
+		if limit := s.usage.quota(org).MaxDevices; limit > 0 {
+			devices, err := s.monitoredDevices(r.Context(), org)
+			if err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			if devices >= limit {
+				log.Printf("[WARN] Rejected enrollment: org %q is at its device quota of %d", org, limit)
+				writeQuotaExceeded(w, errDeviceQuota, time.Now())
+				return
+			}
+		}
+	}
+
+	device, apiKey, err := s.enroller.Enroll(r.Context(), s.store, req.Token)
+	if errors.Is(err, errInvalidEnrollmentToken) {
+		log.Printf("[WARN] Rejected enrollment with invalid token")
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Enrollment failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "enrollment failed")
+		return
+	}
+	if apiKey != "" {
+		s.addAPIKey(apiKey, device.Org)
+	}
+
+	log.Printf("[INFO] Enrolled device %s (org %q)", device.ID, device.Org)
+	s.notifyRequestWebhooks(r.Context(), WebhookRegistration, device.ID, time.Now())
+	writeJSON(w, http.StatusCreated, EnrollResponse{DeviceID: device.ID, APIKey: apiKey})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// setupEnrollTestServer returns a server with auth enabled and an enroller
+// backed by files in a temp directory.
+func setupEnrollTestServer(t *testing.T, devicesCSV string) (*Server, string) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tokensPath := write("tokens.csv", "token,org\ntok-1,acme\ntok-2,acme\n")
+	devicesPath := write("devices.csv", devicesCSV)
+	keysPath := write("api_keys.csv", "key,org\nadmin-key,acme\n")
+
+	enroller, err := NewEnroller(tokensPath, devicesPath, keysPath)
+	if err != nil {
+		t.Fatalf("NewEnroller failed: %v", err)
+	}
+	server := NewServer(NewStore(), nil)
+	server.EnableAuth(APIKeys{"admin-key": "acme"})
+	server.EnableEnrollment(enroller)
+	return server, dir
+}
+
+func enroll(router http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/enroll", strings.NewReader(`{"token": "`+token+`"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestEnroll_Success tests that a token yields a usable device ID and API key
+func TestEnroll_Success(t *testing.T) {
+	server, dir := setupEnrollTestServer(t, "device_id,org\nexisting,acme\n")
+	router := server.Router()
+
+	rr := enroll(router, "tok-1")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp EnrollResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !regexp.MustCompile(`^([0-9a-f]{2}-){5}[0-9a-f]{2}$`).MatchString(resp.DeviceID) {
+		t.Errorf("expected MAC-style device ID, got %q", resp.DeviceID)
+	}
+	if resp.APIKey == "" {
+		t.Fatal("expected an API key")
+	}
+
+	// The new key works for the new device
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/"+resp.DeviceID+"/heartbeat", bytes.NewBufferString(body))
+	req.Header.Set(apiKeyHeader, resp.APIKey)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected heartbeat status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The device, key and burned token are persisted
+	store := NewStore()
+	if err := store.LoadDevicesFromCSV(t.Context(), filepath.Join(dir, "devices.csv")); err != nil {
+		t.Fatalf("failed to reload devices: %v", err)
+	}
+	if org, err := store.DeviceOrg(t.Context(), resp.DeviceID); err != nil || org != "acme" {
+		t.Errorf("expected persisted device in acme, got %q (%v)", org, err)
+	}
+	keys, err := LoadAPIKeysFromCSV(filepath.Join(dir, "api_keys.csv"))
+	if err != nil {
+		t.Fatalf("failed to reload API keys: %v", err)
+	}
+	if keys[resp.APIKey] != "acme" || keys["admin-key"] != "acme" {
+		t.Errorf("expected both keys persisted, got %v", keys)
+	}
+	if remaining, _ := server.enroller.Remaining(); remaining != 1 {
+		t.Errorf("expected 1 token left, got %d", remaining)
+	}
+}
+
+// TestEnroll_TokenIsOneTime tests that a token can't be reused
+func TestEnroll_TokenIsOneTime(t *testing.T) {
+	server, _ := setupEnrollTestServer(t, "device_id,org\n")
+	router := server.Router()
+
+	if rr := enroll(router, "tok-1"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", rr.Code)
+	}
+	if rr := enroll(router, "tok-1"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 on reuse, got %d", rr.Code)
+	}
+	if rr := enroll(router, "bogus"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for unknown token, got %d", rr.Code)
+	}
+	if got := deviceCount(t, server.store); got != 1 {
+		t.Errorf("expected 1 enrolled device, got %d", got)
+	}
+}
+
+// TestEnroll_MissingOrgColumn tests that a device CSV that can't record the
+// org fails enrollment rather than dropping the device's tenancy
+func TestEnroll_MissingOrgColumn(t *testing.T) {
+	server, _ := setupEnrollTestServer(t, "device_id\n")
+	router := server.Router()
+
+	if rr := enroll(router, "tok-1"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rr.Code)
+	}
+	if got := deviceCount(t, server.store); got != 0 {
+		t.Errorf("expected no device registered, got %d", got)
+	}
+}
+
+// TestEnroll_Disabled tests the response when enrollment isn't configured
+func TestEnroll_Disabled(t *testing.T) {
+	server := setupTestServer()
+
+	if rr := enroll(server.Router(), "tok-1"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
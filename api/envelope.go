@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Some legacy consumers expect every JSON response wrapped as
+// {"data": ..., "error": null}, with errors as {"data": null, "error": ...}.
+// SetResponseEnvelope turns the envelope on for every request, and the
+// X-Response-Envelope header turns it on or off for one. It's applied in
+// writeJSON and writeErrorResponse, so every handler honours it; the status
+// code is unchanged. Streams such as NDJSON ingest results and CSV exports
+// aren't single JSON values and are never enveloped.
+
+// envelopeHeader turns the envelope on ("true") or off ("false") for a request.
+const envelopeHeader = "X-Response-Envelope"
+
+// Envelope wraps a response body for consumers that expect one. Exactly one
+// of Data and Error is set.
+type Envelope struct {
+	Data  any `json:"data"`
+	Error any `json:"error"` // problem details, or the legacy error shape
+}
+
+// SetResponseEnvelope makes JSON responses enveloped unless a request sends
+// X-Response-Envelope: false.
+func (s *Server) SetResponseEnvelope(enabled bool) {
+	s.envelope = enabled
+}
+
+// wantsEnvelope reports whether responses to r are enveloped. A header that
+// isn't a boolean is ignored.
+func (s *Server) wantsEnvelope(r *http.Request) bool {
+	if enabled, err := strconv.ParseBool(r.Header.Get(envelopeHeader)); err == nil {
+		return enabled
+	}
+	return s.envelope
+}
+
+// envelopeFor returns data as the body written to w: in an envelope if the
+// request asked for one, otherwise as is.
+func envelopeFor(w http.ResponseWriter, data any) any {
+	if format := responseFormat(w); format != nil && format.envelope {
+		return Envelope{Data: data}
+	}
+	return data
+}
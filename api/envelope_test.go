@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getEnvelope sends a GET with the given X-Response-Envelope header, if any.
+func getEnvelope(router http.Handler, path, header string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != "" {
+		req.Header.Set(envelopeHeader, header)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestResponseEnvelope tests enveloping responses and errors per request
+func TestResponseEnvelope(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+
+	rr := getEnvelope(router, "/api/v2/devices/device-1/stats", "true")
+	var ok struct {
+		Data  StatsV2Response `json:"data"`
+		Error *ProblemDetails `json:"error"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&ok)
+	if rr.Code != http.StatusOK || ok.Data.DeviceID != "device-1" || ok.Error != nil {
+		t.Errorf("expected enveloped stats, got %d %+v", rr.Code, ok)
+	}
+
+	rr = getEnvelope(router, "/api/v1/devices/nope/stats", "true")
+	var failed struct {
+		Data  *StatsResponse  `json:"data"`
+		Error *ProblemDetails `json:"error"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&failed)
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != "application/json" ||
+		failed.Data != nil || failed.Error == nil || failed.Error.Code != errCodeDeviceNotFound {
+		t.Errorf("expected an enveloped 404, got %d %+v", rr.Code, failed)
+	}
+
+	// Enveloped and bare bodies differ, so their ETags must too
+	bare := getEnvelope(router, "/api/v2/devices/device-1/stats", "")
+	enveloped := getEnvelope(router, "/api/v2/devices/device-1/stats", "1")
+	if bare.Header().Get("ETag") == enveloped.Header().Get("ETag") || bare.Header().Get("Vary") != envelopeHeader {
+		t.Errorf("expected distinct ETags varying on %s, got %q and %q (Vary %q)", envelopeHeader,
+			bare.Header().Get("ETag"), enveloped.Header().Get("ETag"), bare.Header().Get("Vary"))
+	}
+}
+
+// TestResponseEnvelope_Global tests enveloping by default, with a per-request opt-out and legacy errors
+func TestResponseEnvelope_Global(t *testing.T) {
+	server := setupTestServer()
+	server.SetResponseEnvelope(true)
+	server.SetLegacyErrors(true)
+	router := server.Router()
+
+	var body map[string]any
+	_ = json.NewDecoder(getEnvelope(router, "/api/v1/devices/nope/stats", "").Body).Decode(&body)
+	want := map[string]any{"msg": "device not found", "code": errCodeDeviceNotFound}
+	if errBody, _ := body["error"].(map[string]any); body["data"] != nil || len(errBody) != 2 ||
+		errBody["msg"] != want["msg"] || errBody["code"] != want["code"] {
+		t.Errorf("expected the legacy error in an envelope, got %v", body)
+	}
+
+	body = nil
+	_ = json.NewDecoder(getEnvelope(router, "/api/v1/devices/nope/stats", "false").Body).Decode(&body)
+	if body["msg"] != "device not found" || body["data"] != nil {
+		t.Errorf("expected the bare legacy error when opted out, got %v", body)
+	}
+}
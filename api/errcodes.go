@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Every error response carries a machine-readable code alongside detail, so
+// clients can branch on the failure without parsing messages. Specific
+// failures have their own codes; anything else gets the generic code for its
+// HTTP status. The catalog below is served at GET /api/v1/errors and is the
+// reference for what each code means; codes are never renamed or reused.
+
+// Error codes for request bodies the server can't decode.
+const (
+	errCodeUnsupportedMediaType = "ERR_UNSUPPORTED_MEDIA_TYPE"
+	errCodeInvalidJSON          = "ERR_INVALID_JSON"
+	errCodeUnknownField         = "ERR_UNKNOWN_FIELD"
+	errCodeInvalidFieldType     = "ERR_INVALID_FIELD_TYPE"
+)
+
+// Error codes for telemetry that decodes but fails validation. Rejected
+// sent_at values have their own codes so device teams can tell a drifting
+// clock from a replayed backlog.
+const (
+	errCodeSentAtRequired         = "ERR_SENT_AT_REQUIRED"
+	errCodeSentAtFuture           = "ERR_SENT_AT_FUTURE"
+	errCodeSentAtTooOld           = "ERR_SENT_AT_TOO_OLD"
+	errCodeUploadTimeRange        = "ERR_UPLOAD_TIME_RANGE"
+	errCodeHeartbeatIntervalRange = "ERR_HEARTBEAT_INTERVAL_RANGE"
+	errCodeUploadIntervalRange    = "ERR_UPLOAD_INTERVAL_RANGE"
+	errCodeVersionTooLong         = "ERR_VERSION_TOO_LONG"
+	errCodeVitalsRange            = "ERR_VITALS_RANGE"
+	errCodeLabelTooLong           = "ERR_LABEL_TOO_LONG"
+	errCodeIngestType             = "ERR_INGEST_TYPE"
+	errCodeActivatedAtRange       = "ERR_ACTIVATED_AT_RANGE"
+	errCodeTransferredAtRange     = "ERR_TRANSFERRED_AT_RANGE"
+	errCodeValidation             = "ERR_VALIDATION"
+)
+
+// Error codes for telemetry the device may not send.
+const (
+	errCodeDeviceNotFound       = "ERR_DEVICE_NOT_FOUND"
+	errCodeDeviceDecommissioned = "ERR_DEVICE_DECOMMISSIONED"
+	errCodeSignatureMissing     = "ERR_SIGNATURE_MISSING"
+	errCodeSignatureInvalid     = "ERR_SIGNATURE_INVALID"
+	errCodeSignatureRequired    = "ERR_SIGNATURE_REQUIRED"
+	errCodeDeviceTokenMissing   = "ERR_DEVICE_TOKEN_MISSING"
+	errCodeDeviceTokenInvalid   = "ERR_DEVICE_TOKEN_INVALID"
+	errCodeDeviceTokenRequired  = "ERR_DEVICE_TOKEN_REQUIRED"
+)
+
+// Error codes for valid ingest lines left unapplied with the rest of
+// their atomic batch, and for a batch over the size it may be.
+const (
+	errCodeBatchAborted  = "ERR_BATCH_ABORTED"
+	errCodeBatchTooLarge = "ERR_BATCH_TOO_LARGE"
+)
+
+// Error codes for lifecycle changes the device's state doesn't allow.
+const (
+	errCodeLifecycleTransition = "ERR_LIFECYCLE_TRANSITION"
+)
+
+// Error codes for requests the server can't serve right now.
+const (
+	errCodeQueueFull          = "ERR_QUEUE_FULL"
+	errCodeStandby            = "ERR_STANDBY"
+	errCodeLoading            = "ERR_LOADING"
+	errCodeTimeout            = "ERR_TIMEOUT"
+	errCodeConcurrencyLimit   = "ERR_CONCURRENCY_LIMIT"
+	errCodeServerConfig       = "ERR_SERVER_CONFIG"
+	errCodeStorageUnavailable = "ERR_STORAGE_UNAVAILABLE"
+)
+
+// Error codes for organizations over their quota.
+const (
+	errCodeQuotaExceeded = "ERR_QUOTA_EXCEEDED"
+)
+
+// statusErrorCodes are the generic codes for errors without a specific one.
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:          "ERR_BAD_REQUEST",
+	http.StatusUnauthorized:        "ERR_UNAUTHORIZED",
+	http.StatusForbidden:           "ERR_FORBIDDEN",
+	http.StatusNotFound:            "ERR_NOT_FOUND",
+	http.StatusMethodNotAllowed:    "ERR_METHOD_NOT_ALLOWED",
+	http.StatusConflict:            "ERR_CONFLICT",
+	http.StatusUnprocessableEntity: "ERR_UNPROCESSABLE",
+	http.StatusTooManyRequests:     "ERR_RATE_LIMITED",
+	http.StatusInternalServerError: "ERR_INTERNAL",
+	http.StatusServiceUnavailable:  "ERR_UNAVAILABLE",
+}
+
+// ErrorCode documents one machine-readable error code.
+type ErrorCode struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"` // HTTP status the code is returned with
+	Description string `json:"description"`
+}
+
+// errorCatalog lists every code the API returns.
+var errorCatalog = []ErrorCode{
+	{errCodeUnsupportedMediaType, http.StatusUnsupportedMediaType, "The request Content-Type isn't accepted by the endpoint; supported lists the accepted types."},
+	{errCodeInvalidJSON, http.StatusBadRequest, "The request body isn't valid JSON."},
+	{errCodeUnknownField, http.StatusBadRequest, "The request body has a field the endpoint doesn't accept; field names it."},
+	{errCodeInvalidFieldType, http.StatusBadRequest, "A request field has the wrong JSON type; field names it."},
+	{errCodeSentAtRequired, http.StatusBadRequest, "A heartbeat has no sent_at."},
+	{errCodeSentAtFuture, http.StatusBadRequest, "sent_at is further ahead of server time than the allowed clock skew."},
+	{errCodeSentAtTooOld, http.StatusBadRequest, "sent_at is older than the server accepts."},
+	{errCodeUploadTimeRange, http.StatusBadRequest, "upload_time is not positive or exceeds the maximum."},
+	{errCodeHeartbeatIntervalRange, http.StatusBadRequest, "heartbeat_interval is negative or exceeds the maximum."},
+	{errCodeUploadIntervalRange, http.StatusBadRequest, "upload_interval is negative or exceeds the maximum."},
+	{errCodeVersionTooLong, http.StatusBadRequest, "firmware_version or agent_version exceeds the maximum length."},
+	{errCodeVitalsRange, http.StatusBadRequest, "battery_pct, temperature_c or disk_free_bytes is out of range."},
+	{errCodeLabelTooLong, http.StatusBadRequest, "upload_id or file_type exceeds the maximum length."},
+	{errCodeIngestType, http.StatusBadRequest, "An ingest record's type is not heartbeat or upload."},
+	{errCodeActivatedAtRange, http.StatusBadRequest, "activated_at falls between the device's first and last counted heartbeats."},
+	{errCodeTransferredAtRange, http.StatusBadRequest, "A transfer's transferred_at is in the future, or restarts aggregates before the device's last counted heartbeat."},
+	{errCodeValidation, http.StatusBadRequest, "The request failed validation for another reason; see detail."},
+	{errCodeDeviceNotFound, http.StatusNotFound, "The device isn't registered, or belongs to another organization."},
+	{errCodeDeviceDecommissioned, http.StatusGone, "The device is decommissioned and accepts no new telemetry."},
+	{errCodeSignatureMissing, http.StatusUnauthorized, "The device signs its payloads but the request has no X-Signature header."},
+	{errCodeSignatureInvalid, http.StatusUnauthorized, "The X-Signature header doesn't match the payload."},
+	{errCodeSignatureRequired, http.StatusBadRequest, "The device signs its payloads, so its records can't be sent through ingest or replay."},
+	{errCodeDeviceTokenMissing, http.StatusUnauthorized, "The device has a token but the request has no X-Device-Token header."},
+	{errCodeDeviceTokenInvalid, http.StatusUnauthorized, "The X-Device-Token header doesn't match the device's token."},
+	{errCodeDeviceTokenRequired, http.StatusBadRequest, "The device has a token, so its records can't be sent through ingest or replay."},
+	{errCodeBatchAborted, http.StatusConflict, "An atomic ingest line was valid but not applied, because another line was rejected or the batch failed to commit."},
+	{errCodeBatchTooLarge, http.StatusRequestEntityTooLarge, "An atomic ingest batch has more lines or bytes than a batch may, and none of it was applied."},
+	{errCodeLifecycleTransition, http.StatusConflict, "The device's lifecycle state doesn't allow the change, e.g. activating a retired device."},
+	{errCodeQueueFull, http.StatusServiceUnavailable, "The write queue is full; retry after the Retry-After delay."},
+	{errCodeStandby, http.StatusServiceUnavailable, "This instance is a standby; send requests to the leader."},
+	{errCodeLoading, http.StatusServiceUnavailable, "The server is still loading its device registry; retry after the Retry-After delay."},
+	{errCodeTimeout, http.StatusServiceUnavailable, "The request didn't finish before the server's handler timeout."},
+	{errCodeConcurrencyLimit, http.StatusServiceUnavailable, "Too many requests to the endpoint are running at once; retry after the Retry-After delay."},
+	{errCodeServerConfig, http.StatusInternalServerError, "The server failed to load its configuration."},
+	{errCodeStorageUnavailable, http.StatusServiceUnavailable, "The storage backend failed to read or write; retry after the Retry-After delay."},
+	{errCodeQuotaExceeded, http.StatusTooManyRequests, "The organization used its daily request quota, or enrolling, transferring, reloading or importing devices would exceed its device quota."},
+	{statusErrorCodes[http.StatusBadRequest], http.StatusBadRequest, "The request is malformed, e.g. a bad query parameter."},
+	{statusErrorCodes[http.StatusUnauthorized], http.StatusUnauthorized, "The API key or enrollment token is missing or invalid."},
+	{statusErrorCodes[http.StatusForbidden], http.StatusForbidden, "The API key isn't allowed to perform the request."},
+	{statusErrorCodes[http.StatusNotFound], http.StatusNotFound, "The requested resource doesn't exist."},
+	{statusErrorCodes[http.StatusMethodNotAllowed], http.StatusMethodNotAllowed, "The resource doesn't support the request method; the Allow header lists the methods it does."},
+	{statusErrorCodes[http.StatusConflict], http.StatusConflict, "The resource already exists."},
+	{statusErrorCodes[http.StatusUnprocessableEntity], http.StatusUnprocessableEntity, "The request is well-formed but can't be applied; see detail."},
+	{statusErrorCodes[http.StatusTooManyRequests], http.StatusTooManyRequests, "The caller exceeded the rate limit."},
+	{statusErrorCodes[http.StatusInternalServerError], http.StatusInternalServerError, "The server failed unexpectedly."},
+	{statusErrorCodes[http.StatusServiceUnavailable], http.StatusServiceUnavailable, "The server can't handle the request right now."},
+}
+
+// writeErrorCode writes an error response with a machine-readable code,
+// falling back to the status's generic code when code is empty.
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	if code == "" {
+		code = statusErrorCodes[status]
+	}
+	writeErrorResponse(w, status, ErrorResponse{Msg: msg, Code: code})
+}
+
+// writeConfigError writes the 500 returned while the server's configuration
+// failed to load.
+func writeConfigError(w http.ResponseWriter, err error) {
+	writeErrorCode(w, http.StatusInternalServerError, errCodeServerConfig, "server configuration error: "+err.Error())
+}
+
+// writeStorageError writes the 503 for a store call that failed. A request
+// whose context ended gets nothing: the timeout middleware answers one that
+// timed out, and a canceled client isn't listening.
+func writeStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		log.Printf("[WARN] Storage call abandoned: %v", err)
+		return
+	}
+	log.Printf("[ERROR] Storage call failed: %v", err)
+	w.Header().Set("Retry-After", "1")
+	writeErrorCode(w, http.StatusServiceUnavailable, errCodeStorageUnavailable, "storage unavailable")
+}
+
+// ErrorCatalogResponse lists the API's error codes.
+type ErrorCatalogResponse struct {
+	Errors []ErrorCode `json:"errors"`
+}
+
+// HandleErrorCodes processes GET /api/v1/errors
+func (s *Server) HandleErrorCodes(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/errors")
+	writeJSON(w, http.StatusOK, ErrorCatalogResponse{Errors: errorCatalog})
+}
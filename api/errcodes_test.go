@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestErrorCatalog tests that codes are unique and every generic code is documented
+func TestErrorCatalog(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, ec := range errorCatalog {
+		if seen[ec.Code] {
+			t.Errorf("duplicate code %s", ec.Code)
+		}
+		seen[ec.Code] = true
+	}
+	for status, code := range statusErrorCodes {
+		if !seen[code] {
+			t.Errorf("generic code %s for %d missing from catalog", code, status)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	setupTestServer().Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/errors", nil))
+	var resp ErrorCatalogResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK || len(resp.Errors) != len(errorCatalog) {
+		t.Errorf("unexpected catalog response %d %+v (%v)", rr.Code, resp, err)
+	}
+}
+
+// TestErrorCodes tests the codes returned for common failures
+func TestErrorCodes(t *testing.T) {
+	server := setupTestServer()
+	server.store.Decommission(t.Context(), "device-2", time.Now())
+	router := server.Router()
+	sentAt := time.Now().UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"unknown device", http.MethodGet, "/api/v1/devices/nope/stats", "", http.StatusNotFound, errCodeDeviceNotFound, ""},
+		{"decommissioned", http.MethodPost, "/api/v1/devices/device-2/heartbeat", `{"sent_at": "` + sentAt + `"}`, http.StatusGone, errCodeDeviceDecommissioned, ""},
+		{"future sent_at", http.MethodPost, "/api/v1/devices/device-1/heartbeat", `{"sent_at": "2999-01-01T00:00:00Z"}`, http.StatusBadRequest, errCodeSentAtFuture, "sent_at"},
+		{"missing sent_at", http.MethodPost, "/api/v1/devices/device-1/heartbeat", `{}`, http.StatusBadRequest, errCodeSentAtRequired, "sent_at"},
+		{"upload time", http.MethodPost, "/api/v1/devices/device-1/stats", `{"upload_time": -1}`, http.StatusBadRequest, errCodeUploadTimeRange, "upload_time"},
+		{"battery", http.MethodPost, "/api/v1/devices/device-1/heartbeat", `{"sent_at": "` + sentAt + `", "battery_pct": 120}`, http.StatusBadRequest, errCodeVitalsRange, "battery_pct"},
+		{"bad query", http.MethodGet, "/api/v1/devices?limit=x", "", http.StatusBadRequest, "ERR_BAD_REQUEST", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			var resp ProblemDetails
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if rr.Code != tt.status || resp.Code != tt.code || resp.Field != tt.field {
+				t.Errorf("expected %d %s %q, got %d %+v", tt.status, tt.code, tt.field, rr.Code, resp)
+			}
+		})
+	}
+}
+
+// TestValidationCode tests that plain errors fall back to no code
+func TestValidationCode(t *testing.T) {
+	if got := validationCode(ErrDeviceNotFound); got != errCodeDeviceNotFound {
+		t.Errorf("expected %s, got %q", errCodeDeviceNotFound, got)
+	}
+	if got := validationCode(errors.New("boom")); got != "" {
+		t.Errorf("expected no code, got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// statsCacheControl lets clients cache stats but makes them revalidate on
+// every poll, which the ETag turns into a cheap 304. "private" because the
+// response depends on the caller's API key.
+const statsCacheControl = "private, no-cache"
+
+// computeETag derives a strong ETag from a response value. Hashing the content
+// rather than counting writes keeps ETags correct across restarts.
+func computeETag(v any) (string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(body)
+	return fmt.Sprintf(`"%x"`, h.Sum64()), nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.
+// Weak comparison is used, as RFC 9110 requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCacheableJSON writes v with ETag and Cache-Control headers, or a bare
+// 304 Not Modified if the client already has this version.
+func writeCacheableJSON(w http.ResponseWriter, r *http.Request, v any) {
+	// The envelope changes the bytes, so it's part of the ETag
+	w.Header().Add("Vary", envelopeHeader)
+	etag, err := computeETag(envelopeFor(w, v))
+	if err != nil {
+		// Fall back to an uncached response rather than failing the request
+		writeJSON(w, http.StatusOK, v)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", statsCacheControl)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
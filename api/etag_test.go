@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestGetStats_ETag tests conditional GET with If-None-Match
+func TestGetStats_ETag(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", rr.Code, etag)
+	}
+	if rr.Header().Get("Cache-Control") != statsCacheControl {
+		t.Errorf("expected Cache-Control %q, got %q", statsCacheControl, rr.Header().Get("Cache-Control"))
+	}
+
+	// Same data: 304 with no body
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected empty body on 304, got %q", rr.Body.String())
+	}
+
+	// New telemetry changes the ETag
+	server.store.RecordUploadStat(t.Context(), "device-1", 7*time.Second)
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 after new telemetry, got %d", rr.Code)
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("ETag should change when stats change")
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{``, false},
+	}
+
+	for _, tc := range tests {
+		if got := etagMatches(tc.header, `"abc"`); got != tc.expected {
+			t.Errorf("etagMatches(%q): expected %v, got %v", tc.header, tc.expected, got)
+		}
+	}
+}
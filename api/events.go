@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Aggregates say how a device has done, not what happened to it. Each device
+// keeps a short timeline of the moments support asks about — when it was
+// registered, first heard from, went offline and came back, was moved or
+// retired — served by GET /api/v1/devices/{device_id}/events. The timeline
+// persists in snapshots and survives activation resets.
+
+// maxDeviceEvents is how many events a device keeps; older ones are dropped.
+const maxDeviceEvents = 100
+
+// Device event types.
+const (
+	eventRegistered     = "registered"
+	eventActivated      = "activated"
+	eventFirstHeartbeat = "first_heartbeat"
+	eventOffline        = "offline"
+	eventOnline         = "online"
+	eventTransferred    = "transferred"
+	eventDecommissioned = "decommissioned"
+	eventMaintenance    = "maintenance"
+)
+
+// DeviceEvent is one entry in a device's timeline.
+type DeviceEvent struct {
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// addEvent appends an event to the device's timeline, dropping the oldest
+// beyond maxDeviceEvents.
+// Callers must hold s.mu for writing.
+func (device *DeviceStats) addEvent(eventType string, at time.Time, detail string) {
+	event := DeviceEvent{Type: eventType, At: at.UTC(), Detail: detail}
+	// Copies handed out share the old backing array, so never append in place
+	events := append(slices.Clip(device.Events), event)
+	if len(events) > maxDeviceEvents {
+		events = slices.Clone(events[len(events)-maxDeviceEvents:])
+	}
+	device.Events = events
+}
+
+// RecordEvent adds an event to the device's timeline.
+func (s *Store) RecordEvent(ctx context.Context, deviceID, eventType string, at time.Time, detail string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	device.addEvent(eventType, at, detail)
+	return nil
+}
+
+// EventsResponse is the body of GET /api/v1/devices/{device_id}/events.
+type EventsResponse struct {
+	DeviceID string        `json:"device_id"`
+	Events   []DeviceEvent `json:"events"` // oldest first
+}
+
+// HandleEvents processes GET /api/v1/devices/{device_id}/events
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/events", deviceID)
+
+	device, err := s.visibleDevice(r, deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	resp := EventsResponse{DeviceID: deviceID, Events: device.Events}
+	if eventType := r.URL.Query().Get("type"); eventType != "" {
+		resp.Events = slices.DeleteFunc(slices.Clone(resp.Events), func(e DeviceEvent) bool {
+			return e.Type != eventType
+		})
+	}
+	if resp.Events == nil {
+		resp.Events = []DeviceEvent{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// eventTypes returns the types of events, in order.
+func eventTypes(events []DeviceEvent) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// TestDeviceEvents_Timeline tests that registration, the first heartbeat,
+// going offline and back, and decommissioning are recorded in order
+func TestDeviceEvents_Timeline(t *testing.T) {
+	s := NewStore()
+	s.AddDevice(t.Context(), DeviceStats{ID: "camera"})
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "camera", t1)
+	s.RecordHeartbeat(t.Context(), "camera", t1.Add(time.Minute))
+
+	m := NewOfflineMonitor(s, 5*time.Minute)
+	m.Check(t.Context(), t1.Add(time.Hour))
+	m.Check(t.Context(), t1.Add(90*time.Minute)) // still offline; not recorded again
+	s.RecordHeartbeat(t.Context(), "camera", t1.Add(2*time.Hour))
+	m.Check(t.Context(), t1.Add(2*time.Hour))
+	s.Decommission(t.Context(), "camera", t1.Add(3*time.Hour))
+	s.Decommission(t.Context(), "camera", t1.Add(4*time.Hour))
+
+	device, _ := s.Device(t.Context(), "camera")
+	want := []string{eventRegistered, eventFirstHeartbeat, eventOffline, eventOnline, eventDecommissioned}
+	if got := eventTypes(device.Events); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if offline := device.Events[2]; !offline.At.Equal(t1.Add(time.Hour)) || offline.Detail != "no heartbeat for 59m0s" {
+		t.Errorf("unexpected offline event %+v", offline)
+	}
+	if retired := device.Events[4]; !retired.At.Equal(t1.Add(3 * time.Hour)) {
+		t.Errorf("expected the original decommission time, got %v", retired.At)
+	}
+}
+
+// TestDeviceEvents_Bounded tests that only the latest events are kept
+func TestDeviceEvents_Bounded(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera"}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	copied, _ := s.Device(t.Context(), "camera")
+	for i := range maxDeviceEvents + 10 {
+		s.RecordEvent(t.Context(), "camera", eventOnline, t1.Add(time.Duration(i)*time.Minute), "")
+	}
+
+	device, _ := s.Device(t.Context(), "camera")
+	if len(device.Events) != maxDeviceEvents {
+		t.Fatalf("expected %d events, got %d", maxDeviceEvents, len(device.Events))
+	}
+	if !device.Events[0].At.Equal(t1.Add(10 * time.Minute)) {
+		t.Errorf("expected the oldest events dropped, first is at %v", device.Events[0].At)
+	}
+	if len(copied.Events) != 0 {
+		t.Errorf("expected an earlier copy unchanged, got %d events", len(copied.Events))
+	}
+}
+
+// TestHandleEvents tests the events endpoint and its type filter
+func TestHandleEvents(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	store.RecordHeartbeat(t.Context(), "device-1", t1)
+	store.Transfer(t.Context(), "device-1", "acme", t1.Add(time.Hour), transferKeep)
+	router := server.Router()
+
+	get := func(path string) (EventsResponse, int) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp EventsResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp, rr.Code
+	}
+
+	resp, code := get("/api/v1/devices/device-1/events")
+	if code != http.StatusOK || !slices.Equal(eventTypes(resp.Events), []string{eventFirstHeartbeat, eventTransferred}) {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	if detail := resp.Events[1].Detail; detail != "to acme" {
+		t.Errorf("unexpected transfer detail %q", detail)
+	}
+
+	resp, _ = get("/api/v1/devices/device-1/events?type=transferred")
+	if len(resp.Events) != 1 {
+		t.Errorf("expected one transfer event, got %+v", resp.Events)
+	}
+	resp, _ = get("/api/v1/devices/device-2/events")
+	if resp.Events == nil || len(resp.Events) != 0 {
+		t.Errorf("expected an empty list, got %+v", resp.Events)
+	}
+	if _, code := get("/api/v1/devices/unknown/events"); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+}
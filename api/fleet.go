@@ -0,0 +1,179 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Fleet-wide endpoints. These aggregate over every device visible to the
+// caller, so they always filter by the caller's organization.
+
+// unknownVersion is reported for devices that never sent a version.
+const unknownVersion = "unknown"
+
+// VersionCount is the number of devices running one version.
+type VersionCount struct {
+	Version string `json:"version"`
+	Count   int    `json:"count"`
+}
+
+// FleetVersionsResponse summarizes firmware and agent versions across the fleet.
+type FleetVersionsResponse struct {
+	TotalDevices int            `json:"total_devices"`
+	Firmware     []VersionCount `json:"firmware"`
+	Agent        []VersionCount `json:"agent"`
+}
+
+// DeviceSummary is one entry in the device list.
+type DeviceSummary struct {
+	ID              string    `json:"device_id"`
+	Org             string    `json:"org,omitempty"`
+	Facility        string    `json:"facility,omitempty"`
+	Room            string    `json:"room,omitempty"`
+	FirmwareVersion string    `json:"firmware_version,omitempty"`
+	AgentVersion    string    `json:"agent_version,omitempty"`
+	LastHeartbeat   time.Time `json:"last_heartbeat,omitzero"`
+	Decommissioned  bool      `json:"decommissioned,omitempty"`
+	Source          string    `json:"source,omitempty"`     // device CSV the device was loaded from
+	Lifecycle       string    `json:"lifecycle"`            // provisioned, active or retired
+	MutedUntil      time.Time `json:"muted_until,omitzero"` // set while offline alerts are muted
+}
+
+// DeviceListResponse is one page of devices, in ID order.
+type DeviceListResponse struct {
+	Devices    []DeviceSummary `json:"devices"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// orgDevices returns every device visible to the caller, decommissioned
+// ones included.
+func (s *Server) orgDevices(r *http.Request) ([]DeviceStats, error) {
+	all, err := s.store.ListDevices(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	org := orgFromContext(r.Context())
+	var devices []DeviceStats
+	for _, device := range all {
+		if org == "" || device.Org == org {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// fleetDevices returns the active (not decommissioned) devices visible to the caller.
+func (s *Server) fleetDevices(r *http.Request) ([]DeviceStats, error) {
+	devices, err := s.orgDevices(r)
+	return slices.DeleteFunc(devices, func(d DeviceStats) bool { return !d.DecommissionedAt.IsZero() }), err
+}
+
+// countVersions tallies versions, most common first.
+func countVersions(versions []string) []VersionCount {
+	counts := make(map[string]int)
+	for _, v := range versions {
+		if v == "" {
+			v = unknownVersion
+		}
+		counts[v]++
+	}
+
+	result := make([]VersionCount, 0, len(counts))
+	for v, n := range counts {
+		result = append(result, VersionCount{Version: v, Count: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Version < result[j].Version
+	})
+	return result
+}
+
+// HandleFleetVersions processes GET /api/v1/fleet/versions
+func (s *Server) HandleFleetVersions(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/fleet/versions")
+
+	devices, err := s.fleetDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	firmware := make([]string, len(devices))
+	agent := make([]string, len(devices))
+	for i, device := range devices {
+		firmware[i] = device.FirmwareVersion
+		agent[i] = device.AgentVersion
+	}
+
+	writeJSON(w, http.StatusOK, FleetVersionsResponse{
+		TotalDevices: len(devices),
+		Firmware:     countVersions(firmware),
+		Agent:        countVersions(agent),
+	})
+}
+
+// HandleListDevices processes GET /api/v1/devices
+func (s *Server) HandleListDevices(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/devices")
+
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Unlike fleetDevices, decommissioned devices are listed so a full
+	// iteration sees every registered device
+	devices, err := s.orgDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	devices, next := paginate(devices, func(d DeviceStats) string { return d.ID }, page)
+	resp := DeviceListResponse{Devices: make([]DeviceSummary, len(devices)), NextCursor: next}
+	for i, device := range devices {
+		resp.Devices[i] = summarizeDevice(device)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// summarizeDevice returns the device's entry in device lists.
+func summarizeDevice(device DeviceStats) DeviceSummary {
+	now := time.Now()
+	summary := DeviceSummary{
+		ID:              device.ID,
+		Org:             device.Org,
+		Facility:        device.Facility,
+		Room:            device.Room,
+		FirmwareVersion: device.FirmwareVersion,
+		AgentVersion:    device.AgentVersion,
+		LastHeartbeat:   device.LastHeartbeat,
+		Decommissioned:  !device.DecommissionedAt.IsZero(),
+		Source:          device.source,
+		Lifecycle:       device.Lifecycle(now),
+	}
+	if device.Muted(now) {
+		summary.MutedUntil = device.MutedUntil
+	}
+	return summary
+}
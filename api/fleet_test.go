@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestFleetVersions tests the version distribution across active devices
+func TestFleetVersions(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-3"] = &DeviceStats{ID: "device-3"}
+	server.backend().(*Store).devices["device-4"] = &DeviceStats{ID: "device-4"}
+	server.store.SetVersions(t.Context(), "device-1", "2.0.0", "1.1")
+	server.store.SetVersions(t.Context(), "device-2", "2.0.0", "1.1")
+	server.store.SetVersions(t.Context(), "device-3", "1.9.0", "")
+	server.store.SetVersions(t.Context(), "device-4", "1.8.0", "1.0")
+	server.store.Decommission(t.Context(), "device-4", time.Now())
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/fleet/versions", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var resp FleetVersionsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp.TotalDevices != 3 {
+		t.Errorf("expected 3 active devices, got %d", resp.TotalDevices)
+	}
+	expectedFirmware := []VersionCount{{"2.0.0", 2}, {"1.9.0", 1}}
+	if len(resp.Firmware) != len(expectedFirmware) {
+		t.Fatalf("expected firmware %v, got %v", expectedFirmware, resp.Firmware)
+	}
+	for i, vc := range expectedFirmware {
+		if resp.Firmware[i] != vc {
+			t.Errorf("firmware[%d]: expected %v, got %v", i, vc, resp.Firmware[i])
+		}
+	}
+	expectedAgent := []VersionCount{{"1.1", 2}, {unknownVersion, 1}}
+	for i, vc := range expectedAgent {
+		if i >= len(resp.Agent) || resp.Agent[i] != vc {
+			t.Errorf("agent: expected %v, got %v", expectedAgent, resp.Agent)
+			break
+		}
+	}
+}
+
+// TestFleetVersions_OrgScoped tests that only the caller's org is summarized
+func TestFleetVersions_OrgScoped(t *testing.T) {
+	server := setupAuthTestServer()
+	server.store.SetVersions(t.Context(), "device-a", "2.0.0", "")
+	server.store.SetVersions(t.Context(), "device-b", "1.0.0", "")
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/fleet/versions", nil)
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var resp FleetVersionsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp.TotalDevices != 1 || len(resp.Firmware) != 1 || resp.Firmware[0].Version != "2.0.0" {
+		t.Errorf("expected only org-a's device, got %+v", resp)
+	}
+}
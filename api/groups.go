@@ -0,0 +1,535 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Device groups let a set of devices (e.g. "3rd floor east wing") be managed
+// as a unit: stats are aggregated per group and the offline monitor applies a
+// group's alert threshold to its members. Groups are scoped to the org that
+// created them, so two orgs may use the same group name. A group can be put
+// into maintenance, which covers its members (see maintenance.go), so
+// changing a group invalidates its members' cached stats.
+
+// maxGroupNameLength bounds group names, which appear in URLs.
+const maxGroupNameLength = 64
+
+// Group is a named set of devices.
+type Group struct {
+	Name       string
+	Org        string
+	AlertAfter time.Duration // Zero means members use their own or the monitor's threshold
+	DeviceIDs  []string      // Sorted
+}
+
+type groupKey struct {
+	org, name string
+}
+
+// CreateGroup adds a group, returning ErrGroupExists if the org already has
+// one by that name.
+func (s *Store) CreateGroup(ctx context.Context, group Group) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	key := groupKey{group.Org, group.Name}
+	if _, exists := s.groups[key]; exists {
+		return ErrGroupExists
+	}
+	s.groups[key] = normalizeGroup(group)
+	return nil
+}
+
+// ReplaceGroup overwrites an existing group's threshold and members.
+func (s *Store) ReplaceGroup(ctx context.Context, group Group) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	key := groupKey{group.Org, group.Name}
+	if _, exists := s.groups[key]; !exists {
+		return ErrGroupNotFound
+	}
+	s.groups[key] = normalizeGroup(group)
+	return nil
+}
+
+// normalizeGroup returns a copy of group with sorted, de-duplicated members.
+func normalizeGroup(group Group) *Group {
+	group.DeviceIDs = slices.Clone(group.DeviceIDs)
+	slices.Sort(group.DeviceIDs)
+	group.DeviceIDs = slices.Compact(group.DeviceIDs)
+	return &group
+}
+
+// GetGroup returns a copy of the org's group.
+func (s *Store) GetGroup(ctx context.Context, org, name string) (Group, error) {
+	if err := s.rlock(ctx); err != nil {
+		return Group{}, err
+	}
+	defer s.mu.RUnlock()
+
+	group, exists := s.groups[groupKey{org, name}]
+	if !exists {
+		return Group{}, ErrGroupNotFound
+	}
+	copied := *group
+	copied.DeviceIDs = slices.Clone(group.DeviceIDs)
+	return copied, nil
+}
+
+// ListGroups returns copies of the org's groups sorted by name.
+// An empty org lists every group.
+func (s *Store) ListGroups(ctx context.Context, org string) ([]Group, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	var groups []Group
+	for key, group := range s.groups {
+		if org != "" && key.org != org {
+			continue
+		}
+		copied := *group
+		copied.DeviceIDs = slices.Clone(group.DeviceIDs)
+		groups = append(groups, copied)
+	}
+	slices.SortFunc(groups, func(a, b Group) int {
+		return strings.Compare(a.Org+"/"+a.Name, b.Org+"/"+b.Name)
+	})
+	return groups, nil
+}
+
+// DeleteGroup removes a group. Its devices are unaffected.
+func (s *Store) DeleteGroup(ctx context.Context, org, name string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	key := groupKey{org, name}
+	if _, exists := s.groups[key]; !exists {
+		return ErrGroupNotFound
+	}
+	delete(s.groups, key)
+	return nil
+}
+
+// AddGroupMember adds a device to a group; adding an existing member is a no-op.
+func (s *Store) AddGroupMember(ctx context.Context, org, name, deviceID string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[groupKey{org, name}]
+	if !exists {
+		return ErrGroupNotFound
+	}
+	if i, found := slices.BinarySearch(group.DeviceIDs, deviceID); !found {
+		group.DeviceIDs = slices.Insert(group.DeviceIDs, i, deviceID)
+	}
+	return nil
+}
+
+// RemoveGroupMember removes a device from a group; removing a non-member is a no-op.
+func (s *Store) RemoveGroupMember(ctx context.Context, org, name, deviceID string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[groupKey{org, name}]
+	if !exists {
+		return ErrGroupNotFound
+	}
+	if i, found := slices.BinarySearch(group.DeviceIDs, deviceID); found {
+		group.DeviceIDs = slices.Delete(group.DeviceIDs, i, i+1)
+	}
+	return nil
+}
+
+// GroupAlertThresholds maps each device in a group with an alert threshold
+// to the tightest threshold among its groups.
+func (s *Store) GroupAlertThresholds(ctx context.Context) (map[string]time.Duration, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	thresholds := make(map[string]time.Duration)
+	for _, group := range s.groups {
+		if group.AlertAfter <= 0 {
+			continue
+		}
+		for _, id := range group.DeviceIDs {
+			if current, ok := thresholds[id]; !ok || group.AlertAfter < current {
+				thresholds[id] = group.AlertAfter
+			}
+		}
+	}
+	return thresholds, nil
+}
+
+// GroupRequest is the body of POST /groups and PUT /groups/{name}.
+type GroupRequest struct {
+	Name       string   `json:"name"`                  // POST only; PUT takes the name from the path
+	DeviceIDs  []string `json:"device_ids"`            // Replaces the membership on PUT
+	AlertAfter int64    `json:"alert_after,omitempty"` // nanoseconds, optional
+}
+
+// GroupResponse describes a group.
+type GroupResponse struct {
+	Name       string    `json:"name"`
+	AlertAfter *Duration `json:"alert_after,omitempty"`
+	DeviceIDs  []string  `json:"device_ids"`
+}
+
+// GroupListResponse is one page of groups, in name order.
+type GroupListResponse struct {
+	Groups     []GroupResponse `json:"groups"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// GroupStatsResponse aggregates stats across a group's active members.
+type GroupStatsResponse struct {
+	Name           string   `json:"name"`
+	DeviceCount    int      `json:"device_count"`
+	Reporting      int      `json:"reporting"`  // Members with at least one heartbeat
+	AvgUptime      float64  `json:"avg_uptime"` // Mean over reporting members
+	MinUptime      float64  `json:"min_uptime"`
+	WorstDeviceID  string   `json:"worst_device_id,omitempty"`
+	AvgUploadTime  Duration `json:"avg_upload_time"` // Weighted by upload count
+	UploadCount    int64    `json:"upload_count"`
+	Decommissioned int      `json:"decommissioned"` // Members excluded from the aggregates
+	InMaintenance  int      `json:"in_maintenance"` // Members in a maintenance window now
+}
+
+func newGroupResponse(group Group, format durationFormat) GroupResponse {
+	resp := GroupResponse{Name: group.Name, DeviceIDs: group.DeviceIDs, AlertAfter: format.optionalDuration(group.AlertAfter)}
+	if resp.DeviceIDs == nil {
+		resp.DeviceIDs = []string{}
+	}
+	return resp
+}
+
+// validGroupName reports whether name can be used as a single URL path segment.
+func validGroupName(name string) bool {
+	return name != "" && len(name) <= maxGroupNameLength && !strings.Contains(name, "/")
+}
+
+// decodeGroupRequest parses and validates a group body, checking that every
+// member is visible to the caller. It writes the error response itself.
+func (s *Server) decodeGroupRequest(w http.ResponseWriter, r *http.Request) (GroupRequest, bool) {
+	var req GroupRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return req, false
+	}
+	if req.AlertAfter < 0 {
+		writeError(w, http.StatusBadRequest, "alert_after must be positive")
+		return req, false
+	}
+	for _, id := range req.DeviceIDs {
+		err := s.checkDeviceVisible(r, id)
+		if errors.Is(err, ErrDeviceNotFound) {
+			writeErrorCode(w, http.StatusBadRequest, errCodeDeviceNotFound, "device not found: "+id)
+			return req, false
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// writeGroupError writes the response for a group operation that failed:
+// 404 when the group doesn't exist, otherwise the store's error.
+func writeGroupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrGroupNotFound) {
+		writeError(w, http.StatusNotFound, "group not found")
+		return
+	}
+	writeStorageError(w, err)
+}
+
+// HandleListGroups processes GET /api/v1/groups
+func (s *Server) HandleListGroups(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/groups")
+
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	groups, err := s.store.ListGroups(r.Context(), orgFromContext(r.Context()))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	// ListGroups sorts by org, then name
+	groups, next := paginate(groups, func(g Group) string {
+		return g.Org + "/" + g.Name
+	}, page)
+	resp := GroupListResponse{Groups: make([]GroupResponse, len(groups)), NextCursor: next}
+	for i, group := range groups {
+		resp.Groups[i] = newGroupResponse(group, format)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleCreateGroup processes POST /api/v1/groups
+func (s *Server) HandleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/groups")
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	req, ok := s.decodeGroupRequest(w, r)
+	if !ok {
+		return
+	}
+	if !validGroupName(req.Name) {
+		writeError(w, http.StatusBadRequest, "name must be 1-64 characters without '/'")
+		return
+	}
+
+	group := Group{
+		Name:       req.Name,
+		Org:        orgFromContext(r.Context()),
+		AlertAfter: time.Duration(req.AlertAfter),
+		DeviceIDs:  req.DeviceIDs,
+	}
+	err := s.store.CreateGroup(r.Context(), group)
+	if errors.Is(err, ErrGroupExists) {
+		writeError(w, http.StatusConflict, "group already exists")
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	log.Printf("[INFO] Group created: %s (%d devices)", req.Name, len(req.DeviceIDs))
+
+	created, err := s.store.GetGroup(r.Context(), group.Org, group.Name)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newGroupResponse(created, format))
+}
+
+// HandleGroup processes GET, PUT and DELETE on /api/v1/groups/{name}
+func (s *Server) HandleGroup(w http.ResponseWriter, r *http.Request, name string) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] %s /api/v1/groups/%s", r.Method, name)
+	org := orgFromContext(r.Context())
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		group, err := s.store.GetGroup(r.Context(), org, name)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newGroupResponse(group, format))
+
+	case http.MethodPut:
+		req, ok := s.decodeGroupRequest(w, r)
+		if !ok {
+			return
+		}
+		group := Group{Name: name, Org: org, AlertAfter: time.Duration(req.AlertAfter), DeviceIDs: req.DeviceIDs}
+		if err := s.store.ReplaceGroup(r.Context(), group); err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		updated, err := s.store.GetGroup(r.Context(), org, name)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newGroupResponse(updated, format))
+
+	case http.MethodDelete:
+		if err := s.store.DeleteGroup(r.Context(), org, name); err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		log.Printf("[INFO] Group deleted: %s", name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleGroupMember processes PUT and DELETE on /api/v1/groups/{name}/devices/{device_id}
+func (s *Server) HandleGroupMember(w http.ResponseWriter, r *http.Request, name, deviceID string) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] %s /api/v1/groups/%s/devices/%s", r.Method, name, deviceID)
+	org := orgFromContext(r.Context())
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		if err := s.checkDeviceVisible(r, deviceID); err != nil {
+			writeDeviceError(w, deviceID, err)
+			return
+		}
+		err = s.store.AddGroupMember(r.Context(), org, name, deviceID)
+	case http.MethodDelete:
+		err = s.store.RemoveGroupMember(r.Context(), org, name, deviceID)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGroupStats processes GET /api/v1/groups/{name}/stats
+func (s *Server) HandleGroupStats(w http.ResponseWriter, r *http.Request, name string) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/groups/%s/stats", name)
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	group, err := s.store.GetGroup(r.Context(), orgFromContext(r.Context()), name)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	resp := GroupStatsResponse{Name: group.Name, DeviceCount: len(group.DeviceIDs)}
+	now := time.Now()
+	var uptimeSum float64
+	var uploadSum time.Duration
+	for _, id := range group.DeviceIDs {
+		device, err := s.store.Device(r.Context(), id)
+		if errors.Is(err, ErrDeviceNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if !device.DecommissionedAt.IsZero() {
+			resp.Decommissioned++
+			continue
+		}
+
+		if device.maintenance.active(now) {
+			resp.InMaintenance++
+		}
+		stats := device.Stats()
+		if stats.HasHeartbeats {
+			if resp.Reporting == 0 || stats.Uptime < resp.MinUptime {
+				resp.MinUptime = stats.Uptime
+				resp.WorstDeviceID = id
+			}
+			resp.Reporting++
+			uptimeSum += stats.Uptime
+		}
+		resp.UploadCount += device.UploadCount
+		uploadSum += device.UploadTimeSum
+	}
+
+	if resp.Reporting > 0 {
+		resp.AvgUptime = format.percent(uptimeSum / float64(resp.Reporting))
+		resp.MinUptime = format.percent(resp.MinUptime)
+	}
+	var avgUpload time.Duration
+	if resp.UploadCount > 0 {
+		avgUpload = uploadSum / time.Duration(resp.UploadCount)
+	}
+	resp.AvgUploadTime = format.duration(avgUpload)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// routeGroup dispatches requests under /api/v1/groups/.
+func (s *Server) routeGroup(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/groups/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		group := func(w http.ResponseWriter, r *http.Request) { s.HandleGroup(w, r, parts[0]) }
+		methods{http.MethodGet: group, http.MethodPut: group, http.MethodDelete: group}.ServeHTTP(w, r)
+	case len(parts) == 2 && parts[1] == "stats":
+		methods{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.HandleGroupStats(w, r, parts[0]) }}.ServeHTTP(w, r)
+	case len(parts) == 2 && parts[1] == "maintenance":
+		methods{http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.HandleGroupMaintenance(w, r, parts[0]) }}.ServeHTTP(w, r)
+	case len(parts) == 3 && parts[1] == "devices" && parts[2] != "":
+		member := func(w http.ResponseWriter, r *http.Request) { s.HandleGroupMember(w, r, parts[0], parts[2]) }
+		methods{http.MethodPut: member, http.MethodDelete: member}.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
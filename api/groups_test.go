@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doGroupRequest sends a request to the router and returns the recorder.
+func doGroupRequest(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestGroups_CRUD tests creating, reading, updating and deleting a group
+func TestGroups_CRUD(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	rr := doGroupRequest(router, http.MethodPost, "/api/v1/groups", `{"name": "east-wing", "device_ids": ["device-2", "device-1"], "alert_after": 180000000000}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created GroupResponse
+	_ = json.NewDecoder(rr.Body).Decode(&created)
+	if !slices.Equal(created.DeviceIDs, []string{"device-1", "device-2"}) || created.AlertAfter == nil || created.AlertAfter.String() != "3m0s" {
+		t.Errorf("unexpected group: %+v", created)
+	}
+
+	if rr := doGroupRequest(router, http.MethodPost, "/api/v1/groups", `{"name": "east-wing"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for duplicate name, got %d", rr.Code)
+	}
+
+	rr = doGroupRequest(router, http.MethodPut, "/api/v1/groups/east-wing", `{"device_ids": ["device-1"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	group, _ := server.store.GetGroup(t.Context(), "", "east-wing")
+	if !slices.Equal(group.DeviceIDs, []string{"device-1"}) || group.AlertAfter != 0 {
+		t.Errorf("expected membership and threshold replaced, got %+v", group)
+	}
+
+	rr = doGroupRequest(router, http.MethodGet, "/api/v1/groups", "")
+	var list GroupListResponse
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Groups) != 1 || list.Groups[0].Name != "east-wing" || list.NextCursor != "" {
+		t.Errorf("expected one group listed, got %+v", list)
+	}
+
+	if rr := doGroupRequest(router, http.MethodDelete, "/api/v1/groups/east-wing", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if rr := doGroupRequest(router, http.MethodGet, "/api/v1/groups/east-wing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after delete, got %d", rr.Code)
+	}
+}
+
+// TestGroups_InvalidRequests tests bad names and unknown members
+func TestGroups_InvalidRequests(t *testing.T) {
+	router := setupTestServer().Router()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"device_ids": []}`},
+		{"unknown device", `{"name": "g", "device_ids": ["nope"]}`},
+		{"negative alert_after", `{"name": "g", "alert_after": -1}`},
+		{"invalid JSON", `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doGroupRequest(router, http.MethodPost, "/api/v1/groups", tt.body); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+// TestGroups_Membership tests adding and removing single members
+func TestGroups_Membership(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.CreateGroup(t.Context(), Group{Name: "floor-3"})
+
+	if rr := doGroupRequest(router, http.MethodPut, "/api/v1/groups/floor-3/devices/device-2", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if rr := doGroupRequest(router, http.MethodPut, "/api/v1/groups/floor-3/devices/unknown", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown device, got %d", rr.Code)
+	}
+	if rr := doGroupRequest(router, http.MethodPut, "/api/v1/groups/nope/devices/device-1", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown group, got %d", rr.Code)
+	}
+	if group, _ := server.store.GetGroup(t.Context(), "", "floor-3"); !slices.Equal(group.DeviceIDs, []string{"device-2"}) {
+		t.Errorf("expected device-2 added, got %v", group.DeviceIDs)
+	}
+
+	if rr := doGroupRequest(router, http.MethodDelete, "/api/v1/groups/floor-3/devices/device-2", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if group, _ := server.store.GetGroup(t.Context(), "", "floor-3"); len(group.DeviceIDs) != 0 {
+		t.Errorf("expected no members, got %v", group.DeviceIDs)
+	}
+}
+
+// TestGroups_Stats tests aggregation across active members
+func TestGroups_Stats(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-3"] = &DeviceStats{ID: "device-3"}
+	router := server.Router()
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		server.store.RecordHeartbeat(t.Context(), "device-1", t1.Add(time.Duration(i)*time.Minute))
+	}
+	server.store.RecordHeartbeat(t.Context(), "device-2", t1)
+	server.store.RecordHeartbeat(t.Context(), "device-2", t1.Add(3*time.Minute)) // 2 of 4 expected = 50%
+	server.store.RecordUploadStat(t.Context(), "device-1", 2*time.Second)
+	server.store.RecordUploadStat(t.Context(), "device-2", 4*time.Second)
+	server.store.Decommission(t.Context(), "device-3", t1)
+	server.store.CreateGroup(t.Context(), Group{Name: "ward", DeviceIDs: []string{"device-1", "device-2", "device-3"}})
+
+	rr := doGroupRequest(router, http.MethodGet, "/api/v1/groups/ward/stats", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp GroupStatsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp.DeviceCount != 3 || resp.Reporting != 2 || resp.Decommissioned != 1 {
+		t.Errorf("unexpected counts: %+v", resp)
+	}
+	if resp.AvgUptime != 75 || resp.MinUptime != 50 || resp.WorstDeviceID != "device-2" {
+		t.Errorf("unexpected uptime aggregates: %+v", resp)
+	}
+	if resp.AvgUploadTime.String() != "3s" || resp.UploadCount != 2 {
+		t.Errorf("unexpected upload aggregates: %+v", resp)
+	}
+}
+
+// TestGroups_OrgScoped tests that groups are invisible across orgs
+func TestGroups_OrgScoped(t *testing.T) {
+	server := setupAuthTestServer()
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/groups", strings.NewReader(`{"name": "g", "device_ids": ["device-b"]}`))
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 adding another org's device, got %d", rr.Code)
+	}
+
+	server.store.CreateGroup(t.Context(), Group{Name: "g", Org: "org-b", DeviceIDs: []string{"device-b"}})
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/groups/g", nil)
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for another org's group, got %d", rr.Code)
+	}
+}
+
+// TestOfflineMonitor_GroupThreshold tests that a group's threshold applies to members
+func TestOfflineMonitor_GroupThreshold(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera"}
+	s.devices["override"] = &DeviceStats{ID: "override", AlertAfter: 10 * time.Minute}
+	s.CreateGroup(t.Context(), Group{Name: "cameras", AlertAfter: 2 * time.Minute, DeviceIDs: []string{"camera", "override"}})
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "camera", t1)
+	s.RecordHeartbeat(t.Context(), "override", t1)
+
+	offline, _ := NewOfflineMonitor(s, time.Hour).Check(t.Context(), t1.Add(3*time.Minute))
+	if !slices.Equal(offline, []string{"camera"}) {
+		t.Errorf("expected only camera offline via the group threshold, got %v", offline)
+	}
+}
+
+// TestSnapshotRestore_Groups tests that groups survive a snapshot round trip
+func TestSnapshotRestore_Groups(t *testing.T) {
+	src := NewStore()
+	src.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	src.devices["device-2"] = &DeviceStats{ID: "device-2"}
+	src.CreateGroup(t.Context(), Group{Name: "ward", AlertAfter: time.Minute, DeviceIDs: []string{"device-1", "device-2"}})
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	dst := NewStore()
+	dst.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	group, err := dst.GetGroup(t.Context(), "", "ward")
+	if err != nil || group.AlertAfter != time.Minute || !slices.Equal(group.DeviceIDs, []string{"device-1"}) {
+		t.Errorf("expected group restored without unregistered members, got %+v", group)
+	}
+}
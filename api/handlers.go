@@ -0,0 +1,948 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request types
+
+type HeartbeatRequest struct {
+	SentAt            time.Time `json:"sent_at"`
+	HeartbeatInterval int64     `json:"heartbeat_interval,omitempty"` // nanoseconds, optional
+	UploadInterval    int64     `json:"upload_interval,omitempty"`    // nanoseconds, optional
+	FirmwareVersion   string    `json:"firmware_version,omitempty"`
+	AgentVersion      string    `json:"agent_version,omitempty"`
+
+	// Optional hardware vitals
+	BatteryPct    *float64 `json:"battery_pct,omitempty"`
+	TemperatureC  *float64 `json:"temperature_c,omitempty"`
+	DiskFreeBytes *int64   `json:"disk_free_bytes,omitempty"`
+}
+
+type UploadStatRequest struct {
+	SentAt     time.Time `json:"sent_at"`
+	UploadTime int64     `json:"upload_time"`         // nanoseconds
+	UploadID   string    `json:"upload_id,omitempty"` // optional, for correlating with device logs
+	FileType   string    `json:"file_type,omitempty"` // optional, e.g. "clip" or "snapshot"
+}
+
+// Response types
+
+type StatsResponse struct {
+	Uptime         float64  `json:"uptime"`
+	AvgUploadTime  Duration `json:"avg_upload_time"`
+	MinUploadTime  Duration `json:"min_upload_time"`
+	MaxUploadTime  Duration `json:"max_upload_time"`
+	LastUploadTime Duration `json:"last_upload_time"`
+
+	// Change over the last 24 complete hours vs the 24 before; omitted
+	// until both windows have data
+	UptimeDelta        *float64  `json:"uptime_delta,omitempty"`          // percentage points
+	AvgUploadTimeDelta *Duration `json:"avg_upload_time_delta,omitempty"` // signed, e.g. "-1.5s"
+
+	// The device's timezone, and heartbeat times in it; the times are omitted
+	// before the first heartbeat
+	Timezone            string    `json:"timezone"`
+	FirstHeartbeatLocal time.Time `json:"first_heartbeat_local,omitzero"`
+	LastHeartbeatLocal  time.Time `json:"last_heartbeat_local,omitzero"`
+
+	// The cadence uptime is measured against: "configured", "detected" from
+	// recent heartbeat gaps, or the one-minute "default"
+	HeartbeatInterval       Duration `json:"heartbeat_interval"`
+	HeartbeatIntervalSource string   `json:"heartbeat_interval_source"`
+
+	// Where the device is in its lifecycle: "provisioned", "active" or
+	// "retired"; uptime of an active device is measured from activated_at
+	Lifecycle   string    `json:"lifecycle"`
+	ActivatedAt time.Time `json:"activated_at,omitzero"`
+
+	// When the maintenance window the device is in ends; omitted outside one
+	MaintenanceUntil time.Time `json:"maintenance_until,omitzero"`
+
+	// Connectivity from heartbeat gaps; omitted until two heartbeats arrive
+	NetworkScore     *float64  `json:"network_score,omitempty"` // 0-100
+	Jitter           *Duration `json:"jitter,omitempty"`
+	MissedHeartbeats *int64    `json:"missed_heartbeats,omitempty"`
+
+	// Delay from sent_at to the server receiving live heartbeats, signed;
+	// omitted until one is measured, and the p95 after a restart until
+	// the next arrives
+	HeartbeatLatency    *Duration `json:"heartbeat_latency,omitempty"`
+	HeartbeatLatencyP95 *Duration `json:"heartbeat_latency_p95,omitempty"`
+
+	// Hardware vitals; omitted for sensors the device never reported
+	BatteryPct    *ReadingResponse `json:"battery_pct,omitempty"`
+	TemperatureC  *ReadingResponse `json:"temperature_c,omitempty"`
+	DiskFreeBytes *ReadingResponse `json:"disk_free_bytes,omitempty"`
+}
+
+// ErrorResponse is the original error shape, written when legacy errors are
+// enabled; see ProblemDetails.
+type ErrorResponse struct {
+	Msg       string   `json:"msg"`
+	Code      string   `json:"code,omitempty"`
+	Field     string   `json:"field,omitempty"`     // the request field at fault, if known
+	Supported []string `json:"supported,omitempty"` // accepted Content-Types, on 415
+}
+
+// Server holds dependencies for HTTP handlers.
+type Server struct {
+	store         Storage // the backend, dropping cached stats as it changes
+	stats         *statsCache
+	deadLetters   *deadLetterQueue
+	configMu      sync.RWMutex
+	configErr     error  // protected by configMu; set if startup configuration failed
+	devicesErr    error  // protected by configMu; set if the device CSV failed to load, until reloaded
+	devicesSpec   string // protected by configMu; device CSV files and globs, empty means reload is disabled
+	keysMu        sync.RWMutex
+	apiKeys       APIKeys // protected by keysMu; empty means authentication is disabled
+	validation    ValidationConfig
+	limiter       *rateLimiter      // nil means rate limiting is disabled
+	routeLimits   *routeLimiter     // nil means routes run without concurrency limits
+	cors          *CORSConfig       // nil means cross-origin requests get no CORS headers
+	chaos         *ChaosConfig      // nil means no faults are injected
+	timeout       time.Duration     // zero means handlers run without a deadline
+	legacyErrors  bool              // errors use the original shape instead of problem details
+	envelope      bool              // responses are enveloped unless the request opts out
+	precision     precision         // rounding of uptime and durations unless the request asks
+	pipeline      *writePipeline    // nil means telemetry is written before responding
+	standby       atomic.Bool       // true while another instance holds leadership
+	loading       atomic.Bool       // true until the device registry has loaded
+	enroller      *Enroller         // nil means enrollment is disabled
+	events        *eventStream      // nil means accepted telemetry isn't published
+	receipts      *receiptBook      // nil means telemetry is acknowledged without receipts
+	diagnostics   *diagnosticsStore // nil means diagnostics uploads are disabled
+	metrics       *requestMetrics   // per-route request counts and latencies
+	metricsDetail bool              // telemetry histograms are per org, with device exemplars
+
+	// Payload signing
+	signingSecret     []byte             // nil means only devices with their own signing_secret sign
+	signatureFailures *signatureFailures // rejected signatures per device
+
+	// Background housekeeping
+	housekeeping *housekeeping
+
+	// Device CSV rewrites by import and topology sync, one at a time
+	importMu sync.Mutex
+
+	// Room and facility sync from the facility management API
+	topology *topologySync
+
+	// Webhook subscriptions and their deliveries
+	webhooks *webhookHub
+
+	// Heartbeat silence after which v2 stats report a device offline, for
+	// devices without their own alert_after; matches the offline monitor's
+	offlineAfter time.Duration
+
+	// Per-organization usage and quotas
+	usage *orgUsage
+
+	// Commands waiting to ride back on heartbeat acknowledgements
+	commands *commandQueue
+
+	// Proxies whose X-Forwarded-For and X-Real-IP are believed when recording
+	// where heartbeats came from and rate limiting; empty means the
+	// connection's address is used
+	trustedProxies []netip.Prefix
+}
+
+// NewServer creates a new server with the given store.
+func NewServer(store Storage, configErr error) *Server {
+	stats := newStatsCache()
+	return &Server{
+		store:       &cachedStorage{Storage: store, stats: stats},
+		stats:       stats,
+		deadLetters: newDeadLetterQueue(DefaultDeadLetterCapacity),
+		configErr:   configErr,
+		validation:  DefaultValidationConfig(),
+		metrics:     newRequestMetrics(),
+
+		signatureFailures: newSignatureFailures(),
+
+		housekeeping: &housekeeping{},
+		topology:     &topologySync{},
+
+		webhooks: newWebhookHub(),
+
+		offlineAfter: DefaultOfflineAfter,
+
+		usage: newOrgUsage(),
+
+		commands: newCommandQueue(),
+	}
+}
+
+// Storage returns the store as the server writes to it. Anything else
+// writing to the store, such as the offline monitor, should use it, so the
+// server's cached stats see the change.
+func (s *Server) Storage() Storage {
+	return s.store
+}
+
+// backend returns the backend the server was created with, under its
+// cached stats.
+func (s *Server) backend() Storage {
+	if cached, ok := s.store.(*cachedStorage); ok {
+		return cached.Storage
+	}
+	return s.store
+}
+
+// SetValidationConfig replaces the limits applied to incoming telemetry.
+func (s *Server) SetValidationConfig(cfg ValidationConfig) {
+	s.validation = cfg
+}
+
+// EnableAuth requires every request to carry one of the given API keys and
+// scopes the request to the key's organization.
+func (s *Server) EnableAuth(keys APIKeys) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	s.apiKeys = keys
+}
+
+// EnableEnrollment lets devices register themselves with one-time tokens.
+func (s *Server) EnableEnrollment(enroller *Enroller) {
+	s.enroller = enroller
+}
+
+// EnableRateLimit limits each client IP to perSecond requests with bursts of up to burst.
+func (s *Server) EnableRateLimit(perSecond float64, burst int) {
+	s.limiter = newRateLimiter(perSecond, burst)
+}
+
+// SetHandlerTimeout bounds how long a request may take; requests that hit
+// the deadline before responding get 503.
+func (s *Server) SetHandlerTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// writeJSON writes a JSON response with the given status code, in an
+// envelope if the request asked for one.
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	encodeJSON(w, status, "application/json", envelopeFor(w, data))
+}
+
+// encodeJSON writes v as the response body with the given status code and
+// Content-Type.
+func encodeJSON(w http.ResponseWriter, status int, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[ERROR] Failed to encode JSON response: %v", err)
+	}
+}
+
+// writeError writes an error response with the status's generic code.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorCode(w, status, "", msg)
+}
+
+// writeValidationError writes a 400 response, including the error code and
+// field when the validation failure carries them.
+func writeValidationError(w http.ResponseWriter, err error) {
+	resp := ErrorResponse{Msg: err.Error(), Code: errCodeValidation}
+	var verr *validationError
+	if errors.As(err, &verr) {
+		resp.Code = verr.code
+		resp.Field = verr.field
+	}
+	writeErrorResponse(w, http.StatusBadRequest, resp)
+}
+
+// extractDeviceID extracts the device ID from a URL path.
+// Expected format: /api/v1/devices/{device_id}/heartbeat or /api/v1/devices/{device_id}/stats
+func extractDeviceID(path string) string {
+	parts := strings.Split(path, "/")
+	// /api/v1/devices/{device_id}/endpoint -> ["", "api", "v1", "devices", "{device_id}", "endpoint"]
+	if len(parts) >= 5 {
+		return parts[4]
+	}
+	return ""
+}
+
+// Validation
+
+// ValidationConfig holds the tunable limits applied to incoming telemetry.
+// Zero disables any limit except MaxFutureSkew.
+type ValidationConfig struct {
+	MaxFutureSkew        time.Duration // How far ahead of server time sent_at may be
+	MaxPastAge           time.Duration // How far behind server time sent_at may be
+	MaxUploadTime        time.Duration // Longest accepted upload_time
+	MaxHeartbeatInterval time.Duration // Longest accepted declared heartbeat cadence
+	MaxUploadInterval    time.Duration // Longest accepted declared upload cadence
+	MaxVersionLength     int           // Longest accepted firmware/agent version string
+}
+
+// DefaultValidationConfig allows 1 minute of clock skew, caps upload times
+// and heartbeat cadences at an hour, and caps upload cadences at a day. Old
+// sent_at values are accepted, since devices flush buffered telemetry after
+// outages and the bundled simulator replays 2024 timestamps; deployments opt
+// into a replay window with MaxPastAge.
+func DefaultValidationConfig() ValidationConfig {
+	return ValidationConfig{
+		MaxFutureSkew:        time.Minute,
+		MaxUploadTime:        time.Hour,
+		MaxHeartbeatInterval: time.Hour,
+		MaxUploadInterval:    24 * time.Hour,
+		MaxVersionLength:     64,
+	}
+}
+
+// validationError is a rejected request with a machine-readable code.
+type validationError struct {
+	code  string
+	field string // empty if the failure isn't tied to one field
+	msg   string
+}
+
+func (e *validationError) Error() string { return e.msg }
+
+// validateSentAt checks a non-zero sent_at against the skew and replay limits.
+func validateSentAt(sentAt time.Time, cfg ValidationConfig, now time.Time) error {
+	if sentAt.After(now.Add(cfg.MaxFutureSkew)) {
+		return &validationError{code: errCodeSentAtFuture, field: "sent_at", msg: "sent_at cannot be in the future"}
+	}
+	if cfg.MaxPastAge > 0 && sentAt.Before(now.Add(-cfg.MaxPastAge)) {
+		return &validationError{code: errCodeSentAtTooOld, field: "sent_at", msg: "sent_at is too old"}
+	}
+	return nil
+}
+
+func validateHeartbeatRequest(req *HeartbeatRequest, cfg ValidationConfig, now time.Time) error {
+	if req.SentAt.IsZero() {
+		return &validationError{code: errCodeSentAtRequired, field: "sent_at", msg: "sent_at is required"}
+	}
+	if err := validateSentAt(req.SentAt, cfg, now); err != nil {
+		return err
+	}
+	if req.HeartbeatInterval < 0 {
+		return &validationError{code: errCodeHeartbeatIntervalRange, field: "heartbeat_interval", msg: "heartbeat_interval must be positive"}
+	}
+	if cfg.MaxHeartbeatInterval > 0 && req.HeartbeatInterval > int64(cfg.MaxHeartbeatInterval) {
+		return &validationError{code: errCodeHeartbeatIntervalRange, field: "heartbeat_interval", msg: "heartbeat_interval exceeds maximum"}
+	}
+	if req.UploadInterval < 0 {
+		return &validationError{code: errCodeUploadIntervalRange, field: "upload_interval", msg: "upload_interval must be positive"}
+	}
+	if cfg.MaxUploadInterval > 0 && req.UploadInterval > int64(cfg.MaxUploadInterval) {
+		return &validationError{code: errCodeUploadIntervalRange, field: "upload_interval", msg: "upload_interval exceeds maximum"}
+	}
+	if cfg.MaxVersionLength > 0 && len(req.FirmwareVersion) > cfg.MaxVersionLength {
+		return &validationError{code: errCodeVersionTooLong, field: "firmware_version", msg: "firmware_version exceeds maximum length"}
+	}
+	if cfg.MaxVersionLength > 0 && len(req.AgentVersion) > cfg.MaxVersionLength {
+		return &validationError{code: errCodeVersionTooLong, field: "agent_version", msg: "agent_version exceeds maximum length"}
+	}
+	return validateVitals(req)
+}
+
+func validateUploadStatRequest(req *UploadStatRequest, cfg ValidationConfig, now time.Time) error {
+	// Note: sent_at is optional for stats (simulator sends zero time)
+	if !req.SentAt.IsZero() {
+		if err := validateSentAt(req.SentAt, cfg, now); err != nil {
+			return err
+		}
+	}
+	if req.UploadTime <= 0 {
+		return &validationError{code: errCodeUploadTimeRange, field: "upload_time", msg: "upload_time must be positive"}
+	}
+	if cfg.MaxUploadTime > 0 && req.UploadTime > int64(cfg.MaxUploadTime) {
+		return &validationError{code: errCodeUploadTimeRange, field: "upload_time", msg: "upload_time exceeds maximum"}
+	}
+	return validateUploadLabels(req.UploadID, req.FileType)
+}
+
+// Recording
+
+// recordHeartbeat stores a validated heartbeat, plus the device's declared
+// cadences, versions and vitals if it sent them. receivedAt is when a live
+// heartbeat arrived, and is zero for ingested ones. Nothing is stored if ctx
+// has ended.
+func (s *Server) recordHeartbeat(ctx context.Context, deviceID, sourceIP string, receivedAt time.Time, req *HeartbeatRequest) error {
+	var diskFree *float64
+	if req.DiskFreeBytes != nil {
+		v := float64(*req.DiskFreeBytes)
+		diskFree = &v
+	}
+	return s.record(ctx, TelemetryEvent{
+		DeviceID:       deviceID,
+		Heartbeat:      true,
+		At:             req.SentAt,
+		ReceivedAt:     receivedAt,
+		SourceIP:       sourceIP,
+		Interval:       time.Duration(req.HeartbeatInterval),
+		UploadInterval: time.Duration(req.UploadInterval),
+		Firmware:       req.FirmwareVersion,
+		Agent:          req.AgentVersion,
+		Battery:        req.BatteryPct,
+		Temperature:    req.TemperatureC,
+		DiskFree:       diskFree,
+	})
+}
+
+// recordUploadStat stores a validated upload stat. Uploads without sent_at
+// are attributed to the time they were received. Nothing is stored if ctx has ended.
+func (s *Server) recordUploadStat(ctx context.Context, deviceID string, req *UploadStatRequest) error {
+	at := req.SentAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return s.record(ctx, TelemetryEvent{
+		DeviceID:   deviceID,
+		At:         at,
+		UploadTime: time.Duration(req.UploadTime),
+		UploadID:   req.UploadID,
+		FileType:   req.FileType,
+	})
+}
+
+// record writes an event to the store, or queues it when async writes are
+// enabled, returning errQueueFull if the queue has no room. Accepted events
+// are counted on the request's receipt, if it has one, and published, when
+// publishing is enabled.
+func (s *Server) record(ctx context.Context, ev TelemetryEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// An atomic ingest applies, counts and publishes its events on commit
+	if batch := atomicIngestFromContext(ctx); batch != nil {
+		batch.tx.Stage(ev)
+		batch.events = append(batch.events, ev)
+		return nil
+	}
+	queued := queuedEvent{TelemetryEvent: ev, receipt: receiptFromContext(ctx)}
+	if queued.receipt != nil {
+		queued.receipt.add()
+	}
+	if s.pipeline != nil {
+		if err := s.pipeline.enqueue(queued); err != nil {
+			if queued.receipt != nil {
+				queued.receipt.cancel()
+			}
+			return err
+		}
+	} else {
+		err := s.store.Apply(ctx, []TelemetryEvent{ev})
+		if queued.receipt != nil {
+			if err != nil {
+				queued.receipt.cancel()
+			} else {
+				queued.receipt.done()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.publish(ev)
+	return nil
+}
+
+// Handlers
+
+// HandleHeartbeat processes POST /api/v1/devices/{device_id}/heartbeat
+func (s *Server) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] POST /api/v1/devices/%s/heartbeat", deviceID)
+
+	// The body is read up front so rejected payloads can be dead-lettered
+	body := readTelemetryBody(r)
+
+	// Check if device exists and is visible to the caller
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			s.deadLetter(r, deviceID, ingestTypeHeartbeat, body, err)
+		}
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	// Decommissioned devices keep their history but accept no new telemetry
+	retired, err := s.store.IsDecommissioned(r.Context(), deviceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if retired {
+		log.Printf("[WARN] Telemetry for decommissioned device: %s", deviceID)
+		s.deadLetter(r, deviceID, ingestTypeHeartbeat, body, ErrDeviceDecommissioned)
+		writeErrorCode(w, http.StatusGone, errCodeDeviceDecommissioned, "device decommissioned")
+		return
+	}
+
+	// Devices with a token must send it
+	if err := s.verifyDeviceToken(r, deviceID); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Rejected heartbeat token for %s: %v", deviceID, err)
+		writeErrorCode(w, http.StatusUnauthorized, validationCode(err), err.Error())
+		return
+	}
+
+	// Devices with a signing key must prove the payload came from them
+	if err := s.verifySignature(r, deviceID, body); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Rejected heartbeat signature for %s: %v", deviceID, err)
+		writeErrorCode(w, http.StatusUnauthorized, validationCode(err), err.Error())
+		return
+	}
+
+	// Telemetry counts toward the organization's daily quota
+	if err := s.admitTelemetry(r.Context(), deviceID, time.Now()); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Heartbeat over quota for %s", deviceID)
+		s.deadLetter(r, deviceID, ingestTypeHeartbeat, body, err)
+		writeQuotaExceeded(w, err, time.Now())
+		return
+	}
+
+	// Parse request body
+	var req HeartbeatRequest
+	if err := decodeJSON(body, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		s.deadLetter(r, deviceID, ingestTypeHeartbeat, body, err)
+		writeValidationError(w, err)
+		return
+	}
+
+	// Validate request
+	receivedAt := time.Now()
+	if err := validateHeartbeatRequest(&req, s.validation, receivedAt); err != nil {
+		log.Printf("[ERROR] Validation failed: %v", err)
+		s.deadLetter(r, deviceID, ingestTypeHeartbeat, body, err)
+		writeValidationError(w, err)
+		return
+	}
+
+	record := func(ctx context.Context) error {
+		return s.recordHeartbeat(ctx, deviceID, s.sourceIP(r), receivedAt, &req)
+	}
+	if wantsHeartbeatAck(r) && s.receipts == nil {
+		// Devices asking for an acknowledgement get their pending commands;
+		// receipt mode answers with the receipt instead
+		format, msg := s.parseDurationFormat(r)
+		if msg != "" {
+			writeError(w, http.StatusBadRequest, msg)
+			return
+		}
+		err = s.acknowledgeHeartbeat(w, r, deviceID, format, record)
+	} else {
+		err = s.acknowledge(w, r, deviceID, record)
+	}
+	if errors.Is(err, errQueueFull) {
+		writeQueueFull(w)
+	} else if err != nil {
+		writeStorageError(w, err)
+	}
+}
+
+// HandlePostStats processes POST /api/v1/devices/{device_id}/stats
+func (s *Server) HandlePostStats(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] POST /api/v1/devices/%s/stats", deviceID)
+
+	// The body is read up front so rejected payloads can be dead-lettered
+	body := readTelemetryBody(r)
+
+	// Check if device exists and is visible to the caller
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			s.deadLetter(r, deviceID, ingestTypeUpload, body, err)
+		}
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	// Decommissioned devices keep their history but accept no new telemetry
+	retired, err := s.store.IsDecommissioned(r.Context(), deviceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if retired {
+		log.Printf("[WARN] Telemetry for decommissioned device: %s", deviceID)
+		s.deadLetter(r, deviceID, ingestTypeUpload, body, ErrDeviceDecommissioned)
+		writeErrorCode(w, http.StatusGone, errCodeDeviceDecommissioned, "device decommissioned")
+		return
+	}
+
+	// Devices with a token must send it
+	if err := s.verifyDeviceToken(r, deviceID); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Rejected upload stat token for %s: %v", deviceID, err)
+		writeErrorCode(w, http.StatusUnauthorized, validationCode(err), err.Error())
+		return
+	}
+
+	// Devices with a signing key must prove the payload came from them
+	if err := s.verifySignature(r, deviceID, body); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Rejected upload stat signature for %s: %v", deviceID, err)
+		writeErrorCode(w, http.StatusUnauthorized, validationCode(err), err.Error())
+		return
+	}
+
+	// Telemetry counts toward the organization's daily quota
+	if err := s.admitTelemetry(r.Context(), deviceID, time.Now()); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[WARN] Upload stat over quota for %s", deviceID)
+		s.deadLetter(r, deviceID, ingestTypeUpload, body, err)
+		writeQuotaExceeded(w, err, time.Now())
+		return
+	}
+
+	// Parse request body
+	var req UploadStatRequest
+	if err := decodeJSON(body, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		s.deadLetter(r, deviceID, ingestTypeUpload, body, err)
+		writeValidationError(w, err)
+		return
+	}
+
+	// Validate request
+	if err := validateUploadStatRequest(&req, s.validation, time.Now()); err != nil {
+		log.Printf("[ERROR] Validation failed: %v", err)
+		s.deadLetter(r, deviceID, ingestTypeUpload, body, err)
+		writeValidationError(w, err)
+		return
+	}
+
+	err = s.acknowledge(w, r, deviceID, func(ctx context.Context) error {
+		return s.recordUploadStat(ctx, deviceID, &req)
+	})
+	if errors.Is(err, errQueueFull) {
+		writeQueueFull(w)
+	} else if err != nil {
+		writeStorageError(w, err)
+	}
+}
+
+// deviceStats looks up the device a stats request is for, shared by every
+// version of the stats endpoint. It writes the error response itself and
+// returns false if there is nothing to report.
+func (s *Server) deviceStats(w http.ResponseWriter, r *http.Request, deviceID string, now time.Time) (DeviceStats, StatsResult, statsTrend, bool) {
+	// Get stats; visibility is checked against the copy so a cached answer
+	// needs no lock at all
+	device, result, trend, err := s.cachedStats(r.Context(), deviceID, now)
+	if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+		writeStorageError(w, err)
+		return DeviceStats{}, StatsResult{}, statsTrend{}, false
+	}
+	if org := orgFromContext(r.Context()); err != nil || (org != "" && org != device.Org) {
+		log.Printf("[WARN] Device not found: %s", deviceID)
+		writeErrorCode(w, http.StatusNotFound, errCodeDeviceNotFound, "device not found")
+		return DeviceStats{}, StatsResult{}, statsTrend{}, false
+	}
+	return device, result, trend, true
+}
+
+// HandleGetStats processes GET /api/v1/devices/{device_id}/stats. Its
+// response is frozen: fields may be added, but never renamed or retyped;
+// reshaped stats belong in a later version (see HandleGetStatsV2).
+func (s *Server) HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/stats", deviceID)
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if r.URL.Query().Has("as_of") {
+		s.handleStatsAsOf(w, r, deviceID, format)
+		return
+	}
+
+	now := time.Now()
+	device, result, trend, ok := s.deviceStats(w, r, deviceID, now)
+	if !ok {
+		return
+	}
+
+	// If no data collected yet, return 204
+	if !result.HasHeartbeats && !result.HasUploads {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Build response
+	resp := StatsResponse{
+		Uptime:         format.percent(result.Uptime),
+		AvgUploadTime:  format.duration(result.AvgUploadTime),
+		MinUploadTime:  format.duration(result.MinUploadTime),
+		MaxUploadTime:  format.duration(result.MaxUploadTime),
+		LastUploadTime: format.duration(result.LastUploadTime),
+		BatteryPct:     readingResponse(device.Battery),
+		TemperatureC:   readingResponse(device.Temperature),
+		DiskFreeBytes:  readingResponse(device.DiskFree),
+
+		Timezone:            device.Location().String(),
+		FirstHeartbeatLocal: localTime(device.FirstHeartbeat, device.Location()),
+		LastHeartbeatLocal:  localTime(device.LastHeartbeat, device.Location()),
+
+		HeartbeatInterval:       format.duration(device.EffectiveInterval()),
+		HeartbeatIntervalSource: device.IntervalSource(),
+
+		Lifecycle:   device.Lifecycle(now),
+		ActivatedAt: device.ActivatedAt,
+
+		MaintenanceUntil: device.maintenance.activeUntil(now),
+	}
+	if quality, ok := device.NetworkQuality(); ok {
+		jitter := format.duration(quality.Jitter)
+		resp.NetworkScore = &quality.Score
+		resp.Jitter = &jitter
+		resp.MissedHeartbeats = &quality.MissedHeartbeats
+	}
+	if latency, ok := device.HeartbeatLatency(); ok {
+		avg := format.duration(latency.Avg)
+		resp.HeartbeatLatency = &avg
+		if latency.Recent > 0 {
+			p95 := format.duration(latency.P95)
+			resp.HeartbeatLatencyP95 = &p95
+		}
+	}
+	if trend.hasUptime {
+		delta := format.percent(trend.uptimeDelta)
+		resp.UptimeDelta = &delta
+	}
+	if trend.hasAvgUpload {
+		delta := format.duration(trend.avgUploadDelta)
+		resp.AvgUploadTimeDelta = &delta
+	}
+
+	writeCacheableJSON(w, r, resp)
+}
+
+// HandleDecommission processes POST /api/v1/devices/{device_id}/decommission
+func (s *Server) HandleDecommission(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] POST /api/v1/devices/%s/decommission", deviceID)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	if err := s.store.Decommission(r.Context(), deviceID, time.Now()); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	log.Printf("[INFO] Device decommissioned: %s", deviceID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Router routes requests to the appropriate handler.
+func (s *Server) Router() http.Handler {
+	mux := http.NewServeMux()
+
+	// The Go HTTP mux doesn't support path parameters, so we need to handle routing manually
+	mux.HandleFunc("/api/v1/devices/", func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+
+		// Determine which endpoint based on path suffix; the method is checked by methods
+		var route methods
+		switch {
+		case strings.HasSuffix(path, "/heartbeat"):
+			route = methods{http.MethodPost: s.HandleHeartbeat}
+		case strings.HasSuffix(path, "/decommission"):
+			route = methods{http.MethodPost: s.HandleDecommission}
+		case strings.HasSuffix(path, "/activate"):
+			route = methods{http.MethodPost: s.HandleActivate}
+		case strings.HasSuffix(path, "/mute"):
+			route = methods{http.MethodPost: s.HandleMute, http.MethodDelete: s.HandleMute}
+		case strings.HasSuffix(path, "/transfer"):
+			route = methods{http.MethodPost: s.HandleTransfer}
+		case strings.HasSuffix(path, "/events"):
+			route = methods{http.MethodGet: s.HandleEvents}
+		case strings.HasSuffix(path, "/diagnostics"):
+			route = methods{http.MethodGet: s.HandleDiagnostics, http.MethodPost: s.HandleDiagnostics}
+		case strings.Contains(path, "/diagnostics/"):
+			route = methods{http.MethodGet: s.HandleDiagnosticsBundle, http.MethodDelete: s.HandleDiagnosticsBundle}
+		case strings.HasSuffix(path, "/commands"):
+			route = methods{http.MethodGet: s.HandleCommands, http.MethodPost: s.HandleCommands}
+		case strings.Contains(path, "/commands/") && strings.HasSuffix(path, "/ack"):
+			route = methods{http.MethodPost: s.HandleCommand}
+		case strings.Contains(path, "/commands/"):
+			route = methods{http.MethodDelete: s.HandleCommand}
+		case strings.HasSuffix(path, "/sla"):
+			route = methods{http.MethodGet: s.HandleDeviceSLA}
+		case strings.HasSuffix(path, "/uploads/recent"):
+			route = methods{http.MethodGet: s.HandleRecentUploads}
+		case strings.HasSuffix(path, "/stats/history"):
+			route = methods{http.MethodGet: s.HandleStatsHistory}
+		case strings.HasSuffix(path, "/stats/daily"):
+			route = methods{http.MethodGet: s.HandleStatsDaily}
+		case strings.HasSuffix(path, "/stats"):
+			route = methods{http.MethodGet: s.HandleGetStats, http.MethodPost: s.HandlePostStats}
+		case !strings.Contains(strings.TrimPrefix(path, "/api/v1/devices/"), "/") && extractDeviceID(path) != "":
+			route = methods{http.MethodGet: s.HandleGetDevice}
+		default:
+			// Unknown endpoint
+			http.NotFound(w, r)
+			return
+		}
+		route.ServeHTTP(w, r)
+	})
+
+	mux.Handle("/api/v1/devices/search", methods{http.MethodGet: s.HandleSearchDevices})
+	mux.Handle("/api/v1/devices/compare", methods{http.MethodGet: s.HandleCompareDevices})
+
+	// Version 2 only serves the endpoints it reshapes
+	mux.HandleFunc("/api/v2/devices/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/v2/devices/")
+		if id, action, _ := strings.Cut(rest, "/"); id == "" || action != "stats" {
+			http.NotFound(w, r)
+			return
+		}
+		methods{http.MethodGet: s.HandleGetStatsV2}.ServeHTTP(w, r)
+	})
+	mux.Handle("/api/v1/devices", methods{http.MethodGet: s.HandleListDevices})
+	mux.Handle("/api/v1/ingest", methods{http.MethodPost: s.HandleIngest})
+	mux.Handle("/api/v1/fleet/versions", methods{http.MethodGet: s.HandleFleetVersions})
+
+	mux.Handle("/api/v1/groups", methods{http.MethodGet: s.HandleListGroups, http.MethodPost: s.HandleCreateGroup})
+	mux.HandleFunc("/api/v1/groups/", s.routeGroup)
+	mux.HandleFunc("/api/v1/orgs/", s.routeOrg)
+
+	// Admin endpoints report on or change state shared by every organization
+	mux.Handle("/api/v1/admin/limits", s.fleetOnly(methods{http.MethodGet: s.HandleLimits}))
+	mux.Handle("/api/v1/admin/queue", s.fleetOnly(methods{http.MethodGet: s.HandleQueue}))
+	mux.Handle("/api/v1/admin/publisher", s.fleetOnly(methods{http.MethodGet: s.HandlePublisher}))
+	mux.Handle("/api/v1/admin/locks", s.fleetOnly(methods{http.MethodGet: s.HandleLocks}))
+	mux.Handle("/api/v1/admin/shadow", s.fleetOnly(methods{http.MethodGet: s.HandleShadow}))
+	mux.Handle("/api/v1/admin/housekeeping", s.fleetOnly(methods{http.MethodGet: s.HandleHousekeeping}))
+	mux.Handle("/api/v1/admin/housekeeping/dry-run", s.fleetOnly(methods{http.MethodGet: s.HandleHousekeepingDryRun}))
+	mux.Handle("/api/v1/admin/topology", s.fleetOnly(methods{http.MethodGet: s.HandleTopology, http.MethodPost: s.HandleTopology}))
+	mux.Handle("/api/v1/admin/signatures", s.fleetOnly(methods{http.MethodGet: s.HandleSignatureFailures}))
+	mux.Handle("/api/v1/admin/reload", s.fleetOnly(methods{http.MethodPost: s.HandleReload}))
+	mux.Handle("/api/v1/admin/devices/export", s.fleetOnly(methods{http.MethodGet: s.HandleDeviceExport}))
+	mux.Handle("/api/v1/admin/devices/import", s.fleetOnly(methods{http.MethodPost: s.HandleDeviceImport}))
+	mux.Handle("/api/v1/admin/validate-csv", s.fleetOnly(methods{http.MethodPost: s.HandleValidateCSV}))
+
+	mux.Handle("/api/v1/receipts/", methods{http.MethodGet: s.HandleReceipt})
+
+	mux.Handle("/api/v1/deadletter", methods{http.MethodGet: s.HandleListDeadLetters})
+	mux.HandleFunc("/api/v1/deadletter/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/v1/deadletter/")
+		_, action, _ := strings.Cut(rest, "/")
+		switch {
+		case rest == "replay":
+			methods{http.MethodPost: s.HandleReplayDeadLetters}.ServeHTTP(w, r)
+		case action == "":
+			methods{http.MethodDelete: s.HandleDeadLetter}.ServeHTTP(w, r)
+		case action == "replay":
+			methods{http.MethodPost: s.HandleDeadLetter}.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	mux.Handle("/api/v1/webhooks", methods{http.MethodGet: s.HandleListWebhooks, http.MethodPost: s.HandleCreateWebhook})
+	mux.HandleFunc("/api/v1/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/webhooks/"), "/")
+		switch action {
+		case "":
+			methods{http.MethodDelete: s.HandleWebhook}.ServeHTTP(w, r)
+		case "deliveries":
+			methods{http.MethodGet: s.HandleWebhook}.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	mux.Handle("/api/v1/maintenance", methods{http.MethodGet: s.HandleListMaintenance, http.MethodPost: s.HandleCreateMaintenance})
+	mux.Handle("/api/v1/maintenance/", methods{http.MethodDelete: s.HandleDeleteMaintenance})
+
+	mux.Handle("/api/v1/fleet/activity", methods{http.MethodGet: s.HandleFleetActivity})
+	mux.Handle("/api/v1/fleet/distribution", methods{http.MethodGet: s.HandleFleetDistribution})
+	mux.Handle("/api/v1/fleet/sla", methods{http.MethodGet: s.HandleFleetSLA})
+	mux.Handle("/api/v1/fleet/offline", methods{http.MethodGet: s.HandleFleetOffline})
+
+	mux.Handle("/api/v1/errors", methods{http.MethodGet: s.HandleErrorCodes})
+
+	// Tracing is outermost so every response, even an error, carries the
+	// request ID. Error formatting comes next so every error below it,
+	// including a recovered panic, gets the request path and the configured
+	// shape.
+	// Recovery comes next so it also catches panics in other middleware;
+	// metrics come next so rejected and timed-out requests are counted; the
+	// timeout wraps everything below logging so 503s are logged; injected
+	// faults come inside it so they're logged and counted like real ones; a
+	// loading or standby instance rejects requests before any other work; CORS
+	// answers preflights before auth, since browsers send them without the
+	// API key; rate limiting runs before auth so key guessing is throttled
+	// too; only authenticated requests take a slot of a concurrency-limited
+	// route
+	api := Chain(mux, traceRequests, s.formatResponses, recoverPanics, s.instrument, logRequests, s.enforceDeadline, s.injectChaos, s.rejectLoading, s.rejectStandby, s.handleCORS, s.rateLimit, s.authenticate, s.limitConcurrency, requireContentType)
+
+	// Health probes and metrics scrapes skip logging, rate limiting and
+	// auth: load balancers and Prometheus poll often and carry no API key
+	root := http.NewServeMux()
+	root.Handle("/", api)
+	root.Handle("/healthz", Chain(methods{http.MethodGet: s.HandleHealthz}, s.formatResponses, recoverPanics))
+	root.Handle("/grpc.health.v1.Health/", Chain(http.HandlerFunc(s.HandleGRPCHealth), s.formatResponses, recoverPanics))
+	root.Handle("/metrics", Chain(methods{http.MethodGet: s.HandleMetrics}, s.formatResponses, recoverPanics))
+
+	// Enrolling devices have no API key yet, so enrollment skips auth but
+	// keeps rate limiting to throttle token guessing
+	root.Handle("/api/v1/enroll", Chain(methods{http.MethodPost: s.HandleEnroll}, traceRequests, s.formatResponses, recoverPanics, s.instrument, logRequests, s.enforceDeadline, s.injectChaos, s.rejectLoading, s.rejectStandby, s.handleCORS, s.rateLimit, s.limitConcurrency, requireContentType))
+	return root
+}
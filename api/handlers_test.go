@@ -1,4 +1,4 @@
-package main
+package api
 
 import (
 	"bytes"
@@ -35,7 +35,7 @@ func TestPostHeartbeat_Success(t *testing.T) {
 	}
 
 	// Verify heartbeat was recorded
-	if server.store.devices["device-1"].HeartbeatCount != 1 {
+	if server.backend().(*Store).devices["device-1"].HeartbeatCount != 1 {
 		t.Error("heartbeat was not recorded")
 	}
 }
@@ -56,10 +56,10 @@ func TestPostHeartbeat_NotFound(t *testing.T) {
 		t.Errorf("expected status 404, got %d", rr.Code)
 	}
 
-	var resp ErrorResponse
+	var resp ProblemDetails
 	_ = json.NewDecoder(rr.Body).Decode(&resp)
-	if resp.Msg != "device not found" {
-		t.Errorf("expected 'device not found', got '%s'", resp.Msg)
+	if resp.Detail != "device not found" {
+		t.Errorf("expected 'device not found', got '%s'", resp.Detail)
 	}
 }
 
@@ -79,10 +79,10 @@ func TestPostHeartbeat_InvalidJSON(t *testing.T) {
 		t.Errorf("expected status 400, got %d", rr.Code)
 	}
 
-	var resp ErrorResponse
+	var resp ProblemDetails
 	_ = json.NewDecoder(rr.Body).Decode(&resp)
-	if resp.Msg != "invalid JSON" {
-		t.Errorf("expected 'invalid JSON', got '%s'", resp.Msg)
+	if resp.Detail != "invalid JSON" {
+		t.Errorf("expected 'invalid JSON', got '%s'", resp.Detail)
 	}
 }
 
@@ -102,10 +102,10 @@ func TestPostHeartbeat_MissingSentAt(t *testing.T) {
 		t.Errorf("expected status 400, got %d", rr.Code)
 	}
 
-	var resp ErrorResponse
+	var resp ProblemDetails
 	_ = json.NewDecoder(rr.Body).Decode(&resp)
-	if resp.Msg != "sent_at is required" {
-		t.Errorf("expected 'sent_at is required', got '%s'", resp.Msg)
+	if resp.Detail != "sent_at is required" {
+		t.Errorf("expected 'sent_at is required', got '%s'", resp.Detail)
 	}
 }
 
@@ -126,10 +126,10 @@ func TestPostStats_Success(t *testing.T) {
 	}
 
 	// Verify upload was recorded
-	if server.store.devices["device-1"].UploadCount != 1 {
+	if server.backend().(*Store).devices["device-1"].UploadCount != 1 {
 		t.Error("upload stat was not recorded")
 	}
-	if server.store.devices["device-1"].UploadTimeSum != 5*time.Second {
+	if server.backend().(*Store).devices["device-1"].UploadTimeSum != 5*time.Second {
 		t.Error("upload time was not recorded correctly")
 	}
 }
@@ -167,10 +167,10 @@ func TestPostStats_InvalidUploadTime(t *testing.T) {
 		t.Errorf("expected status 400, got %d", rr.Code)
 	}
 
-	var resp ErrorResponse
+	var resp ProblemDetails
 	_ = json.NewDecoder(rr.Body).Decode(&resp)
-	if resp.Msg != "upload_time must be positive" {
-		t.Errorf("expected 'upload_time must be positive', got '%s'", resp.Msg)
+	if resp.Detail != "upload_time must be positive" {
+		t.Errorf("expected 'upload_time must be positive', got '%s'", resp.Detail)
 	}
 }
 
@@ -191,10 +191,10 @@ func TestPostStats_UploadTimeExceedsMax(t *testing.T) {
 		t.Errorf("expected status 400, got %d", rr.Code)
 	}
 
-	var resp ErrorResponse
+	var resp ProblemDetails
 	_ = json.NewDecoder(rr.Body).Decode(&resp)
-	if resp.Msg != "upload_time exceeds maximum" {
-		t.Errorf("expected 'upload_time exceeds maximum', got '%s'", resp.Msg)
+	if resp.Detail != "upload_time exceeds maximum" {
+		t.Errorf("expected 'upload_time exceeds maximum', got '%s'", resp.Detail)
 	}
 }
 
@@ -204,12 +204,16 @@ func TestGetStats_Success(t *testing.T) {
 	router := server.Router()
 
 	// First, add some telemetry data
-	device := server.store.devices["device-1"]
+	device := server.backend().(*Store).devices["device-1"]
 	device.HeartbeatCount = 5
+	device.CoveredMinutes = 5
 	device.FirstHeartbeat = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
 	device.LastHeartbeat = time.Date(2024, 1, 15, 10, 4, 0, 0, time.UTC)
 	device.UploadCount = 2
 	device.UploadTimeSum = 15 * time.Second
+	device.MinUploadTime = 5 * time.Second
+	device.MaxUploadTime = 10 * time.Second
+	device.LastUploadTime = 10 * time.Second
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
 	rr := httptest.NewRecorder()
@@ -229,9 +233,18 @@ func TestGetStats_Success(t *testing.T) {
 	}
 
 	// 15 seconds / 2 = 7.5 seconds
-	if resp.AvgUploadTime != "7.5s" {
+	if resp.AvgUploadTime.String() != "7.5s" {
 		t.Errorf("expected avg_upload_time '7.5s', got '%s'", resp.AvgUploadTime)
 	}
+	if resp.MinUploadTime.String() != "5s" {
+		t.Errorf("expected min_upload_time '5s', got '%s'", resp.MinUploadTime)
+	}
+	if resp.MaxUploadTime.String() != "10s" {
+		t.Errorf("expected max_upload_time '10s', got '%s'", resp.MaxUploadTime)
+	}
+	if resp.LastUploadTime.String() != "10s" {
+		t.Errorf("expected last_upload_time '10s', got '%s'", resp.LastUploadTime)
+	}
 }
 
 // TestGetStats_NotFound tests 404 for unknown device
@@ -299,10 +312,10 @@ func TestConfigurationError(t *testing.T) {
 			t.Errorf("%s %s: expected status 500, got %d", e.method, e.path, rr.Code)
 		}
 
-		var resp ErrorResponse
+		var resp ProblemDetails
 		_ = json.NewDecoder(rr.Body).Decode(&resp)
-		if resp.Msg != "server configuration error: failed to load devices.csv" {
-			t.Errorf("expected configuration error message, got '%s'", resp.Msg)
+		if resp.Detail != "server configuration error: failed to load devices.csv" {
+			t.Errorf("expected configuration error message, got '%s'", resp.Detail)
 		}
 	}
 }
@@ -326,3 +339,180 @@ func TestExtractDeviceID(t *testing.T) {
 		}
 	}
 }
+
+// TestPostHeartbeat_DeclaresInterval tests a device declaring its heartbeat cadence
+func TestPostHeartbeat_DeclaresInterval(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z", "heartbeat_interval": 30000000000}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	if server.backend().(*Store).devices["device-1"].HeartbeatInterval != 30*time.Second {
+		t.Errorf("expected interval 30s, got %v", server.backend().(*Store).devices["device-1"].HeartbeatInterval)
+	}
+}
+
+// TestPostHeartbeat_InvalidInterval tests 400 for an out-of-range heartbeat_interval
+func TestPostHeartbeat_InvalidInterval(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z", "heartbeat_interval": -1}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+
+	var resp ProblemDetails
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Detail != "heartbeat_interval must be positive" {
+		t.Errorf("expected 'heartbeat_interval must be positive', got '%s'", resp.Detail)
+	}
+}
+
+// TestDecommission tests that a decommissioned device rejects telemetry but keeps its stats
+func TestDecommission(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/decommission", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rr.Code)
+	}
+
+	posts := []struct {
+		path string
+		body string
+	}{
+		{"/api/v1/devices/device-1/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z"}`},
+		{"/api/v1/devices/device-1/stats", `{"sent_at": "2024-01-15T10:00:00Z", "upload_time": 5000000000}`},
+	}
+	for _, p := range posts {
+		req := httptest.NewRequest(http.MethodPost, p.path, bytes.NewBufferString(p.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusGone {
+			t.Errorf("POST %s: expected status 410, got %d", p.path, rr.Code)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected stats to stay queryable with status 200, got %d", rr.Code)
+	}
+	if server.backend().(*Store).devices["device-1"].UploadCount != 1 {
+		t.Error("telemetry was recorded for a decommissioned device")
+	}
+}
+
+// TestDecommission_NotFound tests 404 when decommissioning an unknown device
+func TestDecommission_NotFound(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/unknown-device/decommission", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+// TestPostHeartbeat_SentAtWindow tests the skew and replay limits and their error codes
+func TestPostHeartbeat_SentAtWindow(t *testing.T) {
+	store := NewStore()
+	store.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	server := NewServer(store, nil)
+	cfg := DefaultValidationConfig()
+	cfg.MaxPastAge = 7 * 24 * time.Hour
+	server.SetValidationConfig(cfg)
+	router := server.Router()
+
+	now := time.Now().UTC()
+	tests := []struct {
+		sentAt time.Time
+		status int
+		code   string
+	}{
+		{now, http.StatusNoContent, ""},
+		{now.Add(-6 * 24 * time.Hour), http.StatusNoContent, ""},
+		{now.Add(-8 * 24 * time.Hour), http.StatusBadRequest, errCodeSentAtTooOld},
+		{now.Add(5 * time.Minute), http.StatusBadRequest, errCodeSentAtFuture},
+	}
+
+	for _, tc := range tests {
+		body := `{"sent_at": "` + tc.sentAt.Format(time.RFC3339) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("sent_at %v: expected status %d, got %d", tc.sentAt, tc.status, rr.Code)
+		}
+		if tc.code != "" {
+			var resp ProblemDetails
+			_ = json.NewDecoder(rr.Body).Decode(&resp)
+			if resp.Code != tc.code {
+				t.Errorf("sent_at %v: expected code '%s', got '%s'", tc.sentAt, tc.code, resp.Code)
+			}
+		}
+	}
+}
+
+// TestValidateSentAt_Configurable tests that the skew and age limits follow the config
+func TestValidateSentAt_Configurable(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	cfg := ValidationConfig{MaxFutureSkew: 10 * time.Minute, MaxPastAge: time.Hour}
+
+	if err := validateSentAt(now.Add(5*time.Minute), cfg, now); err != nil {
+		t.Errorf("5m ahead should be within a 10m skew: %v", err)
+	}
+	if err := validateSentAt(now.Add(-2*time.Hour), cfg, now); err == nil {
+		t.Error("2h old should exceed a 1h max age")
+	}
+
+	cfg.MaxPastAge = 0
+	if err := validateSentAt(now.Add(-365*24*time.Hour), cfg, now); err != nil {
+		t.Errorf("max age 0 should disable the replay check: %v", err)
+	}
+}
+
+// TestPostHeartbeat_Versions tests that reported versions are stored
+func TestPostHeartbeat_Versions(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z", "firmware_version": "2.1.0", "agent_version": "0.9.3"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	device := server.backend().(*Store).devices["device-1"]
+	if device.FirmwareVersion != "2.1.0" || device.AgentVersion != "0.9.3" {
+		t.Errorf("expected versions 2.1.0/0.9.3, got %s/%s", device.FirmwareVersion, device.AgentVersion)
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Health checking for load balancers. HTTP balancers probe GET /healthz;
+// gRPC-aware ones (Envoy, ALB gRPC target groups) call the standard
+// grpc.health.v1.Health/Check over h2c. Both bypass auth and rate limiting,
+// since probes carry no API key.
+
+// Serving status values from grpc.health.v1.HealthCheckResponse.ServingStatus.
+const (
+	healthServing    = 1
+	healthNotServing = 2
+)
+
+// gRPC status codes used by the health service.
+const (
+	grpcOK            = 0
+	grpcInvalidArg    = 3
+	grpcNotFound      = 5
+	grpcUnimplemented = 12
+)
+
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+	// maxGRPCMessageSize bounds health requests, which only carry a service name
+	maxGRPCMessageSize = 4 << 10
+)
+
+// HealthResponse is the body of GET /healthz.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// healthy reports whether the server can serve traffic. A loading or
+// standby instance reports unhealthy so load balancers route elsewhere.
+func (s *Server) healthy() bool {
+	return s.configError() == nil && !s.standby.Load() && !s.loading.Load()
+}
+
+// HandleHealthz processes GET /healthz: 200 when serving, 503 otherwise.
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !s.healthy() {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "NOT_SERVING"})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "SERVING"})
+}
+
+// HandleGRPCHealth implements grpc.health.v1.Health. Only the overall server
+// status (service "") is known; Watch is not supported.
+func (s *Server) HandleGRPCHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != grpcHealthCheckPath {
+		writeGRPCStatus(w, grpcUnimplemented, "method not implemented")
+		return
+	}
+
+	msg, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArg, err.Error())
+		return
+	}
+	service, err := decodeHealthCheckRequest(msg)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArg, err.Error())
+		return
+	}
+	if service != "" {
+		writeGRPCStatus(w, grpcNotFound, "unknown service")
+		return
+	}
+
+	status := uint64(healthServing)
+	if !s.healthy() {
+		status = healthNotServing
+	}
+	// HealthCheckResponse{status = 1 (varint)}
+	resp := binary.AppendUvarint([]byte{0x08}, status)
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(frameGRPCMessage(resp))
+	writeGRPCStatus(w, grpcOK, "")
+}
+
+// writeGRPCStatus sets the grpc-status trailers that end every gRPC response.
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+// readGRPCMessage reads one length-prefixed gRPC message: a compression flag
+// byte, a 4-byte big-endian length, then the protobuf payload.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, errors.New("missing gRPC message")
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessageSize {
+		return nil, errors.New("message too large")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("truncated gRPC message")
+	}
+	return msg, nil
+}
+
+// frameGRPCMessage prefixes an uncompressed protobuf payload for the wire.
+func frameGRPCMessage(msg []byte) []byte {
+	framed := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(msg)))
+	return append(framed, msg...)
+}
+
+// decodeHealthCheckRequest extracts the service name (field 1) from a
+// HealthCheckRequest, skipping any fields it doesn't know.
+func decodeHealthCheckRequest(msg []byte) (string, error) {
+	var service string
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return "", errors.New("malformed request")
+		}
+		msg = msg[n:]
+
+		switch tag & 7 { // wire type
+		case 0: // varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return "", errors.New("malformed request")
+			}
+			msg = msg[n:]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return "", errors.New("malformed request")
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return "", errors.New("malformed request")
+			}
+			if tag>>3 == 1 {
+				service = string(msg[n : n+int(size)])
+			}
+			msg = msg[n+int(size):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return "", errors.New("malformed request")
+			}
+			msg = msg[4:]
+		default:
+			return "", errors.New("malformed request")
+		}
+	}
+	return service, nil
+}
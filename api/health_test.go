@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHealthz tests the HTTP health endpoint with and without a config error
+func TestHealthz(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAuth(APIKeys{"key-a": "org-a"}) // probes carry no API key
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+
+	broken := NewServer(NewStore(), errors.New("devices.csv missing"))
+	rr = httptest.NewRecorder()
+	broken.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rr.Code)
+	}
+}
+
+// grpcHealthCheck calls grpc.health.v1.Health/Check over h2c and returns the
+// grpc-status trailer and response payload.
+func grpcHealthCheck(t *testing.T, server *Server, path string, request []byte) (string, []byte) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(server.Router())
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{Protocols: new(http.Protocols)}}
+	client.Transport.(*http.Transport).Protocols.SetUnencryptedHTTP2(true)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(frameGRPCMessage(request)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	msg, _ := readGRPCMessage(resp.Body)
+	_, _ = io.Copy(io.Discard, resp.Body) // trailers arrive after the body
+	return resp.Trailer.Get("Grpc-Status"), msg
+}
+
+// TestGRPCHealthCheck tests the standard gRPC health Check method
+func TestGRPCHealthCheck(t *testing.T) {
+	status, msg := grpcHealthCheck(t, setupTestServer(), grpcHealthCheckPath, nil)
+	if status != "0" {
+		t.Errorf("expected grpc-status 0, got %q", status)
+	}
+	if !bytes.Equal(msg, []byte{0x08, healthServing}) {
+		t.Errorf("expected SERVING response, got %x", msg)
+	}
+
+	broken := NewServer(NewStore(), errors.New("devices.csv missing"))
+	if _, msg := grpcHealthCheck(t, broken, grpcHealthCheckPath, nil); !bytes.Equal(msg, []byte{0x08, healthNotServing}) {
+		t.Errorf("expected NOT_SERVING response, got %x", msg)
+	}
+}
+
+// TestGRPCHealthCheck_Errors tests unknown services and unsupported methods
+func TestGRPCHealthCheck_Errors(t *testing.T) {
+	server := setupTestServer()
+
+	// HealthCheckRequest{service: "other"}
+	if status, _ := grpcHealthCheck(t, server, grpcHealthCheckPath, append([]byte{0x0a, 5}, "other"...)); status != "5" {
+		t.Errorf("expected NOT_FOUND for unknown service, got %q", status)
+	}
+	if status, _ := grpcHealthCheck(t, server, "/grpc.health.v1.Health/Watch", nil); status != "12" {
+		t.Errorf("expected UNIMPLEMENTED for Watch, got %q", status)
+	}
+}
+
+// TestDecodeHealthCheckRequest tests parsing with unknown fields and bad input
+func TestDecodeHealthCheckRequest(t *testing.T) {
+	// field 2 varint, then field 1 = "svc"
+	service, err := decodeHealthCheckRequest([]byte{0x10, 0x01, 0x0a, 3, 's', 'v', 'c'})
+	if err != nil || service != "svc" {
+		t.Errorf("expected svc, got %q (%v)", service, err)
+	}
+	if _, err := decodeHealthCheckRequest([]byte{0x0a, 10, 's'}); err == nil {
+		t.Error("expected error for truncated field")
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Per-device history is kept as hourly buckets in a fixed-size ring, so memory
+// stays bounded (~40 KB per active device) no matter how long the server runs.
+const (
+	historyBucketSize = time.Hour
+	historyBuckets    = 30 * 24 // 30 days of hourly buckets
+
+	// maxHistoryPoints bounds the size of a single history response
+	maxHistoryPoints = 1000
+)
+
+// HistoryBucket holds the telemetry received during one hour.
+type HistoryBucket struct {
+	Start          time.Time
+	HeartbeatCount int64
+	Minutes        uint64 // bit m is set if a heartbeat arrived in minute m
+	UploadCount    int64
+	UploadTimeSum  time.Duration
+}
+
+// deviceHistory is a ring of hourly buckets indexed by hour number.
+type deviceHistory struct {
+	buckets []HistoryBucket
+	latest  time.Time // start of the newest bucket written; housekeeping drops rings gone idle
+
+	// When the ring was created for a device that already had telemetry,
+	// after a restart or eviction; only telemetry sent since is all in it
+	since time.Time
+}
+
+// historyFor returns the device's history, creating it on first use and
+// evicting another device's if the fleet is at its cap.
+// Callers must hold s.mu for writing.
+func (s *Store) historyFor(deviceID string) *deviceHistory {
+	h, exists := s.history[deviceID]
+	if !exists {
+		if s.limits.HistoryDevices > 0 && len(s.history) >= s.limits.HistoryDevices {
+			s.evictHistoryLocked(lowWater(s.limits.HistoryDevices))
+		}
+		h = &deviceHistory{buckets: make([]HistoryBucket, historyBuckets)}
+		if device := s.devices[deviceID]; device != nil && (device.HeartbeatCount > 0 || device.UploadCount > 0) {
+			h.since = time.Now()
+		}
+		s.history[deviceID] = h
+	}
+	return h
+}
+
+// slot returns the ring index for the bucket starting at start.
+func (h *deviceHistory) slot(start time.Time) int {
+	hour := start.Unix() / int64(historyBucketSize/time.Second)
+	n := int64(len(h.buckets))
+	return int(((hour % n) + n) % n)
+}
+
+// filled returns how many buckets hold telemetry.
+func (h *deviceHistory) filled() int {
+	n := 0
+	for _, b := range h.buckets {
+		if !b.Start.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// bucket returns the bucket covering t, recycling the slot if it holds an
+// older hour. It returns nil if t is older than the hour now in its slot,
+// i.e. it has fallen out of the retention window.
+func (h *deviceHistory) bucket(t time.Time) *HistoryBucket {
+	start := t.UTC().Truncate(historyBucketSize)
+	b := &h.buckets[h.slot(start)]
+
+	switch {
+	case b.Start.Equal(start):
+		return b
+	case start.Before(b.Start):
+		return nil
+	default:
+		*b = HistoryBucket{Start: start}
+		if start.After(h.latest) {
+			h.latest = start
+		}
+		return b
+	}
+}
+
+// History returns copies of the device's non-empty buckets that start in
+// [from, to), oldest first, along with the device's heartbeat interval.
+func (s *Store) History(ctx context.Context, deviceID string, from, to time.Time) ([]HistoryBucket, time.Duration, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer s.mu.RUnlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return nil, 0, ErrDeviceNotFound
+	}
+	interval := device.EffectiveInterval()
+
+	h, exists := s.history[deviceID]
+	if !exists {
+		return nil, interval, nil
+	}
+
+	// Buckets older than the ring can't be present, so skip scanning them
+	start := from.UTC().Truncate(historyBucketSize)
+	if oldest := to.Add(-historyBucketSize * historyBuckets); start.Before(oldest) {
+		start = oldest.UTC().Truncate(historyBucketSize)
+	}
+
+	var result []HistoryBucket
+	for ; start.Before(to); start = start.Add(historyBucketSize) {
+		b := h.buckets[h.slot(start)]
+		if b.Start.Equal(start) && !start.Before(from) {
+			result = append(result, b)
+		}
+	}
+	return result, interval, nil
+}
+
+// HistoryPoint is one step of a history response.
+type HistoryPoint struct {
+	Start          time.Time `json:"start"`
+	HeartbeatCount int64     `json:"heartbeat_count"`
+	UploadCount    int64     `json:"upload_count"`
+	Uptime         float64   `json:"uptime"`
+	AvgUploadTime  Duration  `json:"avg_upload_time"`
+}
+
+// HistoryResponse is the body of GET /stats/history.
+type HistoryResponse struct {
+	DeviceID string         `json:"device_id"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Step     Duration       `json:"step"`
+	Points   []HistoryPoint `json:"points"`
+}
+
+// buildHistoryPoints rolls hourly buckets up into steps covering [from, to).
+// Every step gets a point, even if empty, so charts have a regular x-axis.
+// Maintenance in a step isn't expected to carry heartbeats.
+func buildHistoryPoints(buckets []HistoryBucket, from, to time.Time, step, interval time.Duration, maintenance maintenanceSchedule, format durationFormat) []HistoryPoint {
+	var points []HistoryPoint
+	i := 0
+	for start := from; start.Before(to); start = start.Add(step) {
+		end := start.Add(step)
+		point := HistoryPoint{Start: start}
+		var uploadSum time.Duration
+		var covered int64
+		for ; i < len(buckets) && buckets[i].Start.Before(end); i++ {
+			point.HeartbeatCount += buckets[i].HeartbeatCount
+			covered += buckets[i].coveredMinutes()
+			point.UploadCount += buckets[i].UploadCount
+			uploadSum += buckets[i].UploadTimeSum
+		}
+
+		// Uptime for the step: observed heartbeats vs expected at the device's cadence
+		if measured := step - maintenance.overlap(start, end); measured > 0 {
+			observed := observedHeartbeats(point.HeartbeatCount, covered, interval)
+			point.Uptime = format.percent(min(float64(observed)/(float64(measured)/float64(interval))*100, 100.0))
+		} else {
+			point.Uptime = 100
+		}
+		var avg time.Duration
+		if point.UploadCount > 0 {
+			avg = uploadSum / time.Duration(point.UploadCount)
+		}
+		point.AvgUploadTime = format.duration(avg)
+		points = append(points, point)
+	}
+	return points
+}
+
+// parseHistoryQuery reads from/to/step, defaulting to the last 24 hours in 1h steps.
+// Bounds are aligned to bucket boundaries.
+func parseHistoryQuery(r *http.Request, now time.Time) (from, to time.Time, step time.Duration, msg string) {
+	q := r.URL.Query()
+
+	to = now.UTC().Truncate(historyBucketSize).Add(historyBucketSize)
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, step, "to must be an RFC 3339 timestamp"
+		}
+		to = t.UTC().Truncate(historyBucketSize)
+	}
+
+	from = to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, step, "from must be an RFC 3339 timestamp"
+		}
+		from = t.UTC().Truncate(historyBucketSize)
+	}
+
+	step = historyBucketSize
+	if v := q.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d%historyBucketSize != 0 {
+			return from, to, step, "step must be a positive multiple of 1h"
+		}
+		step = d
+	}
+
+	if !from.Before(to) {
+		return from, to, step, "from must be before to"
+	}
+	if to.Sub(from)/step > maxHistoryPoints {
+		return from, to, step, "too many points; use a larger step or shorter range"
+	}
+	return from, to, step, ""
+}
+
+// HandleStatsHistory processes GET /api/v1/devices/{device_id}/stats/history
+func (s *Server) HandleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/stats/history", deviceID)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	from, to, step, msg := parseHistoryQuery(r, time.Now())
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	buckets, interval, err := s.store.History(r.Context(), deviceID, from, to)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	device, err := s.store.Device(r.Context(), deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HistoryResponse{
+		DeviceID: deviceID,
+		From:     from,
+		To:       to,
+		Step:     format.duration(step),
+		Points:   buildHistoryPoints(buckets, from, to, step, interval, device.maintenance, format),
+	})
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStore_History tests that telemetry lands in hourly buckets
+func TestStore_History(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+	s.RecordHeartbeat(t.Context(), "device-1", t1.Add(30*time.Minute))
+	s.RecordHeartbeat(t.Context(), "device-1", t1.Add(2*time.Hour))
+	s.RecordUploadStatAt(t.Context(), "device-1", 4*time.Second, t1.Add(10*time.Minute))
+
+	buckets, interval, err := s.History(t.Context(), "device-1", t1, t1.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if interval != defaultHeartbeatInterval {
+		t.Errorf("expected default interval, got %v", interval)
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 non-empty buckets, got %d", len(buckets))
+	}
+	if !buckets[0].Start.Equal(t1) || buckets[0].HeartbeatCount != 2 || buckets[0].UploadCount != 1 || buckets[0].UploadTimeSum != 4*time.Second {
+		t.Errorf("unexpected first bucket: %+v", buckets[0])
+	}
+	if !buckets[1].Start.Equal(t1.Add(2*time.Hour)) || buckets[1].HeartbeatCount != 1 {
+		t.Errorf("unexpected second bucket: %+v", buckets[1])
+	}
+
+	if _, _, err := s.History(t.Context(), "unknown", t1, t1.Add(time.Hour)); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("expected ErrDeviceNotFound for unknown device, got %v", err)
+	}
+}
+
+// TestStore_HistoryRingWraps tests that old buckets are recycled and late
+// events for recycled hours are dropped
+func TestStore_HistoryRingWraps(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	later := t1.Add(historyBucketSize * historyBuckets) // same ring slot as t1
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+	s.RecordHeartbeat(t.Context(), "device-1", later)
+	s.RecordHeartbeat(t.Context(), "device-1", t1) // too old for the slot now
+
+	if buckets, _, _ := s.History(t.Context(), "device-1", t1, t1.Add(time.Hour)); len(buckets) != 0 {
+		t.Errorf("expected recycled bucket to be gone, got %+v", buckets)
+	}
+	buckets, _, _ := s.History(t.Context(), "device-1", later, later.Add(time.Hour))
+	if len(buckets) != 1 || buckets[0].HeartbeatCount != 1 {
+		t.Errorf("expected one heartbeat in the new bucket, got %+v", buckets)
+	}
+}
+
+// TestGetStatsHistory tests the history endpoint with a multi-hour step
+func TestGetStatsHistory(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 60 {
+		server.store.RecordHeartbeat(t.Context(), "device-1", t1.Add(time.Duration(i)*time.Minute))
+	}
+	server.store.RecordUploadStatAt(t.Context(), "device-1", 2*time.Second, t1.Add(time.Hour))
+	server.store.RecordUploadStatAt(t.Context(), "device-1", 4*time.Second, t1.Add(time.Hour))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats/history?from=2024-01-15T10:00:00Z&to=2024-01-15T14:00:00Z&step=2h", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp HistoryResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if len(resp.Points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(resp.Points))
+	}
+	first := resp.Points[0]
+	// 60 heartbeats over a 2h step at 1/min = 50%
+	if first.HeartbeatCount != 60 || first.Uptime != 50.0 {
+		t.Errorf("unexpected first point: %+v", first)
+	}
+	if first.UploadCount != 2 || first.AvgUploadTime.String() != "3s" {
+		t.Errorf("unexpected upload stats: %+v", first)
+	}
+	// Empty steps are still returned so charts stay aligned
+	if second := resp.Points[1]; second.HeartbeatCount != 0 || second.Uptime != 0 {
+		t.Errorf("expected empty second point, got %+v", second)
+	}
+}
+
+// TestGetStatsHistory_InvalidQuery tests rejection of malformed parameters
+func TestGetStatsHistory_InvalidQuery(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"bad from", "from=yesterday"},
+		{"bad to", "to=now"},
+		{"step not hourly", "step=30m"},
+		{"negative step", "step=-1h"},
+		{"from after to", "from=2024-01-16T00:00:00Z&to=2024-01-15T00:00:00Z"},
+		{"too many points", "from=2020-01-01T00:00:00Z&to=2024-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats/history?"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+// TestGetStatsHistory_NotFound tests history for an unknown device
+func TestGetStatsHistory_NotFound(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/unknown-device/stats/history", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
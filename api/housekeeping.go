@@ -0,0 +1,436 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Housekeeping runs periodically on the active instance. Each pass prunes
+// devices decommissioned longer ago than the retention, frees history rings
+// that have received nothing within the ring's window (~40 KB each), and
+// samples store and runtime memory, so operators can see what a long-running
+// server is holding on to.
+
+// DefaultDecommissionRetention is how long decommissioned devices are kept
+// before housekeeping prunes them.
+const DefaultDecommissionRetention = 90 * 24 * time.Hour
+
+// CompactResult counts what one compaction pass freed.
+type CompactResult struct {
+	PrunedDevices    int `json:"pruned_devices"`
+	DroppedHistories int `json:"dropped_histories"`
+}
+
+// StoreUsage reports what the store holds, with an estimate of the memory it
+// takes. The estimate counts fixed-size structures and strings, not map overhead.
+type StoreUsage struct {
+	Devices        int   `json:"devices"`
+	Decommissioned int   `json:"decommissioned"`
+	HistoryRings   int   `json:"history_rings"`
+	UploadRecords  int   `json:"upload_records"`
+	DeadLetters    int   `json:"dead_letters"`
+	EstimatedBytes int64 `json:"estimated_bytes"`
+
+	Limits    MemoryLimits `json:"limits"` // zero is unlimited
+	Evictions Evictions    `json:"evictions"`
+}
+
+// Retention policies a compaction applies, as named in dry runs.
+const (
+	policyDecommissionRetention = "decommission_retention"
+	policyIdleHistory           = "idle_history"
+	policyDiagnosticsRetention  = "diagnostics_retention"
+)
+
+// RetentionImpact is what one retention policy frees, or would free.
+type RetentionImpact struct {
+	Policy             string `json:"policy"`
+	Devices            int    `json:"devices"`
+	HistoryRings       int    `json:"history_rings"`
+	HistoryBuckets     int    `json:"history_buckets"` // hourly buckets holding telemetry
+	UploadRecords      int    `json:"upload_records"`
+	GroupMemberships   int    `json:"group_memberships"`
+	MaintenanceWindows int    `json:"maintenance_windows"`
+	DiagnosticsBundles int    `json:"diagnostics_bundles"`
+	EstimatedBytes     int64  `json:"estimated_bytes"` // memory, estimated as for StoreUsage
+	DiskBytes          int64  `json:"disk_bytes"`
+}
+
+// compaction is what a compaction pass would remove.
+type compaction struct {
+	pruned map[string]bool // devices decommissioned before the cutoff
+	idle   []string        // other devices whose history ring has gone idle
+}
+
+// compactionLocked works out what compacting at now would remove, without
+// changing anything. The caller must hold s.mu.
+func (s *Store) compactionLocked(now time.Time, retention time.Duration) compaction {
+	c := compaction{pruned: make(map[string]bool)}
+	if retention > 0 {
+		cutoff := now.Add(-retention)
+		for id, device := range s.devices {
+			if !device.DecommissionedAt.IsZero() && device.DecommissionedAt.Before(cutoff) {
+				c.pruned[id] = true
+			}
+		}
+	}
+	oldest := now.Add(-historyBucketSize * historyBuckets)
+	for id, h := range s.history {
+		if !c.pruned[id] && !h.latest.After(oldest) {
+			c.idle = append(c.idle, id)
+		}
+	}
+	return c
+}
+
+// Compact prunes devices decommissioned before now minus retention, along
+// with their history, recent uploads, group memberships and maintenance
+// windows, and drops history rings with no bucket inside the ring's window.
+// A zero retention keeps decommissioned devices forever.
+func (s *Store) Compact(ctx context.Context, now time.Time, retention time.Duration) (CompactResult, error) {
+	if err := s.lock(ctx); err != nil {
+		return CompactResult{}, err
+	}
+	defer s.mu.Unlock()
+
+	c := s.compactionLocked(now, retention)
+	var result CompactResult
+	for id := range c.pruned {
+		delete(s.devices, id)
+		delete(s.recentUploads, id)
+		if _, exists := s.history[id]; exists {
+			delete(s.history, id)
+			result.DroppedHistories++
+		}
+	}
+	if len(c.pruned) > 0 {
+		for _, group := range s.groups {
+			group.DeviceIDs = slices.DeleteFunc(group.DeviceIDs, func(id string) bool { return c.pruned[id] })
+		}
+		s.maintenance = slices.DeleteFunc(s.maintenance, func(w MaintenanceWindow) bool { return c.pruned[w.DeviceID] })
+	}
+	result.PrunedDevices = len(c.pruned)
+
+	for _, id := range c.idle {
+		delete(s.history, id)
+	}
+	result.DroppedHistories += len(c.idle)
+	return result, nil
+}
+
+// PlanCompact reports what Compact would free at now, per policy, without
+// changing anything.
+func (s *Store) PlanCompact(ctx context.Context, now time.Time, retention time.Duration) ([]RetentionImpact, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	c := s.compactionLocked(now, retention)
+	decommissioned := RetentionImpact{Policy: policyDecommissionRetention, Devices: len(c.pruned)}
+	for id := range c.pruned {
+		decommissioned.EstimatedBytes += deviceBytes(s.devices[id])
+		if h, exists := s.history[id]; exists {
+			decommissioned.HistoryRings++
+			decommissioned.HistoryBuckets += h.filled()
+			decommissioned.EstimatedBytes += historyRingBytes
+		}
+		if ring, exists := s.recentUploads[id]; exists {
+			decommissioned.UploadRecords += len(ring.records)
+			for _, rec := range ring.records {
+				decommissioned.EstimatedBytes += uploadRecordBytes(rec)
+			}
+		}
+	}
+	if len(c.pruned) > 0 {
+		for _, group := range s.groups {
+			for _, id := range group.DeviceIDs {
+				if c.pruned[id] {
+					decommissioned.GroupMemberships++
+					decommissioned.EstimatedBytes += int64(len(id))
+				}
+			}
+		}
+		for _, w := range s.maintenance {
+			if c.pruned[w.DeviceID] {
+				decommissioned.MaintenanceWindows++
+				decommissioned.EstimatedBytes += int64(unsafe.Sizeof(w))
+			}
+		}
+	}
+
+	idle := RetentionImpact{Policy: policyIdleHistory, HistoryRings: len(c.idle)}
+	for _, id := range c.idle {
+		idle.HistoryBuckets += s.history[id].filled()
+		idle.EstimatedBytes += historyRingBytes
+	}
+	return []RetentionImpact{decommissioned, idle}, nil
+}
+
+// historyRingBytes is the size of one device's history ring.
+const historyRingBytes = historyBuckets * int64(unsafe.Sizeof(HistoryBucket{}))
+
+// deviceBytes estimates a device's registry entry and aggregates.
+func deviceBytes(device *DeviceStats) int64 {
+	return int64(unsafe.Sizeof(*device)) + int64(len(device.ID)+len(device.Org)+len(device.Facility)+len(device.Room)+len(device.FirmwareVersion)+len(device.AgentVersion))
+}
+
+// uploadRecordBytes estimates one recent upload record.
+func uploadRecordBytes(rec UploadRecord) int64 {
+	return int64(unsafe.Sizeof(rec)) + int64(len(rec.UploadID)+len(rec.FileType))
+}
+
+// Usage reports what the store holds and estimates its memory.
+func (s *Store) Usage(ctx context.Context) (StoreUsage, error) {
+	if err := s.rlock(ctx); err != nil {
+		return StoreUsage{}, err
+	}
+	usage := StoreUsage{Limits: s.limits, Evictions: s.evictions}
+	var bytes int64
+	for _, device := range s.devices {
+		usage.Devices++
+		if !device.DecommissionedAt.IsZero() {
+			usage.Decommissioned++
+		}
+		bytes += deviceBytes(device)
+	}
+	usage.HistoryRings = len(s.history)
+	bytes += int64(usage.HistoryRings) * historyRingBytes
+	for _, ring := range s.recentUploads {
+		usage.UploadRecords += len(ring.records)
+		for _, rec := range ring.records {
+			bytes += uploadRecordBytes(rec)
+		}
+	}
+	s.mu.RUnlock()
+
+	usage.EstimatedBytes = bytes
+	return usage, nil
+}
+
+// storeUsage reports what the store holds, along with the server's dead
+// letters. Only dead letters are counted for backends outside process
+// memory.
+func (s *Server) storeUsage(ctx context.Context) (StoreUsage, error) {
+	var usage StoreUsage
+	if bounded, ok := s.backend().(MemoryBounded); ok {
+		var err error
+		if usage, err = bounded.Usage(ctx); err != nil {
+			return StoreUsage{}, err
+		}
+	}
+	var bytes int64
+	usage.DeadLetters, bytes = s.deadLetters.usage()
+	usage.EstimatedBytes += bytes
+	usage.Evictions.DeadLetters = s.deadLetters.droppedCount()
+	return usage, nil
+}
+
+// usage returns how many dead letters are queued and their approximate size.
+func (q *deadLetterQueue) usage() (int, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bytes := int64(len(q.entries)) * int64(unsafe.Sizeof(DeadLetter{}))
+	for _, dl := range q.entries {
+		bytes += int64(len(dl.Org) + len(dl.DeviceID) + len(dl.Type) + len(dl.Payload) + len(dl.Reason) + len(dl.Code))
+	}
+	return len(q.entries), bytes
+}
+
+// RuntimeMemory samples the Go runtime's memory statistics.
+type RuntimeMemory struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+func readRuntimeMemory() RuntimeMemory {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return RuntimeMemory{
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapInuseBytes: ms.HeapInuse,
+		SysBytes:       ms.Sys,
+		NumGC:          ms.NumGC,
+		Goroutines:     runtime.NumGoroutine(),
+	}
+}
+
+// HousekeepingRun is the outcome of one housekeeping pass.
+type HousekeepingRun struct {
+	At              time.Time     `json:"at"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Compacted       CompactResult `json:"compacted"`
+	PrunedBundles   int           `json:"pruned_diagnostics_bundles,omitempty"` // expired diagnostics bundles deleted
+	Store           StoreUsage    `json:"store"`
+	Runtime         RuntimeMemory `json:"runtime"`
+}
+
+// housekeeping tracks the periodic housekeeping job.
+type housekeeping struct {
+	mu        sync.Mutex
+	interval  time.Duration    // protected by mu; zero until RunHousekeeping starts
+	retention time.Duration    // protected by mu
+	runs      int64            // protected by mu
+	totals    CompactResult    // protected by mu
+	last      *HousekeepingRun // protected by mu; nil until the first pass
+}
+
+// RunHousekeeping compacts the store every interval until ctx is cancelled,
+// pruning devices decommissioned more than retention ago; zero retention
+// keeps them forever.
+func (s *Server) RunHousekeeping(ctx context.Context, interval, retention time.Duration) {
+	s.housekeeping.mu.Lock()
+	s.housekeeping.interval, s.housekeeping.retention = interval, retention
+	s.housekeeping.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.housekeep(ctx, time.Now(), retention)
+		}
+	}
+}
+
+// housekeep runs one housekeeping pass and records its outcome.
+func (s *Server) housekeep(ctx context.Context, now time.Time, retention time.Duration) HousekeepingRun {
+	start := time.Now()
+	var compacted CompactResult
+	if compactor, ok := s.backend().(Compactor); ok {
+		var err error
+		compacted, err = compactor.Compact(ctx, now, retention)
+		if err != nil {
+			log.Printf("[ERROR] Housekeeping compaction failed: %v", err)
+		}
+		// Pruned devices' stats must not be served from the cache
+		s.stats.invalidate()
+	}
+	usage, err := s.storeUsage(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Housekeeping couldn't read store usage: %v", err)
+	}
+	run := HousekeepingRun{
+		At:        now.UTC(),
+		Compacted: compacted,
+		Store:     usage,
+		Runtime:   readRuntimeMemory(),
+	}
+	if s.diagnostics != nil {
+		run.PrunedBundles = s.diagnostics.prune(now)
+	}
+	run.DurationSeconds = time.Since(start).Seconds()
+
+	if compacted.PrunedDevices > 0 || compacted.DroppedHistories > 0 {
+		log.Printf("[INFO] Housekeeping pruned %d decommissioned devices and dropped %d idle histories", compacted.PrunedDevices, compacted.DroppedHistories)
+	}
+	if run.PrunedBundles > 0 {
+		log.Printf("[INFO] Housekeeping deleted %d expired diagnostics bundles", run.PrunedBundles)
+	}
+
+	s.housekeeping.mu.Lock()
+	defer s.housekeeping.mu.Unlock()
+	s.housekeeping.runs++
+	s.housekeeping.totals.PrunedDevices += compacted.PrunedDevices
+	s.housekeeping.totals.DroppedHistories += compacted.DroppedHistories
+	s.housekeeping.last = &run
+	return run
+}
+
+// HousekeepingResponse reports the housekeeping job and current memory use.
+type HousekeepingResponse struct {
+	Enabled          bool             `json:"enabled"`
+	IntervalSeconds  float64          `json:"interval_seconds,omitempty"`
+	RetentionSeconds float64          `json:"retention_seconds,omitempty"` // zero keeps decommissioned devices forever
+	Runs             int64            `json:"runs"`
+	Totals           CompactResult    `json:"totals"`
+	LastRun          *HousekeepingRun `json:"last_run,omitempty"`
+	Store            StoreUsage       `json:"store"`
+	Runtime          RuntimeMemory    `json:"runtime"`
+}
+
+// HandleHousekeeping processes GET /api/v1/admin/housekeeping
+func (s *Server) HandleHousekeeping(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/housekeeping")
+
+	s.housekeeping.mu.Lock()
+	resp := HousekeepingResponse{
+		Enabled:          s.housekeeping.interval > 0,
+		IntervalSeconds:  s.housekeeping.interval.Seconds(),
+		RetentionSeconds: s.housekeeping.retention.Seconds(),
+		Runs:             s.housekeeping.runs,
+		Totals:           s.housekeeping.totals,
+		LastRun:          s.housekeeping.last,
+	}
+	s.housekeeping.mu.Unlock()
+
+	usage, err := s.storeUsage(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	resp.Store = usage
+	resp.Runtime = readRuntimeMemory()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HousekeepingDryRunResponse is what a housekeeping run would free now.
+type HousekeepingDryRunResponse struct {
+	At               time.Time         `json:"at"`
+	RetentionSeconds float64           `json:"retention_seconds"` // zero keeps decommissioned devices forever
+	Policies         []RetentionImpact `json:"policies"`
+}
+
+// HandleHousekeepingDryRun processes GET /api/v1/admin/housekeeping/dry-run.
+// It evaluates compaction and diagnostics retention as a run would, at the
+// configured retention or ?retention=, without deleting anything.
+func (s *Server) HandleHousekeepingDryRun(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/housekeeping/dry-run")
+
+	s.housekeeping.mu.Lock()
+	retention := s.housekeeping.retention
+	if s.housekeeping.interval <= 0 {
+		retention = DefaultDecommissionRetention
+	}
+	s.housekeeping.mu.Unlock()
+	if v := r.URL.Query().Get("retention"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "retention must be a non-negative duration (e.g. 720h or 30d)")
+			return
+		}
+		retention = d
+	}
+
+	now := time.Now()
+	resp := HousekeepingDryRunResponse{
+		At:               now.UTC(),
+		RetentionSeconds: retention.Seconds(),
+		Policies:         []RetentionImpact{},
+	}
+	if compactor, ok := s.backend().(Compactor); ok {
+		impacts, err := compactor.PlanCompact(r.Context(), now, retention)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		resp.Policies = append(resp.Policies, impacts...)
+	}
+	if s.diagnostics != nil {
+		resp.Policies = append(resp.Policies, s.diagnostics.expired(now))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
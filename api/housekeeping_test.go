@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStoreCompact tests pruning old decommissioned devices and idle history
+func TestStoreCompact(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore()
+	for _, id := range []string{"retired", "recent", "idle", "active"} {
+		store.AddDevice(t.Context(), DeviceStats{ID: id})
+	}
+	store.RecordHeartbeat(t.Context(), "retired", now.Add(-100*24*time.Hour))
+	store.Decommission(t.Context(), "retired", now.Add(-95*24*time.Hour))
+	store.Decommission(t.Context(), "recent", now.Add(-time.Hour))
+	store.RecordHeartbeat(t.Context(), "idle", now.Add(-40*24*time.Hour))
+	store.RecordHeartbeat(t.Context(), "active", now.Add(-time.Hour))
+	store.CreateGroup(t.Context(), Group{Name: "lobby", DeviceIDs: []string{"active", "retired"}})
+	store.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "retired", Start: now, End: now.Add(time.Hour)})
+
+	result, err := store.Compact(t.Context(), now, DefaultDecommissionRetention)
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if result.PrunedDevices != 1 || result.DroppedHistories != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if deviceExists(t, store, "retired") || !deviceExists(t, store, "recent") || !deviceExists(t, store, "idle") {
+		t.Error("expected only the long-decommissioned device to be pruned")
+	}
+	if _, exists := store.history["active"]; !exists {
+		t.Error("expected the active device's history to be kept")
+	}
+	if group, _ := store.GetGroup(t.Context(), "", "lobby"); len(group.DeviceIDs) != 1 || group.DeviceIDs[0] != "active" {
+		t.Errorf("expected the pruned device to leave its group, got %v", group.DeviceIDs)
+	}
+	if windows, _ := store.ListMaintenance(t.Context(), "", ""); len(windows) != 0 {
+		t.Errorf("expected the pruned device's maintenance to be removed, got %v", windows)
+	}
+
+	// Zero retention keeps decommissioned devices
+	store.Decommission(t.Context(), "active", now.Add(-365*24*time.Hour))
+	if result, _ := store.Compact(t.Context(), now, 0); result.PrunedDevices != 0 || !deviceExists(t, store, "active") {
+		t.Errorf("expected no pruning with zero retention, got %+v", result)
+	}
+}
+
+// TestStorePlanCompact tests that a dry run reports what Compact would free
+// without changing anything
+func TestStorePlanCompact(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore()
+	for _, id := range []string{"retired", "idle", "active"} {
+		store.AddDevice(t.Context(), DeviceStats{ID: id})
+	}
+	store.RecordHeartbeat(t.Context(), "retired", now.Add(-100*24*time.Hour))
+	store.Decommission(t.Context(), "retired", now.Add(-95*24*time.Hour))
+	store.RecordHeartbeat(t.Context(), "idle", now.Add(-40*24*time.Hour))
+	store.RecordHeartbeat(t.Context(), "active", now.Add(-time.Hour))
+	store.CreateGroup(t.Context(), Group{Name: "lobby", DeviceIDs: []string{"active", "retired"}})
+	store.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "retired", Start: now, End: now.Add(time.Hour)})
+
+	impacts, err := store.PlanCompact(t.Context(), now, DefaultDecommissionRetention)
+	if err != nil {
+		t.Fatalf("PlanCompact failed: %v", err)
+	}
+	if len(impacts) != 2 {
+		t.Fatalf("expected two policies, got %+v", impacts)
+	}
+	decommissioned, idle := impacts[0], impacts[1]
+	if decommissioned.Policy != policyDecommissionRetention || decommissioned.Devices != 1 || decommissioned.HistoryRings != 1 ||
+		decommissioned.HistoryBuckets != 1 || decommissioned.GroupMemberships != 1 || decommissioned.MaintenanceWindows != 1 {
+		t.Errorf("unexpected decommission impact %+v", decommissioned)
+	}
+	if idle.Policy != policyIdleHistory || idle.HistoryRings != 1 || idle.EstimatedBytes != historyRingBytes {
+		t.Errorf("unexpected idle history impact %+v", idle)
+	}
+
+	// Nothing was changed, and Compact frees what was planned
+	if !deviceExists(t, store, "retired") || len(store.history) != 3 || len(store.maintenance) != 1 {
+		t.Fatal("expected the dry run to leave the store untouched")
+	}
+	result, _ := store.Compact(t.Context(), now, DefaultDecommissionRetention)
+	if result.PrunedDevices != decommissioned.Devices || result.DroppedHistories != decommissioned.HistoryRings+idle.HistoryRings {
+		t.Errorf("expected Compact to match the plan, got %+v", result)
+	}
+}
+
+// TestStoreUsage tests counting what the store holds
+func TestStoreUsage(t *testing.T) {
+	store := NewStore()
+	store.AddDevice(t.Context(), DeviceStats{ID: "device-1"})
+	store.AddDevice(t.Context(), DeviceStats{ID: "device-2"})
+	store.Decommission(t.Context(), "device-2", time.Now())
+	store.RecordHeartbeat(t.Context(), "device-1", time.Now())
+	store.RecordUploadStatAt(t.Context(), "device-1", time.Second, time.Now())
+	server := NewServer(store, nil)
+	server.deadLetters.add(DeadLetter{DeviceID: "device-3", Payload: "{}"})
+
+	usage, err := server.storeUsage(t.Context())
+	if err != nil {
+		t.Fatalf("storeUsage failed: %v", err)
+	}
+	if usage.Devices != 2 || usage.Decommissioned != 1 || usage.HistoryRings != 1 || usage.UploadRecords != 1 || usage.DeadLetters != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if usage.EstimatedBytes < historyBuckets {
+		t.Errorf("expected the history ring in the estimate, got %d bytes", usage.EstimatedBytes)
+	}
+}
+
+// TestHandleHousekeeping tests reporting housekeeping runs
+func TestHandleHousekeeping(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	get := func() HousekeepingResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/housekeeping", nil))
+		var resp HousekeepingResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d (%v)", rr.Code, err)
+		}
+		return resp
+	}
+
+	if resp := get(); resp.Enabled || resp.Runs != 0 || resp.LastRun != nil || resp.Store.Devices != 2 {
+		t.Errorf("unexpected response before any run %+v", resp)
+	}
+
+	server.store.Decommission(t.Context(), "device-2", time.Now().Add(-100*24*time.Hour))
+	server.housekeep(t.Context(), time.Now(), DefaultDecommissionRetention)
+	resp := get()
+	if resp.Runs != 1 || resp.Totals.PrunedDevices != 1 || resp.LastRun == nil || resp.Store.Devices != 1 || resp.Runtime.HeapAllocBytes == 0 {
+		t.Errorf("unexpected response after a run %+v", resp)
+	}
+}
+
+// TestHandleHousekeepingDryRun tests reporting what housekeeping would free
+func TestHandleHousekeepingDryRun(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.Decommission(t.Context(), "device-2", time.Now().Add(-10*24*time.Hour))
+
+	get := func(query string) (int, HousekeepingDryRunResponse) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/housekeeping/dry-run"+query, nil))
+		var resp HousekeepingDryRunResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp
+	}
+
+	// The default retention keeps a device decommissioned ten days ago
+	code, resp := get("")
+	if code != http.StatusOK || resp.RetentionSeconds != DefaultDecommissionRetention.Seconds() || len(resp.Policies) != 2 || resp.Policies[0].Devices != 0 {
+		t.Errorf("unexpected default dry run %d %+v", code, resp)
+	}
+	code, resp = get("?retention=7d")
+	if code != http.StatusOK || resp.Policies[0].Devices != 1 {
+		t.Errorf("expected a week's retention to prune one device, got %d %+v", code, resp)
+	}
+	if !deviceExists(t, server.store, "device-2") {
+		t.Error("expected the dry run not to prune")
+	}
+	for _, query := range []string{"?retention=-1h", "?retention=soon"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", query, code)
+		}
+	}
+}
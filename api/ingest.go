@@ -0,0 +1,351 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Bulk ingest for gateway boxes that proxy many cameras. The request body is
+// newline-delimited JSON (NDJSON), one telemetry record per line. Lines are
+// processed as they arrive and each gets a result line in the response, so a
+// gateway can retry exactly the lines that were rejected.
+//
+// With ?atomic=true the request is all or nothing instead: accepted lines
+// are staged on a store transaction, which is committed only if every line
+// is accepted. Results are then written once the outcome is known, and
+// valid lines of a failed batch are reported as aborted, and no longer count
+// toward the daily quota. Lines of an atomic
+// batch aren't dead-lettered, even when rejected: the gateway retries the
+// batch as a whole, and replaying one line from it would apply it alone.
+
+// maxIngestLineSize caps a single NDJSON line; telemetry records are tiny.
+const maxIngestLineSize = 64 * 1024
+
+// An atomic batch is held in memory until it commits, so it's capped;
+// streamed requests aren't.
+const (
+	maxAtomicIngestLines = 10000
+	maxAtomicIngestBytes = 16 << 20
+)
+
+// Telemetry record types accepted by the ingest endpoint.
+const (
+	ingestTypeHeartbeat = "heartbeat"
+	ingestTypeUpload    = "upload"
+)
+
+// IngestRecord is one line of an ingest request.
+type IngestRecord struct {
+	DeviceID string `json:"device_id"`
+	Type     string `json:"type"` // "heartbeat" or "upload"
+
+	SentAt            time.Time `json:"sent_at"`
+	UploadTime        int64     `json:"upload_time,omitempty"`        // nanoseconds, uploads only
+	UploadID          string    `json:"upload_id,omitempty"`          // uploads only
+	FileType          string    `json:"file_type,omitempty"`          // uploads only
+	HeartbeatInterval int64     `json:"heartbeat_interval,omitempty"` // nanoseconds, heartbeats only
+	UploadInterval    int64     `json:"upload_interval,omitempty"`    // nanoseconds, heartbeats only
+	FirmwareVersion   string    `json:"firmware_version,omitempty"`
+	AgentVersion      string    `json:"agent_version,omitempty"`
+	BatteryPct        *float64  `json:"battery_pct,omitempty"`     // heartbeats only
+	TemperatureC      *float64  `json:"temperature_c,omitempty"`   // heartbeats only
+	DiskFreeBytes     *int64    `json:"disk_free_bytes,omitempty"` // heartbeats only
+}
+
+// IngestResult is the outcome of one line, written back as NDJSON.
+type IngestResult struct {
+	Line     int    `json:"line"`
+	DeviceID string `json:"device_id,omitempty"`
+	Status   string `json:"status"` // "accepted" or "rejected"
+	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// atomicIngest is the telemetry staged by an atomic ingest request.
+type atomicIngest struct {
+	tx       Tx
+	events   []TelemetryEvent   // staged on tx, counted and published on commit
+	admitted map[usageKey]int64 // lines counted toward a daily quota, refunded if the batch aborts
+}
+
+type atomicIngestKey struct{}
+
+// withAtomicIngest returns a copy of ctx whose recorded telemetry is staged
+// on batch instead of written.
+func withAtomicIngest(ctx context.Context, batch *atomicIngest) context.Context {
+	return context.WithValue(ctx, atomicIngestKey{}, batch)
+}
+
+// atomicIngestFromContext returns the request's atomic batch, or nil.
+func atomicIngestFromContext(ctx context.Context) *atomicIngest {
+	batch, _ := ctx.Value(atomicIngestKey{}).(*atomicIngest)
+	return batch
+}
+
+// commitIngest applies an atomic batch, then counts its events on the
+// request's receipt and publishes them, as record does for each event.
+// Batches bypass the async write queue, since the response reports
+// whether they were applied.
+func (s *Server) commitIngest(ctx context.Context, batch *atomicIngest) error {
+	if err := batch.tx.Commit(ctx); err != nil {
+		return err
+	}
+	rc := receiptFromContext(ctx)
+	for _, ev := range batch.events {
+		if rc != nil {
+			rc.add()
+			rc.done()
+		}
+		s.publish(ev)
+	}
+	return nil
+}
+
+// ingestRecord validates and stores one record, returning its result.
+// Rejected lines are kept in the dead-letter queue, unless they're part of
+// an atomic batch.
+func (s *Server) ingestRecord(r *http.Request, line int, data []byte) IngestResult {
+	result := IngestResult{Line: line, Status: "rejected"}
+	deadLetter := func(deviceID, kind string, err error) {
+		if atomicIngestFromContext(r.Context()) == nil {
+			s.deadLetter(r, deviceID, kind, data, err)
+		}
+	}
+
+	var rec IngestRecord
+	if err := decodeJSON(data, &rec); err != nil {
+		result.Error = err.Error()
+		result.Code = validationCode(err)
+		deadLetter("", "", err)
+		return result
+	}
+	result.DeviceID = rec.DeviceID
+
+	if err := s.applyRecord(r, rec); err != nil {
+		result.Error = err.Error()
+		result.Code = validationCode(err)
+		deadLetter(rec.DeviceID, rec.Type, err)
+		return result
+	}
+
+	result.Status = "accepted"
+	return result
+}
+
+// applyRecord validates and stores one telemetry record.
+func (s *Server) applyRecord(r *http.Request, rec IngestRecord) error {
+	if err := s.checkDeviceVisible(r, rec.DeviceID); err != nil {
+		return err
+	}
+	retired, err := s.store.IsDecommissioned(r.Context(), rec.DeviceID)
+	if err != nil {
+		return err
+	}
+	if retired {
+		return ErrDeviceDecommissioned
+	}
+	// Records carry no signature, so signing devices report only through
+	// their own endpoints; otherwise a replayed dead letter could bypass it
+	signed, err := s.signatureRequired(r.Context(), rec.DeviceID)
+	if err != nil {
+		return err
+	}
+	if signed {
+		return errSignatureRequired
+	}
+	// Nor do they carry a device token
+	tokened, err := s.deviceTokenRequired(r.Context(), rec.DeviceID)
+	if err != nil {
+		return err
+	}
+	if tokened {
+		return errDeviceTokenRequired
+	}
+
+	// Only valid lines count toward the organization's daily quota
+	now := time.Now()
+	switch rec.Type {
+	case ingestTypeHeartbeat:
+		req := HeartbeatRequest{
+			SentAt:            rec.SentAt,
+			HeartbeatInterval: rec.HeartbeatInterval,
+			UploadInterval:    rec.UploadInterval,
+			FirmwareVersion:   rec.FirmwareVersion,
+			AgentVersion:      rec.AgentVersion,
+			BatteryPct:        rec.BatteryPct,
+			TemperatureC:      rec.TemperatureC,
+			DiskFreeBytes:     rec.DiskFreeBytes,
+		}
+		if err := validateHeartbeatRequest(&req, s.validation, now); err != nil {
+			return err
+		}
+		if err := s.admitTelemetry(r.Context(), rec.DeviceID, now); err != nil {
+			return err
+		}
+		// Ingest comes from gateways and replays, not the device's own network,
+		// so neither its address nor its delay is recorded
+		return s.recordHeartbeat(r.Context(), rec.DeviceID, "", time.Time{}, &req)
+	case ingestTypeUpload:
+		req := UploadStatRequest{SentAt: rec.SentAt, UploadTime: rec.UploadTime, UploadID: rec.UploadID, FileType: rec.FileType}
+		if err := validateUploadStatRequest(&req, s.validation, now); err != nil {
+			return err
+		}
+		if err := s.admitTelemetry(r.Context(), rec.DeviceID, now); err != nil {
+			return err
+		}
+		return s.recordUploadStat(r.Context(), rec.DeviceID, &req)
+	default:
+		return &validationError{code: errCodeIngestType, field: "type", msg: "type must be heartbeat or upload"}
+	}
+}
+
+// HandleIngest processes POST /api/v1/ingest
+func (s *Server) HandleIngest(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/ingest")
+
+	// In receipt mode the whole request shares one receipt, processed once
+	// every accepted line is applied
+	if s.receipts != nil {
+		rc, _ := s.receipts.open(orgFromContext(r.Context()), "", "")
+		defer rc.seal()
+		r = r.WithContext(withReceipt(r.Context(), rc))
+		w.Header().Set(receiptHeader, rc.id)
+	}
+
+	// An atomic request stages its lines and commits them together
+	var batch *atomicIngest
+	if r.URL.Query().Get("atomic") == "true" {
+		if r.ContentLength > maxAtomicIngestBytes {
+			writeErrorCode(w, http.StatusRequestEntityTooLarge, errCodeBatchTooLarge, fmt.Sprintf("atomic batch must be at most %d bytes", maxAtomicIngestBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxAtomicIngestBytes)
+		tx, err := s.store.Begin(r.Context())
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		batch = &atomicIngest{tx: tx}
+		defer batch.tx.Rollback()
+		r = r.WithContext(withAtomicIngest(r.Context(), batch))
+	}
+
+	// Results are streamed while the body is still being read
+	rc := http.NewResponseController(w)
+	_ = rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+
+	// An atomic batch's results are held back until it's committed
+	var results []IngestResult
+	emit := func(result IngestResult) error {
+		if batch != nil {
+			results = append(results, result)
+			return nil
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		_ = rc.Flush()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxIngestLineSize)
+
+	line, accepted, rejected := 0, 0, 0
+	tooLarge := "" // why an atomic batch stopped being read
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if batch != nil && len(results) == maxAtomicIngestLines {
+			tooLarge = fmt.Sprintf("atomic batch must be at most %d lines", maxAtomicIngestLines)
+			break
+		}
+
+		// Stop at the deadline or client disconnect; the response is already
+		// streaming, so report where processing stopped instead of a 503
+		if err := r.Context().Err(); err != nil {
+			log.Printf("[WARN] Ingest stopped at line %d: %v", line, err)
+			_ = emit(IngestResult{Line: line, Status: "rejected", Error: "request cancelled: " + err.Error()})
+			break
+		}
+
+		result := s.ingestRecord(r, line, scanner.Bytes())
+		if result.Status == "accepted" {
+			accepted++
+		} else {
+			rejected++
+		}
+		if err := emit(result); err != nil {
+			log.Printf("[ERROR] Failed to write ingest result: %v", err)
+			return
+		}
+	}
+
+	// A read error (e.g. an oversized line) ends the stream; report it as a
+	// final result so the client knows where processing stopped.
+	var maxBytesErr *http.MaxBytesError
+	switch err := scanner.Err(); {
+	case errors.As(err, &maxBytesErr):
+		line++
+		tooLarge = fmt.Sprintf("atomic batch must be at most %d bytes", maxAtomicIngestBytes)
+	case err != nil:
+		log.Printf("[ERROR] Ingest stream failed at line %d: %v", line+1, err)
+		_ = emit(IngestResult{Line: line + 1, Status: "rejected", Error: "unreadable line: " + err.Error()})
+	}
+	if tooLarge != "" {
+		log.Printf("[WARN] Atomic ingest refused at line %d: %s", line, tooLarge)
+		_ = emit(IngestResult{Line: line, Status: "rejected", Error: tooLarge, Code: errCodeBatchTooLarge})
+	}
+
+	if batch != nil {
+		abort := ""
+		for _, result := range results {
+			if result.Status != "accepted" {
+				abort = fmt.Sprintf("line %d was rejected", result.Line)
+				break
+			}
+		}
+		if abort == "" {
+			if err := s.commitIngest(r.Context(), batch); err != nil {
+				log.Printf("[WARN] Atomic ingest failed to commit: %v", err)
+				abort = err.Error()
+			}
+		}
+		if abort != "" {
+			for i, result := range results {
+				if result.Status == "accepted" {
+					results[i] = IngestResult{Line: result.Line, DeviceID: result.DeviceID, Status: "rejected", Error: "not applied: " + abort, Code: errCodeBatchAborted}
+				}
+			}
+			accepted, rejected = 0, accepted+rejected
+			s.usage.refund(batch.admitted)
+		}
+		for _, result := range results {
+			if err := enc.Encode(result); err != nil {
+				log.Printf("[ERROR] Failed to write ingest result: %v", err)
+				return
+			}
+		}
+	}
+
+	log.Printf("[INFO] Ingest complete: %d accepted, %d rejected", accepted, rejected)
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper to post an NDJSON body and decode the per-line results
+func postIngest(t *testing.T, router http.Handler, body string) []IngestResult {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var results []IngestResult
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var result IngestResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("invalid result line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// TestIngest_MixedRecords tests per-line accept/reject results
+func TestIngest_MixedRecords(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := strings.Join([]string{
+		`{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`,
+		`{"device_id": "device-1", "type": "upload", "upload_time": 5000000000}`,
+		``,
+		`{"device_id": "unknown-device", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`,
+		`{"device_id": "device-2", "type": "upload", "upload_time": 0}`,
+		`{"device_id": "device-2", "type": "reboot"}`,
+		`{not json`,
+	}, "\n")
+
+	results := postIngest(t, router, body)
+
+	expected := []struct {
+		line   int
+		status string
+		error  string
+	}{
+		{1, "accepted", ""},
+		{2, "accepted", ""},
+		{4, "rejected", "device not found"},
+		{5, "rejected", "upload_time must be positive"},
+		{6, "rejected", "type must be heartbeat or upload"},
+		{7, "rejected", "invalid JSON"},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d: %+v", len(expected), len(results), results)
+	}
+	for i, e := range expected {
+		r := results[i]
+		if r.Line != e.line || r.Status != e.status || r.Error != e.error {
+			t.Errorf("result %d: expected line %d %s %q, got line %d %s %q", i, e.line, e.status, e.error, r.Line, r.Status, r.Error)
+		}
+	}
+
+	device := server.backend().(*Store).devices["device-1"]
+	if device.HeartbeatCount != 1 || device.UploadCount != 1 {
+		t.Errorf("expected 1 heartbeat and 1 upload recorded, got %d and %d", device.HeartbeatCount, device.UploadCount)
+	}
+}
+
+// TestIngest_ValidationCode tests that validation codes are reported per line
+func TestIngest_ValidationCode(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	results := postIngest(t, router, `{"device_id": "device-1", "type": "heartbeat", "sent_at": "`+future+`"}`)
+
+	if len(results) != 1 || results[0].Code != errCodeSentAtFuture {
+		t.Errorf("expected code %s, got %+v", errCodeSentAtFuture, results)
+	}
+}
+
+// TestIngest_OversizedLine tests that an unreadable line ends the stream with a result
+func TestIngest_OversizedLine(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}` + "\n" +
+		strings.Repeat("x", maxIngestLineSize+1)
+	results := postIngest(t, router, body)
+
+	if len(results) != 2 || results[0].Status != "accepted" || results[1].Line != 2 || results[1].Status != "rejected" {
+		t.Errorf("expected accepted line 1 then rejected line 2, got %+v", results)
+	}
+}
+
+// TestIngest_Atomic tests that an atomic ingest applies every line or none
+func TestIngest_Atomic(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	post := func(body string) []IngestResult {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest?atomic=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var results []IngestResult
+		for line := range strings.SplitSeq(strings.TrimSpace(rr.Body.String()), "\n") {
+			var result IngestResult
+			if err := json.Unmarshal([]byte(line), &result); err != nil {
+				t.Fatalf("invalid result line %q: %v", line, err)
+			}
+			results = append(results, result)
+		}
+		return results
+	}
+
+	results := post(strings.Join([]string{
+		`{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`,
+		`{"device_id": "device-2", "type": "upload", "upload_time": 0}`,
+		`{"device_id": "device-2", "type": "upload", "upload_time": 5000000000}`,
+	}, "\n"))
+	if len(results) != 3 || results[1].Code != errCodeUploadTimeRange {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Status != "rejected" || results[i].Code != errCodeBatchAborted || results[i].Error != "not applied: line 2 was rejected" {
+			t.Errorf("line %d: expected the valid line to be aborted, got %+v", i+1, results[i])
+		}
+	}
+	store := server.backend().(*Store)
+	if store.devices["device-1"].HeartbeatCount != 0 || store.devices["device-2"].UploadCount != 0 {
+		t.Error("expected nothing recorded from a failed batch")
+	}
+	if list := listDeadLetters(t, router, ""); len(list.DeadLetters) != 0 {
+		t.Errorf("expected no dead letters from an atomic batch, got %+v", list.DeadLetters)
+	}
+
+	results = post(strings.Join([]string{
+		`{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`,
+		`{"device_id": "device-2", "type": "upload", "upload_time": 5000000000}`,
+	}, "\n"))
+	if len(results) != 2 || results[0].Status != "accepted" || results[1].Status != "accepted" {
+		t.Fatalf("expected both lines accepted, got %+v", results)
+	}
+	if store.devices["device-1"].HeartbeatCount != 1 || store.devices["device-2"].UploadCount != 1 {
+		t.Error("expected the committed batch to be recorded")
+	}
+}
+
+// failingCommitStore is a store whose transactions fail to commit, as when
+// a device is removed while its batch is staged
+type failingCommitStore struct {
+	Storage
+}
+
+func (s failingCommitStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.Storage.Begin(ctx)
+	return failingCommitTx{tx}, err
+}
+
+type failingCommitTx struct {
+	Tx
+}
+
+func (tx failingCommitTx) Commit(context.Context) error {
+	tx.Rollback()
+	return fmt.Errorf("device device-2: %w", ErrDeviceNotFound)
+}
+
+// TestIngest_AtomicCommitFails tests that a batch whose every line is valid
+// but whose commit fails is reported as aborted and records nothing
+func TestIngest_AtomicCommitFails(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	server.store = &cachedStorage{Storage: failingCommitStore{store}, stats: server.stats}
+	router := server.Router()
+
+	body := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}` + "\n" +
+		`{"device_id": "device-2", "type": "upload", "upload_time": 5000000000}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest?atomic=true", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 result lines, got %q", rr.Body.String())
+	}
+	for i, line := range lines {
+		var result IngestResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("invalid result line %q: %v", line, err)
+		}
+		if result.Status != "rejected" || result.Code != errCodeBatchAborted || result.Error != "not applied: device device-2: device not found" {
+			t.Errorf("line %d: expected the line to be aborted, got %+v", i+1, result)
+		}
+	}
+	if store.devices["device-1"].HeartbeatCount != 0 || store.devices["device-2"].UploadCount != 0 {
+		t.Error("expected nothing recorded from a failed commit")
+	}
+	if list := listDeadLetters(t, router, ""); len(list.DeadLetters) != 0 {
+		t.Errorf("expected no dead letters from an aborted batch, got %+v", list.DeadLetters)
+	}
+}
+
+// TestIngest_AtomicTooLarge tests that an atomic batch past its line or byte
+// cap is refused and records nothing
+func TestIngest_AtomicTooLarge(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	line := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`
+	body := strings.Repeat(line+"\n", maxAtomicIngestLines+1)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest?atomic=true", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var last IngestResult
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("invalid result line %q: %v", lines[len(lines)-1], err)
+	}
+	if len(lines) != maxAtomicIngestLines+1 || last.Line != maxAtomicIngestLines+1 || last.Code != errCodeBatchTooLarge {
+		t.Errorf("expected the line past the cap refused, got %d results ending %+v", len(lines), last)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 0 {
+		t.Errorf("expected nothing recorded from an oversized batch, got %d heartbeats", device.HeartbeatCount)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/ingest?atomic=true", strings.NewReader(strings.Repeat("\n", maxAtomicIngestBytes+1)))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), errCodeBatchTooLarge) {
+		t.Errorf("expected status 413 for an oversized body, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A body without a length is cut off as it's read
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/ingest?atomic=true", strings.NewReader(strings.Repeat("\n", maxAtomicIngestBytes+1)))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), errCodeBatchTooLarge) {
+		t.Errorf("expected a streamed oversized body refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
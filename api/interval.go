@@ -0,0 +1,108 @@
+package api
+
+import (
+	"slices"
+	"time"
+)
+
+// Devices that neither declare a heartbeat_interval nor have one in the CSV
+// get their cadence inferred from the median of their recent heartbeat gaps,
+// so a camera beating every 5 minutes isn't scored at 20% uptime against
+// the one-minute default. The median ignores the odd late or missed
+// heartbeat, and gaps spanning maintenance aren't sampled.
+
+// DefaultIntervalSamples is how many recent gaps the detected interval is
+// the median of.
+const DefaultIntervalSamples = 15
+
+// minIntervalSamples is how many gaps must be seen before an interval is
+// detected; until then the default applies.
+const minIntervalSamples = 5
+
+// offlineIntervals is how many expected heartbeats a device may miss before
+// the offline monitor alerts, when that takes longer than its default threshold.
+const offlineIntervals = 3
+
+// Sources of a device's effective heartbeat interval.
+const (
+	intervalSourceConfigured = "configured" // CSV column or declared in heartbeats
+	intervalSourceDetected   = "detected"
+	intervalSourceDefault    = "default"
+)
+
+// EffectiveInterval returns the heartbeat cadence the device is held to:
+// its configured or declared interval, else the detected one, else
+// defaultHeartbeatInterval.
+func (device *DeviceStats) EffectiveInterval() time.Duration {
+	switch {
+	case device.HeartbeatInterval > 0:
+		return device.HeartbeatInterval
+	case device.detectedInterval > 0:
+		return device.detectedInterval
+	default:
+		return defaultHeartbeatInterval
+	}
+}
+
+// IntervalSource reports where EffectiveInterval comes from.
+func (device *DeviceStats) IntervalSource() string {
+	switch {
+	case device.HeartbeatInterval > 0:
+		return intervalSourceConfigured
+	case device.detectedInterval > 0:
+		return intervalSourceDetected
+	default:
+		return intervalSourceDefault
+	}
+}
+
+// SetIntervalSamples sets how many recent heartbeat gaps the detected
+// interval is the median of; zero disables detection. Gaps already sampled
+// and intervals already detected are discarded.
+func (s *Store) SetIntervalSamples(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.intervalSamples = max(n, 0)
+	for _, device := range s.devices {
+		device.recentGaps, device.nextGap, device.detectedInterval = nil, 0, 0
+	}
+}
+
+// sampleGapLocked adds a heartbeat gap to the device's recent gaps and
+// updates its detected interval once enough have been seen. Intervals of a
+// second or more are rounded to the second, so jitter doesn't make the
+// interval wander.
+// Callers must hold s.mu for writing.
+func (s *Store) sampleGapLocked(device *DeviceStats, gap time.Duration) {
+	if s.intervalSamples <= 0 {
+		return
+	}
+	if len(device.recentGaps) < s.intervalSamples {
+		device.recentGaps = append(device.recentGaps, gap)
+	} else {
+		device.recentGaps[device.nextGap] = gap
+		device.nextGap = (device.nextGap + 1) % len(device.recentGaps)
+	}
+	if len(device.recentGaps) < min(minIntervalSamples, s.intervalSamples) {
+		return
+	}
+
+	interval := medianDuration(device.recentGaps)
+	if interval >= time.Second {
+		interval = interval.Round(time.Second)
+	}
+	device.detectedInterval = interval
+}
+
+// medianDuration returns the median of values, averaging the middle two
+// when there's an even number of them.
+func medianDuration(values []time.Duration) time.Duration {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMedianDuration tests odd and even sample counts
+func TestMedianDuration(t *testing.T) {
+	if got := medianDuration([]time.Duration{5, 1, 3}); got != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+	if got := medianDuration([]time.Duration{4, 1, 3, 2}); got != 2 {
+		t.Errorf("expected 2, got %v", got)
+	}
+}
+
+// TestIntervalDetection tests inferring a five-minute cadence despite a missed heartbeat
+func TestIntervalDetection(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera"}
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	// Four gaps aren't enough to detect a cadence
+	at := base
+	for i := 0; i < 5; i++ {
+		s.RecordHeartbeat(t.Context(), "camera", at)
+		at = at.Add(5*time.Minute + time.Duration(i)*100*time.Millisecond)
+	}
+	if device, _ := s.Device(t.Context(), "camera"); device.IntervalSource() != intervalSourceDefault {
+		t.Fatalf("expected the default interval after 4 gaps, got %s", device.IntervalSource())
+	}
+
+	// A missed heartbeat (a 10m gap) doesn't move the median
+	at = at.Add(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		s.RecordHeartbeat(t.Context(), "camera", at)
+		at = at.Add(5 * time.Minute)
+	}
+	device, _ := s.Device(t.Context(), "camera")
+	if device.EffectiveInterval() != 5*time.Minute || device.IntervalSource() != intervalSourceDetected {
+		t.Fatalf("expected a detected 5m interval, got %v (%s)", device.EffectiveInterval(), device.IntervalSource())
+	}
+	if stats := device.Stats(); stats.Uptime < 85 {
+		t.Errorf("expected uptime against the detected cadence, got %v", stats.Uptime)
+	}
+
+	// A configured interval wins over the detected one
+	s.SetHeartbeatInterval(t.Context(), "camera", 2*time.Minute)
+	if device, _ := s.Device(t.Context(), "camera"); device.EffectiveInterval() != 2*time.Minute || device.IntervalSource() != intervalSourceConfigured {
+		t.Errorf("expected the configured interval, got %v (%s)", device.EffectiveInterval(), device.IntervalSource())
+	}
+
+	// Disabling detection forgets detected intervals
+	s.SetHeartbeatInterval(t.Context(), "camera", 0)
+	s.SetIntervalSamples(0)
+	s.RecordHeartbeat(t.Context(), "camera", at)
+	if device, _ := s.Device(t.Context(), "camera"); device.IntervalSource() != intervalSourceDefault {
+		t.Errorf("expected the default interval with detection disabled, got %s", device.IntervalSource())
+	}
+}
+
+// TestOfflineMonitor_DetectedInterval tests that slow devices get a threshold of several intervals
+func TestOfflineMonitor_DetectedInterval(t *testing.T) {
+	s := NewStore()
+	s.devices["slow"] = &DeviceStats{ID: "slow"}
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 6 {
+		s.RecordHeartbeat(t.Context(), "slow", base.Add(time.Duration(i)*10*time.Minute))
+	}
+	last := base.Add(50 * time.Minute)
+
+	m := NewOfflineMonitor(s, 5*time.Minute)
+	if offline, _ := m.Check(t.Context(), last.Add(15*time.Minute)); len(offline) != 0 {
+		t.Errorf("expected a 10m device to be online after 15m of silence, got %v", offline)
+	}
+	if offline, _ := m.Check(t.Context(), last.Add(31*time.Minute)); len(offline) != 1 {
+		t.Errorf("expected a 10m device offline after 3 missed heartbeats, got %v", offline)
+	}
+}
+
+// TestHandleGetStats_HeartbeatInterval tests reporting the interval and its source
+func TestHandleGetStats_HeartbeatInterval(t *testing.T) {
+	server := setupTestServer()
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Now().Add(-time.Minute))
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Now())
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats?format=seconds", nil))
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp["heartbeat_interval"] != 60.0 || resp["heartbeat_interval_source"] != intervalSourceDefault {
+		t.Errorf("unexpected interval %v (%v)", resp["heartbeat_interval"], resp["heartbeat_interval_source"])
+	}
+}
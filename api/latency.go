@@ -0,0 +1,82 @@
+package api
+
+import (
+	"slices"
+	"time"
+)
+
+// Heartbeat latency is the delay between a heartbeat's sent_at and the
+// server receiving it, over HTTP or UDP. It's network transit plus any
+// clock offset, so a facility whose heartbeats arrive seconds late has a
+// congested uplink or a drifting clock, either worth a look. Ingested and
+// replayed heartbeats arrive long after they were sent and aren't measured.
+
+// latencySamples is how many recent delays the p95 is taken over.
+const latencySamples = 100
+
+// recordLatency adds a heartbeat's delay to the device's average and recent
+// delays, and updates their p95. It's computed here rather than when read,
+// since copies of the device share the ring with the store.
+func (device *DeviceStats) recordLatency(latency time.Duration) {
+	device.LatencyCount++
+	device.LatencySum += latency
+	if len(device.recentLatencies) < latencySamples {
+		device.recentLatencies = append(device.recentLatencies, latency)
+	} else {
+		device.recentLatencies[device.nextLatency] = latency
+		device.nextLatency = (device.nextLatency + 1) % len(device.recentLatencies)
+	}
+
+	sorted := slices.Clone(device.recentLatencies)
+	slices.Sort(sorted)
+	rank := (95*len(sorted) + 99) / 100 // nearest rank
+	device.latencyP95 = sorted[rank-1]
+}
+
+// HeartbeatLatency summarizes a device's heartbeat delays.
+type HeartbeatLatency struct {
+	Avg     time.Duration // over every measured heartbeat
+	Samples int64
+
+	// Over the latest latencySamples delays; Recent is zero after a
+	// restore, since snapshots keep the average but not the recent delays
+	P95    time.Duration
+	Recent int
+}
+
+// HeartbeatLatency returns the device's heartbeat delays. The bool is false
+// until a live heartbeat has been measured.
+func (device *DeviceStats) HeartbeatLatency() (HeartbeatLatency, bool) {
+	if device.LatencyCount == 0 {
+		return HeartbeatLatency{}, false
+	}
+	return HeartbeatLatency{
+		Avg:     device.LatencySum / time.Duration(device.LatencyCount),
+		Samples: device.LatencyCount,
+		P95:     device.latencyP95,
+		Recent:  len(device.recentLatencies),
+	}, true
+}
+
+// StatsV2Latency is the delay between the device sending heartbeats and the
+// server receiving them.
+type StatsV2Latency struct {
+	AvgSeconds float64  `json:"avg_seconds"`
+	P95Seconds *float64 `json:"p95_seconds"` // over the latest 100 heartbeats; null after a restart until one arrives
+	Samples    int64    `json:"samples"`
+}
+
+// latencyResponse returns the device's heartbeat delays for v2 stats, or nil
+// before the first is measured.
+func latencyResponse(device DeviceStats) *StatsV2Latency {
+	latency, ok := device.HeartbeatLatency()
+	if !ok {
+		return nil
+	}
+	resp := &StatsV2Latency{AvgSeconds: latency.Avg.Seconds(), Samples: latency.Samples}
+	if latency.Recent > 0 {
+		p95 := latency.P95.Seconds()
+		resp.P95Seconds = &p95
+	}
+	return resp
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordLatency tests the average over every delay and the p95 over recent ones
+func TestRecordLatency(t *testing.T) {
+	device := &DeviceStats{ID: "camera"}
+	if _, ok := device.HeartbeatLatency(); ok {
+		t.Error("expected no latency before a heartbeat is measured")
+	}
+
+	// An early burst of slow heartbeats falls out of the p95 window
+	for range 10 {
+		device.recordLatency(10 * time.Second)
+	}
+	for i := range latencySamples {
+		device.recordLatency(time.Duration(i+1) * time.Millisecond)
+	}
+	latency, _ := device.HeartbeatLatency()
+	want := HeartbeatLatency{
+		Avg:     (100*time.Second + 5050*time.Millisecond) / 110,
+		Samples: 110,
+		P95:     95 * time.Millisecond,
+		Recent:  latencySamples,
+	}
+	if latency != want {
+		t.Errorf("expected %+v, got %+v", want, latency)
+	}
+}
+
+// TestHeartbeatLatency tests measuring live heartbeats but not ingested ones
+func TestHeartbeatLatency(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	sentAt := time.Now().Add(-2 * time.Second).UTC().Format(time.RFC3339Nano)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat",
+		strings.NewReader(`{"sent_at": "`+sentAt+`"}`)))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rr.Code, rr.Body)
+	}
+	postIngest(t, router, `{"type": "heartbeat", "device_id": "device-1", "sent_at": "`+
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)+`"}`+"\n")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v2/devices/device-1/stats", nil))
+	var resp StatsV2Response
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	latency := resp.Latency
+	if latency == nil || latency.Samples != 1 || latency.P95Seconds == nil ||
+		latency.AvgSeconds < 2 || latency.AvgSeconds > 3 || *latency.P95Seconds != latency.AvgSeconds {
+		t.Errorf("expected one heartbeat about 2s late, got %+v", latency)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v2/devices/device-2/stats", nil))
+	resp = StatsV2Response{}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Latency != nil {
+		t.Errorf("expected no latency without heartbeats, got %+v", resp.Latency)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Active/standby deployment: two instances share a lock and only the holder
+// ingests telemetry and runs background jobs. The standby reports NOT_SERVING
+// on its health checks, so the load balancer routes to the active instance,
+// and rejects API requests with 503 until it acquires the lock. The lock is
+// released when the active process exits, so failover needs no coordinator.
+
+// LeaderElector decides which instance is active. Backends such as a
+// Consul session or a Kubernetes lease can implement it alongside the file lock.
+type LeaderElector interface {
+	// TryAcquire attempts to become the leader without blocking.
+	TryAcquire() (bool, error)
+	// Release gives up leadership.
+	Release() error
+}
+
+// RunLeaderElection retries TryAcquire every interval until it succeeds,
+// returning true, or ctx is cancelled, returning false.
+func RunLeaderElection(ctx context.Context, elector LeaderElector, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		acquired, err := elector.TryAcquire()
+		if err != nil {
+			log.Printf("[ERROR] Leader election failed: %v", err)
+		}
+		if acquired {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetStandby switches the server between standby and active.
+func (s *Server) SetStandby(standby bool) {
+	s.standby.Store(standby)
+}
+
+// Standby reports whether the server is waiting to become active.
+func (s *Server) Standby() bool {
+	return s.standby.Load()
+}
+
+// rejectStandby answers every API request with 503 while the server is on standby.
+func (s *Server) rejectStandby(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.standby.Load() {
+			writeErrorCode(w, http.StatusServiceUnavailable, errCodeStandby, "standby instance")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package api
+
+import "errors"
+
+// FileLockElector is only supported on Unix, where flock is available.
+type FileLockElector struct{}
+
+// NewFileLockElector creates an elector that always fails on this platform.
+func NewFileLockElector(path string) *FileLockElector {
+	return &FileLockElector{}
+}
+
+// TryAcquire always fails: file-lock election needs flock.
+func (e *FileLockElector) TryAcquire() (bool, error) {
+	return false, errors.New("file lock leader election is not supported on this platform")
+}
+
+// Release is a no-op.
+func (e *FileLockElector) Release() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeElector grants leadership after a number of attempts.
+type fakeElector struct {
+	attempts, grantAfter int
+}
+
+func (e *fakeElector) TryAcquire() (bool, error) {
+	e.attempts++
+	return e.attempts >= e.grantAfter, nil
+}
+
+func (e *fakeElector) Release() error { return nil }
+
+// TestRunLeaderElection tests retrying until the lock is granted
+func TestRunLeaderElection(t *testing.T) {
+	elector := &fakeElector{grantAfter: 3}
+	if !RunLeaderElection(context.Background(), elector, time.Millisecond) {
+		t.Fatal("expected leadership to be acquired")
+	}
+	if elector.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", elector.attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if RunLeaderElection(ctx, &fakeElector{grantAfter: 100}, time.Millisecond) {
+		t.Error("expected election to stop when the context is cancelled")
+	}
+}
+
+// TestStandby tests that a standby rejects API requests and fails health checks
+func TestStandby(t *testing.T) {
+	server := setupTestServer()
+	server.SetStandby(true)
+	router := server.Router()
+
+	for _, path := range []string{"/api/v1/devices/device-1/stats", "/healthz"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status 503 on standby, got %d", path, rr.Code)
+		}
+	}
+
+	server.SetStandby(false)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 once active, got %d", rr.Code)
+	}
+}
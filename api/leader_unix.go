@@ -0,0 +1,54 @@
+//go:build unix
+
+package api
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FileLockElector elects the instance holding an exclusive flock on a shared
+// file. The kernel drops the lock when the holder exits, however it exits.
+// flock is unreliable on some network filesystems; use a local or
+// lock-aware shared volume.
+type FileLockElector struct {
+	path string
+	file *os.File
+}
+
+// NewFileLockElector creates an elector using the lock file at path.
+func NewFileLockElector(path string) *FileLockElector {
+	return &FileLockElector{path: path}
+}
+
+// TryAcquire takes the lock if no other process holds it.
+func (e *FileLockElector) TryAcquire() (bool, error) {
+	if e.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	e.file = file
+	return true, nil
+}
+
+// Release unlocks the file so a standby can take over.
+func (e *FileLockElector) Release() error {
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close() // closing the descriptor releases the flock
+	e.file = nil
+	return err
+}
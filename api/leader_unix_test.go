@@ -0,0 +1,30 @@
+//go:build unix
+
+package api
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestFileLockElector tests that only one elector holds the lock at a time
+func TestFileLockElector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	active := NewFileLockElector(path)
+	standby := NewFileLockElector(path)
+
+	if ok, err := active.TryAcquire(); !ok || err != nil {
+		t.Fatalf("expected first elector to acquire, got %v, %v", ok, err)
+	}
+	if ok, err := standby.TryAcquire(); ok || err != nil {
+		t.Fatalf("expected second elector to wait, got %v, %v", ok, err)
+	}
+
+	if err := active.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if ok, err := standby.TryAcquire(); !ok || err != nil {
+		t.Errorf("expected standby to take over, got %v, %v", ok, err)
+	}
+	_ = standby.Release()
+}
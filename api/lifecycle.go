@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Devices are listed in the CSV days before they're installed, and bench
+// testing sends heartbeats long before the device is on a wall. A device is
+// provisioned until it's activated, either by the CSV's activated_at column
+// or POST /api/v1/devices/{id}/activate, and retired once decommissioned.
+// Heartbeats sent before activation aren't counted, and an activated
+// device's uptime is measured from its activation rather than its first
+// heartbeat.
+
+// Lifecycle states, in the only order a device moves through them.
+const (
+	lifecycleProvisioned = "provisioned"
+	lifecycleActive      = "active"
+	lifecycleRetired     = "retired"
+)
+
+// Errors for activations the store refuses.
+var (
+	errActivateRetired  = &validationError{code: errCodeLifecycleTransition, msg: "device is retired and can't be activated"}
+	errActivatedAtRange = &validationError{code: errCodeActivatedAtRange, field: "activated_at",
+		msg: "activated_at falls between the device's first and last counted heartbeats; choose a time before the first or after the last"}
+)
+
+// Lifecycle returns the device's lifecycle state at now. Devices with a
+// future activated_at stay provisioned until then.
+func (device *DeviceStats) Lifecycle(now time.Time) string {
+	switch {
+	case !device.DecommissionedAt.IsZero():
+		return lifecycleRetired
+	case !device.ActivatedAt.IsZero() && !now.Before(device.ActivatedAt):
+		return lifecycleActive
+	default:
+		return lifecycleProvisioned
+	}
+}
+
+// Activate marks the device active from at, which may be in the past or the
+// future. An active device can be activated again to correct the time.
+// Heartbeats counted before at are discarded, so at must not fall inside
+// the span of counted heartbeats; it returns errActivatedAtRange if it does,
+// errActivateRetired for a decommissioned device, and ErrDeviceNotFound.
+func (s *Store) Activate(ctx context.Context, deviceID string, at time.Time) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	if !device.DecommissionedAt.IsZero() {
+		return errActivateRetired
+	}
+	return activateLocked(device, at)
+}
+
+// activateLocked sets the device's activation time, discarding heartbeat
+// aggregates that predate it.
+// Callers must hold s.mu for writing.
+func activateLocked(device *DeviceStats, at time.Time) error {
+	if device.HeartbeatCount > 0 && at.After(device.FirstHeartbeat) {
+		if !at.After(device.LastHeartbeat) {
+			return errActivatedAtRange
+		}
+		resetHeartbeatsLocked(device)
+	}
+	device.ActivatedAt = at
+	device.addEvent(eventActivated, at, "")
+	return nil
+}
+
+// resetHeartbeatsLocked forgets the device's heartbeat aggregates, as if it
+// had never sent one. Hourly history is kept.
+// Callers must hold s.mu for writing.
+func resetHeartbeatsLocked(device *DeviceStats) {
+	device.HeartbeatCount, device.CoveredMinutes, device.CoalescedHeartbeats = 0, 0, 0
+	device.FirstHeartbeat = time.Time{}
+	device.LastHeartbeat = time.Time{}
+	device.HeartbeatGaps, device.MissedHeartbeats, device.JitterGaps, device.JitterSum = 0, 0, 0, 0
+	device.recentGaps, device.nextGap, device.detectedInterval = nil, 0, 0
+	device.LatencyCount, device.LatencySum = 0, 0
+	device.latencyP95, device.recentLatencies, device.nextLatency = 0, nil, 0
+}
+
+// ActivateRequest is the optional body of POST /api/v1/devices/{device_id}/activate.
+type ActivateRequest struct {
+	ActivatedAt time.Time `json:"activated_at"` // defaults to now
+}
+
+// LifecycleResponse reports a device's lifecycle state.
+type LifecycleResponse struct {
+	DeviceID    string    `json:"device_id"`
+	Lifecycle   string    `json:"lifecycle"`
+	ActivatedAt time.Time `json:"activated_at,omitzero"`
+}
+
+// HandleActivate processes POST /api/v1/devices/{device_id}/activate
+func (s *Server) HandleActivate(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] POST /api/v1/devices/%s/activate", deviceID)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	// The body is optional; without one the device is activated now
+	var req ActivateRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := decodeJSON(body, &req); err != nil {
+			log.Printf("[ERROR] Invalid JSON: %v", err)
+			writeValidationError(w, err)
+			return
+		}
+	}
+	if req.ActivatedAt.IsZero() {
+		req.ActivatedAt = time.Now()
+	}
+
+	err = s.store.Activate(r.Context(), deviceID, req.ActivatedAt.UTC())
+	switch {
+	case errors.Is(err, errActivateRetired):
+		writeErrorCode(w, http.StatusConflict, errCodeLifecycleTransition, err.Error())
+		return
+	case errors.Is(err, errActivatedAtRange):
+		writeValidationError(w, err)
+		return
+	case err != nil:
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	device, err := s.store.Device(r.Context(), deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	log.Printf("[INFO] Device activated: %s at %s", deviceID, device.ActivatedAt.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, LifecycleResponse{
+		DeviceID:    deviceID,
+		Lifecycle:   device.Lifecycle(time.Now()),
+		ActivatedAt: device.ActivatedAt,
+	})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDeviceLifecycle tests moving from provisioned to active to retired
+func TestDeviceLifecycle(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	device := DeviceStats{ID: "cam"}
+	if got := device.Lifecycle(now); got != lifecycleProvisioned {
+		t.Errorf("expected provisioned, got %s", got)
+	}
+	device.ActivatedAt = now.Add(time.Hour)
+	if got := device.Lifecycle(now); got != lifecycleProvisioned {
+		t.Errorf("expected provisioned before a scheduled activation, got %s", got)
+	}
+	if got := device.Lifecycle(now.Add(time.Hour)); got != lifecycleActive {
+		t.Errorf("expected active, got %s", got)
+	}
+	device.DecommissionedAt = now.Add(2 * time.Hour)
+	if got := device.Lifecycle(now.Add(3 * time.Hour)); got != lifecycleRetired {
+		t.Errorf("expected retired, got %s", got)
+	}
+}
+
+// TestStoreActivate tests that bench-test heartbeats don't count towards uptime
+func TestStoreActivate(t *testing.T) {
+	s := NewStore()
+	s.devices["cam"] = &DeviceStats{ID: "cam"}
+	bench := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "cam", bench)
+	s.RecordHeartbeat(t.Context(), "cam", bench.Add(time.Minute))
+
+	// Activation can't split the heartbeats already counted
+	if err := s.Activate(t.Context(), "cam", bench.Add(30*time.Second)); err != errActivatedAtRange {
+		t.Errorf("expected errActivatedAtRange, got %v", err)
+	}
+
+	installed := bench.Add(3 * 24 * time.Hour)
+	if err := s.Activate(t.Context(), "cam", installed); err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+	s.RecordHeartbeat(t.Context(), "cam", installed.Add(-time.Minute)) // late bench heartbeat
+	for i := 1; i <= 10; i++ {
+		if i != 5 {
+			s.RecordHeartbeat(t.Context(), "cam", installed.Add(time.Duration(i)*time.Minute))
+		}
+	}
+
+	device, _ := s.Device(t.Context(), "cam")
+	if device.HeartbeatCount != 9 || !device.FirstHeartbeat.Equal(installed.Add(time.Minute)) {
+		t.Fatalf("expected only post-activation heartbeats counted, got %d from %v", device.HeartbeatCount, device.FirstHeartbeat)
+	}
+	// Measured from activation: 9 of the 11 heartbeats expected from minute 0 to 10
+	if stats := device.Stats(); stats.Uptime < 81.8 || stats.Uptime > 81.9 {
+		t.Errorf("expected uptime of 9/11, got %v", stats.Uptime)
+	}
+
+	s.Decommission(t.Context(), "cam", installed.Add(time.Hour))
+	if err := s.Activate(t.Context(), "cam", installed); err != errActivateRetired {
+		t.Errorf("expected errActivateRetired, got %v", err)
+	}
+	if err := s.Activate(t.Context(), "unknown", installed); err != ErrDeviceNotFound {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+// TestParseDevicesCSV_ActivatedAt tests the activated_at column
+func TestParseDevicesCSV_ActivatedAt(t *testing.T) {
+	devices, err := parseDevicesCSV(strings.NewReader("device_id,activated_at\ncam-1,2024-06-01T08:00:00-06:00\ncam-2,\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if want := time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC); !devices[0].ActivatedAt.Equal(want) || !devices[1].ActivatedAt.IsZero() {
+		t.Errorf("unexpected activation times %v, %v", devices[0].ActivatedAt, devices[1].ActivatedAt)
+	}
+	if _, err := parseDevicesCSV(strings.NewReader("device_id,activated_at\ncam-1,tuesday\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an invalid activated_at error, got %v", err)
+	}
+}
+
+// TestHandleActivate tests activating through the API and reporting the lifecycle
+func TestHandleActivate(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	activate := func(id, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/devices/"+id+"/activate", bytes.NewBufferString(body)))
+		return rr
+	}
+
+	rr := activate("device-1", "")
+	var resp LifecycleResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK || resp.Lifecycle != lifecycleActive || resp.ActivatedAt.IsZero() {
+		t.Fatalf("unexpected response %d %+v (%v)", rr.Code, resp, err)
+	}
+
+	rr = activate("device-2", `{"activated_at": "2999-01-01T00:00:00Z"}`)
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Lifecycle != lifecycleProvisioned {
+		t.Errorf("expected a scheduled activation to stay provisioned, got %s", resp.Lifecycle)
+	}
+
+	server.store.Decommission(t.Context(), "device-2", time.Now())
+	if rr := activate("device-2", ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 activating a retired device, got %d", rr.Code)
+	}
+	if rr := activate("device-9", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown device, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil))
+	var list DeviceListResponse
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Devices) != 2 || list.Devices[0].Lifecycle != lifecycleActive || list.Devices[1].Lifecycle != lifecycleRetired {
+		t.Errorf("unexpected device list %+v", list.Devices)
+	}
+}
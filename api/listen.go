@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The server can listen on several addresses at once: a TCP port for
+// devices alongside a Unix socket for a local reverse proxy, or separate
+// IPv4 and IPv6 addresses on hosts that route only one of them internally.
+
+// ListenAddr is one address the server listens on.
+type ListenAddr struct {
+	Network string // "tcp" (dual-stack), "tcp4", "tcp6" or "unix"
+	Address string // host:port, or a socket path for unix
+}
+
+// String returns the address in the form ParseListenAddrs accepts.
+func (a ListenAddr) String() string {
+	if a.Network == "tcp" {
+		return a.Address
+	}
+	return a.Network + ":" + a.Address
+}
+
+// listenNetworks are the prefixes an address may carry.
+var listenNetworks = []string{"tcp4", "tcp6", "unix"}
+
+// ParseListenAddrs parses a comma-separated list of listen addresses. A bare
+// host:port is TCP; with an empty or unspecified host (":6733", "[::]:6733")
+// it accepts IPv4 and IPv6 on dual-stack hosts. "tcp4:" or "tcp6:" restricts
+// an address to one family, and "unix:/path" is a Unix domain socket.
+func ParseListenAddrs(spec string) ([]ListenAddr, error) {
+	var addrs []ListenAddr
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr := ListenAddr{Network: "tcp", Address: entry}
+		for _, network := range listenNetworks {
+			if rest, ok := strings.CutPrefix(entry, network+":"); ok {
+				addr = ListenAddr{Network: network, Address: rest}
+				break
+			}
+		}
+
+		if addr.Network == "unix" {
+			if addr.Address == "" {
+				return nil, fmt.Errorf("listen address %q: missing socket path", entry)
+			}
+		} else if _, port, err := net.SplitHostPort(addr.Address); err != nil || port == "" {
+			return nil, fmt.Errorf("listen address %q: expected host:port (IPv6 hosts in brackets, e.g. [::1]:6733) or unix:/path", entry)
+		}
+		if slices.Contains(addrs, addr) {
+			return nil, fmt.Errorf("listen address %q is listed twice", entry)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no listen addresses")
+	}
+	return addrs, nil
+}
+
+// unixSocketMode lets a reverse proxy in the server's group connect.
+const unixSocketMode = 0o660
+
+// Listen opens a listener on addr. A socket file left behind by an unclean
+// shutdown is removed first; any other file at the path is an error. The
+// socket is removed again when the listener closes.
+func Listen(addr ListenAddr) (net.Listener, error) {
+	if addr.Network != "unix" {
+		return net.Listen(addr.Network, addr.Address)
+	}
+
+	info, err := os.Lstat(addr.Address)
+	switch {
+	case err == nil && info.Mode().Type() == fs.ModeSocket:
+		// A live server still answers on the socket
+		if conn, err := net.Dial("unix", addr.Address); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", addr.Address)
+		}
+		if err := os.Remove(addr.Address); err != nil {
+			return nil, err
+		}
+	case err == nil:
+		return nil, fmt.Errorf("%s exists and is not a socket", addr.Address)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr.Address, unixSocketMode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseListenAddrs tests parsing TCP, single-family and Unix socket addresses
+func TestParseListenAddrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []ListenAddr
+		wantErr string
+	}{
+		{"default", ":6733", []ListenAddr{{"tcp", ":6733"}}, ""},
+		{"several", ":6733, unix:/run/sy.sock,tcp6:[::1]:6733,tcp4:10.0.0.1:6733", []ListenAddr{
+			{"tcp", ":6733"}, {"unix", "/run/sy.sock"}, {"tcp6", "[::1]:6733"}, {"tcp4", "10.0.0.1:6733"},
+		}, ""},
+		{"ipv6 without brackets", "::1:6733", nil, "expected host:port"},
+		{"missing port", "localhost", nil, "expected host:port"},
+		{"empty socket path", "unix:", nil, "missing socket path"},
+		{"duplicate", ":6733,:6733", nil, "listed twice"},
+		{"empty", " , ", nil, "no listen addresses"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListenAddrs(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
+
+// TestListen_UnixSocket tests replacing a stale socket and refusing a live one or another file
+func TestListen_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	addr := ListenAddr{Network: "unix", Address: filepath.Join(dir, "sy.sock")}
+
+	// A socket left behind by a crash: closed without removing the file
+	stale, err := net.Listen("unix", addr.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("expected the stale socket replaced, got %v", err)
+	}
+	if info, err := os.Stat(addr.Address); err != nil || info.Mode().Perm() != unixSocketMode {
+		t.Errorf("expected socket mode %o, got %v (%v)", unixSocketMode, info, err)
+	}
+
+	if _, err := Listen(addr); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected a live socket refused, got %v", err)
+	}
+
+	_ = listener.Close()
+	if _, err := os.Stat(addr.Address); !os.IsNotExist(err) {
+		t.Errorf("expected the socket removed on close, got %v", err)
+	}
+
+	file := filepath.Join(dir, "not-a-socket")
+	_ = os.WriteFile(file, nil, 0o644)
+	if _, err := Listen(ListenAddr{Network: "unix", Address: file}); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("expected a regular file refused, got %v", err)
+	}
+}
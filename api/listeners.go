@@ -0,0 +1,51 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// The API can be split across two listeners: reads (stats, lists, reports
+// and metrics) on an internal port, and everything else, chiefly device
+// ingest, on the port facility networks can reach. Both serve /healthz so
+// each can sit behind its own load balancer.
+
+// readMethods are the methods served by the read listener.
+var readMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// isRead reports whether the request only reads.
+func isRead(r *http.Request) bool {
+	return slices.Contains(readMethods, r.Method)
+}
+
+// ReadRouter serves the API's reads for a read-only listener. Other methods
+// get 405.
+func (s *Server) ReadRouter() http.Handler {
+	router := s.Router()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			log.Printf("[WARN] Rejected %s %s on the read-only listener", r.Method, r.URL.Path)
+			w.Header().Set("Allow", strings.Join(readMethods, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "this listener is read-only")
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// WriteRouter serves everything ReadRouter doesn't, for the listener that
+// takes device telemetry when reads are served separately. GET and HEAD get
+// 404, except for /healthz. OPTIONS is still answered, so gateways can
+// discover the methods a resource accepts.
+func (s *Server) WriteRouter() http.Handler {
+	router := s.Router()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && isRead(r) && r.URL.Path != "/healthz" {
+			writeError(w, http.StatusNotFound, "reads are served on the read-only listener")
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
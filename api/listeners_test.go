@@ -0,0 +1,47 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSplitListeners tests serving reads and ingest on separate routers
+func TestSplitListeners(t *testing.T) {
+	server := setupTestServer()
+	read, write := server.ReadRouter(), server.WriteRouter()
+	heartbeat := func() *http.Request {
+		body := `{"sent_at": "` + time.Now().UTC().Format(time.RFC3339) + `"}`
+		return httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		req     *http.Request
+		want    int
+	}{
+		{"ingest on write", write, heartbeat(), http.StatusNoContent},
+		{"ingest on read", read, heartbeat(), http.StatusMethodNotAllowed},
+		{"stats on read", read, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil), http.StatusOK},
+		{"stats on write", write, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil), http.StatusNotFound},
+		{"metrics on write", write, httptest.NewRequest(http.MethodGet, "/metrics", nil), http.StatusNotFound},
+		{"healthz on write", write, httptest.NewRequest(http.MethodGet, "/healthz", nil), http.StatusOK},
+		{"healthz on read", read, httptest.NewRequest(http.MethodGet, "/healthz", nil), http.StatusOK},
+		{"options on write", write, httptest.NewRequest(http.MethodOptions, "/api/v1/devices", nil), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, tt.req)
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusMethodNotAllowed && rr.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+				t.Errorf("unexpected Allow header %q", rr.Header().Get("Allow"))
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Two-phase startup: the listeners open before the device registry loads, so
+// a large CSV doesn't leave load balancers and devices facing a refused
+// connection. Until loading finishes, health checks report NOT_SERVING and
+// API requests get a 503 with Retry-After, as on a standby.
+
+// loadingRetryAfter is the Retry-After, in seconds, sent while loading.
+const loadingRetryAfter = 5
+
+// SetLoading marks the server as loading its registry, or done loading.
+func (s *Server) SetLoading(loading bool) {
+	s.loading.Store(loading)
+}
+
+// Loading reports whether the server is still loading its registry.
+func (s *Server) Loading() bool {
+	return s.loading.Load()
+}
+
+// rejectLoading answers every API request with 503 while the server is loading.
+func (s *Server) rejectLoading(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.loading.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(loadingRetryAfter))
+			writeErrorCode(w, http.StatusServiceUnavailable, errCodeLoading, "loading device registry")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
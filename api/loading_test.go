@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLoading tests that a loading server fails health checks and asks requests to retry
+func TestLoading(t *testing.T) {
+	server := setupTestServer()
+	server.SetLoading(true)
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+	var problem ProblemDetails
+	_ = json.NewDecoder(rr.Body).Decode(&problem)
+	if rr.Code != http.StatusServiceUnavailable || problem.Code != errCodeLoading || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a 503 %s with Retry-After while loading, got %d %+v", errCodeLoading, rr.Code, problem)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /healthz 503 while loading, got %d", rr.Code)
+	}
+
+	server.SetLoading(false)
+	for _, path := range []string{"/api/v1/devices/device-1/stats", "/healthz"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code/100 != 2 {
+			t.Errorf("%s: expected success once loaded, got %d", path, rr.Code)
+		}
+	}
+}
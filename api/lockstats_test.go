@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestTimedRWMutex tests that only contended acquires are counted
+func TestTimedRWMutex(t *testing.T) {
+	var m timedRWMutex
+
+	m.Lock()
+	m.Unlock()
+	m.RLock()
+	m.RUnlock()
+	if stats := m.stats(); stats != (LockWaitStats{}) {
+		t.Fatalf("expected no waits when uncontended, got %+v", stats)
+	}
+
+	m.Lock()
+	done := make(chan struct{})
+	go func() {
+		m.RLock()
+		m.RUnlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	m.Unlock()
+	<-done
+
+	stats := m.stats()
+	if stats.ReadWaits != 1 || stats.ReadWaitSeconds < 0.01 {
+		t.Errorf("expected one read wait of at least 10ms, got %+v", stats)
+	}
+	if stats.WriteWaits != 0 {
+		t.Errorf("expected no write waits, got %d", stats.WriteWaits)
+	}
+}
+
+// TestHandleLocks tests the lock contention endpoint
+func TestHandleLocks(t *testing.T) {
+	server := setupTestServer()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/locks", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp LocksResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.RuntimeMutexWaitSeconds < 0 {
+		t.Errorf("unexpected runtime mutex wait: %v", resp.RuntimeMutexWaitSeconds)
+	}
+}
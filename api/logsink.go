@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Everything logs through the standard logger with an event prefix such as
+// [ERROR]. A log sink replaces the logger's output: it derives each line's
+// level from its prefix, drops lines below the configured level, and writes
+// the rest as text or JSON to stderr, or to the local syslog or journald on
+// facility edge servers.
+
+// logLevel orders log lines by severity.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// eventLevels maps each event prefix to its level. Lines without a known
+// prefix are info.
+var eventLevels = map[string]logLevel{
+	"REQUEST":  levelDebug,
+	"RESPONSE": levelDebug,
+	"INFO":     levelInfo,
+	"CONFIG":   levelInfo,
+	"STARTUP":  levelInfo,
+	"SHUTDOWN": levelInfo,
+	"WARN":     levelWarn,
+	"ALERT":    levelWarn,
+	"ERROR":    levelError,
+}
+
+// LogSinks returns the names -log-sink accepts.
+func LogSinks() []string {
+	return []string{"text", "json", "syslog", "journald"}
+}
+
+// LogLevels returns the names -log-level accepts, least severe first.
+func LogLevels() []string {
+	return logLevelNames
+}
+
+// logSink writes log lines that pass the level filter.
+type logSink interface {
+	// emit writes one line; event is its prefix without brackets, empty if
+	// it has none, and msg is the line after the prefix
+	emit(level logLevel, event, line, msg string) error
+	Close() error
+}
+
+// ConfigureLogging sends the standard logger's output to the named sink,
+// dropping lines below level. Text keeps the logger's timestamps; the other
+// sinks timestamp lines themselves. The returned closer releases the sink's
+// connection.
+func ConfigureLogging(sinkName, level string) (io.Closer, error) {
+	minLevel := -1
+	for i, name := range logLevelNames {
+		if name == level {
+			minLevel = i
+		}
+	}
+	if minLevel < 0 {
+		return nil, fmt.Errorf("unknown log level %q (available: %s)", level, strings.Join(LogLevels(), ", "))
+	}
+
+	var sink logSink
+	var err error
+	switch sinkName {
+	case "text":
+		sink = &textSink{w: os.Stderr}
+	case "json":
+		sink = &jsonSink{w: os.Stderr}
+	case "syslog":
+		sink, err = newSyslogSink()
+	case "journald":
+		sink, err = newJournaldSink(journaldSocket)
+	default:
+		return nil, fmt.Errorf("unknown log sink %q (available: %s)", sinkName, strings.Join(LogSinks(), ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s log sink: %w", sinkName, err)
+	}
+
+	if sinkName != "text" {
+		log.SetFlags(0)
+	}
+	log.SetOutput(&levelWriter{min: logLevel(minLevel), sink: sink})
+	return sink, nil
+}
+
+// levelWriter is the standard logger's output. The logger serializes its
+// writes and passes one complete line per call.
+type levelWriter struct {
+	min  logLevel
+	sink logSink
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	level, event, msg := parseLogLine(line)
+	if level < w.min {
+		return len(p), nil
+	}
+	if err := w.sink.emit(level, event, line, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseLogLine returns the line's level and event prefix, and the message
+// after the prefix. The prefix may follow the logger's timestamp.
+func parseLogLine(line string) (level logLevel, event, msg string) {
+	start := strings.IndexByte(line, '[')
+	end := strings.IndexByte(line, ']')
+	if start >= 0 && end > start {
+		if level, ok := eventLevels[line[start+1:end]]; ok {
+			return level, line[start+1 : end], strings.TrimSpace(line[end+1:])
+		}
+	}
+	return levelInfo, "", line
+}
+
+// textSink writes lines unchanged, as the logger would.
+type textSink struct {
+	w io.Writer
+}
+
+func (s *textSink) emit(level logLevel, event, line, msg string) error {
+	_, err := io.WriteString(s.w, line+"\n")
+	return err
+}
+
+func (s *textSink) Close() error {
+	return nil
+}
+
+// jsonSink writes each line as a JSON object for log shippers.
+type jsonSink struct {
+	w io.Writer
+}
+
+// jsonLogLine is a line written by the json sink.
+type jsonLogLine struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Event string    `json:"event,omitempty"` // the prefix, e.g. ALERT
+	Msg   string    `json:"msg"`
+}
+
+func (s *jsonSink) emit(level logLevel, event, line, msg string) error {
+	data, err := json.Marshal(jsonLogLine{Time: time.Now().UTC(), Level: level.String(), Event: event, Msg: msg})
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *jsonSink) Close() error {
+	return nil
+}
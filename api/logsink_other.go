@@ -0,0 +1,18 @@
+//go:build !unix
+
+package api
+
+import "errors"
+
+// journaldSocket is unused off Unix, where journald doesn't run.
+const journaldSocket = ""
+
+// newSyslogSink always fails: log/syslog needs a Unix syslog daemon.
+func newSyslogSink() (logSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// newJournaldSink always fails: journald only runs on Linux.
+func newJournaldSink(path string) (logSink, error) {
+	return nil, errors.New("journald is not supported on this platform")
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestParseLogLine tests that levels come from the event prefix, after any timestamp
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		level logLevel
+		event string
+		msg   string
+	}{
+		{"[REQUEST] GET /api/v1/devices", levelDebug, "REQUEST", "GET /api/v1/devices"},
+		{"2024/01/15 10:00:00 [ALERT] Device offline: cam-1", levelWarn, "ALERT", "Device offline: cam-1"},
+		{"[ERROR] Server failed: boom", levelError, "ERROR", "Server failed: boom"},
+		{"no prefix [here]", levelInfo, "", "no prefix [here]"},
+	}
+	for _, tt := range tests {
+		level, event, msg := parseLogLine(tt.line)
+		if level != tt.level || event != tt.event || msg != tt.msg {
+			t.Errorf("%q: got %v %q %q", tt.line, level, event, msg)
+		}
+	}
+}
+
+// TestLevelWriter tests that lines below the minimum level are dropped
+func TestLevelWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&levelWriter{min: levelWarn, sink: &jsonSink{w: &buf}}, "", 0)
+	logger.Print("[REQUEST] GET /api/v1/devices")
+	logger.Print("[CONFIG] Loaded 5 devices")
+	logger.Print("[ALERT] Device offline: cam-1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the alert written, got %q", lines)
+	}
+	var got jsonLogLine
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Level != "warn" || got.Event != "ALERT" || got.Msg != "Device offline: cam-1" || got.Time.IsZero() {
+		t.Errorf("unexpected line %+v", got)
+	}
+}
+
+// TestConfigureLogging_Unknown tests that unknown sinks and levels are rejected
+func TestConfigureLogging_Unknown(t *testing.T) {
+	if _, err := ConfigureLogging("kafka", "info"); err == nil {
+		t.Error("expected an unknown sink rejected")
+	}
+	if _, err := ConfigureLogging("text", "verbose"); err == nil {
+		t.Error("expected an unknown level rejected")
+	}
+}
@@ -0,0 +1,100 @@
+//go:build unix
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// logIdentifier tags lines in syslog and journald.
+const logIdentifier = "safelyyou"
+
+// journaldSocket is where journald accepts its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// syslogSink writes lines to the local syslog daemon at their level's
+// severity, keeping the event prefix so existing greps still match.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (*syslogSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) emit(level logLevel, event, line, msg string) error {
+	switch level {
+	case levelDebug:
+		return s.w.Debug(line)
+	case levelWarn:
+		return s.w.Warning(line)
+	case levelError:
+		return s.w.Err(line)
+	default:
+		return s.w.Info(line)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// journaldSink writes lines to journald's native socket, so journalctl
+// filters them by priority and shows the event as a field. Lines too large
+// for one datagram are dropped.
+type journaldSink struct {
+	conn *net.UnixConn
+}
+
+func newJournaldSink(path string) (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+// journaldPriorities maps levels to syslog severities.
+var journaldPriorities = map[logLevel]int{
+	levelDebug: 7,
+	levelInfo:  6,
+	levelWarn:  4,
+	levelError: 3,
+}
+
+func (s *journaldSink) emit(level logLevel, event, line, msg string) error {
+	var b bytes.Buffer
+	writeJournaldField(&b, "MESSAGE", line)
+	writeJournaldField(&b, "PRIORITY", strconv.Itoa(journaldPriorities[level]))
+	writeJournaldField(&b, "SYSLOG_IDENTIFIER", logIdentifier)
+	if event != "" {
+		writeJournaldField(&b, "EVENT", event)
+	}
+	_, err := s.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournaldField appends a field in journald's native format. Values
+// containing newlines are length-prefixed rather than newline-terminated.
+func writeJournaldField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+func (s *journaldSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// TestJournaldSink tests the native protocol fields, including a multi-line message
+func TestJournaldSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = journal.Close() }()
+
+	sink, err := newJournaldSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sink.Close() }()
+
+	line := "[ERROR] Invalid CSV:\nline 3"
+	if err := sink.emit(levelError, "ERROR", line, "Invalid CSV:\nline 3"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	n, err := journal.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want bytes.Buffer
+	want.WriteString("MESSAGE\n")
+	_ = binary.Write(&want, binary.LittleEndian, uint64(len(line)))
+	want.WriteString(line + "\nPRIORITY=3\nSYSLOG_IDENTIFIER=safelyyou\nEVENT=ERROR\n")
+	if !bytes.Equal(buf[:n], want.Bytes()) {
+		t.Errorf("got %q, want %q", buf[:n], want.Bytes())
+	}
+}
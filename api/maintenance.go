@@ -0,0 +1,412 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance windows mark planned downtime, such as a firmware rollout, for
+// one device, a device group or a whole facility (org). Heartbeat gaps inside
+// a window don't count against uptime or SLA, and the offline monitor stays
+// quiet until the window ends. A group's window covers whoever is a member
+// while it runs, so devices added to the group mid-window are covered too.
+
+// maxMaintenanceWindow bounds a single window, so a mistyped end date can't
+// silently hide a month of real outages.
+const maxMaintenanceWindow = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a scheduled maintenance period.
+type MaintenanceWindow struct {
+	ID       int64     `json:"id"`
+	Org      string    `json:"org,omitempty"`
+	DeviceID string    `json:"device_id,omitempty"` // empty means every device in Org, or in Group
+	Group    string    `json:"group,omitempty"`     // a group of Org's, whose members it covers
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// appliesTo reports whether the window covers the device, looking group
+// windows up in groups.
+func (w MaintenanceWindow) appliesTo(device *DeviceStats, groups map[groupKey]*Group) bool {
+	switch {
+	case w.DeviceID != "":
+		return w.DeviceID == device.ID
+	case w.Group != "":
+		group, exists := groups[groupKey{w.Org, w.Group}]
+		if !exists {
+			return false
+		}
+		_, member := slices.BinarySearch(group.DeviceIDs, device.ID)
+		return member
+	}
+	return w.Org == "" || w.Org == device.Org
+}
+
+// active reports whether t falls inside the window.
+func (w MaintenanceWindow) active(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// maintenanceSchedule is a device's maintenance as sorted, non-overlapping
+// [start, end) ranges.
+type maintenanceSchedule []timeRange
+
+type timeRange struct {
+	start, end time.Time
+}
+
+// newMaintenanceSchedule merges the windows covering a device.
+func newMaintenanceSchedule(windows []MaintenanceWindow, device *DeviceStats, groups map[groupKey]*Group) maintenanceSchedule {
+	var ranges []timeRange
+	for _, w := range windows {
+		if w.appliesTo(device, groups) {
+			ranges = append(ranges, timeRange{w.Start, w.End})
+		}
+	}
+	slices.SortFunc(ranges, func(a, b timeRange) int { return a.start.Compare(b.start) })
+
+	var merged maintenanceSchedule
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !r.start.After(merged[n-1].end) {
+			if r.end.After(merged[n-1].end) {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// overlap returns how much of [from, to) is under maintenance.
+func (m maintenanceSchedule) overlap(from, to time.Time) time.Duration {
+	var total time.Duration
+	for _, r := range m {
+		start, end := maxTime(r.start, from), minTime(r.end, to)
+		if start.Before(end) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
+
+// active reports whether t falls inside a window.
+func (m maintenanceSchedule) active(t time.Time) bool {
+	for _, r := range m {
+		if !t.Before(r.start) && t.Before(r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeUntil returns the end of the window t falls inside, or the zero
+// time if it isn't in one.
+func (m maintenanceSchedule) activeUntil(t time.Time) time.Time {
+	for _, r := range m {
+		if !t.Before(r.start) && t.Before(r.end) {
+			return r.end
+		}
+	}
+	return time.Time{}
+}
+
+// lastEnd returns the end of the latest window finished by t, or the zero time.
+func (m maintenanceSchedule) lastEnd(t time.Time) time.Time {
+	var last time.Time
+	for _, r := range m {
+		if !r.end.After(t) {
+			last = r.end
+		}
+	}
+	return last
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// copyDeviceLocked returns a copy of the device carrying its maintenance
+// schedule, which Stats and the monitor use to excuse planned gaps.
+// Callers must hold s.mu.
+func (s *Store) copyDeviceLocked(device *DeviceStats) DeviceStats {
+	copied := *device
+	copied.maintenance = newMaintenanceSchedule(s.maintenance, device, s.groups)
+	return copied
+}
+
+// AddMaintenance schedules a window, assigning its ID.
+func (s *Store) AddMaintenance(ctx context.Context, w MaintenanceWindow) (MaintenanceWindow, error) {
+	if err := s.lock(ctx); err != nil {
+		return MaintenanceWindow{}, err
+	}
+	defer s.mu.Unlock()
+
+	s.nextMaintenanceID++
+	w.ID = s.nextMaintenanceID
+	s.maintenance = append(s.maintenance, w)
+	return w, nil
+}
+
+// ListMaintenance returns the windows visible to org, in ID order. An empty
+// deviceID returns every window; otherwise only those covering the device.
+func (s *Store) ListMaintenance(ctx context.Context, org, deviceID string) ([]MaintenanceWindow, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	var result []MaintenanceWindow
+	for _, w := range s.maintenance {
+		if org != "" && w.Org != org {
+			continue
+		}
+		if deviceID != "" {
+			device, exists := s.devices[deviceID]
+			if !exists || !w.appliesTo(device, s.groups) {
+				continue
+			}
+		}
+		result = append(result, w)
+	}
+	return result, nil
+}
+
+// DeleteMaintenance cancels a window visible to org.
+func (s *Store) DeleteMaintenance(ctx context.Context, org string, id int64) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	for i, w := range s.maintenance {
+		if w.ID == id && (org == "" || w.Org == org) {
+			s.maintenance = slices.Delete(s.maintenance, i, i+1)
+			return nil
+		}
+	}
+	return ErrMaintenanceNotFound
+}
+
+// MaintenanceRequest is the body of POST /api/v1/maintenance.
+type MaintenanceRequest struct {
+	DeviceID string    `json:"device_id,omitempty"` // omit to cover the caller's whole facility
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// MaintenanceListResponse is one page of maintenance windows, in ID order.
+type MaintenanceListResponse struct {
+	Windows    []MaintenanceWindow `json:"windows"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// HandleCreateMaintenance processes POST /api/v1/maintenance
+func (s *Server) HandleCreateMaintenance(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/maintenance")
+
+	var req MaintenanceRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return
+	}
+
+	switch {
+	case req.Start.IsZero() || req.End.IsZero():
+		writeError(w, http.StatusBadRequest, "start and end are required")
+		return
+	case !req.End.After(req.Start):
+		writeError(w, http.StatusBadRequest, "end must be after start")
+		return
+	case req.End.Sub(req.Start) > maxMaintenanceWindow:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("window cannot exceed %v", maxMaintenanceWindow))
+		return
+	}
+	if req.DeviceID != "" {
+		if err := s.checkDeviceVisible(r, req.DeviceID); err != nil {
+			writeDeviceError(w, req.DeviceID, err)
+			return
+		}
+	}
+
+	window, err := s.store.AddMaintenance(r.Context(), MaintenanceWindow{
+		Org:      orgFromContext(r.Context()),
+		DeviceID: req.DeviceID,
+		Start:    req.Start.UTC(),
+		End:      req.End.UTC(),
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	log.Printf("[INFO] Scheduled maintenance %d for %q from %s to %s",
+		window.ID, window.DeviceID, window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+	writeJSON(w, http.StatusCreated, window)
+}
+
+// HandleListMaintenance processes GET /api/v1/maintenance. ?group= keeps a
+// group's windows, and ?active=true those in effect now.
+func (s *Server) HandleListMaintenance(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/maintenance")
+
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	query := r.URL.Query()
+	windows, err := s.store.ListMaintenance(r.Context(), orgFromContext(r.Context()), query.Get("device_id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if group := query.Get("group"); group != "" {
+		windows = slices.DeleteFunc(windows, func(w MaintenanceWindow) bool { return w.Group != group })
+	}
+	if query.Get("active") == "true" {
+		now := time.Now()
+		windows = slices.DeleteFunc(windows, func(w MaintenanceWindow) bool { return !w.active(now) })
+	}
+	windows, next := paginate(windows, func(w MaintenanceWindow) string { return fmt.Sprintf("%020d", w.ID) }, page)
+	if windows == nil {
+		windows = []MaintenanceWindow{}
+	}
+	writeJSON(w, http.StatusOK, MaintenanceListResponse{Windows: windows, NextCursor: next})
+}
+
+// HandleDeleteMaintenance processes DELETE /api/v1/maintenance/{id}
+func (s *Server) HandleDeleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/maintenance/")
+	log.Printf("[REQUEST] DELETE /api/v1/maintenance/%s", idStr)
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err == nil {
+		err = s.store.DeleteMaintenance(r.Context(), orgFromContext(r.Context()), id)
+		if err != nil && !errors.Is(err, ErrMaintenanceNotFound) {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, "maintenance window not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GroupMaintenanceRequest is the body of POST /api/v1/groups/{name}/maintenance.
+type GroupMaintenanceRequest struct {
+	Duration int64  `json:"duration"` // nanoseconds, at most maxMaintenanceWindow
+	Reason   string `json:"reason,omitempty"`
+}
+
+// HandleGroupMaintenance processes POST /api/v1/groups/{name}/maintenance,
+// putting the group into maintenance from now for the requested duration.
+// The window expires on its own, or is cancelled early with DELETE
+// /api/v1/maintenance/{id}. Each member's timeline records it, with the
+// request ID, so support can see who silenced a device and why.
+func (s *Server) HandleGroupMaintenance(w http.ResponseWriter, r *http.Request, name string) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/groups/%s/maintenance", name)
+
+	var req GroupMaintenanceRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return
+	}
+	duration := time.Duration(req.Duration)
+	switch {
+	case duration <= 0:
+		writeError(w, http.StatusBadRequest, "duration must be positive")
+		return
+	case duration > maxMaintenanceWindow:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("window cannot exceed %v", maxMaintenanceWindow))
+		return
+	}
+
+	org := orgFromContext(r.Context())
+	group, err := s.store.GetGroup(r.Context(), org, name)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	window, err := s.store.AddMaintenance(r.Context(), MaintenanceWindow{
+		Org:    org,
+		Group:  group.Name,
+		Start:  now,
+		End:    now.Add(duration),
+		Reason: req.Reason,
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	detail := fmt.Sprintf("group %s, window %d until %s", group.Name, window.ID, window.End.Format(time.RFC3339))
+	if req.Reason != "" {
+		detail += ": " + req.Reason
+	}
+	if id := traceFromContext(r.Context()).id; id != "" {
+		detail += " (request " + id + ")"
+	}
+	for _, id := range group.DeviceIDs {
+		if err := s.store.RecordEvent(r.Context(), id, eventMaintenance, now, detail); err != nil {
+			log.Printf("[WARN] Failed to record maintenance on %s's timeline: %v", id, err)
+		}
+	}
+	log.Printf("[INFO] Group %q in maintenance %d until %s (%d devices)",
+		group.Name, window.ID, window.End.Format(time.RFC3339), len(group.DeviceIDs))
+	writeJSON(w, http.StatusCreated, window)
+}
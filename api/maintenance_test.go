@@ -0,0 +1,246 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMaintenanceSchedule tests merging overlapping windows and measuring overlap
+func TestMaintenanceSchedule(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	device := &DeviceStats{ID: "device-1", Org: "acme"}
+	windows := []MaintenanceWindow{
+		{DeviceID: "device-1", Start: t0.Add(30 * time.Minute), End: t0.Add(90 * time.Minute)},
+		{Org: "acme", Start: t0, End: t0.Add(45 * time.Minute)},
+		{DeviceID: "device-2", Start: t0, End: t0.Add(5 * time.Hour)},
+		{Org: "other", Start: t0, End: t0.Add(5 * time.Hour)},
+	}
+
+	schedule := newMaintenanceSchedule(windows, device, nil)
+	if len(schedule) != 1 || !schedule[0].end.Equal(t0.Add(90*time.Minute)) {
+		t.Fatalf("expected one merged range ending at 11:30, got %+v", schedule)
+	}
+	if got := schedule.overlap(t0.Add(time.Hour), t0.Add(2*time.Hour)); got != 30*time.Minute {
+		t.Errorf("expected 30m overlap, got %v", got)
+	}
+	if !schedule.active(t0) || schedule.active(t0.Add(90*time.Minute)) {
+		t.Error("expected window to be [start, end)")
+	}
+	if got := schedule.lastEnd(t0.Add(2 * time.Hour)); !got.Equal(t0.Add(90 * time.Minute)) {
+		t.Errorf("expected last end 11:30, got %v", got)
+	}
+}
+
+// TestMaintenance_Uptime tests that heartbeat gaps during maintenance don't lower uptime
+func TestMaintenance_Uptime(t *testing.T) {
+	server := setupTestServer()
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	server.store.RecordHeartbeat(t.Context(), "device-1", t0)
+	server.store.RecordHeartbeat(t.Context(), "device-1", t0.Add(10*time.Minute))
+
+	device, _ := server.store.Device(t.Context(), "device-1")
+	if result := device.Stats(); result.Uptime >= 50 {
+		t.Fatalf("expected low uptime before maintenance, got %v", result.Uptime)
+	}
+
+	server.store.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "device-1", Start: t0.Add(time.Minute), End: t0.Add(10 * time.Minute)})
+	device, _ = server.store.Device(t.Context(), "device-1")
+	if result := device.Stats(); result.Uptime != 100 {
+		t.Errorf("expected 100%% uptime with the gap excused, got %v", result.Uptime)
+	}
+}
+
+// TestMaintenance_SLA tests that maintenance hours are left out of the SLA window
+func TestMaintenance_SLA(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	// Three hours ago: a full hour, a silent hour under maintenance, then a full hour
+	to := time.Now().UTC().Truncate(time.Hour)
+	recordHours(server.store, "device-1", to.Add(-3*time.Hour), 60, 0, 60)
+	server.store.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "device-1", Start: to.Add(-2 * time.Hour), End: to.Add(-time.Hour)})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/sla?target=99.5&window=30d", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	var resp SLAResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.AchievedUptime != 100 || resp.DowntimeMinutes != 0 || resp.MaintenanceMinutes != 60 || !resp.Pass {
+		t.Errorf("expected maintenance hour excused, got %+v", resp)
+	}
+	if resp.AllowedDowntimeMinutes != 0.6 {
+		t.Errorf("expected allowance over the 120 measured minutes, got %v", resp.AllowedDowntimeMinutes)
+	}
+}
+
+// TestMaintenance_OfflineMonitor tests that alerts are suppressed during
+// maintenance and silence is measured from its end
+func TestMaintenance_OfflineMonitor(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+	s.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "device-1", Start: t1, End: t1.Add(time.Hour)})
+
+	m := NewOfflineMonitor(s, 5*time.Minute)
+
+	if offline, _ := m.Check(t.Context(), t1.Add(30*time.Minute)); len(offline) != 0 {
+		t.Errorf("expected no alert during maintenance, got %v", offline)
+	}
+	if offline, _ := m.Check(t.Context(), t1.Add(62*time.Minute)); len(offline) != 0 {
+		t.Errorf("expected grace after maintenance ends, got %v", offline)
+	}
+	if offline, _ := m.Check(t.Context(), t1.Add(66*time.Minute)); !slices.Equal(offline, []string{"device-1"}) {
+		t.Errorf("expected device-1 offline after the threshold, got %v", offline)
+	}
+}
+
+// TestMaintenance_API tests scheduling, listing and cancelling windows
+func TestMaintenance_API(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/maintenance", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"missing end", `{"start": "2024-01-15T10:00:00Z"}`, http.StatusBadRequest},
+		{"end before start", `{"start": "2024-01-15T10:00:00Z", "end": "2024-01-15T09:00:00Z"}`, http.StatusBadRequest},
+		{"too long", `{"start": "2024-01-15T10:00:00Z", "end": "2024-01-30T10:00:00Z"}`, http.StatusBadRequest},
+		{"unknown device", `{"device_id": "nope", "start": "2024-01-15T10:00:00Z", "end": "2024-01-15T11:00:00Z"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := post(tt.body); rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+
+	rr := post(`{"device_id": "device-1", "start": "2024-01-15T10:00:00Z", "end": "2024-01-15T12:00:00Z", "reason": "firmware rollout"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created MaintenanceWindow
+	_ = json.NewDecoder(rr.Body).Decode(&created)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/maintenance?device_id=device-2", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var list MaintenanceListResponse
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Windows) != 0 {
+		t.Errorf("expected no windows for device-2, got %+v", list.Windows)
+	}
+
+	path := "/api/v1/maintenance/" + strconv.FormatInt(created.ID, 10)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, path, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, path, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 on second delete, got %d", rr.Code)
+	}
+}
+
+// TestMaintenance_Group tests putting a group into maintenance: its members
+// at any moment are covered, and stats, the timeline and the list show it
+func TestMaintenance_Group(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Now().Add(-time.Minute))
+	server.store.RecordHeartbeat(t.Context(), "device-2", time.Now().Add(-time.Minute))
+	server.store.CreateGroup(t.Context(), Group{Name: "east", DeviceIDs: []string{"device-1"}})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"no duration", "/api/v1/groups/east/maintenance", `{}`, http.StatusBadRequest},
+		{"too long", "/api/v1/groups/east/maintenance", `{"duration": 1209600000000000}`, http.StatusBadRequest},
+		{"unknown group", "/api/v1/groups/west/maintenance", `{"duration": 3600000000000}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doGroupRequest(router, http.MethodPost, tt.path, tt.body); rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+
+	rr := doGroupRequest(router, http.MethodPost, "/api/v1/groups/east/maintenance", `{"duration": 7200000000000, "reason": "switch swap"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var window MaintenanceWindow
+	_ = json.NewDecoder(rr.Body).Decode(&window)
+	if window.Group != "east" || window.End.Sub(window.Start) != 2*time.Hour {
+		t.Errorf("unexpected window %+v", window)
+	}
+
+	stats := func(deviceID string) StatsResponse {
+		t.Helper()
+		rr := doGroupRequest(router, http.MethodGet, "/api/v1/devices/"+deviceID+"/stats", "")
+		var resp StatsResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+	if got := stats("device-1").MaintenanceUntil; !got.Equal(window.End) {
+		t.Errorf("expected device-1 in maintenance until %v, got %v", window.End, got)
+	}
+	if got := stats("device-2").MaintenanceUntil; !got.IsZero() {
+		t.Errorf("expected device-2 outside maintenance, got %v", got)
+	}
+
+	// A member added mid-window is covered from then on
+	doGroupRequest(router, http.MethodPut, "/api/v1/groups/east/devices/device-2", "")
+	if got := stats("device-2").MaintenanceUntil; !got.Equal(window.End) {
+		t.Errorf("expected the new member covered, got %v", got)
+	}
+	rr = doGroupRequest(router, http.MethodGet, "/api/v1/groups/east/stats", "")
+	var groupStats GroupStatsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&groupStats)
+	if groupStats.InMaintenance != 2 {
+		t.Errorf("expected 2 members in maintenance, got %+v", groupStats)
+	}
+
+	device, _ := server.store.Device(t.Context(), "device-1")
+	last := device.Events[len(device.Events)-1]
+	if last.Type != eventMaintenance || !strings.Contains(last.Detail, "group east") || !strings.Contains(last.Detail, "switch swap") {
+		t.Errorf("expected a maintenance event on the timeline, got %+v", last)
+	}
+
+	server.store.AddMaintenance(t.Context(), MaintenanceWindow{DeviceID: "device-2", Start: window.Start.Add(-3 * time.Hour), End: window.Start.Add(-2 * time.Hour)})
+	rr = doGroupRequest(router, http.MethodGet, "/api/v1/maintenance?active=true", "")
+	var list MaintenanceListResponse
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Windows) != 1 || list.Windows[0].ID != window.ID {
+		t.Errorf("expected only the group's window active, got %+v", list.Windows)
+	}
+	rr = doGroupRequest(router, http.MethodGet, "/api/v1/maintenance?group=west", "")
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Windows) != 0 {
+		t.Errorf("expected no windows for another group, got %+v", list.Windows)
+	}
+}
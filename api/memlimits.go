@@ -0,0 +1,94 @@
+package api
+
+import (
+	"slices"
+	"time"
+)
+
+// Every per-device structure the store grows is bounded on its own: the
+// history ring holds 30 days of hours and the upload ring -recent-uploads
+// records. Their number isn't, so a large fleet with history and upload
+// records enabled grows with it. MemoryLimits caps how many devices keep
+// each, fleet-wide. When a device with none needs one and the cap is
+// reached, the rings written least recently are evicted, a sixteenth of the
+// cap at a time so the scan is paid once per batch rather than per device.
+// Their devices lose history or upload records (never their aggregates) and
+// start new rings on their next telemetry. Receipts, which deduplicate
+// retries, have a fleet-wide capacity and an optional per-device one (see EnableReceipts).
+// Evictions are counted and exported as safelyyou_evictions_total.
+
+// MemoryLimits caps how many devices keep per-device rings. Zero is unlimited.
+type MemoryLimits struct {
+	HistoryDevices int `json:"history_devices"` // devices with an hourly history ring (~40 KB each)
+	UploadDevices  int `json:"upload_devices"`  // devices with recent upload records
+}
+
+// Evictions counts what was forgotten to stay within a capacity.
+type Evictions struct {
+	HistoryRings int64 `json:"history_rings"`
+	UploadRings  int64 `json:"upload_rings"`
+	DeadLetters  int64 `json:"dead_letters"`
+}
+
+// SetMemoryLimits sets the fleet-wide caps, evicting rings at once if the
+// store already holds more.
+func (s *Store) SetMemoryLimits(limits MemoryLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = MemoryLimits{HistoryDevices: max(limits.HistoryDevices, 0), UploadDevices: max(limits.UploadDevices, 0)}
+	if s.limits.HistoryDevices > 0 {
+		s.evictHistoryLocked(s.limits.HistoryDevices)
+	}
+	if s.limits.UploadDevices > 0 {
+		s.evictUploadsLocked(s.limits.UploadDevices)
+	}
+}
+
+// Evictions reports how many rings have been evicted.
+func (s *Store) Evictions() Evictions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evictions
+}
+
+// A device needing a ring at a full cap evicts one in this many of the cap's
+// rings, so the devices after it find room without scanning every ring.
+const evictionBatchDivisor = 16
+
+// lowWater returns how many rings to keep when a device needs one and limit
+// is reached.
+func lowWater(limit int) int {
+	return limit - max(limit/evictionBatchDivisor, 1)
+}
+
+// evictHistoryLocked drops the least recently written history rings until
+// at most keep remain.
+// Callers must hold s.mu for writing.
+func (s *Store) evictHistoryLocked(keep int) {
+	s.evictions.HistoryRings += evictOldest(s.history, keep, func(h *deviceHistory) time.Time { return h.latest })
+}
+
+// evictUploadsLocked drops the least recently written upload rings until at
+// most keep remain.
+// Callers must hold s.mu for writing.
+func (s *Store) evictUploadsLocked(keep int) {
+	s.evictions.UploadRings += evictOldest(s.recentUploads, keep, func(r *uploadRing) time.Time { return r.latest })
+}
+
+// evictOldest deletes the entries of rings with the oldest latest times
+// until at most keep remain, returning how many it deleted.
+func evictOldest[T any](rings map[string]T, keep int, latest func(T) time.Time) int64 {
+	extra := len(rings) - keep
+	if extra <= 0 {
+		return 0
+	}
+	ids := make([]string, 0, len(rings))
+	for id := range rings {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int { return latest(rings[a]).Compare(latest(rings[b])) })
+	for _, id := range ids[:extra] {
+		delete(rings, id)
+	}
+	return int64(extra)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMemoryLimits_History tests evicting the least recently written history ring at the fleet cap
+func TestMemoryLimits_History(t *testing.T) {
+	store := NewStore()
+	for _, id := range []string{"device-1", "device-2", "device-3"} {
+		store.AddDevice(t.Context(), DeviceStats{ID: id})
+	}
+	store.SetMemoryLimits(MemoryLimits{HistoryDevices: 2})
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	store.RecordHeartbeat(t.Context(), "device-1", now.Add(-2*time.Hour))
+	store.RecordHeartbeat(t.Context(), "device-2", now.Add(-time.Hour))
+	store.RecordHeartbeat(t.Context(), "device-3", now)
+
+	if buckets, _, _ := store.History(t.Context(), "device-1", now.Add(-24*time.Hour), now.Add(time.Hour)); len(buckets) != 0 {
+		t.Errorf("expected device-1's history evicted, got %d buckets", len(buckets))
+	}
+	if buckets, _, _ := store.History(t.Context(), "device-3", now.Add(-24*time.Hour), now.Add(time.Hour)); len(buckets) != 1 {
+		t.Errorf("expected device-3's history kept, got %d buckets", len(buckets))
+	}
+	if stats, _ := store.GetStats(t.Context(), "device-1"); !stats.HasHeartbeats {
+		t.Error("expected device-1's aggregates kept")
+	}
+
+	// Lowering the cap evicts at once
+	store.SetMemoryLimits(MemoryLimits{HistoryDevices: 1})
+	usage, _ := store.Usage(t.Context())
+	if usage.HistoryRings != 1 || usage.Evictions.HistoryRings != 2 || usage.Limits.HistoryDevices != 1 {
+		t.Errorf("expected 1 ring after 2 evictions, got %+v", usage)
+	}
+}
+
+// TestMemoryLimits_Uploads tests evicting the least recently written upload ring at the fleet cap
+func TestMemoryLimits_Uploads(t *testing.T) {
+	store := NewStore()
+	for _, id := range []string{"device-1", "device-2", "device-3"} {
+		store.AddDevice(t.Context(), DeviceStats{ID: id})
+	}
+	store.SetMemoryLimits(MemoryLimits{UploadDevices: 2})
+
+	store.RecordUploadStat(t.Context(), "device-1", time.Second)
+	store.RecordUploadStat(t.Context(), "device-2", time.Second)
+	store.RecordUploadStat(t.Context(), "device-1", 2*time.Second)
+	store.RecordUploadStat(t.Context(), "device-3", time.Second)
+
+	if records, _ := store.RecentUploads(t.Context(), "device-2", 10); len(records) != 0 {
+		t.Errorf("expected device-2's records evicted, got %d", len(records))
+	}
+	if records, _ := store.RecentUploads(t.Context(), "device-1", 10); len(records) != 2 {
+		t.Errorf("expected device-1's records kept, got %d", len(records))
+	}
+	if evictions := store.Evictions(); evictions.UploadRings != 1 {
+		t.Errorf("expected 1 upload ring evicted, got %+v", evictions)
+	}
+}
+
+// TestMemoryLimits_Batch tests that a full cap evicts a batch of rings, so
+// the devices after it find room without evicting again
+func TestMemoryLimits_Batch(t *testing.T) {
+	store := NewStore()
+	for i := range 34 {
+		store.AddDevice(t.Context(), DeviceStats{ID: fmt.Sprintf("device-%d", i)})
+	}
+	store.SetMemoryLimits(MemoryLimits{HistoryDevices: 32})
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	for i := range 32 {
+		store.RecordHeartbeat(t.Context(), fmt.Sprintf("device-%d", i), now.Add(time.Duration(i-32)*time.Hour))
+	}
+	store.RecordHeartbeat(t.Context(), "device-32", now)
+	if usage, _ := store.Usage(t.Context()); usage.HistoryRings != 31 || usage.Evictions.HistoryRings != 2 {
+		t.Fatalf("expected 2 rings evicted at once, got %+v", usage)
+	}
+	for _, id := range []string{"device-0", "device-1"} {
+		if _, exists := store.history[id]; exists {
+			t.Errorf("expected %s, among the least recently written, to be evicted", id)
+		}
+	}
+
+	store.RecordHeartbeat(t.Context(), "device-33", now)
+	if usage, _ := store.Usage(t.Context()); usage.HistoryRings != 32 || usage.Evictions.HistoryRings != 2 {
+		t.Errorf("expected room for the next device without evicting, got %+v", usage)
+	}
+}
+
+// TestMemoryLimits_Metrics tests exporting eviction counters
+func TestMemoryLimits_Metrics(t *testing.T) {
+	server := setupTestServer()
+	server.EnableReceipts(1, 0)
+	server.backend().(*Store).SetMemoryLimits(MemoryLimits{HistoryDevices: 1})
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Now().Add(-time.Hour))
+	server.store.RecordHeartbeat(t.Context(), "device-2", time.Now())
+	server.receipts.open("", "device-1", "")
+	server.receipts.open("", "device-1", "")
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`safelyyou_evictions_total{kind="dead_letters"} 0`,
+		`safelyyou_evictions_total{kind="history_rings"} 1`,
+		`safelyyou_evictions_total{kind="receipts"} 1`,
+		`safelyyou_evictions_total{kind="upload_rings"} 0`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, rr.Body.String())
+		}
+	}
+}
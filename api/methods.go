@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// methods routes a resource's requests by HTTP method. A method the resource
+// doesn't support gets 405 with an Allow header listing the ones it does,
+// and OPTIONS is answered with the same list, so clients and API gateways
+// can discover what each resource accepts. Resources with GET also serve
+// HEAD.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if handler, ok := m[method]; ok {
+		handler(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", m.allow())
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+}
+
+// allow returns the Allow header value for the resource.
+func (m methods) allow() string {
+	allowed := []string{http.MethodOptions}
+	for method := range m {
+		allowed = append(allowed, method)
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	slices.Sort(allowed)
+	return strings.Join(allowed, ", ")
+}
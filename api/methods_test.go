@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRouter_MethodNotAllowed tests 405 responses with an Allow header
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	router := setupTestServer().Router()
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodPost, "/api/v1/devices", "GET, HEAD, OPTIONS"},
+		{http.MethodDelete, "/api/v1/devices/device-1/stats", "GET, HEAD, OPTIONS, POST"},
+		{http.MethodGet, "/api/v1/devices/device-1/heartbeat", "OPTIONS, POST"},
+		{http.MethodPost, "/api/v1/groups/lobby", "DELETE, GET, HEAD, OPTIONS, PUT"},
+		{http.MethodGet, "/api/v1/groups/lobby/devices/device-1", "DELETE, OPTIONS, PUT"},
+		{http.MethodGet, "/api/v1/deadletter/replay", "OPTIONS, POST"},
+		{http.MethodPost, "/api/v1/webhooks/wh-1/deliveries", "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/api/v1/enroll", "OPTIONS, POST"},
+		{http.MethodPost, "/healthz", "GET, HEAD, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != tt.allow {
+				t.Fatalf("expected 405 allowing %q, got %d allowing %q", tt.allow, rr.Code, rr.Header().Get("Allow"))
+			}
+			var resp ProblemDetails
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Code != "ERR_METHOD_NOT_ALLOWED" {
+				t.Errorf("expected ERR_METHOD_NOT_ALLOWED, got %+v (%v)", resp, err)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown resource, got %d", rr.Code)
+	}
+}
+
+// TestRouter_Options tests answering OPTIONS without an API key
+func TestRouter_Options(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAuth(APIKeys{"key": ""})
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodOptions, "/api/v1/devices/device-1/stats", nil))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("expected 204 with Allow, got %d allowing %q", rr.Code, rr.Header().Get("Allow"))
+	}
+
+	// Other methods still need a key
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/v1/devices/device-1/stats", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a key, got %d", rr.Code)
+	}
+}
+
+// TestRouter_Head tests that GET resources answer HEAD
+func TestRouter_Head(t *testing.T) {
+	router := setupTestServer().Router()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, "/api/v1/devices", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request metrics in the Prometheus text format, for alerting on API latency
+// and error spikes (the RED method: rate, errors, duration). Requests are
+// labeled by route template rather than raw path, so device IDs don't blow
+// up label cardinality.
+
+// routeTemplates are the API's routes. A {param} matches one path segment;
+// literal routes are listed before parameterized ones that could match them.
+var routeTemplates = [][]string{
+	splitRoute("/api/v1/devices"),
+	splitRoute("/api/v1/devices/search"),
+	splitRoute("/api/v1/devices/compare"),
+	splitRoute("/api/v1/devices/{device_id}"),
+	splitRoute("/api/v1/devices/{device_id}/heartbeat"),
+	splitRoute("/api/v1/devices/{device_id}/stats"),
+	splitRoute("/api/v1/devices/{device_id}/stats/history"),
+	splitRoute("/api/v1/devices/{device_id}/stats/daily"),
+	splitRoute("/api/v1/devices/{device_id}/sla"),
+	splitRoute("/api/v1/devices/{device_id}/uploads/recent"),
+	splitRoute("/api/v1/devices/{device_id}/decommission"),
+	splitRoute("/api/v1/devices/{device_id}/activate"),
+	splitRoute("/api/v1/devices/{device_id}/mute"),
+	splitRoute("/api/v1/devices/{device_id}/transfer"),
+	splitRoute("/api/v1/devices/{device_id}/events"),
+	splitRoute("/api/v1/devices/{device_id}/commands"),
+	splitRoute("/api/v1/devices/{device_id}/commands/{id}"),
+	splitRoute("/api/v1/devices/{device_id}/commands/{id}/ack"),
+	splitRoute("/api/v1/devices/{device_id}/diagnostics"),
+	splitRoute("/api/v1/devices/{device_id}/diagnostics/{bundle_id}"),
+	splitRoute("/api/v2/devices/{device_id}/stats"),
+	splitRoute("/api/v1/ingest"),
+	splitRoute("/api/v1/enroll"),
+	splitRoute("/api/v1/fleet/versions"),
+	splitRoute("/api/v1/fleet/activity"),
+	splitRoute("/api/v1/fleet/sla"),
+	splitRoute("/api/v1/fleet/distribution"),
+	splitRoute("/api/v1/fleet/offline"),
+	splitRoute("/api/v1/groups"),
+	splitRoute("/api/v1/groups/{name}"),
+	splitRoute("/api/v1/groups/{name}/stats"),
+	splitRoute("/api/v1/groups/{name}/maintenance"),
+	splitRoute("/api/v1/groups/{name}/devices/{device_id}"),
+	splitRoute("/api/v1/orgs/{org}/usage"),
+	splitRoute("/api/v1/admin/limits"),
+	splitRoute("/api/v1/admin/queue"),
+	splitRoute("/api/v1/admin/publisher"),
+	splitRoute("/api/v1/admin/locks"),
+	splitRoute("/api/v1/admin/shadow"),
+	splitRoute("/api/v1/admin/reload"),
+	splitRoute("/api/v1/admin/devices/export"),
+	splitRoute("/api/v1/admin/devices/import"),
+	splitRoute("/api/v1/admin/validate-csv"),
+	splitRoute("/api/v1/admin/signatures"),
+	splitRoute("/api/v1/admin/housekeeping"),
+	splitRoute("/api/v1/admin/housekeeping/dry-run"),
+	splitRoute("/api/v1/admin/topology"),
+	splitRoute("/api/v1/receipts/{id}"),
+	splitRoute("/api/v1/deadletter"),
+	splitRoute("/api/v1/deadletter/replay"),
+	splitRoute("/api/v1/deadletter/{id}"),
+	splitRoute("/api/v1/deadletter/{id}/replay"),
+	splitRoute("/api/v1/maintenance"),
+	splitRoute("/api/v1/maintenance/{id}"),
+	splitRoute("/api/v1/webhooks"),
+	splitRoute("/api/v1/webhooks/{id}"),
+	splitRoute("/api/v1/webhooks/{id}/deliveries"),
+	splitRoute("/api/v1/errors"),
+}
+
+// unmatchedRoute labels requests for paths outside routeTemplates.
+const unmatchedRoute = "unmatched"
+
+func splitRoute(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// routeLabel returns the route template matching path.
+func routeLabel(path string) string {
+	segments := splitRoute(path)
+	for _, template := range routeTemplates {
+		if len(template) != len(segments) {
+			continue
+		}
+		match := true
+		for i, part := range template {
+			if !strings.HasPrefix(part, "{") && part != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return "/" + strings.Join(template, "/")
+		}
+	}
+	return unmatchedRoute
+}
+
+// methodLabel bounds the method label to standard methods.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// durationBuckets are the histogram upper bounds in seconds, Prometheus' defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies one labeled series.
+type requestKey struct {
+	method, route string
+	code          int
+}
+
+// durationHistogram counts observations per bucket. counts[i] holds
+// observations at or under durationBuckets[i]; the last slot is +Inf.
+type durationHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *durationHistogram) observe(seconds float64) {
+	i, _ := slices.BinarySearch(durationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// requestMetrics holds per-route request counts and durations.
+type requestMetrics struct {
+	mu        sync.Mutex
+	durations map[requestKey]*durationHistogram // protected by mu
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{durations: make(map[requestKey]*durationHistogram)}
+}
+
+// observe records one finished request.
+func (m *requestMetrics) observe(method, path string, code int, d time.Duration) {
+	key := requestKey{methodLabel(method), routeLabel(path), code}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exists := m.durations[key]
+	if !exists {
+		h = &durationHistogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// instrument records every request's route, status and duration. A handler
+// that panics is counted as a 500, which recoverPanics then writes.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			code := rec.status
+			switch {
+			case !completed:
+				code = http.StatusInternalServerError
+			case code == 0:
+				code = http.StatusOK
+			}
+			s.metrics.observe(r.Method, r.URL.Path, code, time.Since(start))
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format, or
+// OpenMetrics if openMetrics, sorted so the output is stable between scrapes.
+func (m *requestMetrics) writeTo(w io.Writer, openMetrics bool) {
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.durations))
+	snapshot := make(map[requestKey]durationHistogram, len(m.durations))
+	for key, h := range m.durations {
+		keys = append(keys, key)
+		snapshot[key] = durationHistogram{counts: slices.Clone(h.counts), sum: h.sum, count: h.count}
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.route, b.route); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.method, b.method); c != 0 {
+			return c
+		}
+		return a.code - b.code
+	})
+	labels := func(key requestKey) string {
+		return fmt.Sprintf(`method=%q,route=%q,code="%d"`, key.method, key.route, key.code)
+	}
+
+	writeCounterFamily(w, "safelyyou_http_requests_total", "API requests by route, method and status code.", openMetrics)
+	for _, key := range keys {
+		fmt.Fprintf(w, "safelyyou_http_requests_total{%s} %d\n", labels(key), snapshot[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP safelyyou_http_request_duration_seconds API request latency by route, method and status code.")
+	fmt.Fprintln(w, "# TYPE safelyyou_http_request_duration_seconds histogram")
+	for _, key := range keys {
+		h := snapshot[key]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "safelyyou_http_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels(key), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "safelyyou_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), h.count)
+		fmt.Fprintf(w, "safelyyou_http_request_duration_seconds_sum{%s} %g\n", labels(key), h.sum)
+		fmt.Fprintf(w, "safelyyou_http_request_duration_seconds_count{%s} %d\n", labels(key), h.count)
+	}
+}
+
+// writeCounterFamily writes a counter's HELP and TYPE lines. OpenMetrics
+// names the family without the _total its samples carry.
+func writeCounterFamily(w io.Writer, name, help string, openMetrics bool) {
+	if openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+}
+
+// writeEvictionMetrics writes the eviction counters in the Prometheus text
+// format, or OpenMetrics if openMetrics.
+func (s *Server) writeEvictionMetrics(w io.Writer, openMetrics bool) {
+	var evictions Evictions
+	if bounded, ok := s.backend().(MemoryBounded); ok {
+		evictions = bounded.Evictions()
+	}
+	evictions.DeadLetters = s.deadLetters.droppedCount()
+	var receipts int64
+	if s.receipts != nil {
+		receipts = s.receipts.evictedCount()
+	}
+
+	writeCounterFamily(w, "safelyyou_evictions_total", "Entries forgotten to stay within a memory cap, by what was evicted.", openMetrics)
+	for _, c := range []struct {
+		kind  string
+		count int64
+	}{
+		{"dead_letters", evictions.DeadLetters},
+		{"history_rings", evictions.HistoryRings},
+		{"receipts", receipts},
+		{"upload_rings", evictions.UploadRings},
+	} {
+		fmt.Fprintf(w, "safelyyou_evictions_total{kind=%q} %d\n", c.kind, c.count)
+	}
+}
+
+// acceptsOpenMetrics reports whether the scraper asked for OpenMetrics,
+// which carries exemplars.
+func acceptsOpenMetrics(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		if strings.TrimSpace(mediaType) == "application/openmetrics-text" {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleMetrics processes GET /metrics, in OpenMetrics if the scraper
+// accepts it and the Prometheus text format otherwise.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := acceptsOpenMetrics(r)
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	s.metrics.writeTo(w, openMetrics)
+	s.writeEvictionMetrics(w, openMetrics)
+	s.writeDistributionMetrics(r.Context(), w, openMetrics)
+	s.writeRouteLimitMetrics(w, openMetrics)
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
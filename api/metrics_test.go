@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRouteLabel tests mapping request paths to route templates
+func TestRouteLabel(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/devices/device-1/stats", "/api/v1/devices/{device_id}/stats"},
+		{"/api/v1/devices/device-1/stats/history", "/api/v1/devices/{device_id}/stats/history"},
+		{"/api/v1/groups/lobby/devices/device-2", "/api/v1/groups/{name}/devices/{device_id}"},
+		{"/api/v1/deadletter/replay", "/api/v1/deadletter/replay"},
+		{"/api/v1/deadletter/7/replay", "/api/v1/deadletter/{id}/replay"},
+		{"/api/v1/maintenance/", "/api/v1/maintenance"},
+		{"/api/v1/devices/device-1/unknown", unmatchedRoute},
+		{"/favicon.ico", unmatchedRoute},
+	}
+	for _, tt := range tests {
+		if got := routeLabel(tt.path); got != tt.want {
+			t.Errorf("routeLabel(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestDurationHistogram tests bucketing observations by upper bound
+func TestDurationHistogram(t *testing.T) {
+	m := newRequestMetrics()
+	m.observe(http.MethodGet, "/api/v1/devices", 200, 5*time.Millisecond)
+	m.observe(http.MethodGet, "/api/v1/devices", 200, 300*time.Millisecond)
+	m.observe(http.MethodGet, "/api/v1/devices", 200, 30*time.Second)
+
+	var buf bytes.Buffer
+	m.writeTo(&buf, false)
+	out := buf.String()
+
+	labels := `method="GET",route="/api/v1/devices",code="200"`
+	for _, line := range []string{
+		`safelyyou_http_requests_total{` + labels + `} 3`,
+		`safelyyou_http_request_duration_seconds_bucket{` + labels + `,le="0.005"} 1`,
+		`safelyyou_http_request_duration_seconds_bucket{` + labels + `,le="0.25"} 1`,
+		`safelyyou_http_request_duration_seconds_bucket{` + labels + `,le="0.5"} 2`,
+		`safelyyou_http_request_duration_seconds_bucket{` + labels + `,le="10"} 2`,
+		`safelyyou_http_request_duration_seconds_bucket{` + labels + `,le="+Inf"} 3`,
+		`safelyyou_http_request_duration_seconds_count{` + labels + `} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+// TestMetrics_Endpoint tests that requests are counted by route and status code
+func TestMetrics_Endpoint(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAuth(APIKeys{"secret": ""})
+	router := server.Router()
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", "secret")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(http.MethodPost, "/api/v1/devices/device-1/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z"}`)
+	send(http.MethodPost, "/api/v1/devices/device-2/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z"}`)
+	send(http.MethodPost, "/api/v1/devices/unknown/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z"}`)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+
+	// Scrapes need no API key
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain, got %q", ct)
+	}
+
+	out := rr.Body.String()
+	for _, line := range []string{
+		`safelyyou_http_requests_total{method="POST",route="/api/v1/devices/{device_id}/heartbeat",code="204"} 2`,
+		`safelyyou_http_requests_total{method="POST",route="/api/v1/devices/{device_id}/heartbeat",code="404"} 1`,
+		`safelyyou_http_requests_total{method="GET",route="/api/v1/devices/{device_id}/stats",code="401"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "device-1") || strings.Contains(out, `route="/metrics"`) {
+		t.Errorf("expected no device IDs or scrape requests in labels:\n%s", out)
+	}
+}
+
+// TestInstrument_Panic tests that a panicking handler is counted as a 500
+func TestInstrument_Panic(t *testing.T) {
+	server := setupTestServer()
+	handler := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}), recoverPanics, server.instrument)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rr.Code)
+	}
+
+	var buf bytes.Buffer
+	server.metrics.writeTo(&buf, false)
+	if want := `safelyyou_http_requests_total{method="GET",route="/api/v1/devices",code="500"} 1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, buf.String())
+	}
+}
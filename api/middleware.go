@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Middleware wraps an http.Handler with cross-cutting behavior such as
+// logging or authentication.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the given middlewares. The first middleware listed is
+// the outermost, so it sees the request first and the response last.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController (e.g. for Flush).
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// recoverPanics turns a handler panic into a 500 JSON response instead of
+// letting net/http drop the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				// http.ErrAbortHandler is net/http's deliberate abort signal
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("[ERROR] Panic handling %s %s%s: %v\n%s", r.Method, r.URL.Path, traceLogSuffix(r.Context()), err, debug.Stack())
+				// Only write an error if the handler hadn't started its response
+				if rec.status == 0 {
+					writeError(rec, http.StatusInternalServerError, "internal server error")
+				}
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// logRequests logs the status and duration of every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("[RESPONSE] %s %s %d %v%s", r.Method, r.URL.Path, rec.status, time.Since(start), traceLogSuffix(r.Context()))
+	})
+}
+
+// enforceDeadline gives each request a deadline. The deadline is
+// cooperative: the handler still runs to completion, and is never cut off
+// mid-write. Storage calls check the request's context, so once it ends they
+// fail without recording anything and handlers stop without writing; a
+// handler that ignores its context keeps going. A request that passed its
+// deadline without writing anything gets 503. It is a no-op when no timeout
+// is set.
+func (s *Server) enforceDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("[WARN] Request timed out after %v: %s %s", s.timeout, r.Method, r.URL.Path)
+			writeErrorCode(w, http.StatusServiceUnavailable, errCodeTimeout, "request timed out")
+		}
+	})
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter is a per-client token bucket: each client may make burst
+// requests at once, refilled at perSecond.
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket // protected by mu
+	lastCleanup time.Time               // protected by mu
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketIdleTimeout is how long an unused bucket is kept. After this long a
+// bucket would be full anyway, so dropping it changes nothing.
+const bucketIdleTimeout = 10 * time.Minute
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// allow reports whether the client may make a request now, consuming a token if so.
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > bucketIdleTimeout {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, exists := l.buckets[client]
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.perSecond
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimit rejects clients exceeding the server's rate limit with 429.
+// It is a no-op when rate limiting is disabled.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := s.sourceIP(r)
+		if !s.limiter.allow(client, time.Now()) {
+			log.Printf("[WARN] Rate limit exceeded: %s", client)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/s.limiter.perSecond))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChain_Order tests that the first middleware listed runs outermost
+func TestChain_Order(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+
+	Chain(handler, mark("a"), mark("b"), mark("c")).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, ","); got != "a,b,c,handler" {
+		t.Errorf("expected order a,b,c,handler, got %s", got)
+	}
+}
+
+// TestRecoverPanics tests that a panicking handler produces a 500 JSON error
+func TestRecoverPanics(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	rr := httptest.NewRecorder()
+
+	Chain(handler, recoverPanics).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rr.Code)
+	}
+	var resp ProblemDetails
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Detail != "internal server error" {
+		t.Errorf("expected 'internal server error', got '%s'", resp.Detail)
+	}
+}
+
+// TestRateLimit tests 429 once a client exhausts its burst
+func TestRateLimit(t *testing.T) {
+	server := setupTestServer()
+	server.EnableRateLimit(1, 2)
+	router := server.Router()
+
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		codes[i] = rr.Code
+	}
+
+	if codes[0] == http.StatusTooManyRequests || codes[1] == http.StatusTooManyRequests {
+		t.Errorf("requests within the burst should pass, got %v", codes)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 after the burst, got %d", codes[2])
+	}
+}
+
+// TestRateLimit_TrustedProxies tests that clients behind a trusted proxy
+// are limited separately, and others can't dodge the limit with headers
+func TestRateLimit_TrustedProxies(t *testing.T) {
+	server := setupTestServer()
+	server.EnableRateLimit(1, 1)
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+	server.SetTrustedProxies(proxies)
+	router := server.Router()
+
+	send := func(peer, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+		req.RemoteAddr = peer
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Two clients behind the same proxy each get their own burst
+	if send("10.0.0.5:5000", "192.168.40.12") == http.StatusTooManyRequests ||
+		send("10.0.0.5:5000", "192.168.40.13") == http.StatusTooManyRequests {
+		t.Error("expected clients behind a trusted proxy to be limited separately")
+	}
+	if code := send("10.0.0.5:5000", "192.168.40.12"); code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for the first client's second request, got %d", code)
+	}
+
+	// An untrusted peer is limited by its own address whatever it claims
+	send("192.168.40.50:5000", "1.1.1.1")
+	if code := send("192.168.40.50:5000", "2.2.2.2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for an untrusted peer changing its header, got %d", code)
+	}
+}
+
+// TestRateLimiter_Refill tests that tokens refill over time per client
+func TestRateLimiter_Refill(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	if !l.allow("a", now) {
+		t.Error("first request should pass")
+	}
+	if l.allow("a", now) {
+		t.Error("second immediate request should be limited")
+	}
+	if !l.allow("b", now) {
+		t.Error("other clients have their own bucket")
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Error("request after refill should pass")
+	}
+}
+
+// TestEnforceDeadline tests that a request exceeding its deadline gets 503
+func TestEnforceDeadline(t *testing.T) {
+	server := setupTestServer()
+	server.SetHandlerTimeout(10 * time.Millisecond)
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // a handler that honors cancellation
+	})
+	rr := httptest.NewRecorder()
+	server.enforceDeadline(slow).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rr.Code)
+	}
+
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rr = httptest.NewRecorder()
+	server.enforceDeadline(fast).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+}
+
+// TestEnforceDeadline_NothingRecorded tests that telemetry arriving after the deadline isn't stored
+func TestEnforceDeadline_NothingRecorded(t *testing.T) {
+	server := setupTestServer()
+	server.SetHandlerTimeout(time.Nanosecond)
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rr.Code)
+	}
+	if count := server.backend().(*Store).devices["device-1"].HeartbeatCount; count != 0 {
+		t.Errorf("expected no heartbeat recorded, got %d", count)
+	}
+}
+
+// TestEnforceDeadline_StoreLockWait tests that a request whose deadline
+// passes while it waits for the store records nothing and gets 503
+func TestEnforceDeadline_StoreLockWait(t *testing.T) {
+	server := setupTestServer()
+	server.SetHandlerTimeout(20 * time.Millisecond)
+	router := server.Router()
+	store := server.backend().(*Store)
+
+	store.mu.Lock()
+	time.AfterFunc(100*time.Millisecond, store.mu.Unlock)
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", rr.Code)
+	}
+	if count := store.devices["device-1"].HeartbeatCount; count != 0 {
+		t.Errorf("expected no heartbeat recorded, got %d", count)
+	}
+}
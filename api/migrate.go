@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Migrate copies a deployment from one backend to another, e.g. from the
+// memory backend restored from its snapshot into a persistent database. It
+// copies what a snapshot holds: every device with its registry fields,
+// secrets and aggregates, groups and maintenance windows. Hourly history
+// and recent upload records stay behind, as they do in snapshots, and so do
+// dead letters, which belong to the server rather than the backend. The
+// destination must be empty, so a migration never merges two deployments,
+// and is checked against the source once written.
+
+// MigrateResult counts what Migrate copied.
+type MigrateResult struct {
+	Devices     int   `json:"devices"`
+	Groups      int   `json:"groups"`
+	Maintenance int   `json:"maintenance"`
+	Heartbeats  int64 `json:"heartbeats"`
+	Uploads     int64 `json:"uploads"`
+}
+
+// Migrate copies src into dst and verifies the copy. An error after the
+// write means dst holds a partial or mismatched copy and shouldn't be used.
+func Migrate(ctx context.Context, dst, src Storage) (MigrateResult, error) {
+	importer, ok := dst.(Importer)
+	if !ok {
+		return MigrateResult{}, errors.New("the destination backend can't be a migration target")
+	}
+	n, err := dst.DeviceCount(ctx)
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("count destination devices: %w", err)
+	}
+	if n > 0 {
+		return MigrateResult{}, fmt.Errorf("destination already has %d devices", n)
+	}
+
+	want, err := exportState(ctx, src)
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("read source: %w", err)
+	}
+	if err := importer.Import(ctx, want); err != nil {
+		return MigrateResult{}, fmt.Errorf("write destination: %w", err)
+	}
+	got, err := exportState(ctx, dst)
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("verify: read destination: %w", err)
+	}
+
+	if err := verifyMigration(want, got); err != nil {
+		return MigrateResult{}, err
+	}
+	result := MigrateResult{
+		Devices:     len(got.Devices),
+		Groups:      len(got.Groups),
+		Maintenance: len(got.Maintenance),
+	}
+	for _, device := range got.Devices {
+		result.Heartbeats += device.HeartbeatCount
+		result.Uploads += device.UploadCount
+	}
+	return result, nil
+}
+
+// verifyMigration checks that the destination holds the same devices,
+// groups and maintenance windows as the source, with the same telemetry
+// counts per device.
+func verifyMigration(want, got StorageState) error {
+	if len(got.Devices) != len(want.Devices) {
+		return fmt.Errorf("verify: copied %d of %d devices", len(got.Devices), len(want.Devices))
+	}
+	for i, device := range want.Devices {
+		copied := got.Devices[i]
+		if copied.ID != device.ID || copied.Org != device.Org {
+			return fmt.Errorf("verify: device %s copied as %s in org %q", device.ID, copied.ID, copied.Org)
+		}
+		if copied.HeartbeatCount != device.HeartbeatCount || copied.UploadCount != device.UploadCount {
+			return fmt.Errorf("verify: device %s has %d heartbeats and %d uploads, want %d and %d",
+				device.ID, copied.HeartbeatCount, copied.UploadCount, device.HeartbeatCount, device.UploadCount)
+		}
+	}
+	if len(got.Groups) != len(want.Groups) {
+		return fmt.Errorf("verify: copied %d of %d groups", len(got.Groups), len(want.Groups))
+	}
+	for i, group := range want.Groups {
+		if !slices.Equal(got.Groups[i].DeviceIDs, group.DeviceIDs) {
+			return fmt.Errorf("verify: group %s/%s has members %s, want %s", group.Org, group.Name,
+				strings.Join(got.Groups[i].DeviceIDs, ","), strings.Join(group.DeviceIDs, ","))
+		}
+	}
+	if len(got.Maintenance) != len(want.Maintenance) {
+		return fmt.Errorf("verify: copied %d of %d maintenance windows", len(got.Maintenance), len(want.Maintenance))
+	}
+	return nil
+}
+
+// Import replaces the store's contents with state's. Unlike Restore, the
+// devices come from state as they are rather than from the registry.
+func (s *Store) Import(ctx context.Context, state StorageState) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	s.devices = make(map[string]*DeviceStats, len(state.Devices))
+	for _, device := range state.Devices {
+		device.maintenance = maintenanceSchedule{}          // rebuilt from the windows on every read
+		device.recentGaps = slices.Clone(device.recentGaps) // the source keeps writing to its own rings
+		device.recentLatencies = slices.Clone(device.recentLatencies)
+		s.devices[device.ID] = &device
+	}
+	s.history = make(map[string]*deviceHistory)
+	s.activity = make(map[string]*activityRing)
+	s.recentUploads = make(map[string]*uploadRing)
+
+	s.groups = make(map[groupKey]*Group, len(state.Groups))
+	for _, group := range state.Groups {
+		s.groups[groupKey{group.Org, group.Name}] = normalizeGroup(group)
+	}
+
+	s.maintenance = slices.Clone(state.Maintenance)
+	s.nextMaintenanceID = 0
+	for _, w := range s.maintenance {
+		s.nextMaintenanceID = max(s.nextMaintenanceID, w.ID)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMigrate tests copying devices, secrets, aggregates, groups and maintenance between backends
+func TestMigrate(t *testing.T) {
+	src := NewStore()
+	src.devices["device-1"] = &DeviceStats{ID: "device-1", Org: "acme", signingKey: []byte("s3cret"), UploadInterval: time.Hour}
+	src.devices["device-2"] = &DeviceStats{ID: "device-2", Org: "globex"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	src.RecordHeartbeat(t.Context(), "device-1", t1)
+	src.RecordHeartbeat(t.Context(), "device-1", t1.Add(time.Minute))
+	src.RecordUploadStatAt(t.Context(), "device-1", 5*time.Second, t1)
+	src.RecordHeartbeat(t.Context(), "device-2", t1)
+	src.CreateGroup(t.Context(), Group{Org: "acme", Name: "lobby", DeviceIDs: []string{"device-1"}})
+	src.AddMaintenance(t.Context(), MaintenanceWindow{Org: "acme", DeviceID: "device-1", Start: t1, End: t1.Add(time.Hour)})
+
+	dst := NewStore()
+	result, err := Migrate(t.Context(), dst, src)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	want := MigrateResult{Devices: 2, Groups: 1, Maintenance: 1, Heartbeats: 3, Uploads: 1}
+	if result != want {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+
+	device, _ := dst.Device(t.Context(), "device-1")
+	if device.Org != "acme" || string(device.signingKey) != "s3cret" || device.HeartbeatCount != 2 ||
+		device.UploadTimeSum != 5*time.Second || device.UploadInterval != time.Hour {
+		t.Errorf("expected device-1 copied with its secret and aggregates, got %+v", device)
+	}
+	if group, err := dst.GetGroup(t.Context(), "acme", "lobby"); err != nil || len(group.DeviceIDs) != 1 {
+		t.Errorf("expected lobby group copied, got %+v", group)
+	}
+	if w, _ := dst.AddMaintenance(t.Context(), MaintenanceWindow{Org: "acme", Start: t1, End: t1.Add(time.Hour)}); w.ID != 2 {
+		t.Errorf("expected maintenance IDs to continue after the copied window, got %d", w.ID)
+	}
+
+	// A second migration into the same destination is refused
+	if _, err := Migrate(t.Context(), dst, src); err == nil {
+		t.Error("expected migrating into a non-empty destination to fail")
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"log"
+	"maps"
+	"time"
+)
+
+// DefaultOfflineAfter is how long a device may go without a heartbeat before
+// the offline monitor alerts, unless the device sets its own alert_after.
+const DefaultOfflineAfter = 5 * time.Minute
+
+// OfflineMonitor periodically checks heartbeat gaps and logs an alert when a
+// device goes silent longer than its threshold, and again when it recovers.
+// It also alerts on heartbeating devices whose uploads stall (see
+// checkUploads), and collapses a facility's device alerts into one during
+// an outage (see checkOutages).
+type OfflineMonitor struct {
+	store        Storage
+	offlineAfter time.Duration
+
+	// Outage detection; zero OutagePercent disables it
+	OutagePercent    float64 // a facility is out when more than this percent of its devices are offline
+	OutageMinDevices int     // facilities with fewer monitored devices never count as out
+
+	// Notify, if set, is called with WebhookDeviceOffline or
+	// WebhookDeviceOnline for each device that goes offline or recovers, and
+	// with WebhookUploadsStalled or WebhookUploadsResumed for uploads
+	Notify func(eventType, deviceID string, at time.Time)
+
+	// NotifyOutage, if set, is called with WebhookFacilityOutage or
+	// WebhookFacilityRecovered when a facility's outage starts or ends, with
+	// its offline devices
+	NotifyOutage func(eventType, org, facility string, offline []string, at time.Time)
+
+	// Devices currently offline, and whether they were alerted on or held
+	// back by an outage; only touched by the monitor's goroutine
+	offline        map[string]bool
+	suppressed     map[string]bool
+	uploadsStalled map[string]bool
+	outages        map[facilityKey]bool // facilities currently out
+}
+
+// NewOfflineMonitor creates a monitor using offlineAfter for devices without
+// their own alert_after threshold.
+func NewOfflineMonitor(store Storage, offlineAfter time.Duration) *OfflineMonitor {
+	return &OfflineMonitor{
+		store:        store,
+		offlineAfter: offlineAfter,
+		offline:      make(map[string]bool),
+		suppressed:   make(map[string]bool),
+
+		uploadsStalled: make(map[string]bool),
+		outages:        make(map[facilityKey]bool),
+	}
+}
+
+// threshold returns the heartbeat gap that triggers an alert for the device.
+func (m *OfflineMonitor) threshold(device DeviceStats, groupThresholds map[string]time.Duration) time.Duration {
+	return offlineThreshold(device, groupThresholds, m.offlineAfter)
+}
+
+// offlineThreshold returns the heartbeat gap after which the device counts
+// as offline: its own alert_after, else the tightest threshold of its
+// groups, else offlineAfter, stretched to offlineIntervals of the device's
+// configured or detected cadence for devices slower than it allows.
+func offlineThreshold(device DeviceStats, groupThresholds map[string]time.Duration, offlineAfter time.Duration) time.Duration {
+	if device.AlertAfter > 0 {
+		return device.AlertAfter
+	}
+	if threshold, ok := groupThresholds[device.ID]; ok {
+		return threshold
+	}
+	if device.IntervalSource() == intervalSourceDefault {
+		return offlineAfter
+	}
+	return max(offlineAfter, offlineIntervals*device.EffectiveInterval())
+}
+
+// monitoredDevice is a device's heartbeat gap at one check.
+type monitoredDevice struct {
+	device    DeviceStats
+	lastSeen  time.Time
+	threshold time.Duration
+	offline   bool
+}
+
+// Check compares every device's heartbeat gap against its threshold and
+// returns the devices alerted as offline or recovered since the last check.
+// Devices that have never sent a heartbeat, or are decommissioned, never
+// alert, nor do devices in a facility outage until it ends. A check the
+// store can't answer is skipped and logged, leaving the alert state as is.
+func (m *OfflineMonitor) Check(ctx context.Context, now time.Time) (wentOffline, recovered []string) {
+	groupThresholds, err := m.store.GroupAlertThresholds(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Offline check skipped: %v", err)
+		return nil, nil
+	}
+	devices, err := m.store.ListDevices(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Offline check skipped: %v", err)
+		return nil, nil
+	}
+	m.forgetRemoved(devices)
+
+	var checked []monitoredDevice
+	for _, device := range devices {
+		// Planned downtime neither alerts nor recovers; afterwards silence
+		// is measured from the end of maintenance
+		if device.maintenance.active(now) {
+			continue
+		}
+		// Muted devices neither alert nor recover either, but silence
+		// still counts, so one still down when the mute expires alerts
+		if device.Muted(now) {
+			continue
+		}
+		lastSeen := maxTime(device.LastHeartbeat, device.maintenance.lastEnd(now))
+
+		threshold := m.threshold(device, groupThresholds)
+		checked = append(checked, monitoredDevice{
+			device:    device,
+			lastSeen:  lastSeen,
+			threshold: threshold,
+			offline: device.DecommissionedAt.IsZero() &&
+				!device.LastHeartbeat.IsZero() &&
+				now.Sub(lastSeen) > threshold,
+		})
+	}
+
+	// Devices held back by an outage that just ended alert now
+	for _, id := range m.checkOutages(checked, now) {
+		delete(m.suppressed, id)
+		wentOffline = append(wentOffline, id)
+		log.Printf("[ALERT] Device %s still offline after its facility's outage", id)
+		if m.Notify != nil {
+			m.Notify(WebhookDeviceOffline, id, now)
+		}
+	}
+
+	for _, c := range checked {
+		device := c.device
+		silence := "no heartbeat for " + now.Sub(c.lastSeen).Round(time.Second).String()
+		switch {
+		case c.offline && !m.offline[device.ID] && m.outages[deviceFacility(device)]:
+			m.offline[device.ID] = true
+			m.suppressed[device.ID] = true
+			m.recordEvent(ctx, device.ID, eventOffline, now, silence+"; alert held for the facility outage")
+		case c.offline && !m.offline[device.ID]:
+			m.offline[device.ID] = true
+			m.recordEvent(ctx, device.ID, eventOffline, now, silence)
+			wentOffline = append(wentOffline, device.ID)
+			log.Printf("[ALERT] Device %s offline: no heartbeat for %v (threshold %v)",
+				device.ID, now.Sub(c.lastSeen).Round(time.Second), c.threshold)
+			if m.Notify != nil {
+				m.Notify(WebhookDeviceOffline, device.ID, now)
+			}
+		case !c.offline && m.suppressed[device.ID]:
+			// Never alerted, so its recovery isn't either
+			delete(m.offline, device.ID)
+			delete(m.suppressed, device.ID)
+			m.recordEvent(ctx, device.ID, eventOnline, now, "")
+		case !c.offline && m.offline[device.ID]:
+			delete(m.offline, device.ID)
+			m.recordEvent(ctx, device.ID, eventOnline, now, "")
+			recovered = append(recovered, device.ID)
+			log.Printf("[INFO] Device %s back online", device.ID)
+			if m.Notify != nil {
+				m.Notify(WebhookDeviceOnline, device.ID, now)
+			}
+		}
+
+		// An offline device keeps its upload alert state until it's back
+		if !c.offline {
+			m.checkUploads(device, now)
+		}
+	}
+	return wentOffline, recovered
+}
+
+// recordEvent adds an event to the device's timeline. The alert stands even
+// if the store fails to record it.
+func (m *OfflineMonitor) recordEvent(ctx context.Context, deviceID, eventType string, at time.Time, detail string) {
+	if err := m.store.RecordEvent(ctx, deviceID, eventType, at, detail); err != nil {
+		log.Printf("[WARN] Failed to record %s event for %s: %v", eventType, deviceID, err)
+	}
+}
+
+// forgetRemoved drops the alert state of devices no longer registered, such
+// as ones a registry reload removed, so it doesn't grow with every device
+// ever seen, and a device registered again under the same ID starts afresh.
+func (m *OfflineMonitor) forgetRemoved(devices []DeviceStats) {
+	if len(m.offline) == 0 && len(m.uploadsStalled) == 0 {
+		return
+	}
+	registered := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		registered[device.ID] = true
+	}
+	for _, state := range []map[string]bool{m.offline, m.suppressed, m.uploadsStalled} {
+		maps.DeleteFunc(state, func(id string, _ bool) bool {
+			return !registered[id]
+		})
+	}
+}
+
+// Run checks every interval until ctx is cancelled.
+func (m *OfflineMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Check(ctx, now)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOfflineMonitor_PerDeviceThreshold tests that alert_after overrides the default threshold
+func TestOfflineMonitor_PerDeviceThreshold(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera", AlertAfter: 3 * time.Minute}
+	s.devices["kiosk"] = &DeviceStats{ID: "kiosk", AlertAfter: 30 * time.Minute}
+	s.devices["default"] = &DeviceStats{ID: "default"}
+	s.devices["never-seen"] = &DeviceStats{ID: "never-seen"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "camera", t1)
+	s.RecordHeartbeat(t.Context(), "kiosk", t1)
+	s.RecordHeartbeat(t.Context(), "default", t1)
+
+	m := NewOfflineMonitor(s, 5*time.Minute)
+
+	offline, _ := m.Check(t.Context(), t1.Add(4*time.Minute))
+	if !slices.Equal(offline, []string{"camera"}) {
+		t.Errorf("after 4m expected only camera offline, got %v", offline)
+	}
+
+	offline, _ = m.Check(t.Context(), t1.Add(10*time.Minute))
+	if !slices.Equal(offline, []string{"default"}) {
+		t.Errorf("after 10m expected only default newly offline, got %v", offline)
+	}
+
+	offline, _ = m.Check(t.Context(), t1.Add(31*time.Minute))
+	if !slices.Equal(offline, []string{"kiosk"}) {
+		t.Errorf("after 31m expected only kiosk newly offline, got %v", offline)
+	}
+}
+
+// TestOfflineMonitor_Recovery tests that a device alerts once and recovers on its next heartbeat
+func TestOfflineMonitor_Recovery(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	s.devices["device-2"] = &DeviceStats{ID: "device-2"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+	s.RecordHeartbeat(t.Context(), "device-2", t1)
+	s.Decommission(t.Context(), "device-2", t1)
+
+	m := NewOfflineMonitor(s, time.Minute)
+
+	if offline, _ := m.Check(t.Context(), t1.Add(2*time.Minute)); !slices.Equal(offline, []string{"device-1"}) {
+		t.Errorf("expected device-1 offline and decommissioned device-2 ignored, got %v", offline)
+	}
+	if offline, _ := m.Check(t.Context(), t1.Add(3*time.Minute)); len(offline) != 0 {
+		t.Errorf("expected no repeat alert, got %v", offline)
+	}
+
+	s.RecordHeartbeat(t.Context(), "device-1", t1.Add(3*time.Minute))
+	if _, recovered := m.Check(t.Context(), t1.Add(3*time.Minute)); !slices.Equal(recovered, []string{"device-1"}) {
+		t.Errorf("expected device-1 recovered, got %v", recovered)
+	}
+}
+
+// TestOfflineMonitor_ReloadForgetsRemoved tests that devices a reload
+// removes are forgotten, so one registered again alerts afresh
+func TestOfflineMonitor_ReloadForgetsRemoved(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	s.devices["device-2"] = &DeviceStats{ID: "device-2"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+	s.RecordHeartbeat(t.Context(), "device-2", t1)
+
+	m := NewOfflineMonitor(s, time.Minute)
+	if offline, _ := m.Check(t.Context(), t1.Add(2*time.Minute)); len(offline) != 2 {
+		t.Fatalf("expected both devices offline, got %v", offline)
+	}
+
+	s.ReplaceDevices(t.Context(), []DeviceStats{{ID: "device-1"}})
+	m.Check(t.Context(), t1.Add(3*time.Minute))
+	if m.offline["device-2"] {
+		t.Error("expected the removed device to be forgotten")
+	}
+	if !m.offline["device-1"] {
+		t.Error("expected the remaining device to stay offline")
+	}
+
+	s.ReplaceDevices(t.Context(), []DeviceStats{{ID: "device-1"}, {ID: "device-2"}})
+	s.RecordHeartbeat(t.Context(), "device-2", t1.Add(3*time.Minute))
+	if offline, _ := m.Check(t.Context(), t1.Add(5*time.Minute)); !slices.Equal(offline, []string{"device-2"}) {
+		t.Errorf("expected the re-registered device to alert again, got %v", offline)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// On-call can mute a device under repair so the offline monitor stops
+// alerting on it. A mute always has an expiry, so a forgotten one can't
+// silence a device for good.
+
+// maxMuteDuration is the longest a device can be muted for at once.
+const maxMuteDuration = 7 * 24 * time.Hour
+
+// Muted reports whether the device's offline alerts are silenced at now.
+func (device *DeviceStats) Muted(now time.Time) bool {
+	return now.Before(device.MutedUntil)
+}
+
+// Mute silences the device's offline alerts until the given time; the zero
+// time unmutes it.
+func (s *Store) Mute(ctx context.Context, deviceID string, until time.Time) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+	device.MutedUntil = until
+	return nil
+}
+
+// MuteResponse reports a device's mute.
+type MuteResponse struct {
+	DeviceID   string    `json:"device_id"`
+	Muted      bool      `json:"muted"`
+	MutedUntil time.Time `json:"muted_until,omitzero"`
+}
+
+// HandleMute processes POST and DELETE /api/v1/devices/{device_id}/mute
+func (s *Server) HandleMute(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] %s /api/v1/devices/%s/mute", r.Method, deviceID)
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	// DELETE unmutes; POST mutes for ?duration=, accepting whole days such as "1d"
+	var until time.Time
+	if r.Method == http.MethodPost {
+		d, err := parseWindow(r.URL.Query().Get("duration"))
+		if err != nil || d <= 0 || d > maxMuteDuration {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("duration must be positive and at most %v (e.g. 2h or 1d)", maxMuteDuration))
+			return
+		}
+		until = time.Now().Add(d).UTC().Truncate(time.Second)
+	}
+
+	if err := s.store.Mute(r.Context(), deviceID, until); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	if until.IsZero() {
+		log.Printf("[INFO] Device unmuted: %s", deviceID)
+	} else {
+		log.Printf("[INFO] Device muted: %s until %s", deviceID, until.Format(time.RFC3339))
+	}
+	writeJSON(w, http.StatusOK, MuteResponse{DeviceID: deviceID, Muted: !until.IsZero(), MutedUntil: until})
+}
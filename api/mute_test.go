@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOfflineMonitor_Mute tests that a muted device doesn't alert until the mute expires
+func TestOfflineMonitor_Mute(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera"}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "camera", t1)
+	s.Mute(t.Context(), "camera", t1.Add(2*time.Hour))
+
+	m := NewOfflineMonitor(s, 5*time.Minute)
+	if offline, _ := m.Check(t.Context(), t1.Add(time.Hour)); len(offline) != 0 {
+		t.Errorf("expected no alert while muted, got %v", offline)
+	}
+	// Still silent once the mute expires
+	if offline, _ := m.Check(t.Context(), t1.Add(2*time.Hour)); !slices.Equal(offline, []string{"camera"}) {
+		t.Errorf("expected camera offline after the mute expired, got %v", offline)
+	}
+
+	// Muting an alerted device holds back its recovery too
+	s.Mute(t.Context(), "camera", t1.Add(3*time.Hour))
+	s.RecordHeartbeat(t.Context(), "camera", t1.Add(150*time.Minute))
+	if _, recovered := m.Check(t.Context(), t1.Add(151*time.Minute)); len(recovered) != 0 {
+		t.Errorf("expected no recovery while muted, got %v", recovered)
+	}
+	s.Mute(t.Context(), "camera", time.Time{})
+	if _, recovered := m.Check(t.Context(), t1.Add(152*time.Minute)); !slices.Equal(recovered, []string{"camera"}) {
+		t.Errorf("expected camera recovered once unmuted, got %v", recovered)
+	}
+}
+
+// TestHandleMute tests muting and unmuting through the API
+func TestHandleMute(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	listed := func() DeviceSummary {
+		var list DeviceListResponse
+		_ = json.NewDecoder(do(http.MethodGet, "/api/v1/devices").Body).Decode(&list)
+		return list.Devices[0]
+	}
+
+	before := time.Now()
+	rr := do(http.MethodPost, "/api/v1/devices/device-1/mute?duration=2h")
+	var resp MuteResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK || !resp.Muted {
+		t.Fatalf("unexpected response %d %+v (%v)", rr.Code, resp, err)
+	}
+	if resp.MutedUntil.Before(before.Add(2*time.Hour-time.Second)) || resp.MutedUntil.After(time.Now().Add(2*time.Hour)) {
+		t.Errorf("expected muted for 2h, got until %v", resp.MutedUntil)
+	}
+	if got := listed(); !got.MutedUntil.Equal(resp.MutedUntil) {
+		t.Errorf("expected muted_until in the device list, got %+v", got)
+	}
+
+	rr = do(http.MethodDelete, "/api/v1/devices/device-1/mute")
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Muted || !listed().MutedUntil.IsZero() {
+		t.Errorf("expected device unmuted, got %d %+v", rr.Code, resp)
+	}
+
+	for _, duration := range []string{"", "-1h", "8d", "soon"} {
+		if rr := do(http.MethodPost, "/api/v1/devices/device-1/mute?duration="+duration); rr.Code != http.StatusBadRequest {
+			t.Errorf("duration %q: expected 400, got %d", duration, rr.Code)
+		}
+	}
+	if rr := do(http.MethodPost, "/api/v1/devices/device-9/mute?duration=1h"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown device, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"math"
+	"time"
+)
+
+// Network quality is judged from the gaps between consecutive heartbeats.
+// A device on flaky Wi-Fi delivers heartbeats late or drops some; a dead
+// camera stops altogether. The score covers only the gaps the device has
+// closed, so a dead camera keeps the score it had while alive and shows up
+// as a stale last heartbeat instead.
+
+// recordGapLocked measures the gap since the device's last heartbeat.
+// Out-of-order heartbeats, older than the last one, aren't measured.
+// Callers must hold s.mu for writing, before LastHeartbeat is updated.
+func (s *Store) recordGapLocked(device *DeviceStats, sentAt time.Time) {
+	if device.HeartbeatCount == 0 || !sentAt.After(device.LastHeartbeat) {
+		return
+	}
+	interval := device.EffectiveInterval()
+
+	// Maintenance expects no heartbeats, so it isn't counted against the device
+	var maintenance time.Duration
+	if len(s.maintenance) > 0 {
+		maintenance = newMaintenanceSchedule(s.maintenance, device, s.groups).overlap(device.LastHeartbeat, sentAt)
+	}
+	gap := sentAt.Sub(device.LastHeartbeat) - maintenance
+	s.observeHeartbeatGapLocked(device, gap)
+
+	// Gaps spanning maintenance say nothing about the device's cadence
+	if maintenance == 0 {
+		s.sampleGapLocked(device, gap)
+	}
+
+	// A gap of at least 1.5 intervals missed a heartbeat per extra interval
+	device.HeartbeatGaps++
+	if intervals := int64(math.Round(float64(gap) / float64(interval))); intervals > 1 {
+		device.MissedHeartbeats += intervals - 1
+		return
+	}
+	device.JitterGaps++
+	device.JitterSum += (gap - interval).Abs()
+}
+
+// NetworkQuality summarizes a device's heartbeat gaps.
+type NetworkQuality struct {
+	Score            float64       // 0-100
+	Jitter           time.Duration // mean deviation from the interval of gaps without misses
+	MissedHeartbeats int64
+}
+
+// NetworkQuality scores the device's connectivity: the share of expected
+// heartbeats delivered, reduced by up to half as jitter approaches a full
+// interval. The bool is false until two heartbeats have been received.
+func (device *DeviceStats) NetworkQuality() (NetworkQuality, bool) {
+	if device.HeartbeatGaps == 0 {
+		return NetworkQuality{}, false
+	}
+	interval := device.EffectiveInterval()
+
+	var q NetworkQuality
+	q.MissedHeartbeats = device.MissedHeartbeats
+	if device.JitterGaps > 0 {
+		q.Jitter = device.JitterSum / time.Duration(device.JitterGaps)
+	}
+	delivered := float64(device.HeartbeatGaps) / float64(device.HeartbeatGaps+device.MissedHeartbeats)
+	jitterRatio := min(float64(q.Jitter)/float64(interval), 1)
+	q.Score = roundTo(delivered*(1-jitterRatio/2)*100, 1)
+	return q, true
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestNetworkQuality tests scoring steady, jittery and lossy heartbeats
+func TestNetworkQuality(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		offsets   []time.Duration // heartbeat times after base
+		score     float64
+		jitter    time.Duration
+		missed    int64
+		hasResult bool
+	}{
+		{"single heartbeat", []time.Duration{0}, 0, 0, 0, false},
+		{"steady", []time.Duration{0, time.Minute, 2 * time.Minute}, 100, 0, 0, true},
+		// Gaps of 80s and 40s: 20s average deviation, a third of an interval
+		{"jittery", []time.Duration{0, 80 * time.Second, 2 * time.Minute}, 83.3, 20 * time.Second, 0, true},
+		// A 4 minute gap misses 3 heartbeats: 2 gaps delivered of 5 expected
+		{"lossy", []time.Duration{0, time.Minute, 5 * time.Minute}, 40, 0, 3, true},
+		// The late heartbeat is older than the last, so it isn't measured
+		{"out of order", []time.Duration{0, 2 * time.Minute, time.Minute}, 50, 0, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+			for _, offset := range tt.offsets {
+				s.RecordHeartbeat(t.Context(), "device-1", base.Add(offset))
+			}
+			device, _ := s.Device(t.Context(), "device-1")
+			q, ok := device.NetworkQuality()
+			if ok != tt.hasResult || q.Score != tt.score || q.Jitter != tt.jitter || q.MissedHeartbeats != tt.missed {
+				t.Errorf("expected %v/%v/%d (%v), got %+v (%v)", tt.score, tt.jitter, tt.missed, tt.hasResult, q, ok)
+			}
+		})
+	}
+}
+
+// TestNetworkQuality_Maintenance tests that a gap spanning maintenance isn't counted as missed
+func TestNetworkQuality_Maintenance(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	s.AddMaintenance(t.Context(), MaintenanceWindow{Start: base.Add(30 * time.Second), End: base.Add(time.Hour + 30*time.Second)})
+
+	s.RecordHeartbeat(t.Context(), "device-1", base)
+	s.RecordHeartbeat(t.Context(), "device-1", base.Add(time.Hour+time.Minute))
+
+	device, _ := s.Device(t.Context(), "device-1")
+	if q, _ := device.NetworkQuality(); q.MissedHeartbeats != 0 || q.Score != 100 {
+		t.Errorf("expected maintenance excused, got %+v", q)
+	}
+}
+
+// TestStats_NetworkQuality tests the score in the stats response
+func TestStats_NetworkQuality(t *testing.T) {
+	server := setupTestServer()
+	base := time.Now().UTC().Add(-time.Hour)
+	server.store.RecordHeartbeat(t.Context(), "device-1", base)
+	router := server.Router()
+
+	get := func() map[string]any {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats?format=seconds", nil))
+		var resp map[string]any
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+
+	if resp := get(); resp["network_score"] != nil {
+		t.Errorf("expected no score after one heartbeat, got %v", resp)
+	}
+	server.store.RecordHeartbeat(t.Context(), "device-1", base.Add(70*time.Second))
+	if resp := get(); resp["network_score"] != 91.7 || resp["jitter"] != 10.0 || resp["missed_heartbeats"] != 0.0 {
+		t.Errorf("unexpected network quality %v", resp)
+	}
+}
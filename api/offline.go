@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GET /api/v1/fleet/offline is the morning triage list: every device silent
+// for longer than a threshold, longest first. Unlike the offline monitor it
+// judges every device by the same threshold and doesn't excuse maintenance
+// or mutes; it flags them instead, so on-call can skip devices someone is
+// already handling.
+
+// FleetOfflineResponse is the body of GET /api/v1/fleet/offline.
+type FleetOfflineResponse struct {
+	Threshold        Duration       `json:"threshold"`
+	Silent           int            `json:"silent"`            // heartbeated, but not within the threshold
+	NeverHeartbeated int            `json:"never_heartbeated"` // no heartbeat counted yet
+	Devices          []SilentDevice `json:"devices"`           // longest silence first, never heartbeated last, one page at a time
+	NextCursor       string         `json:"next_cursor,omitempty"`
+}
+
+// SilentDevice is a device in the offline report.
+type SilentDevice struct {
+	DeviceID      string    `json:"device_id"`
+	Org           string    `json:"org,omitempty"`
+	Lifecycle     string    `json:"lifecycle"`
+	LastHeartbeat time.Time `json:"last_heartbeat,omitzero"`
+	Downtime      *Duration `json:"downtime"`              // since the last heartbeat; null if it never heartbeated
+	Maintenance   bool      `json:"maintenance,omitempty"` // under a maintenance window now
+	MutedUntil    time.Time `json:"muted_until,omitzero"`
+}
+
+// HandleFleetOffline processes GET /api/v1/fleet/offline
+func (s *Server) HandleFleetOffline(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/fleet/offline")
+
+	threshold := s.offlineAfter
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "threshold must be a positive duration (e.g. 10m or 1d)")
+			return
+		}
+		threshold = d
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	now := time.Now().UTC()
+	resp := FleetOfflineResponse{Threshold: format.duration(threshold), Devices: []SilentDevice{}}
+	devices, err := s.fleetDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	var silent []DeviceStats
+	for _, device := range devices {
+		// Devices scheduled for a later installation aren't expected to heartbeat yet
+		if device.ActivatedAt.After(now) {
+			continue
+		}
+		if device.LastHeartbeat.IsZero() {
+			resp.NeverHeartbeated++
+		} else if now.Sub(device.LastHeartbeat) > threshold {
+			resp.Silent++
+		} else {
+			continue
+		}
+		silent = append(silent, device)
+	}
+
+	// Never-heartbeated devices sort last: they're usually not installed yet.
+	// Ties keep fleetDevices' ID order, so the zero-padded heartbeat time
+	// plus ID is a unique, ordered key
+	sort.SliceStable(silent, func(i, j int) bool {
+		a, b := silent[i].LastHeartbeat, silent[j].LastHeartbeat
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		return a.Before(b)
+	})
+	silent, resp.NextCursor = paginate(silent, func(device DeviceStats) string {
+		if device.LastHeartbeat.IsZero() {
+			return "1/" + device.ID
+		}
+		return fmt.Sprintf("0/%020d/%s", device.LastHeartbeat.UnixNano(), device.ID)
+	}, page)
+
+	for _, device := range silent {
+		entry := SilentDevice{
+			DeviceID:      device.ID,
+			Org:           device.Org,
+			Lifecycle:     device.Lifecycle(now),
+			LastHeartbeat: device.LastHeartbeat,
+			Maintenance:   device.maintenance.active(now),
+		}
+		if !device.LastHeartbeat.IsZero() {
+			downtime := format.duration(now.Sub(device.LastHeartbeat))
+			entry.Downtime = &downtime
+		}
+		if device.Muted(now) {
+			entry.MutedUntil = device.MutedUntil
+		}
+		resp.Devices = append(resp.Devices, entry)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
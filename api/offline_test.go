@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHandleFleetOffline tests listing silent devices longest first, paged
+func TestHandleFleetOffline(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	now := time.Now()
+	store.devices["device-3"] = &DeviceStats{ID: "device-3"}
+	store.devices["device-4"] = &DeviceStats{ID: "device-4"}
+	store.devices["device-5"] = &DeviceStats{ID: "device-5", ActivatedAt: now.Add(24 * time.Hour)}
+	store.RecordHeartbeat(t.Context(), "device-1", now.Add(-time.Hour))
+	store.RecordHeartbeat(t.Context(), "device-3", now.Add(-time.Minute))
+	store.RecordHeartbeat(t.Context(), "device-4", now.Add(-2*time.Hour))
+	store.Mute(t.Context(), "device-1", now.Add(time.Hour))
+	router := server.Router()
+
+	get := func(query string) FleetOfflineResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/fleet/offline"+query, nil))
+		var resp FleetOfflineResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d (%v)", rr.Code, err)
+		}
+		return resp
+	}
+
+	resp := get("?threshold=10m")
+	if resp.Silent != 2 || resp.NeverHeartbeated != 1 || resp.Threshold.Duration != 10*time.Minute {
+		t.Errorf("unexpected totals %+v", resp)
+	}
+	var ids []string
+	for _, device := range resp.Devices {
+		ids = append(ids, device.DeviceID)
+	}
+	if len(ids) != 3 || ids[0] != "device-4" || ids[1] != "device-1" || ids[2] != "device-2" {
+		t.Fatalf("expected device-4, device-1, device-2, got %v", ids)
+	}
+	if resp.Devices[1].MutedUntil.IsZero() || resp.Devices[1].Downtime == nil || resp.Devices[2].Downtime != nil {
+		t.Errorf("unexpected devices %+v", resp.Devices)
+	}
+
+	// The default threshold is the offline monitor's; paging walks the same order
+	var paged []string
+	cursor := ""
+	for range 3 {
+		page := get("?limit=1&cursor=" + cursor)
+		for _, device := range page.Devices {
+			paged = append(paged, device.DeviceID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(paged) != 3 || paged[0] != "device-4" || paged[2] != "device-2" {
+		t.Errorf("expected the pages in order, got %v", paged)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/fleet/offline?threshold=-1m", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative threshold, got %d", rr.Code)
+	}
+}
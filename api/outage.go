@@ -0,0 +1,102 @@
+package api
+
+import (
+	"cmp"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+// A backbone or power failure takes a whole facility offline at once, and
+// paging once per camera buries the one thing worth knowing. When more than
+// OutagePercent of a facility's monitored devices are offline, the offline
+// monitor raises one facility outage alert instead, and holds back the
+// alerts of devices going offline until the outage ends. Devices still
+// offline then alert individually; ones that came back never alert at all.
+// Facilities come from the device CSVs' facility column, which the
+// topology sync fills in. They're counted within each organization, so an
+// outage never lists another tenant's devices; devices without a facility
+// count as one facility per organization.
+
+// facilityKey names a facility within its organization.
+type facilityKey struct {
+	org, facility string
+}
+
+// deviceFacility returns the facility the device counts towards.
+func deviceFacility(device DeviceStats) facilityKey {
+	return facilityKey{org: device.Org, facility: device.Facility}
+}
+
+// facilityCount counts a facility's monitored devices at one check.
+type facilityCount struct {
+	devices int
+	offline []string
+}
+
+// checkOutages starts and ends facility outages from this check's heartbeat
+// gaps, returning the held-back devices of facilities whose outage ended
+// that are still offline.
+func (m *OfflineMonitor) checkOutages(checked []monitoredDevice, now time.Time) []string {
+	if m.OutagePercent <= 0 {
+		return nil
+	}
+
+	counts := make(map[facilityKey]*facilityCount)
+	for _, c := range checked {
+		// Devices that never alert don't count either way
+		if !c.device.DecommissionedAt.IsZero() || c.device.LastHeartbeat.IsZero() {
+			continue
+		}
+		key := deviceFacility(c.device)
+		count := counts[key]
+		if count == nil {
+			count = &facilityCount{}
+			counts[key] = count
+		}
+		count.devices++
+		if c.offline {
+			count.offline = append(count.offline, c.device.ID)
+		}
+	}
+
+	var stillOffline []string
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b facilityKey) int {
+		return cmp.Or(cmp.Compare(a.org, b.org), cmp.Compare(a.facility, b.facility))
+	})
+	for key := range m.outages {
+		if counts[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		count := counts[key]
+		if count == nil {
+			count = &facilityCount{}
+		}
+		out := count.devices >= max(m.OutageMinDevices, 1) &&
+			float64(len(count.offline))*100 > m.OutagePercent*float64(count.devices)
+
+		switch {
+		case out && !m.outages[key]:
+			m.outages[key] = true
+			log.Printf("[ALERT] Facility %q (org %q) outage: %d of %d devices offline", key.facility, key.org, len(count.offline), count.devices)
+			if m.NotifyOutage != nil {
+				m.NotifyOutage(WebhookFacilityOutage, key.org, key.facility, count.offline, now)
+			}
+		case !out && m.outages[key]:
+			delete(m.outages, key)
+			log.Printf("[INFO] Facility %q (org %q) outage over: %d of %d devices offline", key.facility, key.org, len(count.offline), count.devices)
+			if m.NotifyOutage != nil {
+				m.NotifyOutage(WebhookFacilityRecovered, key.org, key.facility, count.offline, now)
+			}
+			for _, id := range count.offline {
+				if m.suppressed[id] {
+					stillOffline = append(stillOffline, id)
+				}
+			}
+		}
+	}
+	return stillOffline
+}
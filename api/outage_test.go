@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOfflineMonitor_Outage tests that a facility outage raises one alert
+// in place of its devices' alerts, and that devices still offline when it
+// ends alert then
+func TestOfflineMonitor_Outage(t *testing.T) {
+	s := NewStore()
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 4; i++ {
+		for _, facility := range []string{"north", "south"} {
+			id := fmt.Sprintf("%s-%d", facility, i)
+			s.devices[id] = &DeviceStats{ID: id, Org: "acme", Facility: facility}
+			s.RecordHeartbeat(t.Context(), id, t1)
+		}
+	}
+
+	var events []string
+	m := NewOfflineMonitor(s, time.Minute)
+	m.OutagePercent, m.OutageMinDevices = 50, 4
+	m.Notify = func(eventType, deviceID string, _ time.Time) { events = append(events, eventType+":"+deviceID) }
+	m.NotifyOutage = func(eventType, _, facility string, offline []string, _ time.Time) {
+		events = append(events, fmt.Sprintf("%s:%s:%v", eventType, facility, offline))
+	}
+
+	// Half of south going quiet is not an outage, but three of north is
+	t2 := t1.Add(2 * time.Minute)
+	for _, id := range []string{"north-4", "south-3", "south-4"} {
+		s.RecordHeartbeat(t.Context(), id, t2)
+	}
+	offline, _ := m.Check(t.Context(), t2)
+	if !slices.Equal(offline, []string{"south-1", "south-2"}) {
+		t.Errorf("expected only south devices alerted, got %v", offline)
+	}
+	want := []string{
+		"facility_outage:north:[north-1 north-2 north-3]",
+		"device_offline:south-1",
+		"device_offline:south-2",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+
+	// north-1 comes back without a recovery alert, ending the outage;
+	// north-2 and north-3 are still out and alert now
+	events = nil
+	s.RecordHeartbeat(t.Context(), "north-1", t2)
+	s.RecordHeartbeat(t.Context(), "north-4", t2)
+	if offline, recovered := m.Check(t.Context(), t2); !slices.Equal(offline, []string{"north-2", "north-3"}) || len(recovered) != 0 {
+		t.Errorf("expected north-2 and north-3 alerted and no recoveries, got %v and %v", offline, recovered)
+	}
+	want = []string{
+		"facility_recovered:north:[north-2 north-3]",
+		"device_offline:north-2",
+		"device_offline:north-3",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+}
+
+// TestOfflineMonitor_OutageMinDevices tests that small facilities never
+// have an outage
+func TestOfflineMonitor_OutageMinDevices(t *testing.T) {
+	s := NewStore()
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for _, id := range []string{"device-1", "device-2"} {
+		s.devices[id] = &DeviceStats{ID: id, Org: "acme"}
+		s.RecordHeartbeat(t.Context(), id, t1)
+	}
+
+	m := NewOfflineMonitor(s, time.Minute)
+	m.OutagePercent, m.OutageMinDevices = 50, 3
+	if offline, _ := m.Check(t.Context(), t1.Add(2*time.Minute)); !slices.Equal(offline, []string{"device-1", "device-2"}) || len(m.outages) != 0 {
+		t.Errorf("expected both devices alerted without an outage, got %v and outages %v", offline, m.outages)
+	}
+}
+
+// TestOfflineMonitor_OutageByOrg tests that facilities with the same name in
+// different organizations are counted apart
+func TestOfflineMonitor_OutageByOrg(t *testing.T) {
+	s := NewStore()
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		for _, org := range []string{"acme", "globex"} {
+			id := fmt.Sprintf("%s-%d", org, i)
+			s.devices[id] = &DeviceStats{ID: id, Org: org, Facility: "main"}
+			s.RecordHeartbeat(t.Context(), id, t1)
+		}
+	}
+
+	var outages []string
+	m := NewOfflineMonitor(s, time.Minute)
+	m.OutagePercent, m.OutageMinDevices = 50, 3
+	m.NotifyOutage = func(eventType, org, facility string, offline []string, _ time.Time) {
+		outages = append(outages, fmt.Sprintf("%s/%s:%v", org, facility, offline))
+	}
+
+	t2 := t1.Add(2 * time.Minute)
+	for i := 1; i <= 3; i++ {
+		s.RecordHeartbeat(t.Context(), fmt.Sprintf("globex-%d", i), t2)
+	}
+	m.Check(t.Context(), t2)
+	if want := []string{"acme/main:[acme-1 acme-2 acme-3]"}; !slices.Equal(outages, want) {
+		t.Errorf("expected only acme's facility out, got %v", outages)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// List endpoints page with opaque cursors rather than offsets. A cursor
+// encodes the sort key of the last item returned, and the next page starts
+// strictly after it, so devices registered or removed while a client is
+// iterating never shift items into or out of pages it hasn't read yet.
+
+// Page sizes for list endpoints.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageQuery holds parsed pagination parameters.
+type pageQuery struct {
+	after string // sort key of the last item already seen; empty for the first page
+	limit int
+}
+
+// parsePageQuery reads the optional cursor and limit parameters, returning a
+// message for the client if either is invalid.
+func parsePageQuery(r *http.Request) (pageQuery, string) {
+	q := pageQuery{limit: defaultPageSize}
+	params := r.URL.Query()
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return q, "limit must be between 1 and " + strconv.Itoa(maxPageSize)
+		}
+		q.limit = limit
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		after, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(after) == 0 {
+			return q, "invalid cursor"
+		}
+		q.after = string(after)
+	}
+	return q, ""
+}
+
+// paginate returns the page of items following the query's cursor, plus the
+// cursor for the next page ("" on the last page). Items must be sorted by
+// key in ascending order, and keys must be unique.
+func paginate[T any](items []T, key func(T) string, q pageQuery) ([]T, string) {
+	start := 0
+	if q.after != "" {
+		start = sort.Search(len(items), func(i int) bool { return key(items[i]) > q.after })
+	}
+	end := min(start+q.limit, len(items))
+
+	page := items[start:end]
+	if end == len(items) {
+		return page, ""
+	}
+	return page, base64.RawURLEncoding.EncodeToString([]byte(key(page[len(page)-1])))
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// TestPaginate_StableUnderInserts tests that items added before the cursor
+// don't shift later pages
+func TestPaginate_StableUnderInserts(t *testing.T) {
+	identity := func(s string) string { return s }
+	items := []string{"b", "d", "f", "h"}
+
+	page, next := paginate(items, identity, pageQuery{limit: 2})
+	if !slices.Equal(page, []string{"b", "d"}) || next == "" {
+		t.Fatalf("unexpected first page %v (next %q)", page, next)
+	}
+
+	// "a" and "c" register between requests
+	items = []string{"a", "b", "c", "d", "f", "h"}
+	q, msg := parsePageQuery(httptest.NewRequest(http.MethodGet, "/?limit=2&cursor="+next, nil))
+	if msg != "" {
+		t.Fatalf("unexpected error: %s", msg)
+	}
+	page, next = paginate(items, identity, q)
+	if !slices.Equal(page, []string{"f", "h"}) || next != "" {
+		t.Errorf("expected last page [f h], got %v (next %q)", page, next)
+	}
+}
+
+// TestListDevices_Pagination tests iterating every device a page at a time
+func TestListDevices_Pagination(t *testing.T) {
+	server := setupTestServer()
+	for i := 3; i <= 5; i++ {
+		id := fmt.Sprintf("device-%d", i)
+		server.backend().(*Store).devices[id] = &DeviceStats{ID: id}
+	}
+	router := server.Router()
+
+	var seen []string
+	cursor := ""
+	for range 10 {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices?limit=2&cursor="+cursor, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+
+		var resp DeviceListResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		for _, d := range resp.Devices {
+			seen = append(seen, d.ID)
+		}
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	want := []string{"device-1", "device-2", "device-3", "device-4", "device-5"}
+	if !slices.Equal(seen, want) {
+		t.Errorf("expected %v, got %v", want, seen)
+	}
+}
+
+// TestListDevices_InvalidPage tests rejection of bad cursor and limit values
+func TestListDevices_InvalidPage(t *testing.T) {
+	router := setupTestServer().Router()
+
+	for _, query := range []string{"limit=0", "limit=5000", "limit=abc", "cursor=!!!"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
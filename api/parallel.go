@@ -0,0 +1,44 @@
+package api
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Fleet endpoints compute a result for every device, and a sequential pass
+// over 100k devices takes seconds. mapDevices spreads that work over the
+// CPUs without holding any lock: callers pass the copies returned by
+// ListDevices.
+
+// parallelMinDevices is the fewest devices each worker gets; smaller fleets
+// are computed on the calling goroutine, where goroutines would cost more
+// than they save.
+const parallelMinDevices = 1024
+
+// mapDevices calls f for every device and returns the results in device
+// order. Large fleets are split into one contiguous chunk per CPU, so memory
+// stays at one result per device however many workers run. f must be safe
+// to call concurrently.
+func mapDevices[T any](devices []DeviceStats, f func(*DeviceStats) T) []T {
+	results := make([]T, len(devices))
+	workers := min(runtime.GOMAXPROCS(0), len(devices)/parallelMinDevices)
+	if workers <= 1 {
+		for i := range devices {
+			results[i] = f(&devices[i])
+		}
+		return results
+	}
+
+	chunk := (len(devices) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(devices); start += chunk {
+		end := min(start+chunk, len(devices))
+		wg.Go(func() {
+			for i := start; i < end; i++ {
+				results[i] = f(&devices[i])
+			}
+		})
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fleet returns n devices with a minute of heartbeats each, the i-th missing i%10 of them.
+func fleet(n int) []DeviceStats {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	devices := make([]DeviceStats, n)
+	for i := range devices {
+		covered := int64(61 - i%10)
+		devices[i] = DeviceStats{ID: fmt.Sprintf("cam-%06d", i), HeartbeatCount: covered, CoveredMinutes: covered,
+			FirstHeartbeat: start, LastHeartbeat: start.Add(time.Hour)}
+	}
+	return devices
+}
+
+// TestMapDevices tests that results keep device order however the fleet is split
+func TestMapDevices(t *testing.T) {
+	for _, n := range []int{0, 1, parallelMinDevices - 1, 5*parallelMinDevices + 7} {
+		devices := fleet(n)
+		ids := mapDevices(devices, func(device *DeviceStats) string { return device.ID })
+		stats := mapDevices(devices, (*DeviceStats).Stats)
+		if len(ids) != n || len(stats) != n {
+			t.Fatalf("n=%d: expected %d results, got %d and %d", n, n, len(ids), len(stats))
+		}
+		for i := range devices {
+			if ids[i] != devices[i].ID || stats[i].Uptime != devices[i].Stats().Uptime {
+				t.Fatalf("n=%d: result %d out of order: %s", n, i, ids[i])
+			}
+		}
+	}
+}
+
+// BenchmarkFleetStats measures computing stats for a 100k device fleet; run
+// with -cpu to compare worker counts
+func BenchmarkFleetStats(b *testing.B) {
+	devices := fleet(100_000)
+	b.Run("sequential", func(b *testing.B) {
+		for b.Loop() {
+			results := make([]StatsResult, len(devices))
+			for i := range devices {
+				results[i] = devices[i].Stats()
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for b.Loop() {
+			mapDevices(devices, (*DeviceStats).Stats)
+		}
+	})
+}
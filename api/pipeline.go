@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The async write pipeline decouples request handling from store writes:
+// handlers enqueue validated telemetry and return 202, and workers apply it
+// in batches under a single lock acquisition. Events are sharded by device,
+// so each device's telemetry is applied in arrival order. When a shard's
+// queue is full the request is shed with 503 instead of blocking.
+
+// maxWriteBatch bounds how many events a worker applies per lock acquisition.
+const maxWriteBatch = 256
+
+var (
+	// errQueueFull is returned when telemetry can't be queued for writing.
+	errQueueFull = errors.New("write queue full")
+	// errPipelineClosed is returned when telemetry arrives after the
+	// pipeline has been stopped.
+	errPipelineClosed = errors.New("write pipeline stopped")
+)
+
+// queuedEvent is telemetry waiting in the write pipeline.
+type queuedEvent struct {
+	TelemetryEvent
+	receipt *receipt // nil unless the request is tracked by a receipt
+}
+
+// Apply applies telemetry in order under one lock acquisition. Events for
+// unknown devices are skipped.
+func (s *Store) Apply(ctx context.Context, events []TelemetryEvent) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.applyBatchLocked(events)
+	return nil
+}
+
+// applyBatchLocked applies events in order. The caller must hold s.mu.
+func (s *Store) applyBatchLocked(events []TelemetryEvent) {
+	for _, ev := range events {
+		device, exists := s.devices[ev.DeviceID]
+		if !exists {
+			continue
+		}
+		if !ev.Heartbeat {
+			s.recordUploadStatLocked(device, UploadRecord{UploadID: ev.UploadID, FileType: ev.FileType, UploadTime: ev.UploadTime, At: ev.At})
+			continue
+		}
+		if ev.Interval > 0 {
+			device.HeartbeatInterval = ev.Interval
+		}
+		if ev.UploadInterval > 0 {
+			device.UploadInterval = ev.UploadInterval
+		}
+		if ev.SourceIP != "" {
+			device.SourceIP, device.SourceIPAt = ev.SourceIP, time.Now().UTC()
+		}
+		device.setVersions(ev.Firmware, ev.Agent)
+		device.recordVitals(ev.Battery, ev.Temperature, ev.DiskFree, ev.At)
+		if !ev.ReceivedAt.IsZero() {
+			device.recordLatency(ev.ReceivedAt.Sub(ev.At))
+		}
+		s.recordHeartbeatLocked(device, ev.At)
+	}
+}
+
+// writePipeline is a set of bounded queues, each drained by one worker.
+type writePipeline struct {
+	store  Storage
+	shards []chan queuedEvent
+	wg     sync.WaitGroup
+
+	// Held for reading while sending, so close never closes a shard under
+	// a sender
+	mu     sync.RWMutex
+	closed bool // protected by mu
+
+	enqueued atomic.Int64
+	applied  atomic.Int64
+	shed     atomic.Int64
+	failed   atomic.Int64
+}
+
+// newWritePipeline starts workers sharing queueSize slots between them.
+func newWritePipeline(store Storage, queueSize, workers int) *writePipeline {
+	p := &writePipeline{store: store, shards: make([]chan queuedEvent, workers)}
+	for i := range p.shards {
+		p.shards[i] = make(chan queuedEvent, max(queueSize/workers, 1))
+		p.wg.Add(1)
+		go p.work(p.shards[i])
+	}
+	return p
+}
+
+// enqueue queues an event on its device's shard without blocking. It
+// returns errPipelineClosed once the pipeline is closed.
+func (p *writePipeline) enqueue(ev queuedEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errPipelineClosed
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(ev.DeviceID))
+	select {
+	case p.shards[h.Sum32()%uint32(len(p.shards))] <- ev:
+		p.enqueued.Add(1)
+		return nil
+	default:
+		p.shed.Add(1)
+		return errQueueFull
+	}
+}
+
+// work applies events from one shard until it is closed and drained. A
+// batch the store fails to apply is logged and its receipts stay pending.
+func (p *writePipeline) work(queue chan queuedEvent) {
+	defer p.wg.Done()
+	batch := make([]queuedEvent, 0, maxWriteBatch)
+	events := make([]TelemetryEvent, 0, maxWriteBatch)
+	for ev := range queue {
+		batch = append(batch[:0], ev)
+		// Take whatever else is already waiting, up to the batch limit
+	drain:
+		for len(batch) < maxWriteBatch {
+			select {
+			case ev, ok := <-queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, ev)
+			default:
+				break drain
+			}
+		}
+		events = events[:0]
+		for _, ev := range batch {
+			events = append(events, ev.TelemetryEvent)
+		}
+		if err := p.store.Apply(context.Background(), events); err != nil {
+			log.Printf("[ERROR] Failed to apply %d queued events: %v", len(events), err)
+			p.failed.Add(int64(len(events)))
+			continue
+		}
+		p.applied.Add(int64(len(batch)))
+		for _, ev := range batch {
+			if ev.receipt != nil {
+				ev.receipt.done()
+			}
+		}
+	}
+}
+
+// close stops accepting events and waits until everything queued is
+// applied. Closing twice is a no-op.
+func (p *writePipeline) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.shards {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// depth returns the number of events waiting to be applied.
+func (p *writePipeline) depth() int {
+	n := 0
+	for _, queue := range p.shards {
+		n += len(queue)
+	}
+	return n
+}
+
+// capacity returns the total number of queue slots.
+func (p *writePipeline) capacity() int {
+	n := 0
+	for _, queue := range p.shards {
+		n += cap(queue)
+	}
+	return n
+}
+
+// EnableAsyncWrites routes telemetry through a write pipeline with queueSize
+// slots drained by the given number of workers. Call StopAsyncWrites once the
+// HTTP server's Shutdown has returned and other telemetry listeners are
+// closed, to apply everything still queued.
+func (s *Server) EnableAsyncWrites(queueSize, workers int) {
+	s.pipeline = newWritePipeline(s.store, queueSize, max(workers, 1))
+}
+
+// StopAsyncWrites drains the write pipeline. Telemetry recorded afterwards is
+// refused rather than queued. It is a no-op when writes are synchronous.
+func (s *Server) StopAsyncWrites() {
+	if s.pipeline != nil {
+		s.pipeline.close()
+	}
+}
+
+// acceptedStatus is the success status for telemetry: 202 when it was
+// queued, 204 when it was written before responding.
+func (s *Server) acceptedStatus() int {
+	if s.pipeline != nil {
+		return http.StatusAccepted
+	}
+	return http.StatusNoContent
+}
+
+// writeQueueFull sheds a request whose telemetry couldn't be queued.
+func writeQueueFull(w http.ResponseWriter) {
+	log.Printf("[WARN] Write queue full, shedding request")
+	w.Header().Set("Retry-After", "1")
+	writeErrorCode(w, http.StatusServiceUnavailable, errCodeQueueFull, "write queue full")
+}
+
+// QueueResponse reports write pipeline metrics.
+type QueueResponse struct {
+	Enabled  bool  `json:"enabled"`
+	Depth    int   `json:"depth"`
+	Capacity int   `json:"capacity"`
+	Enqueued int64 `json:"enqueued"`
+	Applied  int64 `json:"applied"`
+	Shed     int64 `json:"shed"`
+	Failed   int64 `json:"failed"` // dequeued but not applied because the store failed
+}
+
+// HandleQueue processes GET /api/v1/admin/queue
+func (s *Server) HandleQueue(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/queue")
+
+	if s.pipeline == nil {
+		writeJSON(w, http.StatusOK, QueueResponse{})
+		return
+	}
+	writeJSON(w, http.StatusOK, QueueResponse{
+		Enabled:  true,
+		Depth:    s.pipeline.depth(),
+		Capacity: s.pipeline.capacity(),
+		Enqueued: s.pipeline.enqueued.Load(),
+		Applied:  s.pipeline.applied.Load(),
+		Shed:     s.pipeline.shed.Load(),
+		Failed:   s.pipeline.failed.Load(),
+	})
+}
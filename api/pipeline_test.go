@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAsyncWrites tests that queued telemetry returns 202 and is applied by the workers
+func TestAsyncWrites(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAsyncWrites(100, 2)
+	router := server.Router()
+
+	for i := range 10 {
+		sentAt := time.Date(2024, 1, 15, 10, i, 0, 0, time.UTC).Format(time.RFC3339)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(`{"sent_at": "`+sentAt+`", "firmware_version": "2.0"}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status 202, got %d", rr.Code)
+		}
+	}
+	server.StopAsyncWrites()
+
+	device := server.backend().(*Store).devices["device-1"]
+	if device.HeartbeatCount != 10 || device.FirmwareVersion != "2.0" {
+		t.Errorf("expected 10 heartbeats applied, got %+v", device)
+	}
+	// Per-device ordering is preserved, so the last heartbeat is the latest
+	if !device.LastHeartbeat.Equal(time.Date(2024, 1, 15, 10, 9, 0, 0, time.UTC)) {
+		t.Errorf("expected last heartbeat 10:09, got %v", device.LastHeartbeat)
+	}
+	if applied := server.pipeline.applied.Load(); applied != 10 {
+		t.Errorf("expected 10 applied, got %d", applied)
+	}
+}
+
+// TestAsyncWrites_QueueFull tests that requests are shed with 503 when the queue is full
+func TestAsyncWrites_QueueFull(t *testing.T) {
+	server := setupTestServer()
+	// A pipeline with no running workers, so nothing drains
+	server.pipeline = &writePipeline{store: server.store, shards: []chan queuedEvent{make(chan queuedEvent, 1)}}
+	router := server.Router()
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", strings.NewReader(`{"upload_time": 1000000000}`))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post(); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rr.Code)
+	}
+	rr := post()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/queue", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var resp QueueResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if !resp.Enabled || resp.Depth != 1 || resp.Capacity != 1 || resp.Enqueued != 1 || resp.Shed != 1 {
+		t.Errorf("unexpected queue metrics: %+v", resp)
+	}
+}
+
+// TestAsyncWrites_AfterStop tests that telemetry arriving after the pipeline
+// is stopped is refused instead of panicking on a closed queue
+func TestAsyncWrites_AfterStop(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAsyncWrites(100, 2)
+	router := server.Router()
+	server.StopAsyncWrites()
+	server.StopAsyncWrites()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(`{"sent_at": "2024-01-15T10:00:00Z"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 after stop, got %d", rr.Code)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 0 {
+		t.Errorf("expected nothing recorded after stop, got %d heartbeats", device.HeartbeatCount)
+	}
+}
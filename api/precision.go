@@ -0,0 +1,77 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Uptime is a ratio, so it's rarely a short decimal (45.45454545...), and
+// downstream systems that diff responses see every last digit drift as a
+// change. Responses keep values exact by default; a server-wide precision
+// (SetPrecision) or ?precision= per request rounds uptime percentages and
+// durations to a number of decimal places, and ?precision=exact asks for
+// exact values whatever the server's default. Raw counters are always
+// exact, and SLA reports keep their own three places.
+
+// maxPrecision is the most decimal places a precision keeps; a duration has
+// no finer digits than nanoseconds.
+const maxPrecision = 9
+
+// precision is how many decimal places response values keep. The zero
+// value keeps them exact.
+type precision struct {
+	places  int
+	rounded bool
+}
+
+// exactPrecision keeps values exact.
+var exactPrecision = precision{}
+
+// newPrecision returns a precision of places decimal places; negative is exact.
+func newPrecision(places int) precision {
+	if places < 0 {
+		return exactPrecision
+	}
+	return precision{places: min(places, maxPrecision), rounded: true}
+}
+
+// round rounds v, half away from zero.
+func (p precision) round(v float64) float64 {
+	if !p.rounded {
+		return v
+	}
+	return roundTo(v, p.places)
+}
+
+// roundDuration rounds d to places decimal places of a second.
+func (p precision) roundDuration(d time.Duration) time.Duration {
+	if !p.rounded {
+		return d
+	}
+	return d.Round(time.Duration(math.Pow10(maxPrecision - p.places)))
+}
+
+// SetPrecision sets the decimal places responses round uptime and durations
+// to when a request doesn't ask; negative keeps them exact.
+func (s *Server) SetPrecision(places int) {
+	s.precision = newPrecision(places)
+}
+
+// parsePrecision reads the optional precision parameter, returning a
+// message for the client if it is invalid.
+func (s *Server) parsePrecision(r *http.Request) (precision, string) {
+	v := r.URL.Query().Get("precision")
+	switch v {
+	case "":
+		return s.precision, ""
+	case "exact":
+		return exactPrecision, ""
+	}
+	places, err := strconv.Atoi(v)
+	if err != nil || places < 0 || places > maxPrecision {
+		return exactPrecision, "precision must be exact or a number of decimal places from 0 to " + strconv.Itoa(maxPrecision)
+	}
+	return newPrecision(places), ""
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDuration_MarshalJSONPrecision tests that durations round to decimal
+// places of their unit
+func TestDuration_MarshalJSONPrecision(t *testing.T) {
+	d := 1234567891 * time.Nanosecond
+	tests := []struct {
+		unit   durationUnit
+		places int
+		want   string
+	}{
+		{unitGo, 2, `"1.23s"`},
+		{unitGo, 0, `"1s"`},
+		{unitGo, -1, `"1.234567891s"`},
+		{unitSeconds, 3, `1.235`},
+		{unitMillis, 1, `1234.6`},
+		{unitMillis, 0, `1235`},
+		{unitISO8601, 1, `"PT1.2S"`},
+	}
+	for _, tt := range tests {
+		format := durationFormat{unit: tt.unit, precision: newPrecision(tt.places)}
+		got, err := json.Marshal(format.duration(d))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("unit %d with %d places: expected %s, got %s (%v)", tt.unit, tt.places, tt.want, got, err)
+		}
+	}
+}
+
+// TestGetStats_Precision tests the server default and the per-request
+// override, including exact
+func TestGetStats_Precision(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for _, minute := range []int{0, 1, 2, 6} {
+		store.RecordHeartbeat(t.Context(), "device-1", t1.Add(time.Duration(minute)*time.Minute))
+	}
+	store.RecordUploadStat(t.Context(), "device-1", 1234567891*time.Nanosecond)
+	router := server.Router()
+
+	// get returns the uptime and average upload time
+	get := func(query string) (float64, time.Duration, int) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp struct {
+			Uptime        float64 `json:"uptime"`
+			AvgUploadTime string  `json:"avg_upload_time"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		avg, _ := time.ParseDuration(resp.AvgUploadTime)
+		return resp.Uptime, avg, rr.Code
+	}
+
+	uptime, avg, _ := get("")
+	if uptime == roundTo(uptime, 6) || avg != 1234567891 {
+		t.Fatalf("expected exact values by default, got %v and %v", uptime, avg)
+	}
+
+	server.SetPrecision(2)
+	if u, a, _ := get(""); u != roundTo(uptime, 2) || a != 1230*time.Millisecond {
+		t.Errorf("expected the server's 2 places, got %v and %v", u, a)
+	}
+	if u, a, _ := get("?precision=0"); u != roundTo(uptime, 0) || a != time.Second {
+		t.Errorf("expected 0 places, got %v and %v", u, a)
+	}
+	if u, a, _ := get("?precision=exact"); u != uptime || a != avg {
+		t.Errorf("expected exact values, got %v and %v", u, a)
+	}
+	for _, query := range []string{"?precision=10", "?precision=-1", "?precision=two"} {
+		if _, _, code := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import "net/http"
+
+// Error responses are RFC 7807 problem details, as the API gateway's error
+// handling expects. The machine-readable code, the field at fault and the
+// supported Content-Types stay as extension members, and each problem type
+// points at the code's entry in the error catalog. Clients not yet migrated
+// can have the original {"msg", "code"} shape back with SetLegacyErrors.
+
+// contentTypeProblem is the media type of problem detail responses.
+const contentTypeProblem = "application/problem+json"
+
+// ProblemDetails is an RFC 7807 error response.
+type ProblemDetails struct {
+	Type     string `json:"type"`               // the code's entry in the error catalog
+	Title    string `json:"title"`              // the HTTP status text
+	Status   int    `json:"status"`             // repeats the response status
+	Detail   string `json:"detail"`             // what went wrong with this request
+	Instance string `json:"instance,omitempty"` // the request path
+
+	Code      string   `json:"code"`
+	Field     string   `json:"field,omitempty"`     // the request field at fault, if known
+	Supported []string `json:"supported,omitempty"` // accepted Content-Types, on 415
+}
+
+// problemType returns the URI identifying code's problem type.
+func problemType(code string) string {
+	return "/api/v1/errors#" + code
+}
+
+// SetLegacyErrors makes error responses use the original application/json
+// shape instead of problem details.
+func (s *Server) SetLegacyErrors(enabled bool) {
+	s.legacyErrors = enabled
+}
+
+// responseFormatWriter carries what a response's shape depends on from the
+// request down to the handlers, which only get the writer.
+type responseFormatWriter struct {
+	http.ResponseWriter
+	instance string
+	legacy   bool
+	envelope bool // see envelope.go
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController (e.g. for Flush).
+func (w *responseFormatWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// formatResponses records the request path, the configured error shape and
+// whether to envelope for any response written below it.
+func (s *Server) formatResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&responseFormatWriter{
+			ResponseWriter: w,
+			instance:       r.URL.Path,
+			legacy:         s.legacyErrors,
+			envelope:       s.wantsEnvelope(r),
+		}, r)
+	})
+}
+
+// responseFormat finds the responseFormatWriter wrapping w, if any.
+func responseFormat(w http.ResponseWriter) *responseFormatWriter {
+	for {
+		switch v := w.(type) {
+		case *responseFormatWriter:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// writeErrorResponse writes resp as problem details, or as is when legacy
+// errors are enabled, either in an envelope's error member if requested.
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	format := responseFormat(w)
+	if format != nil && format.envelope {
+		var body any = resp
+		if !format.legacy {
+			body = problemDetails(status, resp, format.instance)
+		}
+		encodeJSON(w, status, "application/json", Envelope{Error: body})
+		return
+	}
+	if format != nil && format.legacy {
+		encodeJSON(w, status, "application/json", resp)
+		return
+	}
+
+	var instance string
+	if format != nil {
+		instance = format.instance
+	}
+	encodeJSON(w, status, contentTypeProblem, problemDetails(status, resp, instance))
+}
+
+// problemDetails converts resp to problem details for a request to instance.
+func problemDetails(status int, resp ErrorResponse, instance string) ProblemDetails {
+	return ProblemDetails{
+		Type:      problemType(resp.Code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    resp.Msg,
+		Instance:  instance,
+		Code:      resp.Code,
+		Field:     resp.Field,
+		Supported: resp.Supported,
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestProblemDetails tests that errors are RFC 7807 problem details
+func TestProblemDetails(t *testing.T) {
+	router := setupTestServer().Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/nope/stats", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeProblem {
+		t.Errorf("expected Content-Type %s, got %s", contentTypeProblem, ct)
+	}
+	var resp ProblemDetails
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	want := ProblemDetails{
+		Type:     "/api/v1/errors#ERR_DEVICE_NOT_FOUND",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "device not found",
+		Instance: "/api/v1/devices/nope/stats",
+		Code:     errCodeDeviceNotFound,
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("expected %+v, got %+v", want, resp)
+	}
+
+	// Extension members survive the new shape
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "text/plain")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	resp = ProblemDetails{}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Status != http.StatusUnsupportedMediaType || resp.Code != errCodeUnsupportedMediaType || len(resp.Supported) == 0 {
+		t.Errorf("expected a 415 listing supported types, got %+v", resp)
+	}
+}
+
+// TestLegacyErrors tests that the compatibility flag restores the original shape
+func TestLegacyErrors(t *testing.T) {
+	server := setupTestServer()
+	server.SetLegacyErrors(true)
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/nope/stats", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", ct)
+	}
+	var body map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&body)
+	want := map[string]any{"msg": "device not found", "code": errCodeDeviceNotFound}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("expected %v, got %v", want, body)
+	}
+}
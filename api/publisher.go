@@ -0,0 +1,441 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event publishing streams every accepted heartbeat and upload stat to a
+// message broker, so analytics pipelines consume raw events instead of
+// scraping aggregates. Publishing is best effort and never slows ingestion:
+// events go through a bounded buffer, and are dropped and counted when the
+// broker falls behind.
+
+const (
+	// maxPublishBatch bounds how many events are sent to the broker at once
+	maxPublishBatch = 500
+
+	// publishTimeout bounds one batch, so a hung broker can't stall the stream
+	publishTimeout = 10 * time.Second
+)
+
+// PublishedEvent is the wire format of a published heartbeat or upload stat.
+type PublishedEvent struct {
+	Type       string    `json:"type"` // "heartbeat" or "upload"
+	DeviceID   string    `json:"device_id"`
+	Org        string    `json:"org,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+	ReceivedAt time.Time `json:"received_at"`
+
+	// Heartbeat fields
+	HeartbeatInterval int64    `json:"heartbeat_interval,omitempty"` // nanoseconds
+	FirmwareVersion   string   `json:"firmware_version,omitempty"`
+	AgentVersion      string   `json:"agent_version,omitempty"`
+	BatteryPct        *float64 `json:"battery_pct,omitempty"`
+	TemperatureC      *float64 `json:"temperature_c,omitempty"`
+	DiskFreeBytes     *float64 `json:"disk_free_bytes,omitempty"`
+
+	// Upload fields
+	UploadTime int64  `json:"upload_time,omitempty"` // nanoseconds
+	UploadID   string `json:"upload_id,omitempty"`
+	FileType   string `json:"file_type,omitempty"`
+}
+
+// newPublishedEvent converts an accepted event for the wire.
+func newPublishedEvent(ev TelemetryEvent, org string, received time.Time) PublishedEvent {
+	if !ev.Heartbeat {
+		return PublishedEvent{
+			Type:       ingestTypeUpload,
+			DeviceID:   ev.DeviceID,
+			Org:        org,
+			SentAt:     ev.At,
+			ReceivedAt: received,
+			UploadTime: int64(ev.UploadTime),
+			UploadID:   ev.UploadID,
+			FileType:   ev.FileType,
+		}
+	}
+	return PublishedEvent{
+		Type:              ingestTypeHeartbeat,
+		DeviceID:          ev.DeviceID,
+		Org:               org,
+		SentAt:            ev.At,
+		ReceivedAt:        received,
+		HeartbeatInterval: int64(ev.Interval),
+		FirmwareVersion:   ev.Firmware,
+		AgentVersion:      ev.Agent,
+		BatteryPct:        ev.Battery,
+		TemperatureC:      ev.Temperature,
+		DiskFreeBytes:     ev.DiskFree,
+	}
+}
+
+// EventPublisher sends events to a broker topic. Publish is only called from
+// one goroutine at a time.
+type EventPublisher interface {
+	// Publish delivers a batch, returning once the broker has accepted it.
+	Publish(ctx context.Context, events []PublishedEvent) error
+	// Close releases the broker connection.
+	Close() error
+}
+
+// eventStream buffers events between request handlers and the publisher.
+type eventStream struct {
+	publisher EventPublisher
+	events    chan PublishedEvent
+	done      chan struct{}
+
+	// Telemetry from the UDP listener can still arrive during shutdown, so
+	// sends are guarded against the channel being closed
+	mu     sync.RWMutex
+	closed bool // protected by mu
+
+	published atomic.Int64
+	dropped   atomic.Int64 // buffer full
+	failed    atomic.Int64 // rejected by, or undeliverable to, the broker
+}
+
+// newEventStream starts publishing events buffered up to bufferSize.
+func newEventStream(publisher EventPublisher, bufferSize int) *eventStream {
+	st := &eventStream{
+		publisher: publisher,
+		events:    make(chan PublishedEvent, max(bufferSize, 1)),
+		done:      make(chan struct{}),
+	}
+	go st.run()
+	return st
+}
+
+// send buffers an event without blocking, dropping it if the buffer is full.
+func (st *eventStream) send(ev PublishedEvent) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if st.closed {
+		st.dropped.Add(1)
+		return
+	}
+	select {
+	case st.events <- ev:
+	default:
+		st.dropped.Add(1)
+	}
+}
+
+// run publishes buffered events in batches until the stream is closed and drained.
+func (st *eventStream) run() {
+	defer close(st.done)
+	batch := make([]PublishedEvent, 0, maxPublishBatch)
+	for ev := range st.events {
+		batch = append(batch[:0], ev)
+		// Take whatever else is already waiting, up to the batch limit
+	drain:
+		for len(batch) < maxPublishBatch {
+			select {
+			case ev, ok := <-st.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, ev)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		err := st.publisher.Publish(ctx, batch)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] Failed to publish %d events: %v", len(batch), err)
+			st.failed.Add(int64(len(batch)))
+			continue
+		}
+		st.published.Add(int64(len(batch)))
+	}
+}
+
+// close stops accepting events, publishes everything buffered and closes the publisher.
+func (st *eventStream) close() {
+	st.mu.Lock()
+	st.closed = true
+	close(st.events)
+	st.mu.Unlock()
+
+	<-st.done
+	if err := st.publisher.Close(); err != nil {
+		log.Printf("[WARN] Failed to close event publisher: %v", err)
+	}
+}
+
+// EnablePublishing publishes every accepted event through publisher, buffering
+// up to bufferSize events. Call StopPublishing after the HTTP server and the
+// write pipeline have shut down to flush the buffer.
+func (s *Server) EnablePublishing(publisher EventPublisher, bufferSize int) {
+	s.events = newEventStream(publisher, bufferSize)
+}
+
+// StopPublishing flushes and closes the event stream. It is a no-op when
+// publishing is disabled.
+func (s *Server) StopPublishing() {
+	if s.events != nil {
+		s.events.close()
+	}
+}
+
+// publish hands an accepted event to the event stream, if enabled. The
+// event is already applied, so its org is looked up even if the request
+// that carried it has since ended.
+func (s *Server) publish(ev TelemetryEvent) {
+	if s.events == nil {
+		return
+	}
+	org, _ := s.store.DeviceOrg(context.Background(), ev.DeviceID)
+	s.events.send(newPublishedEvent(ev, org, time.Now().UTC()))
+}
+
+// PublisherResponse reports event publishing metrics.
+type PublisherResponse struct {
+	Enabled   bool  `json:"enabled"`
+	Buffered  int   `json:"buffered"`
+	Capacity  int   `json:"capacity"`
+	Published int64 `json:"published"`
+	Dropped   int64 `json:"dropped"`
+	Failed    int64 `json:"failed"`
+}
+
+// HandlePublisher processes GET /api/v1/admin/publisher
+func (s *Server) HandlePublisher(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/publisher")
+
+	if s.events == nil {
+		writeJSON(w, http.StatusOK, PublisherResponse{})
+		return
+	}
+	writeJSON(w, http.StatusOK, PublisherResponse{
+		Enabled:   true,
+		Buffered:  len(s.events.events),
+		Capacity:  cap(s.events.events),
+		Published: s.events.published.Load(),
+		Dropped:   s.events.dropped.Load(),
+		Failed:    s.events.failed.Load(),
+	})
+}
+
+// NewEventPublisher creates a publisher for a broker URL:
+// nats://host:4222 publishes to a NATS subject, and kafka+http://host:8082
+// (or kafka+https) produces to a Kafka topic through a Confluent-compatible
+// REST proxy.
+func NewEventPublisher(rawURL, topic string) (EventPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid publish URL: %w", err)
+	}
+	switch u.Scheme {
+	case "nats":
+		return &NATSPublisher{Addr: u.Host, Subject: topic}, nil
+	case "kafka+http", "kafka+https":
+		u.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+		return &KafkaRESTPublisher{
+			BaseURL: strings.TrimSuffix(u.String(), "/"),
+			Topic:   topic,
+			Client:  &http.Client{Timeout: publishTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported publish URL scheme %q (use nats, kafka+http or kafka+https)", u.Scheme)
+	}
+}
+
+// NATSPublisher publishes to a NATS subject over the NATS text protocol.
+// It connects lazily and reconnects on the next batch after an error.
+type NATSPublisher struct {
+	Addr    string // host:port
+	Subject string
+
+	mu   sync.Mutex
+	conn net.Conn      // protected by mu; nil until connected
+	rd   *bufio.Reader // protected by mu
+}
+
+// Publish sends each event as a PUB, then a PING, and waits for the PONG so
+// the batch is known to have reached the server.
+func (p *NATSPublisher) Publish(ctx context.Context, events []PublishedEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = p.conn.SetDeadline(deadline)
+	}
+
+	var buf bytes.Buffer
+	for _, ev := range events {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", p.Subject, len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+
+	err := func() error {
+		if _, err := p.conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		return p.awaitPong()
+	}()
+	if err != nil {
+		p.disconnect()
+	}
+	return err
+}
+
+// connect dials the server and completes the handshake. Callers must hold p.mu.
+func (p *NATSPublisher) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	p.conn, p.rd = conn, bufio.NewReader(conn)
+
+	// The server greets with INFO; verbose off means no +OK per PUB
+	line, err := p.rd.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO") {
+		err = fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, `CONNECT {"verbose":false,"pedantic":false,"name":"safelyyou"}`+"\r\n")
+	}
+	if err != nil {
+		p.disconnect()
+	}
+	return err
+}
+
+// awaitPong reads until the PONG for our PING, answering the server's own
+// PINGs and failing on -ERR. Callers must hold p.mu.
+func (p *NATSPublisher) awaitPong() error {
+	for {
+		line, err := p.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := io.WriteString(p.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// disconnect drops the connection. Callers must hold p.mu.
+func (p *NATSPublisher) disconnect() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn, p.rd = nil, nil
+}
+
+// Close closes the connection, if any.
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnect()
+	return nil
+}
+
+// KafkaRESTPublisher produces to a Kafka topic through a REST proxy, keyed by
+// device ID so each device's events stay ordered within a partition.
+type KafkaRESTPublisher struct {
+	BaseURL string // e.g. http://kafka-rest:8082
+	Topic   string
+	Client  *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string         `json:"key"`
+	Value PublishedEvent `json:"value"`
+}
+
+// Publish posts the batch as one produce request.
+func (p *KafkaRESTPublisher) Publish(ctx context.Context, events []PublishedEvent) error {
+	records := make([]kafkaRecord, len(events))
+	for i, ev := range events {
+		records[i] = kafkaRecord{Key: ev.DeviceID, Value: ev}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/topics/"+url.PathEscape(p.Topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka REST proxy returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	// A 200 can still carry per-record failures
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode kafka REST proxy response: %w", err)
+	}
+	failed := 0
+	var last string
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			failed++
+			last = offset.Error
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("kafka rejected %d of %d records: %s", failed, len(events), last)
+	}
+	return nil
+}
+
+// Close is a no-op; the HTTP client needs no teardown.
+func (p *KafkaRESTPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher keeps published events in memory.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []PublishedEvent
+	block  chan struct{} // if set, Publish waits on it
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, events []PublishedEvent) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, events...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+// TestPublishing_AcceptedOnly tests that accepted telemetry is published and rejected telemetry isn't
+func TestPublishing_AcceptedOnly(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-1"].Org = "acme"
+	publisher := &recordingPublisher{}
+	server.EnablePublishing(publisher, 10)
+	router := server.Router()
+
+	post := func(path, body string) {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post("/api/v1/devices/device-1/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z", "firmware_version": "2.1.0", "battery_pct": 80}`)
+	post("/api/v1/devices/device-1/stats", `{"sent_at": "2024-01-15T10:00:00Z", "upload_time": 5000000000}`)
+	post("/api/v1/devices/device-1/heartbeat", `{}`)                                 // fails validation
+	post("/api/v1/devices/unknown/heartbeat", `{"sent_at": "2024-01-15T10:00:00Z"}`) // unknown device
+	server.StopPublishing()
+
+	if len(publisher.events) != 2 {
+		t.Fatalf("expected 2 published events, got %+v", publisher.events)
+	}
+	hb, upload := publisher.events[0], publisher.events[1]
+	if hb.Type != ingestTypeHeartbeat || hb.DeviceID != "device-1" || hb.Org != "acme" ||
+		hb.FirmwareVersion != "2.1.0" || hb.BatteryPct == nil || *hb.BatteryPct != 80 {
+		t.Errorf("unexpected heartbeat event: %+v", hb)
+	}
+	if upload.Type != ingestTypeUpload || upload.UploadTime != int64(5*time.Second) || upload.ReceivedAt.IsZero() {
+		t.Errorf("unexpected upload event: %+v", upload)
+	}
+}
+
+// TestEventStream_DropsWhenFull tests that a slow broker never blocks ingestion
+func TestEventStream_DropsWhenFull(t *testing.T) {
+	publisher := &recordingPublisher{block: make(chan struct{})}
+	st := newEventStream(publisher, 1)
+
+	// The first event is taken by the publisher, the second fills the buffer
+	st.send(PublishedEvent{DeviceID: "a"})
+	time.Sleep(20 * time.Millisecond)
+	st.send(PublishedEvent{DeviceID: "b"})
+	st.send(PublishedEvent{DeviceID: "c"})
+
+	close(publisher.block)
+	st.close()
+	st.send(PublishedEvent{DeviceID: "d"}) // after close
+
+	if st.dropped.Load() != 2 || st.published.Load() != 2 {
+		t.Errorf("expected 2 published and 2 dropped, got %d and %d", st.published.Load(), st.dropped.Load())
+	}
+}
+
+// TestNATSPublisher tests the NATS handshake and PUB framing against a fake server
+func TestNATSPublisher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	type pub struct{ subject, payload string }
+	received := make(chan pub, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		rd := bufio.NewReader(conn)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 3 && fields[0] == "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(rd, payload); err != nil {
+					return
+				}
+				received <- pub{fields[1], string(payload[:n])}
+			case len(fields) == 1 && fields[0] == "PING":
+				_, _ = io.WriteString(conn, "PONG\r\n")
+			}
+		}
+	}()
+
+	publisher, err := NewEventPublisher("nats://"+ln.Addr().String(), "telemetry.test")
+	if err != nil {
+		t.Fatalf("NewEventPublisher failed: %v", err)
+	}
+	defer func() { _ = publisher.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := publisher.Publish(ctx, []PublishedEvent{{Type: ingestTypeHeartbeat, DeviceID: "device-1"}}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	got := <-received
+	var ev PublishedEvent
+	if err := json.Unmarshal([]byte(got.payload), &ev); err != nil {
+		t.Fatalf("invalid payload %q: %v", got.payload, err)
+	}
+	if got.subject != "telemetry.test" || ev.DeviceID != "device-1" {
+		t.Errorf("unexpected publish %s %+v", got.subject, ev)
+	}
+}
+
+// TestKafkaRESTPublisher tests produce requests and per-record errors
+func TestKafkaRESTPublisher(t *testing.T) {
+	var failRecords bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/telemetry" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Records []kafkaRecord `json:"records"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Records) != 1 || body.Records[0].Key != "device-1" {
+			http.Error(w, "unexpected records", http.StatusBadRequest)
+			return
+		}
+		if failRecords {
+			_, _ = io.WriteString(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"broker unavailable"}]}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"offsets":[{"partition":0,"offset":42,"error_code":null,"error":null}]}`)
+	}))
+	defer proxy.Close()
+
+	publisher, err := NewEventPublisher("kafka+"+proxy.URL, "telemetry")
+	if err != nil {
+		t.Fatalf("NewEventPublisher failed: %v", err)
+	}
+	events := []PublishedEvent{{Type: ingestTypeUpload, DeviceID: "device-1"}}
+
+	if err := publisher.Publish(context.Background(), events); err != nil {
+		t.Errorf("expected publish to succeed, got %v", err)
+	}
+	failRecords = true
+	if err := publisher.Publish(context.Background(), events); err == nil || !strings.Contains(err.Error(), "broker unavailable") {
+		t.Errorf("expected record error, got %v", err)
+	}
+}
+
+// TestNewEventPublisher_InvalidURL tests rejecting unknown schemes
+func TestNewEventPublisher_InvalidURL(t *testing.T) {
+	if _, err := NewEventPublisher("amqp://localhost", "t"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
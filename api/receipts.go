@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receipts decouple a device's request timeout from store latency. In
+// receipt mode, telemetry endpoints answer 202 with a receipt ID as soon as
+// the payload is validated and queued, and GET /api/v1/receipts/{id} later
+// confirms it was applied. A device that retries with the same
+// Idempotency-Key gets its original receipt back instead of recording the
+// telemetry twice.
+
+// DefaultReceiptCapacity is how many receipts are remembered when receipt
+// mode is enabled; the oldest are forgotten first.
+const DefaultReceiptCapacity = 100_000
+
+// receiptHeader carries the receipt ID on telemetry responses.
+const receiptHeader = "X-Receipt-ID"
+
+// Receipt states.
+const (
+	receiptPending   = "pending"
+	receiptProcessed = "processed"
+)
+
+// receipt tracks the events accepted by one request.
+type receipt struct {
+	id       string
+	org      string
+	deviceID string // empty for bulk ingest
+	key      idempotencyKey
+
+	mu          sync.Mutex
+	events      int  // protected by mu; events accepted so far
+	processed   int  // protected by mu; events applied to the store
+	sealed      bool // protected by mu; set once the request accepts no more events
+	acceptedAt  time.Time
+	processedAt time.Time // protected by mu; zero until every event is applied
+}
+
+// add counts an event queued under the receipt.
+func (rc *receipt) add() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.events++
+}
+
+// cancel uncounts an event that couldn't be queued.
+func (rc *receipt) cancel() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.events--
+	rc.completeLocked()
+}
+
+// done counts an event as applied.
+func (rc *receipt) done() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.processed++
+	rc.completeLocked()
+}
+
+// seal marks the request as finished accepting events.
+func (rc *receipt) seal() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.sealed = true
+	rc.completeLocked()
+}
+
+func (rc *receipt) completeLocked() {
+	if rc.sealed && rc.processed == rc.events && rc.processedAt.IsZero() {
+		rc.processedAt = time.Now()
+	}
+}
+
+// ReceiptResponse describes a receipt.
+type ReceiptResponse struct {
+	ReceiptID   string    `json:"receipt_id"`
+	DeviceID    string    `json:"device_id,omitempty"` // omitted for bulk ingest
+	Status      string    `json:"status"`              // "pending" or "processed"
+	Events      int       `json:"events"`
+	Processed   int       `json:"processed"`
+	AcceptedAt  time.Time `json:"accepted_at"`
+	ProcessedAt time.Time `json:"processed_at,omitzero"`
+}
+
+func (rc *receipt) response() ReceiptResponse {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	resp := ReceiptResponse{
+		ReceiptID:   rc.id,
+		DeviceID:    rc.deviceID,
+		Status:      receiptPending,
+		Events:      rc.events,
+		Processed:   rc.processed,
+		AcceptedAt:  rc.acceptedAt,
+		ProcessedAt: rc.processedAt,
+	}
+	if !rc.processedAt.IsZero() {
+		resp.Status = receiptProcessed
+	}
+	return resp
+}
+
+// idempotencyKey scopes a client's Idempotency-Key to its org and device.
+type idempotencyKey struct {
+	org, deviceID, key string
+}
+
+// receiptDevice identifies the device a receipt was issued to.
+type receiptDevice struct {
+	org, deviceID string
+}
+
+// receiptBook remembers the most recent receipts.
+type receiptBook struct {
+	mu        sync.Mutex
+	receipts  map[string]*receipt         // protected by mu
+	byKey     map[idempotencyKey]*receipt // protected by mu
+	order     []string                    // protected by mu; ring of receipt IDs, oldest at next once full
+	next      int                         // protected by mu
+	perDevice int                         // zero is unlimited
+	byDevice  map[receiptDevice][]string  // protected by mu; live receipt IDs, oldest first; kept while perDevice is set
+	evicted   int64                       // protected by mu; forgotten to stay within a capacity
+}
+
+func newReceiptBook(capacity, perDevice int) *receiptBook {
+	return &receiptBook{
+		receipts:  make(map[string]*receipt),
+		byKey:     make(map[idempotencyKey]*receipt),
+		order:     make([]string, 0, capacity),
+		perDevice: perDevice,
+		byDevice:  make(map[receiptDevice][]string),
+	}
+}
+
+// open starts a receipt for a request. If key is set and a receipt with the
+// same key exists, that receipt is returned with existing set, and the
+// request must not record anything.
+func (b *receiptBook) open(org, deviceID, key string) (rc *receipt, existing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := idempotencyKey{org, deviceID, key}
+	if key != "" {
+		if rc, exists := b.byKey[k]; exists {
+			return rc, true
+		}
+	}
+
+	rc = &receipt{id: randomHex(16), org: org, deviceID: deviceID, acceptedAt: time.Now().UTC()}
+	if key != "" {
+		rc.key = k
+		b.byKey[k] = rc
+	}
+	if len(b.order) < cap(b.order) {
+		b.order = append(b.order, rc.id)
+	} else {
+		if b.forgetLocked(b.order[b.next]) {
+			b.evicted++
+		}
+		b.order[b.next] = rc.id
+		b.next = (b.next + 1) % len(b.order)
+	}
+	b.receipts[rc.id] = rc
+
+	// One device retrying with fresh keys mustn't push out everyone else's;
+	// bulk ingest isn't tied to a device
+	if b.perDevice > 0 && deviceID != "" {
+		dev := receiptDevice{org, deviceID}
+		b.byDevice[dev] = append(b.byDevice[dev], rc.id)
+		if ids := b.byDevice[dev]; len(ids) > b.perDevice {
+			b.forgetLocked(ids[0])
+			b.evicted++
+		}
+	}
+	return rc, false
+}
+
+// discard forgets a receipt whose request recorded nothing, so a retry with
+// the same key is recorded.
+func (b *receiptBook) discard(rc *receipt) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forgetLocked(rc.id)
+}
+
+// forgetLocked forgets a receipt, reporting whether it was still remembered.
+func (b *receiptBook) forgetLocked(id string) bool {
+	rc, exists := b.receipts[id]
+	if !exists {
+		return false
+	}
+	delete(b.receipts, id)
+	if rc.key.key != "" && b.byKey[rc.key] == rc {
+		delete(b.byKey, rc.key)
+	}
+
+	dev := receiptDevice{rc.org, rc.deviceID}
+	if ids, exists := b.byDevice[dev]; exists {
+		if ids = slices.DeleteFunc(ids, func(other string) bool { return other == id }); len(ids) > 0 {
+			b.byDevice[dev] = ids
+		} else {
+			delete(b.byDevice, dev)
+		}
+	}
+	return true
+}
+
+// evictedCount returns how many receipts were forgotten to stay within a capacity.
+func (b *receiptBook) evictedCount() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.evicted
+}
+
+// get returns a receipt visible to org ("" sees every receipt).
+func (b *receiptBook) get(org, id string) (*receipt, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rc, exists := b.receipts[id]
+	if !exists || (org != "" && rc.org != org) {
+		return nil, false
+	}
+	return rc, true
+}
+
+type receiptKey struct{}
+
+// withReceipt returns a copy of ctx whose recorded telemetry is counted
+// under rc.
+func withReceipt(ctx context.Context, rc *receipt) context.Context {
+	return context.WithValue(ctx, receiptKey{}, rc)
+}
+
+// receiptFromContext returns the request's receipt, or nil.
+func receiptFromContext(ctx context.Context) *receipt {
+	rc, _ := ctx.Value(receiptKey{}).(*receipt)
+	return rc
+}
+
+// EnableReceipts answers telemetry with receipts, remembering up to
+// capacity of them and, unless perDevice is zero, up to perDevice for each
+// device. The oldest are forgotten first.
+func (s *Server) EnableReceipts(capacity, perDevice int) {
+	s.receipts = newReceiptBook(max(capacity, 1), max(perDevice, 0))
+}
+
+// acknowledge records one device's telemetry through record and writes the
+// success response: a receipt in receipt mode, otherwise an empty 202 or 204.
+// On error nothing is written.
+func (s *Server) acknowledge(w http.ResponseWriter, r *http.Request, deviceID string, record func(ctx context.Context) error) error {
+	if s.receipts == nil {
+		if err := record(r.Context()); err != nil {
+			return err
+		}
+		w.WriteHeader(s.acceptedStatus())
+		return nil
+	}
+
+	rc, existing := s.receipts.open(orgFromContext(r.Context()), deviceID, r.Header.Get("Idempotency-Key"))
+	if existing {
+		log.Printf("[INFO] Duplicate request for receipt %s, not recorded again", rc.id)
+	} else {
+		if err := record(withReceipt(r.Context(), rc)); err != nil {
+			s.receipts.discard(rc)
+			return err
+		}
+		rc.seal()
+	}
+	writeReceipt(w, rc)
+	return nil
+}
+
+// writeReceipt answers a telemetry request with its receipt.
+func writeReceipt(w http.ResponseWriter, rc *receipt) {
+	w.Header().Set(receiptHeader, rc.id)
+	w.Header().Set("Location", "/api/v1/receipts/"+rc.id)
+	writeJSON(w, http.StatusAccepted, rc.response())
+}
+
+// HandleReceipt processes GET /api/v1/receipts/{id}
+func (s *Server) HandleReceipt(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/receipts/")
+	log.Printf("[REQUEST] GET /api/v1/receipts/%s", id)
+
+	if s.receipts == nil {
+		writeError(w, http.StatusNotFound, "receipts are not enabled")
+		return
+	}
+	rc, exists := s.receipts.get(orgFromContext(r.Context()), id)
+	if !exists {
+		writeError(w, http.StatusNotFound, "receipt not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, rc.response())
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestReceipts_Heartbeat tests that a queued heartbeat's receipt turns processed once applied
+func TestReceipts_Heartbeat(t *testing.T) {
+	server := setupTestServer()
+	server.EnableAsyncWrites(10, 1)
+	server.EnableReceipts(10, 0)
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(`{"sent_at": "2024-01-15T10:00:00Z"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rr.Code)
+	}
+	var accepted ReceiptResponse
+	_ = json.NewDecoder(rr.Body).Decode(&accepted)
+	if accepted.ReceiptID == "" || rr.Header().Get(receiptHeader) != accepted.ReceiptID || accepted.Events != 1 {
+		t.Fatalf("unexpected receipt %+v", accepted)
+	}
+	location := rr.Header().Get("Location")
+
+	server.StopAsyncWrites()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, location, nil))
+	var got ReceiptResponse
+	_ = json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || got.Status != receiptProcessed || got.Processed != 1 || got.DeviceID != "device-1" || got.ProcessedAt.IsZero() {
+		t.Errorf("expected processed receipt, got %d %+v", rr.Code, got)
+	}
+}
+
+// TestReceipts_IdempotencyKey tests that a retried request isn't recorded twice
+func TestReceipts_IdempotencyKey(t *testing.T) {
+	server := setupTestServer()
+	server.EnableReceipts(10, 0)
+	router := server.Router()
+
+	post := func(key string) ReceiptResponse {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", bytes.NewBufferString(`{"upload_time": 5000000000}`))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp ReceiptResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}
+
+	first, retry, other := post("upload-1"), post("upload-1"), post("upload-2")
+	if first.ReceiptID != retry.ReceiptID || first.ReceiptID == other.ReceiptID {
+		t.Errorf("expected the retry to share the first receipt, got %s %s %s", first.ReceiptID, retry.ReceiptID, other.ReceiptID)
+	}
+	if first.Status != receiptProcessed {
+		t.Errorf("expected synchronous writes to be processed immediately, got %+v", first)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.UploadCount != 2 {
+		t.Errorf("expected 2 uploads recorded, got %d", device.UploadCount)
+	}
+}
+
+// TestReceipts_Ingest tests one receipt covering a bulk ingest request
+func TestReceipts_Ingest(t *testing.T) {
+	server := setupTestServer()
+	server.EnableReceipts(10, 0)
+	router := server.Router()
+
+	body := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}
+{"device_id": "unknown", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}
+{"device_id": "device-2", "type": "upload", "upload_time": 1000000000}
+`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	id := rr.Header().Get(receiptHeader)
+	if id == "" {
+		t.Fatal("expected a receipt header")
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/receipts/"+id, nil))
+	var got ReceiptResponse
+	_ = json.NewDecoder(rr.Body).Decode(&got)
+	if got.Status != receiptProcessed || got.Events != 2 || got.DeviceID != "" {
+		t.Errorf("expected 2 processed events, got %+v", got)
+	}
+}
+
+// TestReceiptBook_Eviction tests that the oldest receipts are forgotten at capacity
+func TestReceiptBook_Eviction(t *testing.T) {
+	b := newReceiptBook(2, 0)
+	first, _ := b.open("", "device-1", "key-1")
+	b.open("", "device-1", "")
+	b.open("", "device-1", "")
+
+	if _, exists := b.get("", first.id); exists {
+		t.Error("expected the oldest receipt forgotten")
+	}
+	if rc, existing := b.open("", "device-1", "key-1"); existing || rc == first {
+		t.Error("expected the forgotten receipt's key to be free again")
+	}
+}
+
+// TestReceiptBook_PerDevice tests that a device's oldest receipts are forgotten at its own capacity
+func TestReceiptBook_PerDevice(t *testing.T) {
+	b := newReceiptBook(10, 2)
+	other, _ := b.open("", "device-2", "key-a")
+	first, _ := b.open("", "device-1", "key-1")
+	b.open("", "device-1", "key-2")
+	b.open("", "device-1", "key-3")
+
+	if _, exists := b.get("", first.id); exists {
+		t.Error("expected the device's oldest receipt forgotten")
+	}
+	if _, exists := b.get("", other.id); !exists {
+		t.Error("expected other devices' receipts kept")
+	}
+	if b.evictedCount() != 1 || len(b.byDevice[receiptDevice{"", "device-1"}]) != 2 {
+		t.Errorf("expected 1 eviction and 2 receipts for device-1, got %d and %v", b.evictedCount(), b.byDevice)
+	}
+
+	// A discarded receipt no longer counts against the device
+	b.discard(other)
+	if _, exists := b.byDevice[receiptDevice{"", "device-2"}]; exists {
+		t.Error("expected the discarded receipt untracked")
+	}
+}
+
+// TestReceipts_OrgScoped tests that receipts are only visible to their org
+func TestReceipts_OrgScoped(t *testing.T) {
+	server := setupTestServer()
+	server.EnableReceipts(10, 0)
+	server.EnableAuth(APIKeys{"acme-key": "acme", "other-key": "other"})
+	server.backend().(*Store).devices["device-1"].Org = "acme"
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(`{"sent_at": "`+time.Now().UTC().Format(time.RFC3339)+`"}`))
+	req.Header.Set("X-API-Key", "acme-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	id := rr.Header().Get(receiptHeader)
+
+	for key, want := range map[string]int{"acme-key": http.StatusOK, "other-key": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/receipts/"+id, nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: expected status %d, got %d", key, want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Fleet teams keep their device lists in spreadsheets. GET
+// /api/v1/admin/devices/export writes the registry as CSV, and POST
+// /api/v1/admin/devices/import applies an edited copy back as a diff: each
+// row adds, updates, removes or decommissions one device. Imports are
+// written to the device CSVs, which stay the source of truth, and the
+// registry is then reloaded from them, so a later reload or restart sees the
+// same fleet.
+
+const contentTypeCSV = "text/csv"
+
+// Import actions; an empty action adds the device if it's new and updates it
+// otherwise.
+const (
+	importAdd          = "add"
+	importUpdate       = "update"
+	importRemove       = "remove"
+	importDecommission = "decommission"
+)
+
+// exportColumns are the export's columns. Signing secrets and tokens are
+// never exported.
+var exportColumns = []string{
+	"device_id", "org", "heartbeat_interval", "alert_after", "timezone", "activated_at",
+	"lifecycle", "decommissioned_at", "source", "upload_interval", "room", "facility",
+}
+
+// importColumns are the device CSV columns an import can set. Columns missing
+// from the import are left as they are, so secrets survive a round trip
+// through an export.
+var importColumns = []string{
+	"org", "heartbeat_interval", "alert_after", "upload_interval", "timezone", "activated_at", "signing_secret", "token", "room", "facility",
+}
+
+// HandleDeviceExport processes GET /api/v1/admin/devices/export
+func (s *Server) HandleDeviceExport(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/admin/devices/export")
+
+	devices, err := s.store.ListDevices(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	records := [][]string{exportColumns}
+	for _, device := range devices {
+		records = append(records, exportRecord(device, now))
+	}
+
+	w.Header().Set("Content-Type", contentTypeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="devices.csv"`)
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		log.Printf("[WARN] Failed to write device export: %v", err)
+	}
+}
+
+// exportRecord returns the device's row in exportColumns order.
+func exportRecord(device DeviceStats, now time.Time) []string {
+	record := []string{device.ID, device.Org, "", "", "", "", device.Lifecycle(now), "", "", "", device.Room, device.Facility}
+	if device.HeartbeatInterval > 0 {
+		record[2] = device.HeartbeatInterval.String()
+	}
+	if device.AlertAfter > 0 {
+		record[3] = device.AlertAfter.String()
+	}
+	if device.location != nil {
+		record[4] = device.location.String()
+	}
+	if !device.ActivatedAt.IsZero() {
+		record[5] = device.ActivatedAt.Format(time.RFC3339)
+	}
+	if !device.DecommissionedAt.IsZero() {
+		record[7] = device.DecommissionedAt.Format(time.RFC3339)
+	}
+	if device.source != "" {
+		record[8] = filepath.Base(device.source)
+	}
+	if device.UploadInterval > 0 {
+		record[9] = device.UploadInterval.String()
+	}
+	return record
+}
+
+// deviceImportRow is one row of an import.
+type deviceImportRow struct {
+	line   int
+	id     string
+	action string
+	source string            // device file to add the device to
+	values map[string]string // importColumns present in the import
+}
+
+// parseDeviceImport reads an import. Its values are validated by the device
+// CSV parser, so device_id must be the first column, as in a device CSV.
+// lifecycle and decommissioned_at are read-only and ignored, so an export
+// imports back unchanged.
+func parseDeviceImport(data []byte) ([]deviceImportRow, error) {
+	if _, err := parseDevicesCSV(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header = slices.Clone(header)
+	if len(header) == 0 || header[0] != "device_id" {
+		return nil, errors.New("device_id must be the first column")
+	}
+	for _, col := range header[1:] {
+		if col != "action" && !slices.Contains(importColumns, col) && !slices.Contains(exportColumns, col) {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+	}
+	actionCol := columnIndex(header, "action")
+	sourceCol := columnIndex(header, "source")
+
+	var rows []deviceImportRow
+	var errs []error
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := deviceImportRow{line: line, id: record[0], values: make(map[string]string)}
+		if actionCol >= 0 {
+			row.action = strings.ToLower(strings.TrimSpace(record[actionCol]))
+			if row.action != "" && !slices.Contains([]string{importAdd, importUpdate, importRemove, importDecommission}, row.action) {
+				errs = append(errs, fmt.Errorf("line %d: unknown action %q (must be add, update, remove or decommission)", line, record[actionCol]))
+				continue
+			}
+		}
+		if sourceCol >= 0 {
+			row.source = record[sourceCol]
+		}
+		for i, col := range header {
+			if slices.Contains(importColumns, col) {
+				row.values[col] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rows, nil
+}
+
+// deviceFile is a device CSV held in memory while an import edits it.
+type deviceFile struct {
+	path    string
+	records [][]string     // header row first; removed devices are nil
+	rows    map[string]int // device ID to index in records
+	changed bool
+}
+
+// readDeviceFile reads the device CSV at path for editing.
+func readDeviceFile(path string) (*deviceFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: missing header row", path)
+	}
+	file := &deviceFile{path: path, records: records, rows: make(map[string]int)}
+	for i, record := range records[1:] {
+		file.rows[record[0]] = i + 1
+	}
+	return file, nil
+}
+
+// add appends a row for the device with no column set.
+func (f *deviceFile) add(id string) {
+	record := make([]string, len(f.records[0]))
+	record[0] = id
+	f.records = append(f.records, record)
+	f.rows[id] = len(f.records) - 1
+	f.changed = true
+}
+
+// remove deletes the device's row.
+func (f *deviceFile) remove(id string) {
+	f.records[f.rows[id]] = nil
+	delete(f.rows, id)
+	f.changed = true
+}
+
+// set sets a column of the row at index, adding the column to the file if
+// it lacks it. It reports whether the value changed; values that parse the
+// same, such as 60s and 1m0s, are left as written.
+func (f *deviceFile) set(index int, column, value string) bool {
+	col := columnIndex(f.records[0], column)
+	if col < 0 {
+		if value == "" {
+			return false
+		}
+		for i := range f.records {
+			if f.records[i] != nil {
+				f.records[i] = append(f.records[i], "")
+			}
+		}
+		col = len(f.records[0]) - 1
+		f.records[0][col] = column
+	}
+	if sameDeviceValue(column, f.records[index][col], value) {
+		return false
+	}
+	f.records[index][col] = value
+	f.changed = true
+	return true
+}
+
+// sameDeviceValue reports whether two values of a device CSV column mean the
+// same thing.
+func sameDeviceValue(column, a, b string) bool {
+	if a == b {
+		return true
+	}
+	switch column {
+	case "heartbeat_interval", "alert_after", "upload_interval":
+		da, errA := time.ParseDuration(a)
+		db, errB := time.ParseDuration(b)
+		return errA == nil && errB == nil && da == db
+	case "activated_at":
+		ta, errA := time.Parse(time.RFC3339, a)
+		tb, errB := time.Parse(time.RFC3339, b)
+		return errA == nil && errB == nil && ta.Equal(tb)
+	}
+	return false
+}
+
+// encode returns the file's CSV, without removed devices.
+func (f *deviceFile) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	for _, record := range f.records {
+		if record != nil {
+			_ = cw.Write(record)
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// DeviceImportResponse is the body of a successful import.
+type DeviceImportResponse struct {
+	Added          int      `json:"added"`
+	Updated        int      `json:"updated"`
+	Unchanged      int      `json:"unchanged"`
+	Removed        int      `json:"removed"`
+	Decommissioned int      `json:"decommissioned"`
+	Files          []string `json:"files"` // device CSVs rewritten
+	Devices        int      `json:"devices"`
+}
+
+// applyDeviceImport applies rows to the device CSVs in paths, returning the
+// files it changed and the devices to decommission. Nothing is changed on
+// error.
+func applyDeviceImport(paths []string, rows []deviceImportRow) ([]*deviceFile, []string, DeviceImportResponse, error) {
+	var resp DeviceImportResponse
+	var files []*deviceFile
+	for _, path := range paths {
+		file, err := readDeviceFile(path)
+		if err != nil {
+			return nil, nil, resp, err
+		}
+		files = append(files, file)
+	}
+	fileOf := func(id string) *deviceFile {
+		for _, file := range files {
+			if _, exists := file.rows[id]; exists {
+				return file
+			}
+		}
+		return nil
+	}
+
+	var decommission []string
+	var errs []error
+	for _, row := range rows {
+		file := fileOf(row.id)
+		action := row.action
+		if action == "" {
+			action = importUpdate
+			if file == nil {
+				action = importAdd
+			}
+		}
+
+		switch {
+		case action == importAdd && file != nil:
+			errs = append(errs, fmt.Errorf("line %d: device %s already exists", row.line, row.id))
+			continue
+		case action != importAdd && file == nil:
+			errs = append(errs, fmt.Errorf("line %d: device %s not found", row.line, row.id))
+			continue
+		case action == importRemove:
+			file.remove(row.id)
+			resp.Removed++
+			continue
+		}
+
+		if action == importAdd {
+			// New devices go to the file named by source, or the only file
+			switch {
+			case row.source != "":
+				i := slices.IndexFunc(files, func(f *deviceFile) bool { return filepath.Base(f.path) == row.source })
+				if i < 0 {
+					errs = append(errs, fmt.Errorf("line %d: unknown source %q", row.line, row.source))
+					continue
+				}
+				file = files[i]
+			case len(files) == 1:
+				file = files[0]
+			default:
+				errs = append(errs, fmt.Errorf("line %d: source is required to add a device when devices come from several files", row.line))
+				continue
+			}
+			file.add(row.id)
+			resp.Added++
+		}
+
+		changed := false
+		for _, col := range importColumns {
+			if value, exists := row.values[col]; exists && file.set(file.rows[row.id], col, value) {
+				changed = true
+			}
+		}
+		switch {
+		case action == importDecommission:
+			decommission = append(decommission, row.id)
+		case action == importAdd:
+		case changed:
+			resp.Updated++
+		default:
+			resp.Unchanged++
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, resp, errors.Join(errs...)
+	}
+
+	var changed []*deviceFile
+	for _, file := range files {
+		if file.changed {
+			changed = append(changed, file)
+		}
+	}
+	return changed, decommission, resp, nil
+}
+
+// HandleDeviceImport processes POST /api/v1/admin/devices/import. The body
+// is a CSV in the device CSV format with an optional action column. Every
+// row is checked before anything is written, and a bad row fails the whole
+// import.
+func (s *Server) HandleDeviceImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] POST /api/v1/admin/devices/import")
+
+	s.configMu.RLock()
+	spec := s.devicesSpec
+	s.configMu.RUnlock()
+	if spec == "" {
+		writeError(w, http.StatusNotFound, "import is not enabled")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	rows, err := parseDeviceImport(body)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	resp, status, err := s.applyImport(r.Context(), spec, rows)
+	switch {
+	case err == nil:
+	case status == http.StatusServiceUnavailable:
+		writeStorageError(w, err)
+		return
+	case status == http.StatusTooManyRequests:
+		log.Printf("[ERROR] Refused to import devices: %v", err)
+		writeQuotaExceeded(w, err, time.Now())
+		return
+	default:
+		writeError(w, status, err.Error())
+		return
+	}
+	log.Printf("[CONFIG] Imported devices: %d added, %d updated, %d removed, %d decommissioned",
+		resp.Added, resp.Updated, resp.Removed, resp.Decommissioned)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// applyImport applies rows to the device CSVs named by spec and reloads
+// the registry from them. On error it returns the status to answer with,
+// and nothing is written unless the status is 500. An import that would put
+// an organization over its device quota is refused with 429.
+func (s *Server) applyImport(ctx context.Context, spec string, rows []deviceImportRow) (DeviceImportResponse, int, error) {
+	paths, err := ExpandDeviceSources(spec)
+	if err != nil {
+		log.Printf("[ERROR] Failed to import devices into %s: %v", spec, err)
+		return DeviceImportResponse{}, http.StatusUnprocessableEntity, err
+	}
+
+	s.importMu.Lock()
+	defer s.importMu.Unlock()
+
+	// Enrollment appends to the device CSV too
+	if s.enroller != nil {
+		s.enroller.mu.Lock()
+		defer s.enroller.mu.Unlock()
+	}
+
+	files, decommission, resp, err := applyDeviceImport(paths, rows)
+	if err != nil {
+		return resp, http.StatusUnprocessableEntity, err
+	}
+
+	// Edited files must still load before any is written
+	encoded := make([][]byte, len(files))
+	edited := make(map[string][]DeviceStats, len(files))
+	for i, file := range files {
+		if encoded[i], err = file.encode(); err == nil {
+			edited[file.path], err = parseDevicesCSV(bytes.NewReader(encoded[i]))
+		}
+		if err != nil {
+			return resp, http.StatusUnprocessableEntity, errors.New(filepath.Base(file.path) + ": " + err.Error())
+		}
+	}
+
+	// Nor may they put an organization over its device quota
+	var proposed []DeviceStats
+	for _, path := range paths {
+		devices, ok := edited[path]
+		if !ok {
+			if devices, err = readDevicesFile(path); err != nil {
+				return resp, http.StatusUnprocessableEntity, err
+			}
+		}
+		proposed = append(proposed, devices...)
+	}
+	if err := s.deviceQuotaError(ctx, proposed, decommission); err != nil {
+		if !refused(err) {
+			return resp, http.StatusServiceUnavailable, err
+		}
+		return resp, http.StatusTooManyRequests, err
+	}
+	resp.Files = []string{}
+	for i, file := range files {
+		err := writeFileAtomic(file.path, func(out io.Writer) error {
+			_, err := out.Write(encoded[i])
+			return err
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed to write %s: %v", file.path, err)
+			return resp, http.StatusInternalServerError, errors.New("failed to write device file")
+		}
+		resp.Files = append(resp.Files, filepath.Base(file.path))
+	}
+
+	devices, err := readDeviceSources(paths)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", spec, err)
+		return resp, http.StatusUnprocessableEntity, err
+	}
+	// The files are written, so a store failure from here on is a 500
+	added, err := s.unregisteredDevices(ctx, devices)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", spec, err)
+		return resp, http.StatusInternalServerError, errors.New("failed to update the registry")
+	}
+	if _, err := s.store.ReplaceDevices(ctx, devices); err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", spec, err)
+		return resp, http.StatusInternalServerError, errors.New("failed to update the registry")
+	}
+	s.notifyRegistered(ctx, added)
+	now := time.Now()
+	for _, id := range decommission {
+		retired, err := s.store.IsDecommissioned(ctx, id)
+		if err == nil && !retired {
+			err = s.store.Decommission(ctx, id, now)
+			if err == nil {
+				resp.Decommissioned++
+			}
+		}
+		if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+			log.Printf("[ERROR] Failed to decommission %s: %v", id, err)
+			return resp, http.StatusInternalServerError, errors.New("failed to update the registry")
+		}
+	}
+
+	s.configMu.Lock()
+	if s.devicesErr != nil {
+		log.Printf("[CONFIG] Device configuration error cleared by import")
+	}
+	s.devicesErr = nil
+	s.configMu.Unlock()
+
+	if resp.Devices, err = s.store.DeviceCount(ctx); err != nil {
+		log.Printf("[ERROR] Failed to count devices: %v", err)
+		return resp, http.StatusInternalServerError, errors.New("failed to update the registry")
+	}
+	return resp, http.StatusOK, nil
+}
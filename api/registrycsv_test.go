@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupRegistryTestServer returns a server whose devices come from the CSVs
+// written into dir, one per name and content pair.
+func setupRegistryTestServer(t *testing.T, dir string, files ...string) *Server {
+	t.Helper()
+	var paths []string
+	for i := 0; i < len(files); i += 2 {
+		paths = append(paths, writeDevicesFile(t, dir, files[i], files[i+1]))
+	}
+	store := NewStore()
+	if err := store.LoadDevicesFromCSV(t.Context(), paths...); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(store, nil)
+	server.SetDeviceSources(strings.Join(paths, ","), nil)
+	return server
+}
+
+// importDevices posts body to the import endpoint.
+func importDevices(router http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// exportDevices returns the export's records.
+func exportDevices(t *testing.T, router http.Handler, apiKey string) [][]string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/devices/export", nil)
+	if apiKey != "" {
+		req.Header.Set(apiKeyHeader, apiKey)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a CSV export, got %d %q: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// TestDeviceExport tests exporting metadata and lifecycle state without secrets
+func TestDeviceExport(t *testing.T) {
+	server := setupRegistryTestServer(t, t.TempDir(), "devices.csv",
+		"device_id,org,heartbeat_interval,timezone,signing_secret,activated_at,upload_interval\n"+
+			"device-1,acme,30s,Europe/Paris,s3cret,2024-01-15T10:00:00Z,1h\n"+
+			"device-2,acme,,,,,\n"+
+			"device-3,globex,,,,,\n")
+	server.store.Decommission(t.Context(), "device-2", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	router := server.Router()
+
+	records := exportDevices(t, router, "")
+	want := [][]string{
+		exportColumns,
+		{"device-1", "acme", "30s", "", "Europe/Paris", "2024-01-15T10:00:00Z", lifecycleActive, "", "devices.csv", "1h0m0s", "", ""},
+		{"device-2", "acme", "", "", "", "", lifecycleRetired, "2024-02-01T00:00:00Z", "devices.csv", "", "", ""},
+		{"device-3", "globex", "", "", "", "", lifecycleProvisioned, "", "devices.csv", "", "", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got %v", len(want), records)
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("record %d: expected %v, got %v", i, want[i], records[i])
+		}
+	}
+
+}
+
+// TestDeviceImport tests applying a diff to the device CSV and the registry
+func TestDeviceImport(t *testing.T) {
+	dir := t.TempDir()
+	server := setupRegistryTestServer(t, dir, "devices.csv",
+		"device_id,org,signing_secret,notes\n"+
+			"device-1,acme,s3cret,lobby\n"+
+			"device-2,acme,,hallway\n"+
+			"device-3,acme,,kitchen\n"+
+			"device-4,acme,,garage\n")
+	server.store.RecordHeartbeat(t.Context(), "device-1", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	router := server.Router()
+
+	rr := importDevices(router, "device_id,action,org,alert_after\n"+
+		"device-1,,globex,10m\n"+
+		"device-2,remove,,\n"+
+		"device-3,decommission,acme,\n"+
+		"device-4,update,acme,\n"+
+		"device-5,,acme,5m\n")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp DeviceImportResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	want := DeviceImportResponse{Added: 1, Updated: 1, Unchanged: 1, Removed: 1, Decommissioned: 1, Devices: 4}
+	if resp.Added != want.Added || resp.Updated != want.Updated || resp.Unchanged != want.Unchanged ||
+		resp.Removed != want.Removed || resp.Decommissioned != want.Decommissioned || resp.Devices != want.Devices ||
+		len(resp.Files) != 1 || resp.Files[0] != "devices.csv" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "devices.csv"))
+	wantFile := "device_id,org,signing_secret,notes,alert_after\n" +
+		"device-1,globex,s3cret,lobby,10m\n" +
+		"device-3,acme,,kitchen,\n" +
+		"device-4,acme,,garage,\n" +
+		"device-5,acme,,,5m\n"
+	if string(data) != wantFile {
+		t.Errorf("expected file:\n%s\ngot:\n%s", wantFile, data)
+	}
+
+	device, _ := server.store.Device(t.Context(), "device-1")
+	if device.Org != "globex" || device.AlertAfter != 10*time.Minute || string(device.signingKey) != "s3cret" || device.HeartbeatCount != 1 {
+		t.Errorf("expected device-1 updated with telemetry and secret kept, got %+v", device)
+	}
+	if deviceExists(t, server.store, "device-2") || !isDecommissioned(t, server.store, "device-3") || !deviceExists(t, server.store, "device-5") {
+		t.Error("expected device-2 removed, device-3 retired and device-5 added")
+	}
+}
+
+// TestDeviceImport_RoundTrip tests that importing an export changes nothing
+func TestDeviceImport_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	content := "device_id,org,heartbeat_interval,timezone,token\n" +
+		"device-1,acme,60s,Europe/Paris,tok\n" +
+		"device-2,acme,,,\n"
+	server := setupRegistryTestServer(t, dir, "devices.csv", content)
+	server.store.Decommission(t.Context(), "device-2", time.Now())
+	router := server.Router()
+
+	var export strings.Builder
+	cw := csv.NewWriter(&export)
+	_ = cw.WriteAll(exportDevices(t, router, ""))
+
+	rr := importDevices(router, export.String())
+	var resp DeviceImportResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Unchanged != 2 || len(resp.Files) != 0 {
+		t.Errorf("expected 2 unchanged devices and no files written, got %d %+v", rr.Code, resp)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "devices.csv")); string(data) != content {
+		t.Errorf("expected device file untouched, got:\n%s", data)
+	}
+}
+
+// TestDeviceImport_Invalid tests that a bad import changes nothing
+func TestDeviceImport_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	content := "device_id,org\ndevice-1,acme\n"
+	server := setupRegistryTestServer(t, dir, "devices.csv", content)
+	router := server.Router()
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", "missing header row"},
+		{"device_id not first", "org,device_id\nacme,device-2\n", "device_id must be the first column"},
+		{"unknown column", "device_id,colour\ndevice-2,red\n", `unknown column "colour"`},
+		{"unknown action", "device_id,action\ndevice-2,archive\n", `line 2: unknown action "archive"`},
+		{"invalid value", "device_id,timezone\ndevice-1,Mars/Olympus\ndevice-2,UTC\n", `line 2: invalid timezone "Mars/Olympus"`},
+		{"duplicate", "device_id\ndevice-2\ndevice-2\n", "line 3: duplicate device_id"},
+		{"add existing", "device_id,action\ndevice-2,add\ndevice-1,add\n", "line 3: device device-1 already exists"},
+		{"update missing", "device_id,action,org\ndevice-9,update,acme\n", "line 2: device device-9 not found"},
+		{"unknown source", "device_id,source\ndevice-2,other.csv\n", `line 2: unknown source "other.csv"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := importDevices(router, tt.body)
+			var resp ProblemDetails
+			_ = json.NewDecoder(rr.Body).Decode(&resp)
+			if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(resp.Detail, tt.want) {
+				t.Errorf("expected status 422 with %q, got %d: %s", tt.want, rr.Code, resp.Detail)
+			}
+		})
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "devices.csv")); string(data) != content || deviceCount(t, server.store) != 1 {
+		t.Errorf("expected registry and file untouched, got:\n%s", data)
+	}
+}
+
+// TestDeviceImport_Sources tests that new devices go to the file named by source
+func TestDeviceImport_Sources(t *testing.T) {
+	dir := t.TempDir()
+	server := setupRegistryTestServer(t, dir,
+		"north.csv", "device_id\ndevice-1\n",
+		"south.csv", "device_id\ndevice-2\n")
+	router := server.Router()
+
+	if rr := importDevices(router, "device_id\ndevice-3\n"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 without a source, got %d", rr.Code)
+	}
+	rr := importDevices(router, "device_id,source\ndevice-3,south.csv\ndevice-1,south.csv\n")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "south.csv")); string(data) != "device_id\ndevice-2\ndevice-3\n" {
+		t.Errorf("expected device-3 added to south.csv, got:\n%s", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "north.csv")); string(data) != "device_id\ndevice-1\n" {
+		t.Errorf("expected north.csv untouched, got:\n%s", data)
+	}
+}
+
+// TestDeviceImport_Restricted tests that import needs an unscoped key, device sources and a CSV body
+func TestDeviceImport_Restricted(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	if rr := importDevices(router, "device_id\n"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without device sources, got %d", rr.Code)
+	}
+
+	server.SetDeviceSources(writeDevicesFile(t, t.TempDir(), "devices.csv", "device_id\n"), nil)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/import", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415 for a JSON body, got %d", rr.Code)
+	}
+
+	server.EnableAuth(APIKeys{"tenant-key": "acme"})
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/import", strings.NewReader("device_id\n"))
+	req.Header.Set(apiKeyHeader, "tenant-key")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || !deviceExists(t, server.store, "device-1") {
+		t.Errorf("expected status 403 and registry untouched, got %d", rr.Code)
+	}
+}
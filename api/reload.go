@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reloading the device registry without a restart, so devices can be added
+// or retired, or a corrected CSV swapped in, without losing telemetry.
+
+// configError returns why the server can't serve requests, or nil.
+func (s *Server) configError() error {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return errors.Join(s.devicesErr, s.configErr)
+}
+
+// SetDeviceSources enables POST /api/v1/admin/reload for the device CSVs
+// named by spec, a comma-separated list of files and globs (see
+// ExpandDeviceSources). loadErr is why they failed to load at startup, if
+// they did; it fails requests like the error passed to NewServer until a
+// reload succeeds.
+func (s *Server) SetDeviceSources(spec string, loadErr error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.devicesSpec = spec
+	s.devicesErr = loadErr
+}
+
+// unregisteredDevices returns the IDs of the devices that aren't registered
+// yet, to announce once a reload or import has added them.
+func (s *Server) unregisteredDevices(ctx context.Context, devices []DeviceStats) ([]string, error) {
+	var ids []string
+	for _, device := range devices {
+		exists, err := s.store.DeviceExists(ctx, device.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			ids = append(ids, device.ID)
+		}
+	}
+	return ids, nil
+}
+
+// notifyRegistered sends a registration event for each device the request
+// ctx belongs to added to the registry.
+func (s *Server) notifyRegistered(ctx context.Context, deviceIDs []string) {
+	now := time.Now()
+	for _, id := range deviceIDs {
+		s.notifyRequestWebhooks(ctx, WebhookRegistration, id, now)
+	}
+}
+
+// ReloadResponse is the body of a successful reload.
+type ReloadResponse struct {
+	File  string   `json:"file,omitempty"` // set when a single file was read
+	Files []string `json:"files"`
+	ReloadResult
+	Devices int `json:"devices"`
+}
+
+// HandleReload processes POST /api/v1/admin/reload. Globs in the device
+// sources are expanded again, so a new facility's CSV is picked up. When the
+// devices come from a single file, the optional file parameter names another
+// CSV in its directory to swap in; paths outside that directory are refused.
+func (s *Server) HandleReload(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] POST /api/v1/admin/reload")
+
+	s.configMu.RLock()
+	spec := s.devicesSpec
+	s.configMu.RUnlock()
+	if spec == "" {
+		writeError(w, http.StatusNotFound, "reload is not enabled")
+		return
+	}
+
+	paths, err := ExpandDeviceSources(spec)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", spec, err)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	var devices []DeviceStats
+	var files []string
+	if name := r.URL.Query().Get("file"); name != "" {
+		if len(paths) != 1 || isGlob(spec) {
+			writeError(w, http.StatusBadRequest, "file can only be set when devices come from a single file")
+			return
+		}
+		devices, err = readSwapFile(w, paths[0], name)
+		if err != nil {
+			return
+		}
+		files = []string{name}
+	} else {
+		// A bad file leaves the current registry and any startup error in place
+		devices, err = readDeviceSources(paths)
+		if err != nil {
+			log.Printf("[ERROR] Failed to reload devices from %s: %v", spec, err)
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		for _, path := range paths {
+			files = append(files, filepath.Base(path))
+		}
+	}
+
+	// A reload mustn't put an organization over its device quota
+	if err := s.deviceQuotaError(r.Context(), devices, nil); err != nil {
+		if !refused(err) {
+			writeStorageError(w, err)
+			return
+		}
+		log.Printf("[ERROR] Refused to reload devices from %s: %v", spec, err)
+		writeQuotaExceeded(w, err, time.Now())
+		return
+	}
+
+	added, err := s.unregisteredDevices(r.Context(), devices)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	result, err := s.store.ReplaceDevices(r.Context(), devices)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.notifyRegistered(r.Context(), added)
+
+	s.configMu.Lock()
+	if s.devicesErr != nil {
+		log.Printf("[CONFIG] Device configuration error cleared by reload")
+	}
+	s.devicesErr = nil
+	s.configMu.Unlock()
+
+	log.Printf("[CONFIG] Reloaded devices from %s: %d added, %d removed, %d unchanged",
+		strings.Join(files, ", "), result.Added, result.Removed, result.Unchanged)
+	count, err := s.store.DeviceCount(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	resp := ReloadResponse{
+		Files:        files,
+		ReloadResult: result,
+		Devices:      count,
+	}
+	if len(files) == 1 {
+		resp.File = files[0]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// readSwapFile parses name, a CSV in devicesPath's directory, writing the
+// error response if it can't be read.
+func readSwapFile(w http.ResponseWriter, devicesPath, name string) ([]DeviceStats, error) {
+	file, err := os.OpenInRoot(filepath.Dir(devicesPath), name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, http.StatusNotFound, "file not found: "+name)
+		return nil, err
+	case err != nil:
+		log.Printf("[WARN] Rejected reload of %s: %v", name, err)
+		writeError(w, http.StatusBadRequest, "file must be in the device file's directory")
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", name, err)
+		}
+	}()
+
+	// A bad file leaves the current registry and any startup error in place
+	devices, err := parseDevicesCSV(file)
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", name, err)
+		writeError(w, http.StatusUnprocessableEntity, name+": "+err.Error())
+		return nil, err
+	}
+	for i := range devices {
+		devices[i].source = filepath.Join(filepath.Dir(devicesPath), name)
+	}
+	return devices, nil
+}
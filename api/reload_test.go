@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeDevicesFile writes a device CSV into dir and returns its path.
+func writeDevicesFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// TestStore_ReplaceDevices tests that devices kept across a swap keep their telemetry
+func TestStore_ReplaceDevices(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	s.devices["device-2"] = &DeviceStats{ID: "device-2"}
+	s.RecordHeartbeat(t.Context(), "device-1", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	s.RecordHeartbeat(t.Context(), "device-2", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+
+	result, err := s.ReplaceDevices(t.Context(), []DeviceStats{
+		{ID: "device-1", Org: "acme", AlertAfter: time.Minute},
+		{ID: "device-3"},
+		{ID: "device-3"},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceDevices failed: %v", err)
+	}
+	if result != (ReloadResult{Added: 1, Removed: 1, Unchanged: 1}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	device, _ := s.Device(t.Context(), "device-1")
+	if device.HeartbeatCount != 1 || device.Org != "acme" || device.AlertAfter != time.Minute {
+		t.Errorf("expected telemetry kept and settings updated, got %+v", device)
+	}
+	if deviceExists(t, s, "device-2") || s.history["device-2"] != nil {
+		t.Error("expected device-2 and its history removed")
+	}
+}
+
+// TestReload tests swapping the registry and clearing a startup load error
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDevicesFile(t, dir, "devices.csv", "device_id\ndevice-1\ndevice-2\n")
+	writeDevicesFile(t, dir, "next.csv", "device_id\ndevice-2\ndevice-3\n")
+	writeDevicesFile(t, dir, "broken.csv", "device_id,heartbeat_interval\ndevice-9,soon\n")
+
+	server := setupTestServer()
+	server.SetDeviceSources(path, errors.New("line 2: bad row"))
+	server.store.RecordHeartbeat(t.Context(), "device-2", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	router := server.Router()
+
+	reload := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload"+query, nil))
+		return rr
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"missing file", "?file=nope.csv", http.StatusNotFound},
+		{"outside directory", "?file=../devices.csv", http.StatusBadRequest},
+		{"invalid csv", "?file=broken.csv", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := reload(tt.query); rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if server.configError() == nil || deviceExists(t, server.store, "device-9") {
+		t.Fatal("expected failed reloads to leave the registry and error in place")
+	}
+
+	rr := reload("?file=next.csv")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp ReloadResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.File != "next.csv" || resp.Added != 1 || resp.Removed != 1 || resp.Unchanged != 1 || resp.Devices != 2 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if server.configError() != nil {
+		t.Errorf("expected configuration error cleared, got %v", server.configError())
+	}
+	if device, _ := server.store.Device(t.Context(), "device-2"); device.HeartbeatCount != 1 {
+		t.Errorf("expected device-2 telemetry kept, got %+v", device)
+	}
+
+	// Without a file parameter the configured file is read again
+	rr = reload("")
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.File != "devices.csv" || !deviceExists(t, server.store, "device-1") {
+		t.Errorf("expected devices.csv reloaded, got %d %+v", rr.Code, resp)
+	}
+}
+
+// TestReload_Restricted tests that reload needs an unscoped key and a configured file
+func TestReload_Restricted(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without a device file, got %d", rr.Code)
+	}
+
+	server.SetDeviceSources(writeDevicesFile(t, t.TempDir(), "devices.csv", "device_id\n"), nil)
+	server.EnableAuth(APIKeys{"tenant-key": "acme"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload", nil)
+	req.Header.Set("X-API-Key", "tenant-key")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || !deviceExists(t, server.store, "device-1") {
+		t.Errorf("expected status 403 and registry untouched, got %d", rr.Code)
+	}
+}
+
+// TestReload_Registration tests that devices added by a reload or an import
+// are announced to registration webhooks, and devices already registered
+// aren't
+func TestReload_Registration(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDevicesFile(t, dir, "devices.csv", "device_id\ndevice-1\ndevice-2\ndevice-3\n")
+
+	server := setupTestServer()
+	server.SetDeviceSources(path, nil)
+	router := server.Router()
+	hook := createWebhook(t, router, `{"url": "https://example.com/hook", "events": ["registration"], "secret": "s"}`)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/devices/import", strings.NewReader("device_id\ndevice-4\n")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var announced []string
+	for _, d := range deliveries(t, router, hook.ID) {
+		if d.Event.Type != WebhookRegistration {
+			t.Errorf("unexpected event %+v", d.Event)
+		}
+		announced = append(announced, d.Event.DeviceID)
+	}
+	slices.Sort(announced)
+	if want := []string{"device-3", "device-4"}; !slices.Equal(announced, want) {
+		t.Errorf("expected %v announced, got %v", want, announced)
+	}
+}
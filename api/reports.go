@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report defaults: devices silent for an hour count as offline, and the
+// worst/slowest lists show the bottom five.
+const (
+	defaultReportOfflineAfter = time.Hour
+	defaultReportTopN         = 5
+)
+
+// FleetReport is a point-in-time summary of fleet health.
+type FleetReport struct {
+	GeneratedAt    time.Time
+	TotalDevices   int
+	Offline        []DeviceStats // Silent longer than the offline threshold, longest first
+	WorstUptime    []DeviceStats // Lowest uptime first
+	SlowestUploads []DeviceStats // Highest average upload time first
+	OfflineAfter   time.Duration
+}
+
+// BuildFleetReport summarizes active devices. Devices that never sent a
+// heartbeat count as offline.
+func BuildFleetReport(devices []DeviceStats, now time.Time, offlineAfter time.Duration, topN int) FleetReport {
+	report := FleetReport{GeneratedAt: now, OfflineAfter: offlineAfter}
+
+	// Stats are computed once per device rather than in every comparison
+	type rankedDevice struct {
+		device DeviceStats
+		stats  StatsResult
+	}
+	stats := mapDevices(devices, (*DeviceStats).Stats)
+	var withHeartbeats, withUploads []rankedDevice
+	for i, device := range devices {
+		if !device.DecommissionedAt.IsZero() {
+			continue
+		}
+		report.TotalDevices++
+
+		if device.LastHeartbeat.IsZero() || now.Sub(device.LastHeartbeat) > offlineAfter {
+			report.Offline = append(report.Offline, device)
+		}
+		if device.HeartbeatCount > 0 {
+			withHeartbeats = append(withHeartbeats, rankedDevice{device, stats[i]})
+		}
+		if device.UploadCount > 0 {
+			withUploads = append(withUploads, rankedDevice{device, stats[i]})
+		}
+	}
+
+	// Zero LastHeartbeat sorts first, so never-seen devices lead the list
+	sort.SliceStable(report.Offline, func(i, j int) bool {
+		return report.Offline[i].LastHeartbeat.Before(report.Offline[j].LastHeartbeat)
+	})
+	sort.SliceStable(withHeartbeats, func(i, j int) bool {
+		return withHeartbeats[i].stats.Uptime < withHeartbeats[j].stats.Uptime
+	})
+	sort.SliceStable(withUploads, func(i, j int) bool {
+		return withUploads[i].stats.AvgUploadTime > withUploads[j].stats.AvgUploadTime
+	})
+
+	for _, ranked := range withHeartbeats[:min(topN, len(withHeartbeats))] {
+		report.WorstUptime = append(report.WorstUptime, ranked.device)
+	}
+	for _, ranked := range withUploads[:min(topN, len(withUploads))] {
+		report.SlowestUploads = append(report.SlowestUploads, ranked.device)
+	}
+	return report
+}
+
+// Subject returns a one-line summary suitable for an email subject.
+func (r FleetReport) Subject() string {
+	return fmt.Sprintf("Fleet summary %s: %d of %d devices offline",
+		r.GeneratedAt.Format("2006-01-02"), len(r.Offline), r.TotalDevices)
+}
+
+// Text renders the report as plain text.
+func (r FleetReport) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Fleet summary generated %s\n", r.GeneratedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Active devices: %d\n\n", r.TotalDevices)
+
+	fmt.Fprintf(&b, "Offline > %v (%d):\n", r.OfflineAfter, len(r.Offline))
+	for _, device := range r.Offline {
+		if device.LastHeartbeat.IsZero() {
+			fmt.Fprintf(&b, "  %s  never seen\n", device.ID)
+		} else {
+			fmt.Fprintf(&b, "  %s  last seen %s ago\n", device.ID, r.GeneratedAt.Sub(device.LastHeartbeat).Round(time.Minute))
+		}
+	}
+
+	fmt.Fprintf(&b, "\nWorst uptime:\n")
+	for _, device := range r.WorstUptime {
+		fmt.Fprintf(&b, "  %s  %.2f%%\n", device.ID, device.Stats().Uptime)
+	}
+
+	fmt.Fprintf(&b, "\nSlowest uploads:\n")
+	for _, device := range r.SlowestUploads {
+		fmt.Fprintf(&b, "  %s  avg %v\n", device.ID, device.Stats().AvgUploadTime)
+	}
+	return b.String()
+}
+
+// ReportSender delivers a rendered report.
+type ReportSender interface {
+	Send(ctx context.Context, subject, body string) error
+}
+
+// SlackSender posts reports to a Slack incoming webhook.
+type SlackSender struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// Send posts the report as a Slack message.
+func (s *SlackSender) Send(ctx context.Context, subject, body string) error {
+	payload, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n```" + body + "```"})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPSender emails reports through an SMTP relay.
+type SMTPSender struct {
+	Addr string    // host:port
+	Auth smtp.Auth // nil for unauthenticated relays
+	From string
+	To   []string
+}
+
+// Send emails the report as plain text.
+func (s *SMTPSender) Send(_ context.Context, subject, body string) error {
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + strings.Join(s.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(s.Addr, s.Auth, s.From, s.To, []byte(msg))
+}
+
+// ReportScheduler sends a fleet report once a day at a fixed local time.
+type ReportScheduler struct {
+	store        Storage
+	sender       ReportSender
+	hour, minute int
+	offlineAfter time.Duration
+	topN         int
+}
+
+// NewReportScheduler creates a scheduler for the given "HH:MM" local time.
+func NewReportScheduler(store Storage, sender ReportSender, at string) (*ReportScheduler, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report time %q: expected HH:MM", at)
+	}
+	return &ReportScheduler{
+		store:        store,
+		sender:       sender,
+		hour:         t.Hour(),
+		minute:       t.Minute(),
+		offlineAfter: defaultReportOfflineAfter,
+		topN:         defaultReportTopN,
+	}, nil
+}
+
+// nextReportTime returns the next occurrence of hour:minute strictly after now.
+func nextReportTime(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Run sends a report every day until ctx is cancelled.
+func (rs *ReportScheduler) Run(ctx context.Context) {
+	for {
+		next := nextReportTime(time.Now(), rs.hour, rs.minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := rs.SendNow(ctx); err != nil {
+			log.Printf("[ERROR] Failed to send fleet report: %v", err)
+		}
+	}
+}
+
+// SendNow builds and delivers a report immediately.
+func (rs *ReportScheduler) SendNow(ctx context.Context) error {
+	devices, err := rs.store.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	report := BuildFleetReport(devices, time.Now(), rs.offlineAfter, rs.topN)
+	if err := rs.sender.Send(ctx, report.Subject(), report.Text()); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Sent fleet report: %d devices, %d offline", report.TotalDevices, len(report.Offline))
+	return nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFleetReport(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	devices := []DeviceStats{
+		{ID: "online", HeartbeatCount: 60, CoveredMinutes: 60, FirstHeartbeat: now.Add(-59 * time.Minute), LastHeartbeat: now,
+			UploadCount: 1, UploadTimeSum: 10 * time.Second},
+		{ID: "flaky", HeartbeatCount: 30, CoveredMinutes: 30, FirstHeartbeat: now.Add(-59 * time.Minute), LastHeartbeat: now,
+			UploadCount: 1, UploadTimeSum: time.Minute},
+		{ID: "silent", HeartbeatCount: 10, CoveredMinutes: 10, FirstHeartbeat: now.Add(-3 * time.Hour), LastHeartbeat: now.Add(-2 * time.Hour)},
+		{ID: "never"},
+		{ID: "retired", DecommissionedAt: now.Add(-time.Hour)},
+	}
+
+	report := BuildFleetReport(devices, now, time.Hour, 2)
+
+	if report.TotalDevices != 4 {
+		t.Errorf("expected 4 active devices, got %d", report.TotalDevices)
+	}
+	if len(report.Offline) != 2 || report.Offline[0].ID != "never" || report.Offline[1].ID != "silent" {
+		t.Errorf("expected offline [never silent], got %v", ids(report.Offline))
+	}
+	if len(report.WorstUptime) != 2 || report.WorstUptime[0].ID != "silent" || report.WorstUptime[1].ID != "flaky" {
+		t.Errorf("expected worst uptime [silent flaky], got %v", ids(report.WorstUptime))
+	}
+	if len(report.SlowestUploads) != 2 || report.SlowestUploads[0].ID != "flaky" {
+		t.Errorf("expected slowest uploads led by flaky, got %v", ids(report.SlowestUploads))
+	}
+	if !strings.Contains(report.Text(), "never seen") {
+		t.Error("report text should mention never-seen devices")
+	}
+}
+
+func ids(devices []DeviceStats) []string {
+	result := make([]string, len(devices))
+	for i, device := range devices {
+		result[i] = device.ID
+	}
+	return result
+}
+
+func TestNextReportTime(t *testing.T) {
+	now := time.Date(2024, 1, 15, 7, 30, 0, 0, time.UTC)
+
+	if next := nextReportTime(now, 8, 0); !next.Equal(time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected later today, got %v", next)
+	}
+	if next := nextReportTime(now, 7, 30); !next.Equal(time.Date(2024, 1, 16, 7, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected tomorrow when the time is now, got %v", next)
+	}
+}
+
+func TestSlackSender(t *testing.T) {
+	var got map[string]string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer webhook.Close()
+
+	sender := &SlackSender{WebhookURL: webhook.URL, Client: webhook.Client()}
+	if err := sender.Send(context.Background(), "subject", "body"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(got["text"], "subject") || !strings.Contains(got["text"], "body") {
+		t.Errorf("unexpected Slack payload: %v", got)
+	}
+}
+
+func TestSlackSender_ErrorStatus(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer webhook.Close()
+
+	sender := &SlackSender{WebhookURL: webhook.URL, Client: webhook.Client()}
+	if err := sender.Send(context.Background(), "subject", "body"); err == nil {
+		t.Error("expected error for non-2xx webhook response")
+	}
+}
+
+func TestNewReportScheduler_InvalidTime(t *testing.T) {
+	if _, err := NewReportScheduler(NewStore(), &SlackSender{}, "8am"); err == nil {
+		t.Error("expected error for invalid report time")
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Device search for support agents, who usually have only part of a serial
+// number read off a device at the facility. IDs and queries are compared
+// without case or separators, so "a4:c1:38:0f" finds "A4C1380F22B7".
+
+// Result counts for search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Match kinds, best first; results are ranked in this order.
+const (
+	matchExact    = "exact"
+	matchPrefix   = "prefix"
+	matchPartial  = "partial"
+	matchTypo     = "typo" // one mistyped character
+	matchMetadata = "metadata"
+)
+
+var matchRank = map[string]int{matchExact: 0, matchPrefix: 1, matchPartial: 2, matchTypo: 3, matchMetadata: 4}
+
+// minTypoQuery is the shortest query allowed a mistyped character; shorter
+// ones would match most of the fleet.
+const minTypoQuery = 4
+
+// DeviceSearchResult is one matching device and how it matched.
+type DeviceSearchResult struct {
+	DeviceSummary
+	Match string `json:"match"`
+	Field string `json:"field"` // device_id, org, firmware_version or agent_version
+}
+
+// DeviceSearchResponse lists matches, best first.
+type DeviceSearchResponse struct {
+	Query   string               `json:"query"`
+	Results []DeviceSearchResult `json:"results"`
+}
+
+// normalizeSearch lowercases s and drops separators, so MAC-style IDs match
+// with or without colons, dashes, dots or spaces.
+func normalizeSearch(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch r {
+		case ':', '-', '.', '_', ' ':
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// matchDeviceID reports how the normalized query matches the normalized ID.
+func matchDeviceID(id, query string) (string, bool) {
+	switch {
+	case id == query:
+		return matchExact, true
+	case strings.HasPrefix(id, query):
+		return matchPrefix, true
+	case strings.Contains(id, query):
+		return matchPartial, true
+	case len(query) >= minTypoQuery && containsWithOneTypo(id, query):
+		return matchTypo, true
+	}
+	return "", false
+}
+
+// containsWithOneTypo reports whether s contains query with exactly one
+// character substituted.
+func containsWithOneTypo(s, query string) bool {
+	for start := 0; start+len(query) <= len(s); start++ {
+		diff := 0
+		for i := 0; i < len(query) && diff <= 1; i++ {
+			if s[start+i] != query[i] {
+				diff++
+			}
+		}
+		if diff == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// searchDevices returns the devices matching query, best first, then by ID.
+func searchDevices(devices []DeviceStats, query string) []DeviceSearchResult {
+	var results []DeviceSearchResult
+	for _, device := range devices {
+		result := DeviceSearchResult{DeviceSummary: summarizeDevice(device)}
+		if match, ok := matchDeviceID(normalizeSearch(device.ID), query); ok {
+			result.Match, result.Field = match, "device_id"
+		} else {
+			for _, field := range []struct{ name, value string }{
+				{"org", device.Org},
+				{"firmware_version", device.FirmwareVersion},
+				{"agent_version", device.AgentVersion},
+			} {
+				if field.value != "" && strings.Contains(normalizeSearch(field.value), query) {
+					result.Match, result.Field = matchMetadata, field.name
+					break
+				}
+			}
+		}
+		if result.Match != "" {
+			results = append(results, result)
+		}
+	}
+
+	slices.SortFunc(results, func(a, b DeviceSearchResult) int {
+		if c := matchRank[a.Match] - matchRank[b.Match]; c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return results
+}
+
+// HandleSearchDevices processes GET /api/v1/devices/search
+func (s *Server) HandleSearchDevices(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/devices/search")
+
+	params := r.URL.Query()
+	query := normalizeSearch(params.Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	// Decommissioned devices are included: support may be asked about them
+	devices, err := s.orgDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	results := searchDevices(devices, query)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	if results == nil {
+		results = []DeviceSearchResult{}
+	}
+	writeJSON(w, http.StatusOK, DeviceSearchResponse{Query: params.Get("q"), Results: results})
+}
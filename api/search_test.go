@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSearchDevices tests matching and ranking partial, separated and mistyped IDs
+func TestSearchDevices(t *testing.T) {
+	devices := []DeviceStats{
+		{ID: "A4C1380F22B7", Org: "sunrise"},
+		{ID: "a4-c1-38-0f-99-01", Org: "sunrise"},
+		{ID: "cam-0f22", Org: "lakeside", FirmwareVersion: "2.1.0"},
+		{ID: "kiosk-17", Org: "lakeside", FirmwareVersion: "3.0.1"},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string // device IDs, best first
+	}{
+		{"mac with separators", "a4:c1:38:0f", []string{"A4C1380F22B7", "a4-c1-38-0f-99-01"}},
+		{"mac without separators", "A4C1380F9901", []string{"a4-c1-38-0f-99-01"}},
+		{"partial serial", "0f22", []string{"A4C1380F22B7", "cam-0f22"}},
+		{"exact without separators", "cam0f22", []string{"cam-0f22"}},
+		{"one typo", "380e22", []string{"A4C1380F22B7"}},
+		{"short query needs exact characters", "0e2", nil},
+		{"metadata", "lakeside", []string{"cam-0f22", "kiosk-17"}},
+		{"firmware", "3.0.1", []string{"kiosk-17"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, result := range searchDevices(devices, normalizeSearch(tt.query)) {
+				got = append(got, result.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleSearchDevices tests the search endpoint's parameters and org scoping
+func TestHandleSearchDevices(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-2"].Org = "other"
+	server.EnableAuth(APIKeys{"acme-key": "acme", "admin-key": ""})
+	server.backend().(*Store).devices["device-1"].Org = "acme"
+	router := server.Router()
+
+	search := func(query, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/search"+query, nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := search("?q=--", "admin-key"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an empty query, got %d", rr.Code)
+	}
+	if rr := search("?q=device&limit=0", "admin-key"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid limit, got %d", rr.Code)
+	}
+
+	rr := search("?q=DEVICE&limit=1", "admin-key")
+	var resp DeviceSearchResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || len(resp.Results) != 1 || resp.Results[0].ID != "device-1" || resp.Results[0].Match != matchPrefix {
+		t.Errorf("expected device-1 as a prefix match, got %d %+v", rr.Code, resp)
+	}
+
+	rr = search("?q=device", "acme-key")
+	resp = DeviceSearchResponse{}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Results) != 1 || resp.Results[0].ID != "device-1" {
+		t.Errorf("expected only acme's device, got %+v", resp.Results)
+	}
+}
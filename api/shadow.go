@@ -0,0 +1,808 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Before cutting over to a new backend, it can run in the shadow of the
+// current one. ShadowStorage writes everything to both, answers every read
+// from the primary, and compares the reads that carry data against the
+// candidate on a background goroutine, so a slow or broken candidate never
+// slows or breaks the API. Divergences are logged and served at GET
+// /api/v1/admin/shadow. Values set from the clock when a write lands, such
+// as event times and source IP timestamps, aren't compared, since the two
+// backends see different clocks.
+
+// Shadow comparison limits.
+const (
+	shadowQueueSize      = 1024 // pending comparisons; more are skipped
+	maxShadowDivergences = 100  // recent divergences kept for the admin endpoint
+	maxShadowValueLength = 512  // longest value kept in a divergence
+	shadowLogEveryNth    = 100  // after a method's first divergence, log one in this many
+)
+
+// ShadowDivergence is one read or write the backends answered differently.
+type ShadowDivergence struct {
+	At        time.Time `json:"at"`
+	Method    string    `json:"method"`
+	Key       string    `json:"key,omitempty"` // device ID, group or org the call was about
+	Primary   string    `json:"primary"`
+	Candidate string    `json:"candidate"`
+}
+
+// ShadowStats counts comparisons between the backends.
+type ShadowStats struct {
+	Compared int64 `json:"compared"`
+	Diverged int64 `json:"diverged"`
+	Skipped  int64 `json:"skipped"` // reads not compared because the queue was full
+	Changed  int64 `json:"changed"` // reads not compared because the primary changed first
+
+	DivergedByMethod map[string]int64   `json:"diverged_by_method"`
+	Recent           []ShadowDivergence `json:"recent"` // oldest first
+}
+
+// ShadowStorage is a Storage that mirrors a primary backend onto a
+// candidate and reports where they differ.
+type ShadowStorage struct {
+	primary, candidate Storage
+
+	// Writes go to both backends in the same order
+	writeMu sync.Mutex
+
+	jobs chan func(context.Context)
+
+	mu    sync.Mutex
+	stats ShadowStats // protected by mu
+}
+
+// NewShadowStorage mirrors primary onto candidate. Reads are compared once
+// Run is started.
+func NewShadowStorage(primary, candidate Storage) *ShadowStorage {
+	return &ShadowStorage{
+		primary:   primary,
+		candidate: candidate,
+		jobs:      make(chan func(context.Context), shadowQueueSize),
+		stats:     ShadowStats{DivergedByMethod: make(map[string]int64)},
+	}
+}
+
+// Run compares queued reads until ctx is cancelled.
+func (s *ShadowStorage) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.jobs:
+			job(ctx)
+		}
+	}
+}
+
+// compareQueued runs the comparisons queued so far.
+func (s *ShadowStorage) compareQueued() {
+	for {
+		select {
+		case job := <-s.jobs:
+			job(context.Background())
+		default:
+			return
+		}
+	}
+}
+
+// Stats returns the comparison counts and recent divergences.
+func (s *ShadowStorage) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.DivergedByMethod = make(map[string]int64, len(s.stats.DivergedByMethod))
+	for method, n := range s.stats.DivergedByMethod {
+		stats.DivergedByMethod[method] = n
+	}
+	stats.Recent = slices.Clone(s.stats.Recent)
+	if stats.Recent == nil {
+		stats.Recent = []ShadowDivergence{}
+	}
+	return stats
+}
+
+// record counts one comparison, keeping and logging it if the backends
+// differed.
+func (s *ShadowStorage) record(method, key string, primary, candidate any, equal bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Compared++
+	if equal {
+		return
+	}
+	s.stats.Diverged++
+	s.stats.DivergedByMethod[method]++
+	d := ShadowDivergence{
+		At:        time.Now().UTC(),
+		Method:    method,
+		Key:       key,
+		Primary:   shadowValue(primary),
+		Candidate: shadowValue(candidate),
+	}
+	s.stats.Recent = append(s.stats.Recent, d)
+	if len(s.stats.Recent) > maxShadowDivergences {
+		s.stats.Recent = slices.Delete(s.stats.Recent, 0, len(s.stats.Recent)-maxShadowDivergences)
+	}
+	if n := s.stats.DivergedByMethod[method]; n == 1 || n%shadowLogEveryNth == 0 {
+		log.Printf("[WARN] Shadow storage divergence #%d in %s(%s): primary %s, candidate %s", n, method, key, d.Primary, d.Candidate)
+	}
+}
+
+// shadowValue formats a compared value for a divergence report.
+func shadowValue(v any) string {
+	s := fmt.Sprintf("%+v", v)
+	if len(s) > maxShadowValueLength {
+		s = strings.ToValidUTF8(s[:maxShadowValueLength], "") + "..."
+	}
+	return s
+}
+
+// shadowWrite compares what the backends returned for the same write.
+func shadowWrite[T comparable](s *ShadowStorage, method, key string, primary, candidate T) {
+	s.record(method, key, primary, candidate, primary == candidate)
+}
+
+// shadowRead queues a comparison of a read the primary answered with
+// primary against the same read on the candidate; read performs it on a
+// backend, reduced to a value that equal can compare. If they differ but
+// the primary no longer answers primary either, a write landed in between,
+// and the read isn't compared.
+func shadowRead[T any](s *ShadowStorage, method, key string, primary T, read func(context.Context, Storage) T, equal func(a, b T) bool) {
+	job := func(ctx context.Context) {
+		candidate := read(ctx, s.candidate)
+		if !equal(primary, candidate) && !equal(primary, read(ctx, s.primary)) {
+			s.mu.Lock()
+			s.stats.Changed++
+			s.mu.Unlock()
+			return
+		}
+		s.record(method, key, primary, candidate, equal(primary, candidate))
+	}
+	select {
+	case s.jobs <- job:
+	default:
+		s.mu.Lock()
+		s.stats.Skipped++
+		s.mu.Unlock()
+	}
+}
+
+// equalValues compares comparable values.
+func equalValues[T comparable](a, b T) bool {
+	return a == b
+}
+
+// errString formats an error for comparison; nil is empty.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// shadowTime normalizes a time so backends that store it differently, such
+// as in another zone or without the monotonic clock, compare equal.
+func shadowTime(t time.Time) time.Time {
+	return t.UTC().Round(0)
+}
+
+// deviceDigest is the part of a device the backends must agree on.
+type deviceDigest struct {
+	ID, Org, Facility string
+	Room              string
+	Err               string
+	HeartbeatCount    int64
+	CoveredMinutes    int64
+	FirstHeartbeat    time.Time
+	LastHeartbeat     time.Time
+	UploadCount       int64
+	UploadTimeSum     time.Duration
+	MinUploadTime     time.Duration
+	MaxUploadTime     time.Duration
+	HeartbeatInterval time.Duration
+	ActivatedAt       time.Time
+	DecommissionedAt  time.Time
+	MutedUntil        time.Time
+}
+
+// digestDevice reduces a device to what the backends must agree on.
+func digestDevice(device DeviceStats, err error) deviceDigest {
+	return deviceDigest{
+		ID:                device.ID,
+		Org:               device.Org,
+		Facility:          device.Facility,
+		Room:              device.Room,
+		Err:               errString(err),
+		HeartbeatCount:    device.HeartbeatCount,
+		CoveredMinutes:    device.CoveredMinutes,
+		FirstHeartbeat:    shadowTime(device.FirstHeartbeat),
+		LastHeartbeat:     shadowTime(device.LastHeartbeat),
+		UploadCount:       device.UploadCount,
+		UploadTimeSum:     device.UploadTimeSum,
+		MinUploadTime:     device.MinUploadTime,
+		MaxUploadTime:     device.MaxUploadTime,
+		HeartbeatInterval: device.HeartbeatInterval,
+		ActivatedAt:       shadowTime(device.ActivatedAt),
+		DecommissionedAt:  shadowTime(device.DecommissionedAt),
+		MutedUntil:        shadowTime(device.MutedUntil),
+	}
+}
+
+// statsDigest is the part of a stats result the backends must agree on.
+type statsDigest struct {
+	Err                       string
+	HasHeartbeats, HasUploads bool
+	Uptime                    float64
+	AvgUploadTime             time.Duration
+	MinUploadTime             time.Duration
+	MaxUploadTime             time.Duration
+	LastUploadTime            time.Duration
+}
+
+// digestStats reduces a stats result to what the backends must agree on.
+func digestStats(result StatsResult, err error) statsDigest {
+	return statsDigest{
+		Err:            errString(err),
+		HasHeartbeats:  result.HasHeartbeats,
+		HasUploads:     result.HasUploads,
+		Uptime:         result.Uptime,
+		AvgUploadTime:  result.AvgUploadTime,
+		MinUploadTime:  result.MinUploadTime,
+		MaxUploadTime:  result.MaxUploadTime,
+		LastUploadTime: result.LastUploadTime,
+	}
+}
+
+// groupDigest is a group as the backends must agree on it.
+type groupDigest struct {
+	Org, Name  string
+	Err        string
+	AlertAfter time.Duration
+	DeviceIDs  string
+}
+
+// digestGroup reduces a group to a comparable value.
+func digestGroup(group Group, err error) groupDigest {
+	return groupDigest{
+		Org:        group.Org,
+		Name:       group.Name,
+		Err:        errString(err),
+		AlertAfter: group.AlertAfter,
+		DeviceIDs:  strings.Join(group.DeviceIDs, ","),
+	}
+}
+
+// digestAll reduces each of values with digest.
+func digestAll[T, D any](values []T, digest func(T) D) []D {
+	digests := make([]D, len(values))
+	for i, v := range values {
+		digests[i] = digest(v)
+	}
+	return digests
+}
+
+// shadowOutcome is what a call returned, compared as a whole.
+type shadowOutcome[T comparable] struct {
+	Value T
+	Err   string
+}
+
+func outcome[T comparable](v T, err error) shadowOutcome[T] {
+	return shadowOutcome[T]{v, errString(err)}
+}
+
+// shadowList is what a call returning a list returned, reduced with a digest.
+type shadowList[D comparable] struct {
+	Values []D
+	Err    string
+}
+
+func listOutcome[T any, D comparable](values []T, err error, digest func(T) D) shadowList[D] {
+	return shadowList[D]{digestAll(values, digest), errString(err)}
+}
+
+func equalLists[D comparable](a, b shadowList[D]) bool {
+	return a.Err == b.Err && slices.Equal(a.Values, b.Values)
+}
+
+// The shadow is a backend in its own right, and snapshots like its primary
+var (
+	_ Storage              = (*ShadowStorage)(nil)
+	_ Snapshotter          = (*ShadowStorage)(nil)
+	_ Importer             = (*ShadowStorage)(nil)
+	_ Compactor            = (*ShadowStorage)(nil)
+	_ MemoryBounded        = (*ShadowStorage)(nil)
+	_ LockReporter         = (*ShadowStorage)(nil)
+	_ DistributionReporter = (*ShadowStorage)(nil)
+)
+
+// DeviceRegistry
+
+func (s *ShadowStorage) LoadDevicesFromCSV(ctx context.Context, filenames ...string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.LoadDevicesFromCSV(ctx, filenames...)
+	shadowWrite(s, "LoadDevicesFromCSV", strings.Join(filenames, ","), errString(err), errString(s.candidate.LoadDevicesFromCSV(ctx, filenames...)))
+	return err
+}
+
+func (s *ShadowStorage) ReplaceDevices(ctx context.Context, devices []DeviceStats) (ReloadResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	result, err := s.primary.ReplaceDevices(ctx, devices)
+	shadowWrite(s, "ReplaceDevices", "", outcome(result, err), outcome(s.candidate.ReplaceDevices(ctx, devices)))
+	return result, err
+}
+
+func (s *ShadowStorage) AddDevice(ctx context.Context, device DeviceStats) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.AddDevice(ctx, device)
+	shadowWrite(s, "AddDevice", device.ID, errString(err), errString(s.candidate.AddDevice(ctx, device)))
+	return err
+}
+
+func (s *ShadowStorage) DeviceExists(ctx context.Context, deviceID string) (bool, error) {
+	exists, err := s.primary.DeviceExists(ctx, deviceID)
+	shadowRead(s, "DeviceExists", deviceID, outcome(exists, err), func(ctx context.Context, b Storage) shadowOutcome[bool] {
+		return outcome(b.DeviceExists(ctx, deviceID))
+	}, equalValues)
+	return exists, err
+}
+
+func (s *ShadowStorage) DeviceOrg(ctx context.Context, deviceID string) (string, error) {
+	org, err := s.primary.DeviceOrg(ctx, deviceID)
+	shadowRead(s, "DeviceOrg", deviceID, outcome(org, err), func(ctx context.Context, b Storage) shadowOutcome[string] {
+		return outcome(b.DeviceOrg(ctx, deviceID))
+	}, equalValues)
+	return org, err
+}
+
+func (s *ShadowStorage) DeviceCount(ctx context.Context) (int, error) {
+	n, err := s.primary.DeviceCount(ctx)
+	shadowRead(s, "DeviceCount", "", outcome(n, err), func(ctx context.Context, b Storage) shadowOutcome[int] {
+		return outcome(b.DeviceCount(ctx))
+	}, equalValues)
+	return n, err
+}
+
+func (s *ShadowStorage) Device(ctx context.Context, deviceID string) (DeviceStats, error) {
+	device, err := s.primary.Device(ctx, deviceID)
+	shadowRead(s, "Device", deviceID, digestDevice(device, err), func(ctx context.Context, b Storage) deviceDigest {
+		return digestDevice(b.Device(ctx, deviceID))
+	}, equalValues)
+	return device, err
+}
+
+func (s *ShadowStorage) ListDevices(ctx context.Context) ([]DeviceStats, error) {
+	devices, err := s.primary.ListDevices(ctx)
+	digest := func(device DeviceStats) deviceDigest { return digestDevice(device, nil) }
+	shadowRead(s, "ListDevices", "", listOutcome(devices, err, digest), func(ctx context.Context, b Storage) shadowList[deviceDigest] {
+		devices, err := b.ListDevices(ctx)
+		return listOutcome(devices, err, digest)
+	}, equalLists)
+	return devices, err
+}
+
+func (s *ShadowStorage) Activate(ctx context.Context, deviceID string, at time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.Activate(ctx, deviceID, at)
+	shadowWrite(s, "Activate", deviceID, errString(err), errString(s.candidate.Activate(ctx, deviceID, at)))
+	return err
+}
+
+func (s *ShadowStorage) Decommission(ctx context.Context, deviceID string, at time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.Decommission(ctx, deviceID, at)
+	shadowWrite(s, "Decommission", deviceID, errString(err), errString(s.candidate.Decommission(ctx, deviceID, at)))
+	return err
+}
+
+func (s *ShadowStorage) Mute(ctx context.Context, deviceID string, until time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.Mute(ctx, deviceID, until)
+	shadowWrite(s, "Mute", deviceID, errString(err), errString(s.candidate.Mute(ctx, deviceID, until)))
+	return err
+}
+
+func (s *ShadowStorage) Transfer(ctx context.Context, deviceID, org string, at time.Time, aggregates string) (DeviceTransfer, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	transfer, err := s.primary.Transfer(ctx, deviceID, org, at, aggregates)
+	_, candidateErr := s.candidate.Transfer(ctx, deviceID, org, at, aggregates)
+	shadowWrite(s, "Transfer", deviceID, errString(err), errString(candidateErr))
+	return transfer, err
+}
+
+func (s *ShadowStorage) IsDecommissioned(ctx context.Context, deviceID string) (bool, error) {
+	retired, err := s.primary.IsDecommissioned(ctx, deviceID)
+	shadowRead(s, "IsDecommissioned", deviceID, outcome(retired, err), func(ctx context.Context, b Storage) shadowOutcome[bool] {
+		return outcome(b.IsDecommissioned(ctx, deviceID))
+	}, equalValues)
+	return retired, err
+}
+
+func (s *ShadowStorage) RecordEvent(ctx context.Context, deviceID, eventType string, at time.Time, detail string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.RecordEvent(ctx, deviceID, eventType, at, detail)
+	shadowWrite(s, "RecordEvent", deviceID, errString(err), errString(s.candidate.RecordEvent(ctx, deviceID, eventType, at, detail)))
+	return err
+}
+
+// TelemetryStore
+
+func (s *ShadowStorage) RecordHeartbeat(ctx context.Context, deviceID string, sentAt time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.RecordHeartbeat(ctx, deviceID, sentAt)
+	shadowWrite(s, "RecordHeartbeat", deviceID, errString(err), errString(s.candidate.RecordHeartbeat(ctx, deviceID, sentAt)))
+	return err
+}
+
+// RecordUploadStat records the upload at the same time on both backends.
+func (s *ShadowStorage) RecordUploadStat(ctx context.Context, deviceID string, uploadTime time.Duration) error {
+	return s.RecordUploadStatAt(ctx, deviceID, uploadTime, time.Now())
+}
+
+func (s *ShadowStorage) RecordUploadStatAt(ctx context.Context, deviceID string, uploadTime time.Duration, at time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.RecordUploadStatAt(ctx, deviceID, uploadTime, at)
+	shadowWrite(s, "RecordUploadStatAt", deviceID, errString(err), errString(s.candidate.RecordUploadStatAt(ctx, deviceID, uploadTime, at)))
+	return err
+}
+
+func (s *ShadowStorage) SetHeartbeatInterval(ctx context.Context, deviceID string, interval time.Duration) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.SetHeartbeatInterval(ctx, deviceID, interval)
+	shadowWrite(s, "SetHeartbeatInterval", deviceID, errString(err), errString(s.candidate.SetHeartbeatInterval(ctx, deviceID, interval)))
+	return err
+}
+
+func (s *ShadowStorage) SetVersions(ctx context.Context, deviceID, firmwareVersion, agentVersion string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.SetVersions(ctx, deviceID, firmwareVersion, agentVersion)
+	shadowWrite(s, "SetVersions", deviceID, errString(err), errString(s.candidate.SetVersions(ctx, deviceID, firmwareVersion, agentVersion)))
+	return err
+}
+
+func (s *ShadowStorage) GetStats(ctx context.Context, deviceID string) (StatsResult, error) {
+	result, err := s.primary.GetStats(ctx, deviceID)
+	shadowRead(s, "GetStats", deviceID, digestStats(result, err), func(ctx context.Context, b Storage) statsDigest {
+		return digestStats(b.GetStats(ctx, deviceID))
+	}, equalValues)
+	return result, err
+}
+
+func (s *ShadowStorage) DeviceAsOf(ctx context.Context, deviceID string, asOf time.Time) (DeviceStats, error) {
+	return s.primary.DeviceAsOf(ctx, deviceID, asOf)
+}
+
+func (s *ShadowStorage) History(ctx context.Context, deviceID string, from, to time.Time) ([]HistoryBucket, time.Duration, error) {
+	buckets, interval, err := s.primary.History(ctx, deviceID, from, to)
+	digest := func(b HistoryBucket) HistoryBucket {
+		b.Start = shadowTime(b.Start)
+		return b
+	}
+	shadowRead(s, "History", deviceID, listOutcome(buckets, err, digest), func(ctx context.Context, b Storage) shadowList[HistoryBucket] {
+		buckets, _, err := b.History(ctx, deviceID, from, to)
+		return listOutcome(buckets, err, digest)
+	}, equalLists)
+	return buckets, interval, err
+}
+
+func (s *ShadowStorage) Activity(ctx context.Context, org string, from, to time.Time, step time.Duration) ([]ActivityPoint, error) {
+	return s.primary.Activity(ctx, org, from, to, step)
+}
+
+func (s *ShadowStorage) RecentUploads(ctx context.Context, deviceID string, limit int) ([]UploadRecord, error) {
+	return s.primary.RecentUploads(ctx, deviceID, limit)
+}
+
+func (s *ShadowStorage) SetRecentUploadCapacity(n int) {
+	for _, backend := range []Storage{s.primary, s.candidate} {
+		if bounded, ok := backend.(MemoryBounded); ok {
+			bounded.SetRecentUploadCapacity(n)
+		}
+	}
+}
+
+func (s *ShadowStorage) SetIntervalSamples(n int) {
+	s.primary.SetIntervalSamples(n)
+	s.candidate.SetIntervalSamples(n)
+}
+
+func (s *ShadowStorage) SetMemoryLimits(limits MemoryLimits) {
+	for _, backend := range []Storage{s.primary, s.candidate} {
+		if bounded, ok := backend.(MemoryBounded); ok {
+			bounded.SetMemoryLimits(limits)
+		}
+	}
+}
+
+func (s *ShadowStorage) Apply(ctx context.Context, events []TelemetryEvent) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.Apply(ctx, events)
+	shadowWrite(s, "Apply", fmt.Sprintf("%d events", len(events)), errString(err), errString(s.candidate.Apply(ctx, events)))
+	return err
+}
+
+// shadowTx stages events on a transaction on each backend, and compares
+// whether both commit. candidate is nil if the candidate couldn't begin one.
+type shadowTx struct {
+	shadow             *ShadowStorage
+	primary, candidate Tx
+	events             int
+}
+
+func (s *ShadowStorage) Begin(ctx context.Context) (Tx, error) {
+	primary, err := s.primary.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := s.candidate.Begin(ctx)
+	shadowWrite(s, "Begin", "", "", errString(err))
+	return &shadowTx{shadow: s, primary: primary, candidate: candidate}, nil
+}
+
+func (tx *shadowTx) Stage(events ...TelemetryEvent) {
+	tx.primary.Stage(events...)
+	if tx.candidate != nil {
+		tx.candidate.Stage(events...)
+	}
+	tx.events += len(events)
+}
+
+func (tx *shadowTx) Commit(ctx context.Context) error {
+	tx.shadow.writeMu.Lock()
+	defer tx.shadow.writeMu.Unlock()
+	err := tx.primary.Commit(ctx)
+	if tx.candidate != nil {
+		shadowWrite(tx.shadow, "Commit", fmt.Sprintf("%d events", tx.events), errString(err), errString(tx.candidate.Commit(ctx)))
+	}
+	return err
+}
+
+func (tx *shadowTx) Rollback() error {
+	if tx.candidate != nil {
+		if err := tx.candidate.Rollback(); err != nil {
+			log.Printf("[WARN] Shadow candidate rollback failed: %v", err)
+		}
+	}
+	return tx.primary.Rollback()
+}
+
+// GroupStore
+
+func (s *ShadowStorage) CreateGroup(ctx context.Context, group Group) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.CreateGroup(ctx, group)
+	shadowWrite(s, "CreateGroup", group.Org+"/"+group.Name, errString(err), errString(s.candidate.CreateGroup(ctx, group)))
+	return err
+}
+
+func (s *ShadowStorage) ReplaceGroup(ctx context.Context, group Group) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.ReplaceGroup(ctx, group)
+	shadowWrite(s, "ReplaceGroup", group.Org+"/"+group.Name, errString(err), errString(s.candidate.ReplaceGroup(ctx, group)))
+	return err
+}
+
+func (s *ShadowStorage) GetGroup(ctx context.Context, org, name string) (Group, error) {
+	group, err := s.primary.GetGroup(ctx, org, name)
+	shadowRead(s, "GetGroup", org+"/"+name, digestGroup(group, err), func(ctx context.Context, b Storage) groupDigest {
+		return digestGroup(b.GetGroup(ctx, org, name))
+	}, equalValues)
+	return group, err
+}
+
+func (s *ShadowStorage) ListGroups(ctx context.Context, org string) ([]Group, error) {
+	groups, err := s.primary.ListGroups(ctx, org)
+	digest := func(group Group) groupDigest { return digestGroup(group, nil) }
+	shadowRead(s, "ListGroups", org, listOutcome(groups, err, digest), func(ctx context.Context, b Storage) shadowList[groupDigest] {
+		groups, err := b.ListGroups(ctx, org)
+		return listOutcome(groups, err, digest)
+	}, equalLists)
+	return groups, err
+}
+
+func (s *ShadowStorage) DeleteGroup(ctx context.Context, org, name string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.DeleteGroup(ctx, org, name)
+	shadowWrite(s, "DeleteGroup", org+"/"+name, errString(err), errString(s.candidate.DeleteGroup(ctx, org, name)))
+	return err
+}
+
+func (s *ShadowStorage) AddGroupMember(ctx context.Context, org, name, deviceID string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.AddGroupMember(ctx, org, name, deviceID)
+	shadowWrite(s, "AddGroupMember", org+"/"+name, errString(err), errString(s.candidate.AddGroupMember(ctx, org, name, deviceID)))
+	return err
+}
+
+func (s *ShadowStorage) RemoveGroupMember(ctx context.Context, org, name, deviceID string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.RemoveGroupMember(ctx, org, name, deviceID)
+	shadowWrite(s, "RemoveGroupMember", org+"/"+name, errString(err), errString(s.candidate.RemoveGroupMember(ctx, org, name, deviceID)))
+	return err
+}
+
+func (s *ShadowStorage) GroupAlertThresholds(ctx context.Context) (map[string]time.Duration, error) {
+	return s.primary.GroupAlertThresholds(ctx)
+}
+
+// MaintenanceStore
+
+func (s *ShadowStorage) AddMaintenance(ctx context.Context, w MaintenanceWindow) (MaintenanceWindow, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	added, err := s.primary.AddMaintenance(ctx, w)
+	candidate, candidateErr := s.candidate.AddMaintenance(ctx, w)
+	shadowWrite(s, "AddMaintenance", w.Org, outcome(added.ID, err), outcome(candidate.ID, candidateErr))
+	return added, err
+}
+
+func (s *ShadowStorage) ListMaintenance(ctx context.Context, org, deviceID string) ([]MaintenanceWindow, error) {
+	windows, err := s.primary.ListMaintenance(ctx, org, deviceID)
+	digest := func(w MaintenanceWindow) MaintenanceWindow {
+		w.Start, w.End = shadowTime(w.Start), shadowTime(w.End)
+		return w
+	}
+	shadowRead(s, "ListMaintenance", org, listOutcome(windows, err, digest), func(ctx context.Context, b Storage) shadowList[MaintenanceWindow] {
+		windows, err := b.ListMaintenance(ctx, org, deviceID)
+		return listOutcome(windows, err, digest)
+	}, equalLists)
+	return windows, err
+}
+
+func (s *ShadowStorage) DeleteMaintenance(ctx context.Context, org string, id int64) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.primary.DeleteMaintenance(ctx, org, id)
+	shadowWrite(s, "DeleteMaintenance", org, errString(err), errString(s.candidate.DeleteMaintenance(ctx, org, id)))
+	return err
+}
+
+// Optional capabilities, answered by the primary where it has them, as
+// with snapshots
+
+func (s *ShadowStorage) LockStats() LockWaitStats {
+	if reporter, ok := s.primary.(LockReporter); ok {
+		return reporter.LockStats()
+	}
+	return LockWaitStats{}
+}
+
+func (s *ShadowStorage) Evictions() Evictions {
+	if bounded, ok := s.primary.(MemoryBounded); ok {
+		return bounded.Evictions()
+	}
+	return Evictions{}
+}
+
+func (s *ShadowStorage) Usage(ctx context.Context) (StoreUsage, error) {
+	if bounded, ok := s.primary.(MemoryBounded); ok {
+		return bounded.Usage(ctx)
+	}
+	return StoreUsage{}, nil
+}
+
+func (s *ShadowStorage) Distributions(ctx context.Context) (Distributions, error) {
+	if reporter, ok := s.primary.(DistributionReporter); ok {
+		return reporter.Distributions(ctx)
+	}
+	return Distributions{}, nil
+}
+
+// Compact compacts both backends that compact, reporting the primary's
+// result.
+func (s *ShadowStorage) Compact(ctx context.Context, now time.Time, retention time.Duration) (CompactResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	var result CompactResult
+	var err, candidateErr error
+	if compactor, ok := s.primary.(Compactor); ok {
+		result, err = compactor.Compact(ctx, now, retention)
+	}
+	if compactor, ok := s.candidate.(Compactor); ok {
+		_, candidateErr = compactor.Compact(ctx, now, retention)
+	}
+	shadowWrite(s, "Compact", "", errString(err), errString(candidateErr))
+	return result, err
+}
+
+// PlanCompact changes nothing, so only the primary's plan is reported.
+func (s *ShadowStorage) PlanCompact(ctx context.Context, now time.Time, retention time.Duration) ([]RetentionImpact, error) {
+	if compactor, ok := s.primary.(Compactor); ok {
+		return compactor.PlanCompact(ctx, now, retention)
+	}
+	return nil, nil
+}
+
+// Import imports into both backends, which must both be migration targets.
+func (s *ShadowStorage) Import(ctx context.Context, state StorageState) error {
+	primary, ok := s.primary.(Importer)
+	candidate, candidateOK := s.candidate.(Importer)
+	if !ok || !candidateOK {
+		return fmt.Errorf("both storage backends must be migration targets")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := primary.Import(ctx, state)
+	shadowWrite(s, "Import", "", errString(err), errString(candidate.Import(ctx, state)))
+	return err
+}
+
+// Snapshot saves the primary, if it snapshots.
+func (s *ShadowStorage) Snapshot(w io.Writer) error {
+	snapshotter, ok := s.primary.(Snapshotter)
+	if !ok {
+		return fmt.Errorf("the primary storage backend doesn't snapshot")
+	}
+	return snapshotter.Snapshot(w)
+}
+
+// Restore restores the primary, then seeds the candidate with a copy of it,
+// so the two start out the same.
+func (s *ShadowStorage) Restore(r io.Reader) error {
+	snapshotter, ok := s.primary.(Snapshotter)
+	if !ok {
+		return fmt.Errorf("the primary storage backend doesn't snapshot")
+	}
+	importer, ok := s.candidate.(Importer)
+	if !ok {
+		return fmt.Errorf("the candidate storage backend can't be seeded")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := snapshotter.Restore(r); err != nil {
+		return err
+	}
+	state, err := exportState(context.Background(), s.primary)
+	if err != nil {
+		return fmt.Errorf("copy the primary to the candidate: %w", err)
+	}
+	if err := importer.Import(context.Background(), state); err != nil {
+		return fmt.Errorf("copy the primary to the candidate: %w", err)
+	}
+	return nil
+}
+
+// HandleShadow processes GET /api/v1/admin/shadow
+func (s *Server) HandleShadow(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/shadow")
+
+	shadow, ok := s.backend().(*ShadowStorage)
+	if !ok {
+		writeError(w, http.StatusNotFound, "shadow storage is not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, shadow.Stats())
+}
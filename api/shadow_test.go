@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestShadowStorage tests that writes reach both backends and that reads
+// the candidate answers differently are reported
+func TestShadowStorage(t *testing.T) {
+	primary, candidate := NewStore(), NewStore()
+	shadow := NewShadowStorage(primary, candidate)
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-1", Org: "acme"})
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-2", Org: "acme"})
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	shadow.RecordHeartbeat(t.Context(), "cam-1", t1)
+	shadow.RecordUploadStat(t.Context(), "cam-1", 3*time.Second)
+
+	if device, _ := candidate.Device(t.Context(), "cam-1"); device.HeartbeatCount != 1 || device.UploadCount != 1 {
+		t.Fatalf("expected the candidate to get the writes, got %+v", device)
+	}
+	shadow.Device(t.Context(), "cam-1")
+	shadow.ListDevices(t.Context())
+	shadow.compareQueued()
+	if stats := shadow.Stats(); stats.Diverged != 0 || stats.Compared == 0 {
+		t.Fatalf("expected agreement, got %+v", stats)
+	}
+
+	// A heartbeat only the candidate saw
+	candidate.RecordHeartbeat(t.Context(), "cam-2", t1)
+	if result, _ := shadow.GetStats(t.Context(), "cam-2"); result.HasHeartbeats {
+		t.Error("expected reads to be answered by the primary")
+	}
+	shadow.compareQueued()
+	stats := shadow.Stats()
+	if stats.Diverged != 1 || stats.DivergedByMethod["GetStats"] != 1 || len(stats.Recent) != 1 || stats.Recent[0].Key != "cam-2" {
+		t.Errorf("expected one GetStats divergence, got %+v", stats)
+	}
+
+	// Writes the backends disagree on are reported too
+	if err := shadow.RecordHeartbeat(t.Context(), "cam-3", t1); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("expected an unknown device to be rejected, got %v", err)
+	}
+	candidate.AddDevice(t.Context(), DeviceStats{ID: "cam-4"})
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-4"})
+	if stats := shadow.Stats(); stats.DivergedByMethod["AddDevice"] != 1 {
+		t.Errorf("expected an AddDevice divergence, got %+v", stats.DivergedByMethod)
+	}
+}
+
+// TestShadowStorage_Tx tests that transactions commit on both backends and
+// that only one of them committing is reported
+func TestShadowStorage_Tx(t *testing.T) {
+	primary, candidate := NewStore(), NewStore()
+	shadow := NewShadowStorage(primary, candidate)
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-1"})
+	ev := TelemetryEvent{DeviceID: "cam-1", Heartbeat: true, At: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)}
+
+	tx, err := shadow.Begin(t.Context())
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	tx.Stage(ev)
+	if err := tx.Commit(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if device, _ := candidate.Device(t.Context(), "cam-1"); device.HeartbeatCount != 1 {
+		t.Errorf("expected the candidate to commit, got %+v", device)
+	}
+
+	candidate.Decommission(t.Context(), "cam-1", ev.At)
+	tx, _ = shadow.Begin(t.Context())
+	tx.Stage(ev)
+	if err := tx.Commit(t.Context()); err != nil {
+		t.Fatalf("expected the primary's result, got %v", err)
+	}
+	if stats := shadow.Stats(); stats.DivergedByMethod["Commit"] != 1 {
+		t.Errorf("expected a Commit divergence, got %+v", stats.DivergedByMethod)
+	}
+}
+
+// TestShadowStorage_ChangedRead tests that a read the primary changed
+// before the comparison isn't reported
+func TestShadowStorage_ChangedRead(t *testing.T) {
+	shadow := NewShadowStorage(NewStore(), NewStore())
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-1"})
+	deviceCount(t, shadow)
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-2"})
+	shadow.compareQueued()
+
+	if stats := shadow.Stats(); stats.Diverged != 0 || stats.Changed != 1 {
+		t.Errorf("expected the read to be skipped as changed, got %+v", stats)
+	}
+}
+
+// TestShadowStorage_Restore tests that restoring a snapshot seeds the
+// candidate as well
+func TestShadowStorage_Restore(t *testing.T) {
+	source := NewStore()
+	source.AddDevice(t.Context(), DeviceStats{ID: "cam-1"})
+	source.RecordHeartbeat(t.Context(), "cam-1", time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	var buf bytes.Buffer
+	if err := source.Snapshot(&buf); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+
+	candidate := NewStore()
+	shadow := NewShadowStorage(NewStore(), candidate)
+	shadow.AddDevice(t.Context(), DeviceStats{ID: "cam-1"})
+	if err := shadow.Restore(&buf); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if device, err := candidate.Device(t.Context(), "cam-1"); err != nil || device.HeartbeatCount != 1 {
+		t.Errorf("expected the candidate to be seeded, got %+v", device)
+	}
+}
+
+// TestHandleShadow tests the shadow comparison endpoint
+func TestHandleShadow(t *testing.T) {
+	server := setupTestServer()
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/shadow", nil))
+		return rr
+	}
+	if rr := get(); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without shadow storage, got %d", rr.Code)
+	}
+
+	server.store = NewShadowStorage(server.store, NewStore())
+	rr := get()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp ShadowStats
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Recent == nil || resp.DivergedByMethod == nil {
+		t.Errorf("expected empty lists rather than null, got %+v", resp)
+	}
+}
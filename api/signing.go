@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signed payloads stop one device, or anyone holding an API key, from
+// reporting telemetry as another device. A device with a signing key sends
+//
+//	X-Signature: sha256=<hex HMAC-SHA256 of the request body>
+//
+// and its heartbeats and upload stats are refused with 401 without one.
+// Keys come from the device CSV's signing_secret column, or are derived per
+// device from DEVICE_SIGNING_SECRET (as for UDP heartbeats), which signs
+// every device. The body's sent_at is covered by the signature, so the
+// sent_at window also bounds how long a captured request can be replayed.
+
+// signatureHeader carries a payload signature.
+const signatureHeader = "X-Signature"
+
+var (
+	errSignatureMissing  error = &validationError{code: errCodeSignatureMissing, msg: "missing " + signatureHeader + " header"}
+	errSignatureInvalid  error = &validationError{code: errCodeSignatureInvalid, msg: "invalid payload signature"}
+	errSignatureRequired error = &validationError{code: errCodeSignatureRequired, msg: "device requires signed requests; send them to the device's endpoints"}
+)
+
+// EnableSigning requires every device to sign its payloads, with keys
+// derived from secret. Devices with their own signing_secret keep it.
+func (s *Server) EnableSigning(secret []byte) {
+	s.signingSecret = secret
+}
+
+// signingKey returns the key the device signs with, or nil if it doesn't
+// have to sign.
+func (s *Server) signingKey(device DeviceStats) []byte {
+	if device.signingKey != nil {
+		return device.signingKey
+	}
+	if s.signingSecret != nil {
+		return deriveDeviceKey(s.signingSecret, device.ID)
+	}
+	return nil
+}
+
+// signatureRequired reports whether the device's telemetry must be signed.
+func (s *Server) signatureRequired(ctx context.Context, deviceID string) (bool, error) {
+	device, err := s.store.Device(ctx, deviceID)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.signingKey(device) != nil, nil
+}
+
+// verifySignature checks the request's signature over body for devices
+// that sign, counting failures against the device.
+func (s *Server) verifySignature(r *http.Request, deviceID string, body []byte) error {
+	device, err := s.store.Device(r.Context(), deviceID)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key := s.signingKey(device)
+	if key == nil {
+		return nil
+	}
+
+	err = checkSignature(key, r.Header.Get(signatureHeader), body)
+	if err != nil {
+		s.signatureFailures.add(device, err, time.Now())
+	}
+	return err
+}
+
+// checkSignature compares a "sha256=<hex>" header with the body's HMAC.
+func checkSignature(key []byte, header string, body []byte) error {
+	if header == "" {
+		return errSignatureMissing
+	}
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return errSignatureInvalid
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return errSignatureInvalid
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errSignatureInvalid
+	}
+	return nil
+}
+
+// SignatureFailure counts a device's rejected signatures.
+type SignatureFailure struct {
+	DeviceID    string    `json:"device_id"`
+	Failures    int64     `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LastReason  string    `json:"last_reason"`
+}
+
+// signatureFailures tracks rejected signatures per device. It has its own
+// lock so rejections never contend with telemetry writes.
+type signatureFailures struct {
+	mu      sync.Mutex
+	devices map[string]*SignatureFailure // protected by mu
+}
+
+func newSignatureFailures() *signatureFailures {
+	return &signatureFailures{devices: make(map[string]*SignatureFailure)}
+}
+
+func (f *signatureFailures) add(device DeviceStats, err error, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, exists := f.devices[device.ID]
+	if !exists {
+		entry = &SignatureFailure{DeviceID: device.ID}
+		f.devices[device.ID] = entry
+	}
+	entry.Failures++
+	entry.LastFailure = at.UTC()
+	entry.LastReason = err.Error()
+}
+
+// list returns every device's failures, most first.
+func (f *signatureFailures) list() []SignatureFailure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := []SignatureFailure{}
+	for _, entry := range f.devices {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Failures != result[j].Failures {
+			return result[i].Failures > result[j].Failures
+		}
+		return result[i].DeviceID < result[j].DeviceID
+	})
+	return result
+}
+
+// SignatureFailuresResponse is the body of GET /api/v1/admin/signatures.
+type SignatureFailuresResponse struct {
+	Devices []SignatureFailure `json:"devices"`
+}
+
+// HandleSignatureFailures processes GET /api/v1/admin/signatures
+func (s *Server) HandleSignatureFailures(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] GET /api/v1/admin/signatures")
+
+	writeJSON(w, http.StatusOK, SignatureFailuresResponse{
+		Devices: s.signatureFailures.list(),
+	})
+}
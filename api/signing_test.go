@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sign returns the X-Signature value for body.
+func sign(key []byte, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestParseDevicesCSV_SigningSecret tests the optional signing_secret column
+func TestParseDevicesCSV_SigningSecret(t *testing.T) {
+	devices, err := parseDevicesCSV(strings.NewReader("device_id,signing_secret\ncam-1,s3cret\ncam-2,\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if string(devices[0].signingKey) != "s3cret" || devices[1].signingKey != nil {
+		t.Errorf("unexpected keys %q %q", devices[0].signingKey, devices[1].signingKey)
+	}
+}
+
+// TestSignedHeartbeat tests accepting valid signatures and rejecting missing or forged ones
+func TestSignedHeartbeat(t *testing.T) {
+	server := setupTestServer()
+	key := []byte("device-1-secret")
+	server.backend().(*Store).devices["device-1"].signingKey = key
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	post := func(deviceID, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/"+deviceID+"/heartbeat", bytes.NewBufferString(body))
+		if signature != "" {
+			req.Header.Set(signatureHeader, signature)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name      string
+		deviceID  string
+		signature string
+		want      int
+	}{
+		{"valid", "device-1", sign(key, body), http.StatusNoContent},
+		{"missing", "device-1", "", http.StatusUnauthorized},
+		{"wrong key", "device-1", sign([]byte("other"), body), http.StatusUnauthorized},
+		{"not hex", "device-1", "sha256=zz", http.StatusUnauthorized},
+		{"no prefix", "device-1", strings.TrimPrefix(sign(key, body), "sha256="), http.StatusUnauthorized},
+		{"unsigned device", "device-2", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if code := post(tt.deviceID, tt.signature); code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, code)
+		}
+	}
+
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.HeartbeatCount != 1 {
+		t.Errorf("expected only the signed heartbeat recorded, got %d", device.HeartbeatCount)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/signatures", nil))
+	var resp SignatureFailuresResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Devices) != 1 || resp.Devices[0].DeviceID != "device-1" || resp.Devices[0].Failures != 4 || resp.Devices[0].LastReason != errSignatureInvalid.Error() {
+		t.Errorf("unexpected failures %+v", resp.Devices)
+	}
+}
+
+// TestSigningSecret tests keys derived from the server secret, for stats and bulk ingest
+func TestSigningSecret(t *testing.T) {
+	server := setupTestServer()
+	secret := []byte("fleet-secret")
+	server.EnableSigning(secret)
+	router := server.Router()
+
+	body := `{"upload_time": 5000000000}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-2/stats", bytes.NewBufferString(body))
+	req.Header.Set(signatureHeader, sign(deriveDeviceKey(secret, "device-2"), body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 with a derived key, got %d", rr.Code)
+	}
+
+	// Another device's key doesn't work
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-2/stats", bytes.NewBufferString(body))
+	req.Header.Set(signatureHeader, sign(deriveDeviceKey(secret, "device-1"), body))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with another device's key, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewBufferString(`{"device_id": "device-1", "type": "upload", "upload_time": 1000000000}`+"\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var result IngestResult
+	_ = json.NewDecoder(rr.Body).Decode(&result)
+	if result.Status != "rejected" || result.Error != errSignatureRequired.Error() {
+		t.Errorf("expected ingest refused for a signing device, got %+v", result)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.UploadCount != 0 {
+		t.Error("expected nothing recorded from ingest")
+	}
+}
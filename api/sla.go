@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLA reports compare achieved uptime over a window against a target, using
+// the hourly history. The window ends at the start of the current hour, so the
+// in-progress hour never counts as downtime.
+const (
+	defaultSLATarget = 99.5
+	defaultSLAWindow = 30 * 24 * time.Hour
+	maxSLAWindow     = historyBucketSize * historyBuckets
+)
+
+// SLAResponse is one device's SLA result.
+type SLAResponse struct {
+	DeviceID               string    `json:"device_id"`
+	From                   time.Time `json:"from"`
+	To                     time.Time `json:"to"`
+	Target                 float64   `json:"target"`
+	AchievedUptime         float64   `json:"achieved_uptime"`
+	DowntimeMinutes        float64   `json:"downtime_minutes"`
+	AllowedDowntimeMinutes float64   `json:"allowed_downtime_minutes"`
+	BreachMinutes          float64   `json:"breach_minutes"` // downtime beyond what the target allows
+	MaintenanceMinutes     float64   `json:"maintenance_minutes"`
+	Pass                   bool      `json:"pass"`
+
+	// Unrounded, for fleet aggregation
+	downtime time.Duration
+	measured time.Duration // the window minus maintenance
+}
+
+// FleetSLAResponse summarizes SLA results across the caller's active devices.
+type FleetSLAResponse struct {
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	Target         float64       `json:"target"`
+	AchievedUptime float64       `json:"achieved_uptime"` // time-weighted across devices
+	Passing        int           `json:"passing"`
+	Failing        int           `json:"failing"`
+	NoData         int           `json:"no_data"`
+	Devices        []SLAResponse `json:"devices"` // worst uptime first, one page at a time
+	NextCursor     string        `json:"next_cursor,omitempty"`
+}
+
+// slaQuery holds parsed SLA parameters.
+type slaQuery struct {
+	target   float64
+	from, to time.Time
+}
+
+// parseWindow parses a Go duration, also accepting whole days such as "30d".
+func parseWindow(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// parseSLAQuery reads target and window, returning a message on invalid input.
+func parseSLAQuery(r *http.Request, now time.Time) (slaQuery, string) {
+	q := r.URL.Query()
+
+	query := slaQuery{target: defaultSLATarget}
+	if v := q.Get("target"); v != "" {
+		target, err := strconv.ParseFloat(v, 64)
+		if err != nil || target <= 0 || target > 100 {
+			return query, "target must be a percentage between 0 and 100"
+		}
+		query.target = target
+	}
+
+	window := defaultSLAWindow
+	if v := q.Get("window"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d <= 0 || d%historyBucketSize != 0 || d > maxSLAWindow {
+			return query, fmt.Sprintf("window must be a whole number of hours up to %v (e.g. 24h or 30d)", maxSLAWindow)
+		}
+		window = d
+	}
+
+	query.to = now.UTC().Truncate(historyBucketSize)
+	query.from = query.to.Add(-window)
+	return query, ""
+}
+
+// deviceSLA computes a device's SLA from its history. Hours before its first
+// heartbeat aren't counted, so newly installed devices aren't penalized, and
+// maintenance windows are left out of both uptime and downtime. It returns
+// false if the device has no heartbeats in the window, or the whole window
+// was maintenance.
+func (s *Server) deviceSLA(ctx context.Context, device DeviceStats, query slaQuery) (SLAResponse, bool, error) {
+	from := query.from
+	if first := device.FirstHeartbeat.UTC().Truncate(historyBucketSize); from.Before(first) {
+		from = first
+	}
+	if device.FirstHeartbeat.IsZero() || !from.Before(query.to) {
+		return SLAResponse{}, false, nil
+	}
+
+	buckets, interval, err := s.store.History(ctx, device.ID, from, query.to)
+	if err != nil {
+		return SLAResponse{}, false, err
+	}
+	counts := make(map[time.Time]int64, len(buckets))
+	for _, b := range buckets {
+		counts[b.Start] = observedHeartbeats(b.HeartbeatCount, b.coveredMinutes(), interval)
+	}
+
+	// Each hour expects hour/interval heartbeats, less any share of the hour
+	// under maintenance; missing ones are downtime
+	expected := float64(historyBucketSize) / float64(interval)
+	var downtime, maintenance time.Duration
+	for start := from; start.Before(query.to); start = start.Add(historyBucketSize) {
+		excused := device.maintenance.overlap(start, start.Add(historyBucketSize))
+		hourExpected := expected * float64(historyBucketSize-excused) / float64(historyBucketSize)
+		missing := max(hourExpected-float64(counts[start]), 0)
+		downtime += time.Duration(missing / expected * float64(historyBucketSize))
+		maintenance += excused
+	}
+
+	span := query.to.Sub(from) - maintenance
+	if span <= 0 {
+		return SLAResponse{}, false, nil
+	}
+	allowed := time.Duration(float64(span) * (100 - query.target) / 100)
+	resp := SLAResponse{
+		DeviceID:               device.ID,
+		From:                   from,
+		To:                     query.to,
+		Target:                 query.target,
+		AchievedUptime:         roundTo(float64(span-downtime)/float64(span)*100, 3),
+		DowntimeMinutes:        roundTo(downtime.Minutes(), 1),
+		AllowedDowntimeMinutes: roundTo(allowed.Minutes(), 1),
+		BreachMinutes:          roundTo(max(downtime-allowed, 0).Minutes(), 1),
+		MaintenanceMinutes:     roundTo(maintenance.Minutes(), 1),
+		downtime:               downtime,
+		measured:               span,
+	}
+	resp.Pass = resp.AchievedUptime >= query.target
+	return resp, true, nil
+}
+
+// roundTo rounds v to the given number of decimal places.
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// HandleDeviceSLA processes GET /api/v1/devices/{device_id}/sla
+func (s *Server) HandleDeviceSLA(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/sla", deviceID)
+
+	device, err := s.visibleDevice(r, deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	query, msg := parseSLAQuery(r, time.Now())
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	resp, ok, err := s.deviceSLA(r.Context(), device, query)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if !ok {
+		// No heartbeats in the window, same as GET /stats with no data
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleFleetSLA processes GET /api/v1/fleet/sla
+func (s *Server) HandleFleetSLA(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/fleet/sla")
+
+	query, msg := parseSLAQuery(r, time.Now())
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	page, msg := parsePageQuery(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	devices, err := s.fleetDevices(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	type deviceResult struct {
+		SLAResponse
+		ok  bool
+		err error
+	}
+	results := mapDevices(devices, func(device *DeviceStats) deviceResult {
+		result, ok, err := s.deviceSLA(r.Context(), *device, query)
+		return deviceResult{result, ok, err}
+	})
+
+	resp := FleetSLAResponse{From: query.from, To: query.to, Target: query.target, Devices: []SLAResponse{}}
+	var span, downtime time.Duration
+	for _, device := range results {
+		if device.err != nil {
+			writeStorageError(w, device.err)
+			return
+		}
+		if !device.ok {
+			resp.NoData++
+			continue
+		}
+		result := device.SLAResponse
+		if result.Pass {
+			resp.Passing++
+		} else {
+			resp.Failing++
+		}
+		span += result.measured
+		downtime += result.downtime
+		resp.Devices = append(resp.Devices, result)
+	}
+
+	if span > 0 {
+		resp.AchievedUptime = roundTo(float64(span-downtime)/float64(span)*100, 3)
+	}
+	// Totals cover the whole fleet; only the device list is paged. Ties keep
+	// fleetDevices' ID order, so the zero-padded uptime plus ID is a unique,
+	// ordered key
+	sort.SliceStable(resp.Devices, func(i, j int) bool {
+		return resp.Devices[i].AchievedUptime < resp.Devices[j].AchievedUptime
+	})
+	resp.Devices, resp.NextCursor = paginate(resp.Devices, func(d SLAResponse) string {
+		return fmt.Sprintf("%07.3f/%s", d.AchievedUptime, d.DeviceID)
+	}, page)
+	writeJSON(w, http.StatusOK, resp)
+}
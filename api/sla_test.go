@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordHours records one heartbeat per minute for each hour in counts,
+// starting at start, up to the given number of heartbeats per hour.
+func recordHours(s Storage, deviceID string, start time.Time, counts ...int) {
+	for h, n := range counts {
+		for m := range n {
+			s.RecordHeartbeat(context.Background(), deviceID, start.Add(time.Duration(h)*time.Hour+time.Duration(m)*time.Minute))
+		}
+	}
+}
+
+// TestDeviceSLA tests achieved uptime and breach minutes over a window
+func TestDeviceSLA(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	// Three hours ago: a full hour, a half hour, then a full hour
+	to := time.Now().UTC().Truncate(time.Hour)
+	recordHours(server.store, "device-1", to.Add(-3*time.Hour), 60, 30, 60)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/sla?target=99.5&window=30d", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp SLAResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	// Measured from the first heartbeat: 30 of 180 minutes down
+	if !resp.From.Equal(to.Add(-3 * time.Hour)) {
+		t.Errorf("expected window to start at first heartbeat, got %v", resp.From)
+	}
+	if resp.AchievedUptime != 83.333 {
+		t.Errorf("expected uptime 83.333, got %v", resp.AchievedUptime)
+	}
+	if resp.DowntimeMinutes != 30 || resp.AllowedDowntimeMinutes != 0.9 || resp.BreachMinutes != 29.1 {
+		t.Errorf("unexpected downtime figures: %+v", resp)
+	}
+	if resp.Pass {
+		t.Error("expected SLA to fail")
+	}
+}
+
+// TestDeviceSLA_NoData tests a device without heartbeats
+func TestDeviceSLA_NoData(t *testing.T) {
+	router := setupTestServer().Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/sla", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+}
+
+// TestDeviceSLA_InvalidQuery tests rejection of bad target and window values
+func TestDeviceSLA_InvalidQuery(t *testing.T) {
+	router := setupTestServer().Router()
+
+	for _, query := range []string{"target=0", "target=101", "target=abc", "window=31d", "window=90m", "window=-1d"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/sla?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+// TestFleetSLA tests pass/fail counts and worst-first ordering across the fleet
+func TestFleetSLA(t *testing.T) {
+	server := setupTestServer()
+	server.backend().(*Store).devices["device-3"] = &DeviceStats{ID: "device-3"}
+	router := server.Router()
+
+	to := time.Now().UTC().Truncate(time.Hour)
+	recordHours(server.store, "device-1", to.Add(-2*time.Hour), 60, 60)
+	recordHours(server.store, "device-2", to.Add(-2*time.Hour), 60, 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/fleet/sla?target=99&window=24h", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var resp FleetSLAResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp.Passing != 1 || resp.Failing != 1 || resp.NoData != 1 {
+		t.Errorf("expected 1 passing, 1 failing, 1 without data, got %+v", resp)
+	}
+	if resp.AchievedUptime != 75 {
+		t.Errorf("expected fleet uptime 75, got %v", resp.AchievedUptime)
+	}
+	if len(resp.Devices) != 2 || resp.Devices[0].DeviceID != "device-2" {
+		t.Errorf("expected device-2 listed first, got %+v", resp.Devices)
+	}
+}
+
+// TestParseWindow tests day suffixes alongside Go durations
+func TestParseWindow(t *testing.T) {
+	tests := map[string]time.Duration{"30d": 720 * time.Hour, "1d": 24 * time.Hour, "12h": 12 * time.Hour}
+	for input, expected := range tests {
+		if got, err := parseWindow(input); err != nil || got != expected {
+			t.Errorf("parseWindow(%q) = %v, %v; expected %v", input, got, err, expected)
+		}
+	}
+	if _, err := parseWindow("xd"); err == nil {
+		t.Error("expected error for invalid day count")
+	}
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// snapshotFormatVersion is bumped whenever the snapshot layout changes
+// incompatibly, so an old binary refuses a newer file instead of misreading it.
+const snapshotFormatVersion = 1
+
+// storeSnapshot is the on-disk representation of the store. Dead letters
+// are the server's, and written by its Snapshotter.
+type storeSnapshot struct {
+	Version int           `json:"version"`
+	TakenAt time.Time     `json:"taken_at"`
+	Devices []DeviceStats `json:"devices"`
+	Groups  []Group       `json:"groups,omitempty"`
+
+	DeadLetters []DeadLetter        `json:"dead_letters,omitempty"`
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+// Snapshot writes every device's aggregates to w as JSON.
+func (s *Store) Snapshot(w io.Writer) error {
+	state, err := exportState(context.Background(), s)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(storeSnapshot{
+		Version:     snapshotFormatVersion,
+		TakenAt:     time.Now().UTC(),
+		Devices:     state.Devices,
+		Groups:      state.Groups,
+		Maintenance: state.Maintenance,
+	})
+}
+
+// exportState collects everything a snapshot or migration copies out of
+// store.
+func exportState(ctx context.Context, store Storage) (StorageState, error) {
+	var state StorageState
+	var err error
+	if state.Devices, err = store.ListDevices(ctx); err != nil {
+		return StorageState{}, err
+	}
+	if state.Groups, err = store.ListGroups(ctx, ""); err != nil {
+		return StorageState{}, err
+	}
+	if state.Maintenance, err = store.ListMaintenance(ctx, "", ""); err != nil {
+		return StorageState{}, err
+	}
+	return state, nil
+}
+
+// Restore loads aggregates previously written by Snapshot.
+// Only devices already registered in the store are restored, so the device
+// CSV stays the source of truth for which devices exist and which org owns
+// them. Heartbeat and upload intervals configured in the CSV also win over
+// the snapshot's. Device groups are restored too.
+func (s *Store) Restore(r io.Reader) error {
+	var snap storeSnapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version != snapshotFormatVersion {
+		return fmt.Errorf("unsupported snapshot version %d (want %d)", snap.Version, snapshotFormatVersion)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saved := range snap.Devices {
+		device, exists := s.devices[saved.ID]
+		if !exists {
+			continue
+		}
+		restored := saved
+		restored.Org = device.Org
+		restored.Facility = device.Facility
+		restored.Room = device.Room
+		restored.AlertAfter = device.AlertAfter
+		restored.location = device.location
+		restored.signingKey = device.signingKey
+		restored.token = device.token
+		restored.source = device.source
+		if !device.ActivatedAt.IsZero() {
+			restored.ActivatedAt = device.ActivatedAt
+		}
+		if device.HeartbeatInterval > 0 {
+			restored.HeartbeatInterval = device.HeartbeatInterval
+		}
+		if device.UploadInterval > 0 {
+			restored.UploadInterval = device.UploadInterval
+		}
+		// Snapshots from before minute coverage only have the heartbeat count
+		if restored.CoveredMinutes == 0 {
+			restored.CoveredMinutes = restored.HeartbeatCount
+		}
+		*device = restored
+	}
+
+	// Groups are restored with members missing from the registry dropped
+	for _, group := range snap.Groups {
+		group.DeviceIDs = slices.DeleteFunc(group.DeviceIDs, func(id string) bool {
+			_, exists := s.devices[id]
+			return !exists
+		})
+		s.groups[groupKey{group.Org, group.Name}] = normalizeGroup(group)
+	}
+
+	// Maintenance windows for devices no longer registered are dropped
+	s.maintenance = slices.DeleteFunc(snap.Maintenance, func(w MaintenanceWindow) bool {
+		_, exists := s.devices[w.DeviceID]
+		return w.DeviceID != "" && !exists
+	})
+	for _, w := range s.maintenance {
+		s.nextMaintenanceID = max(s.nextMaintenanceID, w.ID)
+	}
+	return nil
+}
+
+// Snapshotter returns a Snapshotter that saves the server's dead letters in
+// store's snapshots and restores them from it, and drops cached stats on
+// restore.
+func (s *Server) Snapshotter(store Snapshotter) Snapshotter {
+	return &serverSnapshotter{server: s, store: store}
+}
+
+// serverSnapshotter adds the server's state to a backend's snapshots.
+type serverSnapshotter struct {
+	server *Server
+	store  Snapshotter
+}
+
+// Snapshot writes store's snapshot with the dead letters added. Only the
+// top level is decoded, so the devices are copied through as written.
+func (ss *serverSnapshotter) Snapshot(w io.Writer) error {
+	var buf bytes.Buffer
+	if err := ss.store.Snapshot(&buf); err != nil {
+		return err
+	}
+	var snap map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &snap); err != nil {
+		return fmt.Errorf("decode store snapshot: %w", err)
+	}
+	if entries, _ := ss.server.deadLetters.list("", ""); len(entries) > 0 {
+		encoded, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		snap["dead_letters"] = encoded
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// Restore restores store, then the dead letters. Dead letters are kept even
+// for unknown devices, since those are exactly the payloads worth replaying
+// once the device is registered.
+func (ss *serverSnapshotter) Restore(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	defer ss.server.stats.invalidate()
+	if err := ss.store.Restore(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	var snap struct {
+		DeadLetters []DeadLetter `json:"dead_letters"`
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	ss.server.deadLetters.restore(snap.DeadLetters)
+	return nil
+}
+
+// SaveSnapshotFile writes a snapshot to path atomically, so a crash mid-write
+// never leaves a truncated snapshot behind.
+func SaveSnapshotFile(store Snapshotter, path string) error {
+	return writeFileAtomic(path, store.Snapshot)
+}
+
+// writeFileAtomic writes a file via a temporary file that is renamed over
+// path, so readers see either the old contents or the new, never a mix.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // no-op after a successful rename
+
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshotFile restores the store from a snapshot file.
+func LoadSnapshotFile(store Snapshotter, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", path, err)
+		}
+	}()
+	return store.Restore(file)
+}
+
+// RunPeriodicSnapshots writes a snapshot every interval until ctx is cancelled.
+func RunPeriodicSnapshots(ctx context.Context, store Snapshotter, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := SaveSnapshotFile(store, path); err != nil {
+				log.Printf("[ERROR] Periodic snapshot to %s failed: %v", path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	src := NewStore()
+	src.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	src.devices["device-2"] = &DeviceStats{ID: "device-2"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	src.RecordHeartbeat(t.Context(), "device-1", t1)
+	src.RecordHeartbeat(t.Context(), "device-1", t1.Add(time.Minute))
+	src.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+	src.SetVersions(t.Context(), "device-2", "1.0.0", "")
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Restore into a registry where device-2 was removed from the CSV
+	dst := NewStore()
+	dst.devices["device-1"] = &DeviceStats{ID: "device-1", Org: "org-a"}
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	device := dst.devices["device-1"]
+	if device.HeartbeatCount != 2 || !device.LastHeartbeat.Equal(t1.Add(time.Minute)) {
+		t.Errorf("heartbeat aggregates not restored: %+v", device)
+	}
+	if device.UploadCount != 1 || device.UploadTimeSum != 5*time.Second {
+		t.Errorf("upload aggregates not restored: %+v", device)
+	}
+	if device.Org != "org-a" {
+		t.Errorf("org should come from the registry, got %q", device.Org)
+	}
+	if deviceExists(t, dst, "device-2") {
+		t.Error("devices missing from the registry should not be restored")
+	}
+}
+
+func TestRestore_UnsupportedVersion(t *testing.T) {
+	s := NewStore()
+	if err := s.Restore(strings.NewReader(`{"version": 99, "devices": []}`)); err == nil {
+		t.Error("expected error for unsupported snapshot version")
+	}
+}
+
+func TestSaveAndLoadSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	src := NewStore()
+	src.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	src.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+	if err := SaveSnapshotFile(src, path); err != nil {
+		t.Fatalf("SaveSnapshotFile failed: %v", err)
+	}
+
+	// Only the snapshot itself should remain, no temp files
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the snapshot file, got %d entries", len(entries))
+	}
+
+	dst := NewStore()
+	dst.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	if err := LoadSnapshotFile(dst, path); err != nil {
+		t.Fatalf("LoadSnapshotFile failed: %v", err)
+	}
+	if dst.devices["device-1"].UploadCount != 1 {
+		t.Error("upload count not restored from file")
+	}
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal read-only SNMPv2c agent so NMS tools that only speak SNMP can poll
+// device health. Only GetRequest and GetNextRequest are supported, which is
+// enough for snmpget and snmpwalk.
+//
+// MIB layout under the base OID (default 1.3.6.1.4.1.99999.1):
+//
+//	base.1.1.<col>.<n>  device table, n = 1..devices sorted by ID
+//	    col 1 deviceId            OCTET STRING
+//	    col 2 uptimeHundredths    INTEGER   (9958 = 99.58%)
+//	    col 3 lastHeartbeatAgeSec INTEGER   (-1 = never)
+//	    col 4 avgUploadMs         Gauge32
+//	    col 5 org                 OCTET STRING
+//	base.2.1.<col>.<n>  facility (org) table, n = 1..orgs sorted by name
+//	    col 1 name                OCTET STRING
+//	    col 2 deviceCount         Gauge32
+//	    col 3 avgUptimeHundredths INTEGER   (over devices with heartbeats)
+//	    col 4 avgUploadMs         Gauge32   (over devices with uploads)
+
+// DefaultSNMPBaseOID is the subtree the agent answers for.
+const DefaultSNMPBaseOID = "1.3.6.1.4.1.99999.1"
+
+// BER tags used by SNMPv2c.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berOID         = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+
+	pduGetRequest  = 0xa0
+	pduGetNext     = 0xa1
+	pduGetResponse = 0xa2
+
+	berNoSuchObject = 0x80
+	berEndOfMibView = 0x82
+
+	snmpVersion2c = 1
+)
+
+var (
+	errSNMPMalformed   = errors.New("malformed SNMP packet")
+	errSNMPVersion     = errors.New("unsupported SNMP version")
+	errSNMPCommunity   = errors.New("unknown SNMP community")
+	errSNMPUnsupported = errors.New("unsupported SNMP PDU type")
+)
+
+// oid is an SNMP object identifier.
+type oid []uint32
+
+// parseOID parses dotted notation such as "1.3.6.1".
+func parseOID(s string) (oid, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	o := make(oid, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q: %w", s, err)
+		}
+		o[i] = uint32(n)
+	}
+	if len(o) < 2 {
+		return nil, fmt.Errorf("invalid OID %q: need at least two arcs", s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compare orders OIDs lexicographically, as SNMP walks require.
+func (o oid) compare(p oid) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		if o[i] != p[i] {
+			if o[i] < p[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(o) - len(p)
+}
+
+// child returns a new OID with arcs appended.
+func (o oid) child(arcs ...uint32) oid {
+	c := make(oid, 0, len(o)+len(arcs))
+	return append(append(c, o...), arcs...)
+}
+
+// snmpVar is one variable binding: an OID and its BER-encoded value.
+type snmpVar struct {
+	oid   oid
+	value []byte
+}
+
+// SNMPAgent answers SNMP polls from the store's current aggregates.
+type SNMPAgent struct {
+	store     Storage
+	community string
+	base      oid
+}
+
+// NewSNMPAgent creates an agent answering for the given community under baseOID.
+func NewSNMPAgent(store Storage, community, baseOID string) (*SNMPAgent, error) {
+	base, err := parseOID(baseOID)
+	if err != nil {
+		return nil, err
+	}
+	return &SNMPAgent{store: store, community: community, base: base}, nil
+}
+
+// Serve answers requests on conn until it is closed.
+func (a *SNMPAgent) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			log.Printf("[WARN] Dropped SNMP request from %s: %v", addr, err)
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			log.Printf("[ERROR] Failed to send SNMP response to %s: %v", addr, err)
+		}
+	}
+}
+
+// handle decodes one request packet and returns the encoded response.
+func (a *SNMPAgent) handle(packet []byte) ([]byte, error) {
+	msg, _, err := readTLV(packet)
+	if err != nil || msg.tag != berSequence {
+		return nil, errSNMPMalformed
+	}
+
+	version, rest, err := readTLV(msg.content)
+	if err != nil || version.tag != berInteger {
+		return nil, errSNMPMalformed
+	}
+	if v, _ := decodeInt(version.content); v != snmpVersion2c {
+		return nil, errSNMPVersion
+	}
+
+	community, rest, err := readTLV(rest)
+	if err != nil || community.tag != berOctetString {
+		return nil, errSNMPMalformed
+	}
+	if string(community.content) != a.community {
+		return nil, errSNMPCommunity
+	}
+
+	pdu, _, err := readTLV(rest)
+	if err != nil {
+		return nil, errSNMPMalformed
+	}
+	if pdu.tag != pduGetRequest && pdu.tag != pduGetNext {
+		return nil, errSNMPUnsupported
+	}
+
+	requestID, rest, err := readTLV(pdu.content)
+	if err != nil || requestID.tag != berInteger {
+		return nil, errSNMPMalformed
+	}
+	// Skip error-status and error-index, which are zero in requests
+	if _, rest, err = readTLV(rest); err != nil {
+		return nil, errSNMPMalformed
+	}
+	if _, rest, err = readTLV(rest); err != nil {
+		return nil, errSNMPMalformed
+	}
+	bindings, _, err := readTLV(rest)
+	if err != nil || bindings.tag != berSequence {
+		return nil, errSNMPMalformed
+	}
+
+	mib, err := a.mib(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	for rest := bindings.content; len(rest) > 0; {
+		var binding tlv
+		if binding, rest, err = readTLV(rest); err != nil || binding.tag != berSequence {
+			return nil, errSNMPMalformed
+		}
+		name, _, err := readTLV(binding.content)
+		if err != nil || name.tag != berOID {
+			return nil, errSNMPMalformed
+		}
+		requested, err := decodeOID(name.content)
+		if err != nil {
+			return nil, errSNMPMalformed
+		}
+
+		var v snmpVar
+		if pdu.tag == pduGetRequest {
+			v = lookupVar(mib, requested)
+		} else {
+			v = nextVar(mib, requested)
+		}
+		out = append(out, encodeTLV(berSequence, append(encodeTLV(berOID, encodeOID(v.oid)), v.value...))...)
+	}
+
+	var body []byte
+	body = append(body, encodeTLV(berInteger, requestID.content)...)
+	body = append(body, encodeTLV(berInteger, encodeInt(0))...) // error-status
+	body = append(body, encodeTLV(berInteger, encodeInt(0))...) // error-index
+	body = append(body, encodeTLV(berSequence, out)...)
+
+	var resp []byte
+	resp = append(resp, encodeTLV(berInteger, encodeInt(snmpVersion2c))...)
+	resp = append(resp, encodeTLV(berOctetString, community.content)...)
+	resp = append(resp, encodeTLV(pduGetResponse, body)...)
+	return encodeTLV(berSequence, resp), nil
+}
+
+// lookupVar returns the exact match for requested, or noSuchObject.
+func lookupVar(mib []snmpVar, requested oid) snmpVar {
+	i := sort.Search(len(mib), func(i int) bool { return mib[i].oid.compare(requested) >= 0 })
+	if i < len(mib) && mib[i].oid.compare(requested) == 0 {
+		return mib[i]
+	}
+	return snmpVar{oid: requested, value: encodeTLV(berNoSuchObject, nil)}
+}
+
+// nextVar returns the first variable after requested, or endOfMibView.
+func nextVar(mib []snmpVar, requested oid) snmpVar {
+	i := sort.Search(len(mib), func(i int) bool { return mib[i].oid.compare(requested) > 0 })
+	if i < len(mib) {
+		return mib[i]
+	}
+	return snmpVar{oid: requested, value: encodeTLV(berEndOfMibView, nil)}
+}
+
+// mib builds the sorted variable list from a snapshot of the store.
+func (a *SNMPAgent) mib(ctx context.Context) ([]snmpVar, error) {
+	devices, err := a.store.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	type orgTotals struct {
+		devices, withHeartbeats, withUploads int
+		uptimeSum                            float64
+		uploadSum                            time.Duration
+	}
+	orgs := make(map[string]*orgTotals)
+
+	deviceTable := a.base.child(1, 1)
+	var vars []snmpVar
+	allStats := mapDevices(devices, (*DeviceStats).Stats)
+	for n, device := range devices {
+		idx := uint32(n + 1)
+		stats := allStats[n]
+
+		age := int64(-1)
+		if stats.HasHeartbeats {
+			age = int64(now.Sub(device.LastHeartbeat).Seconds())
+		}
+
+		vars = append(vars,
+			snmpVar{deviceTable.child(1, idx), encodeTLV(berOctetString, []byte(device.ID))},
+			snmpVar{deviceTable.child(2, idx), encodeTLV(berInteger, encodeInt(int64(stats.Uptime*100)))},
+			snmpVar{deviceTable.child(3, idx), encodeTLV(berInteger, encodeInt(age))},
+			snmpVar{deviceTable.child(4, idx), encodeTLV(berGauge32, encodeInt(stats.AvgUploadTime.Milliseconds()))},
+			snmpVar{deviceTable.child(5, idx), encodeTLV(berOctetString, []byte(device.Org))},
+		)
+
+		totals, ok := orgs[device.Org]
+		if !ok {
+			totals = &orgTotals{}
+			orgs[device.Org] = totals
+		}
+		totals.devices++
+		if stats.HasHeartbeats {
+			totals.withHeartbeats++
+			totals.uptimeSum += stats.Uptime
+		}
+		if stats.HasUploads {
+			totals.withUploads++
+			totals.uploadSum += stats.AvgUploadTime
+		}
+	}
+
+	names := make([]string, 0, len(orgs))
+	for name := range orgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	orgTable := a.base.child(2, 1)
+	for n, name := range names {
+		idx := uint32(n + 1)
+		totals := orgs[name]
+
+		var avgUptime float64
+		if totals.withHeartbeats > 0 {
+			avgUptime = totals.uptimeSum / float64(totals.withHeartbeats)
+		}
+		var avgUpload time.Duration
+		if totals.withUploads > 0 {
+			avgUpload = totals.uploadSum / time.Duration(totals.withUploads)
+		}
+
+		vars = append(vars,
+			snmpVar{orgTable.child(1, idx), encodeTLV(berOctetString, []byte(name))},
+			snmpVar{orgTable.child(2, idx), encodeTLV(berGauge32, encodeInt(int64(totals.devices)))},
+			snmpVar{orgTable.child(3, idx), encodeTLV(berInteger, encodeInt(int64(avgUptime*100)))},
+			snmpVar{orgTable.child(4, idx), encodeTLV(berGauge32, encodeInt(avgUpload.Milliseconds()))},
+		)
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i].oid.compare(vars[j].oid) < 0 })
+	return vars, nil
+}
+
+// BER encoding and decoding
+
+// tlv is a decoded BER tag-length-value element.
+type tlv struct {
+	tag     byte
+	content []byte
+}
+
+// readTLV decodes one element from b and returns it with the remaining bytes.
+func readTLV(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errSNMPMalformed
+	}
+	tag, length, offset := b[0], int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return tlv{}, nil, errSNMPMalformed
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b) < offset+length {
+		return tlv{}, nil, errSNMPMalformed
+	}
+	return tlv{tag: tag, content: b[offset : offset+length]}, b[offset+length:], nil
+}
+
+// encodeTLV encodes a tag, definite length, and content.
+func encodeTLV(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// decodeInt decodes a big-endian two's complement integer.
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errSNMPMalformed
+	}
+	v := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// encodeInt encodes v as a minimal big-endian two's complement integer.
+func encodeInt(v int64) []byte {
+	out := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		out = append([]byte{byte(v)}, out...)
+	}
+	return out
+}
+
+// decodeOID decodes BER object identifier content.
+func decodeOID(b []byte) (oid, error) {
+	if len(b) == 0 {
+		return nil, errSNMPMalformed
+	}
+	o := oid{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var arc uint32
+	for _, c := range b[1:] {
+		arc = arc<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			o = append(o, arc)
+			arc = 0
+		}
+	}
+	return o, nil
+}
+
+// encodeOID encodes an object identifier as BER content.
+func encodeOID(o oid) []byte {
+	out := []byte{byte(o[0]*40 + o[1])}
+	for _, arc := range o[2:] {
+		chunk := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7f) | 0x80}, chunk...)
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
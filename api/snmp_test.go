@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Helper to build an SNMPv2c request packet for a single OID
+func buildSNMPRequest(pduType byte, community string, requested oid) []byte {
+	binding := encodeTLV(berSequence, append(encodeTLV(berOID, encodeOID(requested)), 0x05, 0x00))
+
+	var body []byte
+	body = append(body, encodeTLV(berInteger, encodeInt(42))...)
+	body = append(body, encodeTLV(berInteger, encodeInt(0))...)
+	body = append(body, encodeTLV(berInteger, encodeInt(0))...)
+	body = append(body, encodeTLV(berSequence, binding)...)
+
+	var msg []byte
+	msg = append(msg, encodeTLV(berInteger, encodeInt(snmpVersion2c))...)
+	msg = append(msg, encodeTLV(berOctetString, []byte(community))...)
+	msg = append(msg, encodeTLV(pduType, body)...)
+	return encodeTLV(berSequence, msg)
+}
+
+// Helper to extract the single variable binding from a response packet
+func parseSNMPResponse(t *testing.T, packet []byte) (oid, tlv) {
+	t.Helper()
+	msg, _, _ := readTLV(packet)
+	_, rest, _ := readTLV(msg.content) // version
+	_, rest, _ = readTLV(rest)         // community
+	pdu, _, _ := readTLV(rest)
+	if pdu.tag != pduGetResponse {
+		t.Fatalf("expected GetResponse PDU, got 0x%x", pdu.tag)
+	}
+	_, rest, _ = readTLV(pdu.content) // request-id
+	_, rest, _ = readTLV(rest)        // error-status
+	_, rest, _ = readTLV(rest)        // error-index
+	bindings, _, _ := readTLV(rest)
+	binding, _, _ := readTLV(bindings.content)
+	name, rest, _ := readTLV(binding.content)
+	value, _, _ := readTLV(rest)
+	o, err := decodeOID(name.content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, value
+}
+
+func setupSNMPAgent(t *testing.T) *SNMPAgent {
+	t.Helper()
+	store := NewStore()
+	store.devices["device-1"] = &DeviceStats{ID: "device-1", Org: "org-a"}
+	store.devices["device-2"] = &DeviceStats{ID: "device-2", Org: "org-a"}
+	store.RecordHeartbeat(t.Context(), "device-1", time.Now())
+	store.RecordUploadStat(t.Context(), "device-1", 1500*time.Millisecond)
+
+	agent, err := NewSNMPAgent(store, "public", DefaultSNMPBaseOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestSNMPAgent_Get(t *testing.T) {
+	agent := setupSNMPAgent(t)
+
+	tests := []struct {
+		requested oid
+		tag       byte
+		expected  int64
+	}{
+		{agent.base.child(1, 1, 2, 1), berInteger, 10000}, // device-1 uptime 100.00%
+		{agent.base.child(1, 1, 3, 2), berInteger, -1},    // device-2 never heartbeated
+		{agent.base.child(1, 1, 4, 1), berGauge32, 1500},  // device-1 avg upload ms
+		{agent.base.child(2, 1, 2, 1), berGauge32, 2},     // org-a device count
+	}
+
+	for _, tc := range tests {
+		resp, err := agent.handle(buildSNMPRequest(pduGetRequest, "public", tc.requested))
+		if err != nil {
+			t.Fatalf("handle failed: %v", err)
+		}
+		o, value := parseSNMPResponse(t, resp)
+		if o.compare(tc.requested) != 0 {
+			t.Errorf("expected OID %s, got %s", tc.requested, o)
+		}
+		if value.tag != tc.tag {
+			t.Errorf("%s: expected tag 0x%x, got 0x%x", tc.requested, tc.tag, value.tag)
+		}
+		if v, _ := decodeInt(value.content); v != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.requested, tc.expected, v)
+		}
+	}
+}
+
+func TestSNMPAgent_GetNoSuchObject(t *testing.T) {
+	agent := setupSNMPAgent(t)
+
+	resp, err := agent.handle(buildSNMPRequest(pduGetRequest, "public", agent.base.child(9)))
+	if err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+	_, value := parseSNMPResponse(t, resp)
+	if value.tag != berNoSuchObject {
+		t.Errorf("expected noSuchObject, got 0x%x", value.tag)
+	}
+}
+
+func TestSNMPAgent_GetNextWalk(t *testing.T) {
+	agent := setupSNMPAgent(t)
+
+	// Walking from the base must visit every variable in order, then end
+	current := agent.base
+	count := 0
+	for {
+		resp, err := agent.handle(buildSNMPRequest(pduGetNext, "public", current))
+		if err != nil {
+			t.Fatalf("handle failed: %v", err)
+		}
+		o, value := parseSNMPResponse(t, resp)
+		if value.tag == berEndOfMibView {
+			break
+		}
+		if o.compare(current) <= 0 {
+			t.Fatalf("walk did not advance: %s after %s", o, current)
+		}
+		current = o
+		count++
+	}
+
+	// 2 devices x 5 columns + 1 org x 4 columns
+	if count != 14 {
+		t.Errorf("expected 14 variables, got %d", count)
+	}
+}
+
+func TestSNMPAgent_WrongCommunity(t *testing.T) {
+	agent := setupSNMPAgent(t)
+
+	_, err := agent.handle(buildSNMPRequest(pduGetRequest, "private", agent.base.child(1, 1, 1, 1)))
+	if !errors.Is(err, errSNMPCommunity) {
+		t.Errorf("expected errSNMPCommunity, got %v", err)
+	}
+}
+
+func TestBEREncoding(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		decoded, err := decodeInt(encodeInt(v))
+		if err != nil || decoded != v {
+			t.Errorf("int round trip %d: got %d (err %v)", v, decoded, err)
+		}
+	}
+
+	o, _ := parseOID("1.3.6.1.4.1.99999.1.200000")
+	decoded, err := decodeOID(encodeOID(o))
+	if err != nil || decoded.compare(o) != 0 {
+		t.Errorf("OID round trip %s: got %s (err %v)", o, decoded, err)
+	}
+}
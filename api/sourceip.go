@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Each device remembers the address its latest heartbeat came from, so a
+// field tech can tell which VLAN or site network a camera actually ended up
+// on, and rate limits are applied per source address. Behind a load
+// balancer or reverse proxy the connection comes from the proxy, so
+// X-Forwarded-For and X-Real-IP are honored, but only when the connection
+// comes from a trusted proxy; otherwise any client could claim any address,
+// and dodge its rate limit by claiming a new one per request.
+
+// ParseTrustedProxies parses a comma-separated list of proxy addresses and
+// CIDR ranges, e.g. "10.0.0.0/8,fd00::1".
+func ParseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range %q", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-IP
+// headers are believed. Connections over a Unix socket always are, since only local
+// processes can reach it.
+func (s *Server) SetTrustedProxies(prefixes []netip.Prefix) {
+	s.trustedProxies = prefixes
+}
+
+// trustedProxy reports whether addr is a trusted proxy.
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIP returns the address a request came from. When the connection is
+// from a trusted proxy, X-Forwarded-For is walked from the right, past any
+// other trusted proxies, to the first address the proxies didn't vouch for.
+// Without X-Forwarded-For, the proxy's X-Real-IP is used.
+func (s *Server) sourceIP(r *http.Request) string {
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	viaSocket := local != nil && local.Network() == "unix"
+
+	peer, err := netip.ParseAddr(clientIP(r))
+	if err != nil && !viaSocket {
+		return clientIP(r)
+	}
+	source := peer.Unmap()
+	trusted := viaSocket || s.trustedProxy(source)
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			hops = []string{realIP}
+		}
+	}
+	for i := len(hops) - 1; i >= 0 && trusted; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		source = hop.Unmap()
+		trusted = s.trustedProxy(source)
+	}
+	if !source.IsValid() {
+		return ""
+	}
+	return source.String()
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestParseTrustedProxies tests parsing addresses and ranges
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.7,fd00::/16,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "fd00::/16"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("expected %v, got %v", want, prefixes)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Errorf("expected %s, got %s", want[i], prefixes[i])
+		}
+	}
+
+	for _, spec := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := ParseTrustedProxies(spec); err == nil {
+			t.Errorf("expected %q refused", spec)
+		}
+	}
+}
+
+// TestSourceIP tests which address is believed with and without trusted proxies
+func TestSourceIP(t *testing.T) {
+	server := setupTestServer()
+	proxies, _ := ParseTrustedProxies("10.0.0.0/8,fd00::1")
+	server.SetTrustedProxies(proxies)
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "192.168.40.12:5000", nil, "192.168.40.12"},
+		{"spoofed by untrusted peer", "192.168.40.12:5000", []string{"1.2.3.4"}, "192.168.40.12"},
+		{"trusted proxy", "10.0.0.5:5000", []string{"192.168.40.12"}, "192.168.40.12"},
+		{"chain of proxies", "10.0.0.5:5000", []string{"1.2.3.4, 192.168.40.12", "10.1.1.1"}, "192.168.40.12"},
+		{"trusted proxy without header", "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"ipv6 proxy", "[fd00::1]:5000", []string{"2001:db8::12"}, "2001:db8::12"},
+		{"ipv4-mapped peer", "[::ffff:192.168.40.12]:5000", nil, "192.168.40.12"},
+		{"garbage hop", "10.0.0.5:5000", []string{"unknown"}, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = tt.peer
+			for _, header := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			if got := server.sourceIP(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// X-Real-IP stands in for X-Forwarded-For, from trusted proxies only
+	for _, tt := range []struct {
+		peer, forwarded, want string
+	}{
+		{"10.0.0.5:5000", "", "192.168.40.12"},
+		{"10.0.0.5:5000", "192.168.40.99", "192.168.40.99"},
+		{"192.168.40.50:5000", "", "192.168.40.50"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = tt.peer
+		req.Header.Set("X-Real-IP", "192.168.40.12")
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := server.sourceIP(req); got != tt.want {
+			t.Errorf("X-Real-IP from %s with X-Forwarded-For %q: expected %s, got %s", tt.peer, tt.forwarded, tt.want, got)
+		}
+	}
+
+	// A proxy on the Unix socket is trusted without being listed
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "192.168.40.12")
+	local := &net.UnixAddr{Name: "/run/safelyyou.sock", Net: "unix"}
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+	if got := server.sourceIP(req); got != "192.168.40.12" {
+		t.Errorf("expected the forwarded address over a Unix socket, got %s", got)
+	}
+}
+
+// TestHeartbeat_SourceIP tests that device detail reports where the latest heartbeat came from
+func TestHeartbeat_SourceIP(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	for i, peer := range []string{"192.168.40.12:5000", "192.168.41.30:5000"} {
+		body := fmt.Sprintf(`{"sent_at": "2024-01-15T10:0%d:00Z"}`, i)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(body))
+		req.RemoteAddr = peer
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1", nil))
+	var resp DeviceCountersResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.LastHeartbeatIP != "192.168.41.30" || resp.LastHeartbeatIPAt.IsZero() {
+		t.Errorf("expected the latest heartbeat's address, got %q at %v", resp.LastHeartbeatIP, resp.LastHeartbeatIPAt)
+	}
+
+	// Ingest comes from gateways, so it doesn't move the device
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(`{"type":"heartbeat","device_id":"device-1","sent_at":"2024-01-15T10:02:00Z"}`))
+	req.RemoteAddr = "10.9.9.9:5000"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.SourceIP != "192.168.41.30" || device.HeartbeatCount != 3 {
+		t.Errorf("expected ingest counted without changing the address, got %q after %d heartbeats", device.SourceIP, device.HeartbeatCount)
+	}
+}
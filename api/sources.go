@@ -0,0 +1,250 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A fleet's devices can be split across several CSVs, typically one per
+// facility. The -devices flag names them as a comma-separated list of files
+// and globs; they're merged into one registry, and a device listed in two
+// files is an error rather than a silent override.
+
+// ExpandDeviceSources returns the device CSVs named by spec, a
+// comma-separated list of file paths and globs. Each glob's matches are
+// sorted, files named twice are read once, and a glob matching nothing is
+// an error so a mistyped pattern doesn't load an empty fleet.
+func ExpandDeviceSources(spec string) ([]string, error) {
+	var paths []string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !isGlob(entry) {
+			paths = append(paths, entry)
+			continue
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no device files match %q", entry)
+		}
+		slices.Sort(matches)
+		paths = append(paths, matches...)
+	}
+
+	var unique []string
+	for _, path := range paths {
+		if !slices.Contains(unique, path) {
+			unique = append(unique, path)
+		}
+	}
+	return unique, nil
+}
+
+// isGlob reports whether entry contains glob metacharacters.
+func isGlob(entry string) bool {
+	return strings.ContainsAny(entry, "*?[")
+}
+
+// readDeviceSources parses every device CSV in paths, recording which file
+// each device came from. Nothing is returned unless every file parses and
+// no device ID appears in more than one of them.
+func readDeviceSources(paths []string) ([]DeviceStats, error) {
+	var devices []DeviceStats
+	sources := make(map[string]string)
+	for _, path := range paths {
+		parsed, err := readDevicesFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range parsed {
+			if other, exists := sources[device.ID]; exists && other != path {
+				return nil, fmt.Errorf("device %s is listed in both %s and %s", device.ID, other, path)
+			}
+			sources[device.ID] = path
+			device.source = path
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// readDevicesFile parses a single device CSV, prefixing parse errors with
+// its path.
+func readDevicesFile(path string) ([]DeviceStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", path, err)
+		}
+	}()
+	devices, err := parseDevicesCSV(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return devices, nil
+}
+
+// maxDeviceCSVErrors caps how many bad rows a parse reports, so a file in the
+// wrong format doesn't produce an error for every line.
+const maxDeviceCSVErrors = 20
+
+// parseDevicesCSV reads the device CSV format described on LoadDevicesFromCSV
+// a row at a time, so a fleet of hundreds of thousands of devices is never
+// held in memory as raw records. Rows with the wrong number of fields, no
+// device_id, a device_id listed earlier in the file or an invalid column are
+// all reported with their line numbers (up to maxDeviceCSVErrors); any of
+// them fails the parse.
+func parseDevicesCSV(r io.Reader) ([]DeviceStats, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	intervalCol := columnIndex(header, "heartbeat_interval")
+	orgCol := columnIndex(header, "org")
+	facilityCol := columnIndex(header, "facility")
+	roomCol := columnIndex(header, "room")
+	alertCol := columnIndex(header, "alert_after")
+	uploadCol := columnIndex(header, "upload_interval")
+	tzCol := columnIndex(header, "timezone")
+	secretCol := columnIndex(header, "signing_secret")
+	tokenCol := columnIndex(header, "token")
+	activatedCol := columnIndex(header, "activated_at")
+
+	var devices []DeviceStats
+	var errs []error
+	firstLine := make(map[string]int)
+	// Thousands of devices share a handful of orgs, facilities and
+	// timezones, and loading a timezone reads the zone database
+	orgs := make(map[string]string)
+	facilities := make(map[string]string)
+	locations := make(map[string]*time.Location)
+	for {
+		if len(errs) == maxDeviceCSVErrors {
+			errs = append(errs, errors.New("too many errors, stopped parsing"))
+			break
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only a wrong field count leaves the reader able to continue
+			errs = append(errs, err)
+			if errors.Is(err, csv.ErrFieldCount) {
+				continue
+			}
+			break
+		}
+		line, _ := reader.FieldPos(0)
+
+		id := record[0]
+		if id == "" {
+			errs = append(errs, fmt.Errorf("line %d: missing device_id", line))
+			continue
+		}
+		if first, exists := firstLine[id]; exists {
+			errs = append(errs, fmt.Errorf("line %d: duplicate device_id %q (first listed on line %d)", line, id, first))
+			continue
+		}
+		firstLine[id] = line
+
+		// Cloned so the device doesn't keep the whole row's memory alive
+		device := DeviceStats{ID: strings.Clone(id)}
+		if orgCol >= 0 && record[orgCol] != "" {
+			org, seen := orgs[record[orgCol]]
+			if !seen {
+				org = strings.Clone(record[orgCol])
+				orgs[org] = org
+			}
+			device.Org = org
+		}
+		if facilityCol >= 0 && record[facilityCol] != "" {
+			facility, seen := facilities[record[facilityCol]]
+			if !seen {
+				facility = strings.Clone(record[facilityCol])
+				facilities[facility] = facility
+			}
+			device.Facility = facility
+		}
+		if roomCol >= 0 {
+			device.Room = strings.Clone(record[roomCol])
+		}
+		if intervalCol >= 0 && record[intervalCol] != "" {
+			interval, err := time.ParseDuration(record[intervalCol])
+			if err != nil || interval <= 0 {
+				errs = append(errs, fmt.Errorf("line %d: invalid heartbeat_interval %q", line, record[intervalCol]))
+				continue
+			}
+			device.HeartbeatInterval = interval
+		}
+		if alertCol >= 0 && record[alertCol] != "" {
+			alertAfter, err := time.ParseDuration(record[alertCol])
+			if err != nil || alertAfter <= 0 {
+				errs = append(errs, fmt.Errorf("line %d: invalid alert_after %q", line, record[alertCol]))
+				continue
+			}
+			device.AlertAfter = alertAfter
+		}
+		if uploadCol >= 0 && record[uploadCol] != "" {
+			interval, err := time.ParseDuration(record[uploadCol])
+			if err != nil || interval <= 0 {
+				errs = append(errs, fmt.Errorf("line %d: invalid upload_interval %q", line, record[uploadCol]))
+				continue
+			}
+			device.UploadInterval = interval
+		}
+		if tzCol >= 0 && record[tzCol] != "" {
+			loc, seen := locations[record[tzCol]]
+			if !seen {
+				loc, err = time.LoadLocation(record[tzCol])
+				if err != nil {
+					errs = append(errs, fmt.Errorf("line %d: invalid timezone %q", line, record[tzCol]))
+					continue
+				}
+				locations[strings.Clone(record[tzCol])] = loc
+			}
+			device.location = loc
+		}
+		if activatedCol >= 0 && record[activatedCol] != "" {
+			activatedAt, err := time.Parse(time.RFC3339, record[activatedCol])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: invalid activated_at %q", line, record[activatedCol]))
+				continue
+			}
+			device.ActivatedAt = activatedAt.UTC()
+		}
+		if secretCol >= 0 && record[secretCol] != "" {
+			device.signingKey = []byte(record[secretCol])
+		}
+		if tokenCol >= 0 && record[tokenCol] != "" {
+			device.token = strings.Clone(record[tokenCol])
+		}
+		devices = append(devices, device)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return devices, nil
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestExpandDeviceSources tests expanding files and globs into device CSV paths
+func TestExpandDeviceSources(t *testing.T) {
+	dir := t.TempDir()
+	lobby := writeDevicesFile(t, dir, "lobby.csv", "device_id\n")
+	annex := writeDevicesFile(t, dir, "annex.csv", "device_id\n")
+	extra := filepath.Join(dir, "extra.txt")
+
+	paths, err := ExpandDeviceSources(extra + ", " + filepath.Join(dir, "*.csv") + "," + lobby)
+	if err != nil {
+		t.Fatalf("ExpandDeviceSources failed: %v", err)
+	}
+	if want := []string{extra, annex, lobby}; !slices.Equal(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	if _, err := ExpandDeviceSources(filepath.Join(dir, "*.json")); err == nil {
+		t.Error("expected an error for a glob matching nothing")
+	}
+}
+
+// TestLoadDevicesFromCSV_MultipleFiles tests merging facility files and rejecting duplicate IDs
+func TestLoadDevicesFromCSV_MultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	lobby := writeDevicesFile(t, dir, "lobby.csv", "device_id\ncam-1\ncam-2\n")
+	annex := writeDevicesFile(t, dir, "annex.csv", "device_id,org\ncam-3,acme\n")
+	clash := writeDevicesFile(t, dir, "clash.csv", "device_id\ncam-2\n")
+
+	s := NewStore()
+	if err := s.LoadDevicesFromCSV(t.Context(), lobby, annex); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	if deviceCount(t, s) != 3 {
+		t.Fatalf("expected 3 devices, got %d", deviceCount(t, s))
+	}
+	if device, _ := s.Device(t.Context(), "cam-3"); device.source != annex || device.Org != "acme" {
+		t.Errorf("expected cam-3 from %s, got %+v", annex, device)
+	}
+
+	s = NewStore()
+	err := s.LoadDevicesFromCSV(t.Context(), lobby, clash)
+	if err == nil || !strings.Contains(err.Error(), lobby) || !strings.Contains(err.Error(), clash) {
+		t.Errorf("expected a conflict naming both files, got %v", err)
+	}
+	if deviceCount(t, s) != 0 {
+		t.Errorf("expected nothing loaded after a conflict, got %d devices", deviceCount(t, s))
+	}
+}
+
+// TestReload_Glob tests that a reload picks up new files matching a glob
+func TestReload_Glob(t *testing.T) {
+	dir := t.TempDir()
+	writeDevicesFile(t, dir, "lobby.csv", "device_id\ndevice-1\n")
+	spec := filepath.Join(dir, "*.csv")
+
+	server := setupTestServer()
+	server.SetDeviceSources(spec, nil)
+	router := server.Router()
+	reload := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload"+query, nil))
+		return rr
+	}
+
+	writeDevicesFile(t, dir, "annex.csv", "device_id\ndevice-3\n")
+	rr := reload("")
+	var resp ReloadResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || !slices.Equal(resp.Files, []string{"annex.csv", "lobby.csv"}) || resp.Devices != 2 {
+		t.Fatalf("unexpected reload %d %+v", rr.Code, resp)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil))
+	var list DeviceListResponse
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	for _, device := range list.Devices {
+		if filepath.Dir(device.Source) != dir {
+			t.Errorf("expected %s to report its source file, got %q", device.ID, device.Source)
+		}
+	}
+
+	if rr := reload("?file=lobby.csv"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 swapping a file into a glob, got %d", rr.Code)
+	}
+
+	writeDevicesFile(t, dir, "clash.csv", "device_id\ndevice-1\n")
+	if rr := reload(""); rr.Code != http.StatusUnprocessableEntity || !deviceExists(t, server.store, "device-3") {
+		t.Errorf("expected 422 and registry untouched on a duplicate, got %d", rr.Code)
+	}
+}
+
+// TestParseDevicesCSV_Errors tests that every bad row is reported with its line number
+func TestParseDevicesCSV_Errors(t *testing.T) {
+	content := "device_id,org,heartbeat_interval\n" +
+		"cam-1,acme,30s\n" +
+		"cam-2,acme\n" +
+		",acme,30s\n" +
+		"cam-1,acme,1m\n" +
+		"cam-3,acme,soon\n" +
+		"cam-4,acme,1m\n"
+	_, err := parseDevicesCSV(strings.NewReader(content))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"line 3: wrong number of fields",
+		"line 4: missing device_id",
+		`line 5: duplicate device_id "cam-1" (first listed on line 2)`,
+		`line 6: invalid heartbeat_interval "soon"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
+
+// TestParseDevicesCSV_TooManyErrors tests that reporting stops after maxDeviceCSVErrors
+func TestParseDevicesCSV_TooManyErrors(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("device_id\n")
+	for range 100 {
+		b.WriteString("cam-1\n")
+	}
+	_, err := parseDevicesCSV(strings.NewReader(b.String()))
+	if err == nil || strings.Count(err.Error(), "\n") != maxDeviceCSVErrors || !strings.Contains(err.Error(), "too many errors") {
+		t.Errorf("expected %d errors and a cutoff, got %v", maxDeviceCSVErrors, err)
+	}
+}
+
+// TestParseDevicesCSV_Large tests loading a fleet of hundreds of thousands of devices
+func TestParseDevicesCSV_Large(t *testing.T) {
+	const n = 300_000
+	var b strings.Builder
+	b.WriteString("device_id,org,timezone\n")
+	for i := range n {
+		fmt.Fprintf(&b, "cam-%06d,org-%d,America/Denver\n", i, i%10)
+	}
+	devices, err := parseDevicesCSV(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(devices) != n || devices[n-1].ID != "cam-299999" || devices[n-1].Location().String() != "America/Denver" {
+		t.Errorf("unexpected result: %d devices, last %+v", len(devices), devices[len(devices)-1])
+	}
+	if devices[0].location != devices[1].location {
+		t.Error("expected devices to share the loaded timezone")
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Dashboards poll stats once a second for thousands of devices, while most
+// devices report far less often. The computed stats are cached per device
+// and dropped whenever the device changes, so a poll between two heartbeats
+// is answered from the cache without taking the store's lock.
+
+// statsEntry is one device's cached stats. The trend is only good for the
+// history bucket it was computed in.
+type statsEntry struct {
+	device DeviceStats
+	result StatsResult
+	trend  statsTrend
+	bucket time.Time
+	gen    uint64 // bumped by every invalidation of the device
+	valid  bool
+}
+
+// statsToken identifies the cache state a miss was computed against, so a
+// write that lands while the stats are computed keeps them out of the cache.
+type statsToken struct {
+	epoch uint64
+	gen   uint64
+}
+
+// statsCache holds computed stats per device. It has its own lock so polls
+// never contend with telemetry writes.
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsEntry // protected by mu
+	epoch   uint64                // protected by mu; bumped when every entry is dropped
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: make(map[string]statsEntry)}
+}
+
+// get returns the device's cached stats if they were computed in bucket.
+// On a miss it returns the token to store fresh stats under.
+func (c *statsCache) get(deviceID string, bucket time.Time) (statsEntry, statsToken, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[deviceID]
+	token := statsToken{epoch: c.epoch, gen: entry.gen}
+	if !entry.valid || !entry.bucket.Equal(bucket) {
+		return statsEntry{}, token, false
+	}
+	return entry, token, true
+}
+
+// put caches stats computed after token was handed out, unless the device
+// changed in the meantime.
+func (c *statsCache) put(deviceID string, token statsToken, entry statsEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token.epoch != c.epoch || token.gen != c.entries[deviceID].gen {
+		return
+	}
+	entry.gen = token.gen
+	entry.valid = true
+	c.entries[deviceID] = entry
+}
+
+// invalidate drops the cached stats of the given devices, or of every
+// device when none are given. Callers invalidate once the change is
+// written, so stats read before it were computed under an older token and
+// are refused by put.
+func (c *statsCache) invalidate(deviceIDs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(deviceIDs) == 0 {
+		c.entries = make(map[string]statsEntry)
+		c.epoch++
+		return
+	}
+	for _, id := range deviceIDs {
+		c.entries[id] = statsEntry{gen: c.entries[id].gen + 1}
+	}
+}
+
+// cachedStorage is the server's store. Every change made through it drops
+// the cached stats of the devices it touched, or of all of them when it
+// can't tell which, once the backend has made it.
+type cachedStorage struct {
+	Storage
+	stats *statsCache
+}
+
+func (c *cachedStorage) LoadDevicesFromCSV(ctx context.Context, filenames ...string) error {
+	defer c.stats.invalidate()
+	return c.Storage.LoadDevicesFromCSV(ctx, filenames...)
+}
+
+func (c *cachedStorage) ReplaceDevices(ctx context.Context, devices []DeviceStats) (ReloadResult, error) {
+	defer c.stats.invalidate()
+	return c.Storage.ReplaceDevices(ctx, devices)
+}
+
+func (c *cachedStorage) AddDevice(ctx context.Context, device DeviceStats) error {
+	defer c.stats.invalidate(device.ID)
+	return c.Storage.AddDevice(ctx, device)
+}
+
+func (c *cachedStorage) Activate(ctx context.Context, deviceID string, at time.Time) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.Activate(ctx, deviceID, at)
+}
+
+func (c *cachedStorage) Decommission(ctx context.Context, deviceID string, at time.Time) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.Decommission(ctx, deviceID, at)
+}
+
+func (c *cachedStorage) Mute(ctx context.Context, deviceID string, until time.Time) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.Mute(ctx, deviceID, until)
+}
+
+func (c *cachedStorage) Transfer(ctx context.Context, deviceID, org string, at time.Time, aggregates string) (DeviceTransfer, error) {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.Transfer(ctx, deviceID, org, at, aggregates)
+}
+
+func (c *cachedStorage) RecordEvent(ctx context.Context, deviceID, eventType string, at time.Time, detail string) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.RecordEvent(ctx, deviceID, eventType, at, detail)
+}
+
+func (c *cachedStorage) RecordHeartbeat(ctx context.Context, deviceID string, sentAt time.Time) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.RecordHeartbeat(ctx, deviceID, sentAt)
+}
+
+func (c *cachedStorage) RecordUploadStat(ctx context.Context, deviceID string, uploadTime time.Duration) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.RecordUploadStat(ctx, deviceID, uploadTime)
+}
+
+func (c *cachedStorage) RecordUploadStatAt(ctx context.Context, deviceID string, uploadTime time.Duration, at time.Time) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.RecordUploadStatAt(ctx, deviceID, uploadTime, at)
+}
+
+func (c *cachedStorage) SetHeartbeatInterval(ctx context.Context, deviceID string, interval time.Duration) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.SetHeartbeatInterval(ctx, deviceID, interval)
+}
+
+func (c *cachedStorage) SetVersions(ctx context.Context, deviceID, firmwareVersion, agentVersion string) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.SetVersions(ctx, deviceID, firmwareVersion, agentVersion)
+}
+
+func (c *cachedStorage) SetIntervalSamples(n int) {
+	defer c.stats.invalidate()
+	c.Storage.SetIntervalSamples(n)
+}
+
+func (c *cachedStorage) Apply(ctx context.Context, events []TelemetryEvent) error {
+	err := c.Storage.Apply(ctx, events)
+	if len(events) > 0 {
+		c.stats.invalidate(eventDevices(events)...)
+	}
+	return err
+}
+
+func (c *cachedStorage) Begin(ctx context.Context) (Tx, error) {
+	tx, err := c.Storage.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTx{Tx: tx, stats: c.stats}, nil
+}
+
+func (c *cachedStorage) CreateGroup(ctx context.Context, group Group) error {
+	defer c.stats.invalidate(group.DeviceIDs...)
+	return c.Storage.CreateGroup(ctx, group)
+}
+
+func (c *cachedStorage) ReplaceGroup(ctx context.Context, group Group) error {
+	defer c.stats.invalidate()
+	return c.Storage.ReplaceGroup(ctx, group)
+}
+
+func (c *cachedStorage) DeleteGroup(ctx context.Context, org, name string) error {
+	defer c.stats.invalidate()
+	return c.Storage.DeleteGroup(ctx, org, name)
+}
+
+func (c *cachedStorage) AddGroupMember(ctx context.Context, org, name, deviceID string) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.AddGroupMember(ctx, org, name, deviceID)
+}
+
+func (c *cachedStorage) RemoveGroupMember(ctx context.Context, org, name, deviceID string) error {
+	defer c.stats.invalidate(deviceID)
+	return c.Storage.RemoveGroupMember(ctx, org, name, deviceID)
+}
+
+func (c *cachedStorage) AddMaintenance(ctx context.Context, w MaintenanceWindow) (MaintenanceWindow, error) {
+	defer c.stats.invalidate()
+	return c.Storage.AddMaintenance(ctx, w)
+}
+
+func (c *cachedStorage) DeleteMaintenance(ctx context.Context, org string, id int64) error {
+	defer c.stats.invalidate()
+	return c.Storage.DeleteMaintenance(ctx, org, id)
+}
+
+// cachedTx drops the cached stats of the devices it wrote to on commit.
+type cachedTx struct {
+	Tx
+	stats   *statsCache
+	devices []string
+}
+
+func (tx *cachedTx) Stage(events ...TelemetryEvent) {
+	tx.devices = append(tx.devices, eventDevices(events)...)
+	tx.Tx.Stage(events...)
+}
+
+func (tx *cachedTx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	if len(tx.devices) > 0 {
+		tx.stats.invalidate(tx.devices...)
+	}
+	return err
+}
+
+// eventDevices returns the device of each event.
+func eventDevices(events []TelemetryEvent) []string {
+	ids := make([]string, len(events))
+	for i, ev := range events {
+		ids[i] = ev.DeviceID
+	}
+	return ids
+}
+
+// cachedStats returns a device's aggregates, stats and trend as of now,
+// from the cache when the device hasn't changed since they were computed.
+func (s *Server) cachedStats(ctx context.Context, deviceID string, now time.Time) (DeviceStats, StatsResult, statsTrend, error) {
+	bucket := now.UTC().Truncate(historyBucketSize)
+	entry, token, ok := s.stats.get(deviceID, bucket)
+	if ok {
+		return entry.device, entry.result, entry.trend, nil
+	}
+
+	// The token was taken before reading, so a write landing meanwhile
+	// keeps these stats out of the cache
+	device, err := s.store.Device(ctx, deviceID)
+	if err != nil {
+		return DeviceStats{}, StatsResult{}, statsTrend{}, err
+	}
+	trend, err := s.statsTrend(ctx, device, now)
+	if err != nil {
+		return DeviceStats{}, StatsResult{}, statsTrend{}, err
+	}
+	entry = statsEntry{
+		device: device,
+		result: device.Stats(),
+		trend:  trend,
+		bucket: bucket,
+	}
+	s.stats.put(deviceID, token, entry)
+	return entry.device, entry.result, entry.trend, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCachedStats_Invalidation tests that cached stats are reused until the device changes
+func TestCachedStats_Invalidation(t *testing.T) {
+	server := setupTestServer()
+	store := server.backend().(*Store)
+	now := time.Now().UTC()
+	store.RecordHeartbeat(t.Context(), "device-1", now.Add(-time.Minute))
+
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now); device.HeartbeatCount != 1 {
+		t.Fatalf("expected 1 heartbeat, got %d", device.HeartbeatCount)
+	}
+
+	// Changed behind the store's back, so only the cache can answer 1
+	store.devices["device-1"].HeartbeatCount = 99
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now); device.HeartbeatCount != 1 {
+		t.Errorf("expected the cached copy, got %d heartbeats", device.HeartbeatCount)
+	}
+
+	// A new history bucket recomputes the trend
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now.Add(historyBucketSize)); device.HeartbeatCount != 99 {
+		t.Errorf("expected stats recomputed in the next bucket, got %d heartbeats", device.HeartbeatCount)
+	}
+
+	// Telemetry for another device leaves this one cached
+	store.devices["device-1"].HeartbeatCount = 1
+	server.store.RecordHeartbeat(t.Context(), "device-2", now)
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now.Add(historyBucketSize)); device.HeartbeatCount != 99 {
+		t.Errorf("expected device-1 still cached, got %d heartbeats", device.HeartbeatCount)
+	}
+
+	server.store.RecordHeartbeat(t.Context(), "device-1", now)
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now.Add(historyBucketSize)); device.HeartbeatCount != 2 {
+		t.Errorf("expected the heartbeat to invalidate, got %d heartbeats", device.HeartbeatCount)
+	}
+
+	// Registry changes drop every device
+	store.devices["device-1"].HeartbeatCount = 7
+	server.store.AddMaintenance(t.Context(), MaintenanceWindow{Start: now, End: now.Add(time.Hour)})
+	if device, _, _, _ := server.cachedStats(t.Context(), "device-1", now.Add(historyBucketSize)); device.HeartbeatCount != 7 {
+		t.Errorf("expected the maintenance window to invalidate, got %d heartbeats", device.HeartbeatCount)
+	}
+}
+
+// TestStatsCache_ConcurrentWrite tests that stats read before a write aren't cached after it
+func TestStatsCache_ConcurrentWrite(t *testing.T) {
+	cache := newStatsCache()
+	bucket := time.Now().UTC().Truncate(historyBucketSize)
+
+	_, token, _ := cache.get("device-1", bucket)
+	cache.invalidate("device-1")
+	cache.put("device-1", token, statsEntry{bucket: bucket})
+	if _, _, ok := cache.get("device-1", bucket); ok {
+		t.Error("expected stale stats kept out after a device write")
+	}
+
+	_, token, _ = cache.get("device-1", bucket)
+	cache.invalidate()
+	cache.put("device-1", token, statsEntry{bucket: bucket})
+	if _, _, ok := cache.get("device-1", bucket); ok {
+		t.Error("expected stale stats kept out after a registry write")
+	}
+
+	_, token, _ = cache.get("device-1", bucket)
+	cache.put("device-1", token, statsEntry{bucket: bucket})
+	if _, _, ok := cache.get("device-1", bucket); !ok {
+		t.Error("expected stats cached without a write")
+	}
+}
+
+// TestGetStats_CachedScoping tests that a cached answer still respects org scoping
+func TestGetStats_CachedScoping(t *testing.T) {
+	server := setupAuthTestServer()
+	router := server.Router()
+	server.store.RecordHeartbeat(t.Context(), "device-a", time.Now().UTC())
+
+	for _, tt := range []struct {
+		key  string
+		want int
+	}{{"key-a", http.StatusOK}, {"key-b", http.StatusNotFound}, {"key-a", http.StatusOK}} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-a/stats", nil)
+		req.Header.Set(apiKeyHeader, tt.key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.key, tt.want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// The API is versioned by path. /api/v1 responses are frozen: fields are
+// only ever added, never renamed or retyped, so existing clients never break.
+// Stats reshaped for clients that want typed values live under /api/v2,
+// which only serves endpoints whose v1 shape was outgrown; every other
+// endpoint stays on v1. v2 durations are always seconds as JSON numbers,
+// so ?format= doesn't apply; ?precision= does.
+
+// Device statuses reported by v2 stats.
+const (
+	statusOnline      = "online"
+	statusOffline     = "offline"     // silent for longer than the offline monitor's threshold
+	statusMaintenance = "maintenance" // under a maintenance window
+	statusNoData      = "no_data"     // never sent a heartbeat
+	statusRetired     = "retired"     // decommissioned
+)
+
+// SetOfflineAfter sets the heartbeat silence after which v2 stats report a
+// device offline, for devices without their own alert_after. It should match
+// the offline monitor's.
+func (s *Server) SetOfflineAfter(offlineAfter time.Duration) {
+	s.offlineAfter = offlineAfter
+}
+
+// StatsV2Response is the response of GET /api/v2/devices/{device_id}/stats.
+type StatsV2Response struct {
+	DeviceID  string `json:"device_id"`
+	Status    string `json:"status"`
+	Lifecycle string `json:"lifecycle"`
+	Timezone  string `json:"timezone"` // times are UTC; this is the facility's zone
+
+	ActivatedAt      time.Time `json:"activated_at,omitzero"`
+	DecommissionedAt time.Time `json:"decommissioned_at,omitzero"`
+	MutedUntil       time.Time `json:"muted_until,omitzero"`
+
+	Heartbeats StatsV2Heartbeats `json:"heartbeats"`
+	Uptime     *StatsV2Uptime    `json:"uptime"`            // null before the first heartbeat
+	Uploads    *StatsV2Uploads   `json:"uploads"`           // null before the first upload
+	Network    *StatsV2Network   `json:"network,omitempty"` // omitted until two heartbeats arrive
+	Latency    *StatsV2Latency   `json:"latency,omitempty"` // omitted until a live heartbeat is measured
+
+	UploadSchedule *StatsV2UploadSchedule `json:"upload_schedule,omitempty"` // omitted without an upload_interval
+
+	// Hardware vitals; omitted for sensors the device never reported
+	BatteryPct    *ReadingResponse `json:"battery_pct,omitempty"`
+	TemperatureC  *ReadingResponse `json:"temperature_c,omitempty"`
+	DiskFreeBytes *ReadingResponse `json:"disk_free_bytes,omitempty"`
+}
+
+// StatsV2Heartbeats describes the heartbeats counted towards uptime.
+type StatsV2Heartbeats struct {
+	Count               int64     `json:"count"`
+	CoveredMinutes      int64     `json:"covered_minutes"` // distinct minutes with a heartbeat
+	Coalesced           int64     `json:"coalesced"`       // received in a minute already counted, not in count
+	First               time.Time `json:"first,omitzero"`
+	Last                time.Time `json:"last,omitzero"`
+	IntervalSeconds     float64   `json:"interval_seconds"`
+	IntervalSource      string    `json:"interval_source"`       // configured, detected or default
+	OfflineAfterSeconds float64   `json:"offline_after_seconds"` // silence before status is offline
+}
+
+// StatsV2Uptime is the device's uptime and the window it's measured over.
+type StatsV2Uptime struct {
+	Percent float64 `json:"percent"`
+
+	// Measured from activation or the first heartbeat to the last
+	// heartbeat, less any time under maintenance
+	WindowStart        time.Time `json:"window_start"`
+	WindowEnd          time.Time `json:"window_end"`
+	WindowSeconds      float64   `json:"window_seconds"`
+	ExpectedHeartbeats float64   `json:"expected_heartbeats"`
+
+	// Change over the last 24 complete hours vs the 24 before; null until
+	// both windows have data
+	DeltaPoints *float64 `json:"delta_points"`
+}
+
+// StatsV2Uploads summarizes the device's upload times.
+type StatsV2Uploads struct {
+	Count       int64   `json:"count"`
+	AvgSeconds  float64 `json:"avg_seconds"`
+	MinSeconds  float64 `json:"min_seconds"`
+	MaxSeconds  float64 `json:"max_seconds"`
+	LastSeconds float64 `json:"last_seconds"`
+
+	// Change in the average over the last 24 complete hours vs the 24
+	// before; null until both windows saw uploads
+	AvgDeltaSeconds *float64 `json:"avg_delta_seconds"`
+}
+
+// StatsV2Network is the device's connectivity from its heartbeat gaps.
+type StatsV2Network struct {
+	Score            float64 `json:"score"` // 0-100
+	JitterSeconds    float64 `json:"jitter_seconds"`
+	MissedHeartbeats int64   `json:"missed_heartbeats"`
+}
+
+// HandleGetStatsV2 processes GET /api/v2/devices/{device_id}/stats. Unlike
+// v1 it answers 200 for a device without data, with status no_data.
+func (s *Server) HandleGetStatsV2(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v2/devices/%s/stats", deviceID)
+
+	p, msg := s.parsePrecision(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	now := time.Now().UTC()
+	device, result, trend, ok := s.deviceStats(w, r, deviceID, now)
+	if !ok {
+		return
+	}
+
+	thresholds, err := s.store.GroupAlertThresholds(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	threshold := offlineThreshold(device, thresholds, s.offlineAfter)
+	resp := StatsV2Response{
+		DeviceID:  deviceID,
+		Status:    deviceStatus(device, threshold, now),
+		Lifecycle: device.Lifecycle(now),
+		Timezone:  device.Location().String(),
+
+		ActivatedAt:      device.ActivatedAt,
+		DecommissionedAt: device.DecommissionedAt,
+
+		Heartbeats: StatsV2Heartbeats{
+			Count:               device.HeartbeatCount,
+			CoveredMinutes:      device.CoveredMinutes,
+			Coalesced:           device.CoalescedHeartbeats,
+			First:               device.FirstHeartbeat,
+			Last:                device.LastHeartbeat,
+			IntervalSeconds:     device.EffectiveInterval().Seconds(),
+			IntervalSource:      device.IntervalSource(),
+			OfflineAfterSeconds: threshold.Seconds(),
+		},
+
+		BatteryPct:    readingResponse(device.Battery),
+		TemperatureC:  readingResponse(device.Temperature),
+		DiskFreeBytes: readingResponse(device.DiskFree),
+	}
+	if device.Muted(now) {
+		resp.MutedUntil = device.MutedUntil
+	}
+
+	if result.HasHeartbeats {
+		start, window, expected := device.uptimeWindow()
+		resp.Uptime = &StatsV2Uptime{
+			Percent:            p.round(result.Uptime),
+			WindowStart:        start,
+			WindowEnd:          device.LastHeartbeat,
+			WindowSeconds:      window.Seconds(),
+			ExpectedHeartbeats: expected,
+		}
+		if trend.hasUptime {
+			delta := p.round(trend.uptimeDelta)
+			resp.Uptime.DeltaPoints = &delta
+		}
+	}
+	if result.HasUploads {
+		resp.Uploads = &StatsV2Uploads{
+			Count:       device.UploadCount,
+			AvgSeconds:  p.round(result.AvgUploadTime.Seconds()),
+			MinSeconds:  p.round(result.MinUploadTime.Seconds()),
+			MaxSeconds:  p.round(result.MaxUploadTime.Seconds()),
+			LastSeconds: p.round(result.LastUploadTime.Seconds()),
+		}
+		if trend.hasAvgUpload {
+			delta := p.round(trend.avgUploadDelta.Seconds())
+			resp.Uploads.AvgDeltaSeconds = &delta
+		}
+	}
+	if quality, ok := device.NetworkQuality(); ok {
+		resp.Network = &StatsV2Network{
+			Score:            quality.Score,
+			JitterSeconds:    p.round(quality.Jitter.Seconds()),
+			MissedHeartbeats: quality.MissedHeartbeats,
+		}
+	}
+	resp.Latency = latencyResponse(device)
+	resp.UploadSchedule = uploadSchedule(device, now)
+
+	writeCacheableJSON(w, r, resp)
+}
+
+// deviceStatus returns the device's status at now, judging silence the way
+// the offline monitor does.
+func deviceStatus(device DeviceStats, threshold time.Duration, now time.Time) string {
+	switch {
+	case !device.DecommissionedAt.IsZero():
+		return statusRetired
+	case device.maintenance.active(now):
+		return statusMaintenance
+	case device.LastHeartbeat.IsZero():
+		return statusNoData
+	case now.Sub(maxTime(device.LastHeartbeat, device.maintenance.lastEnd(now))) > threshold:
+		return statusOffline
+	default:
+		return statusOnline
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// statsFixture is a server whose device-1 has a fixed, long-past history,
+// so its stats don't depend on when the test runs.
+func statsFixture() *Server {
+	server := setupTestServer()
+	start := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, minute := range []int{0, 1, 2, 4} {
+		server.store.RecordHeartbeat(context.Background(), "device-1", start.Add(time.Duration(minute)*time.Minute))
+	}
+	server.store.RecordUploadStat(context.Background(), "device-1", 2*time.Second)
+	server.store.RecordUploadStat(context.Background(), "device-1", 4*time.Second)
+	return server
+}
+
+// TestHandleGetStats_V1Frozen tests that the v1 stats response stays byte-compatible
+func TestHandleGetStats_V1Frozen(t *testing.T) {
+	rr := httptest.NewRecorder()
+	statsFixture().Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+
+	want := `{"uptime":80,"avg_upload_time":"3s","min_upload_time":"2s","max_upload_time":"4s","last_upload_time":"4s",` +
+		`"uptime_delta":0,"timezone":"UTC","first_heartbeat_local":"2020-03-01T12:00:00Z","last_heartbeat_local":"2020-03-01T12:04:00Z",` +
+		`"heartbeat_interval":"1m0s","heartbeat_interval_source":"default","lifecycle":"provisioned",` +
+		`"network_score":75,"jitter":"0s","missed_heartbeats":1}` + "\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Errorf("v1 response changed:\ngot  %d %s\nwant %s", rr.Code, rr.Body.String(), want)
+	}
+}
+
+// TestHandleGetStatsV2 tests the typed v2 stats response
+func TestHandleGetStatsV2(t *testing.T) {
+	server := statsFixture()
+	router := server.Router()
+	get := func(path string) (*httptest.ResponseRecorder, StatsV2Response) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp StatsV2Response
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	rr, resp := get("/api/v2/devices/device-1/stats")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp.Status != statusOffline || resp.Heartbeats.Count != 4 || resp.Heartbeats.IntervalSeconds != 60 || resp.Heartbeats.OfflineAfterSeconds != 300 {
+		t.Errorf("unexpected status or heartbeats %+v", resp)
+	}
+	if u := resp.Uptime; u == nil || u.Percent != 80 || u.WindowSeconds != 240 || u.ExpectedHeartbeats != 5 {
+		t.Errorf("unexpected uptime %+v", resp.Uptime)
+	}
+	if u := resp.Uploads; u == nil || u.Count != 2 || u.AvgSeconds != 3 || u.MinSeconds != 2 || u.MaxSeconds != 4 {
+		t.Errorf("unexpected uploads %+v", resp.Uploads)
+	}
+	if n := resp.Network; n == nil || n.Score != 75 || n.MissedHeartbeats != 1 {
+		t.Errorf("unexpected network %+v", resp.Network)
+	}
+
+	// A device without data gets 200 in v2, where v1 answers 204
+	server.store.RecordHeartbeat(t.Context(), "device-2", time.Now())
+	if rr, resp := get("/api/v2/devices/device-2/stats"); rr.Code != http.StatusOK || resp.Status != statusOnline || resp.Uploads != nil {
+		t.Errorf("unexpected response for a reporting device %d %s", rr.Code, rr.Body.String())
+	}
+	server.store.Decommission(t.Context(), "device-2", time.Now())
+	if _, resp := get("/api/v2/devices/device-2/stats"); resp.Status != statusRetired {
+		t.Errorf("expected retired, got %s", resp.Status)
+	}
+
+	for _, path := range []string{"/api/v2/devices/device-9/stats", "/api/v2/devices/device-1/heartbeat", "/api/v2/devices/"} {
+		if rr, _ := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rr.Code)
+		}
+	}
+}
+
+// TestDeviceStatus tests deriving a device's status from its silence
+func TestDeviceStatus(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		device DeviceStats
+		want   string
+	}{
+		{"never reported", DeviceStats{}, statusNoData},
+		{"recent", DeviceStats{LastHeartbeat: now.Add(-time.Minute)}, statusOnline},
+		{"silent", DeviceStats{LastHeartbeat: now.Add(-10 * time.Minute)}, statusOffline},
+		{"retired", DeviceStats{LastHeartbeat: now, DecommissionedAt: now}, statusRetired},
+		{"maintenance", DeviceStats{LastHeartbeat: now.Add(-time.Hour),
+			maintenance: maintenanceSchedule{{start: now.Add(-time.Hour), end: now.Add(time.Hour)}}}, statusMaintenance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deviceStatus(tt.device, 5*time.Minute, now); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
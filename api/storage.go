@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is everything the server, monitor and agents need from a backend:
+// the device registry, telemetry aggregates and the per-org state built on
+// them. Handlers only use this interface, so a persistent backend can be
+// swapped in without touching them. Store is the in-memory implementation.
+//
+// Methods that read or write data take a context, which ends the call when
+// the request does, and return an error. ErrDeviceNotFound and the other
+// errors below, possibly wrapped, mean the call was understood but names
+// something that doesn't exist or already does; any other error means the
+// backend couldn't serve it. Configuration and in-process counters are
+// plain methods.
+//
+// Capabilities only some backends have, such as snapshots, compaction and
+// memory caps, are separate interfaces below, checked for with a type
+// assertion.
+type Storage interface {
+	DeviceRegistry
+	TelemetryStore
+	GroupStore
+	MaintenanceStore
+}
+
+// Errors returned by backends for calls naming something missing or taken.
+var (
+	ErrDeviceNotFound       error = &validationError{code: errCodeDeviceNotFound, msg: "device not found"}
+	ErrDeviceDecommissioned error = &validationError{code: errCodeDeviceDecommissioned, msg: "device decommissioned"}
+	ErrDeviceExists               = errors.New("device already registered")
+	ErrGroupNotFound              = errors.New("group not found")
+	ErrGroupExists                = errors.New("group already exists")
+	ErrMaintenanceNotFound        = errors.New("maintenance window not found")
+)
+
+// DeviceRegistry holds which devices exist and who owns them.
+type DeviceRegistry interface {
+	LoadDevicesFromCSV(ctx context.Context, filenames ...string) error
+	ReplaceDevices(ctx context.Context, devices []DeviceStats) (ReloadResult, error)
+	AddDevice(ctx context.Context, device DeviceStats) error
+	DeviceExists(ctx context.Context, deviceID string) (bool, error)
+	DeviceOrg(ctx context.Context, deviceID string) (string, error)
+	DeviceCount(ctx context.Context) (int, error)
+	Device(ctx context.Context, deviceID string) (DeviceStats, error)
+	ListDevices(ctx context.Context) ([]DeviceStats, error)
+	Activate(ctx context.Context, deviceID string, at time.Time) error
+	Decommission(ctx context.Context, deviceID string, at time.Time) error
+	Mute(ctx context.Context, deviceID string, until time.Time) error
+	Transfer(ctx context.Context, deviceID, org string, at time.Time, aggregates string) (DeviceTransfer, error)
+	IsDecommissioned(ctx context.Context, deviceID string) (bool, error)
+	RecordEvent(ctx context.Context, deviceID, eventType string, at time.Time, detail string) error
+}
+
+// TelemetryStore records telemetry and serves the aggregates built from it.
+type TelemetryStore interface {
+	RecordHeartbeat(ctx context.Context, deviceID string, sentAt time.Time) error
+	RecordUploadStat(ctx context.Context, deviceID string, uploadTime time.Duration) error
+	RecordUploadStatAt(ctx context.Context, deviceID string, uploadTime time.Duration, at time.Time) error
+	SetHeartbeatInterval(ctx context.Context, deviceID string, interval time.Duration) error
+	SetVersions(ctx context.Context, deviceID, firmwareVersion, agentVersion string) error
+	GetStats(ctx context.Context, deviceID string) (StatsResult, error)
+	DeviceAsOf(ctx context.Context, deviceID string, asOf time.Time) (DeviceStats, error)
+	History(ctx context.Context, deviceID string, from, to time.Time) ([]HistoryBucket, time.Duration, error)
+	Activity(ctx context.Context, org string, from, to time.Time, step time.Duration) ([]ActivityPoint, error)
+	RecentUploads(ctx context.Context, deviceID string, limit int) ([]UploadRecord, error)
+	SetIntervalSamples(n int)
+
+	// Apply records validated events in order, as a single write where
+	// the backend allows it. Events for unknown devices are skipped.
+	Apply(ctx context.Context, events []TelemetryEvent) error
+	// Begin starts a unit of work whose telemetry is applied all together
+	// on Commit, or not at all.
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a unit of work on a backend. Telemetry staged on it isn't visible
+// until Commit applies all of it at once: under one lock in the memory
+// backend, or in one database transaction in a SQL backend. A Tx belongs
+// to one goroutine and ends with Commit or Rollback.
+type Tx interface {
+	// Stage queues events to apply on Commit.
+	Stage(events ...TelemetryEvent)
+	// Commit applies every staged event, or none of them if any can't be
+	// applied, such as one for a device removed or decommissioned since it
+	// was staged.
+	Commit(ctx context.Context) error
+	// Rollback discards the staged events. It does nothing after Commit.
+	Rollback() error
+}
+
+// TelemetryEvent is one validated heartbeat or upload stat for a backend
+// to apply.
+type TelemetryEvent struct {
+	DeviceID  string
+	Heartbeat bool
+	At        time.Time // sent_at for heartbeats; sent_at or receipt time for uploads
+
+	// When the server received a live heartbeat; zero for ingested and
+	// replayed ones, whose sent_at says nothing about the network
+	ReceivedAt time.Time
+
+	// Heartbeat fields
+	SourceIP        string // empty if unknown
+	Interval        time.Duration
+	UploadInterval  time.Duration
+	Firmware, Agent string
+	Battery         *float64
+	Temperature     *float64
+	DiskFree        *float64
+
+	// Upload fields
+	UploadTime         time.Duration
+	UploadID, FileType string
+}
+
+// StorageState is what a migration copies between backends: every device
+// with its registry fields and aggregates, groups and maintenance windows.
+type StorageState struct {
+	Devices     []DeviceStats
+	Groups      []Group
+	Maintenance []MaintenanceWindow
+}
+
+// GroupStore holds device groups.
+type GroupStore interface {
+	CreateGroup(ctx context.Context, group Group) error
+	ReplaceGroup(ctx context.Context, group Group) error
+	GetGroup(ctx context.Context, org, name string) (Group, error)
+	ListGroups(ctx context.Context, org string) ([]Group, error)
+	DeleteGroup(ctx context.Context, org, name string) error
+	AddGroupMember(ctx context.Context, org, name, deviceID string) error
+	RemoveGroupMember(ctx context.Context, org, name, deviceID string) error
+	GroupAlertThresholds(ctx context.Context) (map[string]time.Duration, error)
+}
+
+// MaintenanceStore holds scheduled maintenance windows.
+type MaintenanceStore interface {
+	AddMaintenance(ctx context.Context, w MaintenanceWindow) (MaintenanceWindow, error)
+	ListMaintenance(ctx context.Context, org, deviceID string) ([]MaintenanceWindow, error)
+	DeleteMaintenance(ctx context.Context, org string, id int64) error
+}
+
+// Snapshotter is implemented by backends that don't persist on their own,
+// so their state can be saved to and restored from a file.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// Importer is implemented by backends that can be a migration target.
+type Importer interface {
+	// Import replaces everything the backend holds with state.
+	Import(ctx context.Context, state StorageState) error
+}
+
+// Compactor is implemented by backends whose state housekeeping prunes,
+// rather than expiring it on their own.
+type Compactor interface {
+	// Compact frees state housekeeping no longer needs to keep.
+	Compact(ctx context.Context, now time.Time, retention time.Duration) (CompactResult, error)
+	// PlanCompact reports what Compact would free, per retention policy,
+	// without changing anything.
+	PlanCompact(ctx context.Context, now time.Time, retention time.Duration) ([]RetentionImpact, error)
+}
+
+// MemoryBounded is implemented by backends holding aggregates in process
+// memory, whose size the server caps and reports.
+type MemoryBounded interface {
+	SetRecentUploadCapacity(n int)
+	SetMemoryLimits(limits MemoryLimits)
+	// Usage reports what the backend holds and roughly how much memory it takes.
+	Usage(ctx context.Context) (StoreUsage, error)
+	// Evictions counts what the backend forgot to stay within its caps.
+	Evictions() Evictions
+}
+
+// LockReporter is implemented by backends with locks worth instrumenting.
+type LockReporter interface {
+	LockStats() LockWaitStats
+}
+
+// DistributionReporter is implemented by backends that keep telemetry
+// histograms for /metrics.
+type DistributionReporter interface {
+	Distributions(ctx context.Context) (Distributions, error)
+}
+
+// StorageFactory creates a backend from a backend-specific DSN, such as a
+// file path or server address. The memory backend ignores it.
+type StorageFactory func(dsn string) (Storage, error)
+
+var (
+	storageMu       sync.Mutex
+	storageBackends = make(map[string]StorageFactory) // protected by storageMu
+)
+
+// RegisterStorage makes a backend available to NewStorage under name.
+// Backends register themselves from an init function; registering a name
+// twice panics, as with database/sql drivers.
+func RegisterStorage(name string, factory StorageFactory) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+
+	if _, exists := storageBackends[name]; exists {
+		panic("storage backend registered twice: " + name)
+	}
+	storageBackends[name] = factory
+}
+
+// NewStorage creates the backend registered under name.
+func NewStorage(name, dsn string) (Storage, error) {
+	storageMu.Lock()
+	factory, exists := storageBackends[name]
+	storageMu.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %s)", name, strings.Join(StorageBackends(), ", "))
+	}
+	return factory(dsn)
+}
+
+// StorageBackends returns the registered backend names, sorted.
+func StorageBackends() []string {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	return slices.Sorted(maps.Keys(storageBackends))
+}
+
+// The in-memory store is the default backend, persisted through snapshots
+var (
+	_ Storage              = (*Store)(nil)
+	_ Snapshotter          = (*Store)(nil)
+	_ Importer             = (*Store)(nil)
+	_ Compactor            = (*Store)(nil)
+	_ MemoryBounded        = (*Store)(nil)
+	_ LockReporter         = (*Store)(nil)
+	_ DistributionReporter = (*Store)(nil)
+)
+
+func init() {
+	RegisterStorage("memory", func(string) (Storage, error) { return NewStore(), nil })
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewStorage tests creating backends by name
+func TestNewStorage(t *testing.T) {
+	store, err := NewStorage("memory", "")
+	if err != nil {
+		t.Fatalf("expected memory backend, got %v", err)
+	}
+	if _, ok := store.(Snapshotter); !ok {
+		t.Error("expected memory backend to support snapshots")
+	}
+
+	_, err = NewStorage("cassandra", "")
+	if err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("expected unknown backend error listing memory, got %v", err)
+	}
+}
+
+// TestRegisterStorage tests registering a backend and rejecting duplicates
+func TestRegisterStorage(t *testing.T) {
+	var gotDSN string
+	RegisterStorage("test-backend", func(dsn string) (Storage, error) {
+		gotDSN = dsn
+		return NewStore(), nil
+	})
+	t.Cleanup(func() {
+		storageMu.Lock()
+		delete(storageBackends, "test-backend")
+		storageMu.Unlock()
+	})
+
+	if !slices.Contains(StorageBackends(), "test-backend") {
+		t.Errorf("expected test-backend listed, got %v", StorageBackends())
+	}
+	if _, err := NewStorage("test-backend", "/tmp/db"); err != nil || gotDSN != "/tmp/db" {
+		t.Errorf("expected DSN passed to factory, got %q (err %v)", gotDSN, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate registration to panic")
+		}
+	}()
+	RegisterStorage("memory", nil)
+}
+
+// unavailableStorage is a backend whose device reads fail, as when a
+// database is unreachable
+type unavailableStorage struct {
+	Storage
+}
+
+func (unavailableStorage) Device(context.Context, string) (DeviceStats, error) {
+	return DeviceStats{}, errors.New("connection refused")
+}
+
+func (unavailableStorage) DeviceOrg(context.Context, string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+// TestStorageUnavailable tests that a failing backend is answered with a
+// retryable 503 rather than a 404
+func TestStorageUnavailable(t *testing.T) {
+	server := NewServer(unavailableStorage{NewStore()}, nil)
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), errCodeStorageUnavailable) {
+		t.Errorf("expected %s, got %s", errCodeStorageUnavailable, rr.Body.String())
+	}
+}
+
+// minimalStorage is a backend with none of the optional capabilities
+type minimalStorage struct {
+	Storage
+}
+
+// TestStorage_OptionalCapabilities tests that the server and migrations
+// work without the optional interfaces
+func TestStorage_OptionalCapabilities(t *testing.T) {
+	server := NewServer(minimalStorage{NewStore()}, nil)
+	run := server.housekeep(t.Context(), time.Now(), DefaultDecommissionRetention)
+	if run.Compacted != (CompactResult{}) || run.Store.Devices != 0 {
+		t.Errorf("expected nothing compacted or counted, got %+v", run)
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/locks", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 from the locks endpoint, got %d", rr.Code)
+	}
+
+	if _, err := Migrate(t.Context(), minimalStorage{NewStore()}, NewStore()); err == nil {
+		t.Error("expected a migration into a backend without Import to fail")
+	}
+}
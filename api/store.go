@@ -0,0 +1,638 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultHeartbeatInterval is the expected heartbeat cadence for devices that
+// don't declare their own.
+const defaultHeartbeatInterval = time.Minute
+
+// DeviceStats holds aggregated telemetry data for a single device.
+// Memory is bounded per device regardless of how long the server runs: about
+// 1 KB here, plus up to 100 latency samples and 100 events (a few KB more).
+// A device sending telemetry also gets a history ring (~40 KB, see
+// deviceHistory) and, reporting uploads, an upload ring (-recent-uploads
+// records, ~5-10 KB). How many devices keep rings is capped by
+// -max-history-devices and -max-upload-devices (see MemoryLimits).
+type DeviceStats struct {
+	ID       string
+	Org      string // Owning organization; empty when multi-tenancy is not configured
+	Facility string // Facility the device is installed in; empty unless set by the CSV's facility column
+	Room     string // Room within the facility; empty unless set by the CSV's room column
+
+	// Expected time between heartbeats; zero means the detected interval, if
+	// any, else defaultHeartbeatInterval (see EffectiveInterval)
+	HeartbeatInterval time.Duration
+
+	// Heartbeat silence after which the offline monitor alerts; zero means the monitor's default
+	AlertAfter time.Duration
+
+	// Expected time between uploads (see missedUploads); zero means the
+	// device uploads on no schedule
+	UploadInterval time.Duration
+
+	// Facility timezone for local-time reporting; nil means UTC (see Location)
+	location *time.Location
+
+	// Key for payload signatures; nil unless set by the CSV's signing_secret
+	signingKey []byte
+
+	// Token the device sends with its telemetry; empty unless set by the CSV's token column
+	token string
+
+	// Device CSV the device was loaded from; empty for devices added otherwise
+	source string
+
+	// Latest versions reported in heartbeats; empty if never reported
+	FirmwareVersion string
+	AgentVersion    string
+
+	// Address the latest HTTP or UDP heartbeat came from (see sourceIP);
+	// empty until one arrives
+	SourceIP   string
+	SourceIPAt time.Time // when it was received
+
+	// Hardware vitals reported in heartbeats
+	Battery     Reading // percent
+	Temperature Reading // degrees Celsius
+	DiskFree    Reading // bytes
+
+	// Set when the device is installed (see Lifecycle); heartbeats sent
+	// before it aren't counted and uptime is measured from it
+	ActivatedAt time.Time
+
+	// Set when the device is retired; history stays queryable but new telemetry is refused
+	DecommissionedAt time.Time
+
+	// Offline alerts are silenced until then (see Muted); zero when not muted
+	MutedUntil time.Time
+
+	// Moves between organizations, oldest first (see Transfer)
+	Transfers []DeviceTransfer
+
+	// Timeline of registration, lifecycle and connectivity changes, oldest
+	// first and capped at maxDeviceEvents (see addEvent)
+	Events []DeviceEvent
+
+	// Heartbeat aggregates
+	HeartbeatCount int64
+	FirstHeartbeat time.Time
+	LastHeartbeat  time.Time
+	CoveredMinutes int64 // distinct minutes with a heartbeat (see observedHeartbeats)
+
+	// Heartbeats received in a minute already counted, and not counted again
+	// (see coalesceHeartbeatLocked); received is HeartbeatCount plus these
+	CoalescedHeartbeats int64
+
+	// Gaps between consecutive heartbeats, for the network quality score
+	HeartbeatGaps    int64         // gaps measured
+	MissedHeartbeats int64         // expected heartbeats missing within those gaps
+	JitterGaps       int64         // gaps without a missed heartbeat
+	JitterSum        time.Duration // sum of |gap - interval| over JitterGaps
+
+	// Delay from sent_at to the server receiving live heartbeats (see
+	// recordLatency); negative while the device's clock runs ahead
+	LatencyCount    int64
+	LatencySum      time.Duration
+	latencyP95      time.Duration   // over recentLatencies; zero while it's empty
+	recentLatencies []time.Duration // ring of the latest delays
+	nextLatency     int             // index the next delay is written to once recentLatencies is full
+
+	// Cadence inferred from recent gaps; zero until enough have been seen
+	detectedInterval time.Duration
+	recentGaps       []time.Duration // ring of the latest gaps
+	nextGap          int             // index the next gap is written to once recentGaps is full
+
+	// Upload aggregates
+	UploadCount    int64
+	UploadTimeSum  time.Duration
+	MinUploadTime  time.Duration
+	MaxUploadTime  time.Duration
+	LastUploadTime time.Duration
+
+	// Upload schedule aggregates, counted while UploadInterval is set
+	LastUploadAt        time.Time // when the latest upload happened
+	MissedUploadWindows int64     // expected uploads missing between consecutive uploads
+
+	// Maintenance covering the device; set only on copies returned by the store
+	maintenance maintenanceSchedule
+}
+
+// Store provides thread-safe access to device statistics.
+// Uses sync.RWMutex to allow concurrent reads while ensuring exclusive writes.
+type Store struct {
+	mu      timedRWMutex              // wait times reported by LockStats
+	devices map[string]*DeviceStats   // protected by mu
+	history map[string]*deviceHistory // protected by mu; created on first telemetry
+	groups  map[groupKey]*Group       // protected by mu
+
+	activity map[string]*activityRing // protected by mu; per org, created on first telemetry
+
+	recentUploads   map[string]*uploadRing // protected by mu; created on first upload
+	recentUploadCap int                    // protected by mu; zero keeps no records
+
+	intervalSamples int // protected by mu; zero disables interval detection
+
+	limits    MemoryLimits // protected by mu
+	evictions Evictions    // protected by mu; dead letters are counted by their queue
+
+	distributions telemetryDistributions // protected by mu
+
+	maintenance       []MaintenanceWindow // protected by mu
+	nextMaintenanceID int64               // protected by mu
+}
+
+// NewStore creates an empty store.
+func NewStore() *Store {
+	return &Store{
+		devices: make(map[string]*DeviceStats),
+		history: make(map[string]*deviceHistory),
+		groups:  make(map[groupKey]*Group),
+
+		activity: make(map[string]*activityRing),
+
+		recentUploads:   make(map[string]*uploadRing),
+		recentUploadCap: DefaultRecentUploads,
+
+		intervalSamples: DefaultIntervalSamples,
+	}
+}
+
+// lock takes the write lock unless ctx has ended, before or while waiting
+// for it. Nothing in the store blocks but its lock, so a request that gave
+// up before getting the lock writes nothing.
+func (s *Store) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// rlock takes the read lock unless ctx has ended, before or while waiting
+// for it.
+func (s *Store) rlock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	if err := ctx.Err(); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	return nil
+}
+
+// LoadDevicesFromCSV reads device IDs from one or more CSV files and initializes them in the store.
+// Each CSV is expected to have a header row with "device_id" as the first column.
+// An optional "heartbeat_interval" column (Go duration, e.g. "30s") sets the
+// device's expected heartbeat cadence, an optional "alert_after" column sets
+// how long the device may be silent before the offline monitor alerts, an
+// optional "upload_interval" column sets how often the device is expected to
+// upload, an optional "org" column assigns the device to an organization,
+// and optional "facility" and "room" columns place it on site. A device
+// listed in more than one file is an error.
+func (s *Store) LoadDevicesFromCSV(ctx context.Context, filenames ...string) error {
+	// Parse all files before touching the store so a bad row loads nothing
+	devices, err := readDeviceSources(filenames)
+	if err != nil {
+		return err
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, device := range devices {
+		if _, exists := s.devices[device.ID]; !exists {
+			device.addEvent(eventRegistered, now, device.source)
+		}
+		s.devices[device.ID] = &device
+	}
+
+	return nil
+}
+
+// ReloadResult counts how a device reload changed the registry. Unchanged
+// devices were registered before and after, though their org or thresholds
+// may have been updated.
+type ReloadResult struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// ReplaceDevices swaps the registry for devices in one step. Devices that
+// stay keep their telemetry and take the new org, facility, thresholds,
+// timezone and signing key; devices not in the list are dropped along with
+// their history and recent uploads.
+func (s *Store) ReplaceDevices(ctx context.Context, devices []DeviceStats) (ReloadResult, error) {
+	if err := s.lock(ctx); err != nil {
+		return ReloadResult{}, err
+	}
+	defer s.mu.Unlock()
+
+	var result ReloadResult
+	now := time.Now()
+	registry := make(map[string]*DeviceStats, len(devices))
+	for _, device := range devices {
+		existing, exists := s.devices[device.ID]
+		if !exists {
+			if _, seen := registry[device.ID]; !seen {
+				result.Added++
+				device.addEvent(eventRegistered, now, device.source)
+			}
+			registry[device.ID] = &device
+			continue
+		}
+		if _, seen := registry[device.ID]; !seen {
+			result.Unchanged++
+		}
+		existing.Org = device.Org
+		existing.Facility = device.Facility
+		existing.Room = device.Room
+		existing.HeartbeatInterval = device.HeartbeatInterval
+		existing.AlertAfter = device.AlertAfter
+		existing.UploadInterval = device.UploadInterval
+		existing.location = device.location
+		existing.signingKey = device.signingKey
+		existing.token = device.token
+		existing.source = device.source
+		if !device.ActivatedAt.IsZero() && !device.ActivatedAt.Equal(existing.ActivatedAt) {
+			if err := activateLocked(existing, device.ActivatedAt); err != nil {
+				log.Printf("[WARN] Ignoring activated_at for %s: %v", device.ID, err)
+			}
+		}
+		registry[device.ID] = existing
+	}
+
+	for id := range s.devices {
+		if _, kept := registry[id]; !kept {
+			delete(s.history, id)
+			delete(s.recentUploads, id)
+			result.Removed++
+		}
+	}
+	s.devices = registry
+	return result, nil
+}
+
+// columnIndex returns the index of the named column in a CSV header row, or -1.
+func columnIndex(header []string, name string) int {
+	for i, col := range header {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddDevice registers a new device. It returns ErrDeviceExists, leaving
+// the store unchanged, if the ID is already registered.
+func (s *Store) AddDevice(ctx context.Context, device DeviceStats) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	if _, exists := s.devices[device.ID]; exists {
+		return ErrDeviceExists
+	}
+	device.addEvent(eventRegistered, time.Now(), "enrolled")
+	s.devices[device.ID] = &device
+	return nil
+}
+
+// DeviceExists checks if a device ID is registered in the store.
+func (s *Store) DeviceExists(ctx context.Context, deviceID string) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mu.RUnlock()
+	_, exists := s.devices[deviceID]
+	return exists, nil
+}
+
+// DeviceOrg returns the organization a device belongs to.
+func (s *Store) DeviceOrg(ctx context.Context, deviceID string) (string, error) {
+	if err := s.rlock(ctx); err != nil {
+		return "", err
+	}
+	defer s.mu.RUnlock()
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return "", ErrDeviceNotFound
+	}
+	return device.Org, nil
+}
+
+// SetVersions records the firmware and agent versions a device reported.
+// Empty values leave the previously reported version unchanged.
+func (s *Store) SetVersions(ctx context.Context, deviceID, firmwareVersion, agentVersion string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	device.setVersions(firmwareVersion, agentVersion)
+	return nil
+}
+
+// setVersions updates whichever versions are non-empty.
+func (device *DeviceStats) setVersions(firmwareVersion, agentVersion string) {
+	if firmwareVersion != "" {
+		device.FirmwareVersion = firmwareVersion
+	}
+	if agentVersion != "" {
+		device.AgentVersion = agentVersion
+	}
+}
+
+// Decommission marks a device as retired at the given time.
+// Decommissioning an already retired device keeps the original timestamp.
+func (s *Store) Decommission(ctx context.Context, deviceID string, at time.Time) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	if device.DecommissionedAt.IsZero() {
+		device.DecommissionedAt = at
+		device.addEvent(eventDecommissioned, at, "")
+	}
+	return nil
+}
+
+// IsDecommissioned reports whether a device has been retired. Unknown
+// devices aren't.
+func (s *Store) IsDecommissioned(ctx context.Context, deviceID string) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mu.RUnlock()
+	device, exists := s.devices[deviceID]
+	return exists && !device.DecommissionedAt.IsZero(), nil
+}
+
+// RecordHeartbeat updates heartbeat statistics for a device.
+// On first heartbeat: sets both FirstHeartbeat and LastHeartbeat.
+// On subsequent heartbeats: only updates LastHeartbeat.
+func (s *Store) RecordHeartbeat(ctx context.Context, deviceID string, sentAt time.Time) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	s.recordHeartbeatLocked(device, sentAt)
+	return nil
+}
+
+// recordHeartbeatLocked updates a device's heartbeat aggregates and history,
+// and the fleet activity.
+// Callers must hold s.mu for writing.
+func (s *Store) recordHeartbeatLocked(device *DeviceStats, sentAt time.Time) {
+	// Bench testing before installation says nothing about uptime
+	if sentAt.Before(device.ActivatedAt) {
+		return
+	}
+	s.recordActivityLocked(device.Org, time.Now(), true)
+
+	b := s.historyFor(device.ID).bucket(sentAt)
+	if coalesceHeartbeatLocked(device, b, sentAt) {
+		return
+	}
+	s.recordGapLocked(device, sentAt)
+	device.HeartbeatCount++
+	if device.FirstHeartbeat.IsZero() {
+		device.FirstHeartbeat = sentAt
+		device.addEvent(eventFirstHeartbeat, sentAt, "")
+	}
+	device.LastHeartbeat = sentAt
+
+	if b != nil {
+		b.HeartbeatCount++
+	}
+	recordCoverageLocked(device, b, sentAt)
+}
+
+// SetHeartbeatInterval records the heartbeat cadence a device declared for itself.
+func (s *Store) SetHeartbeatInterval(ctx context.Context, deviceID string, interval time.Duration) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	device.HeartbeatInterval = interval
+	return nil
+}
+
+// RecordUploadStat records an upload time measurement for a device, received now.
+func (s *Store) RecordUploadStat(ctx context.Context, deviceID string, uploadTime time.Duration) error {
+	return s.RecordUploadStatAt(ctx, deviceID, uploadTime, time.Now())
+}
+
+// RecordUploadStatAt records an upload time measurement that happened at the
+// given time, which decides the history bucket it lands in.
+// Min and max are tracked alongside the sum so a single outlier stays visible
+// even when the average hides it.
+func (s *Store) RecordUploadStatAt(ctx context.Context, deviceID string, uploadTime time.Duration, at time.Time) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	s.recordUploadStatLocked(device, UploadRecord{UploadTime: uploadTime, At: at})
+	return nil
+}
+
+// recordUploadStatLocked updates a device's upload aggregates, history and
+// recent uploads, and the fleet activity.
+// Callers must hold s.mu for writing.
+func (s *Store) recordUploadStatLocked(device *DeviceStats, rec UploadRecord) {
+	uploadTime := rec.UploadTime
+	now := time.Now()
+	if device.UploadCount == 0 || uploadTime < device.MinUploadTime {
+		device.MinUploadTime = uploadTime
+	}
+	if uploadTime > device.MaxUploadTime {
+		device.MaxUploadTime = uploadTime
+	}
+	device.UploadCount++
+	device.UploadTimeSum += uploadTime
+	device.LastUploadTime = uploadTime
+	s.observeUploadTimeLocked(device, uploadTime)
+	device.recordUploadGap(rec.At)
+
+	if b := s.historyFor(device.ID).bucket(rec.At); b != nil {
+		b.UploadCount++
+		b.UploadTimeSum += uploadTime
+	}
+	rec.ReceivedAt = now
+	s.recordUploadLocked(device.ID, rec)
+	s.recordActivityLocked(device.Org, now, false)
+}
+
+// StatsResult holds calculated statistics for a device.
+type StatsResult struct {
+	HasHeartbeats  bool
+	HasUploads     bool
+	Uptime         float64
+	AvgUploadTime  time.Duration
+	MinUploadTime  time.Duration
+	MaxUploadTime  time.Duration
+	LastUploadTime time.Duration
+
+	// Maintenance covering the device; set only on copies returned by the store
+	maintenance maintenanceSchedule
+}
+
+// GetStats calculates statistics for a device.
+// Returns uptime percentage and average, min, max, and most recent upload time.
+// Handles edge cases:
+//   - Single heartbeat: returns 100% uptime (device was online at only observed moment)
+//   - Zero uploads: HasUploads is false
+func (s *Store) GetStats(ctx context.Context, deviceID string) (StatsResult, error) {
+	if err := s.rlock(ctx); err != nil {
+		return StatsResult{}, err
+	}
+	defer s.mu.RUnlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return StatsResult{}, ErrDeviceNotFound
+	}
+
+	copied := s.copyDeviceLocked(device)
+	return copied.Stats(), nil
+}
+
+// uptimeWindow returns the span uptime is measured over and the heartbeats
+// expected in it. Activated devices are measured from activation, so a slow
+// first heartbeat counts as downtime. One is added to expected to include
+// the first interval (fence-post problem); with the default one-minute
+// cadence it is minutes + 1. Time under maintenance expects no heartbeats.
+// Only meaningful once the device has heartbeats.
+func (device *DeviceStats) uptimeWindow() (start time.Time, window time.Duration, expected float64) {
+	start = device.FirstHeartbeat
+	if !device.ActivatedAt.IsZero() && device.ActivatedAt.Before(start) {
+		start = device.ActivatedAt
+	}
+	window = device.LastHeartbeat.Sub(start) - device.maintenance.overlap(start, device.LastHeartbeat)
+	return start, window, float64(window)/float64(device.EffectiveInterval()) + 1
+}
+
+// observedHeartbeats returns the heartbeats uptime counts: distinct minutes
+// with a heartbeat, or every heartbeat for devices beating faster than once
+// a minute.
+func (device *DeviceStats) observedHeartbeats() int64 {
+	return observedHeartbeats(device.HeartbeatCount, device.CoveredMinutes, device.EffectiveInterval())
+}
+
+// Stats calculates statistics from the device's aggregates.
+func (device *DeviceStats) Stats() StatsResult {
+	result := StatsResult{}
+
+	// Calculate uptime if we have heartbeats
+	if device.HeartbeatCount > 0 {
+		result.HasHeartbeats = true
+
+		start, _, expected := device.uptimeWindow()
+		if device.HeartbeatCount == 1 && start.Equal(device.FirstHeartbeat) {
+			// Single heartbeat: device was online at that moment
+			result.Uptime = 100.0
+		} else {
+			// Formula: (observed / expected heartbeats over the window) * 100
+			result.Uptime = (float64(device.observedHeartbeats()) / expected) * 100
+
+			// Cap at 100% (could exceed if multiple heartbeats in same interval)
+			if result.Uptime > 100.0 {
+				result.Uptime = 100.0
+			}
+		}
+	}
+
+	// Calculate average upload time if we have uploads
+	if device.UploadCount > 0 {
+		result.HasUploads = true
+		result.AvgUploadTime = device.UploadTimeSum / time.Duration(device.UploadCount)
+		result.MinUploadTime = device.MinUploadTime
+		result.MaxUploadTime = device.MaxUploadTime
+		result.LastUploadTime = device.LastUploadTime
+	}
+
+	return result
+}
+
+// DeviceCount returns the number of registered devices.
+func (s *Store) DeviceCount(ctx context.Context) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mu.RUnlock()
+	return len(s.devices), nil
+}
+
+// Device returns a copy of one device's aggregates.
+func (s *Store) Device(ctx context.Context, deviceID string) (DeviceStats, error) {
+	if err := s.rlock(ctx); err != nil {
+		return DeviceStats{}, err
+	}
+	defer s.mu.RUnlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return DeviceStats{}, ErrDeviceNotFound
+	}
+	return s.copyDeviceLocked(device), nil
+}
+
+// ListDevices returns a copy of every device's aggregates, sorted by ID.
+// Copies let callers compute fleet-wide views without holding the lock.
+func (s *Store) ListDevices(ctx context.Context) ([]DeviceStats, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	devices := make([]DeviceStats, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, s.copyDeviceLocked(device))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
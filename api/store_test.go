@@ -0,0 +1,571 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// deviceExists reports whether s has the device, failing the test on a
+// store error.
+func deviceExists(t *testing.T, s Storage, deviceID string) bool {
+	t.Helper()
+	exists, err := s.DeviceExists(t.Context(), deviceID)
+	if err != nil {
+		t.Fatalf("DeviceExists(%s): %v", deviceID, err)
+	}
+	return exists
+}
+
+// isDecommissioned reports whether the device is retired, failing the test
+// on a store error.
+func isDecommissioned(t *testing.T, s Storage, deviceID string) bool {
+	t.Helper()
+	retired, err := s.IsDecommissioned(t.Context(), deviceID)
+	if err != nil {
+		t.Fatalf("IsDecommissioned(%s): %v", deviceID, err)
+	}
+	return retired
+}
+
+// deviceCount counts s's devices, failing the test on a store error.
+func deviceCount(t *testing.T, s Storage) int {
+	t.Helper()
+	n, err := s.DeviceCount(t.Context())
+	if err != nil {
+		t.Fatalf("DeviceCount: %v", err)
+	}
+	return n
+}
+
+func TestNewStore(t *testing.T) {
+	s := NewStore()
+	if s == nil {
+		t.Fatal("NewStore returned nil")
+	}
+	if s.devices == nil {
+		t.Fatal("devices map not initialized")
+	}
+}
+
+func TestLoadDevicesFromCSV(t *testing.T) {
+	// Create a temporary CSV file
+	content := "device_id\nabc-123\nxyz-456\n"
+	tmpFile, err := os.CreateTemp("", "devices*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	s := NewStore()
+	if err := s.LoadDevicesFromCSV(t.Context(), tmpFile.Name()); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+
+	if deviceCount(t, s) != 2 {
+		t.Errorf("expected 2 devices, got %d", deviceCount(t, s))
+	}
+
+	if !deviceExists(t, s, "abc-123") {
+		t.Error("device abc-123 should exist")
+	}
+	if !deviceExists(t, s, "xyz-456") {
+		t.Error("device xyz-456 should exist")
+	}
+	if deviceExists(t, s, "not-exists") {
+		t.Error("device not-exists should not exist")
+	}
+}
+
+func TestLoadDevicesFromCSV_FileNotFound(t *testing.T) {
+	s := NewStore()
+	err := s.LoadDevicesFromCSV(t.Context(), "/nonexistent/path/devices.csv")
+	if err == nil {
+		t.Error("expected error for non-existent file")
+	}
+}
+
+func TestRecordHeartbeat(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 1, 15, 10, 5, 0, 0, time.UTC)
+
+	// First heartbeat
+	if err := s.RecordHeartbeat(t.Context(), "device-1", t1); err != nil {
+		t.Errorf("RecordHeartbeat failed for existing device: %v", err)
+	}
+
+	device := s.devices["device-1"]
+	if device.HeartbeatCount != 1 {
+		t.Errorf("expected count 1, got %d", device.HeartbeatCount)
+	}
+	if !device.FirstHeartbeat.Equal(t1) {
+		t.Errorf("expected FirstHeartbeat %v, got %v", t1, device.FirstHeartbeat)
+	}
+	if !device.LastHeartbeat.Equal(t1) {
+		t.Errorf("expected LastHeartbeat %v, got %v", t1, device.LastHeartbeat)
+	}
+
+	// Second heartbeat
+	s.RecordHeartbeat(t.Context(), "device-1", t2)
+
+	if device.HeartbeatCount != 2 {
+		t.Errorf("expected count 2, got %d", device.HeartbeatCount)
+	}
+	if !device.FirstHeartbeat.Equal(t1) {
+		t.Error("FirstHeartbeat should not change on subsequent heartbeats")
+	}
+	if !device.LastHeartbeat.Equal(t2) {
+		t.Errorf("expected LastHeartbeat %v, got %v", t2, device.LastHeartbeat)
+	}
+}
+
+func TestRecordHeartbeat_UnknownDevice(t *testing.T) {
+	s := NewStore()
+	t1 := time.Now()
+
+	if err := s.RecordHeartbeat(t.Context(), "unknown", t1); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("RecordHeartbeat for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestRecordUploadStat(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	upload1 := 5 * time.Second
+	upload2 := 10 * time.Second
+
+	// First upload
+	if err := s.RecordUploadStat(t.Context(), "device-1", upload1); err != nil {
+		t.Errorf("RecordUploadStat failed for existing device: %v", err)
+	}
+
+	device := s.devices["device-1"]
+	if device.UploadCount != 1 {
+		t.Errorf("expected count 1, got %d", device.UploadCount)
+	}
+	if device.UploadTimeSum != upload1 {
+		t.Errorf("expected sum %v, got %v", upload1, device.UploadTimeSum)
+	}
+
+	// Second upload
+	s.RecordUploadStat(t.Context(), "device-1", upload2)
+
+	if device.UploadCount != 2 {
+		t.Errorf("expected count 2, got %d", device.UploadCount)
+	}
+	if device.UploadTimeSum != upload1+upload2 {
+		t.Errorf("expected sum %v, got %v", upload1+upload2, device.UploadTimeSum)
+	}
+}
+
+func TestRecordUploadStat_UnknownDevice(t *testing.T) {
+	s := NewStore()
+
+	if err := s.RecordUploadStat(t.Context(), "unknown", 5*time.Second); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("RecordUploadStat for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestStore_GetStats_NoData(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	result, err := s.GetStats(t.Context(), "device-1")
+	if err != nil {
+		t.Fatalf("GetStats should return true for existing device: %v", err)
+	}
+	if result.HasHeartbeats {
+		t.Error("HasHeartbeats should be false with no heartbeats")
+	}
+	if result.HasUploads {
+		t.Error("HasUploads should be false with no uploads")
+	}
+}
+
+func TestGetStats_UnknownDevice(t *testing.T) {
+	s := NewStore()
+
+	if _, err := s.GetStats(t.Context(), "unknown"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("GetStats for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestGetStats_SingleHeartbeat(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+
+	result, _ := s.GetStats(t.Context(), "device-1")
+	if !result.HasHeartbeats {
+		t.Error("HasHeartbeats should be true")
+	}
+	if result.Uptime != 100.0 {
+		t.Errorf("single heartbeat should have 100%% uptime, got %.2f%%", result.Uptime)
+	}
+}
+
+func TestGetStats_UptimeCalculation(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	// Simulate 5 heartbeats over 10 minutes
+	// Expected: 5 / (10 + 1) * 100 = 45.45%
+	baseTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	for _, minute := range []int{0, 2, 5, 8, 10} {
+		if err := s.RecordHeartbeat(t.Context(), "device-1", baseTime.Add(time.Duration(minute)*time.Minute)); err != nil {
+			t.Fatalf("RecordHeartbeat at minute %d: %v", minute, err)
+		}
+	}
+
+	result, err := s.GetStats(t.Context(), "device-1")
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+	// 5 heartbeats over 11 minutes (0-10 inclusive)
+	expected := (5.0 / 11.0) * 100
+
+	if result.Uptime < expected-0.1 || result.Uptime > expected+0.1 {
+		t.Errorf("expected uptime ~%.2f%%, got %.2f%%", expected, result.Uptime)
+	}
+}
+
+func TestGetStats_UptimeCap(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	// Multiple heartbeats in same minute should cap at 100%
+	baseTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	s.RecordHeartbeat(t.Context(), "device-1", baseTime)
+	s.RecordHeartbeat(t.Context(), "device-1", baseTime.Add(10*time.Second))
+	s.RecordHeartbeat(t.Context(), "device-1", baseTime.Add(20*time.Second))
+
+	result, _ := s.GetStats(t.Context(), "device-1")
+	if result.Uptime > 100.0 {
+		t.Errorf("uptime should be capped at 100%%, got %.2f%%", result.Uptime)
+	}
+}
+
+func TestGetStats_AvgUploadTime(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	s.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+	s.RecordUploadStat(t.Context(), "device-1", 10*time.Second)
+	s.RecordUploadStat(t.Context(), "device-1", 15*time.Second)
+
+	result, _ := s.GetStats(t.Context(), "device-1")
+	if !result.HasUploads {
+		t.Error("HasUploads should be true")
+	}
+
+	// (5 + 10 + 15) / 3 = 10 seconds
+	expected := 10 * time.Second
+	if result.AvgUploadTime != expected {
+		t.Errorf("expected avg upload time %v, got %v", expected, result.AvgUploadTime)
+	}
+}
+
+func TestGetStats_Combined(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	baseTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	// Add heartbeats and uploads
+	s.RecordHeartbeat(t.Context(), "device-1", baseTime)
+	s.RecordHeartbeat(t.Context(), "device-1", baseTime.Add(1*time.Minute))
+	s.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+	s.RecordUploadStat(t.Context(), "device-1", 15*time.Second)
+
+	result, err := s.GetStats(t.Context(), "device-1")
+	if err != nil {
+		t.Fatalf("device should exist: %v", err)
+	}
+	if !result.HasHeartbeats {
+		t.Error("should have heartbeats")
+	}
+	if !result.HasUploads {
+		t.Error("should have uploads")
+	}
+	// 2 heartbeats over 2 minutes = 100%
+	if result.Uptime != 100.0 {
+		t.Errorf("expected 100%% uptime, got %.2f%%", result.Uptime)
+	}
+	// (5 + 15) / 2 = 10 seconds
+	if result.AvgUploadTime != 10*time.Second {
+		t.Errorf("expected avg 10s, got %v", result.AvgUploadTime)
+	}
+}
+
+func TestGetStats_MinMaxLastUploadTime(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	s.RecordUploadStat(t.Context(), "device-1", 10*time.Second)
+	s.RecordUploadStat(t.Context(), "device-1", 40*time.Minute) // outlier
+	s.RecordUploadStat(t.Context(), "device-1", 5*time.Second)
+	s.RecordUploadStat(t.Context(), "device-1", 8*time.Second)
+
+	result, _ := s.GetStats(t.Context(), "device-1")
+	if result.MinUploadTime != 5*time.Second {
+		t.Errorf("expected min 5s, got %v", result.MinUploadTime)
+	}
+	if result.MaxUploadTime != 40*time.Minute {
+		t.Errorf("expected max 40m, got %v", result.MaxUploadTime)
+	}
+	if result.LastUploadTime != 8*time.Second {
+		t.Errorf("expected last 8s, got %v", result.LastUploadTime)
+	}
+}
+
+func TestLoadDevicesFromCSV_HeartbeatInterval(t *testing.T) {
+	content := "device_id,heartbeat_interval\nabc-123,30s\nxyz-456,\n"
+	tmpFile, err := os.CreateTemp("", "devices*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	s := NewStore()
+	if err := s.LoadDevicesFromCSV(t.Context(), tmpFile.Name()); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+
+	if s.devices["abc-123"].HeartbeatInterval != 30*time.Second {
+		t.Errorf("expected interval 30s, got %v", s.devices["abc-123"].HeartbeatInterval)
+	}
+	if s.devices["xyz-456"].HeartbeatInterval != 0 {
+		t.Errorf("expected default interval, got %v", s.devices["xyz-456"].HeartbeatInterval)
+	}
+}
+
+func TestLoadDevicesFromCSV_InvalidHeartbeatInterval(t *testing.T) {
+	content := "device_id,heartbeat_interval\nabc-123,soon\n"
+	tmpFile, err := os.CreateTemp("", "devices*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	s := NewStore()
+	if err := s.LoadDevicesFromCSV(t.Context(), tmpFile.Name()); err == nil {
+		t.Error("expected error for invalid heartbeat_interval")
+	}
+	if deviceCount(t, s) != 0 {
+		t.Errorf("expected no devices loaded, got %d", deviceCount(t, s))
+	}
+}
+
+func TestLoadDevicesFromCSV_AlertAfter(t *testing.T) {
+	content := "device_id,alert_after\nabc-123,3m\nxyz-456,\n"
+	tmpFile, err := os.CreateTemp("", "devices*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = tmpFile.Close()
+
+	s := NewStore()
+	if err := s.LoadDevicesFromCSV(t.Context(), tmpFile.Name()); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+
+	if s.devices["abc-123"].AlertAfter != 3*time.Minute {
+		t.Errorf("expected alert_after 3m, got %v", s.devices["abc-123"].AlertAfter)
+	}
+	if s.devices["xyz-456"].AlertAfter != 0 {
+		t.Errorf("expected default alert_after, got %v", s.devices["xyz-456"].AlertAfter)
+	}
+}
+
+func TestGetStats_UptimeWithHeartbeatInterval(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1", HeartbeatInterval: 30 * time.Second}
+
+	// A 30s device heartbeating once a minute for 10 minutes (11 heartbeats)
+	// Expected: 11 / (10m / 30s + 1) * 100 = 52.38%
+	baseTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i <= 10; i++ {
+		s.RecordHeartbeat(t.Context(), "device-1", baseTime.Add(time.Duration(i)*time.Minute))
+	}
+
+	result, _ := s.GetStats(t.Context(), "device-1")
+	expected := (11.0 / 21.0) * 100
+	if result.Uptime < expected-0.1 || result.Uptime > expected+0.1 {
+		t.Errorf("expected uptime ~%.2f%%, got %.2f%%", expected, result.Uptime)
+	}
+}
+
+func TestSetHeartbeatInterval(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	if err := s.SetHeartbeatInterval(t.Context(), "device-1", 30*time.Second); err != nil {
+		t.Errorf("SetHeartbeatInterval failed for existing device: %v", err)
+	}
+	if s.devices["device-1"].HeartbeatInterval != 30*time.Second {
+		t.Errorf("expected interval 30s, got %v", s.devices["device-1"].HeartbeatInterval)
+	}
+	if err := s.SetHeartbeatInterval(t.Context(), "unknown", 30*time.Second); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("SetHeartbeatInterval for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestStore_Decommission(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	if isDecommissioned(t, s, "device-1") {
+		t.Error("device should not start decommissioned")
+	}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	if err := s.Decommission(t.Context(), "device-1", t1); err != nil {
+		t.Errorf("Decommission failed for existing device: %v", err)
+	}
+	if !isDecommissioned(t, s, "device-1") {
+		t.Error("device should be decommissioned")
+	}
+
+	// Repeating keeps the original timestamp
+	s.Decommission(t.Context(), "device-1", t1.Add(time.Hour))
+	if !s.devices["device-1"].DecommissionedAt.Equal(t1) {
+		t.Errorf("expected DecommissionedAt %v, got %v", t1, s.devices["device-1"].DecommissionedAt)
+	}
+
+	if err := s.Decommission(t.Context(), "unknown", t1); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Decommission for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestSetVersions(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+
+	s.SetVersions(t.Context(), "device-1", "1.0.0", "0.1")
+	s.SetVersions(t.Context(), "device-1", "1.1.0", "") // agent omitted
+
+	device := s.devices["device-1"]
+	if device.FirmwareVersion != "1.1.0" {
+		t.Errorf("expected firmware 1.1.0, got %s", device.FirmwareVersion)
+	}
+	if device.AgentVersion != "0.1" {
+		t.Errorf("omitted agent version should be kept, got %s", device.AgentVersion)
+	}
+	if err := s.SetVersions(t.Context(), "unknown", "1.0.0", ""); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("SetVersions for unknown device returned %v, want ErrDeviceNotFound", err)
+	}
+}
+
+// Benchmarks for the store under concurrent load. Run with
+//
+//	go test -run '^$' -bench Store -benchmem
+//
+// Each reports contended lock waits per op alongside ns/op; see the
+// Performance section of docs/design.md for the targets these track.
+
+var benchmarkFleetSizes = []int{1_000, 10_000, 100_000}
+
+// benchmarkParallelism is goroutines per CPU, so the lock sees contention
+// even on small machines.
+const benchmarkParallelism = 8
+
+// newBenchmarkStore returns a store of n devices that have each sent a
+// heartbeat, so their history is already allocated as in steady state.
+func newBenchmarkStore(b *testing.B, n int) (*Store, []string, time.Time) {
+	b.Helper()
+	s := NewStore()
+	ids := make([]string, n)
+	start := time.Now().UTC().Truncate(time.Hour)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("device-%06d", i)
+		s.devices[ids[i]] = &DeviceStats{ID: ids[i]}
+		s.RecordHeartbeat(b.Context(), ids[i], start)
+	}
+	return s, ids, start
+}
+
+// reportLockWaits adds the store lock's contended waits per op to the results.
+func reportLockWaits(b *testing.B, s *Store) {
+	stats := s.LockStats()
+	waitNanos := (stats.WriteWaitSeconds + stats.ReadWaitSeconds) * float64(time.Second)
+	b.ReportMetric(waitNanos/float64(b.N), "lock-wait-ns/op")
+}
+
+// benchmarkStore runs op in parallel against every fleet size. Each
+// goroutine walks the fleet from its own offset.
+func benchmarkStore(b *testing.B, op func(s *Store, id string, at time.Time, i int)) {
+	for _, n := range benchmarkFleetSizes {
+		b.Run(fmt.Sprintf("devices=%d", n), func(b *testing.B) {
+			s, ids, start := newBenchmarkStore(b, n)
+			var offset atomic.Int64
+			b.SetParallelism(benchmarkParallelism)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(offset.Add(int64(n / benchmarkParallelism)))
+				for pb.Next() {
+					i++
+					op(s, ids[i%n], start.Add(time.Duration(i)*time.Millisecond), i)
+				}
+			})
+			b.StopTimer()
+			reportLockWaits(b, s)
+		})
+	}
+}
+
+// BenchmarkStore_RecordHeartbeat measures concurrent heartbeat writes
+func BenchmarkStore_RecordHeartbeat(b *testing.B) {
+	benchmarkStore(b, func(s *Store, id string, at time.Time, _ int) {
+		s.RecordHeartbeat(b.Context(), id, at)
+	})
+}
+
+// BenchmarkStore_GetStats measures concurrent stats reads
+func BenchmarkStore_GetStats(b *testing.B) {
+	benchmarkStore(b, func(s *Store, id string, _ time.Time, _ int) {
+		s.GetStats(b.Context(), id)
+	})
+}
+
+// BenchmarkStore_Mixed measures heartbeat writes with one stats read in ten
+func BenchmarkStore_Mixed(b *testing.B) {
+	benchmarkStore(b, func(s *Store, id string, at time.Time, i int) {
+		if i%10 == 0 {
+			s.GetStats(b.Context(), id)
+			return
+		}
+		s.RecordHeartbeat(b.Context(), id, at)
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	_ "time/tzdata" // devices.csv names IANA zones; don't depend on the host's zoneinfo
+)
+
+// Facilities run on local time, and SLA days are contractual local days.
+// Each device may carry an IANA timezone (the CSV's timezone column); stats
+// show heartbeat times in it, and daily rollups start at its local midnight.
+
+// Daily rollups default to the last week and cover at most the retained history.
+const (
+	defaultDailyDays = 7
+	maxDailyDays     = historyBuckets * historyBucketSize / (24 * time.Hour)
+)
+
+// Location returns the device's timezone, UTC if it has none.
+func (d DeviceStats) Location() *time.Location {
+	if d.location == nil {
+		return time.UTC
+	}
+	return d.location
+}
+
+// localTime returns t in loc, or the zero time if t is unset.
+func localTime(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loc)
+}
+
+// DailyPoint is one local day of a daily rollup.
+type DailyPoint struct {
+	Date           string    `json:"date"` // local calendar date, e.g. "2024-01-15"
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"` // 23 or 25 hours after start across DST changes
+	HeartbeatCount int64     `json:"heartbeat_count"`
+	UploadCount    int64     `json:"upload_count"`
+	Uptime         float64   `json:"uptime"`
+	AvgUploadTime  Duration  `json:"avg_upload_time"`
+}
+
+// DailyResponse is the body of GET /stats/daily.
+type DailyResponse struct {
+	DeviceID string       `json:"device_id"`
+	Timezone string       `json:"timezone"`
+	Days     []DailyPoint `json:"days"`
+}
+
+// localMidnight returns the start of t's calendar day in loc.
+func localMidnight(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// buildDailyPoints rolls hourly buckets up into the complete local days
+// before now, oldest first. Hourly buckets are aligned to UTC hours, so in
+// zones offset by a fraction of an hour each bucket counts toward the day
+// its start falls in.
+func buildDailyPoints(buckets []HistoryBucket, now time.Time, days int, interval time.Duration, maintenance maintenanceSchedule, format durationFormat) []DailyPoint {
+	loc := now.Location()
+	end := localMidnight(now, loc)
+	points := make([]DailyPoint, 0, days)
+	for i := days; i > 0; i-- {
+		dayStart := end.AddDate(0, 0, -i)
+		dayEnd := dayStart.AddDate(0, 0, 1)
+
+		var day []HistoryBucket
+		for _, b := range buckets {
+			if !b.Start.Before(dayStart) && b.Start.Before(dayEnd) {
+				day = append(day, b)
+			}
+		}
+		hp := buildHistoryPoints(day, dayStart, dayEnd, dayEnd.Sub(dayStart), interval, maintenance, format)[0]
+		points = append(points, DailyPoint{
+			Date:           dayStart.Format(time.DateOnly),
+			Start:          dayStart,
+			End:            dayEnd,
+			HeartbeatCount: hp.HeartbeatCount,
+			UploadCount:    hp.UploadCount,
+			Uptime:         hp.Uptime,
+			AvgUploadTime:  hp.AvgUploadTime,
+		})
+	}
+	return points
+}
+
+// HandleStatsDaily processes GET /api/v1/devices/{device_id}/stats/daily
+func (s *Server) HandleStatsDaily(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/stats/daily", deviceID)
+
+	device, err := s.visibleDevice(r, deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	days := defaultDailyDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > int(maxDailyDays) {
+			writeError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(int(maxDailyDays)))
+			return
+		}
+		days = n
+	}
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	now := time.Now().In(device.Location())
+	to := localMidnight(now, now.Location())
+	buckets, interval, err := s.store.History(r.Context(), deviceID, to.AddDate(0, 0, -days), to)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DailyResponse{
+		DeviceID: deviceID,
+		Timezone: device.Location().String(),
+		Days:     buildDailyPoints(buckets, now, days, interval, device.maintenance, format),
+	})
+}
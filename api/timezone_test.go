@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseDevicesCSV_Timezone tests the optional timezone column
+func TestParseDevicesCSV_Timezone(t *testing.T) {
+	devices, err := parseDevicesCSV(strings.NewReader("device_id,timezone\ncam-1,America/Denver\ncam-2,\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if got := devices[0].Location().String(); got != "America/Denver" {
+		t.Errorf("expected America/Denver, got %s", got)
+	}
+	if devices[1].Location() != time.UTC {
+		t.Errorf("expected UTC without a timezone, got %s", devices[1].Location())
+	}
+
+	if _, err := parseDevicesCSV(strings.NewReader("device_id,timezone\ncam-1,Mars/Olympus\n")); err == nil {
+		t.Error("expected error for an unknown timezone")
+	}
+}
+
+// TestBuildDailyPoints_DST tests that days start at local midnight, including across a DST change
+func TestBuildDailyPoints_DST(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2024, 3, 11, 9, 0, 0, 0, loc)
+	buckets := []HistoryBucket{
+		// 23:00 local on March 9th, then 01:00 and 23:00 local on the 10th
+		{Start: time.Date(2024, 3, 10, 4, 0, 0, 0, time.UTC), HeartbeatCount: 60, Minutes: allMinutes},
+		{Start: time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC), HeartbeatCount: 60, Minutes: allMinutes},
+		{Start: time.Date(2024, 3, 11, 3, 0, 0, 0, time.UTC), HeartbeatCount: 60, Minutes: allMinutes, UploadCount: 2, UploadTimeSum: 4 * time.Second},
+	}
+
+	points := buildDailyPoints(buckets, now, 2, time.Minute, nil, formatGo)
+	if len(points) != 2 {
+		t.Fatalf("expected 2 days, got %d", len(points))
+	}
+	sat, sun := points[0], points[1]
+	if sat.Date != "2024-03-09" || sat.HeartbeatCount != 60 || sat.End.Sub(sat.Start) != 24*time.Hour {
+		t.Errorf("unexpected first day %+v", sat)
+	}
+	if sun.Date != "2024-03-10" || sun.HeartbeatCount != 120 || sun.End.Sub(sun.Start) != 23*time.Hour {
+		t.Errorf("unexpected DST day %+v", sun)
+	}
+	if sun.AvgUploadTime.Duration != 2*time.Second {
+		t.Errorf("expected 2s average upload, got %v", sun.AvgUploadTime)
+	}
+	if want := 120.0 / (23 * 60) * 100; sun.Uptime != want {
+		t.Errorf("expected uptime %v against a 23 hour day, got %v", want, sun.Uptime)
+	}
+}
+
+// TestStats_LocalTimes tests heartbeat times and daily rollups in the device's timezone
+func TestStats_LocalTimes(t *testing.T) {
+	server := setupTestServer()
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	server.backend().(*Store).devices["device-1"].location = tokyo
+	heartbeat := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	server.store.RecordHeartbeat(t.Context(), "device-1", heartbeat)
+	router := server.Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil))
+	var stats map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&stats)
+	if stats["timezone"] != "Asia/Tokyo" || stats["last_heartbeat_local"] != heartbeat.In(tokyo).Format(time.RFC3339) {
+		t.Errorf("expected Tokyo times, got %v", stats)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats/daily?days=3", nil))
+	var daily DailyResponse
+	_ = json.NewDecoder(rr.Body).Decode(&daily)
+	if rr.Code != http.StatusOK || daily.Timezone != "Asia/Tokyo" || len(daily.Days) != 3 {
+		t.Fatalf("unexpected response %d %+v", rr.Code, daily)
+	}
+	last := daily.Days[2]
+	if h, m, _ := last.End.In(tokyo).Clock(); h != 0 || m != 0 || last.Date != time.Now().In(tokyo).AddDate(0, 0, -1).Format(time.DateOnly) {
+		t.Errorf("expected the last day to end at Tokyo midnight today, got %+v", last)
+	}
+
+	for _, query := range []string{"?days=0", "?days=31", "?format=hours"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats/daily"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
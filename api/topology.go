@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Facility topology sync. The facility management system knows which room
+// and facility each camera is installed in; rather than curating that by
+// hand, the active instance pulls the mapping periodically and applies it
+// to the device CSVs through the same path as an import, so the files stay
+// the source of truth. The facility goes in its own column: org decides
+// which API keys see a device, and only the registry's owners may change
+// it. The mapping can't register devices either. Entries for devices the
+// registry doesn't list are reported as unknown, and registered devices the
+// mapping doesn't name are left alone and reported as unmapped.
+
+// maxTopologyBytes bounds a topology response.
+const maxTopologyBytes = 32 << 20
+
+// TopologyEntry places one device.
+type TopologyEntry struct {
+	DeviceID string `json:"device_id"`
+	Room     string `json:"room"`
+	Facility string `json:"facility"`
+}
+
+// TopologySource fetches the mapping from the facility management API,
+// which answers {"devices": [TopologyEntry, ...]}.
+type TopologySource struct {
+	URL    string
+	Token  string // sent as a bearer token when set
+	Client *http.Client
+}
+
+// Fetch retrieves the current mapping.
+func (t *TopologySource) Fetch(ctx context.Context) ([]TopologyEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("facility API returned %s", resp.Status)
+	}
+	var body struct {
+		Devices []TopologyEntry `json:"devices"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTopologyBytes)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode facility API response: %w", err)
+	}
+	return body.Devices, nil
+}
+
+// TopologySyncResult is the outcome of one sync.
+type TopologySyncResult struct {
+	At        time.Time `json:"at"`
+	Devices   int       `json:"devices"` // entries in the mapping
+	Updated   int       `json:"updated"`
+	Unchanged int       `json:"unchanged"`
+	Unmapped  int       `json:"unmapped"`          // registered devices the mapping doesn't name
+	Unknown   []string  `json:"unknown,omitempty"` // entries for devices that aren't registered
+	Skipped   []string  `json:"skipped,omitempty"` // entries whose device_id can't be registered
+	Files     []string  `json:"files"`             // device CSVs rewritten
+	Error     string    `json:"error,omitempty"`
+}
+
+// topologySync tracks the periodic topology sync.
+type topologySync struct {
+	mu       sync.Mutex
+	source   *TopologySource     // protected by mu; nil until RunTopologySync starts
+	interval time.Duration       // protected by mu
+	runs     int64               // protected by mu
+	failures int64               // protected by mu
+	last     *TopologySyncResult // protected by mu; nil until the first sync
+}
+
+// RunTopologySync syncs from source at once and then every interval until
+// ctx is cancelled.
+func (s *Server) RunTopologySync(ctx context.Context, source *TopologySource, interval time.Duration) {
+	s.topology.mu.Lock()
+	s.topology.source, s.topology.interval = source, interval
+	s.topology.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.syncTopology(ctx, source)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncTopology runs one sync and records its outcome.
+func (s *Server) syncTopology(ctx context.Context, source *TopologySource) TopologySyncResult {
+	result, err := s.reconcileTopology(ctx, source)
+	result.At = time.Now().UTC()
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("[ERROR] Topology sync failed: %v", err)
+	} else if result.Updated > 0 {
+		log.Printf("[CONFIG] Topology sync: %d updated, %d unchanged, %d unmapped",
+			result.Updated, result.Unchanged, result.Unmapped)
+	}
+	if len(result.Unknown) > 0 {
+		log.Printf("[WARN] Topology sync: %d devices in the mapping aren't registered: %v", len(result.Unknown), result.Unknown)
+	}
+
+	s.topology.mu.Lock()
+	defer s.topology.mu.Unlock()
+	s.topology.runs++
+	if err != nil {
+		s.topology.failures++
+	}
+	s.topology.last = &result
+	return result
+}
+
+// reconcileTopology fetches the mapping and applies each entry's facility
+// and room to the device CSVs. Empty values are left as they are.
+func (s *Server) reconcileTopology(ctx context.Context, source *TopologySource) (TopologySyncResult, error) {
+	result := TopologySyncResult{Files: []string{}}
+
+	s.configMu.RLock()
+	spec := s.devicesSpec
+	s.configMu.RUnlock()
+	if spec == "" {
+		return result, errors.New("device CSVs are not configured")
+	}
+	entries, err := source.Fetch(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Devices = len(entries)
+
+	var rows []deviceImportRow
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if problem := deviceIDProblem(entry.DeviceID); entry.DeviceID == "" || problem != "" {
+			result.Skipped = append(result.Skipped, entry.DeviceID)
+			continue
+		}
+		if seen[entry.DeviceID] {
+			return result, fmt.Errorf("device %s is listed twice", entry.DeviceID)
+		}
+		seen[entry.DeviceID] = true
+		exists, err := s.store.DeviceExists(ctx, entry.DeviceID)
+		if err != nil {
+			return result, err
+		}
+		if !exists {
+			result.Unknown = append(result.Unknown, entry.DeviceID)
+			continue
+		}
+
+		row := deviceImportRow{line: i + 1, id: entry.DeviceID, values: make(map[string]string)}
+		if entry.Facility != "" {
+			row.values["facility"] = entry.Facility
+		}
+		if entry.Room != "" {
+			row.values["room"] = entry.Room
+		}
+		rows = append(rows, row)
+	}
+	devices, err := s.store.ListDevices(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, device := range devices {
+		if !seen[device.ID] {
+			result.Unmapped++
+		}
+	}
+	slices.Sort(result.Unknown)
+	slices.Sort(result.Skipped)
+
+	if len(rows) == 0 {
+		return result, nil
+	}
+	resp, _, err := s.applyImport(ctx, spec, rows)
+	result.Updated, result.Unchanged = resp.Updated, resp.Unchanged
+	if resp.Files != nil {
+		result.Files = resp.Files
+	}
+	return result, err
+}
+
+// TopologyResponse reports the topology sync.
+type TopologyResponse struct {
+	Enabled         bool                `json:"enabled"`
+	IntervalSeconds float64             `json:"interval_seconds,omitempty"`
+	Runs            int64               `json:"runs"`
+	Failures        int64               `json:"failures"`
+	LastSync        *TopologySyncResult `json:"last_sync,omitempty"`
+}
+
+// HandleTopology processes GET and POST /api/v1/admin/topology. GET reports
+// the sync; POST syncs at once and answers with the result.
+func (s *Server) HandleTopology(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] %s /api/v1/admin/topology", r.Method)
+
+	s.topology.mu.Lock()
+	source := s.topology.source
+	resp := TopologyResponse{
+		Enabled:         source != nil,
+		IntervalSeconds: s.topology.interval.Seconds(),
+		Runs:            s.topology.runs,
+		Failures:        s.topology.failures,
+		LastSync:        s.topology.last,
+	}
+	s.topology.mu.Unlock()
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if source == nil {
+		writeError(w, http.StatusNotFound, "topology sync is not enabled")
+		return
+	}
+
+	result := s.syncTopology(r.Context(), source)
+	if result.Error != "" {
+		writeJSON(w, http.StatusBadGateway, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// facilityAPI serves body to requests carrying the token.
+func facilityAPI(t *testing.T, token, body string) *httptest.Server {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// TestSyncTopology tests that a sync applies facilities and rooms to the
+// device CSVs and the registry, leaving orgs alone and adding no devices
+func TestSyncTopology(t *testing.T) {
+	dir := t.TempDir()
+	server := setupRegistryTestServer(t, dir, "devices.csv",
+		"device_id,org,room,notes\n"+
+			"device-1,acme,,lobby\n"+
+			"device-2,acme,201,hallway\n"+
+			"device-3,acme,,garage\n")
+	api := facilityAPI(t, "t0ken", `{"devices": [
+		{"device_id": "device-1", "room": "101", "facility": "globex"},
+		{"device_id": "device-2", "room": "201", "facility": "acme"},
+		{"device_id": "device-9", "room": "9", "facility": "acme"},
+		{"device_id": "bad id", "room": "1", "facility": "acme"}
+	]}`)
+
+	result := server.syncTopology(context.Background(), &TopologySource{URL: api.URL, Token: "t0ken", Client: api.Client()})
+	if result.Error != "" {
+		t.Fatalf("expected sync to succeed, got %s", result.Error)
+	}
+	if result.Devices != 4 || result.Updated != 2 || result.Unchanged != 0 || result.Unmapped != 1 ||
+		!slices.Equal(result.Unknown, []string{"device-9"}) || !slices.Equal(result.Skipped, []string{"bad id"}) ||
+		!slices.Equal(result.Files, []string{"devices.csv"}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "devices.csv"))
+	wantFile := "device_id,org,room,notes,facility\n" +
+		"device-1,acme,101,lobby,globex\n" +
+		"device-2,acme,201,hallway,acme\n" +
+		"device-3,acme,,garage,\n"
+	if string(data) != wantFile {
+		t.Errorf("expected file:\n%s\ngot:\n%s", wantFile, data)
+	}
+	device, _ := server.store.Device(t.Context(), "device-1")
+	if device.Org != "acme" || device.Facility != "globex" || device.Room != "101" {
+		t.Errorf("expected device-1 kept in acme, at globex room 101, got org %q facility %q room %q", device.Org, device.Facility, device.Room)
+	}
+	if deviceExists(t, server.store, "device-9") {
+		t.Error("expected the unknown device-9 not to be registered")
+	}
+
+	// Nothing to change writes nothing
+	result = server.syncTopology(context.Background(), &TopologySource{URL: api.URL, Token: "t0ken", Client: api.Client()})
+	if result.Error != "" || result.Updated != 0 || len(result.Files) != 0 {
+		t.Errorf("expected an unchanged second sync, got %+v", result)
+	}
+
+	// A rejected token fails the sync and leaves the files alone
+	result = server.syncTopology(context.Background(), &TopologySource{URL: api.URL, Token: "wrong", Client: api.Client()})
+	if result.Error == "" {
+		t.Error("expected the sync to fail with a rejected token")
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, "devices.csv")); string(after) != wantFile {
+		t.Errorf("expected file unchanged after a failed sync, got:\n%s", after)
+	}
+}
+
+// TestHandleTopology tests the topology status and sync-now endpoint
+func TestHandleTopology(t *testing.T) {
+	dir := t.TempDir()
+	server := setupRegistryTestServer(t, dir, "devices.csv", "device_id,org\ndevice-1,acme\n")
+	router := server.Router()
+
+	do := func(method, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/topology", nil)
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var status TopologyResponse
+	rr := do(http.MethodGet, "")
+	_ = json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusOK || status.Enabled {
+		t.Errorf("expected status 200 and disabled, got %d %+v", rr.Code, status)
+	}
+	if rr := do(http.MethodPost, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 while disabled, got %d", rr.Code)
+	}
+
+	api := facilityAPI(t, "t0ken", `{"devices": [{"device_id": "device-1", "room": "12", "facility": "acme"}]}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.RunTopologySync(ctx, &TopologySource{URL: api.URL, Token: "t0ken", Client: api.Client()}, 1<<40)
+
+	rr = do(http.MethodPost, "")
+	var result TopologySyncResult
+	_ = json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || result.Updated != 1 {
+		t.Errorf("expected status 200 and an updated device, got %d %+v", rr.Code, result)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.Room != "12" {
+		t.Errorf("expected device-1 in room 12, got %q", device.Room)
+	}
+
+	rr = do(http.MethodGet, "")
+	_ = json.NewDecoder(rr.Body).Decode(&status)
+	if !status.Enabled || status.Runs != 2 || status.LastSync == nil {
+		t.Errorf("expected two recorded syncs, got %+v", status)
+	}
+
+	server.EnableAuth(APIKeys{"admin-key": "", "tenant-key": "acme"})
+	if rr := do(http.MethodPost, "tenant-key"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an organization key, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Request correlation. Every request gets an ID, the device's X-Request-ID
+// when it sends a usable one, so device-side logs can be matched with the
+// server's. A W3C traceparent is accepted alongside it. Both are echoed on
+// the response, written on the request's [RESPONSE] log line, and carried to
+// webhook deliveries the request causes, where the traceparent names the
+// server's span as the parent.
+
+const (
+	requestIDHeader   = "X-Request-ID"
+	traceparentHeader = "traceparent"
+
+	// maxRequestIDLength bounds a client-supplied request ID
+	maxRequestIDLength = 128
+)
+
+// requestTrace identifies a request across systems.
+type requestTrace struct {
+	id          string
+	traceparent string // as received; empty if none or malformed
+	spanID      string // the server's span within the trace, when there is one
+}
+
+type traceContextKey struct{}
+
+// traceFromContext returns the trace of the request ctx belongs to, or the
+// zero trace outside a request.
+func traceFromContext(ctx context.Context) requestTrace {
+	trace, _ := ctx.Value(traceContextKey{}).(requestTrace)
+	return trace
+}
+
+// childTraceparent returns the traceparent for a call the request makes:
+// the same trace, with the server's span as parent. Empty without a trace.
+func (t requestTrace) childTraceparent() string {
+	if t.traceparent == "" {
+		return ""
+	}
+	parts := strings.Split(t.traceparent, "-")
+	return parts[0] + "-" + parts[1] + "-" + t.spanID + "-" + parts[3]
+}
+
+// traceRequests assigns each request its trace, echoes the request ID and
+// traceparent on the response, and makes the trace available to handlers.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := requestTrace{id: r.Header.Get(requestIDHeader)}
+		if !validRequestID(trace.id) {
+			trace.id = randomHex(16)
+		}
+		if tp := strings.ToLower(r.Header.Get(traceparentHeader)); validTraceparent(tp) {
+			trace.traceparent, trace.spanID = tp, randomHex(8)
+			w.Header().Set(traceparentHeader, tp)
+		}
+		w.Header().Set(requestIDHeader, trace.id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceContextKey{}, trace)))
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to
+// log and echo: printable ASCII without spaces, and not too long.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// validTraceparent reports whether tp is a version 00 W3C traceparent
+// (version-traceid-parentid-flags, lowercase hex) with non-zero IDs. Later
+// versions may append fields, which this server doesn't understand.
+func validTraceparent(tp string) bool {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return false
+	}
+	for i, size := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != size || strings.Trim(parts[i], "0123456789abcdef") != "" {
+			return false
+		}
+	}
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
+
+// traceLogSuffix returns the request's correlation fields for a log line.
+func traceLogSuffix(ctx context.Context) string {
+	trace := traceFromContext(ctx)
+	if trace.id == "" {
+		return ""
+	}
+	suffix := " request_id=" + trace.id
+	if trace.traceparent != "" {
+		suffix += " traceparent=" + trace.traceparent
+	}
+	return suffix
+}
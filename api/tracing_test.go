@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTraceRequests tests that request IDs and traceparents are echoed, and
+// that a missing or unusable request ID is replaced
+func TestTraceRequests(t *testing.T) {
+	router := setupTestServer().Router()
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	for _, tc := range []struct {
+		name            string
+		requestID       string
+		traceparent     string
+		wantTraceparent string
+	}{
+		{"supplied", "cam-0001-42", traceparent, traceparent},
+		{"generated", "", "", ""},
+		{"unusable ID", "has space", "", ""},
+		{"zero trace ID", "", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"unknown version", "", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/unknown/stats", nil)
+		if tc.requestID != "" {
+			req.Header.Set(requestIDHeader, tc.requestID)
+		}
+		if tc.traceparent != "" {
+			req.Header.Set(traceparentHeader, tc.traceparent)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		id := rr.Header().Get(requestIDHeader)
+		switch {
+		case rr.Code != http.StatusNotFound:
+			t.Errorf("%s: expected status 404, got %d", tc.name, rr.Code)
+		case tc.name == "supplied" && id != tc.requestID:
+			t.Errorf("%s: expected request ID %q echoed, got %q", tc.name, tc.requestID, id)
+		case tc.name != "supplied" && len(id) != 32:
+			t.Errorf("%s: expected a generated request ID, got %q", tc.name, id)
+		case rr.Header().Get(traceparentHeader) != tc.wantTraceparent:
+			t.Errorf("%s: expected traceparent %q, got %q", tc.name, tc.wantTraceparent, rr.Header().Get(traceparentHeader))
+		}
+	}
+}
+
+// TestRequestTrace_ChildTraceparent tests that calls made for a request
+// stay in its trace with the server's span as parent
+func TestRequestTrace_ChildTraceparent(t *testing.T) {
+	var trace requestTrace
+	handler := traceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = traceFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	child := trace.childTraceparent()
+	if !validTraceparent(child) || !strings.HasPrefix(child, "00-4bf92f3577b34da6a3ce929d0e0e4736-") ||
+		strings.Contains(child, "00f067aa0ba902b7") || !strings.HasSuffix(child, "-01") {
+		t.Errorf("expected a child of the incoming trace, got %q", child)
+	}
+	if (requestTrace{id: "x"}).childTraceparent() != "" {
+		t.Error("expected no traceparent without an incoming one")
+	}
+}
+
+// TestWebhooks_Trace tests that deliveries caused by a request carry its
+// request ID and trace
+func TestWebhooks_Trace(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer target.Close()
+
+	server := setupTestServer()
+	router := server.Router()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.RunWebhooks(ctx, 1)
+	createWebhook(t, router, `{"url": "`+target.URL+`", "events": ["registration"], "secret": "s"}`)
+
+	traced := context.WithValue(context.Background(), traceContextKey{}, requestTrace{
+		id:          "cam-0001-42",
+		traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		spanID:      "b7ad6b7169203331",
+	})
+	server.notifyRequestWebhooks(traced, WebhookRegistration, "device-2", time.Now())
+
+	select {
+	case h := <-headers:
+		if h.Get(requestIDHeader) != "cam-0001-42" || h.Get(traceparentHeader) != "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01" {
+			t.Errorf("expected the request's trace on the delivery, got %v", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+	}
+}
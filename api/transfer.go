@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Hardware is redeployed between facilities. A transfer moves a device to
+// another organization, and can restart its aggregates so neither
+// facility's uptime includes the other's time. The old organization's
+// groups and device maintenance windows drop the device. Reloads and
+// restarts still take the org from the device CSV, so it must be updated
+// too.
+
+// How a transfer treats the device's aggregates.
+const (
+	transferKeep  = "keep"  // carried over to the new organization
+	transferReset = "reset" // restarted at the transfer
+	transferSplit = "split" // restarted, with the old totals kept in the transfer record
+)
+
+// Errors for transfers the store refuses.
+var (
+	errTransferRetired    = &validationError{code: errCodeLifecycleTransition, msg: "device is retired and can't be transferred"}
+	errTransferredAtRange = &validationError{code: errCodeTransferredAtRange, field: "transferred_at",
+		msg: "transferred_at must not be in the future or before the device's last counted heartbeat"}
+)
+
+// DeviceTransfer records a device moving between organizations.
+type DeviceTransfer struct {
+	FromOrg       string
+	ToOrg         string
+	TransferredAt time.Time
+	Aggregates    string // keep, reset or split
+
+	// The aggregates left behind, for split transfers
+	Before *TransferTotals `json:",omitempty"`
+}
+
+// TransferTotals are a device's aggregates up to a split transfer.
+type TransferTotals struct {
+	FirstHeartbeat time.Time
+	LastHeartbeat  time.Time
+	HeartbeatCount int64
+	Uptime         float64
+	UploadCount    int64
+	UploadTimeSum  time.Duration
+}
+
+// Transfer moves the device to org at the given time, treating its
+// aggregates as the aggregates mode says. Resetting them also restarts
+// uptime from the transfer, as for an activation. It returns
+// ErrDeviceNotFound, errTransferRetired, or errTransferredAtRange if
+// aggregates restart at a time before the last counted heartbeat.
+func (s *Store) Transfer(ctx context.Context, deviceID, org string, at time.Time, aggregates string) (DeviceTransfer, error) {
+	if err := s.lock(ctx); err != nil {
+		return DeviceTransfer{}, err
+	}
+	defer s.mu.Unlock()
+
+	device, exists := s.devices[deviceID]
+	if !exists {
+		return DeviceTransfer{}, ErrDeviceNotFound
+	}
+	if !device.DecommissionedAt.IsZero() {
+		return DeviceTransfer{}, errTransferRetired
+	}
+
+	transfer := DeviceTransfer{FromOrg: device.Org, ToOrg: org, TransferredAt: at, Aggregates: aggregates}
+	if aggregates != transferKeep {
+		if at.Before(device.LastHeartbeat) {
+			return DeviceTransfer{}, errTransferredAtRange
+		}
+		if aggregates == transferSplit {
+			copied := s.copyDeviceLocked(device)
+			transfer.Before = &TransferTotals{
+				FirstHeartbeat: device.FirstHeartbeat,
+				LastHeartbeat:  device.LastHeartbeat,
+				HeartbeatCount: device.HeartbeatCount,
+				Uptime:         copied.Stats().Uptime,
+				UploadCount:    device.UploadCount,
+				UploadTimeSum:  device.UploadTimeSum,
+			}
+		}
+		resetHeartbeatsLocked(device)
+		device.UploadCount, device.UploadTimeSum = 0, 0
+		device.MinUploadTime, device.MaxUploadTime, device.LastUploadTime = 0, 0, 0
+		device.LastUploadAt, device.MissedUploadWindows = time.Time{}, 0
+		device.ActivatedAt = at
+		delete(s.history, deviceID)
+		delete(s.recentUploads, deviceID)
+	}
+
+	// The old organization's groups and device windows no longer cover it
+	for key, group := range s.groups {
+		if key.org != device.Org {
+			continue
+		}
+		if i, found := slices.BinarySearch(group.DeviceIDs, deviceID); found {
+			group.DeviceIDs = slices.Delete(group.DeviceIDs, i, i+1)
+		}
+	}
+	s.maintenance = slices.DeleteFunc(s.maintenance, func(w MaintenanceWindow) bool {
+		return w.DeviceID == deviceID && w.Org == device.Org
+	})
+
+	device.Org = org
+	// Copies handed out share the old backing array, so never append in place
+	device.Transfers = append(slices.Clip(device.Transfers), transfer)
+	detail := "to " + org
+	if transfer.FromOrg != "" {
+		detail = "from " + transfer.FromOrg + " " + detail
+	}
+	device.addEvent(eventTransferred, at, detail)
+	return transfer, nil
+}
+
+// TransferRequest is the body of POST /api/v1/devices/{device_id}/transfer.
+type TransferRequest struct {
+	Org           string    `json:"org"`
+	TransferredAt time.Time `json:"transferred_at"` // defaults to now
+	Aggregates    string    `json:"aggregates"`     // keep (default), reset or split
+}
+
+// TransferResponse describes a transfer.
+type TransferResponse struct {
+	DeviceID      string                  `json:"device_id,omitempty"`
+	FromOrg       string                  `json:"from_org"`
+	ToOrg         string                  `json:"to_org"`
+	TransferredAt time.Time               `json:"transferred_at"`
+	Aggregates    string                  `json:"aggregates"`
+	Before        *TransferTotalsResponse `json:"before,omitempty"` // split transfers only
+}
+
+// TransferTotalsResponse is the aggregates a split transfer left behind.
+type TransferTotalsResponse struct {
+	FirstHeartbeat time.Time `json:"first_heartbeat,omitzero"`
+	LastHeartbeat  time.Time `json:"last_heartbeat,omitzero"`
+	HeartbeatCount int64     `json:"heartbeat_count"`
+	Uptime         float64   `json:"uptime"`
+	UploadCount    int64     `json:"upload_count"`
+	AvgUploadTime  Duration  `json:"avg_upload_time"`
+}
+
+func newTransferResponse(t DeviceTransfer, format durationFormat) TransferResponse {
+	resp := TransferResponse{FromOrg: t.FromOrg, ToOrg: t.ToOrg, TransferredAt: t.TransferredAt, Aggregates: t.Aggregates}
+	if b := t.Before; b != nil {
+		var avg time.Duration
+		if b.UploadCount > 0 {
+			avg = b.UploadTimeSum / time.Duration(b.UploadCount)
+		}
+		resp.Before = &TransferTotalsResponse{
+			FirstHeartbeat: b.FirstHeartbeat,
+			LastHeartbeat:  b.LastHeartbeat,
+			HeartbeatCount: b.HeartbeatCount,
+			Uptime:         b.Uptime,
+			UploadCount:    b.UploadCount,
+			AvgUploadTime:  format.duration(avg),
+		}
+	}
+	return resp
+}
+
+// knownOrg reports whether an API key is scoped to org, so a device moved
+// there stays reachable. Any org is known when authentication is disabled.
+func (s *Server) knownOrg(org string) bool {
+	s.keysMu.RLock()
+	defer s.keysMu.RUnlock()
+
+	if len(s.apiKeys) == 0 {
+		return true
+	}
+	for _, keyOrg := range s.apiKeys {
+		if keyOrg == org {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleTransfer processes POST /api/v1/devices/{device_id}/transfer
+func (s *Server) HandleTransfer(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] POST /api/v1/devices/%s/transfer", deviceID)
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	device, err := s.visibleDevice(r, deviceID)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	org := device.Org
+
+	var req TransferRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	if req.TransferredAt.IsZero() {
+		req.TransferredAt = now
+	}
+	if req.Aggregates == "" {
+		req.Aggregates = transferKeep
+	}
+	switch {
+	case req.Org == "":
+		writeValidationError(w, &validationError{code: errCodeValidation, field: "org", msg: "org is required"})
+		return
+	case req.Org == org:
+		writeValidationError(w, &validationError{code: errCodeValidation, field: "org", msg: "device already belongs to org"})
+		return
+	case !s.knownOrg(req.Org):
+		writeValidationError(w, &validationError{code: errCodeValidation, field: "org", msg: "no API key is scoped to org"})
+		return
+	case req.Aggregates != transferKeep && req.Aggregates != transferReset && req.Aggregates != transferSplit:
+		writeValidationError(w, &validationError{code: errCodeValidation, field: "aggregates", msg: "aggregates must be keep, reset or split"})
+		return
+	case req.TransferredAt.After(now):
+		writeValidationError(w, errTransferredAtRange)
+		return
+	}
+
+	// The device quota is soft: concurrent transfers and enrollments may both pass it
+	if limit := s.usage.quota(req.Org).MaxDevices; limit > 0 && device.DecommissionedAt.IsZero() {
+		devices, err := s.monitoredDevices(r.Context(), req.Org)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if devices >= limit {
+			log.Printf("[WARN] Rejected transfer of %s: org %q is at its device quota of %d", deviceID, req.Org, limit)
+			writeQuotaExceeded(w, errDeviceQuota, time.Now())
+			return
+		}
+	}
+
+	transfer, err := s.store.Transfer(r.Context(), deviceID, req.Org, req.TransferredAt.UTC(), req.Aggregates)
+	switch {
+	case errors.Is(err, errTransferRetired):
+		writeErrorCode(w, http.StatusConflict, errCodeLifecycleTransition, err.Error())
+		return
+	case errors.Is(err, errTransferredAtRange):
+		writeValidationError(w, err)
+		return
+	case err != nil:
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	log.Printf("[INFO] Device %s transferred from %q to %q (%s)", deviceID, transfer.FromOrg, transfer.ToOrg, transfer.Aggregates)
+	resp := newTransferResponse(transfer, format)
+	resp.DeviceID = deviceID
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStore_Transfer tests that a split transfer restarts the aggregates and
+// leaves the old organization's groups and device windows behind
+func TestStore_Transfer(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera", Org: "org-a", HeartbeatInterval: time.Minute}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		s.RecordHeartbeat(t.Context(), "camera", t1.Add(time.Duration(i)*time.Minute))
+	}
+	s.RecordUploadStatAt(t.Context(), "camera", 4*time.Second, t1)
+	s.RecordUploadStatAt(t.Context(), "camera", 6*time.Second, t1)
+	s.CreateGroup(t.Context(), Group{Name: "lobby", Org: "org-a", DeviceIDs: []string{"camera"}})
+	s.AddMaintenance(t.Context(), MaintenanceWindow{Org: "org-a", DeviceID: "camera", Start: t1.Add(time.Hour), End: t1.Add(2 * time.Hour)})
+	s.AddMaintenance(t.Context(), MaintenanceWindow{Org: "org-a", Start: t1.Add(time.Hour), End: t1.Add(2 * time.Hour)})
+
+	at := t1.Add(30 * time.Minute)
+	if _, err := s.Transfer(t.Context(), "camera", "org-b", t1.Add(5*time.Minute), transferReset); !errors.Is(err, errTransferredAtRange) {
+		t.Errorf("expected errTransferredAtRange before the last heartbeat, got %v", err)
+	}
+	transfer, err := s.Transfer(t.Context(), "camera", "org-b", at, transferSplit)
+	if err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	before := transfer.Before
+	if before == nil || before.HeartbeatCount != 10 || before.Uptime != 100 || before.UploadCount != 2 || before.UploadTimeSum != 10*time.Second {
+		t.Errorf("expected the old totals in the record, got %+v", before)
+	}
+
+	device, _ := s.Device(t.Context(), "camera")
+	if device.Org != "org-b" || device.HeartbeatCount != 0 || device.UploadCount != 0 || !device.ActivatedAt.Equal(at) {
+		t.Errorf("expected restarted aggregates in org-b, got %+v", device)
+	}
+	if len(device.Transfers) != 1 || device.Transfers[0].FromOrg != "org-a" {
+		t.Errorf("expected the transfer recorded on the device, got %+v", device.Transfers)
+	}
+	if buckets, _, _ := s.History(t.Context(), "camera", t1.Add(-time.Hour), at); len(buckets) != 0 {
+		t.Error("expected the old organization's history dropped")
+	}
+	if group, _ := s.GetGroup(t.Context(), "org-a", "lobby"); len(group.DeviceIDs) != 0 {
+		t.Errorf("expected camera removed from org-a's group, got %v", group.DeviceIDs)
+	}
+	if windows, _ := s.ListMaintenance(t.Context(), "org-a", ""); len(windows) != 1 || windows[0].DeviceID != "" {
+		t.Errorf("expected only org-a's org-wide window left, got %+v", windows)
+	}
+
+	// Heartbeats before the transfer no longer count
+	s.RecordHeartbeat(t.Context(), "camera", at.Add(-time.Minute))
+	s.RecordHeartbeat(t.Context(), "camera", at.Add(time.Minute))
+	if device, _ := s.Device(t.Context(), "camera"); device.HeartbeatCount != 1 {
+		t.Errorf("expected only the heartbeat after the transfer counted, got %d", device.HeartbeatCount)
+	}
+
+	s.devices["camera"].DecommissionedAt = at.Add(time.Hour)
+	if _, err := s.Transfer(t.Context(), "camera", "org-a", at.Add(2*time.Hour), transferKeep); !errors.Is(err, errTransferRetired) {
+		t.Errorf("expected errTransferRetired, got %v", err)
+	}
+}
+
+// TestHandleTransfer tests that a transferred device moves to the new
+// organization's API key
+func TestHandleTransfer(t *testing.T) {
+	server := setupAuthTestServer()
+	router := server.Router()
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(apiKeyHeader, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, tc := range []struct {
+		name, key, body string
+		want            int
+	}{
+		{"missing org", "key-a", `{}`, http.StatusBadRequest},
+		{"same org", "key-a", `{"org":"org-a"}`, http.StatusBadRequest},
+		{"org without a key", "key-a", `{"org":"org-c"}`, http.StatusBadRequest},
+		{"unknown mode", "key-a", `{"org":"org-b","aggregates":"merge"}`, http.StatusBadRequest},
+		{"future time", "key-a", `{"org":"org-b","transferred_at":"2999-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"another org's device", "key-b", `{"org":"org-b"}`, http.StatusNotFound},
+	} {
+		if rr := do(http.MethodPost, "/api/v1/devices/device-a/transfer", tc.key, tc.body); rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := do(http.MethodPost, "/api/v1/devices/device-a/transfer", "key-a", `{"org":"org-b","aggregates":"split"}`)
+	var resp TransferResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %+v (%v)", rr.Code, resp, err)
+	}
+	if resp.FromOrg != "org-a" || resp.ToOrg != "org-b" || resp.Aggregates != transferSplit || resp.Before == nil {
+		t.Errorf("unexpected transfer %+v", resp)
+	}
+
+	if rr := do(http.MethodGet, "/api/v1/devices/device-a", "key-a", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the old org to lose the device, got %d", rr.Code)
+	}
+	rr = do(http.MethodGet, "/api/v1/devices/device-a", "key-b", "")
+	var counters DeviceCountersResponse
+	if err := json.NewDecoder(rr.Body).Decode(&counters); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d (%v)", rr.Code, err)
+	}
+	if counters.Org != "org-b" || len(counters.Transfers) != 1 {
+		t.Errorf("expected the device in org-b with its transfer listed, got %+v", counters)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// trendWindow is the span compared for stats trends: the last complete 24
+// hours against the 24 before them.
+const trendWindow = 24 * time.Hour
+
+// statsTrend is the change in a device's uptime and average upload time
+// between the previous trend window and the current one.
+type statsTrend struct {
+	uptimeDelta    float64 // percentage points; positive is improving
+	hasUptime      bool
+	avgUploadDelta time.Duration // positive is slowing down
+	hasAvgUpload   bool
+}
+
+// windowTotals sums the history buckets of one trend window.
+type windowTotals struct {
+	heartbeats, uploads int64
+	uploadTimeSum       time.Duration
+}
+
+// statsTrend compares the two most recent complete trend windows from the
+// device's hourly history. Uptime is only compared once the device had been
+// reporting for the whole previous window, since a device enrolled part-way
+// through would otherwise look like it recovered. Upload time is compared
+// when both windows saw uploads.
+func (s *Server) statsTrend(ctx context.Context, device DeviceStats, now time.Time) (statsTrend, error) {
+	to := now.UTC().Truncate(historyBucketSize)
+	from := to.Add(-2 * trendWindow)
+
+	buckets, interval, err := s.store.History(ctx, device.ID, from, to)
+	if err != nil {
+		return statsTrend{}, err
+	}
+	var prev, cur windowTotals
+	for _, b := range buckets {
+		w := &cur
+		if b.Start.Before(to.Add(-trendWindow)) {
+			w = &prev
+		}
+		w.heartbeats += observedHeartbeats(b.HeartbeatCount, b.coveredMinutes(), interval)
+		w.uploads += b.UploadCount
+		w.uploadTimeSum += b.UploadTimeSum
+	}
+
+	var trend statsTrend
+	if !device.FirstHeartbeat.IsZero() && !device.FirstHeartbeat.After(from) {
+		// Maintenance expects no heartbeats; a window that was all
+		// maintenance has no uptime to compare
+		uptime := func(start time.Time, w windowTotals) (float64, bool) {
+			measured := trendWindow - device.maintenance.overlap(start, start.Add(trendWindow))
+			if measured <= 0 {
+				return 0, false
+			}
+			return min(float64(w.heartbeats)/(float64(measured)/float64(interval))*100, 100.0), true
+		}
+		prevUptime, okPrev := uptime(from, prev)
+		curUptime, okCur := uptime(from.Add(trendWindow), cur)
+		if okPrev && okCur {
+			trend.uptimeDelta = roundTo(curUptime-prevUptime, 3)
+			trend.hasUptime = true
+		}
+	}
+	if prev.uploads > 0 && cur.uploads > 0 {
+		trend.avgUploadDelta = cur.uploadTimeSum/time.Duration(cur.uploads) - prev.uploadTimeSum/time.Duration(prev.uploads)
+		trend.hasAvgUpload = true
+	}
+	return trend, nil
+}
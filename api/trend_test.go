@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStatsTrend tests uptime and upload time deltas between windows
+func TestStatsTrend(t *testing.T) {
+	server := setupTestServer()
+	now := time.Now().UTC().Truncate(time.Hour)
+	start := now.Add(-2 * trendWindow)
+
+	// Previous window fully up; current window up for only half of each hour
+	counts := make([]int, 48)
+	for h := range counts {
+		counts[h] = 60
+		if h >= 24 {
+			counts[h] = 30
+		}
+	}
+	recordHours(server.store, "device-1", start, counts...)
+	server.store.RecordUploadStatAt(t.Context(), "device-1", 2*time.Second, start.Add(time.Hour))
+	server.store.RecordUploadStatAt(t.Context(), "device-1", 3*time.Second, now.Add(-time.Hour))
+	server.store.RecordUploadStatAt(t.Context(), "device-1", 4*time.Second, now.Add(-time.Hour))
+
+	device, _ := server.store.Device(t.Context(), "device-1")
+	trend, err := server.statsTrend(t.Context(), device, now.Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("statsTrend failed: %v", err)
+	}
+
+	if !trend.hasUptime || trend.uptimeDelta != -50 {
+		t.Errorf("expected uptime delta -50, got %v (has=%v)", trend.uptimeDelta, trend.hasUptime)
+	}
+	if !trend.hasAvgUpload || trend.avgUploadDelta != 1500*time.Millisecond {
+		t.Errorf("expected upload delta 1.5s, got %v (has=%v)", trend.avgUploadDelta, trend.hasAvgUpload)
+	}
+}
+
+// TestGetStats_TrendOmittedForNewDevice tests that a device without a full
+// previous window gets no trend fields
+func TestGetStats_TrendOmittedForNewDevice(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	recordHours(server.store, "device-1", now.Add(-3*time.Hour), 60, 60)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp StatsResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.UptimeDelta != nil || resp.AvgUploadTimeDelta != nil {
+		t.Errorf("expected no trend fields, got %+v / %q", resp.UptimeDelta, resp.AvgUploadTimeDelta)
+	}
+}
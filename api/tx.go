@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The memory backend's transactions stage events in the Tx and apply them
+// under one acquisition of the store lock, after checking that every event
+// can be applied. Nothing is locked while events are staged, so a slow
+// client streaming a batch doesn't hold up other writers.
+
+// errTxDone is returned when a transaction that already ended is committed.
+var errTxDone = errors.New("transaction already committed or rolled back")
+
+// storeTx is a transaction on the memory backend.
+type storeTx struct {
+	store  *Store
+	events []TelemetryEvent
+	done   bool
+}
+
+// Begin starts a transaction.
+func (s *Store) Begin(ctx context.Context) (Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &storeTx{store: s}, nil
+}
+
+func (tx *storeTx) Stage(events ...TelemetryEvent) {
+	tx.events = append(tx.events, events...)
+}
+
+// Commit applies the staged events if every device they're for is still
+// registered and not decommissioned.
+func (tx *storeTx) Commit(ctx context.Context) error {
+	if tx.done {
+		return errTxDone
+	}
+	tx.done = true
+
+	s := tx.store
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	for _, ev := range tx.events {
+		device, exists := s.devices[ev.DeviceID]
+		if !exists {
+			return fmt.Errorf("device %s: %w", ev.DeviceID, ErrDeviceNotFound)
+		}
+		if !device.DecommissionedAt.IsZero() {
+			return fmt.Errorf("device %s: %w", ev.DeviceID, ErrDeviceDecommissioned)
+		}
+	}
+	s.applyBatchLocked(tx.events)
+	return nil
+}
+
+func (tx *storeTx) Rollback() error {
+	tx.done = true
+	tx.events = nil
+	return nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestStoreTx tests committing, rolling back and conflicting transactions
+func TestStoreTx(t *testing.T) {
+	store := NewStore()
+	store.AddDevice(t.Context(), DeviceStats{ID: "cam-1"})
+	store.AddDevice(t.Context(), DeviceStats{ID: "cam-2"})
+	at := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	heartbeat := func(deviceID string) TelemetryEvent {
+		return TelemetryEvent{DeviceID: deviceID, Heartbeat: true, At: at}
+	}
+
+	tx, err := store.Begin(t.Context())
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	tx.Stage(heartbeat("cam-1"))
+	tx.Rollback()
+	if err := tx.Commit(t.Context()); !errors.Is(err, errTxDone) {
+		t.Errorf("expected errTxDone after rollback, got %v", err)
+	}
+	if store.devices["cam-1"].HeartbeatCount != 0 {
+		t.Error("expected a rolled back event not to be applied")
+	}
+
+	// A device decommissioned after its event was staged fails the whole
+	// transaction
+	tx, _ = store.Begin(t.Context())
+	tx.Stage(heartbeat("cam-1"), heartbeat("cam-2"))
+	store.Decommission(t.Context(), "cam-2", at)
+	if err := tx.Commit(t.Context()); !errors.Is(err, ErrDeviceDecommissioned) {
+		t.Errorf("expected ErrDeviceDecommissioned, got %v", err)
+	}
+	if store.devices["cam-1"].HeartbeatCount != 0 {
+		t.Error("expected no event applied from a failed commit")
+	}
+
+	tx, _ = store.Begin(t.Context())
+	tx.Stage(heartbeat("cam-1"))
+	if err := tx.Commit(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx.Rollback()
+	if store.devices["cam-1"].HeartbeatCount != 1 {
+		t.Errorf("expected the committed event applied once, got %d", store.devices["cam-1"].HeartbeatCount)
+	}
+}
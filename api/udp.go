@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// A UDP heartbeat listener for battery-powered sensors that can't afford a
+// TCP handshake and HTTP headers per heartbeat. Each datagram is one
+// heartbeat; nothing is sent back.
+//
+// Packet layout (big-endian):
+//
+//	byte 0           version (1)
+//	byte 1           device ID length n (1..255)
+//	bytes 2..2+n     device ID
+//	next 8 bytes     sent_at, Unix milliseconds
+//	last 16 bytes    HMAC-SHA256 of everything before it, truncated
+//
+// Each device signs with its own key, derived as HMAC-SHA256(secret, device
+// ID), so the server only holds one secret and a leaked device key can't
+// forge heartbeats for other devices.
+
+const (
+	udpHeartbeatVersion = 1
+	udpHeartbeatMACSize = 16
+	udpHeartbeatMinSize = 2 + 1 + 8 + udpHeartbeatMACSize
+)
+
+var (
+	errUDPMalformed = errors.New("malformed heartbeat packet")
+	errUDPVersion   = errors.New("unsupported heartbeat packet version")
+	errUDPSignature = errors.New("invalid heartbeat signature")
+	errUDPReplay    = errors.New("heartbeat not newer than the last one accepted")
+)
+
+// UDPHeartbeatListener verifies heartbeat datagrams and records them through
+// the server, so they get the same validation and write path as HTTP ones.
+type UDPHeartbeatListener struct {
+	server *Server
+	secret []byte
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time // protected by mu; last accepted sent_at per device
+}
+
+// NewUDPHeartbeatListener creates a listener verifying packets with keys
+// derived from secret.
+func NewUDPHeartbeatListener(server *Server, secret []byte) *UDPHeartbeatListener {
+	return &UDPHeartbeatListener{server: server, secret: secret, lastSent: make(map[string]time.Time)}
+}
+
+// deriveDeviceKey derives a device's signing key from a server secret.
+func deriveDeviceKey(secret []byte, deviceID string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(deviceID))
+	return mac.Sum(nil)
+}
+
+// Serve records heartbeats received on conn until it is closed, returning
+// nil once it is.
+func (l *UDPHeartbeatListener) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		source := ""
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			source = udpAddr.AddrPort().Addr().Unmap().String()
+		}
+		if err := l.handle(buf[:n], source, time.Now()); err != nil {
+			log.Printf("[WARN] Dropped UDP heartbeat from %s: %v", addr, err)
+		}
+	}
+}
+
+// handle verifies and records one heartbeat packet sent from source.
+func (l *UDPHeartbeatListener) handle(packet []byte, source string, now time.Time) error {
+	if len(packet) < udpHeartbeatMinSize {
+		return errUDPMalformed
+	}
+	if packet[0] != udpHeartbeatVersion {
+		return errUDPVersion
+	}
+	idLen := int(packet[1])
+	if idLen == 0 || len(packet) != 2+idLen+8+udpHeartbeatMACSize {
+		return errUDPMalformed
+	}
+	deviceID := string(packet[2 : 2+idLen])
+	signed := packet[:2+idLen+8]
+	sentAt := time.UnixMilli(int64(binary.BigEndian.Uint64(packet[2+idLen:]))).UTC()
+
+	// Verify before anything else, so unsigned packets can't probe which devices exist
+	mac := hmac.New(sha256.New, deriveDeviceKey(l.secret, deviceID))
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil)[:udpHeartbeatMACSize], packet[len(signed):]) {
+		return errUDPSignature
+	}
+
+	s := l.server
+	if s.configError() != nil || s.Standby() || s.Loading() {
+		return errors.New("server not accepting telemetry")
+	}
+	// Telemetry counts toward the organization's daily quota, as over HTTP
+	if err := s.admitTelemetry(context.Background(), deviceID, now); err != nil {
+		return err
+	}
+	device, err := s.store.Device(context.Background(), deviceID)
+	if err != nil {
+		return err
+	}
+	if !device.DecommissionedAt.IsZero() {
+		return ErrDeviceDecommissioned
+	}
+	req := HeartbeatRequest{SentAt: sentAt}
+	if err := validateHeartbeatRequest(&req, s.validation, now); err != nil {
+		return err
+	}
+
+	// A captured packet stays validly signed, so only strictly newer
+	// timestamps are accepted to stop replays inflating uptime. After a
+	// restart the stored last heartbeat stands in until the first packet.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last, ok := l.lastSent[deviceID]
+	if !ok {
+		last = device.LastHeartbeat
+	}
+	if !sentAt.After(last) {
+		return errUDPReplay
+	}
+	if err := s.recordHeartbeat(context.Background(), deviceID, source, now, &req); err != nil {
+		return err
+	}
+	l.lastSent[deviceID] = sentAt
+	return nil
+}
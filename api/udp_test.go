@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var testUDPSecret = []byte("udp-test-secret")
+
+// encodeUDPHeartbeat builds a packet the way a device would.
+func encodeUDPHeartbeat(secret []byte, deviceID string, sentAt time.Time) []byte {
+	packet := []byte{udpHeartbeatVersion, byte(len(deviceID))}
+	packet = append(packet, deviceID...)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(sentAt.UnixMilli()))
+	mac := hmac.New(sha256.New, deriveDeviceKey(secret, deviceID))
+	mac.Write(packet)
+	return append(packet, mac.Sum(nil)[:udpHeartbeatMACSize]...)
+}
+
+// TestUDPHeartbeat_Accepted tests that a signed packet is recorded
+func TestUDPHeartbeat_Accepted(t *testing.T) {
+	server := setupTestServer()
+	listener := NewUDPHeartbeatListener(server, testUDPSecret)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	if err := listener.handle(encodeUDPHeartbeat(testUDPSecret, "device-1", now), "10.20.0.7", now); err != nil {
+		t.Fatalf("expected packet accepted, got %v", err)
+	}
+
+	device, _ := server.store.Device(t.Context(), "device-1")
+	if device.HeartbeatCount != 1 || !device.LastHeartbeat.Equal(now) {
+		t.Errorf("expected one heartbeat at %v, got %d at %v", now, device.HeartbeatCount, device.LastHeartbeat)
+	}
+	if device.SourceIP != "10.20.0.7" {
+		t.Errorf("expected source 10.20.0.7, got %q", device.SourceIP)
+	}
+}
+
+// TestUDPHeartbeat_Rejected tests packets that must not be recorded
+func TestUDPHeartbeat_Rejected(t *testing.T) {
+	now := time.Now().UTC()
+	valid := encodeUDPHeartbeat(testUDPSecret, "device-1", now)
+
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-udpHeartbeatMACSize-1] ^= 0x01 // shift the timestamp
+
+	// A device key for device-2 can't sign for device-1
+	forged := []byte{udpHeartbeatVersion, byte(len("device-1"))}
+	forged = append(forged, "device-1"...)
+	forged = binary.BigEndian.AppendUint64(forged, uint64(now.UnixMilli()))
+	mac := hmac.New(sha256.New, deriveDeviceKey(testUDPSecret, "device-2"))
+	mac.Write(forged)
+	forged = append(forged, mac.Sum(nil)[:udpHeartbeatMACSize]...)
+
+	badVersion := append([]byte(nil), valid...)
+	badVersion[0] = 2
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"truncated", valid[:10], errUDPMalformed},
+		{"bad version", badVersion, errUDPVersion},
+		{"tampered", tampered, errUDPSignature},
+		{"wrong device key", forged, errUDPSignature},
+		{"wrong secret", encodeUDPHeartbeat([]byte("other"), "device-1", now), errUDPSignature},
+		{"unknown device", encodeUDPHeartbeat(testUDPSecret, "nope", now), ErrDeviceNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := setupTestServer()
+			listener := NewUDPHeartbeatListener(server, testUDPSecret)
+
+			if err := listener.handle(tt.packet, "10.20.0.7", now); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+			if server.backend().(*Store).devices["device-1"].HeartbeatCount != 0 {
+				t.Error("expected no heartbeat recorded")
+			}
+		})
+	}
+}
+
+// TestUDPHeartbeat_Replay tests that a captured packet can't be resent
+func TestUDPHeartbeat_Replay(t *testing.T) {
+	server := setupTestServer()
+	listener := NewUDPHeartbeatListener(server, testUDPSecret)
+	now := time.Now().UTC()
+	packet := encodeUDPHeartbeat(testUDPSecret, "device-1", now)
+
+	if err := listener.handle(packet, "10.20.0.7", now); err != nil {
+		t.Fatalf("expected first packet accepted, got %v", err)
+	}
+	if err := listener.handle(packet, "10.20.0.7", now); !errors.Is(err, errUDPReplay) {
+		t.Errorf("expected replay rejected, got %v", err)
+	}
+
+	// A fresh listener, as after a restart, falls back to the stored last heartbeat
+	restarted := NewUDPHeartbeatListener(server, testUDPSecret)
+	if err := restarted.handle(packet, "10.20.0.7", now); !errors.Is(err, errUDPReplay) {
+		t.Errorf("expected replay after restart rejected, got %v", err)
+	}
+}
+
+// TestUDPHeartbeat_Quota tests that UDP heartbeats count toward the daily quota
+func TestUDPHeartbeat_Quota(t *testing.T) {
+	server := setupAuthTestServer()
+	server.SetQuotas(Quotas{"org-a": {MaxDailyRequests: 1}})
+	listener := NewUDPHeartbeatListener(server, testUDPSecret)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	if err := listener.handle(encodeUDPHeartbeat(testUDPSecret, "device-a", now.Add(-time.Second)), "10.20.0.7", now); err != nil {
+		t.Fatalf("expected the first heartbeat accepted, got %v", err)
+	}
+	if err := listener.handle(encodeUDPHeartbeat(testUDPSecret, "device-a", now), "10.20.0.7", now); !errors.Is(err, errRequestQuota) {
+		t.Errorf("expected the second heartbeat over quota, got %v", err)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-a"); device.HeartbeatCount != 1 {
+		t.Errorf("expected 1 heartbeat recorded, got %d", device.HeartbeatCount)
+	}
+}
+
+// TestUDPHeartbeat_Serve tests receiving a packet over a real socket
+func TestUDPHeartbeat_Serve(t *testing.T) {
+	server := setupTestServer()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- NewUDPHeartbeatListener(server, testUDPSecret).Serve(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	if _, err := client.Write(encodeUDPHeartbeat(testUDPSecret, "device-2", time.Now())); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if device, _ := server.store.Device(t.Context(), "device-2"); device.HeartbeatCount == 1 {
+			if device.SourceIP != "127.0.0.1" {
+				t.Errorf("expected source 127.0.0.1, got %q", device.SourceIP)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = conn.Close()
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return nil once closed, got %v", err)
+	}
+}
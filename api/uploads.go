@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Per-upload records for debugging individual slow transfers. Aggregates
+// hide which upload was slow; devices may tag each upload stat with an
+// upload_id (correlating it with the device's own logs) and a file_type,
+// and the most recent ones are kept per device.
+
+// DefaultRecentUploads is how many upload records are kept per device.
+const DefaultRecentUploads = 50
+
+// Longest accepted upload_id and file_type.
+const (
+	maxUploadIDLength = 128
+	maxFileTypeLength = 32
+)
+
+// UploadRecord is one upload stat as received.
+type UploadRecord struct {
+	UploadID   string
+	FileType   string
+	UploadTime time.Duration
+	At         time.Time // sent_at, or ReceivedAt if the device didn't send one
+	ReceivedAt time.Time
+}
+
+// uploadRing keeps a device's most recent upload records.
+type uploadRing struct {
+	records []UploadRecord
+	next    int       // index the next record is written to once records is full
+	latest  time.Time // when the newest record was received; the least recent ring is evicted first
+}
+
+func (r *uploadRing) add(rec UploadRecord, capacity int) {
+	if len(r.records) < capacity {
+		r.records = append(r.records, rec)
+		return
+	}
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % capacity
+}
+
+// newestFirst returns up to limit records, most recent first.
+func (r *uploadRing) newestFirst(limit int) []UploadRecord {
+	n := min(limit, len(r.records))
+	result := make([]UploadRecord, n)
+	for i := range n {
+		idx := (r.next - 1 - i + 2*len(r.records)) % len(r.records)
+		result[i] = r.records[idx]
+	}
+	return result
+}
+
+// SetRecentUploadCapacity sets how many upload records are kept per device;
+// zero disables them. Records already kept are discarded.
+func (s *Store) SetRecentUploadCapacity(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recentUploadCap = max(n, 0)
+	clear(s.recentUploads)
+}
+
+// RecentUploads returns up to limit of the device's most recent upload
+// records, newest first.
+func (s *Store) RecentUploads(ctx context.Context, deviceID string, limit int) ([]UploadRecord, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	if _, exists := s.devices[deviceID]; !exists {
+		return nil, ErrDeviceNotFound
+	}
+	ring, exists := s.recentUploads[deviceID]
+	if !exists {
+		return []UploadRecord{}, nil
+	}
+	return ring.newestFirst(limit), nil
+}
+
+// recordUploadLocked keeps rec in the device's ring, evicting another
+// device's ring if the fleet is at its cap.
+// Callers must hold s.mu for writing.
+func (s *Store) recordUploadLocked(deviceID string, rec UploadRecord) {
+	if s.recentUploadCap == 0 {
+		return
+	}
+	ring, exists := s.recentUploads[deviceID]
+	if !exists {
+		if s.limits.UploadDevices > 0 && len(s.recentUploads) >= s.limits.UploadDevices {
+			s.evictUploadsLocked(lowWater(s.limits.UploadDevices))
+		}
+		ring = &uploadRing{}
+		s.recentUploads[deviceID] = ring
+	}
+	ring.add(rec, s.recentUploadCap)
+	ring.latest = rec.ReceivedAt
+}
+
+// validateUploadLabels checks the optional upload_id and file_type.
+func validateUploadLabels(uploadID, fileType string) error {
+	if len(uploadID) > maxUploadIDLength {
+		return &validationError{code: errCodeLabelTooLong, field: "upload_id", msg: "upload_id exceeds maximum length"}
+	}
+	if len(fileType) > maxFileTypeLength {
+		return &validationError{code: errCodeLabelTooLong, field: "file_type", msg: "file_type exceeds maximum length"}
+	}
+	return nil
+}
+
+// UploadRecordResponse is one entry of GET /uploads/recent.
+type UploadRecordResponse struct {
+	UploadID   string    `json:"upload_id,omitempty"`
+	FileType   string    `json:"file_type,omitempty"`
+	UploadTime Duration  `json:"upload_time"`
+	At         time.Time `json:"at"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// RecentUploadsResponse lists a device's most recent uploads, newest first.
+type RecentUploadsResponse struct {
+	DeviceID string                 `json:"device_id"`
+	Uploads  []UploadRecordResponse `json:"uploads"`
+}
+
+// HandleRecentUploads processes GET /api/v1/devices/{device_id}/uploads/recent
+func (s *Server) HandleRecentUploads(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	deviceID := extractDeviceID(r.URL.Path)
+	log.Printf("[REQUEST] GET /api/v1/devices/%s/uploads/recent", deviceID)
+
+	format, msg := s.parseDurationFormat(r)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	limit := maxPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	if err := s.checkDeviceVisible(r, deviceID); err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+	records, err := s.store.RecentUploads(r.Context(), deviceID, limit)
+	if err != nil {
+		writeDeviceError(w, deviceID, err)
+		return
+	}
+
+	resp := RecentUploadsResponse{DeviceID: deviceID, Uploads: make([]UploadRecordResponse, len(records))}
+	for i, rec := range records {
+		resp.Uploads[i] = UploadRecordResponse{
+			UploadID:   rec.UploadID,
+			FileType:   rec.FileType,
+			UploadTime: format.duration(rec.UploadTime),
+			At:         rec.At,
+			ReceivedAt: rec.ReceivedAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUploadRing tests that the ring keeps the newest records, newest first
+func TestUploadRing(t *testing.T) {
+	var ring uploadRing
+	for i := range 5 {
+		ring.add(UploadRecord{UploadID: fmt.Sprint(i)}, 3)
+	}
+
+	var got []string
+	for _, rec := range ring.newestFirst(10) {
+		got = append(got, rec.UploadID)
+	}
+	if strings.Join(got, ",") != "4,3,2" {
+		t.Errorf("expected 4,3,2, got %v", got)
+	}
+	if recs := ring.newestFirst(1); len(recs) != 1 || recs[0].UploadID != "4" {
+		t.Errorf("expected only the newest record, got %+v", recs)
+	}
+}
+
+// TestStore_RecentUploadsDisabled tests that a zero capacity keeps no records
+func TestStore_RecentUploadsDisabled(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	s.SetRecentUploadCapacity(0)
+	s.RecordUploadStat(t.Context(), "device-1", time.Second)
+
+	recs, err := s.RecentUploads(t.Context(), "device-1", 10)
+	if err != nil || len(recs) != 0 {
+		t.Errorf("expected no records, got %+v", recs)
+	}
+	if device, _ := s.Device(t.Context(), "device-1"); device.UploadCount != 1 {
+		t.Error("expected aggregates still updated")
+	}
+}
+
+// TestRecentUploads tests recording upload IDs and file types and listing them
+func TestRecentUploads(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/stats", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	post(`{"sent_at": "2024-01-15T10:00:00Z", "upload_time": 5000000000, "upload_id": "up-1", "file_type": "clip"}`)
+	post(`{"upload_time": 90000000000, "upload_id": "up-2", "file_type": "snapshot"}`)
+	if code := post(`{"upload_time": 1, "upload_id": "` + strings.Repeat("x", maxUploadIDLength+1) + `"}`); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a long upload_id, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/uploads/recent?format=seconds", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp struct {
+		Uploads []struct {
+			UploadID   string    `json:"upload_id"`
+			FileType   string    `json:"file_type"`
+			UploadTime float64   `json:"upload_time"`
+			At         time.Time `json:"at"`
+			ReceivedAt time.Time `json:"received_at"`
+		} `json:"uploads"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %+v", resp.Uploads)
+	}
+	newest, oldest := resp.Uploads[0], resp.Uploads[1]
+	if newest.UploadID != "up-2" || newest.FileType != "snapshot" || newest.UploadTime != 90 || newest.At.IsZero() || newest.ReceivedAt.IsZero() {
+		t.Errorf("unexpected newest upload %+v", newest)
+	}
+	if oldest.UploadID != "up-1" || !oldest.At.Equal(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected oldest upload %+v", oldest)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/devices/unknown/uploads/recent", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown device, got %d", rr.Code)
+	}
+}
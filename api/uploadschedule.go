@@ -0,0 +1,98 @@
+package api
+
+import (
+	"log"
+	"time"
+)
+
+// Devices can declare how often they're expected to upload, with the CSV's
+// upload_interval column or upload_interval in their heartbeats (e.g. one
+// video an hour). Missed upload windows are counted, and the offline monitor
+// alerts when a device keeps heartbeating but stops uploading, which usually
+// means the camera's disk is full.
+
+// stalledUploadWindows is how many expected uploads in a row a heartbeating
+// device may miss before the monitor alerts, so one late upload isn't a stall.
+const stalledUploadWindows = 2
+
+// recordUploadGap counts the expected uploads missing between the previous
+// upload and one at at. An upload older than the latest counts no gap.
+func (device *DeviceStats) recordUploadGap(at time.Time) {
+	if !at.After(device.LastUploadAt) {
+		return
+	}
+	if device.UploadInterval > 0 && !device.LastUploadAt.IsZero() {
+		if windows := int64(at.Sub(device.LastUploadAt) / device.UploadInterval); windows > 1 {
+			device.MissedUploadWindows += windows - 1
+		}
+	}
+	device.LastUploadAt = at
+}
+
+// overdueUploads returns how many expected uploads the device has missed
+// since its last upload, or since it started heartbeating if it never
+// uploaded. Activation and the end of maintenance restart the count.
+func (device *DeviceStats) overdueUploads(now time.Time) int64 {
+	if device.UploadInterval <= 0 || device.FirstHeartbeat.IsZero() {
+		return 0
+	}
+	since := maxTime(maxTime(device.LastUploadAt, device.FirstHeartbeat), maxTime(device.ActivatedAt, device.maintenance.lastEnd(now)))
+	if !now.After(since) {
+		return 0
+	}
+	return int64(now.Sub(since) / device.UploadInterval)
+}
+
+// uploadsStalled reports whether the device has missed enough expected
+// uploads to alert on. Whether it's still heartbeating is up to the caller.
+func (device *DeviceStats) uploadsStalled(now time.Time) bool {
+	return device.DecommissionedAt.IsZero() && device.overdueUploads(now) >= stalledUploadWindows
+}
+
+// checkUploads alerts when the device's uploads stall, and again when they
+// resume. Callers skip devices that are offline, since the offline alert
+// already covers them.
+func (m *OfflineMonitor) checkUploads(device DeviceStats, now time.Time) {
+	stalled := device.uploadsStalled(now)
+	switch {
+	case stalled && !m.uploadsStalled[device.ID]:
+		m.uploadsStalled[device.ID] = true
+		log.Printf("[ALERT] Device %s uploads stalled: %d expected uploads missed while heartbeating (expected every %v)",
+			device.ID, device.overdueUploads(now), device.UploadInterval)
+		if m.Notify != nil {
+			m.Notify(WebhookUploadsStalled, device.ID, now)
+		}
+	case !stalled && m.uploadsStalled[device.ID]:
+		delete(m.uploadsStalled, device.ID)
+		log.Printf("[INFO] Device %s uploading again", device.ID)
+		if m.Notify != nil {
+			m.Notify(WebhookUploadsResumed, device.ID, now)
+		}
+	}
+}
+
+// StatsV2UploadSchedule reports how a device is keeping to its expected
+// upload cadence.
+type StatsV2UploadSchedule struct {
+	IntervalSeconds float64   `json:"interval_seconds"`
+	LastUploadAt    time.Time `json:"last_upload_at,omitzero"`
+	MissedWindows   int64     `json:"missed_windows"`  // between uploads, plus overdue_windows
+	OverdueWindows  int64     `json:"overdue_windows"` // missed since the last upload
+	Stalled         bool      `json:"stalled"`         // overdue enough for the monitor to alert
+}
+
+// uploadSchedule returns the device's upload schedule at now, or nil if it
+// has none.
+func uploadSchedule(device DeviceStats, now time.Time) *StatsV2UploadSchedule {
+	if device.UploadInterval <= 0 {
+		return nil
+	}
+	overdue := device.overdueUploads(now)
+	return &StatsV2UploadSchedule{
+		IntervalSeconds: device.UploadInterval.Seconds(),
+		LastUploadAt:    device.LastUploadAt,
+		MissedWindows:   device.MissedUploadWindows + overdue,
+		OverdueWindows:  overdue,
+		Stalled:         device.uploadsStalled(now),
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordUploadGap tests counting expected uploads missing between uploads
+func TestRecordUploadGap(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera", UploadInterval: time.Hour}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordUploadStatAt(t.Context(), "camera", time.Second, t1)
+	s.RecordUploadStatAt(t.Context(), "camera", time.Second, t1.Add(90*time.Minute))
+	s.RecordUploadStatAt(t.Context(), "camera", time.Second, t1.Add(4*time.Hour+10*time.Minute)) // 11:30 to 14:10 missed one
+	s.RecordUploadStatAt(t.Context(), "camera", time.Second, t1.Add(2*time.Hour))                // late arrival; no gap
+
+	device, _ := s.Device(t.Context(), "camera")
+	if device.MissedUploadWindows != 1 || !device.LastUploadAt.Equal(t1.Add(4*time.Hour+10*time.Minute)) {
+		t.Errorf("expected 1 missed window up to 14:10, got %d up to %v", device.MissedUploadWindows, device.LastUploadAt)
+	}
+}
+
+// TestOfflineMonitor_UploadsStalled tests alerting on a heartbeating device that stopped uploading
+func TestOfflineMonitor_UploadsStalled(t *testing.T) {
+	s := NewStore()
+	s.devices["camera"] = &DeviceStats{ID: "camera", UploadInterval: time.Hour}
+	s.devices["silent"] = &DeviceStats{ID: "silent", UploadInterval: time.Hour}
+	s.devices["unscheduled"] = &DeviceStats{ID: "unscheduled"}
+
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for _, id := range []string{"camera", "silent", "unscheduled"} {
+		s.RecordHeartbeat(t.Context(), id, t1)
+		s.RecordUploadStatAt(t.Context(), id, time.Second, t1)
+	}
+
+	var events []string
+	m := NewOfflineMonitor(s, 5*time.Minute)
+	m.Notify = func(eventType, deviceID string, _ time.Time) { events = append(events, eventType+":"+deviceID) }
+
+	// One missed upload is tolerated
+	s.RecordHeartbeat(t.Context(), "camera", t1.Add(110*time.Minute))
+	s.RecordHeartbeat(t.Context(), "unscheduled", t1.Add(110*time.Minute))
+	m.Check(t.Context(), t1.Add(111*time.Minute))
+	if len(events) != 1 || events[0] != WebhookDeviceOffline+":silent" {
+		t.Errorf("expected only silent offline after one missed upload, got %v", events)
+	}
+
+	s.RecordHeartbeat(t.Context(), "camera", t1.Add(2*time.Hour))
+	s.RecordHeartbeat(t.Context(), "unscheduled", t1.Add(2*time.Hour))
+	m.Check(t.Context(), t1.Add(2*time.Hour+time.Minute))
+	if !slices.Equal(events[1:], []string{WebhookUploadsStalled + ":camera"}) {
+		t.Errorf("expected camera's uploads stalled, got %v", events[1:])
+	}
+
+	m.Check(t.Context(), t1.Add(2*time.Hour+2*time.Minute))
+	if len(events) != 2 {
+		t.Errorf("expected no repeat alert, got %v", events[2:])
+	}
+
+	s.RecordUploadStatAt(t.Context(), "camera", time.Second, t1.Add(2*time.Hour+2*time.Minute))
+	m.Check(t.Context(), t1.Add(2*time.Hour+3*time.Minute))
+	if !slices.Equal(events[2:], []string{WebhookUploadsResumed + ":camera"}) {
+		t.Errorf("expected camera's uploads resumed, got %v", events[2:])
+	}
+}
+
+// TestHeartbeat_DeclaredUploadInterval tests declaring the upload cadence in a heartbeat
+func TestHeartbeat_DeclaredUploadInterval(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	now := time.Now().UTC()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", strings.NewReader(body)))
+		return rr
+	}
+	sentAt := now.Add(-3 * time.Hour).Format(time.RFC3339)
+	if rr := post(`{"sent_at": "` + sentAt + `", "upload_interval": 3600000000000}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rr.Code, rr.Body)
+	}
+	if rr := post(`{"sent_at": "` + sentAt + `", "upload_interval": 172800000000000}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errCodeUploadIntervalRange) {
+		t.Errorf("expected a 2-day upload_interval refused, got %d: %s", rr.Code, rr.Body)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v2/devices/device-1/stats", nil))
+	var resp StatsV2Response
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	want := StatsV2UploadSchedule{IntervalSeconds: 3600, MissedWindows: 3, OverdueWindows: 3, Stalled: true}
+	if resp.UploadSchedule == nil || *resp.UploadSchedule != want {
+		t.Errorf("expected %+v, got %+v", want, resp.UploadSchedule)
+	}
+}
+
+// TestParseDevicesCSV_UploadInterval tests the upload_interval column
+func TestParseDevicesCSV_UploadInterval(t *testing.T) {
+	devices, err := parseDevicesCSV(strings.NewReader("device_id,upload_interval\ncam-1,1h\ncam-2,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devices[0].UploadInterval != time.Hour || devices[1].UploadInterval != 0 {
+		t.Errorf("expected 1h and none, got %v and %v", devices[0].UploadInterval, devices[1].UploadInterval)
+	}
+
+	if _, err := parseDevicesCSV(strings.NewReader("device_id,upload_interval\ncam-1,hourly\n")); err == nil || !strings.Contains(err.Error(), "invalid upload_interval") {
+		t.Errorf("expected an invalid upload_interval refused, got %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Customers are billed per monitored device, so usage is counted per
+// organization: devices registered and not retired, and telemetry
+// requests per UTC day. Each heartbeat, upload stat and bulk ingest line
+// is one request; bulk ingest counts only valid lines, and none of an
+// atomic batch that isn't applied. A device quota refuses every change that
+// would put an organization over it: enrolling, transferring a device in,
+// and reloading or importing the device CSVs. A registry already over it
+// at startup fails requests like a bad device CSV, until a reload brings it
+// under. An organization over its daily request quota gets 429 until the
+// next UTC day. Request counts are in memory and start from zero on
+// restart. Both quotas are soft: concurrent requests may pass them together.
+
+// Quota limits an organization's usage; zero fields are unlimited.
+type Quota struct {
+	MaxDevices       int
+	MaxDailyRequests int64
+}
+
+// Quotas maps an organization to its quota. Organizations without one are
+// unlimited.
+type Quotas map[string]Quota
+
+// LoadQuotasFromCSV reads quotas from a CSV file with an
+// "org,max_devices,max_daily_requests" header row. Empty limits are
+// unlimited.
+func LoadQuotasFromCSV(filename string) (Quotas, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", filename, err)
+		}
+	}()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make(Quotas)
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 3 || record[0] == "" {
+			return nil, fmt.Errorf("line %d: expected org, max_devices and max_daily_requests", i+1)
+		}
+		var quota Quota
+		if record[1] != "" {
+			if quota.MaxDevices, err = strconv.Atoi(record[1]); err != nil || quota.MaxDevices < 0 {
+				return nil, fmt.Errorf("line %d: invalid max_devices %q", i+1, record[1])
+			}
+		}
+		if record[2] != "" {
+			if quota.MaxDailyRequests, err = strconv.ParseInt(record[2], 10, 64); err != nil || quota.MaxDailyRequests < 0 {
+				return nil, fmt.Errorf("line %d: invalid max_daily_requests %q", i+1, record[2])
+			}
+		}
+		quotas[record[0]] = quota
+	}
+	return quotas, nil
+}
+
+var (
+	errRequestQuota error = &validationError{code: errCodeQuotaExceeded, msg: "organization's daily request quota exceeded"}
+	errDeviceQuota  error = &validationError{code: errCodeQuotaExceeded, msg: "organization's device quota reached"}
+)
+
+// orgUsage counts telemetry requests per organization. It has its own lock
+// so accounting never contends with telemetry writes.
+type orgUsage struct {
+	mu     sync.Mutex
+	quotas Quotas                  // protected by mu
+	orgs   map[string]*usageCounts // protected by mu
+}
+
+// usageCounts are an organization's request counts.
+type usageCounts struct {
+	day      time.Time // UTC midnight starting the day today counts
+	today    int64
+	total    int64
+	rejected int64 // refused over quota
+}
+
+func newOrgUsage() *orgUsage {
+	return &orgUsage{orgs: make(map[string]*usageCounts)}
+}
+
+// countsLocked returns the org's counts with today rolled over to now's day.
+// Callers must hold u.mu.
+func (u *orgUsage) countsLocked(org string, now time.Time) *usageCounts {
+	counts, exists := u.orgs[org]
+	if !exists {
+		counts = &usageCounts{}
+		u.orgs[org] = counts
+	}
+	if day := utcDay(now); !counts.day.Equal(day) {
+		counts.day, counts.today = day, 0
+	}
+	return counts
+}
+
+// usageKey is an organization's UTC day.
+type usageKey struct {
+	org string
+	day time.Time
+}
+
+// admit counts a telemetry request for org at now, or returns
+// errRequestQuota if the org has used its daily quota.
+func (u *orgUsage) admit(org string, now time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	counts := u.countsLocked(org, now)
+	if limit := u.quotas[org].MaxDailyRequests; limit > 0 && counts.today >= limit {
+		counts.rejected++
+		return errRequestQuota
+	}
+	counts.today++
+	counts.total++
+	return nil
+}
+
+// refund uncounts requests admitted but never applied, by org and the day
+// they were admitted. A day that has since rolled over keeps its count.
+func (u *orgUsage) refund(admitted map[usageKey]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, n := range admitted {
+		counts, exists := u.orgs[key.org]
+		if !exists {
+			continue
+		}
+		counts.total -= n
+		if counts.day.Equal(key.day) {
+			counts.today -= n
+		}
+	}
+}
+
+// usage returns a copy of the org's counts at now, and its quota.
+func (u *orgUsage) usage(org string, now time.Time) (usageCounts, Quota) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	counts := usageCounts{day: utcDay(now)}
+	if saved, exists := u.orgs[org]; exists {
+		counts.total, counts.rejected = saved.total, saved.rejected
+		if saved.day.Equal(counts.day) {
+			counts.today = saved.today
+		}
+	}
+	return counts, u.quotas[org]
+}
+
+// quota returns the org's quota.
+func (u *orgUsage) quota(org string) Quota {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.quotas[org]
+}
+
+// utcDay returns the UTC midnight starting t's day.
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// SetQuotas replaces the per-organization quotas.
+func (s *Server) SetQuotas(quotas Quotas) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	s.usage.quotas = quotas
+}
+
+// admitTelemetry counts a telemetry request for the device's organization,
+// returning errRequestQuota if the organization is over its daily quota.
+// Requests admitted for an atomic ingest batch are remembered on it, to be
+// refunded if it aborts.
+func (s *Server) admitTelemetry(ctx context.Context, deviceID string, now time.Time) error {
+	org, err := s.store.DeviceOrg(ctx, deviceID)
+	if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+		return err
+	}
+	if err := s.usage.admit(org, now); err != nil {
+		return err
+	}
+	if batch := atomicIngestFromContext(ctx); batch != nil {
+		if batch.admitted == nil {
+			batch.admitted = make(map[usageKey]int64)
+		}
+		batch.admitted[usageKey{org, utcDay(now)}]++
+	}
+	return nil
+}
+
+// deviceQuotaError returns errDeviceQuota, naming the organization, if
+// devices would put any organization over its device quota as the whole
+// registry. Devices already decommissioned, or in retiring, don't count.
+func (s *Server) deviceQuotaError(ctx context.Context, devices []DeviceStats, retiring []string) error {
+	s.usage.mu.Lock()
+	quotas := s.usage.quotas
+	s.usage.mu.Unlock()
+	if len(quotas) == 0 {
+		return nil
+	}
+
+	current, err := s.store.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	retired := make(map[string]bool)
+	for _, device := range current {
+		if !device.DecommissionedAt.IsZero() {
+			retired[device.ID] = true
+		}
+	}
+	for _, id := range retiring {
+		retired[id] = true
+	}
+
+	counts := make(map[string]int)
+	for _, device := range devices {
+		if !retired[device.ID] && device.DecommissionedAt.IsZero() {
+			counts[device.Org]++
+		}
+	}
+	orgs := slices.Sorted(maps.Keys(counts))
+	for _, org := range orgs {
+		if limit := quotas[org].MaxDevices; limit > 0 && counts[org] > limit {
+			return fmt.Errorf("org %q would have %d devices, over its quota of %d: %w", org, counts[org], limit, errDeviceQuota)
+		}
+	}
+	return nil
+}
+
+// CheckDeviceQuotas returns an error naming an organization the registry
+// puts over its device quota, if any.
+func (s *Server) CheckDeviceQuotas(ctx context.Context) error {
+	devices, err := s.store.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	return s.deviceQuotaError(ctx, devices, nil)
+}
+
+// writeQuotaExceeded answers a request refused over quota, retrying after
+// the quota resets at the next UTC midnight.
+func writeQuotaExceeded(w http.ResponseWriter, err error, now time.Time) {
+	if errors.Is(err, errRequestQuota) {
+		retry := utcDay(now).Add(24 * time.Hour).Sub(now)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	}
+	writeErrorCode(w, http.StatusTooManyRequests, errCodeQuotaExceeded, err.Error())
+}
+
+// monitoredDevices counts the org's devices that aren't retired.
+func (s *Server) monitoredDevices(ctx context.Context, org string) (int, error) {
+	devices, err := s.store.ListDevices(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, device := range devices {
+		if device.Org == org && device.DecommissionedAt.IsZero() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UsageResponse is the body of GET /api/v1/orgs/{org}/usage.
+type UsageResponse struct {
+	Org string `json:"org"`
+
+	// Devices registered and not retired
+	Devices         int  `json:"devices"`
+	MaxDevices      int  `json:"max_devices,omitempty"` // omitted when unlimited
+	OverDeviceQuota bool `json:"over_device_quota,omitempty"`
+
+	// Telemetry requests; totals count since the server started
+	Day              time.Time `json:"day"` // UTC day requests_today counts
+	RequestsToday    int64     `json:"requests_today"`
+	MaxDailyRequests int64     `json:"max_daily_requests,omitempty"` // omitted when unlimited
+	RequestsTotal    int64     `json:"requests_total"`
+	Rejected         int64     `json:"rejected"` // refused over the daily quota
+}
+
+// HandleUsage processes GET /api/v1/orgs/{org}/usage
+func (s *Server) HandleUsage(w http.ResponseWriter, r *http.Request, org string) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/orgs/%s/usage", org)
+
+	// Keys see only their own organization's usage
+	if caller := orgFromContext(r.Context()); (caller != "" && caller != org) || !s.knownOrg(org) {
+		writeError(w, http.StatusNotFound, "organization not found")
+		return
+	}
+
+	counts, quota := s.usage.usage(org, time.Now())
+	devices, err := s.monitoredDevices(r.Context(), org)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, UsageResponse{
+		Org:              org,
+		Devices:          devices,
+		MaxDevices:       quota.MaxDevices,
+		OverDeviceQuota:  quota.MaxDevices > 0 && devices > quota.MaxDevices,
+		Day:              counts.day,
+		RequestsToday:    counts.today,
+		MaxDailyRequests: quota.MaxDailyRequests,
+		RequestsTotal:    counts.total,
+		Rejected:         counts.rejected,
+	})
+}
+
+// routeOrg serves /api/v1/orgs/{org}/usage.
+func (s *Server) routeOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/orgs/"), "/usage")
+	if !ok || org == "" || strings.Contains(org, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	methods{http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.HandleUsage(w, r, org) }}.ServeHTTP(w, r)
+}
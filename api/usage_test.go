@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadQuotasFromCSV tests parsing quotas with unlimited fields left empty
+func TestLoadQuotasFromCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.csv")
+	if err := os.WriteFile(path, []byte("org,max_devices,max_daily_requests\norg-a,10,\norg-b,,5000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	quotas, err := LoadQuotasFromCSV(path)
+	if err != nil {
+		t.Fatalf("LoadQuotasFromCSV failed: %v", err)
+	}
+	if quotas["org-a"] != (Quota{MaxDevices: 10}) || quotas["org-b"] != (Quota{MaxDailyRequests: 5000}) {
+		t.Errorf("unexpected quotas %+v", quotas)
+	}
+
+	if err := os.WriteFile(path, []byte("org,max_devices,max_daily_requests\norg-a,-1,\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadQuotasFromCSV(path); err == nil {
+		t.Error("expected a negative quota rejected")
+	}
+}
+
+// TestOrgUsage_DailyQuota tests that the daily quota refuses requests until the next UTC day
+func TestOrgUsage_DailyQuota(t *testing.T) {
+	u := newOrgUsage()
+	u.quotas = Quotas{"org-a": {MaxDailyRequests: 2}}
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	for i, want := range []error{nil, nil, errRequestQuota} {
+		if err := u.admit("org-a", day.Add(time.Duration(i)*time.Hour)); err != want {
+			t.Errorf("request %d: expected %v, got %v", i, want, err)
+		}
+	}
+	if err := u.admit("org-b", day); err != nil {
+		t.Errorf("expected org-b unlimited, got %v", err)
+	}
+	if err := u.admit("org-a", day.Add(24*time.Hour)); err != nil {
+		t.Errorf("expected the quota reset the next day, got %v", err)
+	}
+	counts, _ := u.usage("org-a", day.Add(24*time.Hour))
+	if counts.today != 1 || counts.total != 3 || counts.rejected != 1 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+// TestHandleUsage tests quota enforcement on telemetry and the usage endpoint's scoping
+func TestHandleUsage(t *testing.T) {
+	server := setupAuthTestServer()
+	server.SetQuotas(Quotas{"org-a": {MaxDevices: 1, MaxDailyRequests: 1}})
+	router := server.Router()
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set(apiKeyHeader, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	if rr := do(http.MethodPost, "/api/v1/devices/device-a/heartbeat", "key-a", body); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the first heartbeat accepted, got %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/api/v1/devices/device-a/heartbeat", "key-a", body)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" || !strings.Contains(rr.Body.String(), errCodeQuotaExceeded) {
+		t.Errorf("expected 429 over quota, got %d %q: %s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/v1/devices/device-b/heartbeat", "key-b", body); rr.Code != http.StatusNoContent {
+		t.Errorf("expected org-b unaffected, got %d", rr.Code)
+	}
+
+	rr = do(http.MethodGet, "/api/v1/orgs/org-a/usage", "key-a", "")
+	var resp UsageResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d (%v)", rr.Code, err)
+	}
+	if resp.Devices != 1 || resp.MaxDevices != 1 || resp.RequestsToday != 1 || resp.MaxDailyRequests != 1 || resp.Rejected != 1 {
+		t.Errorf("unexpected usage %+v", resp)
+	}
+
+	if rr := do(http.MethodGet, "/api/v1/orgs/org-b/usage", "key-a", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected another org's usage hidden, got %d", rr.Code)
+	}
+}
+
+// TestEnroll_DeviceQuota tests that enrollment stops at the device quota without using the token
+func TestEnroll_DeviceQuota(t *testing.T) {
+	server, _ := setupEnrollTestServer(t, "device_id,org\n")
+	server.SetQuotas(Quotas{"acme": {MaxDevices: 1}})
+	router := server.Router()
+
+	if rr := enroll(router, "tok-1"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected the first enrollment to succeed, got %d", rr.Code)
+	}
+	if rr := enroll(router, "tok-2"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 at the device quota, got %d", rr.Code)
+	}
+	if remaining, _ := server.enroller.Remaining(); remaining != 1 {
+		t.Errorf("expected the refused token kept, got %d remaining", remaining)
+	}
+}
+
+// TestIngest_QuotaCountsValidLines tests that bulk ingest counts only valid
+// lines toward the daily quota, and refunds the lines of an aborted batch
+func TestIngest_QuotaCountsValidLines(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+	ingest := func(query string, lines ...string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest"+query, strings.NewReader(strings.Join(lines, "\n")))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rr.Code)
+		}
+	}
+	requestsToday := func() int64 {
+		counts, _ := server.usage.usage("", time.Now())
+		return counts.today
+	}
+	valid := `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2024-01-15T10:00:00Z"}`
+	invalid := `{"device_id": "device-2", "type": "upload", "upload_time": 0}`
+
+	ingest("", valid, invalid)
+	if today := requestsToday(); today != 1 {
+		t.Errorf("expected only the valid line counted, got %d", today)
+	}
+	ingest("?atomic=true", valid, invalid)
+	if today := requestsToday(); today != 1 {
+		t.Errorf("expected the aborted batch refunded, got %d", today)
+	}
+	ingest("?atomic=true", valid, valid)
+	if today := requestsToday(); today != 3 {
+		t.Errorf("expected the committed batch counted, got %d", today)
+	}
+}
+
+// TestDeviceQuota_Registry tests that reloads and transfers can't put an
+// organization over its device quota, and that decommissioned devices don't count
+func TestDeviceQuota_Registry(t *testing.T) {
+	server := setupAuthTestServer()
+	server.EnableAuth(APIKeys{"key-a": "org-a", "key-b": "org-b", "admin-key": ""})
+	server.SetQuotas(Quotas{"org-b": {MaxDevices: 1}})
+	router := server.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-a/transfer", strings.NewReader(`{"org": "org-b"}`))
+	req.Header.Set(apiKeyHeader, "key-a")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), errCodeQuotaExceeded) {
+		t.Errorf("expected a transfer into a full org refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if org, _ := server.store.DeviceOrg(t.Context(), "device-a"); org != "org-a" {
+		t.Errorf("expected device-a to stay in org-a, got %q", org)
+	}
+
+	path := writeDevicesFile(t, t.TempDir(), "devices.csv", "device_id,org\ndevice-a,org-a\ndevice-b,org-b\ndevice-c,org-b\n")
+	server.SetDeviceSources(path, nil)
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/reload", nil)
+	req.Header.Set(apiKeyHeader, "admin-key")
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests || deviceExists(t, server.store, "device-c") {
+		t.Errorf("expected a reload over the quota refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := server.CheckDeviceQuotas(t.Context()); err != nil {
+		t.Errorf("expected the registry within quota, got %v", err)
+	}
+	if err := server.deviceQuotaError(t.Context(), []DeviceStats{{ID: "device-b", Org: "org-b"}, {ID: "device-c", Org: "org-b"}}, []string{"device-c"}); err != nil {
+		t.Errorf("expected a device being retired not to count, got %v", err)
+	}
+}
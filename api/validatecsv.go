@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Fleet managers edit device CSVs by hand before the nightly reload. POST
+// /api/v1/admin/validate-csv checks a file as if it replaced one of the
+// device sources, and reports every problem plus what a reload would then
+// change, without writing anything.
+
+// CSVValidationResponse reports a dry-run check of a device CSV.
+type CSVValidationResponse struct {
+	File     string   `json:"file"`     // the device source the upload was checked as
+	Valid    bool     `json:"valid"`    // false if any errors
+	Errors   []string `json:"errors"`   // problems that fail a reload or make devices unreachable
+	Warnings []string `json:"warnings"` // loads, but probably not as intended
+
+	// What a reload would change; omitted unless the file is valid
+	Changes *CSVValidationChanges `json:"changes,omitempty"`
+}
+
+// CSVValidationChanges is the difference between the registry and what a
+// reload would load.
+type CSVValidationChanges struct {
+	Added     []string       `json:"added"`
+	Removed   []string       `json:"removed"` // dropped with their telemetry
+	Changed   []DeviceChange `json:"changed"`
+	Unchanged int            `json:"unchanged"`
+	Devices   int            `json:"devices"` // registry size after the reload
+}
+
+// DeviceChange lists the registry fields a reload would change on a device.
+type DeviceChange struct {
+	DeviceID string   `json:"device_id"`
+	Fields   []string `json:"fields"`
+}
+
+// deviceCSVColumns are the columns a device CSV loads; any other is ignored.
+var deviceCSVColumns = append([]string{"device_id"}, importColumns...)
+
+// deviceIDProblem returns why id can't be addressed through the API, or "".
+// IDs are a single path segment, and /api/v1/devices/search and compare are
+// routes.
+func deviceIDProblem(id string) string {
+	switch {
+	case id == "search" || id == "compare":
+		return "is reserved by /api/v1/devices/" + id
+	case strings.TrimSpace(id) != id:
+		return "has leading or trailing whitespace"
+	case strings.ContainsAny(id, "/?#%"):
+		return "contains one of / ? # %"
+	case strings.ContainsFunc(id, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }):
+		return "contains whitespace or control characters"
+	}
+	return ""
+}
+
+// validateDevicesCSV checks data as the device CSV at path, with the other
+// device sources in others. It returns the devices a reload would load,
+// which are nil if there are errors.
+func validateDevicesCSV(data []byte, path string, others []string) ([]DeviceStats, []string, []string) {
+	var errs, warnings []string
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	switch {
+	case err == io.EOF:
+		return nil, []string{"missing header row"}, nil
+	case err != nil:
+		return nil, []string{err.Error()}, nil
+	case header[0] != "device_id":
+		return nil, []string{"device_id must be the first column"}, nil
+	}
+	for _, col := range header[1:] {
+		if !slices.Contains(deviceCSVColumns, col) {
+			warnings = append(warnings, fmt.Sprintf("column %q is not a device column and is ignored", col))
+		}
+	}
+	if current, err := readHeader(path); err == nil {
+		for _, col := range current {
+			if slices.Contains(deviceCSVColumns, col) && !slices.Contains(header, col) {
+				warnings = append(warnings, fmt.Sprintf("column %q in %s is missing, so its values would be cleared", col, filepath.Base(path)))
+			}
+		}
+	}
+
+	// IDs the parser accepts but the API couldn't address
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break // reported by the parser
+		}
+		line, _ := reader.FieldPos(0)
+		if problem := deviceIDProblem(record[0]); record[0] != "" && problem != "" {
+			errs = append(errs, fmt.Sprintf("line %d: device_id %q %s", line, record[0], problem))
+		}
+	}
+
+	devices, err := parseDevicesCSV(bytes.NewReader(data))
+	if err != nil {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				errs = append(errs, e.Error())
+			}
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+	for i := range devices {
+		devices[i].source = path
+	}
+
+	// A device may only be listed in one source
+	listed := make(map[string]string, len(devices))
+	for _, device := range devices {
+		listed[device.ID] = filepath.Base(path)
+	}
+	for _, other := range others {
+		parsed, err := readDevicesFile(other)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, device := range parsed {
+			if file, exists := listed[device.ID]; exists {
+				errs = append(errs, fmt.Sprintf("device %s is listed in both %s and %s", device.ID, file, filepath.Base(other)))
+				continue
+			}
+			listed[device.ID] = filepath.Base(other)
+			device.source = other
+			devices = append(devices, device)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs, warnings
+	}
+	return devices, nil, warnings
+}
+
+// readHeader returns the header row of the CSV at path.
+func readHeader(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("[WARN] Failed to close file %s: %v", path, err)
+		}
+	}()
+	return csv.NewReader(file).Read()
+}
+
+// registryChanges returns the registry fields a reload would change on
+// current by loading next, in the order a device is described plus source.
+func registryChanges(current, next DeviceStats) []string {
+	var fields []string
+	if current.Org != next.Org {
+		fields = append(fields, "org")
+	}
+	if current.Facility != next.Facility {
+		fields = append(fields, "facility")
+	}
+	if current.Room != next.Room {
+		fields = append(fields, "room")
+	}
+	if current.HeartbeatInterval != next.HeartbeatInterval {
+		fields = append(fields, "heartbeat_interval")
+	}
+	if current.AlertAfter != next.AlertAfter {
+		fields = append(fields, "alert_after")
+	}
+	if current.UploadInterval != next.UploadInterval {
+		fields = append(fields, "upload_interval")
+	}
+	if current.Location().String() != next.Location().String() {
+		fields = append(fields, "timezone")
+	}
+	// An empty activated_at leaves the activation in place
+	if !next.ActivatedAt.IsZero() && !next.ActivatedAt.Equal(current.ActivatedAt) {
+		fields = append(fields, "activated_at")
+	}
+	if !bytes.Equal(current.signingKey, next.signingKey) {
+		fields = append(fields, "signing_secret")
+	}
+	if current.token != next.token {
+		fields = append(fields, "token")
+	}
+	if current.source != next.source {
+		fields = append(fields, "source")
+	}
+	return fields
+}
+
+// diffRegistry compares the registry with the devices a reload would load.
+func diffRegistry(current, next []DeviceStats) *CSVValidationChanges {
+	changes := &CSVValidationChanges{Added: []string{}, Removed: []string{}, Changed: []DeviceChange{}, Devices: len(next)}
+	existing := make(map[string]DeviceStats, len(current))
+	for _, device := range current {
+		existing[device.ID] = device
+	}
+	for _, device := range next {
+		old, exists := existing[device.ID]
+		if !exists {
+			changes.Added = append(changes.Added, device.ID)
+			continue
+		}
+		delete(existing, device.ID)
+		if fields := registryChanges(old, device); len(fields) > 0 {
+			changes.Changed = append(changes.Changed, DeviceChange{DeviceID: device.ID, Fields: fields})
+		} else {
+			changes.Unchanged++
+		}
+	}
+	for _, device := range current {
+		if _, removed := existing[device.ID]; removed {
+			changes.Removed = append(changes.Removed, device.ID)
+		}
+	}
+	slices.Sort(changes.Added)
+	slices.SortFunc(changes.Changed, func(a, b DeviceChange) int { return strings.Compare(a.DeviceID, b.DeviceID) })
+	return changes
+}
+
+// HandleValidateCSV processes POST /api/v1/admin/validate-csv. The body is a
+// device CSV, checked as the device source named by the file parameter,
+// which may be omitted when devices come from a single file. A name that
+// isn't a current source is checked as a new file beside them, as a glob
+// would pick up. Nothing is written or reloaded.
+func (s *Server) HandleValidateCSV(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[REQUEST] POST /api/v1/admin/validate-csv")
+
+	s.configMu.RLock()
+	spec := s.devicesSpec
+	s.configMu.RUnlock()
+	if spec == "" {
+		writeError(w, http.StatusNotFound, "validation is not enabled")
+		return
+	}
+
+	paths, err := ExpandDeviceSources(spec)
+	if err != nil {
+		log.Printf("[ERROR] Failed to expand device sources %s: %v", spec, err)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	name := r.URL.Query().Get("file")
+	switch {
+	case name == "" && len(paths) != 1:
+		writeError(w, http.StatusBadRequest, "file is required when devices come from several files")
+		return
+	case name == "":
+		name = filepath.Base(paths[0])
+	case name != filepath.Base(name):
+		writeError(w, http.StatusBadRequest, "file must be a file name, not a path")
+		return
+	}
+	path := filepath.Join(filepath.Dir(paths[0]), name)
+	var others []string
+	for _, p := range paths {
+		if filepath.Base(p) == name {
+			path = p
+		} else {
+			others = append(others, p)
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	devices, errs, warnings := validateDevicesCSV(body, path, others)
+	resp := CSVValidationResponse{
+		File:     name,
+		Valid:    len(errs) == 0,
+		Errors:   append([]string{}, errs...),
+		Warnings: append([]string{}, warnings...),
+	}
+	if resp.Valid {
+		current, err := s.store.ListDevices(r.Context())
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		resp.Changes = diffRegistry(current, devices)
+	}
+	log.Printf("[INFO] Validated device CSV as %s: %d errors, %d warnings", name, len(resp.Errors), len(resp.Warnings))
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validateCSV posts body to the validation endpoint and decodes the report.
+func validateCSV(t *testing.T, router http.Handler, query, body string) CSVValidationResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/validate-csv"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp CSVValidationResponse
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	return resp
+}
+
+// TestValidateCSV_Changes tests reporting what a reload would change without applying it
+func TestValidateCSV_Changes(t *testing.T) {
+	dir := t.TempDir()
+	content := "device_id,org,timezone,signing_secret\n" +
+		"device-1,acme,Europe/Paris,s3cret\n" +
+		"device-2,acme,,\n" +
+		"device-3,acme,,\n"
+	server := setupRegistryTestServer(t, dir, "devices.csv", content)
+	router := server.Router()
+
+	resp := validateCSV(t, router, "", "device_id,org,timezone,notes\n"+
+		"device-1,globex,Europe/Paris,lobby\n"+
+		"device-2,acme,,\n"+
+		"device-4,acme,UTC,\n")
+	if !resp.Valid || resp.File != "devices.csv" || len(resp.Errors) != 0 {
+		t.Fatalf("expected a valid file, got %+v", resp)
+	}
+	wantWarnings := []string{
+		`column "notes" is not a device column and is ignored`,
+		`column "signing_secret" in devices.csv is missing, so its values would be cleared`,
+	}
+	if !reflect.DeepEqual(resp.Warnings, wantWarnings) {
+		t.Errorf("expected warnings %q, got %q", wantWarnings, resp.Warnings)
+	}
+	want := &CSVValidationChanges{
+		Added:     []string{"device-4"},
+		Removed:   []string{"device-3"},
+		Changed:   []DeviceChange{{DeviceID: "device-1", Fields: []string{"org", "signing_secret"}}},
+		Unchanged: 1,
+		Devices:   3,
+	}
+	if !reflect.DeepEqual(resp.Changes, want) {
+		t.Errorf("expected changes %+v, got %+v", want, resp.Changes)
+	}
+
+	// Nothing was applied
+	if data, _ := os.ReadFile(filepath.Join(dir, "devices.csv")); string(data) != content {
+		t.Errorf("expected device file untouched, got:\n%s", data)
+	}
+	if device, _ := server.store.Device(t.Context(), "device-1"); device.Org != "acme" || !deviceExists(t, server.store, "device-3") {
+		t.Error("expected registry untouched")
+	}
+}
+
+// TestValidateCSV_Errors tests reporting every problem with its line
+func TestValidateCSV_Errors(t *testing.T) {
+	server := setupRegistryTestServer(t, t.TempDir(),
+		"north.csv", "device_id\ndevice-1\n",
+		"south.csv", "device_id\ndevice-2\n")
+	router := server.Router()
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  []string
+	}{
+		{"missing header", "?file=north.csv", "", []string{"missing header row"}},
+		{"device_id not first", "?file=north.csv", "org,device_id\nacme,device-1\n", []string{"device_id must be the first column"}},
+		{"bad rows", "?file=north.csv", "device_id,heartbeat_interval\ndevice-1,30s\n,30s\ndevice-1,1m\ncam 9,\nsearch,\ndevice-5,often\n", []string{
+			`line 5: device_id "cam 9" contains whitespace or control characters`,
+			`line 6: device_id "search" is reserved by /api/v1/devices/search`,
+			"line 3: missing device_id",
+			`line 4: duplicate device_id "device-1" (first listed on line 2)`,
+			`line 7: invalid heartbeat_interval "often"`,
+		}},
+		{"listed in another source", "?file=north.csv", "device_id\ndevice-1\ndevice-2\n", []string{"device device-2 is listed in both north.csv and south.csv"}},
+		{"new source", "?file=east.csv", "device_id\ndevice-1\n", []string{"device device-1 is listed in both east.csv and north.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := validateCSV(t, router, tt.query, tt.body)
+			if resp.Valid || resp.Changes != nil || !reflect.DeepEqual(resp.Errors, tt.want) {
+				t.Errorf("expected errors %q, got %+v", tt.want, resp)
+			}
+		})
+	}
+
+	// With several sources the file must be named
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/validate-csv", strings.NewReader("device_id\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without file, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"time"
+)
+
+// Plausible sensor ranges; readings outside them are rejected as bad data.
+const (
+	minTemperatureC = -50.0
+	maxTemperatureC = 150.0
+)
+
+// Reading tracks one hardware sensor reported in heartbeats: the most recent
+// value plus the lowest and highest seen. Count is zero if never reported.
+type Reading struct {
+	Count    int64
+	Latest   float64
+	LatestAt time.Time // sent_at of the heartbeat that carried Latest
+	Min      float64
+	Max      float64
+}
+
+// add folds a value reported at the given time into the reading. Late
+// heartbeats widen min/max but don't replace a newer latest value.
+func (r *Reading) add(v float64, at time.Time) {
+	if r.Count == 0 || v < r.Min {
+		r.Min = v
+	}
+	if r.Count == 0 || v > r.Max {
+		r.Max = v
+	}
+	if r.Count == 0 || !at.Before(r.LatestAt) {
+		r.Latest = v
+		r.LatestAt = at
+	}
+	r.Count++
+}
+
+// recordVitals applies the optional sensor values carried by a heartbeat.
+func (device *DeviceStats) recordVitals(battery, temperature, diskFree *float64, at time.Time) {
+	if battery != nil {
+		device.Battery.add(*battery, at)
+	}
+	if temperature != nil {
+		device.Temperature.add(*temperature, at)
+	}
+	if diskFree != nil {
+		device.DiskFree.add(*diskFree, at)
+	}
+}
+
+// validateVitals checks the optional sensor fields of a heartbeat.
+func validateVitals(req *HeartbeatRequest) error {
+	if req.BatteryPct != nil && (*req.BatteryPct < 0 || *req.BatteryPct > 100) {
+		return &validationError{code: errCodeVitalsRange, field: "battery_pct", msg: "battery_pct must be between 0 and 100"}
+	}
+	if req.TemperatureC != nil && (*req.TemperatureC < minTemperatureC || *req.TemperatureC > maxTemperatureC) {
+		return &validationError{code: errCodeVitalsRange, field: "temperature_c", msg: "temperature_c is out of range"}
+	}
+	if req.DiskFreeBytes != nil && *req.DiskFreeBytes < 0 {
+		return &validationError{code: errCodeVitalsRange, field: "disk_free_bytes", msg: "disk_free_bytes must not be negative"}
+	}
+	return nil
+}
+
+// ReadingResponse is a sensor's latest, minimum and maximum values.
+type ReadingResponse struct {
+	Latest float64 `json:"latest"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// readingResponse returns nil for sensors the device never reported, so they
+// are omitted from the stats response.
+func readingResponse(r Reading) *ReadingResponse {
+	if r.Count == 0 {
+		return nil
+	}
+	return &ReadingResponse{Latest: r.Latest, Min: r.Min, Max: r.Max}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestReading_Add tests latest/min/max tracking, including late readings
+func TestReading_Add(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	var r Reading
+
+	r.add(80, base)
+	r.add(60, base.Add(2*time.Minute))
+	r.add(95, base.Add(time.Minute)) // late: widens max but isn't latest
+
+	if r.Count != 3 {
+		t.Errorf("expected count 3, got %d", r.Count)
+	}
+	if r.Latest != 60 || !r.LatestAt.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("expected latest 60 at +2m, got %v at %v", r.Latest, r.LatestAt)
+	}
+	if r.Min != 60 || r.Max != 95 {
+		t.Errorf("expected min/max 60/95, got %v/%v", r.Min, r.Max)
+	}
+}
+
+// TestPostHeartbeat_Vitals tests that vitals are tracked and returned in stats
+func TestPostHeartbeat_Vitals(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	bodies := []string{
+		`{"sent_at": "2024-01-15T10:00:00Z", "battery_pct": 90, "temperature_c": 41.5, "disk_free_bytes": 5000000000}`,
+		`{"sent_at": "2024-01-15T10:01:00Z", "battery_pct": 72.5, "temperature_c": 38}`,
+	}
+	for _, body := range bodies {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp StatsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.BatteryPct == nil || *resp.BatteryPct != (ReadingResponse{Latest: 72.5, Min: 72.5, Max: 90}) {
+		t.Errorf("unexpected battery_pct: %+v", resp.BatteryPct)
+	}
+	if resp.TemperatureC == nil || *resp.TemperatureC != (ReadingResponse{Latest: 38, Min: 38, Max: 41.5}) {
+		t.Errorf("unexpected temperature_c: %+v", resp.TemperatureC)
+	}
+	if resp.DiskFreeBytes == nil || resp.DiskFreeBytes.Latest != 5000000000 {
+		t.Errorf("unexpected disk_free_bytes: %+v", resp.DiskFreeBytes)
+	}
+}
+
+// TestGetStats_NoVitals tests that unreported vitals are omitted from stats
+func TestGetStats_NoVitals(t *testing.T) {
+	server := setupTestServer()
+	router := server.Router()
+
+	body := `{"sent_at": "2024-01-15T10:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(body))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/devices/device-1/stats", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if bytes.Contains(rr.Body.Bytes(), []byte("battery_pct")) {
+		t.Errorf("expected battery_pct to be omitted, got %s", rr.Body.String())
+	}
+}
+
+// TestPostHeartbeat_InvalidVitals tests rejection of out-of-range vitals
+func TestPostHeartbeat_InvalidVitals(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"battery above 100", `{"sent_at": "2024-01-15T10:00:00Z", "battery_pct": 101}`},
+		{"negative battery", `{"sent_at": "2024-01-15T10:00:00Z", "battery_pct": -1}`},
+		{"temperature too high", `{"sent_at": "2024-01-15T10:00:00Z", "temperature_c": 500}`},
+		{"negative disk free", `{"sent_at": "2024-01-15T10:00:00Z", "disk_free_bytes": -5}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := setupTestServer()
+			router := server.Router()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/device-1/heartbeat", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rr.Code)
+			}
+			if server.backend().(*Store).devices["device-1"].HeartbeatCount != 0 {
+				t.Error("expected rejected heartbeat not to be recorded")
+			}
+		})
+	}
+}
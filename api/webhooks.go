@@ -0,0 +1,584 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook subscriptions notify consumers of device events by POSTing JSON
+// to a URL they register. Each delivery is signed with the subscription's
+// secret and retried with exponential backoff; the most recent deliveries
+// of every subscription are kept so consumers can see what failed and why.
+// Subscriptions are kept in memory and scoped to the caller's organization.
+
+// Webhook event types.
+const (
+	WebhookDeviceOffline = "device_offline"
+	WebhookDeviceOnline  = "device_online"
+	WebhookRegistration  = "registration"
+
+	WebhookUploadsStalled = "uploads_stalled"
+	WebhookUploadsResumed = "uploads_resumed"
+
+	WebhookFacilityOutage    = "facility_outage"
+	WebhookFacilityRecovered = "facility_recovered"
+)
+
+// webhookEventTypes are the event types a webhook may subscribe to.
+var webhookEventTypes = []string{
+	WebhookDeviceOffline, WebhookDeviceOnline, WebhookRegistration, WebhookUploadsStalled, WebhookUploadsResumed,
+	WebhookFacilityOutage, WebhookFacilityRecovered,
+}
+
+const (
+	// Retry policy defaults and bounds
+	defaultWebhookAttempts   = 5
+	maxWebhookAttempts       = 10
+	defaultWebhookBackoff    = time.Second
+	defaultWebhookMaxBackoff = 5 * time.Minute
+
+	// webhookTimeout bounds one delivery attempt
+	webhookTimeout = 10 * time.Second
+
+	// webhookDeliveryHistory is how many deliveries are kept per webhook
+	webhookDeliveryHistory = 50
+
+	// maxWebhooksPerOrg bounds the subscriptions one organization may create
+	maxWebhooksPerOrg = 100
+
+	// webhookQueueSize bounds delivery attempts waiting for a worker
+	webhookQueueSize = 1000
+
+	// Delivery statuses
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// WebhookRetryPolicy controls how failed deliveries are retried: attempt n
+// waits InitialBackoff * 2^(n-1), capped at MaxBackoff.
+type WebhookRetryPolicy struct {
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+// backoff returns how long to wait after the given failed attempt.
+func (p WebhookRetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff.Duration
+	for i := 1; i < attempt && d < p.MaxBackoff.Duration; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff.Duration)
+}
+
+// WebhookRequest is the body of POST /api/v1/webhooks.
+type WebhookRequest struct {
+	URL    string              `json:"url"`
+	Events []string            `json:"events"`
+	Secret string              `json:"secret"`
+	Retry  *WebhookRetryPolicy `json:"retry,omitempty"` // defaults apply to omitted fields
+}
+
+// Webhook is a subscription. The secret is never returned.
+type Webhook struct {
+	ID        string             `json:"id"`
+	Org       string             `json:"org,omitempty"`
+	URL       string             `json:"url"`
+	Events    []string           `json:"events"`
+	Retry     WebhookRetryPolicy `json:"retry"`
+	CreatedAt time.Time          `json:"created_at"`
+
+	secret []byte
+}
+
+// WebhookEvent is the body POSTed to subscribers.
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	DeviceID string    `json:"device_id,omitempty"` // empty for facility events
+	Org      string    `json:"org,omitempty"`
+	At       time.Time `json:"at"`
+
+	// Facility events name the facility and list its offline devices
+	Facility       string   `json:"facility,omitempty"`
+	OfflineDevices []string `json:"offline_devices,omitempty"`
+
+	// Events caused by a request carry its ID, also sent as X-Request-ID
+	RequestID   string `json:"request_id,omitempty"`
+	traceparent string // sent as traceparent
+}
+
+// WebhookDelivery is the state of one event's delivery to one webhook.
+type WebhookDelivery struct {
+	ID          string       `json:"id"`
+	Event       WebhookEvent `json:"event"`
+	Status      string       `json:"status"` // "pending", "delivered" or "failed"
+	Attempts    int          `json:"attempts"`
+	LastAttempt time.Time    `json:"last_attempt,omitzero"`
+	NextAttempt time.Time    `json:"next_attempt,omitzero"` // set while a retry is scheduled
+	StatusCode  int          `json:"status_code,omitempty"` // of the last attempt, if it got a response
+	LastError   string       `json:"last_error,omitempty"`
+}
+
+// webhookJob is one delivery attempt waiting for a worker.
+type webhookJob struct {
+	hookID, deliveryID string
+}
+
+// webhookHub holds subscriptions and their deliveries. It has its own lock
+// so notifications never contend with telemetry writes.
+type webhookHub struct {
+	mu         sync.Mutex
+	hooks      map[string]*Webhook           // protected by mu
+	deliveries map[string][]*WebhookDelivery // protected by mu; per webhook, oldest first
+
+	queue  chan webhookJob
+	client *http.Client
+}
+
+func newWebhookHub() *webhookHub {
+	return &webhookHub{
+		hooks:      make(map[string]*Webhook),
+		deliveries: make(map[string][]*WebhookDelivery),
+		queue:      make(chan webhookJob, webhookQueueSize),
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// add registers a webhook unless its org already has the maximum.
+func (h *webhookHub) add(hook *Webhook) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for _, existing := range h.hooks {
+		if existing.Org == hook.Org {
+			count++
+		}
+	}
+	if count >= maxWebhooksPerOrg {
+		return false
+	}
+	h.hooks[hook.ID] = hook
+	return true
+}
+
+// list returns copies of the webhooks visible to org, oldest first.
+func (h *webhookHub) list(org string) []Webhook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result []Webhook
+	for _, hook := range h.hooks {
+		if org == "" || hook.Org == org {
+			result = append(result, *hook)
+		}
+	}
+	slices.SortFunc(result, func(a, b Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return result
+}
+
+// remove deletes a webhook visible to org, with its deliveries.
+func (h *webhookHub) remove(org, id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hook, exists := h.hooks[id]
+	if !exists || (org != "" && hook.Org != org) {
+		return false
+	}
+	delete(h.hooks, id)
+	delete(h.deliveries, id)
+	return true
+}
+
+// history returns copies of a webhook's deliveries, newest first.
+func (h *webhookHub) history(org, id string) ([]WebhookDelivery, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hook, exists := h.hooks[id]
+	if !exists || (org != "" && hook.Org != org) {
+		return nil, false
+	}
+	deliveries := h.deliveries[id]
+	result := make([]WebhookDelivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		result = append(result, *deliveries[i])
+	}
+	return result, true
+}
+
+// notify queues a delivery of ev to every webhook subscribed to it.
+func (h *webhookHub) notify(ev WebhookEvent) {
+	h.mu.Lock()
+	var jobs []webhookJob
+	for _, hook := range h.hooks {
+		if (hook.Org != "" && hook.Org != ev.Org) || !slices.Contains(hook.Events, ev.Type) {
+			continue
+		}
+		d := &WebhookDelivery{ID: randomHex(8), Event: ev, Status: deliveryPending}
+		deliveries := append(h.deliveries[hook.ID], d)
+		if len(deliveries) > webhookDeliveryHistory {
+			deliveries = deliveries[len(deliveries)-webhookDeliveryHistory:]
+		}
+		h.deliveries[hook.ID] = deliveries
+		jobs = append(jobs, webhookJob{hook.ID, d.ID})
+	}
+	h.mu.Unlock()
+
+	for _, job := range jobs {
+		h.enqueue(job)
+	}
+}
+
+// enqueue hands an attempt to the workers, failing the delivery if the
+// queue is full.
+func (h *webhookHub) enqueue(job webhookJob) {
+	select {
+	case h.queue <- job:
+	default:
+		log.Printf("[WARN] Webhook queue full, dropping delivery %s", job.deliveryID)
+		h.update(job, func(d *WebhookDelivery) {
+			d.Status, d.LastError, d.NextAttempt = deliveryFailed, "delivery queue full", time.Time{}
+		})
+	}
+}
+
+// lookup returns copies of a job's webhook and delivery, if both still exist.
+func (h *webhookHub) lookup(job webhookJob) (Webhook, WebhookDelivery, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hook, exists := h.hooks[job.hookID]
+	if !exists {
+		return Webhook{}, WebhookDelivery{}, false
+	}
+	for _, d := range h.deliveries[job.hookID] {
+		if d.ID == job.deliveryID {
+			return *hook, *d, true
+		}
+	}
+	return Webhook{}, WebhookDelivery{}, false
+}
+
+// update applies fn to a job's delivery, if it is still kept.
+func (h *webhookHub) update(job webhookJob, fn func(d *WebhookDelivery)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, d := range h.deliveries[job.hookID] {
+		if d.ID == job.deliveryID {
+			fn(d)
+			return
+		}
+	}
+}
+
+// run delivers queued attempts until ctx is cancelled.
+func (h *webhookHub) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-h.queue:
+			h.attempt(ctx, job)
+		}
+	}
+}
+
+// attempt makes one delivery attempt, scheduling a retry if it failed in a
+// way worth retrying and attempts remain.
+func (h *webhookHub) attempt(ctx context.Context, job webhookJob) {
+	hook, delivery, ok := h.lookup(job)
+	if !ok {
+		return // the webhook was deleted, or the delivery aged out
+	}
+
+	status, err := h.post(ctx, hook, delivery.Event)
+	now := time.Now().UTC()
+	attempts := delivery.Attempts + 1
+	retry := err != nil && retryableDelivery(status) && attempts < hook.Retry.MaxAttempts && ctx.Err() == nil
+
+	h.update(job, func(d *WebhookDelivery) {
+		d.Attempts, d.LastAttempt, d.StatusCode, d.NextAttempt = attempts, now, status, time.Time{}
+		switch {
+		case err == nil:
+			d.Status, d.LastError = deliveryDelivered, ""
+		case retry:
+			d.LastError = err.Error()
+			d.NextAttempt = now.Add(hook.Retry.backoff(attempts))
+		default:
+			d.Status, d.LastError = deliveryFailed, err.Error()
+		}
+	})
+
+	if retry {
+		time.AfterFunc(hook.Retry.backoff(attempts), func() { h.enqueue(job) })
+	} else if err != nil {
+		log.Printf("[WARN] Webhook %s delivery %s failed after %d attempts: %v", hook.ID, delivery.ID, attempts, err)
+	}
+}
+
+// post sends an event to the webhook, signed with its secret. It returns
+// the response status, or zero if there was no response.
+func (h *webhookHub) post(ctx context.Context, hook Webhook, ev WebhookEvent) (int, error) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("X-Webhook-Event", ev.Type)
+	if ev.RequestID != "" {
+		req.Header.Set(requestIDHeader, ev.RequestID)
+	}
+	if ev.traceparent != "" {
+		req.Header.Set(traceparentHeader, ev.traceparent)
+	}
+	mac := hmac.New(sha256.New, hook.secret)
+	mac.Write(body)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryableDelivery reports whether a failed attempt may succeed later:
+// connection errors, server errors, timeouts and rate limiting. Other client
+// errors mean the request itself was refused.
+func retryableDelivery(status int) bool {
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// RunWebhooks delivers webhook notifications with workers goroutines until
+// ctx is cancelled. Notifications made before it starts wait in the queue.
+func (s *Server) RunWebhooks(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.webhooks.run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// NotifyWebhooks sends an event about a device to the webhooks subscribed
+// to eventType in the device's organization.
+func (s *Server) NotifyWebhooks(eventType, deviceID string, at time.Time) {
+	s.notifyRequestWebhooks(context.Background(), eventType, deviceID, at)
+}
+
+// notifyRequestWebhooks is NotifyWebhooks for an event caused by the
+// request ctx belongs to, whose trace the deliveries carry.
+func (s *Server) notifyRequestWebhooks(ctx context.Context, eventType, deviceID string, at time.Time) {
+	// The event already happened, so it's announced even if the request ended
+	org, _ := s.store.DeviceOrg(context.WithoutCancel(ctx), deviceID)
+	trace := traceFromContext(ctx)
+	s.webhooks.notify(WebhookEvent{
+		ID:          randomHex(8),
+		Type:        eventType,
+		DeviceID:    deviceID,
+		Org:         org,
+		At:          at.UTC(),
+		RequestID:   trace.id,
+		traceparent: trace.childTraceparent(),
+	})
+}
+
+// NotifyOutage sends a facility outage event to the webhooks subscribed to
+// eventType in the facility's organization.
+func (s *Server) NotifyOutage(eventType, org, facility string, offline []string, at time.Time) {
+	s.webhooks.notify(WebhookEvent{
+		ID:             randomHex(8),
+		Type:           eventType,
+		Org:            org,
+		At:             at.UTC(),
+		Facility:       facility,
+		OfflineDevices: offline,
+	})
+}
+
+// validateWebhookRequest checks a subscription and fills in retry defaults.
+func validateWebhookRequest(req *WebhookRequest) (WebhookRetryPolicy, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "url", msg: "url must be an absolute http or https URL"}
+	}
+	if len(req.Events) == 0 {
+		return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "events", msg: "events is required"}
+	}
+	for _, ev := range req.Events {
+		if !slices.Contains(webhookEventTypes, ev) {
+			return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "events",
+				msg: fmt.Sprintf("unknown event %q (supported: %s)", ev, strings.Join(webhookEventTypes, ", "))}
+		}
+	}
+	if req.Secret == "" {
+		return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "secret", msg: "secret is required"}
+	}
+
+	policy := WebhookRetryPolicy{
+		MaxAttempts:    defaultWebhookAttempts,
+		InitialBackoff: Duration{Duration: defaultWebhookBackoff},
+		MaxBackoff:     Duration{Duration: defaultWebhookMaxBackoff},
+	}
+	if req.Retry != nil {
+		if req.Retry.MaxAttempts != 0 {
+			policy.MaxAttempts = req.Retry.MaxAttempts
+		}
+		if req.Retry.InitialBackoff.Duration != 0 {
+			policy.InitialBackoff = req.Retry.InitialBackoff
+		}
+		if req.Retry.MaxBackoff.Duration != 0 {
+			policy.MaxBackoff = req.Retry.MaxBackoff
+		}
+	}
+	switch {
+	case policy.MaxAttempts < 1 || policy.MaxAttempts > maxWebhookAttempts:
+		return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "retry",
+			msg: fmt.Sprintf("retry.max_attempts must be between 1 and %d", maxWebhookAttempts)}
+	case policy.InitialBackoff.Duration < 0 || policy.MaxBackoff.Duration < policy.InitialBackoff.Duration:
+		return WebhookRetryPolicy{}, &validationError{code: errCodeValidation, field: "retry",
+			msg: "retry backoffs must be positive, with max_backoff at least initial_backoff"}
+	}
+	// Stored in Go syntax whatever the request used
+	policy.InitialBackoff = Duration{Duration: policy.InitialBackoff.Duration}
+	policy.MaxBackoff = Duration{Duration: policy.MaxBackoff.Duration}
+	return policy, nil
+}
+
+// WebhookListResponse lists the caller's webhooks, oldest first.
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookDeliveriesResponse lists a webhook's recent deliveries, newest first.
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// HandleCreateWebhook processes POST /api/v1/webhooks
+func (s *Server) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] POST /api/v1/webhooks")
+
+	var req WebhookRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		log.Printf("[ERROR] Invalid JSON: %v", err)
+		writeValidationError(w, err)
+		return
+	}
+	policy, err := validateWebhookRequest(&req)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	hook := &Webhook{
+		ID:        randomHex(8),
+		Org:       orgFromContext(r.Context()),
+		URL:       req.URL,
+		Events:    slices.Compact(slices.Sorted(slices.Values(req.Events))),
+		Retry:     policy,
+		CreatedAt: time.Now().UTC(),
+		secret:    []byte(req.Secret),
+	}
+	if !s.webhooks.add(hook) {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("organization already has %d webhooks", maxWebhooksPerOrg))
+		return
+	}
+	log.Printf("[INFO] Created webhook %s for %v", hook.ID, hook.Events)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// HandleListWebhooks processes GET /api/v1/webhooks
+func (s *Server) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	log.Printf("[REQUEST] GET /api/v1/webhooks")
+
+	hooks := s.webhooks.list(orgFromContext(r.Context()))
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	writeJSON(w, http.StatusOK, WebhookListResponse{Webhooks: hooks})
+}
+
+// HandleWebhook processes DELETE /api/v1/webhooks/{id} and
+// GET /api/v1/webhooks/{id}/deliveries
+func (s *Server) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	// Check for configuration error
+	if configErr := s.configError(); configErr != nil {
+		log.Printf("[ERROR] Configuration error: %v", configErr)
+		writeConfigError(w, configErr)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/webhooks/")
+	id, action, _ := strings.Cut(rest, "/")
+	log.Printf("[REQUEST] %s /api/v1/webhooks/%s", r.Method, rest)
+	org := orgFromContext(r.Context())
+
+	switch {
+	case r.Method == http.MethodDelete && action == "":
+		if !s.webhooks.remove(org, id) {
+			writeError(w, http.StatusNotFound, "webhook not found")
+			return
+		}
+		log.Printf("[INFO] Deleted webhook %s", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && action == "deliveries":
+		deliveries, ok := s.webhooks.history(org, id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "webhook not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, WebhookDeliveriesResponse{Deliveries: deliveries})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createWebhook subscribes to events via the API and returns the webhook.
+func createWebhook(t *testing.T, router http.Handler, body string) Webhook {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewBufferString(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var hook Webhook
+	if err := json.NewDecoder(rr.Body).Decode(&hook); err != nil {
+		t.Fatalf("failed to decode webhook: %v", err)
+	}
+	return hook
+}
+
+// deliveries returns a webhook's deliveries via the API.
+func deliveries(t *testing.T, router http.Handler, id string) []WebhookDelivery {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/webhooks/"+id+"/deliveries", nil))
+	var resp WebhookDeliveriesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode deliveries: %v", err)
+	}
+	return resp.Deliveries
+}
+
+// waitForDelivery polls until the webhook's newest delivery leaves pending.
+func waitForDelivery(t *testing.T, router http.Handler, id string) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if list := deliveries(t, router, id); len(list) > 0 && list[0].Status != deliveryPending {
+			return list[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("delivery still pending")
+	return WebhookDelivery{}
+}
+
+// TestWebhooks_CRUD tests creating, listing and deleting subscriptions
+func TestWebhooks_CRUD(t *testing.T) {
+	router := setupTestServer().Router()
+
+	hook := createWebhook(t, router, `{"url": "https://example.com/hook", "events": ["device_online", "device_offline", "device_offline"], "secret": "s3cret"}`)
+	if len(hook.Events) != 2 || hook.Retry.MaxAttempts != defaultWebhookAttempts || hook.Retry.InitialBackoff.Duration != defaultWebhookBackoff {
+		t.Errorf("unexpected webhook %+v", hook)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/webhooks", nil))
+	if bytes.Contains(rr.Body.Bytes(), []byte("s3cret")) {
+		t.Error("secret leaked in list response")
+	}
+	var list WebhookListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list.Webhooks) != 1 {
+		t.Fatalf("expected 1 webhook, got %+v (%v)", list, err)
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/v1/webhooks/"+hook.ID, nil))
+		if rr.Code != want {
+			t.Errorf("expected %d, got %d", want, rr.Code)
+		}
+	}
+}
+
+// TestWebhooks_Validation tests rejected subscriptions
+func TestWebhooks_Validation(t *testing.T) {
+	router := setupTestServer().Router()
+	for name, body := range map[string]string{
+		"relative url":  `{"url": "/hook", "events": ["registration"], "secret": "s"}`,
+		"ftp url":       `{"url": "ftp://example.com", "events": ["registration"], "secret": "s"}`,
+		"no events":     `{"url": "https://example.com", "events": [], "secret": "s"}`,
+		"unknown event": `{"url": "https://example.com", "events": ["reboot"], "secret": "s"}`,
+		"anomaly":       `{"url": "https://example.com", "events": ["anomaly"], "secret": "s"}`,
+		"no secret":     `{"url": "https://example.com", "events": ["registration"]}`,
+		"attempts":      `{"url": "https://example.com", "events": ["registration"], "secret": "s", "retry": {"max_attempts": 50}}`,
+		"backoffs":      `{"url": "https://example.com", "events": ["registration"], "secret": "s", "retry": {"initial_backoff": "1m", "max_backoff": "1s"}}`,
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewBufferString(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+// TestWebhooks_Delivery tests a signed delivery after a retried failure
+func TestWebhooks_Delivery(t *testing.T) {
+	var calls atomic.Int32
+	var received WebhookEvent
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := checkSignature([]byte("s3cret"), r.Header.Get(signatureHeader), body); err != nil {
+			t.Errorf("bad signature: %v", err)
+		}
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	server := setupTestServer()
+	router := server.Router()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.RunWebhooks(ctx, 1)
+
+	hook := createWebhook(t, router, `{"url": "`+target.URL+`", "events": ["device_offline"], "secret": "s3cret", "retry": {"initial_backoff": "10ms"}}`)
+	server.NotifyWebhooks(WebhookDeviceOnline, "device-1", time.Now()) // not subscribed
+	server.NotifyWebhooks(WebhookDeviceOffline, "device-1", time.Now())
+
+	d := waitForDelivery(t, router, hook.ID)
+	if d.Status != deliveryDelivered || d.Attempts != 2 || d.StatusCode != http.StatusOK {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+	if received.Type != WebhookDeviceOffline || received.DeviceID != "device-1" {
+		t.Errorf("unexpected event %+v", received)
+	}
+	if list := deliveries(t, router, hook.ID); len(list) != 1 {
+		t.Errorf("expected only the subscribed event delivered, got %d", len(list))
+	}
+}
+
+// TestWebhooks_ClientErrorNotRetried tests that a refused delivery fails at once
+func TestWebhooks_ClientErrorNotRetried(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer target.Close()
+
+	server := setupTestServer()
+	router := server.Router()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.RunWebhooks(ctx, 1)
+
+	hook := createWebhook(t, router, `{"url": "`+target.URL+`", "events": ["registration"], "secret": "s"}`)
+	server.NotifyWebhooks(WebhookRegistration, "device-2", time.Now())
+
+	d := waitForDelivery(t, router, hook.ID)
+	if d.Status != deliveryFailed || d.Attempts != 1 || d.StatusCode != http.StatusGone || d.LastError == "" {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+}
+
+// TestWebhookRetryPolicy_Backoff tests exponential backoff with a cap
+func TestWebhookRetryPolicy_Backoff(t *testing.T) {
+	p := WebhookRetryPolicy{InitialBackoff: Duration{Duration: time.Second}, MaxBackoff: Duration{Duration: 5 * time.Second}}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+// TestOfflineMonitor_Notify tests that status changes are passed to Notify
+func TestOfflineMonitor_Notify(t *testing.T) {
+	s := NewStore()
+	s.devices["device-1"] = &DeviceStats{ID: "device-1"}
+	t1 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.RecordHeartbeat(t.Context(), "device-1", t1)
+
+	var events []string
+	m := NewOfflineMonitor(s, time.Minute)
+	m.Notify = func(eventType, deviceID string, at time.Time) { events = append(events, eventType) }
+
+	m.Check(t.Context(), t1.Add(2*time.Minute))
+	s.RecordHeartbeat(t.Context(), "device-1", t1.Add(3*time.Minute))
+	m.Check(t.Context(), t1.Add(3*time.Minute))
+	if len(events) != 2 || events[0] != WebhookDeviceOffline || events[1] != WebhookDeviceOnline {
+		t.Errorf("unexpected events %v", events)
+	}
+}
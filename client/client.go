@@ -0,0 +1,322 @@
+// Package client is a Go client for the SafelyYou device monitoring API,
+// for device agents reporting heartbeats and upload stats.
+//
+// Requests that fail with a network error, 429 or a 502/503/504 are retried
+// with exponential backoff and jitter, honoring Retry-After. Each attempt has
+// its own timeout, and the caller's context bounds the whole call.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used by New.
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	DefaultTimeout    = 10 * time.Second
+)
+
+// ErrNoData is returned by GetStats when the device has not reported yet.
+var ErrNoData = errors.New("no telemetry recorded for device")
+
+// APIError is a non-success response from the API.
+type APIError struct {
+	StatusCode int
+	Msg        string
+	Code       string // Machine-readable code, e.g. ERR_SENT_AT_FUTURE; may be empty
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("api error %d (%s): %s", e.StatusCode, e.Code, e.Msg)
+	}
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Msg)
+}
+
+// Client calls the API. Fields may be changed after New and before first use.
+type Client struct {
+	BaseURL    string // e.g. http://127.0.0.1:6733/api/v1
+	APIKey     string // Sent as X-API-Key when set
+	HTTPClient *http.Client
+
+	MaxRetries int           // Retries after the first attempt; 0 disables retries
+	MinBackoff time.Duration // Delay before the first retry, doubled for each one after
+	MaxBackoff time.Duration // Upper bound on a single delay
+	Timeout    time.Duration // Per-attempt timeout; 0 means only ctx applies
+}
+
+// New creates a client for the API at baseURL with default retry settings.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DefaultMaxRetries,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Timeout:    DefaultTimeout,
+	}
+}
+
+// Stats is a device's computed statistics.
+type Stats struct {
+	Uptime         float64
+	AvgUploadTime  time.Duration
+	MinUploadTime  time.Duration
+	MaxUploadTime  time.Duration
+	LastUploadTime time.Duration
+}
+
+// SendHeartbeat reports that the device was alive at sentAt.
+func (c *Client) SendHeartbeat(ctx context.Context, deviceID string, sentAt time.Time) error {
+	body := map[string]any{"sent_at": sentAt.UTC().Format(time.RFC3339Nano)}
+	_, err := c.do(ctx, http.MethodPost, devicePath(deviceID, "heartbeat"), body)
+	return err
+}
+
+// SendUploadStat reports how long a video upload took.
+func (c *Client) SendUploadStat(ctx context.Context, deviceID string, uploadTime time.Duration) error {
+	body := map[string]any{
+		"sent_at":     time.Now().UTC().Format(time.RFC3339Nano),
+		"upload_time": int64(uploadTime),
+	}
+	_, err := c.do(ctx, http.MethodPost, devicePath(deviceID, "stats"), body)
+	return err
+}
+
+// GetStats returns the device's uptime and upload statistics, or ErrNoData
+// if it has not reported yet.
+func (c *Client) GetStats(ctx context.Context, deviceID string) (*Stats, error) {
+	data, err := c.do(ctx, http.MethodGet, devicePath(deviceID, "stats"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrNoData
+	}
+
+	var resp struct {
+		Uptime         float64 `json:"uptime"`
+		AvgUploadTime  string  `json:"avg_upload_time"`
+		MinUploadTime  string  `json:"min_upload_time"`
+		MaxUploadTime  string  `json:"max_upload_time"`
+		LastUploadTime string  `json:"last_upload_time"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decode stats: %w", err)
+	}
+
+	stats := &Stats{Uptime: resp.Uptime}
+	for _, field := range []struct {
+		dst *time.Duration
+		src string
+	}{
+		{&stats.AvgUploadTime, resp.AvgUploadTime},
+		{&stats.MinUploadTime, resp.MinUploadTime},
+		{&stats.MaxUploadTime, resp.MaxUploadTime},
+		{&stats.LastUploadTime, resp.LastUploadTime},
+	} {
+		if field.src == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.src)
+		if err != nil {
+			return nil, fmt.Errorf("decode stats: %w", err)
+		}
+		*field.dst = d
+	}
+	return stats, nil
+}
+
+// Device is one entry of a device list.
+type Device struct {
+	ID              string    `json:"device_id"`
+	Org             string    `json:"org"`
+	FirmwareVersion string    `json:"firmware_version"`
+	AgentVersion    string    `json:"agent_version"`
+	LastHeartbeat   time.Time `json:"last_heartbeat"` // zero if the device never reported
+	Decommissioned  bool      `json:"decommissioned"`
+}
+
+// DevicePage is one page of devices, in ID order.
+type DevicePage struct {
+	Devices    []Device `json:"devices"`
+	NextCursor string   `json:"next_cursor"` // empty on the last page
+}
+
+// ListDevices returns the page of registered devices after cursor; pass ""
+// for the first page.
+func (c *Client) ListDevices(ctx context.Context, cursor string) (*DevicePage, error) {
+	path := "/devices"
+	if cursor != "" {
+		path += "?cursor=" + url.QueryEscape(cursor)
+	}
+	data, err := c.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var page DevicePage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("decode devices: %w", err)
+	}
+	return &page, nil
+}
+
+// Event is one entry in a device's timeline, such as going offline or
+// being decommissioned.
+type Event struct {
+	Type   string    `json:"type"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail"`
+}
+
+// DeviceEvents returns the device's recent timeline, oldest first.
+func (c *Client) DeviceEvents(ctx context.Context, deviceID string) ([]Event, error) {
+	data, err := c.do(ctx, http.MethodGet, devicePath(deviceID, "events"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decode events: %w", err)
+	}
+	return resp.Events, nil
+}
+
+func devicePath(deviceID, endpoint string) string {
+	return "/devices/" + url.PathEscape(deviceID) + "/" + endpoint
+}
+
+// do sends a request, retrying transient failures, and returns the body of
+// the successful response.
+func (c *Client) do(ctx context.Context, method, path string, body any) ([]byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		data, retryAfter, err := c.attempt(ctx, method, path, payload)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) {
+			return data, err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = min(retryAfter, c.MaxBackoff)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt makes a single request. retryAfter is set from the Retry-After header.
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte) (data []byte, retryAfter time.Duration, err error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	// The client decodes bare bodies, even from a server enveloping by default
+	req.Header.Set("X-Response-Envelope", "false")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return data, 0, nil
+	}
+
+	apiErr := &APIError{StatusCode: resp.StatusCode, Msg: http.StatusText(resp.StatusCode)}
+	// Problem details carry the message in detail; servers running with
+	// legacy errors send it as msg
+	var errBody struct {
+		Detail string `json:"detail"`
+		Msg    string `json:"msg"`
+		Code   string `json:"code"`
+	}
+	if json.Unmarshal(data, &errBody) == nil {
+		if errBody.Detail == "" {
+			errBody.Detail = errBody.Msg
+		}
+		if errBody.Detail != "" {
+			apiErr.Msg, apiErr.Code = errBody.Detail, errBody.Code
+		}
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, retryAfter, apiErr
+}
+
+// retryable reports whether a failed attempt may succeed if repeated.
+// Client errors such as 400 or 404 are final; the caller's own cancellation is too.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true // network errors and per-attempt timeouts
+}
+
+// backoff returns the delay before retry number attempt (from 0): the
+// doubled minimum, capped, with full jitter so many devices don't retry in step.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.MinBackoff << min(attempt, 30)
+	if d <= 0 || d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d)) + 1)
+}
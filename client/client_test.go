@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for ts with fast retries.
+func newTestClient(ts *httptest.Server) *Client {
+	c := New(ts.URL + "/api/v1")
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 5 * time.Millisecond
+	return c
+}
+
+// TestSendHeartbeat tests the request path, body and API key
+func TestSendHeartbeat(t *testing.T) {
+	sentAt := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/devices/device-1/heartbeat" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("X-API-Key") != "secret" {
+			t.Errorf("expected API key header, got %q", r.Header.Get("X-API-Key"))
+		}
+		var body struct {
+			SentAt time.Time `json:"sent_at"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if !body.SentAt.Equal(sentAt) {
+			t.Errorf("expected sent_at %v, got %v", sentAt, body.SentAt)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := newTestClient(ts)
+	c.APIKey = "secret"
+	if err := c.SendHeartbeat(context.Background(), "device-1", sentAt); err != nil {
+		t.Fatalf("SendHeartbeat failed: %v", err)
+	}
+}
+
+// TestRetryOnServiceUnavailable tests that 503s are retried until success
+func TestRetryOnServiceUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	if err := newTestClient(ts).SendUploadStat(context.Background(), "device-1", 3*time.Second); err != nil {
+		t.Fatalf("SendUploadStat failed: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+// TestNoRetryOnClientError tests that 4xx errors fail immediately with details
+func TestNoRetryOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"msg": "sent_at cannot be in the future", "code": "ERR_SENT_AT_FUTURE"}`))
+	}))
+	defer ts.Close()
+
+	err := newTestClient(ts).SendHeartbeat(context.Background(), "device-1", time.Now())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "ERR_SENT_AT_FUTURE" {
+		t.Fatalf("expected APIError with code, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 attempt, got %d", calls.Load())
+	}
+}
+
+// TestProblemDetailsError tests that problem detail responses are read too
+func TestProblemDetailsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"type": "/api/v1/errors#ERR_DEVICE_NOT_FOUND", "title": "Not Found", "status": 404, "detail": "device not found", "code": "ERR_DEVICE_NOT_FOUND"}`))
+	}))
+	defer ts.Close()
+
+	err := newTestClient(ts).SendHeartbeat(context.Background(), "device-1", time.Now())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "ERR_DEVICE_NOT_FOUND" || apiErr.Msg != "device not found" {
+		t.Fatalf("expected APIError with detail and code, got %v", err)
+	}
+}
+
+// TestRetriesExhausted tests that the last error is returned after MaxRetries
+func TestRetriesExhausted(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	c := newTestClient(ts)
+	c.MaxRetries = 2
+	err := c.SendHeartbeat(context.Background(), "device-1", time.Now())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 APIError, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+// TestPerAttemptTimeout tests that a hung attempt times out and is retried
+func TestPerAttemptTimeout(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			time.Sleep(200 * time.Millisecond) // longer than the client waits
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := newTestClient(ts)
+	c.Timeout = 50 * time.Millisecond
+	if err := c.SendHeartbeat(context.Background(), "device-1", time.Now()); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+}
+
+// TestGetStats tests decoding stats and the no-data case
+func TestGetStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/devices/empty/stats" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"uptime": 98.5, "avg_upload_time": "3m7.5s", "min_upload_time": "1s", "max_upload_time": "5m", "last_upload_time": "2s"}`))
+	}))
+	defer ts.Close()
+
+	c := newTestClient(ts)
+	stats, err := c.GetStats(context.Background(), "device-1")
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.Uptime != 98.5 || stats.AvgUploadTime != 3*time.Minute+7500*time.Millisecond || stats.MaxUploadTime != 5*time.Minute {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if _, err := c.GetStats(context.Background(), "empty"); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData, got %v", err)
+	}
+}
+
+// TestListDevices tests decoding a page and passing the cursor
+func TestListDevices(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("cursor") == "next" {
+			_, _ = w.Write([]byte(`{"devices": [{"device_id": "device-2"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"devices": [{"device_id": "device-1", "org": "acme", "last_heartbeat": "2024-01-15T10:00:00Z"}], "next_cursor": "next"}`))
+	}))
+	defer ts.Close()
+
+	c := newTestClient(ts)
+	page, err := c.ListDevices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("ListDevices failed: %v", err)
+	}
+	if len(page.Devices) != 1 || page.Devices[0].Org != "acme" || page.Devices[0].LastHeartbeat.IsZero() || page.NextCursor != "next" {
+		t.Errorf("unexpected first page: %+v", page)
+	}
+
+	page, err = c.ListDevices(context.Background(), page.NextCursor)
+	if err != nil || len(page.Devices) != 1 || page.Devices[0].ID != "device-2" || page.NextCursor != "" {
+		t.Errorf("unexpected last page: %+v (%v)", page, err)
+	}
+}
+
+// TestDeviceEvents tests decoding a device's timeline
+func TestDeviceEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/devices/device-1/events" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"device_id": "device-1", "events": [{"type": "registered", "at": "2024-01-15T10:00:00Z", "detail": "devices.csv"}, {"type": "offline", "at": "2024-01-15T11:00:00Z"}]}`))
+	}))
+	defer ts.Close()
+
+	events, err := newTestClient(ts).DeviceEvents(context.Background(), "device-1")
+	if err != nil {
+		t.Fatalf("DeviceEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Detail != "devices.csv" || events[1].Type != "offline" || events[1].At.Hour() != 11 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,219 @@
+// Command syctl queries the device monitoring API from the command line.
+//
+//	syctl [-url URL] [-api-key KEY] [-json] devices
+//	syctl [-url URL] [-api-key KEY] [-json] stats <device_id>
+//	syctl [-url URL] [-api-key KEY] [-json] [-interval D] tail <device_id>
+//
+// The API key defaults to $SAFELYYOU_API_KEY.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"safelyyou/client"
+)
+
+const usage = `usage: syctl [flags] <command> [args]
+
+commands:
+  devices             list registered devices
+  stats <device_id>   show a device's uptime and upload times
+  tail <device_id>    print a device's events, then follow new ones until interrupted
+
+flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "syctl:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run parses args and executes one command, writing its output to stdout.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("syctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	baseURL := flags.String("url", "http://127.0.0.1:6733/api/v1", "API base URL")
+	apiKey := flags.String("api-key", os.Getenv("SAFELYYOU_API_KEY"), "API key sent as X-API-Key")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	interval := flags.Duration("interval", 5*time.Second, "how often tail polls for new events")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c := client.New(*baseURL)
+	c.APIKey = *apiKey
+
+	switch flags.Arg(0) {
+	case "devices":
+		return listDevices(ctx, c, stdout, *asJSON)
+	case "stats":
+		if flags.NArg() != 2 {
+			return errors.New("stats takes one device ID")
+		}
+		return showStats(ctx, c, flags.Arg(1), stdout, *asJSON)
+	case "tail":
+		if flags.NArg() != 2 {
+			return errors.New("tail takes one device ID")
+		}
+		if *interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		return tailEvents(ctx, c, flags.Arg(1), *interval, stdout, *asJSON)
+	case "":
+		flags.Usage()
+		return flag.ErrHelp
+	default:
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+}
+
+// listDevices prints every device, following pages until the last.
+func listDevices(ctx context.Context, c *client.Client, stdout io.Writer, asJSON bool) error {
+	var devices []client.Device
+	for cursor := ""; ; {
+		page, err := c.ListDevices(ctx, cursor)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, page.Devices...)
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	if asJSON {
+		return printJSON(stdout, devices)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tORG\tFIRMWARE\tAGENT\tLAST HEARTBEAT")
+	for _, d := range devices {
+		last := "never"
+		if !d.LastHeartbeat.IsZero() {
+			last = d.LastHeartbeat.Format(time.RFC3339)
+		}
+		if d.Decommissioned {
+			last += " (decommissioned)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.ID, orDash(d.Org), orDash(d.FirmwareVersion), orDash(d.AgentVersion), last)
+	}
+	return tw.Flush()
+}
+
+// showStats prints one device's stats.
+func showStats(ctx context.Context, c *client.Client, deviceID string, stdout io.Writer, asJSON bool) error {
+	stats, err := c.GetStats(ctx, deviceID)
+	if errors.Is(err, client.ErrNoData) {
+		_, err = fmt.Fprintf(stdout, "%s has not reported yet\n", deviceID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(stdout, map[string]any{
+			"device_id":        deviceID,
+			"uptime":           stats.Uptime,
+			"avg_upload_time":  stats.AvgUploadTime.String(),
+			"min_upload_time":  stats.MinUploadTime.String(),
+			"max_upload_time":  stats.MaxUploadTime.String(),
+			"last_upload_time": stats.LastUploadTime.String(),
+		})
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "device\t%s\n", deviceID)
+	fmt.Fprintf(tw, "uptime\t%.2f%%\n", stats.Uptime)
+	fmt.Fprintf(tw, "upload time\tavg %v, min %v, max %v, last %v\n", stats.AvgUploadTime, stats.MinUploadTime, stats.MaxUploadTime, stats.LastUploadTime)
+	return tw.Flush()
+}
+
+// tailEvents prints the device's timeline, then polls every interval and
+// prints events added since, until ctx is cancelled. The API serves the
+// timeline rather than a stream, so new events are found by locating the
+// last one printed; if it has aged out of the timeline, everything is new.
+func tailEvents(ctx context.Context, c *client.Client, deviceID string, interval time.Duration, stdout io.Writer, asJSON bool) error {
+	var last *client.Event
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		events, err := c.DeviceEvents(ctx, deviceID)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if last != nil {
+			events = eventsAfter(events, *last)
+		}
+		for _, e := range events {
+			if err := printEvent(stdout, e, asJSON); err != nil {
+				return err
+			}
+			last = &e
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventsAfter returns the events after the last occurrence of last, or all
+// of them if it isn't there.
+func eventsAfter(events []client.Event, last client.Event) []client.Event {
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Type == last.Type && e.At.Equal(last.At) && e.Detail == last.Detail {
+			return events[i+1:]
+		}
+	}
+	return events
+}
+
+// printEvent prints one event as a line of text, or of JSON.
+func printEvent(w io.Writer, e client.Event, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(e)
+	}
+	line := fmt.Sprintf("%s  %-15s  %s", e.At.Format(time.RFC3339), e.Type, e.Detail)
+	_, err := fmt.Fprintln(w, strings.TrimRight(line, " "))
+	return err
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestAPI serves two pages of devices and stats for device-1.
+func newTestAPI(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/devices" && r.URL.Query().Get("cursor") == "":
+			_, _ = w.Write([]byte(`{"devices": [{"device_id": "device-1", "org": "acme", "last_heartbeat": "2024-01-15T10:00:00Z"}], "next_cursor": "c1"}`))
+		case r.URL.Path == "/api/v1/devices":
+			_, _ = w.Write([]byte(`{"devices": [{"device_id": "device-2", "decommissioned": true}]}`))
+		case r.URL.Path == "/api/v1/devices/device-1/stats":
+			_, _ = w.Write([]byte(`{"uptime": 99.5, "avg_upload_time": "2s", "min_upload_time": "1s", "max_upload_time": "3s", "last_upload_time": "2s"}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestRun_Devices tests listing every page of devices as a table and as JSON
+func TestRun_Devices(t *testing.T) {
+	ts := newTestAPI(t)
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"-url", ts.URL + "/api/v1", "-api-key", "secret", "devices"}, &out, io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	table := out.String()
+	if !strings.Contains(table, "device-1  acme") || !strings.Contains(table, "never (decommissioned)") {
+		t.Errorf("unexpected table:\n%s", table)
+	}
+
+	out.Reset()
+	if err := run(context.Background(), []string{"-url", ts.URL + "/api/v1", "-api-key", "secret", "-json", "devices"}, &out, io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var devices []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &devices); err != nil || len(devices) != 2 {
+		t.Errorf("expected 2 devices as JSON, got %s (%v)", out.String(), err)
+	}
+}
+
+// TestRun_Stats tests showing stats, and a device with no data
+func TestRun_Stats(t *testing.T) {
+	ts := newTestAPI(t)
+	args := []string{"-url", ts.URL + "/api/v1", "-api-key", "secret", "stats"}
+
+	var out bytes.Buffer
+	if err := run(context.Background(), append(args, "device-1"), &out, io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "99.50%") || !strings.Contains(out.String(), "max 3s") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := run(context.Background(), append(args, "device-2"), &out, io.Discard); err != nil || !strings.Contains(out.String(), "not reported yet") {
+		t.Errorf("expected no data message, got %q (%v)", out.String(), err)
+	}
+}
+
+// TestRun_Tail tests that tail prints the timeline, then only the events
+// added between polls
+func TestRun_Tail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var polls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := `{"type": "registered", "at": "2024-01-15T10:00:00Z", "detail": "devices.csv"}, {"type": "offline", "at": "2024-01-15T11:00:00Z", "detail": "no heartbeat for 5m0s"}`
+		switch polls.Add(1) {
+		case 1:
+		case 2:
+			events += `, {"type": "online", "at": "2024-01-15T11:30:00Z"}`
+		default:
+			events += `, {"type": "online", "at": "2024-01-15T11:30:00Z"}`
+			cancel()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"device_id": "device-1", "events": [` + events + `]}`))
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	if err := run(ctx, []string{"-url", ts.URL + "/api/v1", "-interval", "1ms", "tail", "device-1"}, &out, io.Discard); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	want := `2024-01-15T10:00:00Z  registered       devices.csv
+2024-01-15T11:00:00Z  offline          no heartbeat for 5m0s
+2024-01-15T11:30:00Z  online
+`
+	if out.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+// TestRun_Errors tests usage and API errors
+func TestRun_Errors(t *testing.T) {
+	ts := newTestAPI(t)
+	for _, args := range [][]string{
+		{"bogus"},
+		{"stats"},
+		{"tail"},
+		{"-interval", "0s", "tail", "device-1"},
+		{"-url", ts.URL + "/api/v1", "devices"}, // no API key
+	} {
+		if err := run(context.Background(), args, io.Discard, io.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package integration runs the API end to end: a real server on a random
+// port, driven over HTTP by the Go client with realistic device traffic,
+// checking the stats it reports against values computed independently of
+// the store. Unit tests in package api cover the pieces; these cover the
+// wiring between them.
+//
+// The storage backend is chosen with SAFELYYOU_TEST_STORAGE (default
+// "memory") and SAFELYYOU_TEST_STORAGE_DSN, so the suite can be pointed at
+// any registered backend, such as one started in a container by CI.
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"safelyyou/api"
+	"safelyyou/client"
+)
+
+// harness is a running server and a client pointed at it.
+type harness struct {
+	server *api.Server
+	store  api.Storage
+	client *client.Client
+	url    string // base URL of the API, e.g. http://127.0.0.1:41234/api/v1
+}
+
+// harnessOption configures the server before it starts listening.
+type harnessOption func(*api.Server)
+
+// withAsyncWrites queues telemetry for background writes, as -async-queue-size does.
+func withAsyncWrites(queueSize, workers int) harnessOption {
+	return func(s *api.Server) { s.EnableAsyncWrites(queueSize, workers) }
+}
+
+// withAuth requires API keys, as an api_keys.csv does.
+func withAuth(keys api.APIKeys) harnessOption {
+	return func(s *api.Server) { s.EnableAuth(keys) }
+}
+
+// startHarness registers the devices in devicesCSV and serves the API on a
+// random loopback port until the test ends.
+func startHarness(t *testing.T, devicesCSV string, opts ...harnessOption) *harness {
+	t.Helper()
+
+	backend := os.Getenv("SAFELYYOU_TEST_STORAGE")
+	if backend == "" {
+		backend = "memory"
+	}
+	store, err := api.NewStorage(backend, os.Getenv("SAFELYYOU_TEST_STORAGE_DSN"))
+	if err != nil {
+		t.Fatalf("failed to create %s storage: %v", backend, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	if err := os.WriteFile(path, []byte(devicesCSV), 0o644); err != nil {
+		t.Fatalf("failed to write devices: %v", err)
+	}
+	if err := store.LoadDevicesFromCSV(t.Context(), path); err != nil {
+		t.Fatalf("failed to load devices: %v", err)
+	}
+
+	server := api.NewServer(store, nil)
+	server.SetDeviceSources(path, nil)
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: server.Router(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("server failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(ctx)
+	})
+
+	url := "http://" + listener.Addr().String() + "/api/v1"
+	c := client.New(url)
+	c.MinBackoff = 10 * time.Millisecond
+	return &harness{server: server, store: store, client: c, url: url}
+}
+
+// devicesCSV returns a device CSV listing n devices named prefix-0 onwards.
+func devicesCSV(prefix string, n int, columns string, row func(i int) string) string {
+	var b strings.Builder
+	b.WriteString("device_id")
+	if columns != "" {
+		b.WriteString("," + columns)
+	}
+	b.WriteString("\n")
+	for i := range n {
+		fmt.Fprintf(&b, "%s-%d", prefix, i)
+		if row != nil {
+			b.WriteString("," + row(i))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"safelyyou/api"
+	"safelyyou/client"
+)
+
+// fleetSize is how many devices the traffic tests simulate.
+const fleetSize = 20
+
+// simulation is the traffic one device sends and the stats it should produce.
+type simulation struct {
+	deviceID   string
+	heartbeats []time.Time
+	uploads    []time.Duration
+}
+
+// expected computes the device's stats from what it sent, independently of
+// the store: heartbeats over the expected one-minute heartbeats in the
+// window, and the mean of the upload times.
+func (sim simulation) expected() client.Stats {
+	window := sim.heartbeats[len(sim.heartbeats)-1].Sub(sim.heartbeats[0])
+	uptime := float64(len(sim.heartbeats)) / (window.Minutes() + 1) * 100
+
+	var sum time.Duration
+	for _, upload := range sim.uploads {
+		sum += upload
+	}
+	return client.Stats{
+		Uptime:         uptime,
+		AvgUploadTime:  sum / time.Duration(len(sim.uploads)),
+		MinUploadTime:  sim.uploads[0],
+		MaxUploadTime:  sim.uploads[len(sim.uploads)-1],
+		LastUploadTime: sim.uploads[len(sim.uploads)-1],
+	}
+}
+
+// simulateFleet plans an hour of one-minute heartbeats ending now for each
+// device, with each device missing a different set of them, plus a few
+// uploads of increasing duration. Devices miss few enough heartbeats that
+// interval detection still sees a one-minute cadence.
+func simulateFleet(prefix string) []simulation {
+	end := time.Now().Add(-time.Minute).Truncate(time.Second)
+	start := end.Add(-time.Hour)
+
+	sims := make([]simulation, fleetSize)
+	for i := range sims {
+		sim := simulation{deviceID: fmt.Sprintf("%s-%d", prefix, i)}
+		for minute := 0; minute <= 60; minute++ {
+			// Keep the first and last heartbeat so the window is exactly an hour
+			missed := minute > 0 && minute < 60 && (minute+i)%(i%5+7) == 0
+			if !missed {
+				sim.heartbeats = append(sim.heartbeats, start.Add(time.Duration(minute)*time.Minute))
+			}
+		}
+		for j := range 5 {
+			sim.uploads = append(sim.uploads, time.Duration(i+1)*100*time.Millisecond+time.Duration(j)*10*time.Millisecond)
+		}
+		sims[i] = sim
+	}
+	return sims
+}
+
+// send replays every device's traffic concurrently, one goroutine per device.
+func send(t *testing.T, c *client.Client, sims []simulation) {
+	t.Helper()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(sims))
+	for _, sim := range sims {
+		wg.Go(func() {
+			for _, at := range sim.heartbeats {
+				if err := c.SendHeartbeat(ctx, sim.deviceID, at); err != nil {
+					errs <- fmt.Errorf("%s heartbeat: %w", sim.deviceID, err)
+					return
+				}
+			}
+			for _, upload := range sim.uploads {
+				if err := c.SendUploadStat(ctx, sim.deviceID, upload); err != nil {
+					errs <- fmt.Errorf("%s upload: %w", sim.deviceID, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// checkStats compares every device's reported stats with its expected ones.
+func checkStats(t *testing.T, c *client.Client, sims []simulation) {
+	t.Helper()
+	for _, sim := range sims {
+		got, err := c.GetStats(context.Background(), sim.deviceID)
+		if err != nil {
+			t.Errorf("%s: %v", sim.deviceID, err)
+			continue
+		}
+		want := sim.expected()
+		if math.Abs(got.Uptime-want.Uptime) > 0.01 {
+			t.Errorf("%s: expected uptime %.2f, got %.2f", sim.deviceID, want.Uptime, got.Uptime)
+		}
+		got.Uptime = want.Uptime
+		if *got != want {
+			t.Errorf("%s: expected upload stats %+v, got %+v", sim.deviceID, want, *got)
+		}
+	}
+}
+
+// TestFleetTraffic tests stats for a fleet reporting concurrently, half with
+// a configured heartbeat interval and half with a detected one
+func TestFleetTraffic(t *testing.T) {
+	h := startHarness(t, devicesCSV("cam", fleetSize, "heartbeat_interval", func(i int) string {
+		if i%2 == 0 {
+			return "1m"
+		}
+		return ""
+	}))
+	sims := simulateFleet("cam")
+
+	send(t, h.client, sims)
+	checkStats(t, h.client, sims)
+
+	var apiErr *client.APIError
+	if _, err := h.client.GetStats(context.Background(), "cam-unknown"); !errors.As(err, &apiErr) || apiErr.Code != "ERR_DEVICE_NOT_FOUND" {
+		t.Errorf("expected ERR_DEVICE_NOT_FOUND for an unregistered device, got %v", err)
+	}
+	page, err := h.client.ListDevices(context.Background(), "")
+	if err != nil || len(page.Devices) != fleetSize {
+		t.Errorf("expected %d devices listed, got %+v (%v)", fleetSize, page, err)
+	}
+}
+
+// TestFleetTraffic_AsyncWrites tests that queued writes add up to the same
+// stats once the queue is drained
+func TestFleetTraffic_AsyncWrites(t *testing.T) {
+	h := startHarness(t, devicesCSV("kiosk", fleetSize, "", nil), withAsyncWrites(64, 4))
+	sims := simulateFleet("kiosk")
+
+	send(t, h.client, sims)
+	h.server.StopAsyncWrites()
+	checkStats(t, h.client, sims)
+}
+
+// TestFleetTraffic_Organizations tests that an organization's key only
+// reaches its own devices
+func TestFleetTraffic_Organizations(t *testing.T) {
+	h := startHarness(t, devicesCSV("cam", fleetSize, "org", func(i int) string {
+		return []string{"acme", "globex"}[i%2]
+	}), withAuth(api.APIKeys{"acme-key": "acme", "globex-key": "globex"}))
+	sims := simulateFleet("cam")
+
+	acme := client.New(h.url)
+	acme.APIKey = "acme-key"
+	var own []simulation
+	for i, sim := range sims {
+		if i%2 == 0 {
+			own = append(own, sim)
+		}
+	}
+	send(t, acme, own)
+	checkStats(t, acme, own)
+
+	var apiErr *client.APIError
+	err := acme.SendHeartbeat(context.Background(), sims[1].deviceID, time.Now())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for another organization's device, got %v", err)
+	}
+	if _, err := h.client.GetStats(context.Background(), sims[0].deviceID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a key, got %v", err)
+	}
+}
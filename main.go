@@ -1,37 +1,688 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"safelyyou/api"
 )
 
 const (
-	port       = ":6733"
-	devicesCSV = "devices.csv"
+	apiKeysCSV = "api_keys.csv"
+
+	// shutdownTimeout bounds how long in-flight requests may take to drain
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("[ERROR] Migration failed: %v", err)
+		}
+		return
+	}
+
+	devicesSpec := flag.String("devices", "devices.csv", "comma-separated device CSV files and globs (e.g. facilities/*.csv); a device listed in two files is an error")
+	snmpAddr := flag.String("snmp-addr", "", "UDP address for the read-only SNMP agent (e.g. :1161); empty disables it")
+	snmpCommunity := flag.String("snmp-community", "public", "SNMP community string")
+	snmpBaseOID := flag.String("snmp-base-oid", api.DefaultSNMPBaseOID, "OID subtree served by the SNMP agent")
+	validation := api.DefaultValidationConfig()
+	flag.DurationVar(&validation.MaxFutureSkew, "max-future-skew", validation.MaxFutureSkew, "how far ahead of server time sent_at may be")
+	flag.DurationVar(&validation.MaxPastAge, "max-sent-at-age", validation.MaxPastAge, "reject telemetry with sent_at older than this; 0 disables")
+	flag.DurationVar(&validation.MaxUploadTime, "max-upload-time", validation.MaxUploadTime, "longest accepted upload_time; 0 disables")
+	flag.DurationVar(&validation.MaxHeartbeatInterval, "max-heartbeat-interval", validation.MaxHeartbeatInterval, "longest accepted declared heartbeat_interval; 0 disables")
+	flag.DurationVar(&validation.MaxUploadInterval, "max-upload-interval", validation.MaxUploadInterval, "longest accepted declared upload_interval; 0 disables")
+	flag.IntVar(&validation.MaxVersionLength, "max-version-length", validation.MaxVersionLength, "longest accepted firmware/agent version string; 0 disables")
+	rateLimit := flag.Float64("rate-limit", 0, "requests per second allowed per client IP; 0 disables")
+	rateBurst := flag.Int("rate-burst", 20, "requests a client may burst above the rate limit")
+	reportAt := flag.String("report-at", "", "local time (HH:MM) to send the daily fleet report; empty disables it")
+	reportSlack := flag.String("report-slack-webhook", "", "Slack incoming webhook URL for fleet reports")
+	reportSMTPAddr := flag.String("report-smtp-addr", "", "SMTP relay host:port for fleet reports")
+	reportSMTPFrom := flag.String("report-smtp-from", "", "sender address for fleet report emails")
+	reportSMTPTo := flag.String("report-smtp-to", "", "comma-separated recipients for fleet report emails")
+	reportSMTPUser := flag.String("report-smtp-user", "", "SMTP username; the password is read from REPORT_SMTP_PASSWORD")
+	storageBackend := flag.String("storage", "memory", "storage backend, one of: "+strings.Join(api.StorageBackends(), ", "))
+	storageDSN := flag.String("storage-dsn", "", "backend-specific connection string, such as a file path or server address; unused by memory")
+	shadowBackend := flag.String("shadow-storage", "", "candidate storage backend to mirror writes onto and compare reads against, for validating it before cutover; empty disables")
+	shadowDSN := flag.String("shadow-storage-dsn", "", "connection string for the -shadow-storage backend")
+	snapshotFile := flag.String("snapshot-file", "", "file to restore aggregates from at startup and snapshot them to; empty disables persistence")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "how often to write periodic snapshots")
+	offlineAfter := flag.Duration("offline-after", api.DefaultOfflineAfter, "heartbeat silence before alerting for devices without an alert_after column")
+	offlineCheckInterval := flag.Duration("offline-check-interval", 30*time.Second, "how often the offline monitor checks heartbeat gaps; 0 disables it")
+	outagePercent := flag.Float64("outage-percent", 0, "raise one facility outage alert instead of device alerts when more than this percent of a facility's devices are offline; 0 disables")
+	outageMinDevices := flag.Int("outage-min-devices", 5, "smallest facility, in monitored devices, that can have an outage")
+	cors := api.DefaultCORSConfig()
+	corsOrigins := flag.String("cors-origins", "", "comma-separated browser origins allowed to call the API (\"*\" for any); empty disables CORS")
+	corsMethods := flag.String("cors-methods", strings.Join(cors.AllowedMethods, ","), "comma-separated methods allowed in cross-origin requests")
+	corsHeaders := flag.String("cors-headers", strings.Join(cors.AllowedHeaders, ","), "comma-separated request headers allowed in cross-origin requests")
+	flag.DurationVar(&cors.MaxAge, "cors-max-age", cors.MaxAge, "how long browsers may cache CORS preflight results")
+	chaosMode := flag.Bool("chaos", false, "development only: inject latency, 500s and dropped responses into API requests to exercise device retry logic")
+	chaos := api.DefaultChaosConfig()
+	flag.DurationVar(&chaos.Latency, "chaos-latency", chaos.Latency, "most latency added to a delayed request in chaos mode")
+	flag.Float64Var(&chaos.LatencyPercent, "chaos-latency-percent", chaos.LatencyPercent, "percentage of requests delayed in chaos mode")
+	flag.Float64Var(&chaos.ErrorPercent, "chaos-error-percent", chaos.ErrorPercent, "percentage of requests answered with a 500 in chaos mode")
+	flag.Float64Var(&chaos.DropPercent, "chaos-drop-percent", chaos.DropPercent, "percentage of requests handled and then cut off without a response in chaos mode")
+	routeConcurrency := flag.String("route-concurrency", "", "comma-separated per-route concurrency limits as [METHOD ]ROUTE=MAX, e.g. 'GET /api/v1/devices/{device_id}/stats=32'; empty disables")
+	routeConcurrencyWait := flag.Duration("route-concurrency-wait", api.DefaultRouteConcurrencyWait, "how long a request waits for a slot on a concurrency-limited route before 503")
+	handlerTimeout := flag.Duration("handler-timeout", 0, "maximum time to handle a request before responding 503; 0 disables")
+	receipts := flag.Bool("receipts", false, "answer telemetry with 202 and a receipt ID that GET /api/v1/receipts/{id} confirms once applied")
+	receiptCapacity := flag.Int("receipt-capacity", api.DefaultReceiptCapacity, "receipts remembered in receipt mode; the oldest are forgotten first")
+	receiptDeviceCapacity := flag.Int("receipt-device-capacity", 0, "receipts remembered per device in receipt mode, so one device can't push out the rest; 0 is unlimited")
+	diagnosticsDir := flag.String("diagnostics-dir", "", "directory to store device diagnostics bundles in; empty disables diagnostics uploads")
+	diagnosticsMaxSize := flag.Int64("diagnostics-max-size", api.DefaultDiagnosticsMaxSize, "largest diagnostics bundle accepted, in bytes")
+	diagnosticsRetention := flag.Duration("diagnostics-retention", api.DefaultDiagnosticsRetention, "how long diagnostics bundles are kept before housekeeping deletes them")
+	asyncQueue := flag.Int("async-queue-size", 0, "queue telemetry for background writes with this many slots and respond 202; 0 writes synchronously")
+	asyncWorkers := flag.Int("async-workers", 4, "workers applying queued telemetry")
+	publishURL := flag.String("publish-url", "", "broker to publish accepted telemetry to: nats://host:4222 or kafka+http://rest-proxy:8082; empty disables publishing")
+	publishTopic := flag.String("publish-topic", "safelyyou.telemetry", "NATS subject or Kafka topic for published telemetry")
+	publishBuffer := flag.Int("publish-buffer", 10000, "events buffered for publishing before new ones are dropped")
+	deadLetterSize := flag.Int("deadletter-size", api.DefaultDeadLetterCapacity, "rejected telemetry payloads kept for inspection and replay; 0 disables")
+	recentUploads := flag.Int("recent-uploads", api.DefaultRecentUploads, "upload records kept per device for debugging; 0 disables")
+	var limits api.MemoryLimits
+	flag.IntVar(&limits.HistoryDevices, "max-history-devices", 0, "devices keeping hourly history (~40 KB each); at the cap the least recently written are evicted, a sixteenth of the cap at a time. 0 is unlimited")
+	flag.IntVar(&limits.UploadDevices, "max-upload-devices", 0, "devices keeping recent upload records; at the cap the least recently written are evicted, a sixteenth of the cap at a time. 0 is unlimited")
+	intervalSamples := flag.Int("interval-samples", api.DefaultIntervalSamples, "recent heartbeat gaps whose median sets the cadence of devices without a configured heartbeat_interval; 0 disables detection")
+	udpHeartbeatAddr := flag.String("udp-heartbeat-addr", "", "UDP address for signed binary heartbeats (e.g. :6734); the secret is read from UDP_HEARTBEAT_SECRET. Empty disables it")
+	listen := flag.String("listen", ":6733", "comma-separated addresses to serve the API on: host:port (\":6733\" is dual-stack IPv4 and IPv6), tcp4:host:port or tcp6:host:port for one family, or unix:/path/to.sock")
+	metricsDetail := flag.Bool("metrics-detail", false, "split /metrics telemetry histograms by organization, with device ID exemplars for OpenMetrics scrapers; /metrics is unauthenticated, so enable only where scrapes are private")
+	legacyErrors := flag.Bool("legacy-errors", false, "answer errors with the original {\"msg\", \"code\"} JSON instead of application/problem+json, for clients not yet migrated")
+	precision := flag.Int("precision", -1, "decimal places uptime and durations are rounded to in responses, 0-9; requests can override it with ?precision=. -1 keeps them exact")
+	responseEnvelope := flag.Bool("response-envelope", false, "wrap JSON responses as {\"data\": ..., \"error\": ...} for legacy consumers; requests can override it with X-Response-Envelope")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated proxy addresses or CIDR ranges whose X-Forwarded-For and X-Real-IP are believed when recording where heartbeats came from and rate limiting; empty uses the connection's address")
+	readAddr := flag.String("read-addr", "", "addresses for a second listener serving only reads (GET, HEAD, OPTIONS), in the same form as -listen, e.g. 127.0.0.1:6735; the main listeners then stop serving reads. Empty serves everything on the main listeners")
+	leaderLock := flag.String("leader-lock", "", "lock file shared with a standby instance; only the holder ingests and runs background jobs. Empty runs standalone")
+	enrollmentTokens := flag.String("enrollment-tokens", "", "CSV of one-time device enrollment tokens (token,org); empty disables enrollment")
+	housekeepingInterval := flag.Duration("housekeeping-interval", 10*time.Minute, "how often to compact the store and sample memory use; 0 disables it")
+	decommissionRetention := flag.Duration("decommission-retention", api.DefaultDecommissionRetention, "how long decommissioned devices are kept before housekeeping prunes them; 0 keeps them forever")
+	topologyURL := flag.String("topology-url", "", "facility management API listing each device's room and facility, synced into the device CSVs (token in FACILITY_API_TOKEN); empty disables the sync")
+	topologyInterval := flag.Duration("topology-interval", 15*time.Minute, "how often to sync the facility topology")
+	webhookWorkers := flag.Int("webhook-workers", 4, "workers delivering webhook notifications")
+	leaderRetry := flag.Duration("leader-retry", 5*time.Second, "how often a standby retries the leader lock")
+	orgQuotas := flag.String("org-quotas", "", "CSV of per-organization quotas (org,max_devices,max_daily_requests); empty leaves every organization unlimited")
+	logSink := flag.String("log-sink", "text", "where logs go, one of: "+strings.Join(api.LogSinks(), ", "))
+	logLevel := flag.String("log-level", "info", "least severe log lines written, one of: "+strings.Join(api.LogLevels(), ", "))
+	flag.Parse()
+
+	logCloser, err := api.ConfigureLogging(*logSink, *logLevel)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to configure logging: %v", err)
+	}
+	defer func() { _ = logCloser.Close() }()
+
+	// Cancelled on SIGINT/SIGTERM to trigger graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log.Println("[STARTUP] SafelyYou Device Monitoring API")
 
-	// Load devices from CSV
-	store := NewStore()
-	var configErr error
+	store, err := api.NewStorage(*storageBackend, *storageDSN)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to open storage: %v", err)
+	}
+	log.Printf("[CONFIG] Storage backend: %s", *storageBackend)
+
+	// Snapshots only apply to backends that don't persist on their own
+	snapshotter, canSnapshot := store.(api.Snapshotter)
+	if *snapshotFile != "" && !canSnapshot {
+		log.Fatalf("[ERROR] -snapshot-file is not supported by the %s storage backend", *storageBackend)
+	}
+
+	// A shadowed store snapshots through the shadow, so a restore seeds the
+	// candidate too
+	if *shadowBackend != "" {
+		candidate, err := api.NewStorage(*shadowBackend, *shadowDSN)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to open shadow storage: %v", err)
+		}
+		shadow := api.NewShadowStorage(store, candidate)
+		go shadow.Run(ctx)
+		store = shadow
+		if canSnapshot {
+			snapshotter = shadow
+		}
+		log.Printf("[CONFIG] Shadow storage backend: %s", *shadowBackend)
+	}
+
+	// Devices load once the listeners are up (see below); a failed load can
+	// be fixed with a reload
+	var devicePaths []string
+	var devicesErr, keysErr, configErr error
+
+	// Load API keys; a missing file leaves the API unauthenticated
+	keys, err := api.LoadAPIKeysFromCSV(apiKeysCSV)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Printf("[CONFIG] No %s found, API authentication disabled", apiKeysCSV)
+	case err != nil:
+		// Fail closed: a broken key file must not silently disable auth
+		log.Printf("[ERROR] Failed to load API keys from %s: %v", apiKeysCSV, err)
+		keysErr = err
+	default:
+		log.Printf("[CONFIG] Loaded %d API keys from %s", len(keys), apiKeysCSV)
+	}
 
-	if err := store.LoadDevicesFromCSV(devicesCSV); err != nil {
-		log.Printf("[ERROR] Failed to load devices from %s: %v", devicesCSV, err)
-		configErr = err
-	} else {
-		log.Printf("[CONFIG] Loaded %d devices from %s", store.DeviceCount(), devicesCSV)
+	store.SetIntervalSamples(*intervalSamples)
+	if bounded, ok := store.(api.MemoryBounded); ok {
+		bounded.SetRecentUploadCapacity(*recentUploads)
+		bounded.SetMemoryLimits(limits)
+	}
+	if limits != (api.MemoryLimits{}) {
+		log.Printf("[CONFIG] Memory limits: history for %d devices, upload records for %d (0 is unlimited)", limits.HistoryDevices, limits.UploadDevices)
+	}
+
+	// Create server (will return 503s until devices load, and 500s while
+	// either load has failed)
+	server := api.NewServer(store, keysErr)
+	// Everything else writes through the server, so its cached stats and
+	// snapshots see the change
+	store = server.Storage()
+	if canSnapshot {
+		snapshotter = server.Snapshotter(snapshotter)
+	}
+	server.SetLoading(true)
+	server.SetValidationConfig(validation)
+	server.SetDeadLetterCapacity(*deadLetterSize)
+	server.SetOfflineAfter(*offlineAfter)
+	server.EnableAuth(keys)
+	if *orgQuotas != "" {
+		quotas, err := api.LoadQuotasFromCSV(*orgQuotas)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load quotas from %s: %v", *orgQuotas, err)
+		}
+		server.SetQuotas(quotas)
+		log.Printf("[CONFIG] Loaded quotas for %d organizations from %s", len(quotas), *orgQuotas)
+	}
+	if *metricsDetail {
+		server.SetMetricsDetail(true)
+		log.Printf("[CONFIG] Per-organization telemetry histograms and device exemplars enabled on /metrics")
+	}
+	if *legacyErrors {
+		server.SetLegacyErrors(true)
+		log.Printf("[CONFIG] Legacy error responses enabled")
+	}
+	if *precision > 9 {
+		log.Fatalf("[ERROR] Invalid -precision: at most 9 decimal places")
+	}
+	if *precision >= 0 {
+		server.SetPrecision(*precision)
+		log.Printf("[CONFIG] Rounding uptime and durations to %d decimal places", *precision)
+	}
+	if *responseEnvelope {
+		server.SetResponseEnvelope(true)
+		log.Printf("[CONFIG] Response envelopes enabled")
+	}
+	if *outagePercent < 0 || *outagePercent >= 100 {
+		log.Fatalf("[ERROR] Invalid -outage-percent: must be at least 0 and below 100")
 	}
+	if *trustedProxies != "" {
+		proxies, err := api.ParseTrustedProxies(*trustedProxies)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid -trusted-proxies: %v", err)
+		}
+		server.SetTrustedProxies(proxies)
+		log.Printf("[CONFIG] Trusting X-Forwarded-For and X-Real-IP from %v", proxies)
+	}
+	if *rateLimit > 0 {
+		server.EnableRateLimit(*rateLimit, *rateBurst)
+		log.Printf("[CONFIG] Rate limit: %.1f req/s per client, burst %d", *rateLimit, *rateBurst)
+	}
+
+	if secret := os.Getenv("DEVICE_SIGNING_SECRET"); secret != "" {
+		server.EnableSigning([]byte(secret))
+		log.Printf("[CONFIG] Payload signatures required for every device")
+	}
+
+	if *asyncQueue > 0 {
+		server.EnableAsyncWrites(*asyncQueue, *asyncWorkers)
+		log.Printf("[CONFIG] Async writes: queue %d, %d workers", *asyncQueue, *asyncWorkers)
+	}
+	if *receipts {
+		server.EnableReceipts(*receiptCapacity, *receiptDeviceCapacity)
+		log.Printf("[CONFIG] Receipts enabled, keeping the last %d", *receiptCapacity)
+	}
+	if *diagnosticsDir != "" {
+		if err := server.EnableDiagnostics(*diagnosticsDir, *diagnosticsMaxSize, *diagnosticsRetention); err != nil {
+			log.Fatalf("[ERROR] Failed to configure diagnostics uploads: %v", err)
+		}
+		log.Printf("[CONFIG] Diagnostics bundles stored in %s, up to %d bytes, kept %v", *diagnosticsDir, *diagnosticsMaxSize, *diagnosticsRetention)
+	}
+	if *publishURL != "" {
+		publisher, err := api.NewEventPublisher(*publishURL, *publishTopic)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to configure event publishing: %v", err)
+		}
+		server.EnablePublishing(publisher, *publishBuffer)
+		log.Printf("[CONFIG] Publishing telemetry to %s topic %s", *publishURL, *publishTopic)
+	}
+	if *routeConcurrency != "" {
+		limits, err := api.ParseRouteLimits(*routeConcurrency)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid -route-concurrency: %v", err)
+		}
+		server.EnableRouteLimits(limits, *routeConcurrencyWait)
+		log.Printf("[CONFIG] Concurrency limits on %d routes, waiting up to %v for a slot", len(limits), *routeConcurrencyWait)
+	}
+	if *handlerTimeout > 0 {
+		server.SetHandlerTimeout(*handlerTimeout)
+		log.Printf("[CONFIG] Handler timeout: %v", *handlerTimeout)
+	}
+	if *chaosMode {
+		if err := server.EnableChaos(chaos); err != nil {
+			log.Fatalf("[ERROR] Invalid chaos settings: %v", err)
+		}
+		log.Printf("[WARN] Chaos mode enabled, do not use in production: %.1f%% of requests delayed up to %v, %.1f%% failed, %.1f%% dropped",
+			chaos.LatencyPercent, chaos.Latency, chaos.ErrorPercent, chaos.DropPercent)
+	}
+	if *corsOrigins != "" {
+		cors.AllowedOrigins = splitList(*corsOrigins)
+		cors.AllowedMethods = splitList(*corsMethods)
+		cors.AllowedHeaders = splitList(*corsHeaders)
+		server.EnableCORS(cors)
+		log.Printf("[CONFIG] CORS enabled for origins %v", cors.AllowedOrigins)
+	}
+
+	// Start the optional SNMP agent
+	if *snmpAddr != "" {
+		startSNMPAgent(store, *snmpAddr, *snmpCommunity, *snmpBaseOID)
+	}
+
+	// Start the optional UDP heartbeat listener
+	stopUDP := func() {}
+	if *udpHeartbeatAddr != "" {
+		stopUDP = startUDPHeartbeatListener(server, *udpHeartbeatAddr, os.Getenv("UDP_HEARTBEAT_SECRET"))
+	}
+
+	// Jobs that only the active instance runs: restoring the latest snapshot
+	// (a standby's would be stale by the time it takes over), reports, alerts
+	// and periodic snapshots
+	startActive := func() {
+		// A standby's registry predates devices the previous leader enrolled
+		if *leaderLock != "" && *enrollmentTokens != "" && configErr == nil {
+			reloadRegistry(ctx, server, store, *devicesSpec, len(keys) > 0)
+		}
+
+		// Restore aggregates saved by a previous run
+		if *snapshotFile != "" && configErr == nil {
+			restoreSnapshot(snapshotter, *snapshotFile)
+		}
+
+		// A registry over an organization's device quota fails requests like
+		// a bad device CSV until a reload fixes it. Checked after the restore,
+		// which brings back which devices are decommissioned
+		if configErr == nil {
+			if err := server.CheckDeviceQuotas(ctx); err != nil {
+				log.Printf("[ERROR] Device registry refused: %v", err)
+				server.SetDeviceSources(*devicesSpec, err)
+			}
+		}
+
+		// Start the optional daily fleet report
+		if *reportAt != "" {
+			var sender api.ReportSender
+			switch {
+			case *reportSlack != "":
+				sender = &api.SlackSender{WebhookURL: *reportSlack, Client: &http.Client{Timeout: 10 * time.Second}}
+			case *reportSMTPAddr != "":
+				smtpSender := &api.SMTPSender{Addr: *reportSMTPAddr, From: *reportSMTPFrom, To: strings.Split(*reportSMTPTo, ",")}
+				if *reportSMTPUser != "" {
+					host, _, _ := net.SplitHostPort(*reportSMTPAddr)
+					smtpSender.Auth = smtp.PlainAuth("", *reportSMTPUser, os.Getenv("REPORT_SMTP_PASSWORD"), host)
+				}
+				sender = smtpSender
+			}
+			startReportScheduler(ctx, store, sender, *reportAt)
+		}
+
+		// Start webhook deliveries
+		go server.RunWebhooks(ctx, *webhookWorkers)
+
+		// Start the offline monitor
+		if *offlineCheckInterval > 0 {
+			log.Printf("[STARTUP] Offline monitor checking every %v (default threshold %v)", *offlineCheckInterval, *offlineAfter)
+			monitor := api.NewOfflineMonitor(store, *offlineAfter)
+			monitor.Notify = server.NotifyWebhooks
+			monitor.OutagePercent, monitor.OutageMinDevices = *outagePercent, *outageMinDevices
+			monitor.NotifyOutage = server.NotifyOutage
+			go monitor.Run(ctx, *offlineCheckInterval)
+		}
+
+		// Start periodic snapshots
+		if *snapshotFile != "" {
+			log.Printf("[STARTUP] Snapshotting to %s every %v", *snapshotFile, *snapshotInterval)
+			go api.RunPeriodicSnapshots(ctx, snapshotter, *snapshotFile, *snapshotInterval)
+		}
+
+		// Start housekeeping
+		if *housekeepingInterval > 0 {
+			log.Printf("[STARTUP] Housekeeping every %v (decommission retention %v)", *housekeepingInterval, *decommissionRetention)
+			go server.RunHousekeeping(ctx, *housekeepingInterval, *decommissionRetention)
+		}
 
-	// Create server (will return 500s if configErr is set)
-	server := NewServer(store, configErr)
+		// Start the facility topology sync
+		if *topologyURL != "" && *topologyInterval > 0 {
+			log.Printf("[STARTUP] Syncing facility topology from %s every %v", *topologyURL, *topologyInterval)
+			source := &api.TopologySource{URL: *topologyURL, Token: os.Getenv("FACILITY_API_TOKEN"), Client: &http.Client{Timeout: 30 * time.Second}}
+			go server.RunTopologySync(ctx, source, *topologyInterval)
+		}
+
+		server.SetStandby(false)
+		log.Println("[STARTUP] Instance is active")
+	}
+
+	var elector api.LeaderElector
+	if *leaderLock != "" {
+		elector = api.NewFileLockElector(*leaderLock)
+		server.SetStandby(true)
+	}
+
+	// Load the registry after the listeners open, so a large CSV doesn't
+	// delay binding them: until it's loaded and the instance is active or on
+	// standby, health checks report NOT_SERVING and requests get a 503
+	go func() {
+		start := time.Now()
+		var err error
+		devicePaths, err = api.ExpandDeviceSources(*devicesSpec)
+		if err == nil {
+			err = store.LoadDevicesFromCSV(ctx, devicePaths...)
+		}
+		var count int
+		if err == nil {
+			count, err = store.DeviceCount(ctx)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to load devices from %s: %v", *devicesSpec, err)
+			devicesErr = err
+		} else {
+			log.Printf("[CONFIG] Loaded %d devices from %s in %v", count, strings.Join(devicePaths, ", "), time.Since(start).Round(time.Millisecond))
+		}
+		server.SetDeviceSources(*devicesSpec, devicesErr)
+		configErr = errors.Join(devicesErr, keysErr)
+
+		// Enrolled devices are written back to the device CSV, and get API keys
+		// only when authentication is enabled
+		if *enrollmentTokens != "" && configErr == nil && len(devicePaths) > 0 {
+			keysPath := ""
+			if len(keys) > 0 {
+				keysPath = apiKeysCSV
+			}
+			enroller, err := api.NewEnroller(*enrollmentTokens, devicePaths[0], keysPath)
+			if err != nil {
+				log.Printf("[ERROR] Failed to load enrollment tokens from %s: %v", *enrollmentTokens, err)
+			} else {
+				server.EnableEnrollment(enroller)
+				remaining, _ := enroller.Remaining()
+				log.Printf("[CONFIG] Enrollment enabled with %d unused tokens", remaining)
+			}
+		}
+
+		// Shutting down mid-load: stay not ready, so no final snapshot
+		// overwrites the last one with unrestored aggregates
+		if ctx.Err() != nil {
+			return
+		}
+		if elector == nil {
+			startActive()
+		} else {
+			log.Printf("[STARTUP] Waiting for leader lock %s", *leaderLock)
+			go func() {
+				if api.RunLeaderElection(ctx, elector, *leaderRetry) {
+					startActive()
+				}
+			}()
+		}
+		server.SetLoading(false)
+		log.Println("[STARTUP] Ready")
+	}()
 
 	// Start HTTP server
-	log.Printf("[STARTUP] Server listening on %s", port)
-	log.Printf("[STARTUP] Base URL: http://127.0.0.1%s/api/v1", port)
+	// Cleartext HTTP/2 (h2c) is enabled alongside HTTP/1 for gRPC health checks
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{Handler: server.Router(), Protocols: &protocols}
+	addrs, listeners := listenAll(*listen)
+
+	// Optionally move reads to their own listener, so only ingest is
+	// reachable on the main port
+	var readServer *http.Server
+	var serving sync.WaitGroup
+	if *readAddr != "" {
+		httpServer.Handler = server.WriteRouter()
+		readServer = &http.Server{Handler: server.ReadRouter(), Protocols: &protocols}
+		_, readListeners := listenAll(*readAddr)
+		for _, listener := range readListeners {
+			log.Printf("[STARTUP] Read-only listener on %s", describeListener(listener))
+			serve(&serving, readServer, listener, "Read-only listener")
+		}
+	}
+
+	// Serve returns as soon as Shutdown starts, so wait for Shutdown itself
+	// to return before treating requests as drained
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		log.Println("[SHUTDOWN] Signal received, draining requests")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if readServer != nil {
+			if err := readServer.Shutdown(shutdownCtx); err != nil {
+				log.Printf("[ERROR] Graceful shutdown of the read-only listener failed: %v", err)
+			}
+		}
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("[ERROR] Graceful shutdown failed: %v", err)
+		}
+	}()
+
+	for _, listener := range listeners {
+		log.Printf("[STARTUP] Server listening on %s", describeListener(listener))
+		serve(&serving, httpServer, listener, "Server")
+	}
+	if tcp, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+		log.Printf("[STARTUP] Base URL: http://%s/api/v1", net.JoinHostPort(loopbackFor(tcp.IP, addrs[0].Network), strconv.Itoa(tcp.Port)))
+	}
+	serving.Wait()
+	<-drained
+	stopUDP()
+
+	// Requests are drained and UDP heartbeats stopped; apply anything still
+	// queued so the final snapshot includes everything accepted
+	server.StopAsyncWrites()
+	server.StopPublishing()
+	if *snapshotFile != "" && !server.Standby() && !server.Loading() {
+		if err := api.SaveSnapshotFile(snapshotter, *snapshotFile); err != nil {
+			log.Printf("[ERROR] Final snapshot to %s failed: %v", *snapshotFile, err)
+		} else {
+			log.Printf("[SHUTDOWN] Wrote snapshot to %s", *snapshotFile)
+		}
+	}
+	if elector != nil {
+		// Release only after the final snapshot, so the standby restores it
+		if err := elector.Release(); err != nil {
+			log.Printf("[ERROR] Failed to release leader lock: %v", err)
+		}
+	}
+	log.Println("[SHUTDOWN] Server stopped")
+}
+
+// listenAll opens every address in spec, exiting if any can't be opened so
+// the server never starts on only some of its addresses.
+func listenAll(spec string) ([]api.ListenAddr, []net.Listener) {
+	addrs, err := api.ParseListenAddrs(spec)
+	if err != nil {
+		log.Fatalf("[ERROR] Invalid listen addresses: %v", err)
+	}
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := api.Listen(addr)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return addrs, listeners
+}
+
+// serve runs srv on listener in the background until srv shuts down. Any
+// other failure is fatal.
+func serve(wg *sync.WaitGroup, srv *http.Server, listener net.Listener, name string) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("[ERROR] %s failed on %s: %v", name, describeListener(listener), err)
+		}
+	}()
+}
+
+// describeListener names a listener's address for logs.
+func describeListener(listener net.Listener) string {
+	addr := listener.Addr()
+	return addr.Network() + " " + addr.String()
+}
 
-	if err := http.ListenAndServe(port, server.Router()); err != nil {
-		log.Fatalf("[ERROR] Server failed: %v", err)
+// loopbackFor returns the host to reach a server bound to ip on network
+// from the same machine.
+func loopbackFor(ip net.IP, network string) string {
+	switch {
+	case !ip.IsUnspecified():
+		return ip.String()
+	case network == "tcp6":
+		return "::1"
+	}
+	return "127.0.0.1"
+}
+
+// splitList splits a comma-separated flag value, trimming spaces and dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// reloadRegistry re-reads the device and API key CSVs, picking up devices
+// enrolled since startup. Aggregates are reset, so it must run before the
+// snapshot is restored.
+func reloadRegistry(ctx context.Context, server *api.Server, store api.Storage, devicesSpec string, authEnabled bool) {
+	devicePaths, err := api.ExpandDeviceSources(devicesSpec)
+	if err == nil {
+		err = store.LoadDevicesFromCSV(ctx, devicePaths...)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to reload devices from %s: %v", devicesSpec, err)
+	}
+	if authEnabled {
+		keys, err := api.LoadAPIKeysFromCSV(apiKeysCSV)
+		if err != nil {
+			log.Printf("[ERROR] Failed to reload API keys from %s: %v", apiKeysCSV, err)
+			return
+		}
+		server.EnableAuth(keys)
+	}
+	count, err := store.DeviceCount(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Failed to count reloaded devices: %v", err)
+		return
+	}
+	log.Printf("[CONFIG] Reloaded %d devices from %s", count, devicesSpec)
+}
+
+// restoreSnapshot loads a previous snapshot if one exists. A corrupt file is
+// moved aside rather than overwritten, so it can still be inspected.
+func restoreSnapshot(store api.Snapshotter, path string) {
+	err := api.LoadSnapshotFile(store, path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Printf("[CONFIG] No snapshot at %s, starting with empty aggregates", path)
+	case err != nil:
+		log.Printf("[ERROR] Failed to restore snapshot %s: %v", path, err)
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			log.Printf("[ERROR] Failed to move aside corrupt snapshot: %v", err)
+		}
+	default:
+		log.Printf("[CONFIG] Restored aggregates from %s", path)
+	}
+}
+
+// startSNMPAgent serves SNMP in the background; failures are logged, not fatal,
+// since SNMP is an optional integration.
+func startSNMPAgent(store api.Storage, addr, community, baseOID string) {
+	agent, err := api.NewSNMPAgent(store, community, baseOID)
+	if err != nil {
+		log.Printf("[ERROR] Invalid SNMP configuration: %v", err)
+		return
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("[ERROR] Failed to start SNMP agent on %s: %v", addr, err)
+		return
+	}
+	log.Printf("[STARTUP] SNMP agent listening on udp %s (base OID %s)", addr, baseOID)
+	go func() {
+		if err := agent.Serve(conn); err != nil {
+			log.Printf("[ERROR] SNMP agent stopped: %v", err)
+		}
+	}()
+}
+
+// startUDPHeartbeatListener serves UDP heartbeats in the background; like
+// SNMP, failures are logged rather than fatal. The returned func closes the
+// socket and waits for the heartbeat being recorded, if any.
+func startUDPHeartbeatListener(server *api.Server, addr, secret string) func() {
+	if secret == "" {
+		log.Printf("[ERROR] UDP heartbeat listener enabled but UDP_HEARTBEAT_SECRET is not set")
+		return func() {}
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("[ERROR] Failed to start UDP heartbeat listener on %s: %v", addr, err)
+		return func() {}
+	}
+	log.Printf("[STARTUP] UDP heartbeat listener on udp %s", addr)
+	listener := api.NewUDPHeartbeatListener(server, []byte(secret))
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := listener.Serve(conn); err != nil {
+			log.Printf("[ERROR] UDP heartbeat listener stopped: %v", err)
+		}
+	}()
+	return func() {
+		_ = conn.Close()
+		<-stopped
+	}
+}
+
+// startReportScheduler sends daily fleet reports in the background; like SNMP,
+// misconfiguration is logged rather than fatal.
+func startReportScheduler(ctx context.Context, store api.Storage, sender api.ReportSender, at string) {
+	if sender == nil {
+		log.Printf("[ERROR] Fleet report enabled but no Slack webhook or SMTP relay configured")
+		return
+	}
+	scheduler, err := api.NewReportScheduler(store, sender, at)
+	if err != nil {
+		log.Printf("[ERROR] Invalid fleet report configuration: %v", err)
+		return
 	}
+	log.Printf("[STARTUP] Daily fleet report scheduled at %s local time", at)
+	go scheduler.Run(ctx)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"safelyyou/api"
+)
+
+// runMigrate implements "safelyyou migrate", which copies a deployment from
+// one storage backend to another and verifies the copy. Backends that don't
+// persist on their own are read from and written to snapshot files, with the
+// source's registry loaded from the device CSVs first, as at startup.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "memory", "storage backend to copy from, one of: "+strings.Join(api.StorageBackends(), ", "))
+	fromDSN := flags.String("from-dsn", "", "connection string for the source backend")
+	devicesSpec := flags.String("devices", "devices.csv", "device CSV files and globs registering the source's devices, for sources restored from a snapshot")
+	fromSnapshot := flags.String("from-snapshot-file", "", "snapshot to restore the source from, for backends that don't persist on their own")
+	to := flags.String("to", "", "storage backend to copy to, one of: "+strings.Join(api.StorageBackends(), ", "))
+	toDSN := flags.String("to-dsn", "", "connection string for the destination backend, which must be empty")
+	toSnapshot := flags.String("to-snapshot-file", "", "snapshot to write the destination to, for backends that don't persist on their own")
+	_ = flags.Parse(args)
+
+	if *to == "" {
+		return errors.New("-to is required")
+	}
+	ctx := context.Background()
+
+	src, err := api.NewStorage(*from, *fromDSN)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	if snapshotter, ok := src.(api.Snapshotter); ok {
+		if *fromSnapshot == "" {
+			return fmt.Errorf("-from-snapshot-file is required to migrate from the %s backend", *from)
+		}
+		devicePaths, err := api.ExpandDeviceSources(*devicesSpec)
+		if err == nil {
+			err = src.LoadDevicesFromCSV(ctx, devicePaths...)
+		}
+		if err != nil {
+			return fmt.Errorf("load devices from %s: %w", *devicesSpec, err)
+		}
+		if err := api.LoadSnapshotFile(snapshotter, *fromSnapshot); err != nil {
+			return fmt.Errorf("restore snapshot %s: %w", *fromSnapshot, err)
+		}
+	}
+
+	dst, err := api.NewStorage(*to, *toDSN)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+	snapshotter, toSnapshotter := dst.(api.Snapshotter)
+	if toSnapshotter && *toSnapshot == "" {
+		return fmt.Errorf("-to-snapshot-file is required to migrate to the %s backend", *to)
+	}
+
+	result, err := api.Migrate(ctx, dst, src)
+	if err != nil {
+		return err
+	}
+	if toSnapshotter {
+		if err := api.SaveSnapshotFile(snapshotter, *toSnapshot); err != nil {
+			return fmt.Errorf("write snapshot %s: %w", *toSnapshot, err)
+		}
+	}
+	log.Printf("[INFO] Migrated %d devices (%d heartbeats, %d uploads), %d groups and %d maintenance windows from %s to %s",
+		result.Devices, result.Heartbeats, result.Uploads, result.Groups, result.Maintenance, *from, *to)
+	return nil
+}